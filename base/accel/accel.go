@@ -0,0 +1,113 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package accel offloads dense linear algebra in model training to accelerators. The CPU accelerator is always
+// available, and the CUDA accelerator is available if built with "-tags cuda".
+package accel
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"go.uber.org/zap"
+)
+
+// Accelerator computes dense linear algebra used in model training.
+type Accelerator interface {
+	// Name of the accelerator.
+	Name() string
+	// Gram sets dst to the sum of x_i x_i^T over rows x_i selected by mask. All rows are selected if mask is nil.
+	Gram(dst, rows [][]float32, mask func(i int) bool) error
+}
+
+var (
+	accelerators = map[string]func() (Accelerator, error){
+		"cpu": func() (Accelerator, error) { return CPU{}, nil },
+	}
+	current      Accelerator = CPU{}
+	currentMutex sync.RWMutex
+)
+
+// register an accelerator. It should be called in init().
+func register(name string, open func() (Accelerator, error)) {
+	accelerators[name] = open
+}
+
+// Use the accelerator for model training in this process.
+func Use(name string) error {
+	open, exist := accelerators[name]
+	if !exist {
+		return errors.NotSupportedf("accelerator %v", name)
+	}
+	a, err := open()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+	current = a
+	return nil
+}
+
+// Current returns the accelerator in use.
+func Current() Accelerator {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+	return current
+}
+
+// Gram computes the Gram matrix by the accelerator in use. It falls back to CPU if the accelerator fails.
+func Gram(dst, rows [][]float32, mask func(i int) bool) error {
+	a := Current()
+	if err := a.Gram(dst, rows, mask); err != nil {
+		if _, isCPU := a.(CPU); isCPU {
+			return errors.Trace(err)
+		}
+		log.Logger().Warn("accelerator failed, fall back to cpu", zap.String("accelerator", a.Name()), zap.Error(err))
+		return CPU{}.Gram(dst, rows, mask)
+	}
+	return nil
+}
+
+// CPU computes on CPU.
+type CPU struct{}
+
+// Name of the accelerator.
+func (CPU) Name() string {
+	return "cpu"
+}
+
+// Gram sets dst to the sum of x_i x_i^T over selected rows.
+func (CPU) Gram(dst, rows [][]float32, mask func(i int) bool) error {
+	for i := range dst {
+		for j := range dst[i] {
+			dst[i][j] = 0
+		}
+	}
+	for index, row := range rows {
+		if mask != nil && !mask(index) {
+			continue
+		}
+		if len(row) != len(dst) {
+			return errors.NotValidf("row of length %v for %vx%v matrix", len(row), len(dst), len(dst))
+		}
+		for i := range dst {
+			for j := range dst[i] {
+				dst[i][j] += row[i] * row[j]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cuda
+
+package accel
+
+/*
+#cgo LDFLAGS: -lcublas -lcudart
+#include <cuda_runtime.h>
+#include <cublas_v2.h>
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// cudaBatchRows is the number of rows copied to the device in a batch.
+const cudaBatchRows = 1 << 16
+
+func init() {
+	register("cuda", newCUDA)
+}
+
+// CUDA computes on NVIDIA GPUs by cuBLAS.
+type CUDA struct {
+	mutex  sync.Mutex
+	handle C.cublasHandle_t
+}
+
+func newCUDA() (Accelerator, error) {
+	a := &CUDA{}
+	if status := C.cublasCreate(&a.handle); status != C.CUBLAS_STATUS_SUCCESS {
+		return nil, errors.Errorf("failed to create cuBLAS handle (status %v)", int(status))
+	}
+	return a, nil
+}
+
+// Name of the accelerator.
+func (a *CUDA) Name() string {
+	return "cuda"
+}
+
+// Gram sets dst to the sum of x_i x_i^T over selected rows. Rows are copied to the device in batches, and each batch
+// is accumulated by SSYRK.
+func (a *CUDA) Gram(dst, rows [][]float32, mask func(i int) bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	k := len(dst)
+	for i := range dst {
+		for j := range dst[i] {
+			dst[i][j] = 0
+		}
+	}
+	if k == 0 {
+		return nil
+	}
+	// allocate device memory
+	var deviceRows, deviceGram unsafe.Pointer
+	if err := cudaError(C.cudaMalloc(&deviceRows, C.size_t(cudaBatchRows*k*4))); err != nil {
+		return errors.Trace(err)
+	}
+	defer C.cudaFree(deviceRows)
+	if err := cudaError(C.cudaMalloc(&deviceGram, C.size_t(k*k*4))); err != nil {
+		return errors.Trace(err)
+	}
+	defer C.cudaFree(deviceGram)
+	// accumulate batches
+	batch := make([]float32, 0, cudaBatchRows*k)
+	alpha, beta := C.float(1), C.float(0)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := cudaError(C.cudaMemcpy(deviceRows, unsafe.Pointer(&batch[0]), C.size_t(len(batch)*4), C.cudaMemcpyHostToDevice)); err != nil {
+			return errors.Trace(err)
+		}
+		// row-major n x k rows are the column-major k x n matrix A, so that the Gram matrix is A A^T.
+		if status := C.cublasSsyrk(a.handle, C.CUBLAS_FILL_MODE_UPPER, C.CUBLAS_OP_N, C.int(k), C.int(len(batch)/k),
+			&alpha, (*C.float)(deviceRows), C.int(k), &beta, (*C.float)(deviceGram), C.int(k)); status != C.CUBLAS_STATUS_SUCCESS {
+			return errors.Errorf("failed to run cublasSsyrk (status %v)", int(status))
+		}
+		batch, beta = batch[:0], 1
+		return nil
+	}
+	for index, row := range rows {
+		if mask != nil && !mask(index) {
+			continue
+		}
+		if len(row) != k {
+			return errors.NotValidf("row of length %v for %vx%v matrix", len(row), k, k)
+		}
+		batch = append(batch, row...)
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return errors.Trace(err)
+	}
+	if beta == 0 {
+		// no row is selected
+		return nil
+	}
+	// copy the upper triangle back
+	gram := make([]float32, k*k)
+	if err := cudaError(C.cudaMemcpy(unsafe.Pointer(&gram[0]), deviceGram, C.size_t(k*k*4), C.cudaMemcpyDeviceToHost)); err != nil {
+		return errors.Trace(err)
+	}
+	for j := 0; j < k; j++ {
+		for i := 0; i <= j; i++ {
+			dst[i][j] = gram[j*k+i]
+			dst[j][i] = gram[j*k+i]
+		}
+	}
+	return nil
+}
+
+func cudaError(code C.cudaError_t) error {
+	if code != C.cudaSuccess {
+		return errors.New(C.GoString(C.cudaGetErrorString(code)))
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cuda
+
+package accel
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/base"
+)
+
+func TestCUDA_Gram(t *testing.T) {
+	a, err := newCUDA()
+	assert.NoError(t, err)
+	rows := make([][]float32, cudaBatchRows+10)
+	for i := range rows {
+		rows[i] = []float32{rand.Float32(), rand.Float32(), rand.Float32()}
+	}
+	mask := func(i int) bool { return i%3 != 0 }
+	expected, actual := base.NewMatrix32(3, 3), base.NewMatrix32(3, 3)
+	assert.NoError(t, CPU{}.Gram(expected, rows, mask))
+	assert.NoError(t, a.Gram(actual, rows, mask))
+	for i := range expected {
+		assert.InDeltaSlice(t, expected[i], actual[i], 1e-1)
+	}
+	// no row is selected
+	assert.NoError(t, a.Gram(actual, rows, func(int) bool { return false }))
+	assert.Equal(t, base.NewMatrix32(3, 3), actual)
+}
@@ -0,0 +1,39 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accel
+
+import (
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/base"
+)
+
+func TestCPU_Gram(t *testing.T) {
+	rows := [][]float32{{1, 2}, {3, 4}, {5, 6}}
+	dst := base.NewMatrix32(2, 2)
+	assert.NoError(t, CPU{}.Gram(dst, rows, nil))
+	assert.Equal(t, [][]float32{{35, 44}, {44, 56}}, dst)
+	assert.NoError(t, CPU{}.Gram(dst, rows, func(i int) bool { return i != 1 }))
+	assert.Equal(t, [][]float32{{26, 32}, {32, 40}}, dst)
+	assert.True(t, errors.IsNotValid(CPU{}.Gram(dst, [][]float32{{1, 2, 3}}, nil)))
+}
+
+func TestUse(t *testing.T) {
+	assert.True(t, errors.IsNotSupported(Use("tpu")))
+	assert.NoError(t, Use("cpu"))
+	assert.Equal(t, "cpu", Current().Name())
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diagnostics
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/cmd/version"
+	"go.uber.org/zap"
+)
+
+// AddFlags adds flags of the admin port serving diagnostics.
+func AddFlags(flagSet *pflag.FlagSet) {
+	flagSet.String("admin-host", "127.0.0.1", "host of the admin port for pprof and runtime diagnostics")
+	flagSet.Int("admin-port", 0, "port of the admin port for pprof and runtime diagnostics, 0 means disabled")
+}
+
+// Serve serves diagnostics on the admin port set by flags in background. Requests must carry the admin API key,
+// which is read on every request since configurations of nodes are updated at runtime.
+func Serve(flagSet *pflag.FlagSet, apiKey func() string) {
+	host, _ := flagSet.GetString("admin-host")
+	port, _ := flagSet.GetInt("admin-port")
+	if port <= 0 {
+		return
+	}
+	addr := fmt.Sprintf("%s:%d", host, port)
+	log.Logger().Info("start admin server", zap.String("url", "http://"+addr))
+	go func() {
+		if err := http.ListenAndServe(addr, NewHandler(apiKey)); err != nil {
+			log.Logger().Fatal("failed to start admin server", zap.Error(err))
+		}
+	}()
+}
+
+// NewHandler creates a handler of pprof, goroutine dumps, GC stats and build info. All requests are rejected if the
+// admin API key is empty.
+func NewHandler(apiKey func() string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/goroutines", dumpGoroutines)
+	mux.HandleFunc("/debug/gc", getGCStats)
+	mux.HandleFunc("/debug/build", getBuildInfo)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKey()
+		if key == "" {
+			http.Error(w, "admin API key is not configured", http.StatusForbidden)
+			return
+		}
+		provided := r.Header.Get("X-API-Key")
+		if provided == "" {
+			provided = r.URL.Query().Get("X-API-Key")
+		}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// dumpGoroutines writes stack traces of all goroutines.
+func dumpGoroutines(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := runtimepprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		log.Logger().Error("failed to dump goroutines", zap.Error(err))
+	}
+}
+
+// GCStats are statistics of garbage collection and memory.
+type GCStats struct {
+	NumGC         int64           `json:"num_gc"`
+	LastGC        time.Time       `json:"last_gc"`
+	PauseTotal    time.Duration   `json:"pause_total"`
+	RecentPauses  []time.Duration `json:"recent_pauses"`
+	GCCPUFraction float64         `json:"gc_cpu_fraction"`
+	HeapAlloc     uint64          `json:"heap_alloc"`
+	HeapSys       uint64          `json:"heap_sys"`
+	HeapObjects   uint64          `json:"heap_objects"`
+	NextGC        uint64          `json:"next_gc"`
+	NumGoroutine  int             `json:"num_goroutine"`
+}
+
+func getGCStats(w http.ResponseWriter, _ *http.Request) {
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	writeJSON(w, GCStats{
+		NumGC:         gcStats.NumGC,
+		LastGC:        gcStats.LastGC,
+		PauseTotal:    gcStats.PauseTotal,
+		RecentPauses:  gcStats.Pause,
+		GCCPUFraction: memStats.GCCPUFraction,
+		HeapAlloc:     memStats.HeapAlloc,
+		HeapSys:       memStats.HeapSys,
+		HeapObjects:   memStats.HeapObjects,
+		NextGC:        memStats.NextGC,
+		NumGoroutine:  runtime.NumGoroutine(),
+	})
+}
+
+// BuildInfo is the information of the binary.
+type BuildInfo struct {
+	Version    string `json:"version"`
+	APIVersion string `json:"api_version"`
+	GoVersion  string `json:"go_version"`
+	GitCommit  string `json:"git_commit"`
+	BuildTime  string `json:"build_time"`
+	OSArch     string `json:"os_arch"`
+}
+
+func getBuildInfo(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, BuildInfo{
+		Version:    version.Version,
+		APIVersion: version.APIVersion,
+		GoVersion:  runtime.Version(),
+		GitCommit:  version.GitCommit,
+		BuildTime:  version.BuildTime,
+		OSArch:     runtime.GOOS + "/" + runtime.GOARCH,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, content any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(content); err != nil {
+		log.Logger().Error("failed to write response", zap.Error(err))
+	}
+}
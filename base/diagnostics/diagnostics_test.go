@@ -0,0 +1,74 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/cmd/version"
+)
+
+func TestHandler(t *testing.T) {
+	apiKey := ""
+	handler := NewHandler(func() string { return apiKey })
+
+	// reject requests if the admin API key is not configured
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/build", nil))
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// reject requests without the admin API key
+	apiKey = "admin"
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/build", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/build?X-API-Key=wrong", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// get build info
+	resp = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/build", nil)
+	req.Header.Set("X-API-Key", "admin")
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var buildInfo BuildInfo
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &buildInfo))
+	assert.Equal(t, version.Version, buildInfo.Version)
+
+	// get GC stats
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/gc?X-API-Key=admin", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var gcStats GCStats
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &gcStats))
+	assert.Positive(t, gcStats.NumGoroutine)
+
+	// dump goroutines
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/goroutines?X-API-Key=admin", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "TestHandler")
+
+	// get profiles
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/pprof/heap?debug=1&X-API-Key=admin", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "heap profile")
+}
@@ -16,9 +16,13 @@ package encoding
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHex(t *testing.T) {
@@ -91,3 +95,45 @@ func TestStringsBytes(t *testing.T) {
 	a := []string{"abc", "de", "f"}
 	assert.Equal(t, 24+16*3+12, int(StringsBytes(a)))
 }
+
+func TestMsgpack(t *testing.T) {
+	type document struct {
+		Name      string             `json:"name"`
+		Count     int                `json:"count"`
+		Offset    int64              `json:"offset"`
+		Value     float32            `json:"value"`
+		Ratio     float64            `json:"ratio"`
+		Enabled   bool               `json:"enabled"`
+		Tags      []string           `json:"tags"`
+		Scores    map[string]float64 `json:"scores"`
+		Timestamp time.Time          `json:"timestamp"`
+		Parent    *document          `json:"parent"`
+	}
+	a := document{
+		Name:      strings.Repeat("a", 300),
+		Count:     100000,
+		Offset:    -1 << 40,
+		Value:     0.123,
+		Ratio:     math.Pi,
+		Enabled:   true,
+		Tags:      []string{"a", "b"},
+		Scores:    map[string]float64{"x": -1, "y": 2.5},
+		Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Parent:    &document{Count: -3, Tags: []string{}},
+	}
+	buf, err := MarshalMsgpack(a)
+	assert.NoError(t, err)
+	jsonBuf, err := json.Marshal(a)
+	assert.NoError(t, err)
+	assert.Less(t, len(buf), len(jsonBuf))
+	var b document
+	assert.NoError(t, UnmarshalMsgpack(buf, &b))
+	assert.Equal(t, a, b)
+	// deterministic encoding
+	c, err := MarshalMsgpack(a)
+	assert.NoError(t, err)
+	assert.Equal(t, buf, c)
+	// invalid encoding
+	assert.Error(t, UnmarshalMsgpack(buf[:len(buf)-1], &b))
+	assert.Error(t, UnmarshalMsgpack(append(buf, 0), &b))
+}
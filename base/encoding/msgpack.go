@@ -0,0 +1,314 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/juju/errors"
+)
+
+// MarshalMsgpack encodes a value in MessagePack. The value is converted by its JSON encoding, so that JSON tags and
+// marshalers are respected and the result is decoded by UnmarshalMsgpack to the same value as the JSON encoding.
+// Keys of maps are sorted to make the encoding deterministic.
+func MarshalMsgpack(v interface{}) ([]byte, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	var value interface{}
+	if err = decoder.Decode(&value); err != nil {
+		return nil, errors.Trace(err)
+	}
+	var w bytes.Buffer
+	if err = writeMsgpack(&w, value); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return w.Bytes(), nil
+}
+
+// UnmarshalMsgpack decodes a value encoded by MarshalMsgpack.
+func UnmarshalMsgpack(data []byte, v interface{}) error {
+	r := &msgpackReader{data: data}
+	value, err := r.read()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if r.pos != len(r.data) {
+		return errors.NotValidf("%d trailing bytes of msgpack", len(r.data)-r.pos)
+	}
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(json.Unmarshal(buf, v))
+}
+
+func writeMsgpack(w *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		w.WriteByte(0xc0)
+	case bool:
+		if value {
+			w.WriteByte(0xc3)
+		} else {
+			w.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			writeMsgpackInt(w, i)
+		} else if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			w.WriteByte(0xcf)
+			writeUint(w, u, 8)
+		} else if f, err := value.Float64(); err != nil {
+			return errors.Trace(err)
+		} else if float64(float32(f)) == f {
+			w.WriteByte(0xca)
+			writeUint(w, uint64(math.Float32bits(float32(f))), 4)
+		} else {
+			w.WriteByte(0xcb)
+			writeUint(w, math.Float64bits(f), 8)
+		}
+	case string:
+		switch n := len(value); {
+		case n < 32:
+			w.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			w.WriteByte(0xd9)
+			writeUint(w, uint64(n), 1)
+		case n <= math.MaxUint16:
+			w.WriteByte(0xda)
+			writeUint(w, uint64(n), 2)
+		default:
+			w.WriteByte(0xdb)
+			writeUint(w, uint64(n), 4)
+		}
+		w.WriteString(value)
+	case []interface{}:
+		writeMsgpackHeader(w, len(value), 0x90, 0xdc, 0xdd)
+		for _, element := range value {
+			if err := writeMsgpack(w, element); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(w, len(value), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			if err := writeMsgpack(w, key); err != nil {
+				return errors.Trace(err)
+			}
+			if err := writeMsgpack(w, value[key]); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	default:
+		return errors.NotSupportedf("msgpack of %T", value)
+	}
+	return nil
+}
+
+// writeMsgpackHeader writes the header of an array or a map, which is a fix type for less than 16 elements.
+func writeMsgpackHeader(w *bytes.Buffer, n int, fix, type16, type32 byte) {
+	switch {
+	case n < 16:
+		w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		w.WriteByte(type16)
+		writeUint(w, uint64(n), 2)
+	default:
+		w.WriteByte(type32)
+		writeUint(w, uint64(n), 4)
+	}
+}
+
+func writeMsgpackInt(w *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		w.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		w.WriteByte(byte(int8(i)))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		w.WriteByte(0xd0)
+		writeUint(w, uint64(uint8(int8(i))), 1)
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		w.WriteByte(0xd1)
+		writeUint(w, uint64(uint16(int16(i))), 2)
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		w.WriteByte(0xd2)
+		writeUint(w, uint64(uint32(int32(i))), 4)
+	default:
+		w.WriteByte(0xd3)
+		writeUint(w, uint64(i), 8)
+	}
+}
+
+// writeUint writes an unsigned integer of n bytes in big endian.
+func writeUint(w *bytes.Buffer, u uint64, n int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], u)
+	w.Write(buf[8-n:])
+}
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes.
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errors.NotValidf("truncated msgpack")
+	}
+	buf := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return buf, nil
+}
+
+// uint reads an unsigned integer of n bytes in big endian.
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	buf, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, b := range buf {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+func (r *msgpackReader) read() (interface{}, error) {
+	buf, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch t := buf[0]; {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xe0 == 0xa0:
+		return r.string(int(t & 0x1f))
+	case t&0xf0 == 0x90:
+		return r.array(int(t & 0x0f))
+	case t&0xf0 == 0x80:
+		return r.object(int(t & 0x0f))
+	case t == 0xc0:
+		return nil, nil
+	case t == 0xc2:
+		return false, nil
+	case t == 0xc3:
+		return true, nil
+	case t >= 0xcc && t <= 0xcf:
+		return r.uint(1 << (t - 0xcc))
+	case t >= 0xd0 && t <= 0xd3:
+		n := 1 << (t - 0xd0)
+		u, err := r.uint(n)
+		if err != nil {
+			return nil, err
+		}
+		// sign extension
+		shift := 64 - 8*n
+		return int64(u<<shift) >> shift, nil
+	case t == 0xca:
+		u, err := r.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(uint32(u)), nil
+	case t == 0xcb:
+		u, err := r.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(u), nil
+	case t >= 0xd9 && t <= 0xdb:
+		n, err := r.uint(1 << (t - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.string(int(n))
+	case t == 0xdc || t == 0xdd:
+		n, err := r.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(int(n))
+	case t == 0xde || t == 0xdf:
+		n, err := r.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.object(int(n))
+	default:
+		return nil, errors.NotSupportedf("msgpack type 0x%x", t)
+	}
+}
+
+func (r *msgpackReader) string(n int) (interface{}, error) {
+	buf, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(buf), nil
+}
+
+func (r *msgpackReader) array(n int) (interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errors.NotValidf("truncated msgpack")
+	}
+	array := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		element, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, element)
+	}
+	return array, nil
+}
+
+func (r *msgpackReader) object(n int) (interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errors.NotValidf("truncated msgpack")
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, errors.NotSupportedf("msgpack map key of %T", key)
+		}
+		if m[name], err = r.read(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
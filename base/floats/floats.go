@@ -154,5 +154,8 @@ func Dot(a, b []float32) (ret float32) {
 	if len(a) != len(b) {
 		panic("floats: slice lengths do not match")
 	}
+	if len(a) == 0 {
+		return 0
+	}
 	return impl.dot(a, b)
 }
@@ -29,6 +29,8 @@ var impl = Default
 func init() {
 	if cpuid.CPU.Supports(cpuid.AVX512F, cpuid.AVX512DQ) {
 		impl = AVX512
+	} else if cpuid.CPU.Supports(cpuid.AVX2, cpuid.FMA3) {
+		impl = AVX2
 	} else if cpuid.CPU.Supports(cpuid.AVX) {
 		impl = AVX
 	}
@@ -40,6 +42,7 @@ const (
 	Default implementation = iota
 	AVX
 	AVX512
+	AVX2
 )
 
 func (i implementation) String() string {
@@ -48,6 +51,8 @@ func (i implementation) String() string {
 		return "avx"
 	case AVX512:
 		return "avx512"
+	case AVX2:
+		return "avx2"
 	default:
 		return "default"
 	}
@@ -55,7 +60,7 @@ func (i implementation) String() string {
 
 func (i implementation) mulConstAddTo(a []float32, b float32, c []float32) {
 	switch i {
-	case AVX:
+	case AVX, AVX2:
 		_mm256_mul_const_add_to(unsafe.Pointer(&a[0]), unsafe.Pointer(&b), unsafe.Pointer(&c[0]), unsafe.Pointer(uintptr(len(a))))
 	case AVX512:
 		_mm512_mul_const_add_to(unsafe.Pointer(&a[0]), unsafe.Pointer(&b), unsafe.Pointer(&c[0]), unsafe.Pointer(uintptr(len(a))))
@@ -66,7 +71,7 @@ func (i implementation) mulConstAddTo(a []float32, b float32, c []float32) {
 
 func (i implementation) mulConstTo(a []float32, b float32, c []float32) {
 	switch i {
-	case AVX:
+	case AVX, AVX2:
 		_mm256_mul_const_to(unsafe.Pointer(&a[0]), unsafe.Pointer(&b), unsafe.Pointer(&c[0]), unsafe.Pointer(uintptr(len(a))))
 	case AVX512:
 		_mm512_mul_const_to(unsafe.Pointer(&a[0]), unsafe.Pointer(&b), unsafe.Pointer(&c[0]), unsafe.Pointer(uintptr(len(a))))
@@ -77,7 +82,7 @@ func (i implementation) mulConstTo(a []float32, b float32, c []float32) {
 
 func (i implementation) mulTo(a, b, c []float32) {
 	switch i {
-	case AVX:
+	case AVX, AVX2:
 		_mm256_mul_to(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), unsafe.Pointer(&c[0]), unsafe.Pointer(uintptr(len(a))))
 	case AVX512:
 		_mm512_mul_to(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), unsafe.Pointer(&c[0]), unsafe.Pointer(uintptr(len(a))))
@@ -88,7 +93,7 @@ func (i implementation) mulTo(a, b, c []float32) {
 
 func (i implementation) mulConst(a []float32, b float32) {
 	switch i {
-	case AVX:
+	case AVX, AVX2:
 		_mm256_mul_const(unsafe.Pointer(&a[0]), unsafe.Pointer(&b), unsafe.Pointer(uintptr(len(a))))
 	case AVX512:
 		_mm512_mul_const(unsafe.Pointer(&a[0]), unsafe.Pointer(&b), unsafe.Pointer(uintptr(len(a))))
@@ -103,6 +108,10 @@ func (i implementation) dot(a, b []float32) float32 {
 		var ret float32
 		_mm256_dot(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), unsafe.Pointer(uintptr(len(a))), unsafe.Pointer(&ret))
 		return ret
+	case AVX2:
+		var ret float32
+		_mm256_fma_dot(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), len(a), unsafe.Pointer(&ret))
+		return ret
 	case AVX512:
 		var ret float32
 		_mm512_dot(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), unsafe.Pointer(uintptr(len(a))), unsafe.Pointer(&ret))
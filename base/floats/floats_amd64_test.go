@@ -141,6 +141,23 @@ func TestAVX512_Dot(t *testing.T) {
 	assert.Equal(t, expected, actual)
 }
 
+func TestAVX2_Dot(t *testing.T) {
+	if !cpuid.CPU.Supports(cpuid.AVX2) || !cpuid.CPU.Supports(cpuid.FMA3) {
+		t.Skip("AVX2 and FMA3 are not supported in the current CPU")
+	}
+	a := []float32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	b := []float32{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120, 130, 140, 150, 160, 170, 180, 190, 200}
+	actual := AVX2.dot(a, b)
+	expected := Default.dot(a, b)
+	assert.Equal(t, expected, actual)
+	// test vectors longer than the unrolled loop
+	for _, n := range []int{1, 7, 8, 31, 32, 33, 100} {
+		v1 := initializeFloat32Array(n)
+		v2 := initializeFloat32Array(n)
+		assert.InDelta(t, Default.dot(v1, v2), AVX2.dot(v1, v2), 1e-4)
+	}
+}
+
 func initializeFloat32Array(n int) []float32 {
 	x := make([]float32, n)
 	for i := 0; i < n; i++ {
@@ -150,7 +167,7 @@ func initializeFloat32Array(n int) []float32 {
 }
 
 func BenchmarkDot(b *testing.B) {
-	for _, impl := range []implementation{Default, AVX, AVX512} {
+	for _, impl := range []implementation{Default, AVX, AVX2, AVX512} {
 		b.Run(impl.String(), func(b *testing.B) {
 			for i := 16; i <= 128; i *= 2 {
 				b.Run(strconv.Itoa(i), func(b *testing.B) {
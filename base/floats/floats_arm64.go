@@ -73,7 +73,7 @@ func (i implementation) mulConst(a []float32, b float32) {
 func (i implementation) dot(a, b []float32) float32 {
 	if i == Neon {
 		var ret float32
-		vdot(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), unsafe.Pointer(uintptr(len(a))), unsafe.Pointer(&ret))
+		vfma_dot(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), len(a), unsafe.Pointer(&ret))
 		return ret
 	} else {
 		return dot(a, b)
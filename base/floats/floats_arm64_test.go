@@ -65,6 +65,12 @@ func TestNEON_Dot(t *testing.T) {
 	actual := Neon.dot(a, b)
 	expected := Default.dot(a, b)
 	assert.Equal(t, expected, actual)
+	// test vectors longer than the unrolled loop
+	for _, n := range []int{1, 3, 4, 15, 16, 17, 100} {
+		v1 := initializeFloat32Array(n)
+		v2 := initializeFloat32Array(n)
+		assert.InDelta(t, Default.dot(v1, v2), Neon.dot(v1, v2), 1e-4)
+	}
 }
 
 func initializeFloat32Array(n int) []float32 {
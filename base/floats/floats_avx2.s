@@ -0,0 +1,64 @@
+//go:build !noasm && amd64
+
+#include "textflag.h"
+
+// func _mm256_fma_dot(a, b unsafe.Pointer, n int, ret unsafe.Pointer)
+TEXT ·_mm256_fma_dot(SB), NOSPLIT, $0-32
+	MOVQ a+0(FP), DI
+	MOVQ b+8(FP), SI
+	MOVQ n+16(FP), DX
+	MOVQ ret+24(FP), CX
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	VXORPS Y2, Y2, Y2
+	VXORPS Y3, Y3, Y3
+
+loop32:
+	CMPQ DX, $32
+	JL   loop8
+	VMOVUPS (DI), Y4
+	VMOVUPS 32(DI), Y5
+	VMOVUPS 64(DI), Y6
+	VMOVUPS 96(DI), Y7
+	VFMADD231PS (SI), Y4, Y0
+	VFMADD231PS 32(SI), Y5, Y1
+	VFMADD231PS 64(SI), Y6, Y2
+	VFMADD231PS 96(SI), Y7, Y3
+	ADDQ $128, DI
+	ADDQ $128, SI
+	SUBQ $32, DX
+	JMP  loop32
+
+loop8:
+	CMPQ DX, $8
+	JL   reduce
+	VMOVUPS (DI), Y4
+	VFMADD231PS (SI), Y4, Y0
+	ADDQ $32, DI
+	ADDQ $32, SI
+	SUBQ $8, DX
+	JMP  loop8
+
+reduce:
+	VADDPS       Y1, Y0, Y0
+	VADDPS       Y3, Y2, Y2
+	VADDPS       Y2, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPS       X1, X0, X0
+	VHADDPS      X0, X0, X0
+	VHADDPS      X0, X0, X0
+
+tail:
+	TESTQ DX, DX
+	JE    done
+	VMOVSS (DI), X4
+	VFMADD231SS (SI), X4, X0
+	ADDQ $4, DI
+	ADDQ $4, SI
+	DECQ DX
+	JMP  tail
+
+done:
+	VMOVSS X0, (CX)
+	VZEROUPPER
+	RET
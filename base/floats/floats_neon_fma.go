@@ -0,0 +1,24 @@
+//go:build !noasm && arm64
+
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package floats
+
+import "unsafe"
+
+// vfma_dot computes the dot product of a and b with fused multiply-add instructions and four accumulators.
+//
+//go:noescape
+func vfma_dot(a, b unsafe.Pointer, n int, ret unsafe.Pointer)
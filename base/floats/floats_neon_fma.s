@@ -0,0 +1,57 @@
+//go:build !noasm && arm64
+
+#include "textflag.h"
+
+// func vfma_dot(a, b unsafe.Pointer, n int, ret unsafe.Pointer)
+TEXT ·vfma_dot(SB), NOSPLIT, $0-32
+	MOVD a+0(FP), R0
+	MOVD b+8(FP), R1
+	MOVD n+16(FP), R2
+	MOVD ret+24(FP), R3
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	VEOR V2.B16, V2.B16, V2.B16
+	VEOR V3.B16, V3.B16, V3.B16
+
+loop16:
+	CMP    $16, R2
+	BLT    loop4
+	VLD1.P 64(R0), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VLD1.P 64(R1), [V8.S4, V9.S4, V10.S4, V11.S4]
+	VFMLA  V4.S4, V8.S4, V0.S4
+	VFMLA  V5.S4, V9.S4, V1.S4
+	VFMLA  V6.S4, V10.S4, V2.S4
+	VFMLA  V7.S4, V11.S4, V3.S4
+	SUB    $16, R2
+	B      loop16
+
+loop4:
+	CMP    $4, R2
+	BLT    reduce
+	VLD1.P 16(R0), [V4.S4]
+	VLD1.P 16(R1), [V8.S4]
+	VFMLA  V4.S4, V8.S4, V0.S4
+	SUB    $4, R2
+	B      loop4
+
+reduce:
+	WORD $0x4e21d400 // fadd v0.4s, v0.4s, v1.4s
+	WORD $0x4e23d442 // fadd v2.4s, v2.4s, v3.4s
+	WORD $0x4e22d400 // fadd v0.4s, v0.4s, v2.4s
+	WORD $0x6e20d400 // faddp v0.4s, v0.4s, v0.4s
+	WORD $0x7e30d800 // faddp s0, v0.2s
+
+tail:
+	CBZ   R2, done
+	FMOVS (R0), F4
+	FMOVS (R1), F5
+	FMULS F4, F5, F5
+	FADDS F5, F0, F0
+	ADD   $4, R0
+	ADD   $4, R1
+	SUB   $1, R2
+	B     tail
+
+done:
+	FMOVS F0, (R3)
+	RET
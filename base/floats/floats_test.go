@@ -127,6 +127,7 @@ func TestDot(t *testing.T) {
 	b := []float32{0, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20}
 	assert.Equal(t, float32(770), Dot(a, b))
 	assert.Panics(t, func() { Dot([]float32{1}, nil) })
+	assert.Zero(t, Dot(nil, nil))
 }
 
 func TestNative_Dot(t *testing.T) {
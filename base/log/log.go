@@ -15,6 +15,7 @@
 package log
 
 import (
+	"context"
 	"net/url"
 	"os"
 	"runtime"
@@ -58,6 +59,30 @@ func ResponseLogger(resp *restful.Response) *zap.Logger {
 	return logger.With(zap.String("request_id", resp.Header().Get("X-Request-ID")))
 }
 
+type requestIdKey struct{}
+
+// WithRequestId returns a context carrying the ID of a request, which is logged by loggers from the context.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestId returns the ID of the request in a context, or an empty string if not found.
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// ContextLogger returns the logger with the ID of the request in a context.
+func ContextLogger(ctx context.Context) *zap.Logger {
+	if requestId := RequestId(ctx); requestId != "" {
+		return logger.With(zap.String("request_id", requestId))
+	}
+	return logger
+}
+
 func CloseLogger() {
 	cfg := zap.NewProductionConfig()
 	cfg.Level = zap.NewAtomicLevelAt(zap.FatalLevel)
@@ -0,0 +1,38 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spill
+
+import (
+	"io"
+	"os"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// mapFile reads the file into memory on platforms without mmap support.
+func mapFile(file *os.File, n int) ([]uint64, error) {
+	data := make([]uint64, n)
+	if _, err := file.ReadAt(unsafe.Slice((*byte)(unsafe.Pointer(&data[0])), n*8), 0); err != nil && err != io.EOF {
+		return nil, errors.Trace(err)
+	}
+	return data, nil
+}
+
+func unmapFile(_ []uint64) error {
+	return nil
+}
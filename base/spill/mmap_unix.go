@@ -0,0 +1,37 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spill
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+func mapFile(file *os.File, n int) ([]uint64, error) {
+	data, err := syscall.Mmap(int(file.Fd()), 0, n*8, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&data[0])), n), nil
+}
+
+func unmapFile(data []uint64) error {
+	return syscall.Munmap(unsafe.Slice((*byte)(unsafe.Pointer(&data[0])), len(data)*8))
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spill
+
+import (
+	"os"
+	"sort"
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// bufferSize is the number of pairs buffered in memory before written to the file.
+const bufferSize = 64 * 1024
+
+// Pairs is a list of int32 pairs spilled to a temporary file. Pairs are appended first, then the file is memory-mapped
+// and sorted in place, so that pages are backed by the file rather than the heap.
+type Pairs struct {
+	file   *os.File
+	buffer []uint64
+	n      int
+	data   []uint64
+}
+
+// NewPairs creates an empty list of pairs in a temporary file under the directory. The system temporary directory is
+// used if the directory is empty.
+func NewPairs(dir string) (*Pairs, error) {
+	file, err := os.CreateTemp(dir, "gorse-spill-*")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &Pairs{file: file, buffer: make([]uint64, 0, bufferSize)}, nil
+}
+
+// Append a pair to the list. Pairs can't be appended after the list is sorted.
+func (p *Pairs) Append(a, b int32) error {
+	if p.data != nil {
+		return errors.NotSupportedf("append to sorted pairs")
+	}
+	p.buffer = append(p.buffer, pack(a, b))
+	p.n++
+	if len(p.buffer) == bufferSize {
+		return p.flush()
+	}
+	return nil
+}
+
+func (p *Pairs) flush() error {
+	if len(p.buffer) == 0 {
+		return nil
+	}
+	// pairs are written in native byte order since they are mapped back in the same process
+	bytes := unsafe.Slice((*byte)(unsafe.Pointer(&p.buffer[0])), len(p.buffer)*8)
+	if _, err := p.file.Write(bytes); err != nil {
+		return errors.Trace(err)
+	}
+	p.buffer = p.buffer[:0]
+	return nil
+}
+
+// Len returns the number of pairs.
+func (p *Pairs) Len() int {
+	return p.n
+}
+
+// Sort maps the file and sorts pairs by the first elements and then the second elements.
+func (p *Pairs) Sort() error {
+	if p.data != nil {
+		return nil
+	}
+	if err := p.flush(); err != nil {
+		return errors.Trace(err)
+	}
+	p.buffer = nil
+	if p.n == 0 {
+		p.data = make([]uint64, 0)
+		return nil
+	}
+	data, err := mapFile(p.file, p.n)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p.data = data
+	sort.Sort(uint64Slice(p.data))
+	return nil
+}
+
+// Contains returns true if the sorted list contains the pair.
+func (p *Pairs) Contains(a, b int32) bool {
+	key := pack(a, b)
+	i := sort.Search(len(p.data), func(i int) bool { return p.data[i] >= key })
+	return i < len(p.data) && p.data[i] == key
+}
+
+// Get the i-th pair of the sorted list.
+func (p *Pairs) Get(i int) (int32, int32) {
+	return int32(p.data[i] >> 32), int32(uint32(p.data[i]))
+}
+
+// Close unmaps and removes the file.
+func (p *Pairs) Close() error {
+	if len(p.data) > 0 {
+		if err := unmapFile(p.data); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	p.data, p.buffer = nil, nil
+	if err := p.file.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Remove(p.file.Name()))
+}
+
+func pack(a, b int32) uint64 {
+	return uint64(uint32(a))<<32 | uint64(uint32(b))
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
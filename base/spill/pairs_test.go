@@ -0,0 +1,59 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spill
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPairs(t *testing.T) {
+	dir := t.TempDir()
+	pairs, err := NewPairs(dir)
+	assert.NoError(t, err)
+	// append pairs across buffers
+	n := bufferSize*2 + 10
+	for i := 0; i < n; i++ {
+		assert.NoError(t, pairs.Append(int32(rand.Intn(1000)), int32(n-i)))
+	}
+	assert.Equal(t, n, pairs.Len())
+	assert.NoError(t, pairs.Sort())
+	assert.Error(t, pairs.Append(0, 0))
+	prevA, prevB := pairs.Get(0)
+	for i := 1; i < pairs.Len(); i++ {
+		a, b := pairs.Get(i)
+		assert.True(t, prevA < a || (prevA == a && prevB < b))
+		prevA, prevB = a, b
+	}
+	a, b := pairs.Get(10)
+	assert.True(t, pairs.Contains(a, b))
+	assert.False(t, pairs.Contains(a, int32(n+1)))
+	// remove file after closed
+	assert.NoError(t, pairs.Close())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestPairs_Empty(t *testing.T) {
+	pairs, err := NewPairs(t.TempDir())
+	assert.NoError(t, err)
+	assert.NoError(t, pairs.Sort())
+	assert.Zero(t, pairs.Len())
+	assert.NoError(t, pairs.Close())
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Event is a snapshot of a task when its status or progress changes.
+type Event struct {
+	Id       uint64
+	Time     time.Time
+	Name     string
+	Status   Status
+	Done     int
+	Total    int
+	Progress float64
+	Error    string
+}
+
+// Journal keeps the latest events of tasks in a ring buffer.
+type Journal struct {
+	mu     sync.Mutex
+	events []Event
+	next   uint64
+	notify chan struct{}
+}
+
+// NewJournal creates a journal retaining at most size events.
+func NewJournal(size int) *Journal {
+	return &Journal{
+		events: make([]Event, size),
+		notify: make(chan struct{}),
+	}
+}
+
+// Observe records an event if the status or the integral percentage of the task changes from the previous state.
+func (j *Journal) Observe(prevStatus Status, prevProgress float64, t *Task) {
+	if j == nil || t == nil || len(j.events) == 0 {
+		return
+	}
+	if prevStatus == t.Status && math.Floor(prevProgress) == math.Floor(t.Progress) {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.events[j.next%uint64(len(j.events))] = Event{
+		Id:       j.next,
+		Time:     time.Now(),
+		Name:     t.Name,
+		Status:   t.Status,
+		Done:     t.Done,
+		Total:    t.Total,
+		Progress: t.Progress,
+		Error:    t.Error,
+	}
+	j.next++
+	close(j.notify)
+	j.notify = make(chan struct{})
+}
+
+// Since returns retained events whose ids are not less than id, and the channel closed once a new event is recorded.
+func (j *Journal) Since(id uint64) ([]Event, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if size := uint64(len(j.events)); j.next > size && id < j.next-size {
+		id = j.next - size
+	}
+	var events []Event
+	for ; id < j.next; id++ {
+		events = append(events, j.events[id%uint64(len(j.events))])
+	}
+	return events, j.notify
+}
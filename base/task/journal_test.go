@@ -0,0 +1,77 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	taskMonitor := NewTaskMonitor()
+	taskMonitor.Journal = NewJournal(3)
+	events, notify := taskMonitor.Journal.Since(0)
+	assert.Empty(t, events)
+
+	// record status changes
+	taskMonitor.Pending("a")
+	taskMonitor.Start("a", 1000)
+	select {
+	case <-notify:
+	default:
+		t.Fatal("waiters should be notified")
+	}
+	events, _ = taskMonitor.Journal.Since(0)
+	assert.Equal(t, []Status{StatusPending, StatusRunning}, statuses(events))
+	assert.Equal(t, []uint64{0, 1}, ids(events))
+
+	// record progress changes of whole percentages
+	taskMonitor.Update("a", 1)
+	taskMonitor.Update("a", 5)
+	taskMonitor.Update("a", 10)
+	events, _ = taskMonitor.Journal.Since(2)
+	assert.Len(t, events, 1)
+	assert.Equal(t, 10, events[0].Done)
+	assert.Equal(t, 1.0, events[0].Progress)
+
+	// drop the oldest events
+	taskMonitor.Fail("a", "error")
+	events, _ = taskMonitor.Journal.Since(0)
+	assert.Equal(t, []uint64{1, 2, 3}, ids(events))
+	assert.Equal(t, StatusFailed, events[2].Status)
+	assert.Equal(t, "error", events[2].Error)
+
+	// no journal
+	taskMonitor.Journal = nil
+	taskMonitor.Start("b", 100)
+	taskMonitor.Finish("b")
+}
+
+func statuses(events []Event) []Status {
+	var result []Status
+	for _, event := range events {
+		result = append(result, event.Status)
+	}
+	return result
+}
+
+func ids(events []Event) []uint64 {
+	var result []uint64
+	for _, event := range events {
+		result = append(result, event.Id)
+	}
+	return result
+}
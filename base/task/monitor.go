@@ -15,10 +15,13 @@
 package task
 
 import (
+	"github.com/juju/errors"
 	"github.com/scylladb/go-set/strset"
+	"math"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,6 +33,7 @@ const (
 	StatusRunning   Status = "Running"
 	StatusSuspended Status = "Suspended"
 	StatusFailed    Status = "Failed"
+	StatusCancelled Status = "Cancelled"
 )
 
 // Task progress information.
@@ -41,6 +45,9 @@ type Task struct {
 	StartTime  time.Time
 	FinishTime time.Time
 	Error      string
+	Progress   float64   // percentage of done
+	ETA        time.Time // estimated finish time of a running task
+	cancelled  int32     // set atomically once the task is cancelled
 }
 
 func NewTask(name string, total int) *Task {
@@ -56,13 +63,19 @@ func NewTask(name string, total int) *Task {
 
 func (t *Task) Update(done int) {
 	t.Done = done
-	t.Status = StatusRunning
+	if t.Status != StatusCancelled {
+		t.Status = StatusRunning
+	}
+	t.Estimate()
 }
 
 func (t *Task) Add(done int) {
 	if t != nil {
-		t.Status = StatusRunning
+		if t.Status != StatusCancelled {
+			t.Status = StatusRunning
+		}
 		t.Done += done
+		t.Estimate()
 	}
 }
 
@@ -71,11 +84,45 @@ func (t *Task) Finish() {
 		t.Status = StatusComplete
 		t.Done = t.Total
 		t.FinishTime = time.Now()
+		t.Estimate()
 	}
 }
 
-func (t *Task) Suspend(flag bool) {
+// Estimate updates the progress and the estimated finish time of the task.
+func (t *Task) Estimate() {
+	if t == nil {
+		return
+	}
+	t.Progress, t.ETA = 0, time.Time{}
+	if t.Status == StatusComplete {
+		t.Progress = 100
+	} else if t.Total > 0 && t.Done > 0 {
+		t.Progress = math.Min(100*float64(t.Done)/float64(t.Total), 100)
+		if t.Status == StatusRunning || t.Status == StatusSuspended {
+			elapsed := time.Since(t.StartTime)
+			t.ETA = t.StartTime.Add(time.Duration(float64(elapsed) * float64(t.Total) / float64(t.Done)))
+		}
+	}
+}
+
+// Cancel the task. Workers of the task should check IsCancelled and stop as soon as possible.
+func (t *Task) Cancel() {
 	if t != nil {
+		atomic.StoreInt32(&t.cancelled, 1)
+		t.Status = StatusCancelled
+		t.FinishTime = time.Now()
+		t.Estimate()
+	}
+}
+
+// IsCancelled returns true if the task has been cancelled. It is safe to be called by workers of the task
+// concurrently.
+func (t *Task) IsCancelled() bool {
+	return t != nil && atomic.LoadInt32(&t.cancelled) == 1
+}
+
+func (t *Task) Suspend(flag bool) {
+	if t != nil && t.Status != StatusCancelled {
 		if flag {
 			t.Status = StatusSuspended
 		} else {
@@ -124,6 +171,7 @@ func (t *SubTask) Finish() {
 type Monitor struct {
 	TaskLock sync.Mutex
 	Tasks    map[string]*Task
+	Journal  *Journal // records changes of tasks if not nil
 }
 
 // NewTaskMonitor creates a Monitor and add pending tasks.
@@ -147,6 +195,7 @@ func (tm *Monitor) Pending(name string) {
 		Name:   name,
 		Status: StatusPending,
 	}
+	tm.Journal.Observe("", 0, tm.Tasks[name])
 }
 
 // Start a task.
@@ -155,6 +204,7 @@ func (tm *Monitor) Start(name string, total int) *Task {
 	defer tm.TaskLock.Unlock()
 	t := NewTask(name, total)
 	tm.Tasks[name] = t
+	tm.Journal.Observe("", 0, t)
 	return t
 }
 
@@ -164,7 +214,7 @@ func (tm *Monitor) Finish(name string) {
 	defer tm.TaskLock.Unlock()
 	task, exist := tm.Tasks[name]
 	if exist {
-		task.Finish()
+		tm.track(task, task.Finish)
 	}
 }
 
@@ -172,30 +222,79 @@ func (tm *Monitor) Finish(name string) {
 func (tm *Monitor) Update(name string, done int) {
 	tm.TaskLock.Lock()
 	defer tm.TaskLock.Unlock()
-	tm.Tasks[name].Update(done)
+	task := tm.Tasks[name]
+	tm.track(task, func() { task.Update(done) })
 }
 
 // Add the progress of a task.
 func (tm *Monitor) Add(name string, done int) {
 	tm.TaskLock.Lock()
 	defer tm.TaskLock.Unlock()
-	tm.Tasks[name].Add(done)
+	task := tm.Tasks[name]
+	tm.track(task, func() { task.Add(done) })
 }
 
 // Suspend a task.
 func (tm *Monitor) Suspend(name string, flag bool) {
 	tm.TaskLock.Lock()
 	defer tm.TaskLock.Unlock()
-	tm.Tasks[name].Suspend(flag)
+	task := tm.Tasks[name]
+	tm.track(task, func() { task.Suspend(flag) })
 }
 
 func (tm *Monitor) Fail(name, err string) {
 	tm.TaskLock.Lock()
 	defer tm.TaskLock.Unlock()
-	tm.Tasks[name].Fail(err)
+	task := tm.Tasks[name]
+	tm.track(task, func() { task.Fail(err) })
+}
+
+// Cancel a running or suspended task.
+func (tm *Monitor) Cancel(name string) error {
+	tm.TaskLock.Lock()
+	defer tm.TaskLock.Unlock()
+	task, exist := tm.Tasks[name]
+	if !exist {
+		return errors.NotFoundf("task %v", name)
+	}
+	if task.Status != StatusRunning && task.Status != StatusSuspended {
+		return errors.NotSupportedf("cancelling %v task", task.Status)
+	}
+	tm.track(task, task.Cancel)
+	return nil
+}
+
+// Retry a failed or cancelled task. The task becomes pending until it is started again.
+func (tm *Monitor) Retry(name string) error {
+	tm.TaskLock.Lock()
+	defer tm.TaskLock.Unlock()
+	task, exist := tm.Tasks[name]
+	if !exist {
+		return errors.NotFoundf("task %v", name)
+	}
+	if task.Status != StatusFailed && task.Status != StatusCancelled {
+		return errors.NotSupportedf("retrying %v task", task.Status)
+	}
+	tm.Tasks[name] = &Task{
+		Name:   name,
+		Status: StatusPending,
+	}
+	tm.Journal.Observe(task.Status, task.Progress, tm.Tasks[name])
+	return nil
+}
+
+// track applies a change to the task and records the change to the journal.
+func (tm *Monitor) track(task *Task, change func()) {
+	if task == nil {
+		change()
+		return
+	}
+	status, progress := task.Status, task.Progress
+	change()
+	tm.Journal.Observe(status, progress, task)
 }
 
-// List all tasks and remove tasks from disconnected workers.
+// List all tasks and remove tasks from disconnected workers. Progress and estimated finish times are updated on listing.
 func (tm *Monitor) List(workers ...string) []Task {
 	tm.TaskLock.Lock()
 	defer tm.TaskLock.Unlock()
@@ -211,6 +310,7 @@ func (tm *Monitor) List(workers ...string) []Task {
 				continue
 			}
 		}
+		t.Estimate()
 		task = append(task, *t)
 	}
 	sort.Sort(Tasks(task))
@@ -15,9 +15,11 @@
 package task
 
 import (
+	"github.com/juju/errors"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestTaskMonitor(t *testing.T) {
@@ -73,6 +75,49 @@ func TestTaskMonitor(t *testing.T) {
 	})
 }
 
+func TestTaskMonitor_Cancel(t *testing.T) {
+	taskMonitor := NewTaskMonitor()
+	assert.True(t, errors.IsNotFound(taskMonitor.Cancel("a")))
+	assert.True(t, errors.IsNotFound(taskMonitor.Retry("a")))
+
+	// cancel running task
+	task := taskMonitor.Start("a", 100)
+	assert.True(t, errors.IsNotSupported(taskMonitor.Retry("a")))
+	assert.NoError(t, taskMonitor.Cancel("a"))
+	assert.True(t, task.IsCancelled())
+	assert.True(t, errors.IsNotSupported(taskMonitor.Cancel("a")))
+	task.Add(10)
+	task.Suspend(true)
+	assert.Equal(t, 10, task.Done)
+	assert.Equal(t, StatusCancelled, task.Status)
+
+	// retry cancelled task
+	assert.NoError(t, taskMonitor.Retry("a"))
+	assert.Equal(t, StatusPending, taskMonitor.GetTask("a").Status)
+	assert.False(t, taskMonitor.GetTask("a").IsCancelled())
+	assert.True(t, errors.IsNotSupported(taskMonitor.Cancel("a")))
+
+	// retry failed task
+	taskMonitor.Start("b", 100)
+	taskMonitor.Fail("b", "error")
+	assert.NoError(t, taskMonitor.Retry("b"))
+	assert.Equal(t, StatusPending, taskMonitor.GetTask("b").Status)
+	assert.Empty(t, taskMonitor.GetTask("b").Error)
+}
+
+func TestTask_Estimate(t *testing.T) {
+	task := NewTask("a", 100)
+	assert.Zero(t, task.Progress)
+	assert.True(t, task.ETA.IsZero())
+	task.StartTime = time.Now().Add(-time.Minute)
+	task.Update(25)
+	assert.Equal(t, 25.0, task.Progress)
+	assert.WithinDuration(t, task.StartTime.Add(4*time.Minute), task.ETA, time.Second)
+	task.Finish()
+	assert.Equal(t, 100.0, task.Progress)
+	assert.True(t, task.ETA.IsZero())
+}
+
 func TestSubTask(t *testing.T) {
 	task := NewTask("a", 100)
 	task.Add(10)
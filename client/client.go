@@ -57,6 +57,10 @@ func (c *GorseClient) SessionRecommend(ctx context.Context, feedbacks []Feedback
 	return request[[]Score](ctx, c, "POST", c.entryPoint+fmt.Sprintf("/api/session/recommend?n=%d", n), feedbacks)
 }
 
+func (c *GorseClient) ComposeSlate(ctx context.Context, userId string, quotas []SlateQuota) ([]SlateItem, error) {
+	return request[[]SlateItem](ctx, c, "POST", c.entryPoint+fmt.Sprintf("/api/slate/%s", userId), quotas)
+}
+
 func (c *GorseClient) GetNeighbors(ctx context.Context, itemId string, n int) ([]Score, error) {
 	return request[[]Score, any](ctx, c, "GET", c.entryPoint+fmt.Sprintf("/api/item/%s/neighbors?n=%d", itemId, n), nil)
 }
@@ -65,6 +69,10 @@ func (c *GorseClient) InsertUser(ctx context.Context, user User) (RowAffected, e
 	return request[RowAffected](ctx, c, "POST", c.entryPoint+"/api/user", user)
 }
 
+func (c *GorseClient) InsertUsers(ctx context.Context, users []User) (RowAffected, error) {
+	return request[RowAffected](ctx, c, "POST", c.entryPoint+"/api/users", users)
+}
+
 func (c *GorseClient) UpdateUser(ctx context.Context, userId string, user UserPatch) (RowAffected, error) {
 	return request[RowAffected](ctx, c, "PATCH", fmt.Sprintf("%s/api/user/%s", c.entryPoint, userId), user)
 }
@@ -81,6 +89,10 @@ func (c *GorseClient) InsertItem(ctx context.Context, item Item) (RowAffected, e
 	return request[RowAffected](ctx, c, "POST", c.entryPoint+"/api/item", item)
 }
 
+func (c *GorseClient) InsertItems(ctx context.Context, items []Item) (RowAffected, error) {
+	return request[RowAffected](ctx, c, "POST", c.entryPoint+"/api/items", items)
+}
+
 func (c *GorseClient) UpdateItem(ctx context.Context, itemId string, item ItemPatch) (RowAffected, error) {
 	return request[RowAffected](ctx, c, "PATCH", fmt.Sprintf("%s/api/item/%s", c.entryPoint, itemId), item)
 }
@@ -58,6 +58,8 @@ type Item struct {
 	Categories []string `json:"Categories"`
 	Timestamp  string   `json:"Timestamp"`
 	Comment    string   `json:"Comment"`
+	Latitude   *float64 `json:"Latitude,omitempty"`
+	Longitude  *float64 `json:"Longitude,omitempty"`
 }
 
 type ItemPatch struct {
@@ -66,4 +68,17 @@ type ItemPatch struct {
 	Timestamp  *time.Time
 	Labels     []string
 	Comment    *string
+	Latitude   *float64
+	Longitude  *float64
+}
+
+type SlateQuota struct {
+	Source   string `json:"Source"`
+	Category string `json:"Category"`
+	N        int    `json:"N"`
+}
+
+type SlateItem struct {
+	ItemId string `json:"ItemId"`
+	Source string `json:"Source"`
 }
@@ -0,0 +1,298 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/schollz/progressbar/v3"
+	"github.com/zhenghaoz/gorse/client"
+)
+
+const (
+	opRecommend         = "recommend"
+	opRecommendCategory = "recommend_category"
+	opNeighbors         = "neighbors"
+	opInsertFeedback    = "insert_feedback"
+)
+
+// BenchOptions are options of the benchmark. Generated users, items and categories are named by their indices, so
+// that a benchmark with SkipGenerate reuses data generated by a previous benchmark of the same scale.
+type BenchOptions struct {
+	Endpoint     string
+	APIKey       string
+	Users        int
+	Items        int
+	Feedback     int
+	FeedbackType string
+	Categories   int
+	BatchSize    int
+	SkipGenerate bool
+	Duration     time.Duration
+	Concurrency  int
+	WriteRatio   float64
+	N            int
+	Seed         int64
+}
+
+func (options *BenchOptions) validate() error {
+	if options.Users <= 0 || options.Items <= 0 {
+		return errors.NotValidf("users and items must be positive, users=%d items=%d", options.Users, options.Items)
+	}
+	if options.BatchSize <= 0 || options.Concurrency <= 0 {
+		return errors.NotValidf("batch size and concurrency must be positive, batch_size=%d concurrency=%d",
+			options.BatchSize, options.Concurrency)
+	}
+	if options.WriteRatio < 0 || options.WriteRatio > 1 {
+		return errors.NotValidf("write ratio %v", options.WriteRatio)
+	}
+	return nil
+}
+
+func userId(i int) string {
+	return fmt.Sprintf("bench_user_%d", i)
+}
+
+func itemId(i int) string {
+	return fmt.Sprintf("bench_item_%d", i)
+}
+
+func category(i int) string {
+	return fmt.Sprintf("bench_category_%d", i)
+}
+
+// Bench generates synthetic data, drives the RESTful APIs with mixed read/write requests and writes latency
+// percentiles of each kind of requests to w.
+func Bench(ctx context.Context, options BenchOptions, w io.Writer) error {
+	if err := options.validate(); err != nil {
+		return errors.Trace(err)
+	}
+	c := client.NewGorseClient(options.Endpoint, options.APIKey)
+	rng := rand.New(rand.NewSource(options.Seed))
+	if !options.SkipGenerate {
+		if err := generate(ctx, c, options, rng); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	stats := run(ctx, c, options)
+	return errors.Trace(report(w, stats, options.Duration))
+}
+
+// generate inserts users, items and feedback. Items in feedback follow a Zipf distribution, so that some items are
+// much more popular than others like real world datasets.
+func generate(ctx context.Context, c *client.GorseClient, options BenchOptions, rng *rand.Rand) error {
+	now := time.Now()
+	bar := progressbar.Default(int64(options.Users+options.Items+options.Feedback), "Generating data")
+	for begin := 0; begin < options.Users; begin += options.BatchSize {
+		end := min(begin+options.BatchSize, options.Users)
+		users := make([]client.User, 0, end-begin)
+		for i := begin; i < end; i++ {
+			users = append(users, client.User{
+				UserId: userId(i),
+				Labels: []string{fmt.Sprintf("age:%d", 18+rng.Intn(50))},
+			})
+		}
+		if _, err := c.InsertUsers(ctx, users); err != nil {
+			return errors.Annotate(err, "failed to insert users")
+		}
+		_ = bar.Add(len(users))
+	}
+	for begin := 0; begin < options.Items; begin += options.BatchSize {
+		end := min(begin+options.BatchSize, options.Items)
+		items := make([]client.Item, 0, end-begin)
+		for i := begin; i < end; i++ {
+			item := client.Item{
+				ItemId:    itemId(i),
+				Labels:    []string{fmt.Sprintf("tag:%d", rng.Intn(100))},
+				Timestamp: now.Add(-time.Duration(rng.Int63n(int64(365 * 24 * time.Hour)))).Format(time.RFC3339),
+			}
+			if options.Categories > 0 {
+				item.Categories = []string{category(rng.Intn(options.Categories))}
+			}
+			items = append(items, item)
+		}
+		if _, err := c.InsertItems(ctx, items); err != nil {
+			return errors.Annotate(err, "failed to insert items")
+		}
+		_ = bar.Add(len(items))
+	}
+	zipf := newItemSampler(rng, options.Items)
+	for begin := 0; begin < options.Feedback; begin += options.BatchSize {
+		end := min(begin+options.BatchSize, options.Feedback)
+		feedback := make([]client.Feedback, 0, end-begin)
+		for i := begin; i < end; i++ {
+			feedback = append(feedback, client.Feedback{
+				FeedbackType: options.FeedbackType,
+				UserId:       userId(rng.Intn(options.Users)),
+				ItemId:       itemId(zipf()),
+				Timestamp:    now.Add(-time.Duration(rng.Int63n(int64(30 * 24 * time.Hour)))).Format(time.RFC3339),
+			})
+		}
+		if _, err := c.InsertFeedback(ctx, feedback); err != nil {
+			return errors.Annotate(err, "failed to insert feedback")
+		}
+		_ = bar.Add(len(feedback))
+	}
+	return nil
+}
+
+func newItemSampler(rng *rand.Rand, n int) func() int {
+	if n == 1 {
+		return func() int { return 0 }
+	}
+	zipf := rand.NewZipf(rng, 1.1, 1, uint64(n-1))
+	return func() int { return int(zipf.Uint64()) }
+}
+
+// opStats are latencies of successful requests and the number of failed requests of an operation.
+type opStats struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// run sends requests by concurrent clients until the duration elapses. Write requests insert a feedback, while read
+// requests get recommendation of a user, recommendation of a user in a category or neighbors of an item evenly.
+func run(ctx context.Context, c *client.GorseClient, options BenchOptions) map[string]*opStats {
+	ctx, cancel := context.WithTimeout(ctx, options.Duration)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		stats = make(map[string]*opStats)
+		wg    sync.WaitGroup
+	)
+	wg.Add(options.Concurrency)
+	for j := 0; j < options.Concurrency; j++ {
+		go func(j int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(options.Seed + int64(j) + 1))
+			zipf := newItemSampler(rng, options.Items)
+			local := make(map[string]*opStats)
+			for ctx.Err() == nil {
+				op, do := nextRequest(c, options, rng, zipf)
+				start := time.Now()
+				err := do(ctx)
+				if ctx.Err() != nil {
+					// requests interrupted by the end of the benchmark are not counted
+					break
+				}
+				if local[op] == nil {
+					local[op] = &opStats{}
+				}
+				if err != nil {
+					local[op].errors++
+				} else {
+					local[op].latencies = append(local[op].latencies, time.Since(start))
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for op, s := range local {
+				if stats[op] == nil {
+					stats[op] = &opStats{}
+				}
+				stats[op].latencies = append(stats[op].latencies, s.latencies...)
+				stats[op].errors += s.errors
+			}
+		}(j)
+	}
+	wg.Wait()
+	return stats
+}
+
+func nextRequest(c *client.GorseClient, options BenchOptions, rng *rand.Rand, zipf func() int) (string, func(context.Context) error) {
+	user := userId(rng.Intn(options.Users))
+	if rng.Float64() < options.WriteRatio {
+		feedback := []client.Feedback{{
+			FeedbackType: options.FeedbackType,
+			UserId:       user,
+			ItemId:       itemId(zipf()),
+			Timestamp:    time.Now().Format(time.RFC3339),
+		}}
+		return opInsertFeedback, func(ctx context.Context) error {
+			_, err := c.InsertFeedback(ctx, feedback)
+			return err
+		}
+	}
+	reads := 2
+	if options.Categories > 0 {
+		reads = 3
+	}
+	switch rng.Intn(reads) {
+	case 0:
+		return opRecommend, func(ctx context.Context) error {
+			_, err := c.GetRecommend(ctx, user, "", options.N)
+			return err
+		}
+	case 1:
+		item := itemId(zipf())
+		return opNeighbors, func(ctx context.Context) error {
+			_, err := c.GetNeighbors(ctx, item, options.N)
+			return err
+		}
+	default:
+		cat := category(rng.Intn(options.Categories))
+		return opRecommendCategory, func(ctx context.Context) error {
+			_, err := c.GetRecommend(ctx, user, cat, options.N)
+			return err
+		}
+	}
+}
+
+func report(w io.Writer, stats map[string]*opStats, duration time.Duration) error {
+	ops := make([]string, 0, len(stats))
+	for op := range stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "OPERATION\tREQUESTS\tERRORS\tQPS\tP50\tP90\tP99\tMAX")
+	for _, op := range ops {
+		s := stats[op]
+		sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+		_, _ = fmt.Fprintf(table, "%s\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\n", op, len(s.latencies)+s.errors, s.errors,
+			float64(len(s.latencies)+s.errors)/duration.Seconds(),
+			percentile(s.latencies, 0.5), percentile(s.latencies, 0.9), percentile(s.latencies, 0.99),
+			percentile(s.latencies, 1))
+	}
+	return errors.Trace(table.Flush())
+}
+
+// percentile returns the p-th percentile of sorted latencies by the nearest-rank method.
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return latencies[rank].Round(time.Microsecond)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
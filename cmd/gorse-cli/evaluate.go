@@ -0,0 +1,109 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/task"
+	"github.com/zhenghaoz/gorse/model"
+	"github.com/zhenghaoz/gorse/model/ranking"
+)
+
+const (
+	splitLeaveOneOut = "leave-one-out"
+	splitTimePrefix  = "time:"
+)
+
+// EvaluateOptions are options of the offline evaluation.
+type EvaluateOptions struct {
+	Model         string
+	Dataset       string
+	Sep           string
+	Header        bool
+	FeedbackTypes []string
+	Split         string
+	Params        model.Params
+	TopK          int
+	Candidates    int
+	Jobs          int
+	Seed          int64
+}
+
+// Evaluate trains a ranking model by the train set of the dataset and writes NDCG, precision, recall and MAP of the
+// model on the test set to w. The dataset is split by user-leave-one-out, or by time if the split is time:<datetime>.
+func Evaluate(options EvaluateOptions, w io.Writer) error {
+	m, err := newRankingModel(options.Model, options.Params)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dataset, timestamps, err := ranking.LoadDataFromCSV(options.Dataset, options.Sep, options.Header, options.FeedbackTypes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var trainSet, testSet *ranking.DataSet
+	switch {
+	case options.Split == splitLeaveOneOut:
+		trainSet, testSet = dataset.Split(0, options.Seed)
+	case strings.HasPrefix(options.Split, splitTimePrefix):
+		split, err := dateparse.ParseAny(strings.TrimPrefix(options.Split, splitTimePrefix))
+		if err != nil {
+			return errors.Annotatef(err, "failed to parse split `%s`", options.Split)
+		}
+		trainSet, testSet = dataset.SplitByTime(timestamps, split)
+	default:
+		return errors.NotValidf("split `%s`", options.Split)
+	}
+	if trainSet.Count() == 0 || testSet.Count() == 0 {
+		return errors.Errorf("empty train set or test set (train=%d, test=%d)", trainSet.Count(), testSet.Count())
+	}
+
+	// evaluate only after the last epoch while fitting
+	config := ranking.NewFitConfig().
+		SetJobsAllocator(task.NewConstantJobsAllocator(options.Jobs)).
+		SetVerbose(math.MaxInt32)
+	config.TopK = options.TopK
+	config.Candidates = options.Candidates
+	start := time.Now()
+	m.Fit(trainSet, testSet, config)
+	fitTime := time.Since(start)
+	scores := ranking.Evaluate(m, testSet, trainSet, options.TopK, options.Candidates, options.Jobs,
+		ranking.NDCG, ranking.Precision, ranking.Recall, ranking.MAP)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "MODEL\tUSERS\tITEMS\tTRAIN\tTEST\tFIT TIME\t"+
+		fmt.Sprintf("NDCG@%d\tPRECISION@%d\tRECALL@%d\tMAP@%d", options.TopK, options.TopK, options.TopK, options.TopK))
+	_, _ = fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%d\t%v\t%.4f\t%.4f\t%.4f\t%.4f\n", options.Model,
+		dataset.UserCount(), dataset.ItemCount(), trainSet.Count(), testSet.Count(), fitTime.Round(time.Millisecond),
+		scores[0], scores[1], scores[2], scores[3])
+	return errors.Trace(table.Flush())
+}
+
+func newRankingModel(name string, params model.Params) (ranking.MatrixFactorization, error) {
+	switch name {
+	case ranking.CollaborativeBPR:
+		return ranking.NewBPR(params), nil
+	case ranking.CollaborativeCCD:
+		return ranking.NewCCD(params), nil
+	}
+	return nil, errors.NotSupportedf("model `%s`", name)
+}
@@ -0,0 +1,309 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/cmd/version"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/model"
+)
+
+var rootCommand = &cobra.Command{
+	Use:   "gorse-cli",
+	Short: "The command line tool of gorse recommender system.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if showVersion, _ := cmd.Flags().GetBool("version"); showVersion {
+			fmt.Println(version.BuildInfo())
+			return
+		}
+		_ = cmd.Help()
+	},
+}
+
+var benchCommand = &cobra.Command{
+	Use:   "bench",
+	Short: "Generate synthetic data and benchmark the RESTful APIs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		var options BenchOptions
+		options.Endpoint, _ = cmd.Flags().GetString("endpoint")
+		options.APIKey, _ = cmd.Flags().GetString("api-key")
+		options.Users, _ = cmd.Flags().GetInt("users")
+		options.Items, _ = cmd.Flags().GetInt("items")
+		options.Feedback, _ = cmd.Flags().GetInt("feedback")
+		options.FeedbackType, _ = cmd.Flags().GetString("feedback-type")
+		options.Categories, _ = cmd.Flags().GetInt("categories")
+		options.BatchSize, _ = cmd.Flags().GetInt("batch-size")
+		options.SkipGenerate, _ = cmd.Flags().GetBool("skip-generate")
+		options.Duration, _ = cmd.Flags().GetDuration("duration")
+		options.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+		options.WriteRatio, _ = cmd.Flags().GetFloat64("write-ratio")
+		options.N, _ = cmd.Flags().GetInt("n")
+		options.Seed, _ = cmd.Flags().GetInt64("seed")
+		if err := Bench(cmd.Context(), options, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var evaluateCommand = &cobra.Command{
+	Use:   "evaluate",
+	Short: "Train and evaluate a ranking model by a dataset without a running cluster.",
+	Run: func(cmd *cobra.Command, args []string) {
+		debug, _ := cmd.Flags().GetBool("debug")
+		log.SetLogger(cmd.Flags(), debug)
+		var options EvaluateOptions
+		options.Model, _ = cmd.Flags().GetString("model")
+		options.Dataset, _ = cmd.Flags().GetString("dataset")
+		options.Sep, _ = cmd.Flags().GetString("sep")
+		options.Header, _ = cmd.Flags().GetBool("header")
+		options.FeedbackTypes, _ = cmd.Flags().GetStringSlice("feedback-types")
+		options.Split, _ = cmd.Flags().GetString("split")
+		options.TopK, _ = cmd.Flags().GetInt("top-k")
+		options.Candidates, _ = cmd.Flags().GetInt("candidates")
+		options.Jobs, _ = cmd.Flags().GetInt("jobs")
+		options.Seed, _ = cmd.Flags().GetInt64("seed")
+		options.Params = modelParams(cmd)
+		if err := Evaluate(options, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var simulateCommand = &cobra.Command{
+	Use:   "simulate",
+	Short: "Replay feedback chronologically to estimate online metrics of a configuration.",
+	Run: func(cmd *cobra.Command, args []string) {
+		debug, _ := cmd.Flags().GetBool("debug")
+		log.SetLogger(cmd.Flags(), debug)
+		var options SimulateOptions
+		options.Model, _ = cmd.Flags().GetString("model")
+		options.Dataset, _ = cmd.Flags().GetString("dataset")
+		options.Sep, _ = cmd.Flags().GetString("sep")
+		options.Header, _ = cmd.Flags().GetBool("header")
+		options.FeedbackTypes, _ = cmd.Flags().GetStringSlice("feedback-types")
+		options.PopularWindow, _ = cmd.Flags().GetDuration("popular-window")
+		options.Interval, _ = cmd.Flags().GetDuration("interval")
+		options.Steps, _ = cmd.Flags().GetInt("steps")
+		options.TopK, _ = cmd.Flags().GetInt("top-k")
+		options.Jobs, _ = cmd.Flags().GetInt("jobs")
+		// positive feedback types and the popular window are read from the configuration if not set
+		if configPath, _ := cmd.Flags().GetString("config"); configPath != "" {
+			conf, err := config.LoadConfig(configPath, true)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if !cmd.Flags().Changed("feedback-types") {
+				options.FeedbackTypes = conf.Recommend.DataSource.PositiveFeedbackTypes
+			}
+			if !cmd.Flags().Changed("popular-window") {
+				options.PopularWindow = conf.Recommend.Popular.PopularWindow
+			}
+		}
+		start, _ := cmd.Flags().GetString("start")
+		var err error
+		if options.Start, err = dateparse.ParseAny(start); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		options.Params = modelParams(cmd)
+		if err = Simulate(options, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var migrateCommand = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply or revert versioned migrations of the data store.",
+	Run: func(cmd *cobra.Command, args []string) {
+		debug, _ := cmd.Flags().GetBool("debug")
+		log.SetLogger(cmd.Flags(), debug)
+		var options MigrateOptions
+		options.DataStore, _ = cmd.Flags().GetString("data-store")
+		options.TablePrefix, _ = cmd.Flags().GetString("table-prefix")
+		options.Version, _ = cmd.Flags().GetInt("to")
+		options.Status, _ = cmd.Flags().GetBool("status")
+		// the data store and the table prefix are read from the configuration if not set
+		if configPath, _ := cmd.Flags().GetString("config"); configPath != "" {
+			conf, err := config.LoadConfig(configPath, false)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if !cmd.Flags().Changed("data-store") {
+				options.DataStore = conf.Database.DataStore
+			}
+			if !cmd.Flags().Changed("table-prefix") {
+				options.TablePrefix = conf.Database.DataTablePrefix
+			}
+		}
+		if options.DataStore == "" {
+			fmt.Fprintln(os.Stderr, "either --config or --data-store is required")
+			os.Exit(1)
+		}
+		if err := Migrate(cmd.Context(), options, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var purgeCacheCommand = &cobra.Command{
+	Use:   "purge-cache",
+	Short: "Remove keys of an old version from the cache store.",
+	Run: func(cmd *cobra.Command, args []string) {
+		debug, _ := cmd.Flags().GetBool("debug")
+		log.SetLogger(cmd.Flags(), debug)
+		var options PurgeCacheOptions
+		options.CacheStore, _ = cmd.Flags().GetString("cache-store")
+		options.TablePrefix, _ = cmd.Flags().GetString("table-prefix")
+		options.Version, _ = cmd.Flags().GetInt("key-version")
+		options.CurrentVersion = -1
+		// the cache store, the table prefix and the current version are read from the configuration if not set
+		if configPath, _ := cmd.Flags().GetString("config"); configPath != "" {
+			conf, err := config.LoadConfig(configPath, false)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if !cmd.Flags().Changed("cache-store") {
+				options.CacheStore = conf.Database.CacheStore
+			}
+			if !cmd.Flags().Changed("table-prefix") {
+				options.TablePrefix = conf.Database.CacheTablePrefix
+			}
+			options.CurrentVersion = conf.Database.CacheKeyVersion
+		}
+		if options.CacheStore == "" {
+			fmt.Fprintln(os.Stderr, "either --config or --cache-store is required")
+			os.Exit(1)
+		}
+		if err := PurgeCache(options, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// modelParams returns hyper-parameters of ranking models in flags. Hyper-parameters not set are left to defaults.
+func modelParams(cmd *cobra.Command) model.Params {
+	seed, _ := cmd.Flags().GetInt64("seed")
+	params := model.Params{model.RandomState: seed}
+	for flag, name := range map[string]model.ParamName{"n-epochs": model.NEpochs, "n-factors": model.NFactors} {
+		if cmd.Flags().Changed(flag) {
+			params[name], _ = cmd.Flags().GetInt(flag)
+		}
+	}
+	for flag, name := range map[string]model.ParamName{"lr": model.Lr, "reg": model.Reg, "alpha": model.Alpha} {
+		if cmd.Flags().Changed(flag) {
+			value, _ := cmd.Flags().GetFloat32(flag)
+			params[name] = value
+		}
+	}
+	return params
+}
+
+// addModelFlags adds flags of the dataset and hyper-parameters of ranking models.
+func addModelFlags(flags *pflag.FlagSet) {
+	log.AddFlags(flags)
+	flags.Bool("debug", false, "use debug log mode")
+	flags.String("dataset", "", "CSV file of feedback types, user ids, item ids and timestamps")
+	flags.String("sep", ",", "separator of the CSV file")
+	flags.Bool("header", true, "skip the header line of the CSV file")
+	flags.StringSlice("feedback-types", nil, "positive feedback types, all feedback are positive if empty")
+	flags.Int("top-k", 10, "number of recommended items to evaluate")
+	flags.Int("jobs", runtime.NumCPU(), "number of jobs to train and evaluate")
+	flags.Int64("seed", 0, "random seed of the split and the model")
+	flags.Int("n-epochs", 0, "number of epochs, the default of the model if not set")
+	flags.Int("n-factors", 0, "number of factors, the default of the model if not set")
+	flags.Float32("lr", 0, "learning rate of bpr, the default of the model if not set")
+	flags.Float32("reg", 0, "regularization strength, the default of the model if not set")
+	flags.Float32("alpha", 0, "weight of negative samples of ccd, the default of the model if not set")
+}
+
+func init() {
+	rootCommand.Flags().BoolP("version", "v", false, "gorse version")
+	benchCommand.Flags().String("endpoint", "http://127.0.0.1:8087", "endpoint of RESTful APIs")
+	benchCommand.Flags().String("api-key", "", "API key of RESTful APIs")
+	benchCommand.Flags().Int("users", 1000, "number of generated users")
+	benchCommand.Flags().Int("items", 1000, "number of generated items")
+	benchCommand.Flags().Int("feedback", 10000, "number of generated feedback")
+	benchCommand.Flags().String("feedback-type", "star", "type of generated feedback")
+	benchCommand.Flags().Int("categories", 10, "number of categories of generated items")
+	benchCommand.Flags().Int("batch-size", 1000, "batch size to insert generated data")
+	benchCommand.Flags().Bool("skip-generate", false, "skip data generation and benchmark existed data")
+	benchCommand.Flags().Duration("duration", 30*time.Second, "duration of the benchmark")
+	benchCommand.Flags().Int("concurrency", 10, "number of concurrent clients")
+	benchCommand.Flags().Float64("write-ratio", 0.1, "ratio of requests inserting feedback, the others read recommendation")
+	benchCommand.Flags().Int("n", 10, "number of recommended items per request")
+	benchCommand.Flags().Int64("seed", 0, "random seed of generated data and requests")
+	rootCommand.AddCommand(benchCommand)
+
+	addModelFlags(evaluateCommand.Flags())
+	evaluateCommand.Flags().String("model", "bpr", "ranking model (bpr or ccd)")
+	evaluateCommand.Flags().String("split", "leave-one-out", "split method (leave-one-out or time:<datetime>)")
+	evaluateCommand.Flags().Int("candidates", 100, "number of negative candidates to evaluate")
+	_ = evaluateCommand.MarkFlagRequired("dataset")
+	rootCommand.AddCommand(evaluateCommand)
+
+	addModelFlags(simulateCommand.Flags())
+	simulateCommand.Flags().String("model", "bpr", "recommender to simulate (bpr, ccd or popular)")
+	simulateCommand.Flags().String("config", "", "configuration to read positive feedback types and the popular window")
+	simulateCommand.Flags().Duration("popular-window", 0, "time window of popular items, all feedback are counted if zero")
+	simulateCommand.Flags().String("start", "", "begin time of the first step")
+	simulateCommand.Flags().Duration("interval", 24*time.Hour, "interval of offline refresh, i.e. the length of a step")
+	simulateCommand.Flags().Int("steps", 7, "number of steps")
+	_ = simulateCommand.MarkFlagRequired("dataset")
+	_ = simulateCommand.MarkFlagRequired("start")
+	rootCommand.AddCommand(simulateCommand)
+
+	log.AddFlags(migrateCommand.Flags())
+	migrateCommand.Flags().Bool("debug", false, "use debug log mode")
+	migrateCommand.Flags().String("config", "", "configuration to read the data store and the table prefix")
+	migrateCommand.Flags().String("data-store", "", "database for data store")
+	migrateCommand.Flags().String("table-prefix", "", "prefix of tables in the data store")
+	migrateCommand.Flags().Int("to", -1, "version to migrate to, the latest version if negative and 0 reverts all migrations")
+	migrateCommand.Flags().Bool("status", false, "print the status of migrations without migrating")
+	rootCommand.AddCommand(migrateCommand)
+
+	log.AddFlags(purgeCacheCommand.Flags())
+	purgeCacheCommand.Flags().Bool("debug", false, "use debug log mode")
+	purgeCacheCommand.Flags().String("config", "", "configuration to read the cache store, the table prefix and the current version")
+	purgeCacheCommand.Flags().String("cache-store", "", "database for cache store")
+	purgeCacheCommand.Flags().String("table-prefix", "", "prefix of tables in the cache store")
+	purgeCacheCommand.Flags().Int("key-version", 0, "version of keys to purge, which must differ from the current version")
+	_ = purgeCacheCommand.MarkFlagRequired("key-version")
+	rootCommand.AddCommand(purgeCacheCommand)
+}
+
+func main() {
+	if err := rootCommand.ExecuteContext(context.Background()); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// MigrateOptions are options to migrate the data store.
+type MigrateOptions struct {
+	DataStore   string
+	TablePrefix string
+	Version     int  // the latest version if negative
+	Status      bool // print the status of migrations without migrating
+}
+
+// Migrate applies or reverts migrations of the data store to reach the version, then prints the status of migrations.
+func Migrate(ctx context.Context, options MigrateOptions, w io.Writer) error {
+	database, err := data.Open(options.DataStore, options.TablePrefix)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer database.Close()
+	migrator, ok := database.(data.Migrator)
+	if !ok {
+		return errors.NotSupportedf("migrations of data store %s", log.RedactDBURL(options.DataStore))
+	}
+	if !options.Status {
+		version := options.Version
+		if version < 0 {
+			version = data.LatestVersion(migrator.Migrations())
+		}
+		if err = migrator.Migrate(ctx, version); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	applied, err := migrator.AppliedMigrations(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	appliedTimes := lo.SliceToMap(applied, func(m data.AppliedMigration) (int, time.Time) {
+		return m.Version, m.AppliedAt
+	})
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(table, "VERSION\tDESCRIPTION\tAPPLIED")
+	for _, m := range migrator.Migrations() {
+		appliedAt := "pending"
+		if t, exist := appliedTimes[m.Version]; exist {
+			appliedAt = t.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(table, "%d\t%s\t%s\n", m.Version, m.Description, appliedAt)
+	}
+	return errors.Trace(table.Flush())
+}
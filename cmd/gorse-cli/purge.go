@@ -0,0 +1,60 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+// PurgeCacheOptions are options to purge keys of a version in the cache store.
+type PurgeCacheOptions struct {
+	CacheStore     string
+	TablePrefix    string
+	Version        int // version of keys to purge
+	CurrentVersion int // version of keys in use, which is never purged, negative if unknown
+}
+
+// PurgeCache removes keys of an old version from the cache store. Keys of other versions are kept, so that the cache
+// store is still shared by clusters using other versions.
+func PurgeCache(options PurgeCacheOptions, w io.Writer) error {
+	if options.Version < 0 {
+		return errors.NotValidf("version %d", options.Version)
+	}
+	if options.Version == options.CurrentVersion {
+		return errors.NotValidf("purging keys of the current version %d", options.Version)
+	}
+	database, err := cache.Open(options.CacheStore, storage.VersionedTablePrefix(options.TablePrefix, options.Version))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer database.Close()
+	numKeys := 0
+	if err = database.Scan(func(string) error {
+		numKeys++
+		return nil
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	if err = database.Purge(); err != nil {
+		return errors.Trace(err)
+	}
+	_, _ = fmt.Fprintf(w, "purged %d keys of version %d\n", numKeys, options.Version)
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/i32set"
+	"github.com/zhenghaoz/gorse/base/heap"
+	"github.com/zhenghaoz/gorse/base/parallel"
+	"github.com/zhenghaoz/gorse/base/task"
+	"github.com/zhenghaoz/gorse/model"
+	"github.com/zhenghaoz/gorse/model/ranking"
+)
+
+// PopularRecommender recommends popular items only.
+const PopularRecommender = "popular"
+
+// SimulateOptions are options of the replay simulation.
+type SimulateOptions struct {
+	Model         string
+	Dataset       string
+	Sep           string
+	Header        bool
+	FeedbackTypes []string
+	PopularWindow time.Duration
+	Start         time.Time
+	Interval      time.Duration
+	Steps         int
+	Params        model.Params
+	TopK          int
+	Jobs          int
+}
+
+// simulateStep is the result of a step of the simulation.
+type simulateStep struct {
+	train     int
+	users     int
+	precision float32
+	recall    float32
+	ndcg      float32
+	hr        float32
+}
+
+// Simulate replays feedback in the dataset chronologically. For each step, the model is refreshed by feedback before
+// the step like the offline refresh of the worker, then items are recommended to users who have feedback in the
+// step and compared with the feedback in the step. Items without feedback before the step are not recommended, and
+// users without feedback before the step are recommended popular items like the fallback recommendation.
+func Simulate(options SimulateOptions, w io.Writer) error {
+	if options.Steps <= 0 || options.Interval <= 0 {
+		return errors.NotValidf("steps %d and interval %v", options.Steps, options.Interval)
+	}
+	if options.Model != PopularRecommender {
+		if _, err := newRankingModel(options.Model, options.Params); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	dataset, timestamps, err := ranking.LoadDataFromCSV(options.Dataset, options.Sep, options.Header, options.FeedbackTypes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if options.Start.IsZero() {
+		return errors.NotValidf("empty start time")
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(table, "STEP\tBEGIN\tTRAIN\tUSERS\tPRECISION@%d\tRECALL@%d\tNDCG@%d\tHR@%d\n",
+		options.TopK, options.TopK, options.TopK, options.TopK)
+	var (
+		total simulateStep
+		steps int
+	)
+	for k := 0; k < options.Steps; k++ {
+		begin := options.Start.Add(time.Duration(k) * options.Interval)
+		step, err := simulate(dataset, timestamps, begin, begin.Add(options.Interval), options)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, _ = fmt.Fprintf(table, "%d\t%s\t%d\t%d\t%.4f\t%.4f\t%.4f\t%.4f\n", k+1, begin.Format(time.RFC3339),
+			step.train, step.users, step.precision, step.recall, step.ndcg, step.hr)
+		if step.users > 0 {
+			steps++
+			total.precision += step.precision
+			total.recall += step.recall
+			total.ndcg += step.ndcg
+			total.hr += step.hr
+		}
+	}
+	if steps > 0 {
+		_, _ = fmt.Fprintf(table, "AVG\t\t\t\t%.4f\t%.4f\t%.4f\t%.4f\n", total.precision/float32(steps),
+			total.recall/float32(steps), total.ndcg/float32(steps), total.hr/float32(steps))
+	}
+	return errors.Trace(table.Flush())
+}
+
+func simulate(dataset *ranking.DataSet, timestamps []time.Time, begin, end time.Time, options SimulateOptions) (simulateStep, error) {
+	var step simulateStep
+	trainSet, testSet := dataset.SplitByTime(timestamps, begin)
+	step.train = trainSet.Count()
+	// collect feedback in the step
+	targets := make(map[int32]*i32set.Set)
+	for i := 0; i < dataset.Count(); i++ {
+		if !timestamps[i].Before(begin) && timestamps[i].Before(end) {
+			userIndex, itemIndex := dataset.GetIndex(i)
+			if targets[userIndex] == nil {
+				targets[userIndex] = i32set.New()
+			}
+			targets[userIndex].Add(itemIndex)
+		}
+	}
+	step.users = len(targets)
+	if step.train == 0 || step.users == 0 {
+		return step, nil
+	}
+
+	// refresh recommenders
+	popular := popularItems(dataset, timestamps, begin, options.PopularWindow)
+	var m ranking.MatrixFactorization
+	if options.Model != PopularRecommender {
+		m, _ = newRankingModel(options.Model, options.Params)
+		config := ranking.NewFitConfig().
+			SetJobsAllocator(task.NewConstantJobsAllocator(options.Jobs)).
+			SetVerbose(math.MaxInt32)
+		m.Fit(trainSet, testSet, config)
+	}
+
+	// recommend and compare with feedback in the step
+	users := make([]int32, 0, len(targets))
+	for userIndex := range targets {
+		users = append(users, userIndex)
+	}
+	scores := make([][4]float32, len(users))
+	_ = parallel.Parallel(len(users), options.Jobs, func(_, i int) error {
+		userIndex := users[i]
+		excludeSet := i32set.New(trainSet.UserFeedback[userIndex]...)
+		var recommends []int32
+		if m != nil && m.IsUserPredictable(userIndex) {
+			candidates := make([]int32, 0, trainSet.ItemCount())
+			for itemIndex := int32(0); itemIndex < int32(trainSet.ItemCount()); itemIndex++ {
+				if m.IsItemPredictable(itemIndex) && !excludeSet.Has(itemIndex) {
+					candidates = append(candidates, itemIndex)
+				}
+			}
+			recommends, _ = ranking.Rank(m, userIndex, candidates, options.TopK)
+		} else {
+			for _, itemIndex := range popular {
+				if len(recommends) >= options.TopK {
+					break
+				}
+				if !excludeSet.Has(itemIndex) {
+					recommends = append(recommends, itemIndex)
+				}
+			}
+		}
+		target := targets[userIndex]
+		scores[i] = [4]float32{ranking.Precision(target, recommends), ranking.Recall(target, recommends),
+			ranking.NDCG(target, recommends), ranking.HR(target, recommends)}
+		return nil
+	})
+	for _, score := range scores {
+		step.precision += score[0]
+		step.recall += score[1]
+		step.ndcg += score[2]
+		step.hr += score[3]
+	}
+	n := float32(len(users))
+	step.precision, step.recall, step.ndcg, step.hr = step.precision/n, step.recall/n, step.ndcg/n, step.hr/n
+	return step, nil
+}
+
+// popularItems ranks items by the number of feedback in the popular window before the time. All feedback before the
+// time are counted if the window is zero.
+func popularItems(dataset *ranking.DataSet, timestamps []time.Time, before time.Time, window time.Duration) []int32 {
+	counts := make([]int, dataset.ItemCount())
+	for i := 0; i < dataset.Count(); i++ {
+		if timestamps[i].Before(before) && (window == 0 || !timestamps[i].Before(before.Add(-window))) {
+			_, itemIndex := dataset.GetIndex(i)
+			counts[itemIndex]++
+		}
+	}
+	filter := heap.NewTopKFilter[int32, float64](dataset.ItemCount())
+	for itemIndex, count := range counts {
+		if count > 0 {
+			filter.Push(int32(itemIndex), float64(count))
+		}
+	}
+	items, _ := filter.PopAll()
+	return items
+}
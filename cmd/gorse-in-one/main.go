@@ -26,16 +26,19 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/benhoyt/goawk/interp"
 	"github.com/benhoyt/goawk/parser"
 	"github.com/juju/errors"
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
+	"github.com/zhenghaoz/gorse/base/diagnostics"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/cmd/version"
 	"github.com/zhenghaoz/gorse/config"
 	"github.com/zhenghaoz/gorse/master"
+	"github.com/zhenghaoz/gorse/protocol"
 	"github.com/zhenghaoz/gorse/storage"
 	"github.com/zhenghaoz/gorse/storage/data"
 	"github.com/zhenghaoz/gorse/worker"
@@ -63,13 +66,13 @@ var oneCommand = &cobra.Command{
 
 		// load config
 		var conf *config.Config
+		var configPath string
 		var err error
 		playgroundMode, _ := cmd.PersistentFlags().GetBool("playground")
 		if playgroundMode {
 			// load config for playground
 			conf = config.GetDefaultConfig()
-			conf.Database.DataStore = "sqlite://data.db"
-			conf.Database.CacheStore = "sqlite://cache.db"
+			conf.UseEmbeddedStores()
 			conf.Recommend.DataSource.PositiveFeedbackTypes = []string{"star", "like"}
 			conf.Recommend.DataSource.ReadFeedbackTypes = []string{"read"}
 			if err := conf.Validate(true); err != nil {
@@ -87,8 +90,17 @@ var oneCommand = &cobra.Command{
 			fmt.Printf("    RESTful APIs:  http://127.0.0.1:%d/apidocs\n", conf.Master.HttpPort)
 			fmt.Printf("    Documentation: https://gorse.io/docs\n")
 			fmt.Println()
+		} else if configPath, _ = cmd.PersistentFlags().GetString("config"); configPath == "" {
+			// run with embedded stores and the default config
+			conf = config.GetDefaultConfig()
+			conf.UseEmbeddedStores()
+			if err := conf.Validate(true); err != nil {
+				log.Logger().Fatal("invalid config", zap.Error(err))
+			}
+			log.Logger().Info("no config file, run with embedded stores",
+				zap.String("data_store", conf.Database.DataStore),
+				zap.String("cache_store", conf.Database.CacheStore))
 		} else {
-			configPath, _ := cmd.PersistentFlags().GetString("config")
 			log.Logger().Info("load config", zap.String("config", configPath))
 			conf, err = config.LoadConfig(configPath, true)
 			if err != nil {
@@ -100,10 +112,31 @@ var oneCommand = &cobra.Command{
 		cachePath, _ := cmd.PersistentFlags().GetString("cache-path")
 		managedMode, _ := cmd.PersistentFlags().GetBool("managed")
 		m := master.NewMaster(conf, cachePath, managedMode)
+		if configPath != "" {
+			m.SetConfigFile(configPath, true)
+			// Reload config
+			go func() {
+				sighup := make(chan os.Signal, 1)
+				signal.Notify(sighup, syscall.SIGHUP)
+				for range sighup {
+					if _, err := m.ReloadConfig(); err != nil {
+						log.Logger().Error("failed to reload config", zap.Error(err))
+					}
+				}
+			}()
+		}
 		// Start worker
 		workerJobs, _ := cmd.PersistentFlags().GetInt("recommend-jobs")
+		var tlsConfig *protocol.TLSConfig
+		if conf.Master.SSLMode {
+			tlsConfig = &protocol.TLSConfig{
+				SSLCA:   conf.Master.SSLCA,
+				SSLCert: conf.Master.SSLCert,
+				SSLKey:  conf.Master.SSLKey,
+			}
+		}
 		w := worker.NewWorker(conf.Master.Host, conf.Master.Port, conf.Master.Host,
-			0, workerJobs, "", managedMode)
+			0, workerJobs, "", managedMode, tlsConfig)
 		go func() {
 			w.SetOneMode(m.Settings)
 			w.Serve()
@@ -119,6 +152,7 @@ var oneCommand = &cobra.Command{
 		}()
 		// Start master
 		m.SetOneMode(w.ScheduleAPIHandler)
+		diagnostics.Serve(cmd.PersistentFlags(), func() string { return m.Config().Master.AdminAPIKey })
 		m.Serve()
 		<-done
 		log.Logger().Info("stop gorse-in-one successfully")
@@ -127,11 +161,12 @@ var oneCommand = &cobra.Command{
 
 func init() {
 	log.AddFlags(oneCommand.PersistentFlags())
+	diagnostics.AddFlags(oneCommand.PersistentFlags())
 	oneCommand.PersistentFlags().Bool("debug", false, "use debug log mode")
 	oneCommand.PersistentFlags().Bool("managed", false, "enable managed mode")
 	oneCommand.PersistentFlags().BoolP("version", "v", false, "gorse version")
 	oneCommand.PersistentFlags().Bool("playground", false, "playground mode (setup a recommender system for GitHub repositories)")
-	oneCommand.PersistentFlags().StringP("config", "c", "", "configuration file path")
+	oneCommand.PersistentFlags().StringP("config", "c", "", "configuration file path (embedded stores and the default configuration are used if empty)")
 	oneCommand.PersistentFlags().String("cache-path", "one_cache.data", "path of cache file")
 	oneCommand.PersistentFlags().Int("recommend-jobs", 1, "number of working jobs for recommendation tasks")
 }
@@ -15,11 +15,12 @@ package main
 
 import (
 	"fmt"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/zhenghaoz/gorse/base/diagnostics"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/cmd/version"
 	"github.com/zhenghaoz/gorse/config"
@@ -40,8 +41,24 @@ var masterCommand = &cobra.Command{
 		debug, _ := cmd.PersistentFlags().GetBool("debug")
 		log.SetLogger(cmd.PersistentFlags(), debug)
 
-		// Create master
 		configPath, _ := cmd.PersistentFlags().GetString("config")
+		// Validate config
+		if validateConfig, _ := cmd.PersistentFlags().GetBool("validate-config"); validateConfig {
+			issues, err := master.ValidateConfig(configPath, false)
+			if err != nil {
+				log.Logger().Fatal("failed to validate config", zap.Error(err))
+			}
+			for _, issue := range issues {
+				fmt.Println(issue)
+			}
+			if config.HasError(issues) {
+				os.Exit(1)
+			}
+			fmt.Println("config is valid")
+			return
+		}
+
+		// Create master
 		log.Logger().Info("load config", zap.String("config", configPath))
 		conf, err := config.LoadConfig(configPath, false)
 		if err != nil {
@@ -50,6 +67,17 @@ var masterCommand = &cobra.Command{
 		cachePath, _ := cmd.PersistentFlags().GetString("cache-path")
 		managedMode, _ := cmd.PersistentFlags().GetBool("managed")
 		m := master.NewMaster(conf, cachePath, managedMode)
+		m.SetConfigFile(configPath, false)
+		// Reload config
+		go func() {
+			sighup := make(chan os.Signal, 1)
+			signal.Notify(sighup, syscall.SIGHUP)
+			for range sighup {
+				if _, err := m.ReloadConfig(); err != nil {
+					log.Logger().Error("failed to reload config", zap.Error(err))
+				}
+			}
+		}()
 		// Stop master
 		done := make(chan struct{})
 		go func() {
@@ -60,6 +88,7 @@ var masterCommand = &cobra.Command{
 			close(done)
 		}()
 		// Start master
+		diagnostics.Serve(cmd.PersistentFlags(), func() string { return m.Config().Master.AdminAPIKey })
 		m.Serve()
 		<-done
 		log.Logger().Info("stop gorse master successfully")
@@ -68,10 +97,12 @@ var masterCommand = &cobra.Command{
 
 func init() {
 	log.AddFlags(masterCommand.PersistentFlags())
+	diagnostics.AddFlags(masterCommand.PersistentFlags())
 	masterCommand.PersistentFlags().Bool("debug", false, "use debug log mode")
 	masterCommand.PersistentFlags().Bool("managed", false, "enable managed mode")
 	masterCommand.PersistentFlags().StringP("config", "c", "", "configuration file path")
 	masterCommand.PersistentFlags().BoolP("version", "v", false, "gorse version")
+	masterCommand.PersistentFlags().Bool("validate-config", false, "validate configuration and exit")
 	masterCommand.PersistentFlags().String("cache-path", "master_cache.data", "path of cache file")
 }
 
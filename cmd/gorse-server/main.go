@@ -16,11 +16,12 @@ package main
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/zhenghaoz/gorse/base/diagnostics"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/cmd/version"
+	"github.com/zhenghaoz/gorse/protocol"
 	"github.com/zhenghaoz/gorse/server"
 	"go.uber.org/zap"
-	_ "net/http/pprof"
 	"os"
 	"os/signal"
 )
@@ -46,7 +47,14 @@ var serverCommand = &cobra.Command{
 		httpPort, _ := cmd.PersistentFlags().GetInt("http-port")
 		httpHost, _ := cmd.PersistentFlags().GetString("http-host")
 		cachePath, _ := cmd.PersistentFlags().GetString("cache-path")
-		s := server.NewServer(masterHost, masterPort, httpHost, httpPort, cachePath)
+		var tlsConfig *protocol.TLSConfig
+		if sslMode, _ := cmd.PersistentFlags().GetBool("ssl-mode"); sslMode {
+			tlsConfig = &protocol.TLSConfig{}
+			tlsConfig.SSLCA, _ = cmd.PersistentFlags().GetString("ssl-ca")
+			tlsConfig.SSLCert, _ = cmd.PersistentFlags().GetString("ssl-cert")
+			tlsConfig.SSLKey, _ = cmd.PersistentFlags().GetString("ssl-key")
+		}
+		s := server.NewServer(masterHost, masterPort, httpHost, httpPort, cachePath, tlsConfig)
 
 		// stop server
 		done := make(chan struct{})
@@ -59,6 +67,7 @@ var serverCommand = &cobra.Command{
 		}()
 
 		// start server
+		diagnostics.Serve(cmd.PersistentFlags(), func() string { return s.Config().Master.AdminAPIKey })
 		s.Serve()
 		<-done
 		log.Logger().Info("stop gorse server successfully")
@@ -67,6 +76,7 @@ var serverCommand = &cobra.Command{
 
 func init() {
 	log.AddFlags(serverCommand.PersistentFlags())
+	diagnostics.AddFlags(serverCommand.PersistentFlags())
 	serverCommand.PersistentFlags().BoolP("version", "v", false, "gorse version")
 	serverCommand.PersistentFlags().Int("master-port", 8086, "port of master node")
 	serverCommand.PersistentFlags().String("master-host", "127.0.0.1", "host of master node")
@@ -74,6 +84,10 @@ func init() {
 	serverCommand.PersistentFlags().String("http-host", "127.0.0.1", "port for RESTful APIs and Prometheus metrics export")
 	serverCommand.PersistentFlags().Bool("debug", false, "use debug log mode")
 	serverCommand.PersistentFlags().String("cache-path", "server_cache.data", "path of cache file")
+	serverCommand.PersistentFlags().Bool("ssl-mode", false, "enable TLS for connection to master node")
+	serverCommand.PersistentFlags().String("ssl-ca", "", "path of CA certificate to verify master node")
+	serverCommand.PersistentFlags().String("ssl-cert", "", "path of client certificate")
+	serverCommand.PersistentFlags().String("ssl-key", "", "path of client private key")
 }
 
 func main() {
@@ -15,11 +15,12 @@ package main
 
 import (
 	"fmt"
-	_ "net/http/pprof"
 
 	"github.com/spf13/cobra"
+	"github.com/zhenghaoz/gorse/base/diagnostics"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/cmd/version"
+	"github.com/zhenghaoz/gorse/protocol"
 	"github.com/zhenghaoz/gorse/worker"
 	"go.uber.org/zap"
 )
@@ -45,13 +46,22 @@ var workerCommand = &cobra.Command{
 		log.SetLogger(cmd.PersistentFlags(), debug)
 		// create worker
 		cachePath, _ := cmd.PersistentFlags().GetString("cache-path")
-		w := worker.NewWorker(masterHost, masterPort, httpHost, httpPort, workingJobs, cachePath, managedModel)
+		var tlsConfig *protocol.TLSConfig
+		if sslMode, _ := cmd.PersistentFlags().GetBool("ssl-mode"); sslMode {
+			tlsConfig = &protocol.TLSConfig{}
+			tlsConfig.SSLCA, _ = cmd.PersistentFlags().GetString("ssl-ca")
+			tlsConfig.SSLCert, _ = cmd.PersistentFlags().GetString("ssl-cert")
+			tlsConfig.SSLKey, _ = cmd.PersistentFlags().GetString("ssl-key")
+		}
+		w := worker.NewWorker(masterHost, masterPort, httpHost, httpPort, workingJobs, cachePath, managedModel, tlsConfig)
+		diagnostics.Serve(cmd.PersistentFlags(), func() string { return w.Config().Master.AdminAPIKey })
 		w.Serve()
 	},
 }
 
 func init() {
 	log.AddFlags(workerCommand.PersistentFlags())
+	diagnostics.AddFlags(workerCommand.PersistentFlags())
 	workerCommand.PersistentFlags().BoolP("version", "v", false, "gorse version")
 	workerCommand.PersistentFlags().String("master-host", "127.0.0.1", "host of master node")
 	workerCommand.PersistentFlags().Int("master-port", 8086, "port of master node")
@@ -61,6 +71,10 @@ func init() {
 	workerCommand.PersistentFlags().Bool("managed", false, "enable managed mode")
 	workerCommand.PersistentFlags().IntP("jobs", "j", 1, "number of working jobs.")
 	workerCommand.PersistentFlags().String("cache-path", "worker_cache.data", "path of cache file")
+	workerCommand.PersistentFlags().Bool("ssl-mode", false, "enable TLS for connection to master node")
+	workerCommand.PersistentFlags().String("ssl-ca", "", "path of CA certificate to verify master node")
+	workerCommand.PersistentFlags().String("ssl-cert", "", "path of client certificate")
+	workerCommand.PersistentFlags().String("ssl-key", "", "path of client private key")
 }
 
 func main() {
@@ -19,8 +19,11 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -30,10 +33,16 @@ import (
 	"github.com/go-playground/validator/v10"
 	en_translations "github.com/go-playground/validator/v10/translations/en"
 	"github.com/juju/errors"
+	"github.com/mitchellh/mapstructure"
+	"github.com/robfig/cron/v3"
 	"github.com/samber/lo"
 	"github.com/spf13/viper"
+	"github.com/zhenghaoz/gorse/base"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/storage"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/feature"
 	"go.opentelemetry.io/otel/exporters/jaeger"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
@@ -59,42 +68,175 @@ type Config struct {
 	Server    ServerConfig    `mapstructure:"server"`
 	Recommend RecommendConfig `mapstructure:"recommend"`
 	Tracing   TracingConfig   `mapstructure:"tracing"`
+	Audit     AuditConfig     `mapstructure:"audit"`
+	Alert     AlertConfig     `mapstructure:"alert"`
 }
 
 // DatabaseConfig is the configuration for the database.
 type DatabaseConfig struct {
-	DataStore        string `mapstructure:"data_store" validate:"required,data_store"`   // database for data store
-	CacheStore       string `mapstructure:"cache_store" validate:"required,cache_store"` // database for cache store
-	TablePrefix      string `mapstructure:"table_prefix"`
-	DataTablePrefix  string `mapstructure:"data_table_prefix"`
-	CacheTablePrefix string `mapstructure:"cache_table_prefix"`
+	DataStore                  string        `mapstructure:"data_store" validate:"required,data_store"`          // database for data store
+	DataStoreReplica           string        `mapstructure:"data_store_replica" validate:"omitempty,data_store"` // read replica of the data store for scans
+	CacheStore                 string        `mapstructure:"cache_store" validate:"required,cache_store"`        // database for cache store
+	SearchStore                string        `mapstructure:"search_store" validate:"omitempty,search_store"`     // database to search items
+	FeatureStore               string        `mapstructure:"feature_store" validate:"omitempty,feature_store"`   // external feature store of click models
+	TablePrefix                string        `mapstructure:"table_prefix"`
+	DataTablePrefix            string        `mapstructure:"data_table_prefix"`
+	CacheTablePrefix           string        `mapstructure:"cache_table_prefix"`
+	CacheKeyVersion            int           `mapstructure:"cache_key_version" validate:"gte=0"`
+	CacheEncoding              string        `mapstructure:"cache_encoding" validate:"oneof=json msgpack"`
+	CacheCompressThreshold     int           `mapstructure:"cache_compress_threshold" validate:"gte=0"`
+	DataStoreTarget            string        `mapstructure:"data_store_target" validate:"omitempty,data_store,nefield=DataStore"`
+	DataStoreMaxOpenConns      int           `mapstructure:"data_store_max_open_conns" validate:"gte=0"`    // max open connections to the data store, 0 means unlimited
+	DataStoreMaxIdleConns      int           `mapstructure:"data_store_max_idle_conns" validate:"gte=0"`    // max idle connections to the data store
+	DataStoreConnMaxLifetime   time.Duration `mapstructure:"data_store_conn_max_lifetime" validate:"gte=0"` // max lifetime of connections to the data store, 0 means unlimited
+	DataStoreMaxConcurrency    int           `mapstructure:"data_store_max_concurrency" validate:"gte=0"`   // max concurrent calls to the data store, 0 means unlimited
+	DataStoreWaitTimeout       time.Duration `mapstructure:"data_store_wait_timeout" validate:"gt=0"`       // max time to wait for a call to the data store
+	DataStoreSlowThreshold     time.Duration `mapstructure:"data_store_slow_threshold" validate:"gte=0"`    // statements to the data store slower than the threshold are logged, 0 means disabled
+	DataStoreExplainRatio      float64       `mapstructure:"data_store_explain_ratio" validate:"lte=1"`     // ratio of logged slow queries with query plans
+	FeedbackPartitionInterval  time.Duration `mapstructure:"feedback_partition_interval" validate:"gte=0"`  // time range of each partition of feedback, 0 means no partitions
+	FeedbackPartitionRetention time.Duration `mapstructure:"feedback_partition_retention" validate:"gte=0"` // partitions of feedback older than retention are dropped, 0 means forever
+	FeatureStoreTimeout        time.Duration `mapstructure:"feature_store_timeout" validate:"gt=0"`         // timeout of each request to the feature store
+	FeatureStoreCacheCapacity  int           `mapstructure:"feature_store_cache_capacity" validate:"gte=0"` // max number of cached users and items respectively, 0 means no cache
+	FeatureStoreCacheTTL       time.Duration `mapstructure:"feature_store_cache_ttl" validate:"gt=0"`       // time-to-live of cached features
+}
+
+// CachePrefix returns the naming prefix in the cache store, which includes the version of cache keys.
+func (config *DatabaseConfig) CachePrefix() string {
+	return storage.VersionedTablePrefix(config.CacheTablePrefix, config.CacheKeyVersion)
+}
+
+// DataStoreLimits returns limits of connections and concurrent calls to the data store.
+func (config *DatabaseConfig) DataStoreLimits() data.Limits {
+	return data.Limits{
+		MaxOpenConns:    config.DataStoreMaxOpenConns,
+		MaxIdleConns:    config.DataStoreMaxIdleConns,
+		ConnMaxLifetime: config.DataStoreConnMaxLifetime,
+		MaxConcurrency:  config.DataStoreMaxConcurrency,
+		WaitTimeout:     config.DataStoreWaitTimeout,
+	}
+}
+
+// DataStoreQueryLog returns options of logging slow statements to the data store.
+func (config *DatabaseConfig) DataStoreQueryLog() data.QueryLogOptions {
+	return data.QueryLogOptions{
+		SlowThreshold: config.DataStoreSlowThreshold,
+		ExplainRatio:  config.DataStoreExplainRatio,
+	}
+}
+
+// CacheStoreOptions returns options of the connection to the cache store.
+func (config *DatabaseConfig) CacheStoreOptions() cache.Options {
+	return cache.Options{
+		Encoding:          config.CacheEncoding,
+		CompressThreshold: config.CacheCompressThreshold,
+	}
+}
+
+func (config *DatabaseConfig) FeatureStoreOptions() feature.Options {
+	return feature.Options{
+		Timeout:   config.FeatureStoreTimeout,
+		CacheSize: config.FeatureStoreCacheCapacity,
+		CacheTTL:  config.FeatureStoreCacheTTL,
+	}
 }
 
 // MasterConfig is the configuration for the master.
 type MasterConfig struct {
-	Port                int           `mapstructure:"port" validate:"gte=0"`        // master port
-	Host                string        `mapstructure:"host"`                         // master host
-	HttpPort            int           `mapstructure:"http_port" validate:"gte=0"`   // HTTP port
-	HttpHost            string        `mapstructure:"http_host"`                    // HTTP host
-	HttpCorsDomains     []string      `mapstructure:"http_cors_domains"`            // add allowed cors domains
-	HttpCorsMethods     []string      `mapstructure:"http_cors_methods"`            // add allowed cors methods
-	NumJobs             int           `mapstructure:"n_jobs" validate:"gt=0"`       // number of working jobs
-	MetaTimeout         time.Duration `mapstructure:"meta_timeout" validate:"gt=0"` // cluster meta timeout (second)
-	DashboardUserName   string        `mapstructure:"dashboard_user_name"`          // dashboard user name
-	DashboardPassword   string        `mapstructure:"dashboard_password"`           // dashboard password
-	DashboardAuthServer string        `mapstructure:"dashboard_auth_server"`        // dashboard auth server
-	DashboardRedacted   bool          `mapstructure:"dashboard_redacted"`
-	AdminAPIKey         string        `mapstructure:"admin_api_key"`
+	Port                int              `mapstructure:"port" validate:"gte=0"`        // master port
+	Host                string           `mapstructure:"host"`                         // master host
+	HttpPort            int              `mapstructure:"http_port" validate:"gte=0"`   // HTTP port
+	HttpHost            string           `mapstructure:"http_host"`                    // HTTP host
+	HttpCorsDomains     []string         `mapstructure:"http_cors_domains"`            // add allowed cors domains
+	HttpCorsMethods     []string         `mapstructure:"http_cors_methods"`            // add allowed cors methods
+	NumJobs             int              `mapstructure:"n_jobs" validate:"gt=0"`       // number of working jobs
+	MetaTimeout         time.Duration    `mapstructure:"meta_timeout" validate:"gt=0"` // cluster meta timeout (second)
+	DashboardUserName   string           `mapstructure:"dashboard_user_name"`          // dashboard user name
+	DashboardPassword   string           `mapstructure:"dashboard_password"`           // dashboard password
+	DashboardAuthServer string           `mapstructure:"dashboard_auth_server"`        // dashboard auth server
+	DashboardRedacted   bool             `mapstructure:"dashboard_redacted"`
+	OIDCIssuer          string           `mapstructure:"dashboard_oidc_issuer"`        // issuer of the OpenID provider
+	OIDCClientID        string           `mapstructure:"dashboard_oidc_client_id"`     // client ID at the OpenID provider
+	OIDCClientSecret    string           `mapstructure:"dashboard_oidc_client_secret"` // client secret at the OpenID provider
+	OIDCRedirectURL     string           `mapstructure:"dashboard_oidc_redirect_url"`  // redirect URL after OpenID Connect login
+	OIDCRole            string           `mapstructure:"dashboard_oidc_role" validate:"oneof=viewer operator admin"`
+	AdminAPIKey         string           `mapstructure:"admin_api_key"`
+	SSLMode             bool             `mapstructure:"ssl_mode"`                                // enable TLS for gRPC connections
+	SSLCA               string           `mapstructure:"ssl_ca"`                                  // CA certificate to verify client certificates
+	SSLCert             string           `mapstructure:"ssl_cert"`                                // certificate of the master node
+	SSLKey              string           `mapstructure:"ssl_key"`                                 // private key of the master node
+	FitCron             string           `mapstructure:"fit_cron" validate:"omitempty,cron"`      // cron expression to fit models
+	NeighborCron        string           `mapstructure:"neighbor_cron" validate:"omitempty,cron"` // cron expression to search neighbors
+	SearchCron          string           `mapstructure:"search_cron" validate:"omitempty,cron"`   // cron expression to search models
+	BlackoutWindows     []BlackoutWindow `mapstructure:"blackout_windows" validate:"dive"`        // time windows not to start jobs
+	SpillDir            string           `mapstructure:"spill_dir"`                               // directory of temporary files to load datasets
+	LeaderElection      string           `mapstructure:"leader_election" validate:"oneof='' redis kubernetes"`
+	LeaderLeaseName     string           `mapstructure:"leader_lease_name" validate:"required"`
+	LeaderLeaseDuration time.Duration    `mapstructure:"leader_lease_duration" validate:"gt=0"` // lease of the leader to be renewed
+	TaskLogSize         int              `mapstructure:"task_log_size" validate:"gt=0"`         // number of retained task events
+	StoreMetricsPeriod  time.Duration    `mapstructure:"store_metrics_period" validate:"gte=0"` // period to export sizes of stores
+	StoreMetricsBatch   int              `mapstructure:"store_metrics_batch" validate:"gt=0"`   // batch size to count rows in the data store
+	CacheWarmupPeriod   time.Duration    `mapstructure:"cache_warmup_period" validate:"gte=0"`  // period to check whether the cache store is empty
+	ConsistencyPeriod   time.Duration    `mapstructure:"consistency_period" validate:"gte=0"`   // period to check consistency between stores
+	ConsistencyRepair   bool             `mapstructure:"consistency_repair"`                    // remove inconsistent entries from the cache store
+}
+
+// BlackoutWindow is a daily time window in which offline jobs are not started. The window crosses midnight if the end
+// is before the start.
+type BlackoutWindow struct {
+	Start string `mapstructure:"start" validate:"datetime=15:04"`
+	End   string `mapstructure:"end" validate:"datetime=15:04"`
+}
+
+// Contains returns true if the clock of a time is in the window.
+func (w BlackoutWindow) Contains(t time.Time) bool {
+	clock := t.Format("15:04")
+	if w.Start <= w.End {
+		return w.Start <= clock && clock < w.End
+	}
+	return w.Start <= clock || clock < w.End
+}
+
+// NextEnd returns the first end of the window after a time.
+func (w BlackoutWindow) NextEnd(t time.Time) time.Time {
+	end, err := time.ParseInLocation("15:04", w.End, t.Location())
+	if err != nil {
+		return t
+	}
+	next := time.Date(t.Year(), t.Month(), t.Day(), end.Hour(), end.Minute(), 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
 }
 
 // ServerConfig is the configuration for the server.
 type ServerConfig struct {
-	APIKey         string        `mapstructure:"api_key"`                      // default number of returned items
-	DefaultN       int           `mapstructure:"default_n" validate:"gt=0"`    // secret key for RESTful APIs (SSL required)
-	ClockError     time.Duration `mapstructure:"clock_error" validate:"gte=0"` // clock error in the cluster in seconds
-	AutoInsertUser bool          `mapstructure:"auto_insert_user"`             // insert new users while inserting feedback
-	AutoInsertItem bool          `mapstructure:"auto_insert_item"`             // insert new items while inserting feedback
-	CacheExpire    time.Duration `mapstructure:"cache_expire" validate:"gt=0"` // server-side cache expire time
+	APIKey              string                  `mapstructure:"api_key"`                               // default number of returned items
+	DefaultN            int                     `mapstructure:"default_n" validate:"gt=0"`             // secret key for RESTful APIs (SSL required)
+	ClockError          time.Duration           `mapstructure:"clock_error" validate:"gte=0"`          // clock error in the cluster in seconds
+	AutoInsertUser      bool                    `mapstructure:"auto_insert_user"`                      // insert new users while inserting feedback
+	AutoInsertItem      bool                    `mapstructure:"auto_insert_item"`                      // insert new items while inserting feedback
+	CacheExpire         time.Duration           `mapstructure:"cache_expire" validate:"gt=0"`          // server-side cache expire time
+	APIKeys             map[string]APIKeyConfig `mapstructure:"api_keys" validate:"dive"`              // API keys of consumers with quotas
+	AsyncFeedback       bool                    `mapstructure:"async_feedback"`                        // flush feedback to the data store asynchronously
+	FeedbackWALPath     string                  `mapstructure:"feedback_wal_path"`                     // path of the write-ahead log of feedback
+	FeedbackFlushPeriod time.Duration           `mapstructure:"feedback_flush_period" validate:"gt=0"` // period to flush feedback to the data store
+	FeedbackFlushSize   int                     `mapstructure:"feedback_flush_size" validate:"gt=0"`   // number of feedback flushed in a batch
+	LocalCacheSize      int                     `mapstructure:"local_cache_size" validate:"gte=0"`     // number of sorted lists cached in the server, 0 means disabled
+	LocalCacheTTL       time.Duration           `mapstructure:"local_cache_ttl" validate:"gt=0"`       // time-to-live of sorted lists cached in the server
+	ShutdownTimeout     time.Duration           `mapstructure:"shutdown_timeout" validate:"gt=0"`      // deadline to finish in-flight requests on shutdown
+	BreakerThreshold    int                     `mapstructure:"breaker_threshold" validate:"gte=0"`    // consecutive data store failures to open the circuit breaker, 0 means disabled
+	BreakerCooldown     time.Duration           `mapstructure:"breaker_cooldown" validate:"gt=0"`      // time before the data store is accessed again once the circuit breaker opens
+	FeedbackDedupWindow time.Duration           `mapstructure:"feedback_dedup_window"`                 // window to collapse repeated feedback, 0 means disabled
+	SessionTTL          time.Duration           `mapstructure:"session_ttl" validate:"gt=0"`           // time-to-live of states of anonymous sessions
+	ResultCacheTTL      time.Duration           `mapstructure:"result_cache_ttl" validate:"gte=0"`     // time-to-live of recommendation results cached in the server, 0 means disabled
+}
+
+// APIKeyConfig is the configuration of an API key for a consumer.
+type APIKeyConfig struct {
+	Key           string `mapstructure:"key" validate:"required"`
+	DailyRequests int    `mapstructure:"daily_requests" validate:"gte=0"` // daily quota of requests, 0 means unlimited
+	DailyWrites   int    `mapstructure:"daily_writes" validate:"gte=0"`   // daily quota of written rows, 0 means unlimited
 }
 
 // RecommendConfig is the configuration of recommendation setup.
@@ -105,6 +247,7 @@ type RecommendConfig struct {
 	Popular       PopularConfig       `mapstructure:"popular"`
 	UserNeighbors NeighborsConfig     `mapstructure:"user_neighbors"`
 	ItemNeighbors NeighborsConfig     `mapstructure:"item_neighbors"`
+	AlsoLiked     AlsoLikedConfig     `mapstructure:"also_liked"`
 	Collaborative CollaborativeConfig `mapstructure:"collaborative"`
 	Replacement   ReplacementConfig   `mapstructure:"replacement"`
 	Offline       OfflineConfig       `mapstructure:"offline"`
@@ -112,16 +255,52 @@ type RecommendConfig struct {
 }
 
 type DataSourceConfig struct {
-	PositiveFeedbackTypes []string `mapstructure:"positive_feedback_types"`                // positive feedback type
-	ReadFeedbackTypes     []string `mapstructure:"read_feedback_types"`                    // feedback type for read event
-	PositiveFeedbackTTL   uint     `mapstructure:"positive_feedback_ttl" validate:"gte=0"` // time-to-live of positive feedbacks
-	ItemTTL               uint     `mapstructure:"item_ttl" validate:"gte=0"`              // item-to-live of items
+	PositiveFeedbackTypes  []string                 `mapstructure:"positive_feedback_types"`                // positive feedback type
+	ReadFeedbackTypes      []string                 `mapstructure:"read_feedback_types"`                    // feedback type for read event
+	PositiveFeedbackTTL    uint                     `mapstructure:"positive_feedback_ttl" validate:"gte=0"` // time-to-live of positive feedbacks
+	ItemTTL                uint                     `mapstructure:"item_ttl" validate:"gte=0"`              // item-to-live of items
+	NegativeFeedbackTTL    map[string]time.Duration `mapstructure:"negative_feedback_ttl"`                  // suppression windows of negative feedbacks
+	ImpressionFeedbackType string                   `mapstructure:"impression_feedback_type"`               // feedback type for impressions
+	PositiveThresholds     []FeedbackThreshold      `mapstructure:"positive_feedback_thresholds" validate:"dive"`
+	LabelCategories        []LabelCategory          `mapstructure:"label_categories" validate:"dive"`
+}
+
+// FeedbackThreshold regards events of a feedback type as positive feedback if a user has at least a count of events on
+// an item within a time window before now. A window of 0 means all events are counted.
+type FeedbackThreshold struct {
+	FeedbackType string        `mapstructure:"feedback_type" validate:"required"`
+	Count        int           `mapstructure:"count" validate:"gt=0"`
+	Window       time.Duration `mapstructure:"window" validate:"gte=0"`
+}
+
+// LabelCategory derives a category from labels of an item matching the pattern. The category refers to submatches of
+// the pattern by $1, $2, ..., e.g., the pattern "^brand:(.+)$" and the category "brand-$1" derive "brand-apple" from
+// the label "brand:apple".
+type LabelCategory struct {
+	Pattern  string `mapstructure:"pattern" validate:"required,regexp"`
+	Category string `mapstructure:"category" validate:"required"`
+}
+
+// Reached returns true if there are enough events within the window.
+func (threshold FeedbackThreshold) Reached(events []time.Time, now time.Time) bool {
+	count := 0
+	for _, event := range events {
+		if threshold.Window == 0 || event.After(now.Add(-threshold.Window)) {
+			count++
+		}
+	}
+	return count >= threshold.Count
 }
 
 type PopularConfig struct {
 	PopularWindow time.Duration `mapstructure:"popular_window" validate:"gte=0"`
 }
 
+type AlsoLikedConfig struct {
+	FeedbackTypes []string      `mapstructure:"feedback_types"`               // feedback types of co-occurrence, positive feedback types if empty
+	TimeWindow    time.Duration `mapstructure:"time_window" validate:"gte=0"` // time window of feedback, 0 means all feedback
+}
+
 type NeighborsConfig struct {
 	NeighborType  string  `mapstructure:"neighbor_type" validate:"oneof=auto similar related ''"`
 	EnableIndex   bool    `mapstructure:"enable_index"`
@@ -138,6 +317,11 @@ type CollaborativeConfig struct {
 	EnableIndex           bool          `mapstructure:"enable_index"`
 	IndexRecall           float32       `mapstructure:"index_recall" validate:"gt=0"`
 	IndexFitEpoch         int           `mapstructure:"index_fit_epoch" validate:"gt=0"`
+	Accelerator           string        `mapstructure:"accelerator" validate:"oneof=cpu cuda"`        // accelerator to train models
+	WarmStartEpoch        int           `mapstructure:"warm_start_epoch" validate:"gte=0"`            // epochs to fit models from previous models
+	EnableShadow          bool          `mapstructure:"enable_shadow"`                                // evaluate models on recent feedback before promotion
+	ShadowWindow          time.Duration `mapstructure:"shadow_window" validate:"gt=0"`                // time window of recent feedback to replay
+	ShadowMaxRegression   float32       `mapstructure:"shadow_max_regression" validate:"gte=0,lte=1"` // maximal relative regression to promote models
 }
 
 type ReplacementConfig struct {
@@ -149,19 +333,54 @@ type ReplacementConfig struct {
 type OfflineConfig struct {
 	CheckRecommendPeriod         time.Duration      `mapstructure:"check_recommend_period" validate:"gt=0"`
 	RefreshRecommendPeriod       time.Duration      `mapstructure:"refresh_recommend_period" validate:"gt=0"`
+	RefreshTiers                 []RefreshTier      `mapstructure:"refresh_tiers" validate:"dive"` // refresh periods of recently active users
 	ExploreRecommend             map[string]float64 `mapstructure:"explore_recommend"`
 	EnableLatestRecommend        bool               `mapstructure:"enable_latest_recommend"`
 	EnablePopularRecommend       bool               `mapstructure:"enable_popular_recommend"`
 	EnableUserBasedRecommend     bool               `mapstructure:"enable_user_based_recommend"`
 	EnableItemBasedRecommend     bool               `mapstructure:"enable_item_based_recommend"`
 	EnableColRecommend           bool               `mapstructure:"enable_collaborative_recommend"`
+	CustomRecommenders           []string           `mapstructure:"custom_recommenders"` // names of registered custom recommenders
 	EnableClickThroughPrediction bool               `mapstructure:"enable_click_through_prediction"`
+	Objectives                   []ObjectiveConfig  `mapstructure:"objectives" validate:"dive"`                  // extra objectives combined with click-through rates
+	EnableTimeContext            bool               `mapstructure:"enable_time_context"`                         // use hours and weekdays as context features of the click model
+	PopularityPenalty            float64            `mapstructure:"popularity_penalty" validate:"gte=0"`         // exponent of item frequency to divide collaborative filtering scores
+	ColdStartItemAge             time.Duration      `mapstructure:"cold_start_item_age" validate:"gt=0"`         // max age of cold-start items
+	ColdStartMaxImpressions      int                `mapstructure:"cold_start_max_impressions" validate:"gte=0"` // max impressions of cold-start items
+	ShufflePeriod                time.Duration      `mapstructure:"shuffle_period" validate:"gte=0"`             // period to rotate shuffling and exploration of each user, 0 means reshuffling in every run
+	ClickCalibration             string             `mapstructure:"click_calibration" validate:"oneof=none platt isotonic"`
+	NormalizeScores              bool               `mapstructure:"normalize_scores"`
 	exploreRecommendLock         sync.RWMutex
 }
 
+// RefreshTier is the refresh period of offline recommendation for users active within a time window.
+type RefreshTier struct {
+	ActiveWithin  time.Duration `mapstructure:"active_within" validate:"gt=0"`
+	RefreshPeriod time.Duration `mapstructure:"refresh_period" validate:"gt=0"`
+}
+
+// ObjectiveConfig is an extra objective in click-through rate prediction. A click model is trained on feedback of the
+// objective types, and its predicted probability is weighted in ranking.
+type ObjectiveConfig struct {
+	Name          string   `mapstructure:"name" validate:"required"`
+	FeedbackTypes []string `mapstructure:"feedback_types" validate:"min=1"`
+	Weight        float64  `mapstructure:"weight" validate:"gte=0"`
+}
+
 type OnlineConfig struct {
-	FallbackRecommend            []string `mapstructure:"fallback_recommend"`
-	NumFeedbackFallbackItemBased int      `mapstructure:"num_feedback_fallback_item_based" validate:"gt=0"`
+	FallbackRecommend            []string            `mapstructure:"fallback_recommend"`
+	CategoryFallbackRecommend    map[string][]string `mapstructure:"category_fallback_recommend"` // fallback recommenders of categories
+	NumFeedbackFallbackItemBased int                 `mapstructure:"num_feedback_fallback_item_based" validate:"gt=0"`
+	ContextBlendWeight           float64             `mapstructure:"context_blend_weight" validate:"gte=0,lte=1"`
+	DormantUserThreshold         time.Duration       `mapstructure:"dormant_user_threshold" validate:"gt=0"`
+	AttributionWindow            time.Duration       `mapstructure:"attribution_window" validate:"gt=0"`
+	BidderURL                    string              `mapstructure:"bidder_url"`                            // URL of the external bidder, empty means disabled
+	BidderTimeout                time.Duration       `mapstructure:"bidder_timeout" validate:"gt=0"`        // timeout of requests to the external bidder
+	FrequencyCap                 int                 `mapstructure:"frequency_cap" validate:"gte=0"`        // max times an item is returned to a user in the window, 0 means unlimited
+	FrequencyCapWindow           time.Duration       `mapstructure:"frequency_cap_window" validate:"gt=0"`  // time window of frequency capping
+	GeoDecayDistance             float64             `mapstructure:"geo_decay_distance" validate:"gte=0"`   // distance (km) halving geo boosts, 0 means disabled
+	RerankScript                 string              `mapstructure:"rerank_script"`                         // path of the Lua script re-ranking recommendation, empty means disabled
+	RerankScriptTimeout          time.Duration       `mapstructure:"rerank_script_timeout" validate:"gt=0"` // timeout of the re-ranking script
 }
 
 type TracingConfig struct {
@@ -172,24 +391,83 @@ type TracingConfig struct {
 	Ratio             float64 `mapstructure:"ratio"`
 }
 
+// AuditConfig is the configuration of audit logs of write operations.
+type AuditConfig struct {
+	EnableAudit bool   `mapstructure:"enable_audit"`
+	Sink        string `mapstructure:"sink" validate:"oneof=file data_store"` // sink of audit logs
+	Path        string `mapstructure:"path"`                                  // path of audit log file
+}
+
+// AlertConfig is the configuration of alerts when measurements fall outside thresholds.
+type AlertConfig struct {
+	EnableAlert  bool          `mapstructure:"enable_alert"`
+	Rules        []AlertRule   `mapstructure:"rules" validate:"dive"`     // thresholds of measurements
+	Cooldown     time.Duration `mapstructure:"cooldown" validate:"gte=0"` // minimal interval between alerts of a measurement
+	WebhookURL   string        `mapstructure:"webhook_url"`               // URL to post alerts in JSON
+	SlackURL     string        `mapstructure:"slack_url"`                 // URL of Slack incoming webhook
+	SMTPHost     string        `mapstructure:"smtp_host"`                 // SMTP server host to send emails
+	SMTPPort     int           `mapstructure:"smtp_port" validate:"gte=0"`
+	SMTPUser     string        `mapstructure:"smtp_user"`
+	SMTPPassword string        `mapstructure:"smtp_password"`
+	EmailFrom    string        `mapstructure:"email_from"`
+	EmailTo      []string      `mapstructure:"email_to"`
+}
+
+// AlertRule raises alerts if a measurement is less than the minimum or greater than the maximum. Bounds are optional.
+type AlertRule struct {
+	Measurement string   `mapstructure:"measurement" validate:"required"` // name of measurement
+	Min         *float32 `mapstructure:"min"`
+	Max         *float32 `mapstructure:"max"`
+}
+
+// Check returns true if the value is out of thresholds of the rule.
+func (r AlertRule) Check(value float32) bool {
+	return (r.Min != nil && value < *r.Min) || (r.Max != nil && value > *r.Max)
+}
+
 func GetDefaultConfig() *Config {
 	return &Config{
+		Database: DatabaseConfig{
+			CacheEncoding:             "json",
+			DataStoreMaxIdleConns:     2,
+			DataStoreWaitTimeout:      10 * time.Second,
+			DataStoreSlowThreshold:    time.Second,
+			FeatureStoreTimeout:       time.Second,
+			FeatureStoreCacheCapacity: 100000,
+			FeatureStoreCacheTTL:      10 * time.Minute,
+		},
 		Master: MasterConfig{
-			Port:            8086,
-			Host:            "0.0.0.0",
-			HttpPort:        8088,
-			HttpHost:        "0.0.0.0",
-			HttpCorsDomains: []string{".*"},
-			HttpCorsMethods: []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
-			NumJobs:         1,
-			MetaTimeout:     10 * time.Second,
+			Port:                8086,
+			Host:                "0.0.0.0",
+			HttpPort:            8088,
+			HttpHost:            "0.0.0.0",
+			HttpCorsDomains:     []string{".*"},
+			HttpCorsMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
+			NumJobs:             1,
+			MetaTimeout:         10 * time.Second,
+			LeaderLeaseName:     "gorse-master",
+			LeaderLeaseDuration: 15 * time.Second,
+			TaskLogSize:         1000,
+			StoreMetricsPeriod:  30 * time.Minute,
+			StoreMetricsBatch:   1000,
+			CacheWarmupPeriod:   time.Minute,
+			ConsistencyPeriod:   24 * time.Hour,
+			OIDCRole:            "viewer",
 		},
 		Server: ServerConfig{
-			DefaultN:       10,
-			ClockError:     5 * time.Second,
-			AutoInsertUser: true,
-			AutoInsertItem: true,
-			CacheExpire:    10 * time.Second,
+			DefaultN:            10,
+			ClockError:          5 * time.Second,
+			AutoInsertUser:      true,
+			AutoInsertItem:      true,
+			CacheExpire:         10 * time.Second,
+			FeedbackWALPath:     "feedback.wal",
+			FeedbackFlushPeriod: time.Second,
+			FeedbackFlushSize:   1000,
+			LocalCacheTTL:       10 * time.Second,
+			ShutdownTimeout:     30 * time.Second,
+			BreakerThreshold:    5,
+			BreakerCooldown:     30 * time.Second,
+			SessionTTL:          30 * time.Minute,
 		},
 		Recommend: RecommendConfig{
 			CacheSize:   100,
@@ -209,14 +487,20 @@ func GetDefaultConfig() *Config {
 				IndexRecall:   0.8,
 				IndexFitEpoch: 3,
 			},
+			AlsoLiked: AlsoLikedConfig{
+				TimeWindow: 30 * 24 * time.Hour,
+			},
 			Collaborative: CollaborativeConfig{
-				ModelFitPeriod:    60 * time.Minute,
-				ModelSearchPeriod: 180 * time.Minute,
-				ModelSearchEpoch:  100,
-				ModelSearchTrials: 10,
-				EnableIndex:       true,
-				IndexRecall:       0.9,
-				IndexFitEpoch:     3,
+				ModelFitPeriod:      60 * time.Minute,
+				ModelSearchPeriod:   180 * time.Minute,
+				ModelSearchEpoch:    100,
+				ModelSearchTrials:   10,
+				EnableIndex:         true,
+				IndexRecall:         0.9,
+				IndexFitEpoch:       3,
+				Accelerator:         "cpu",
+				ShadowWindow:        24 * time.Hour,
+				ShadowMaxRegression: 0.05,
 			},
 			Replacement: ReplacementConfig{
 				EnableReplacement:        false,
@@ -232,16 +516,37 @@ func GetDefaultConfig() *Config {
 				EnableItemBasedRecommend:     false,
 				EnableColRecommend:           true,
 				EnableClickThroughPrediction: false,
+				EnableTimeContext:            false,
+				ColdStartItemAge:             7 * 24 * time.Hour,
+				ColdStartMaxImpressions:      100,
+				ShufflePeriod:                24 * time.Hour,
+				ClickCalibration:             "none",
+				NormalizeScores:              false,
 			},
 			Online: OnlineConfig{
 				FallbackRecommend:            []string{"latest"},
 				NumFeedbackFallbackItemBased: 10,
+				ContextBlendWeight:           0.5,
+				DormantUserThreshold:         30 * 24 * time.Hour,
+				AttributionWindow:            24 * time.Hour,
+				BidderTimeout:                100 * time.Millisecond,
+				FrequencyCapWindow:           24 * time.Hour,
+				GeoDecayDistance:             10,
+				RerankScriptTimeout:          50 * time.Millisecond,
 			},
 		},
 		Tracing: TracingConfig{
 			Exporter: "jaeger",
 			Sampler:  "always",
 		},
+		Audit: AuditConfig{
+			Sink: "file",
+			Path: "audit.log",
+		},
+		Alert: AlertConfig{
+			Cooldown: time.Hour,
+			SMTPPort: 25,
+		},
 	}
 }
 
@@ -361,16 +666,70 @@ func (config *Config) OfflineRecommendDigest(option ...DigestOption) string {
 			builder.WriteString(fmt.Sprintf("-%v-%v",
 				config.Recommend.Collaborative.IndexRecall, config.Recommend.Collaborative.IndexFitEpoch))
 		}
+		if config.Recommend.Offline.PopularityPenalty > 0 {
+			builder.WriteString(fmt.Sprintf("-%v", config.Recommend.Offline.PopularityPenalty))
+		}
+	}
+	if len(config.Recommend.Offline.CustomRecommenders) > 0 {
+		builder.WriteString(fmt.Sprintf("-%v", config.Recommend.Offline.CustomRecommenders))
+	}
+	if options.enableRanking && len(config.Recommend.Offline.Objectives) > 0 {
+		builder.WriteString(fmt.Sprintf("-%v", config.Recommend.Offline.Objectives))
 	}
 	if config.Recommend.Replacement.EnableReplacement {
 		builder.WriteString(fmt.Sprintf("-%v-%v",
 			config.Recommend.Replacement.PositiveReplacementDecay, config.Recommend.Replacement.ReadReplacementDecay))
 	}
+	if len(config.Recommend.DataSource.NegativeFeedbackTTL) > 0 {
+		builder.WriteString(fmt.Sprintf("-%v", config.Recommend.DataSource.NegativeFeedbackTTL))
+	}
 
 	digest := md5.Sum([]byte(builder.String()))
 	return hex.EncodeToString(digest[:])
 }
 
+// Update returns a copy of the config whose parameters are replaced by those of another config, and keys of changed
+// parameters. The config itself is kept unchanged since it is read by other goroutines, the copy should be swapped in
+// by Settings.SetConfig.
+func (config *Config) Update(other *Config) (*Config, []string) {
+	next := config.clone()
+	return next, updateFields(reflect.ValueOf(next).Elem(), reflect.ValueOf(other).Elem(), "")
+}
+
+// UpdateRecommend returns a copy of the config whose recommendation parameters are replaced by those of another
+// config, and keys of changed parameters. Other sections are kept since they could not be changed without restarts.
+func (config *Config) UpdateRecommend(other *Config) (*Config, []string) {
+	next := config.clone()
+	return next, updateFields(reflect.ValueOf(&next.Recommend).Elem(), reflect.ValueOf(&other.Recommend).Elem(), "recommend.")
+}
+
+// clone returns a shallow copy of the config. Locks are not copied.
+func (config *Config) clone() *Config {
+	next := new(Config)
+	updateFields(reflect.ValueOf(next).Elem(), reflect.ValueOf(config).Elem(), "")
+	return next
+}
+
+// updateFields copies exported fields from src to dst. Structs are updated field by field so that locks in them are
+// not copied.
+func updateFields(dst, src reflect.Value, prefix string) []string {
+	var changed []string
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct {
+			changed = append(changed, updateFields(dst.Field(i), src.Field(i), key+".")...)
+		} else if !reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+			dst.Field(i).Set(src.Field(i))
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
 func (config *OfflineConfig) Lock() {
 	config.exploreRecommendLock.Lock()
 }
@@ -389,6 +748,88 @@ func (config *OfflineConfig) GetExploreRecommend(key string) (value float64, exi
 	return
 }
 
+// GetRefreshRecommendPeriod returns the refresh period of offline recommendation for a user inactive for a duration.
+// The first tier whose window covers the duration is used, and dormant users out of all tiers are refreshed by
+// refresh_recommend_period. Tiers are sorted by windows during validation.
+func (config *OfflineConfig) GetRefreshRecommendPeriod(inactive time.Duration) time.Duration {
+	for _, tier := range config.RefreshTiers {
+		if inactive <= tier.ActiveWithin {
+			return tier.RefreshPeriod
+		}
+	}
+	return config.RefreshRecommendPeriod
+}
+
+// GetFallbackRecommend returns fallback recommenders of a category. The global fallback recommenders are used if the
+// category is not configured.
+func (config *OnlineConfig) GetFallbackRecommend(category string) []string {
+	if recommenders, exist := config.CategoryFallbackRecommend[category]; exist {
+		return recommenders
+	}
+	return config.FallbackRecommend
+}
+
+// PositiveThreshold returns the count threshold of a feedback type. The second return value is false if events of the
+// feedback type are not counted.
+func (config *DataSourceConfig) PositiveThreshold(feedbackType string) (FeedbackThreshold, bool) {
+	return lo.Find(config.PositiveThresholds, func(threshold FeedbackThreshold) bool {
+		return threshold.FeedbackType == feedbackType
+	})
+}
+
+// labelPatterns caches compiled patterns of label categories.
+var labelPatterns sync.Map
+
+// DeriveCategories returns categories of an item appended by categories derived from its labels. Duplicate categories
+// and derived categories that are invalid ids are removed, and categories are kept in order.
+func (config *DataSourceConfig) DeriveCategories(categories, labels []string) []string {
+	if len(config.LabelCategories) == 0 || len(labels) == 0 {
+		return categories
+	}
+	derived := make([]string, 0, len(categories))
+	exist := make(map[string]struct{}, len(categories))
+	add := func(category string) {
+		if _, ok := exist[category]; !ok && base.ValidateId(category) == nil {
+			exist[category] = struct{}{}
+			derived = append(derived, category)
+		}
+	}
+	for _, category := range categories {
+		add(category)
+	}
+	for _, rule := range config.LabelCategories {
+		var pattern *regexp.Regexp
+		if value, ok := labelPatterns.Load(rule.Pattern); ok {
+			pattern = value.(*regexp.Regexp)
+		} else if compiled, err := regexp.Compile(rule.Pattern); err != nil {
+			continue // invalid patterns are rejected by validation
+		} else {
+			labelPatterns.Store(rule.Pattern, compiled)
+			pattern = compiled
+		}
+		for _, label := range labels {
+			if match := pattern.FindStringSubmatchIndex(label); match != nil {
+				add(string(pattern.ExpandString(nil, rule.Category, label, match)))
+			}
+		}
+	}
+	return derived
+}
+
+// SuppressUntil returns the unix timestamp until which the item in a negative feedback is excluded from
+// recommendation. The second return value is false if the feedback is not negative. Items suppressed forever
+// are suppressed until math.MaxFloat64.
+func (config *DataSourceConfig) SuppressUntil(feedbackType string, timestamp time.Time) (float64, bool) {
+	ttl, exist := config.NegativeFeedbackTTL[feedbackType]
+	if !exist {
+		return 0, false
+	}
+	if ttl == 0 {
+		return math.MaxFloat64, true
+	}
+	return float64(timestamp.Add(ttl).Unix()), true
+}
+
 func (config *TracingConfig) NewTracerProvider() (trace.TracerProvider, error) {
 	if !config.EnableTracing {
 		return trace.NewNoopTracerProvider(), nil
@@ -458,6 +899,22 @@ func (config *TracingConfig) Equal(other TracingConfig) bool {
 
 func setDefault() {
 	defaultConfig := GetDefaultConfig()
+	// [database]
+	viper.SetDefault("database.cache_key_version", defaultConfig.Database.CacheKeyVersion)
+	viper.SetDefault("database.cache_encoding", defaultConfig.Database.CacheEncoding)
+	viper.SetDefault("database.cache_compress_threshold", defaultConfig.Database.CacheCompressThreshold)
+	viper.SetDefault("database.data_store_max_open_conns", defaultConfig.Database.DataStoreMaxOpenConns)
+	viper.SetDefault("database.data_store_max_idle_conns", defaultConfig.Database.DataStoreMaxIdleConns)
+	viper.SetDefault("database.data_store_conn_max_lifetime", defaultConfig.Database.DataStoreConnMaxLifetime)
+	viper.SetDefault("database.data_store_max_concurrency", defaultConfig.Database.DataStoreMaxConcurrency)
+	viper.SetDefault("database.data_store_wait_timeout", defaultConfig.Database.DataStoreWaitTimeout)
+	viper.SetDefault("database.data_store_slow_threshold", defaultConfig.Database.DataStoreSlowThreshold)
+	viper.SetDefault("database.data_store_explain_ratio", defaultConfig.Database.DataStoreExplainRatio)
+	viper.SetDefault("database.feedback_partition_interval", defaultConfig.Database.FeedbackPartitionInterval)
+	viper.SetDefault("database.feedback_partition_retention", defaultConfig.Database.FeedbackPartitionRetention)
+	viper.SetDefault("database.feature_store_timeout", defaultConfig.Database.FeatureStoreTimeout)
+	viper.SetDefault("database.feature_store_cache_capacity", defaultConfig.Database.FeatureStoreCacheCapacity)
+	viper.SetDefault("database.feature_store_cache_ttl", defaultConfig.Database.FeatureStoreCacheTTL)
 	// [master]
 	viper.SetDefault("master.port", defaultConfig.Master.Port)
 	viper.SetDefault("master.host", defaultConfig.Master.Host)
@@ -467,6 +924,15 @@ func setDefault() {
 	viper.SetDefault("master.http_cors_methods", defaultConfig.Master.HttpCorsMethods)
 	viper.SetDefault("master.n_jobs", defaultConfig.Master.NumJobs)
 	viper.SetDefault("master.meta_timeout", defaultConfig.Master.MetaTimeout)
+	viper.SetDefault("master.leader_lease_name", defaultConfig.Master.LeaderLeaseName)
+	viper.SetDefault("master.leader_lease_duration", defaultConfig.Master.LeaderLeaseDuration)
+	viper.SetDefault("master.task_log_size", defaultConfig.Master.TaskLogSize)
+	viper.SetDefault("master.store_metrics_period", defaultConfig.Master.StoreMetricsPeriod)
+	viper.SetDefault("master.store_metrics_batch", defaultConfig.Master.StoreMetricsBatch)
+	viper.SetDefault("master.cache_warmup_period", defaultConfig.Master.CacheWarmupPeriod)
+	viper.SetDefault("master.consistency_period", defaultConfig.Master.ConsistencyPeriod)
+	viper.SetDefault("master.consistency_repair", defaultConfig.Master.ConsistencyRepair)
+	viper.SetDefault("master.dashboard_oidc_role", defaultConfig.Master.OIDCRole)
 	// [server]
 	viper.SetDefault("server.api_key", defaultConfig.Server.APIKey)
 	viper.SetDefault("server.default_n", defaultConfig.Server.DefaultN)
@@ -474,6 +940,17 @@ func setDefault() {
 	viper.SetDefault("server.auto_insert_user", defaultConfig.Server.AutoInsertUser)
 	viper.SetDefault("server.auto_insert_item", defaultConfig.Server.AutoInsertItem)
 	viper.SetDefault("server.cache_expire", defaultConfig.Server.CacheExpire)
+	viper.SetDefault("server.feedback_wal_path", defaultConfig.Server.FeedbackWALPath)
+	viper.SetDefault("server.feedback_flush_period", defaultConfig.Server.FeedbackFlushPeriod)
+	viper.SetDefault("server.feedback_flush_size", defaultConfig.Server.FeedbackFlushSize)
+	viper.SetDefault("server.local_cache_size", defaultConfig.Server.LocalCacheSize)
+	viper.SetDefault("server.local_cache_ttl", defaultConfig.Server.LocalCacheTTL)
+	viper.SetDefault("server.shutdown_timeout", defaultConfig.Server.ShutdownTimeout)
+	viper.SetDefault("server.breaker_threshold", defaultConfig.Server.BreakerThreshold)
+	viper.SetDefault("server.breaker_cooldown", defaultConfig.Server.BreakerCooldown)
+	viper.SetDefault("server.feedback_dedup_window", defaultConfig.Server.FeedbackDedupWindow)
+	viper.SetDefault("server.session_ttl", defaultConfig.Server.SessionTTL)
+	viper.SetDefault("server.result_cache_ttl", defaultConfig.Server.ResultCacheTTL)
 	// [recommend]
 	viper.SetDefault("recommend.cache_size", defaultConfig.Recommend.CacheSize)
 	viper.SetDefault("recommend.cache_expire", defaultConfig.Recommend.CacheExpire)
@@ -489,6 +966,8 @@ func setDefault() {
 	viper.SetDefault("recommend.item_neighbors.enable_index", defaultConfig.Recommend.ItemNeighbors.EnableIndex)
 	viper.SetDefault("recommend.item_neighbors.index_recall", defaultConfig.Recommend.ItemNeighbors.IndexRecall)
 	viper.SetDefault("recommend.item_neighbors.index_fit_epoch", defaultConfig.Recommend.ItemNeighbors.IndexFitEpoch)
+	// [recommend.also_liked]
+	viper.SetDefault("recommend.also_liked.time_window", defaultConfig.Recommend.AlsoLiked.TimeWindow)
 	// [recommend.collaborative]
 	viper.SetDefault("recommend.collaborative.model_fit_period", defaultConfig.Recommend.Collaborative.ModelFitPeriod)
 	viper.SetDefault("recommend.collaborative.model_search_period", defaultConfig.Recommend.Collaborative.ModelSearchPeriod)
@@ -497,6 +976,9 @@ func setDefault() {
 	viper.SetDefault("recommend.collaborative.enable_index", defaultConfig.Recommend.Collaborative.EnableIndex)
 	viper.SetDefault("recommend.collaborative.index_recall", defaultConfig.Recommend.Collaborative.IndexRecall)
 	viper.SetDefault("recommend.collaborative.index_fit_epoch", defaultConfig.Recommend.Collaborative.IndexFitEpoch)
+	viper.SetDefault("recommend.collaborative.accelerator", defaultConfig.Recommend.Collaborative.Accelerator)
+	viper.SetDefault("recommend.collaborative.shadow_window", defaultConfig.Recommend.Collaborative.ShadowWindow)
+	viper.SetDefault("recommend.collaborative.shadow_max_regression", defaultConfig.Recommend.Collaborative.ShadowMaxRegression)
 	// [recommend.replacement]
 	viper.SetDefault("recommend.replacement.enable_replacement", defaultConfig.Recommend.Replacement.EnableReplacement)
 	viper.SetDefault("recommend.replacement.positive_replacement_decay", defaultConfig.Recommend.Replacement.PositiveReplacementDecay)
@@ -510,12 +992,82 @@ func setDefault() {
 	viper.SetDefault("recommend.offline.enable_item_based_recommend", defaultConfig.Recommend.Offline.EnableItemBasedRecommend)
 	viper.SetDefault("recommend.offline.enable_collaborative_recommend", defaultConfig.Recommend.Offline.EnableColRecommend)
 	viper.SetDefault("recommend.offline.enable_click_through_prediction", defaultConfig.Recommend.Offline.EnableClickThroughPrediction)
+	viper.SetDefault("recommend.offline.enable_time_context", defaultConfig.Recommend.Offline.EnableTimeContext)
+	viper.SetDefault("recommend.offline.cold_start_item_age", defaultConfig.Recommend.Offline.ColdStartItemAge)
+	viper.SetDefault("recommend.offline.cold_start_max_impressions", defaultConfig.Recommend.Offline.ColdStartMaxImpressions)
+	viper.SetDefault("recommend.offline.shuffle_period", defaultConfig.Recommend.Offline.ShufflePeriod)
+	viper.SetDefault("recommend.offline.click_calibration", defaultConfig.Recommend.Offline.ClickCalibration)
+	viper.SetDefault("recommend.offline.normalize_scores", defaultConfig.Recommend.Offline.NormalizeScores)
 	// [recommend.online]
 	viper.SetDefault("recommend.online.fallback_recommend", defaultConfig.Recommend.Online.FallbackRecommend)
 	viper.SetDefault("recommend.online.num_feedback_fallback_item_based", defaultConfig.Recommend.Online.NumFeedbackFallbackItemBased)
+	viper.SetDefault("recommend.online.context_blend_weight", defaultConfig.Recommend.Online.ContextBlendWeight)
+	viper.SetDefault("recommend.online.dormant_user_threshold", defaultConfig.Recommend.Online.DormantUserThreshold)
+	viper.SetDefault("recommend.online.attribution_window", defaultConfig.Recommend.Online.AttributionWindow)
+	viper.SetDefault("recommend.online.bidder_timeout", defaultConfig.Recommend.Online.BidderTimeout)
+	viper.SetDefault("recommend.online.frequency_cap_window", defaultConfig.Recommend.Online.FrequencyCapWindow)
+	viper.SetDefault("recommend.online.geo_decay_distance", defaultConfig.Recommend.Online.GeoDecayDistance)
+	viper.SetDefault("recommend.online.rerank_script_timeout", defaultConfig.Recommend.Online.RerankScriptTimeout)
 	// [tracing]
 	viper.SetDefault("tracing.exporter", defaultConfig.Tracing.Exporter)
 	viper.SetDefault("tracing.sampler", defaultConfig.Tracing.Sampler)
+	// [audit]
+	viper.SetDefault("audit.sink", defaultConfig.Audit.Sink)
+	viper.SetDefault("audit.path", defaultConfig.Audit.Path)
+	// [alert]
+	viper.SetDefault("alert.cooldown", defaultConfig.Alert.Cooldown)
+	viper.SetDefault("alert.smtp_port", defaultConfig.Alert.SMTPPort)
+}
+
+// expandHookFunc returns a decode hook which substitutes references in string values:
+//   - ${NAME} is replaced by the environment variable NAME, which must be set.
+//   - ${NAME:-DEFAULT} is replaced by the environment variable NAME, or DEFAULT if NAME is unset or empty.
+//   - ${file:PATH} is replaced by the content of the file PATH without trailing newlines, such as a mounted secret.
+func expandHookFunc() mapstructure.DecodeHookFuncKind {
+	return func(from, _ reflect.Kind, data interface{}) (interface{}, error) {
+		if from != reflect.String {
+			return data, nil
+		}
+		return expand(data.(string))
+	}
+}
+
+// referencePattern matches references in the form of ${...}. Dollar signs not followed by braces are left as they are.
+var referencePattern = regexp.MustCompile(`\$\{[^{}]+\}`)
+
+// expand substitutes references to environment variables and files in a string.
+func expand(s string) (string, error) {
+	var err error
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return ""
+		}
+		ref := match[2 : len(match)-1]
+		if strings.HasPrefix(ref, "file:") {
+			path := strings.TrimPrefix(ref, "file:")
+			content, readErr := os.ReadFile(path)
+			if readErr != nil {
+				err = errors.Annotatef(readErr, "failed to read secret file `%s`", path)
+				return ""
+			}
+			return strings.TrimRight(string(content), "\r\n")
+		}
+		if name, defaultValue, hasDefault := strings.Cut(ref, ":-"); hasDefault {
+			if value := os.Getenv(name); value != "" {
+				return value
+			}
+			return defaultValue
+		}
+		value, exist := os.LookupEnv(ref)
+		if !exist {
+			err = errors.NotFoundf("environment variable `%s`", ref)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return expanded, nil
 }
 
 type configBinding struct {
@@ -523,8 +1075,43 @@ type configBinding struct {
 	env string
 }
 
-// LoadConfig loads configuration from toml file.
+// EmbeddedDataStore and EmbeddedCacheStore are SQLite databases in the working directory, which are used by the all
+// in one mode if the data store or the cache store is not configured.
+const (
+	EmbeddedDataStore  = "sqlite://data.db"
+	EmbeddedCacheStore = "sqlite://cache.db"
+)
+
+// UseEmbeddedStores uses embedded SQLite databases for the data store and the cache store if they are not configured.
+func (config *Config) UseEmbeddedStores() {
+	if config.Database.DataStore == "" {
+		config.Database.DataStore = EmbeddedDataStore
+	}
+	if config.Database.CacheStore == "" {
+		config.Database.CacheStore = EmbeddedCacheStore
+	}
+}
+
+// LoadConfig loads configuration from toml file. Embedded stores are used in the all in one mode if stores are not
+// configured.
 func LoadConfig(path string, oneModel bool) (*Config, error) {
+	conf, err := readConfig(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if oneModel {
+		conf.UseEmbeddedStores()
+	}
+
+	// validate config file
+	if err := conf.Validate(oneModel); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return conf, nil
+}
+
+// readConfig reads configuration from toml file without validation.
+func readConfig(path string) (*Config, error) {
 	// set default config
 	setDefault()
 
@@ -532,8 +1119,15 @@ func LoadConfig(path string, oneModel bool) (*Config, error) {
 	bindings := []configBinding{
 		{"database.cache_store", "GORSE_CACHE_STORE"},
 		{"database.data_store", "GORSE_DATA_STORE"},
+		{"database.data_store_replica", "GORSE_DATA_STORE_REPLICA"},
+		{"database.data_store_target", "GORSE_DATA_STORE_TARGET"},
+		{"database.search_store", "GORSE_SEARCH_STORE"},
+		{"database.feature_store", "GORSE_FEATURE_STORE"},
 		{"database.table_prefix", "GORSE_TABLE_PREFIX"},
 		{"database.cache_table_prefix", "GORSE_CACHE_TABLE_PREFIX"},
+		{"database.cache_key_version", "GORSE_CACHE_KEY_VERSION"},
+		{"database.cache_encoding", "GORSE_CACHE_ENCODING"},
+		{"database.cache_compress_threshold", "GORSE_CACHE_COMPRESS_THRESHOLD"},
 		{"database.data_table_prefix", "GORSE_DATA_TABLE_PREFIX"},
 		{"master.port", "GORSE_MASTER_PORT"},
 		{"master.host", "GORSE_MASTER_HOST"},
@@ -544,6 +1138,10 @@ func LoadConfig(path string, oneModel bool) (*Config, error) {
 		{"master.dashboard_password", "GORSE_DASHBOARD_PASSWORD"},
 		{"master.dashboard_auth_server", "GORSE_DASHBOARD_AUTH_SERVER"},
 		{"master.dashboard_redacted", "GORSE_DASHBOARD_REDACTED"},
+		{"master.dashboard_oidc_issuer", "GORSE_DASHBOARD_OIDC_ISSUER"},
+		{"master.dashboard_oidc_client_id", "GORSE_DASHBOARD_OIDC_CLIENT_ID"},
+		{"master.dashboard_oidc_client_secret", "GORSE_DASHBOARD_OIDC_CLIENT_SECRET"},
+		{"master.dashboard_oidc_redirect_url", "GORSE_DASHBOARD_OIDC_REDIRECT_URL"},
 		{"master.admin_api_key", "GORSE_ADMIN_API_KEY"},
 		{"server.api_key", "GORSE_SERVER_API_KEY"},
 	}
@@ -567,12 +1165,11 @@ func LoadConfig(path string, oneModel bool) (*Config, error) {
 
 	// unmarshal config file
 	var conf Config
-	if err := viper.Unmarshal(&conf); err != nil {
-		return nil, errors.Trace(err)
-	}
-
-	// validate config file
-	if err := conf.Validate(oneModel); err != nil {
+	if err := viper.Unmarshal(&conf, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		expandHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))); err != nil {
 		return nil, errors.Trace(err)
 	}
 
@@ -587,6 +1184,22 @@ func LoadConfig(path string, oneModel bool) (*Config, error) {
 }
 
 func (config *Config) Validate(oneModel bool) error {
+	issues, err := config.fieldIssues(oneModel)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(issues) > 0 {
+		return errors.New(issues[0].Message)
+	}
+	// refresh tiers are matched from the narrowest window
+	sort.SliceStable(config.Recommend.Offline.RefreshTiers, func(i, j int) bool {
+		return config.Recommend.Offline.RefreshTiers[i].ActiveWithin < config.Recommend.Offline.RefreshTiers[j].ActiveWithin
+	})
+	return nil
+}
+
+// fieldIssues returns translated errors of all invalid fields.
+func (config *Config) fieldIssues(oneModel bool) ([]ValidationIssue, error) {
 	validate := validator.New()
 	if err := validate.RegisterValidation("data_store", func(fl validator.FieldLevel) bool {
 		prefixes := []string{
@@ -609,13 +1222,26 @@ func (config *Config) Validate(oneModel bool) error {
 		}
 		return false
 	}); err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	if err := validate.RegisterValidation("cron", func(fl validator.FieldLevel) bool {
+		_, err := cron.ParseStandard(fl.Field().String())
+		return err == nil
+	}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := validate.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+		_, err := regexp.Compile(fl.Field().String())
+		return err == nil
+	}); err != nil {
+		return nil, errors.Trace(err)
 	}
 	if err := validate.RegisterValidation("cache_store", func(fl validator.FieldLevel) bool {
 		prefixes := []string{
 			storage.RedisPrefix,
 			storage.RedissPrefix,
 			storage.RedisClusterPrefix,
+			storage.RedisSentinelPrefix,
 			storage.MongoPrefix,
 			storage.MongoSrvPrefix,
 			storage.MySQLPrefix,
@@ -623,7 +1249,7 @@ func (config *Config) Validate(oneModel bool) error {
 			storage.PostgreSQLPrefix,
 		}
 		if oneModel {
-			prefixes = append(prefixes, storage.SQLitePrefix)
+			prefixes = append(prefixes, storage.SQLitePrefix, storage.LocalPrefix)
 		}
 		for _, prefix := range prefixes {
 			if strings.HasPrefix(fl.Field().String(), prefix) {
@@ -632,7 +1258,36 @@ func (config *Config) Validate(oneModel bool) error {
 		}
 		return false
 	}); err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	if err := validate.RegisterValidation("search_store", func(fl validator.FieldLevel) bool {
+		prefixes := []string{
+			storage.ElasticsearchPrefix,
+			storage.ElasticsearchsPrefix,
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(fl.Field().String(), prefix) {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := validate.RegisterValidation("feature_store", func(fl validator.FieldLevel) bool {
+		prefixes := []string{
+			storage.HTTPPrefix,
+			storage.HTTPSPrefix,
+			storage.GRPCPrefix,
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(fl.Field().String(), prefix) {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return nil, errors.Trace(err)
 	}
 	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
 		return strings.SplitN(fld.Tag.Get("mapstructure"), ",", 2)[0]
@@ -642,7 +1297,7 @@ func (config *Config) Validate(oneModel bool) error {
 		// translate errors
 		trans := ut.New(en.New()).GetFallback()
 		if err := en_translations.RegisterDefaultTranslations(validate, trans); err != nil {
-			return errors.Trace(err)
+			return nil, errors.Trace(err)
 		}
 		if err := validate.RegisterTranslation("data_store", trans, func(ut ut.Translator) error {
 			return ut.Add("data_store", "unsupported data storage backend", true) // see universal-translator for details
@@ -650,7 +1305,7 @@ func (config *Config) Validate(oneModel bool) error {
 			t, _ := ut.T("data_store", fe.Field())
 			return t
 		}); err != nil {
-			return errors.Trace(err)
+			return nil, errors.Trace(err)
 		}
 		if err := validate.RegisterTranslation("cache_store", trans, func(ut ut.Translator) error {
 			return ut.Add("cache_store", "unsupported cache storage backend", true) // see universal-translator for details
@@ -658,12 +1313,41 @@ func (config *Config) Validate(oneModel bool) error {
 			t, _ := ut.T("cache_store", fe.Field())
 			return t
 		}); err != nil {
-			return errors.Trace(err)
+			return nil, errors.Trace(err)
+		}
+		if err := validate.RegisterTranslation("search_store", trans, func(ut ut.Translator) error {
+			return ut.Add("search_store", "unsupported search storage backend", true) // see universal-translator for details
+		}, func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("search_store", fe.Field())
+			return t
+		}); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := validate.RegisterTranslation("feature_store", trans, func(ut ut.Translator) error {
+			return ut.Add("feature_store", "unsupported feature store", true) // see universal-translator for details
+		}, func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("feature_store", fe.Field())
+			return t
+		}); err != nil {
+			return nil, errors.Trace(err)
 		}
-		errs := err.(validator.ValidationErrors)
-		for _, e := range errs {
-			return errors.New(e.Translate(trans))
+		if err := validate.RegisterTranslation("regexp", trans, func(ut ut.Translator) error {
+			return ut.Add("regexp", "{0} must be a valid regular expression", true) // see universal-translator for details
+		}, func(ut ut.Translator, fe validator.FieldError) string {
+			t, _ := ut.T("regexp", fe.Field())
+			return t
+		}); err != nil {
+			return nil, errors.Trace(err)
+		}
+		var issues []ValidationIssue
+		for _, e := range err.(validator.ValidationErrors) {
+			issues = append(issues, ValidationIssue{
+				Level:   LevelError,
+				Key:     strings.TrimPrefix(e.Namespace(), "Config."),
+				Message: e.Translate(trans),
+			})
 		}
+		return issues, nil
 	}
-	return nil
+	return nil, nil
 }
@@ -15,30 +15,50 @@
 package config
 
 import (
+	"sync/atomic"
+
 	"github.com/zhenghaoz/gorse/model/click"
 	"github.com/zhenghaoz/gorse/model/ranking"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/feature"
+	"github.com/zhenghaoz/gorse/storage/search"
 )
 
 type Settings struct {
-	Config *Config
+	config atomic.Value // *Config, swapped as a whole so that readers never see partial updates
 
 	// database clients
-	CacheClient cache.Database
-	DataClient  data.Database
+	CacheClient   cache.Database
+	DataClient    data.Database
+	SearchClient  search.Database // nil if the search store is not configured
+	FeatureClient feature.Store   // nil if the feature store is not configured
 
 	// recommendation models
 	RankingModel        ranking.MatrixFactorization
 	RankingModelVersion int64
 	ClickModel          click.FactorizationMachine
 	ClickModelVersion   int64
+	ObjectiveModels     map[string]click.FactorizationMachine // click models of objectives, versioned with the click model
 }
 
 func NewSettings() *Settings {
-	return &Settings{
-		Config:      GetDefaultConfig(),
+	s := &Settings{
 		CacheClient: cache.NoDatabase{},
 		DataClient:  data.NoDatabase{},
 	}
+	s.SetConfig(GetDefaultConfig())
+	return s
+}
+
+// Config returns the current config. The config is shared by readers, so it should be replaced by SetConfig instead
+// of being modified.
+func (s *Settings) Config() *Config {
+	cfg, _ := s.config.Load().(*Config)
+	return cfg
+}
+
+// SetConfig replaces the config atomically.
+func (s *Settings) SetConfig(cfg *Config) {
+	s.config.Store(cfg)
 }
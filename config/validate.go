@@ -0,0 +1,214 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+)
+
+const (
+	LevelError   = "error"   // the process fails to start or misbehaves
+	LevelWarning = "warning" // the process starts but the configuration might be unintended
+)
+
+// ValidationIssue is a problem found in configuration.
+type ValidationIssue struct {
+	Level   string `json:"level"`
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+func (issue ValidationIssue) String() string {
+	return fmt.Sprintf("[%s] %s: %s", issue.Level, issue.Key, issue.Message)
+}
+
+// HasError returns true if any issue is an error.
+func HasError(issues []ValidationIssue) bool {
+	return lo.ContainsBy(issues, func(issue ValidationIssue) bool {
+		return issue.Level == LevelError
+	})
+}
+
+// deprecatedKeys maps keys of previous versions to their replacements. Values of deprecated keys are ignored.
+var deprecatedKeys = map[string]string{
+	"database.cache_size":                "recommend.cache_size",
+	"database.auto_insert_user":          "server.auto_insert_user",
+	"database.auto_insert_item":          "server.auto_insert_item",
+	"database.positive_feedback_types":   "recommend.data_source.positive_feedback_types",
+	"database.read_feedback_types":       "recommend.data_source.read_feedback_types",
+	"database.positive_feedback_ttl":     "recommend.data_source.positive_feedback_ttl",
+	"database.item_ttl":                  "recommend.data_source.item_ttl",
+	"recommend.popular_window":           "recommend.popular.popular_window",
+	"recommend.fit_period":               "recommend.collaborative.model_fit_period",
+	"recommend.search_period":            "recommend.collaborative.model_search_period",
+	"recommend.search_epoch":             "recommend.collaborative.model_search_epoch",
+	"recommend.search_trials":            "recommend.collaborative.model_search_trials",
+	"recommend.refresh_recommend_period": "recommend.offline.refresh_recommend_period",
+	"recommend.fallback_recommend":       "recommend.online.fallback_recommend",
+}
+
+// ValidateFile reads configuration from toml file and returns all issues found, including deprecated or unknown keys,
+// invalid fields and inconsistent feedback types. An error is returned if the file fails to be read.
+func ValidateFile(path string, oneModel bool) (*Config, []ValidationIssue, error) {
+	// check keys in the file
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	issues := checkKeys(v.AllKeys())
+
+	// check values
+	conf, err := readConfig(path)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if oneModel {
+		conf.UseEmbeddedStores()
+	}
+	valueIssues, err := conf.Check(oneModel)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return conf, append(issues, valueIssues...), nil
+}
+
+// Check returns issues of invalid fields and inconsistent feedback types.
+func (config *Config) Check(oneModel bool) ([]ValidationIssue, error) {
+	issues, err := config.fieldIssues(oneModel)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(issues, config.feedbackIssues()...), nil
+}
+
+// feedbackIssues returns issues of feedback types conflicting with each other.
+func (config *Config) feedbackIssues() []ValidationIssue {
+	var issues []ValidationIssue
+	dataSource := config.Recommend.DataSource
+	if len(dataSource.PositiveFeedbackTypes) == 0 {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelWarning,
+			Key:     "recommend.data_source.positive_feedback_types",
+			Message: "no positive feedback types, recommendation models won't be trained",
+		})
+	}
+	for _, feedbackType := range lo.Intersect(dataSource.PositiveFeedbackTypes, dataSource.ReadFeedbackTypes) {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelError,
+			Key:     "recommend.data_source.read_feedback_types",
+			Message: fmt.Sprintf("feedback type `%s` is both positive and read", feedbackType),
+		})
+	}
+	if lo.Contains(dataSource.PositiveFeedbackTypes, dataSource.ImpressionFeedbackType) {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelError,
+			Key:     "recommend.data_source.impression_feedback_type",
+			Message: fmt.Sprintf("feedback type `%s` is both positive and impression", dataSource.ImpressionFeedbackType),
+		})
+	}
+	negativeTypes := lo.Keys(dataSource.NegativeFeedbackTTL)
+	sort.Strings(negativeTypes)
+	for _, feedbackType := range lo.Intersect(dataSource.PositiveFeedbackTypes, negativeTypes) {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelError,
+			Key:     "recommend.data_source.negative_feedback_ttl",
+			Message: fmt.Sprintf("feedback type `%s` is both positive and negative", feedbackType),
+		})
+	}
+	countedTypes := lo.Map(dataSource.PositiveThresholds, func(threshold FeedbackThreshold, _ int) string {
+		return threshold.FeedbackType
+	})
+	for _, feedbackType := range lo.Intersect(dataSource.PositiveFeedbackTypes, countedTypes) {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelError,
+			Key:     "recommend.data_source.positive_feedback_thresholds",
+			Message: fmt.Sprintf("feedback type `%s` is both positive and counted", feedbackType),
+		})
+	}
+	for _, feedbackType := range lo.FindDuplicates(countedTypes) {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelError,
+			Key:     "recommend.data_source.positive_feedback_thresholds",
+			Message: fmt.Sprintf("duplicate thresholds of feedback type `%s`", feedbackType),
+		})
+	}
+	for _, objective := range lo.FindDuplicatesBy(config.Recommend.Offline.Objectives, func(objective ObjectiveConfig) string {
+		return objective.Name
+	}) {
+		issues = append(issues, ValidationIssue{
+			Level:   LevelError,
+			Key:     "recommend.offline.objectives",
+			Message: fmt.Sprintf("duplicate objective `%s`", objective.Name),
+		})
+	}
+	return issues
+}
+
+// checkKeys returns issues of deprecated or unknown keys.
+func checkKeys(keys []string) []ValidationIssue {
+	knownKeys, mapKeys := make(map[string]struct{}), make([]string, 0)
+	collectKeys(reflect.TypeOf(Config{}), "", knownKeys, &mapKeys)
+	sort.Strings(keys)
+	var issues []ValidationIssue
+	for _, key := range keys {
+		if replacement, deprecated := deprecatedKeys[key]; deprecated {
+			issues = append(issues, ValidationIssue{
+				Level:   LevelWarning,
+				Key:     key,
+				Message: fmt.Sprintf("deprecated key is ignored, use `%s` instead", replacement),
+			})
+		} else if _, known := knownKeys[key]; !known && !lo.ContainsBy(mapKeys, func(mapKey string) bool {
+			return strings.HasPrefix(key, mapKey+".")
+		}) {
+			issues = append(issues, ValidationIssue{
+				Level:   LevelWarning,
+				Key:     key,
+				Message: "unknown key is ignored",
+			})
+		}
+	}
+	return issues
+}
+
+// collectKeys collects keys of fields in a struct type. Keys of map fields are collected separately since any subkey
+// of them is valid.
+func collectKeys(t reflect.Type, prefix string, keys map[string]struct{}, mapKeys *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.SplitN(field.Tag.Get("mapstructure"), ",", 2)[0]
+		if !field.IsExported() || tag == "" {
+			continue
+		}
+		key := prefix + tag
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			if field.Type.PkgPath() == t.PkgPath() {
+				collectKeys(field.Type, key+".", keys, mapKeys)
+				continue
+			}
+		case reflect.Map:
+			*mapKeys = append(*mapKeys, key)
+		}
+		keys[key] = struct{}{}
+	}
+}
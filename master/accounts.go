@@ -0,0 +1,201 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/server"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role of a dashboard account. Viewers read the dashboard, operators also change data, tasks and settings, and admins
+// also manage accounts and purge data.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+var roleLevels = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// allows returns true if the role has the permissions of another role.
+func (r Role) allows(other Role) bool {
+	return roleLevels[r] >= roleLevels[other]
+}
+
+// requiredRole returns the role required to send a request to the master.
+func requiredRole(request *http.Request) Role {
+	if strings.HasPrefix(request.URL.Path, "/api/dashboard/accounts") || strings.HasPrefix(request.URL.Path, "/api/purge") {
+		return RoleAdmin
+	}
+	if request.Method == http.MethodGet || request.Method == http.MethodHead || request.Method == http.MethodOptions {
+		return RoleViewer
+	}
+	return RoleOperator
+}
+
+// Account is a dashboard account. The password is only used to create or update an account and never returned.
+type Account struct {
+	Name     string `json:"name"`
+	Role     Role   `json:"role"`
+	Password string `json:"password,omitempty"`
+}
+
+// accountRecord is an account saved in the cache store.
+type accountRecord struct {
+	Role         Role   `json:"role"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// loadAccounts loads dashboard accounts from the cache store. Accounts are indexed by names.
+func loadAccounts(ctx context.Context, client cache.Database) (map[string]accountRecord, error) {
+	accounts := make(map[string]accountRecord)
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.DashboardAccounts)).String()
+	if errors.Is(err, errors.NotFound) {
+		return accounts, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = cache.Unmarshal(buf, &accounts); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return accounts, nil
+}
+
+func saveAccounts(ctx context.Context, client cache.Database, accounts map[string]accountRecord) error {
+	buf, err := cache.Marshal(client, accounts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.DashboardAccounts), buf))
+}
+
+// SaveAccount creates an account or updates the role and the password of an account. The password of an existing
+// account is kept if it is empty. Accounts of OpenID Connect identities, named by oidc:{issuer}#{subject}, only have
+// roles without passwords.
+func SaveAccount(ctx context.Context, client cache.Database, account Account) error {
+	if account.Name == "" {
+		return errors.NotValidf("empty account name")
+	}
+	if _, exist := roleLevels[account.Role]; !exist {
+		return errors.NotValidf("role `%s`", account.Role)
+	}
+	accounts, err := loadAccounts(ctx, client)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	record, exist := accounts[account.Name]
+	if strings.HasPrefix(account.Name, oidcAccountPrefix) {
+		if account.Password != "" {
+			return errors.NotValidf("password of OpenID Connect account")
+		}
+	} else if !exist && account.Password == "" {
+		return errors.NotValidf("empty password")
+	}
+	record.Role = account.Role
+	if account.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(account.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		record.PasswordHash = string(hash)
+	}
+	accounts[account.Name] = record
+	return saveAccounts(ctx, client, accounts)
+}
+
+// DeleteAccount deletes an account. Sessions of the account become invalid.
+func DeleteAccount(ctx context.Context, client cache.Database, name string) error {
+	accounts, err := loadAccounts(ctx, client)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, exist := accounts[name]; !exist {
+		return errors.NotFoundf("account %s", name)
+	}
+	delete(accounts, name)
+	return saveAccounts(ctx, client, accounts)
+}
+
+// checkAccess writes 401 if the request is not logged in or 403 if the role of the account is not sufficient.
+func (m *Master) checkAccess(response http.ResponseWriter, request *http.Request) bool {
+	account := m.checkLogin(request)
+	if account == nil {
+		writeError(response, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	if role := requiredRole(request); !account.Role.allows(role) {
+		writeError(response, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+func (m *Master) getAccounts(request *restful.Request, response *restful.Response) {
+	accounts, err := loadAccounts(request.Request.Context(), m.CacheClient)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	results := make([]Account, 0, len(accounts))
+	for name, record := range accounts {
+		results = append(results, Account{Name: name, Role: record.Role})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	server.Ok(response, results)
+}
+
+func (m *Master) setAccount(request *restful.Request, response *restful.Response) {
+	var account Account
+	if err := request.ReadEntity(&account); err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	if err := SaveAccount(request.Request.Context(), m.CacheClient, account); errors.IsNotValid(err) {
+		server.BadRequest(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	log.Logger().Info("save dashboard account", zap.String("name", account.Name), zap.String("role", string(account.Role)))
+	server.Ok(response, server.Success{RowAffected: 1})
+}
+
+func (m *Master) deleteAccount(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+	if err := DeleteAccount(request.Request.Context(), m.CacheClient, name); errors.Is(err, errors.NotFound) {
+		server.PageNotFound(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	log.Logger().Info("delete dashboard account", zap.String("name", name))
+	server.Ok(response, server.Success{RowAffected: 1})
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+	"go.uber.org/zap"
+)
+
+// Alert is raised when a measurement falls outside thresholds of a rule.
+type Alert struct {
+	Measurement string    `json:"measurement"`
+	Value       float32   `json:"value"`
+	Min         *float32  `json:"min,omitempty"`
+	Max         *float32  `json:"max,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// String returns a human-readable message of the alert.
+func (a Alert) String() string {
+	var bounds []string
+	if a.Min != nil {
+		bounds = append(bounds, fmt.Sprintf("min %v", *a.Min))
+	}
+	if a.Max != nil {
+		bounds = append(bounds, fmt.Sprintf("max %v", *a.Max))
+	}
+	return fmt.Sprintf("[gorse] %s = %v is out of thresholds (%s) at %s",
+		a.Measurement, a.Value, strings.Join(bounds, ", "), a.Timestamp.Format(time.RFC3339))
+}
+
+// Alerter checks measurements against rules and sends alerts to webhooks, Slack and emails. Alerts of a measurement
+// are sent at most once in a cooldown.
+type Alerter struct {
+	config    config.AlertConfig
+	client    *http.Client
+	mutex     sync.Mutex
+	lastAlert map[string]time.Time
+}
+
+// NewAlerter creates an Alerter. It returns nil if alerts are disabled.
+func NewAlerter(cfg config.AlertConfig) *Alerter {
+	if !cfg.EnableAlert {
+		return nil
+	}
+	return &Alerter{
+		config:    cfg,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		lastAlert: make(map[string]time.Time),
+	}
+}
+
+// Check measurements and send alerts of measurements out of thresholds.
+func (a *Alerter) Check(ctx context.Context, measurements ...server.Measurement) {
+	for _, alert := range a.check(measurements, time.Now()) {
+		log.Logger().Warn("measurement out of thresholds",
+			zap.String("measurement", alert.Measurement), zap.Float32("value", alert.Value))
+		if err := a.send(ctx, alert); err != nil {
+			log.Logger().Error("failed to send alert", zap.String("measurement", alert.Measurement), zap.Error(err))
+		}
+	}
+}
+
+// check returns alerts of measurements out of thresholds and not in cooldowns. Only the latest measurement of each
+// name is checked since measurements of past days are recomputed.
+func (a *Alerter) check(measurements []server.Measurement, now time.Time) []Alert {
+	if a == nil {
+		return nil
+	}
+	latest := make(map[string]server.Measurement)
+	var names []string
+	for _, measurement := range measurements {
+		if last, exist := latest[measurement.Name]; !exist {
+			names = append(names, measurement.Name)
+			latest[measurement.Name] = measurement
+		} else if measurement.Timestamp.After(last.Timestamp) {
+			latest[measurement.Name] = measurement
+		}
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var alerts []Alert
+	for _, name := range names {
+		measurement := latest[name]
+		for _, rule := range a.config.Rules {
+			if rule.Measurement != name || !rule.Check(measurement.Value) {
+				continue
+			}
+			if last, exist := a.lastAlert[name]; exist && now.Sub(last) < a.config.Cooldown {
+				continue
+			}
+			a.lastAlert[name] = now
+			alerts = append(alerts, Alert{
+				Measurement: name,
+				Value:       measurement.Value,
+				Min:         rule.Min,
+				Max:         rule.Max,
+				Timestamp:   measurement.Timestamp,
+			})
+			break
+		}
+	}
+	return alerts
+}
+
+// send an alert to all configured channels. Errors of channels are combined.
+func (a *Alerter) send(ctx context.Context, alert Alert) error {
+	var messages []string
+	if a.config.WebhookURL != "" {
+		if err := a.post(ctx, a.config.WebhookURL, alert); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if a.config.SlackURL != "" {
+		if err := a.post(ctx, a.config.SlackURL, map[string]string{"text": alert.String()}); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if a.config.SMTPHost != "" && len(a.config.EmailTo) > 0 {
+		if err := a.email(alert); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+func (a *Alerter) post(ctx context.Context, url string, body any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := a.client.Do(request)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return errors.Errorf("failed to post alert to %s: %s", url, response.Status)
+	}
+	return nil
+}
+
+func (a *Alerter) email(alert Alert) error {
+	var auth smtp.Auth
+	if a.config.SMTPUser != "" {
+		auth = smtp.PlainAuth("", a.config.SMTPUser, a.config.SMTPPassword, a.config.SMTPHost)
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: [gorse] %s out of thresholds\r\n\r\n%s\r\n",
+		a.config.EmailFrom, strings.Join(a.config.EmailTo, ", "), alert.Measurement, alert.String())
+	addr := net.JoinHostPort(a.config.SMTPHost, strconv.Itoa(a.config.SMTPPort))
+	return errors.Trace(smtp.SendMail(addr, auth, a.config.EmailFrom, a.config.EmailTo, []byte(message)))
+}
+
+// insertMeasurements saves measurements and checks them against alert rules.
+func (m *Master) insertMeasurements(ctx context.Context, measurements ...server.Measurement) {
+	if err := m.RestServer.InsertMeasurement(ctx, measurements...); err != nil {
+		log.Logger().Error("failed to insert measurement", zap.Error(err))
+	}
+	m.alerter.Check(ctx, measurements...)
+}
@@ -0,0 +1,99 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+)
+
+func TestAlerter_Check(t *testing.T) {
+	assert.Nil(t, NewAlerter(config.AlertConfig{}))
+	var nilAlerter *Alerter
+	assert.Empty(t, nilAlerter.check([]server.Measurement{{Name: RankingModelNDCG}}, time.Now()))
+
+	alerter := NewAlerter(config.AlertConfig{
+		EnableAlert: true,
+		Cooldown:    time.Hour,
+		Rules: []config.AlertRule{
+			{Measurement: RankingModelNDCG, Min: lo.ToPtr[float32](0.1)},
+			{Measurement: RecommendationCoverage, Min: lo.ToPtr[float32](0.2), Max: lo.ToPtr[float32](0.9)},
+		},
+	})
+	now := time.Now()
+	alerts := alerter.check([]server.Measurement{
+		{Name: RankingModelNDCG, Timestamp: now, Value: 0.05},
+		{Name: RecommendationCoverage, Timestamp: now, Value: 0.5},
+		{Name: ClickModelAUC, Timestamp: now, Value: 0},
+	}, now)
+	assert.Equal(t, []Alert{{Measurement: RankingModelNDCG, Value: 0.05, Min: lo.ToPtr[float32](0.1), Timestamp: now}}, alerts)
+
+	// alerts in cooldown are suppressed
+	alerts = alerter.check([]server.Measurement{
+		{Name: RankingModelNDCG, Timestamp: now, Value: 0.05},
+		{Name: RecommendationCoverage, Timestamp: now, Value: 0.95},
+	}, now.Add(time.Minute))
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, RecommendationCoverage, alerts[0].Measurement)
+	alerts = alerter.check([]server.Measurement{{Name: RankingModelNDCG, Timestamp: now, Value: 0.05}}, now.Add(time.Hour))
+	assert.Len(t, alerts, 1)
+
+	// only the latest measurement is checked
+	alerts = alerter.check([]server.Measurement{
+		{Name: RecommendationCoverage, Timestamp: now, Value: 0.5},
+		{Name: RecommendationCoverage, Timestamp: now.Add(-24 * time.Hour), Value: 0.1},
+	}, now.Add(2*time.Hour))
+	assert.Empty(t, alerts)
+}
+
+func TestAlerter_Send(t *testing.T) {
+	var webhookAlert Alert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&webhookAlert))
+	}))
+	defer webhook.Close()
+	var slackMessage map[string]string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&slackMessage))
+	}))
+	defer slack.Close()
+
+	alerter := NewAlerter(config.AlertConfig{
+		EnableAlert: true,
+		WebhookURL:  webhook.URL,
+		SlackURL:    slack.URL,
+		Rules:       []config.AlertRule{{Measurement: RankingModelNDCG, Min: lo.ToPtr[float32](0.1)}},
+	})
+	timestamp := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	alerter.Check(context.Background(), server.Measurement{Name: RankingModelNDCG, Timestamp: timestamp, Value: 0.05})
+	assert.Equal(t, RankingModelNDCG, webhookAlert.Measurement)
+	assert.Equal(t, float32(0.05), webhookAlert.Value)
+	assert.Equal(t, float32(0.1), *webhookAlert.Min)
+	assert.Nil(t, webhookAlert.Max)
+	assert.Equal(t, "[gorse] RankingModelNDCG = 0.05 is out of thresholds (min 0.1) at 2023-01-01T00:00:00Z", slackMessage["text"])
+
+	// failed posts are reported
+	webhook.Close()
+	assert.Error(t, alerter.send(context.Background(), webhookAlert))
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/heap"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/base/parallel"
+	"github.com/zhenghaoz/gorse/base/task"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+	"modernc.org/sortutil"
+)
+
+// FindAlsoLikedItemsTask updates items liked by users who liked each item. Items are scored by the number of users
+// who liked both items in the time window.
+type FindAlsoLikedItemsTask struct {
+	*Master
+	lastItems *strset.Set
+}
+
+func NewFindAlsoLikedItemsTask(m *Master) *FindAlsoLikedItemsTask {
+	return &FindAlsoLikedItemsTask{Master: m, lastItems: strset.New()}
+}
+
+func (t *FindAlsoLikedItemsTask) name() string {
+	return TaskFindAlsoLikedItems
+}
+
+func (t *FindAlsoLikedItemsTask) priority() int {
+	return -t.rankingTrainSet.Count()
+}
+
+func (t *FindAlsoLikedItemsTask) run(j *task.JobsAllocator) error {
+	ctx := context.Background()
+	startTaskTime := time.Now()
+	t.taskMonitor.Start(TaskFindAlsoLikedItems, 2)
+	feedbackTypes := t.Config().Recommend.AlsoLiked.FeedbackTypes
+	if len(feedbackTypes) == 0 {
+		feedbackTypes = t.Config().Recommend.DataSource.PositiveFeedbackTypes
+	}
+	var beginTime *time.Time
+	if t.Config().Recommend.AlsoLiked.TimeWindow > 0 {
+		beginTime = lo.ToPtr(t.Config().Now().Add(-t.Config().Recommend.AlsoLiked.TimeWindow))
+	}
+
+	// STEP 1: pull feedback in the time window
+	userIndex, itemIndex := base.NewMapIndex(), base.NewMapIndex()
+	var userItems, itemUsers [][]int32
+	feedbackChan, errChan := t.DataClient.GetFeedbackStream(ctx, batchSize, beginTime, t.Config().Now(), feedbackTypes...)
+	for feedback := range feedbackChan {
+		for _, f := range feedback {
+			userIndex.Add(f.UserId)
+			itemIndex.Add(f.ItemId)
+			u, i := userIndex.ToNumber(f.UserId), itemIndex.ToNumber(f.ItemId)
+			if int(u) == len(userItems) {
+				userItems = append(userItems, nil)
+			}
+			if int(i) == len(itemUsers) {
+				itemUsers = append(itemUsers, nil)
+			}
+			userItems[u] = append(userItems[u], i)
+			itemUsers[i] = append(itemUsers[i], u)
+		}
+	}
+	if err := <-errChan; err != nil {
+		t.taskMonitor.Fail(TaskFindAlsoLikedItems, err.Error())
+		return errors.Trace(err)
+	}
+	// remove duplicate feedback of different types
+	for u := range userItems {
+		sort.Sort(sortutil.Int32Slice(userItems[u]))
+		userItems[u] = sortedUnique(userItems[u])
+	}
+	for i := range itemUsers {
+		sort.Sort(sortutil.Int32Slice(itemUsers[i]))
+		itemUsers[i] = sortedUnique(itemUsers[i])
+	}
+	t.taskMonitor.Update(TaskFindAlsoLikedItems, 1)
+	log.Logger().Info("start finding also liked items",
+		zap.Int("n_users", len(userItems)),
+		zap.Int("n_items", len(itemUsers)),
+		zap.Strings("feedback_types", feedbackTypes))
+
+	// STEP 2: count co-occurrence of items
+	err := parallel.DynamicParallel(len(itemUsers), j, func(_, jobId int) error {
+		counts := make(map[int32]int)
+		for _, u := range itemUsers[jobId] {
+			for _, i := range userItems[u] {
+				if i != int32(jobId) {
+					counts[i]++
+				}
+			}
+		}
+		filter := heap.NewTopKFilter[int32, float64](t.Config().Recommend.CacheSize)
+		for i, count := range counts {
+			filter.Push(i, float64(count))
+		}
+		elem, scores := filter.PopAll()
+		items := make([]string, len(elem))
+		for k := range elem {
+			items[k] = itemIndex.ToName(elem[k])
+		}
+		return errors.Trace(t.CacheClient.SetSorted(ctx, cache.Key(cache.AlsoLikedItems, itemIndex.ToName(int32(jobId))),
+			cache.CreateScoredItems(items, scores)))
+	})
+	if err != nil {
+		t.taskMonitor.Fail(TaskFindAlsoLikedItems, err.Error())
+		return errors.Trace(err)
+	}
+	// remove also liked items of items without feedback in the time window
+	items := strset.New(itemIndex.GetNames()...)
+	for _, itemId := range strset.Difference(t.lastItems, items).List() {
+		if err = t.CacheClient.Delete(ctx, cache.Key(cache.AlsoLikedItems, itemId)); err != nil {
+			t.taskMonitor.Fail(TaskFindAlsoLikedItems, err.Error())
+			return errors.Trace(err)
+		}
+	}
+	t.lastItems = items
+	if err = t.CacheClient.Set(ctx, cache.Time(cache.Key(cache.GlobalMeta, cache.LastUpdateAlsoLikedTime), time.Now())); err != nil {
+		log.Logger().Error("failed to set also liked items update time", zap.Error(err))
+	}
+	t.taskMonitor.Finish(TaskFindAlsoLikedItems)
+	log.Logger().Info("complete finding also liked items",
+		zap.Duration("used_time", time.Since(startTaskTime)))
+	return nil
+}
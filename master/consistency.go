@@ -0,0 +1,213 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+)
+
+// ConsistencyReport is the result of a consistency check between the data store and the cache store.
+type ConsistencyReport struct {
+	CheckTime         time.Time `json:"check_time"`
+	HiddenItems       int       `json:"hidden_items"`       // hidden or deleted items in cached lists of items
+	DeletedUsers      int       `json:"deleted_users"`      // keys of recommendations and neighbors of deleted users
+	OrphanedNeighbors int       `json:"orphaned_neighbors"` // neighbors of deleted items and deleted neighbors
+	Repaired          bool      `json:"repaired"`
+}
+
+// splitCacheKey splits a key in the cache store into the prefix and the id of the user or the item.
+func splitCacheKey(key string) (prefix, id string) {
+	splits := strings.SplitN(key, "/", 3)
+	if len(splits) > 1 {
+		return splits[0], splits[1]
+	}
+	return splits[0], ""
+}
+
+// checkConsistency finds entries in the cache store inconsistent with the data store:
+//   - hidden or deleted items in popular items, latest items, cold-start items, recommendations and neighbors,
+//   - recommendations, neighbors and their meta of deleted users,
+//   - neighbors of deleted items and deleted users or items in neighbors.
+//
+// Inconsistent entries are removed from the cache store if repair is true.
+func (m *Master) checkConsistency(ctx context.Context, repair bool) (*ConsistencyReport, error) {
+	m.consistencyCheckMutex.Lock()
+	defer m.consistencyCheckMutex.Unlock()
+	startTime := time.Now()
+
+	// pull users and items
+	users := strset.New()
+	userChan, errChan := m.DataClient.GetUserStream(ctx, batchSize)
+	for batch := range userChan {
+		for _, user := range batch {
+			users.Add(user.UserId)
+		}
+	}
+	if err := <-errChan; err != nil {
+		return nil, errors.Trace(err)
+	}
+	hiddenItems := make(map[string]bool)
+	itemChan, errChan := m.DataClient.GetItemStream(ctx, batchSize, nil)
+	for batch := range itemChan {
+		for _, item := range batch {
+			hiddenItems[item.ItemId] = item.IsHidden
+		}
+	}
+	if err := <-errChan; err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// keys are collected before checking since the cache store might be modified during the scan
+	var keys []string
+	if err := m.CacheClient.Scan(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	report := &ConsistencyReport{CheckTime: startTime, Repaired: repair}
+	var (
+		staleMembers []cache.SetMember // members to remove from sorted sets
+		staleSorted  []string          // sorted sets to clear
+		staleValues  []string          // values to delete
+	)
+	// checkItems finds hidden or deleted items in a sorted set of items. Deleted items are orphaned neighbors if the
+	// sorted set is neighbors of an item.
+	checkItems := func(key string, neighbors bool) error {
+		scores, err := m.CacheClient.GetSorted(ctx, key, 0, -1)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, score := range scores {
+			if hidden, exist := hiddenItems[score.Id]; !exist && neighbors {
+				report.OrphanedNeighbors++
+			} else if hidden || !exist {
+				report.HiddenItems++
+			} else {
+				continue
+			}
+			staleMembers = append(staleMembers, cache.Member(key, score.Id))
+		}
+		return nil
+	}
+	for _, key := range keys {
+		prefix, id := splitCacheKey(key)
+		var err error
+		switch prefix {
+		case cache.PopularItems, cache.LatestItems, cache.ColdStartItems:
+			err = checkItems(key, false)
+		case cache.OfflineRecommend, cache.CollaborativeRecommend:
+			if !users.Has(id) {
+				report.DeletedUsers++
+				staleSorted = append(staleSorted, key)
+			} else {
+				err = checkItems(key, false)
+			}
+		case cache.UserNeighbors:
+			if !users.Has(id) {
+				report.DeletedUsers++
+				staleSorted = append(staleSorted, key)
+				break
+			}
+			var scores []cache.Scored
+			if scores, err = m.CacheClient.GetSorted(ctx, key, 0, -1); err == nil {
+				for _, score := range scores {
+					if !users.Has(score.Id) {
+						report.OrphanedNeighbors++
+						staleMembers = append(staleMembers, cache.Member(key, score.Id))
+					}
+				}
+			}
+		case cache.OfflineRecommendDigest, cache.LastUpdateUserRecommendTime,
+			cache.UserNeighborsDigest, cache.LastUpdateUserNeighborsTime:
+			if !users.Has(id) {
+				report.DeletedUsers++
+				staleValues = append(staleValues, key)
+			}
+		case cache.ItemNeighbors, cache.AlsoLikedItems:
+			if _, exist := hiddenItems[id]; !exist {
+				report.OrphanedNeighbors++
+				staleSorted = append(staleSorted, key)
+			} else {
+				err = checkItems(key, true)
+			}
+		case cache.ItemNeighborsDigest, cache.LastUpdateItemNeighborsTime:
+			if _, exist := hiddenItems[id]; !exist {
+				report.OrphanedNeighbors++
+				staleValues = append(staleValues, key)
+			}
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	// remove inconsistent entries
+	if repair {
+		if len(staleMembers) > 0 {
+			if err := m.CacheClient.RemSorted(ctx, staleMembers...); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		for _, key := range staleSorted {
+			if err := m.CacheClient.RemSortedByScore(ctx, key, math.Inf(-1), math.Inf(1)); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		for _, key := range staleValues {
+			if err := m.CacheClient.Delete(ctx, key); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+	}
+
+	ConsistencyIssuesVec.WithLabelValues("hidden_items").Set(float64(report.HiddenItems))
+	ConsistencyIssuesVec.WithLabelValues("deleted_users").Set(float64(report.DeletedUsers))
+	ConsistencyIssuesVec.WithLabelValues("orphaned_neighbors").Set(float64(report.OrphanedNeighbors))
+	m.consistencyReportMutex.Lock()
+	m.consistencyReport = report
+	m.consistencyReportMutex.Unlock()
+	log.Logger().Info("check consistency between data store and cache store",
+		zap.Int("n_hidden_items", report.HiddenItems),
+		zap.Int("n_deleted_users", report.DeletedUsers),
+		zap.Int("n_orphaned_neighbors", report.OrphanedNeighbors),
+		zap.Bool("repaired", repair),
+		zap.Duration("used_time", time.Since(startTime)))
+	return report, nil
+}
+
+// RunConsistencyCheckLoop checks consistency between the data store and the cache store periodically.
+func (m *Master) RunConsistencyCheckLoop() {
+	defer base.CheckPanic()
+	ticker := time.NewTicker(m.Config().Master.ConsistencyPeriod)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		if _, err := m.checkConsistency(context.Background(), m.Config().Master.ConsistencyRepair); err != nil {
+			log.Logger().Error("failed to check consistency", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+func TestMaster_CheckConsistency(t *testing.T) {
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+
+	// users 0-2 and items 0-4 exist, item 4 is hidden
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, m.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: strconv.Itoa(i)}}))
+	}
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, m.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: strconv.Itoa(i), IsHidden: i == 4}}))
+	}
+	assert.NoError(t, m.CacheClient.AddSorted(ctx,
+		cache.Sorted(cache.Key(cache.PopularItems, ""), []cache.Scored{{"3", 1}, {"4", 2}, {"9", 3}}),
+		cache.Sorted(cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"1", 1}, {"4", 2}}),
+		cache.Sorted(cache.Key(cache.OfflineRecommend, "5"), []cache.Scored{{"1", 1}}),
+		cache.Sorted(cache.Key(cache.UserNeighbors, "0"), []cache.Scored{{"1", 1}, {"7", 2}}),
+		cache.Sorted(cache.Key(cache.ItemNeighbors, "8"), []cache.Scored{{"1", 1}}),
+		cache.Sorted(cache.Key(cache.ItemNeighbors, "0"), []cache.Scored{{"1", 1}, {"4", 2}, {"9", 3}})))
+	assert.NoError(t, m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "5"), time.Now())))
+
+	report, err := m.checkConsistency(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.HiddenItems)
+	assert.Equal(t, 2, report.DeletedUsers)
+	assert.Equal(t, 3, report.OrphanedNeighbors)
+	assert.False(t, report.Repaired)
+	scores, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, ""), 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, scores, 3)
+
+	// repair inconsistent entries
+	report, err = m.checkConsistency(ctx, true)
+	assert.NoError(t, err)
+	assert.True(t, report.Repaired)
+	scores, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, ""), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3"}, cache.RemoveScores(scores))
+	scores, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "0"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, cache.RemoveScores(scores))
+	scores, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "5"), 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, scores)
+	report, err = m.checkConsistency(ctx, false)
+	assert.NoError(t, err)
+	assert.Zero(t, report.HiddenItems)
+	assert.Zero(t, report.DeletedUsers)
+	assert.Zero(t, report.OrphanedNeighbors)
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"go.uber.org/zap"
+)
+
+// maxRepairRounds is the max number of rounds to verify and repair data copied to the target data store.
+const maxRepairRounds = 3
+
+// copyDataStore copies historical data from the data store to the target data store, then verifies copied data and
+// repairs differences caused by writes during the copy. It returns true if no difference is found at last.
+func (m *Master) copyDataStore(ctx context.Context, source, target data.Database) (bool, error) {
+	startTime := time.Now()
+	stats, err := data.CopyData(ctx, source, target, batchSize)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	DataStoreTargetCopiedVec.WithLabelValues("users").Set(float64(stats.Users))
+	DataStoreTargetCopiedVec.WithLabelValues("items").Set(float64(stats.Items))
+	DataStoreTargetCopiedVec.WithLabelValues("feedback").Set(float64(stats.Feedback))
+	log.Logger().Info("copy data to target data store",
+		zap.Int("n_users", stats.Users),
+		zap.Int("n_items", stats.Items),
+		zap.Int("n_feedback", stats.Feedback),
+		zap.Duration("used_time", time.Since(startTime)))
+	for round := 0; round < maxRepairRounds; round++ {
+		diff, err := data.VerifyData(ctx, source, target, batchSize)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		DataStoreTargetDifferences.Set(float64(diff.Len()))
+		if diff.Len() == 0 {
+			return true, nil
+		}
+		log.Logger().Info("repair differences in target data store",
+			zap.Int("n_users", len(diff.Users)),
+			zap.Int("n_items", len(diff.Items)),
+			zap.Int("n_feedback", len(diff.Feedback)))
+		if err = data.RepairData(ctx, source, target, diff); err != nil {
+			return false, errors.Trace(err)
+		}
+	}
+	diff, err := data.VerifyData(ctx, source, target, batchSize)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	DataStoreTargetDifferences.Set(float64(diff.Len()))
+	return diff.Len() == 0, nil
+}
+
+// RunDataStoreCopy copies data to the target data store in background while writes are sent to both data stores.
+func (m *Master) RunDataStoreCopy(source, target data.Database) {
+	defer base.CheckPanic()
+	ready, err := m.copyDataStore(context.Background(), source, target)
+	if err != nil {
+		log.Logger().Error("failed to copy data to target data store", zap.Error(err))
+	} else if ready {
+		log.Logger().Info("target data store is ready to replace the data store",
+			zap.String("data_store_target", log.RedactDBURL(m.Config().Database.DataStoreTarget)))
+	} else {
+		log.Logger().Warn("target data store differs from the data store after repairs, restart the master to copy again")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+func TestMaster_CopyDataStore(t *testing.T) {
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	target, err := data.Open("sqlite://"+filepath.Join(t.TempDir(), "target.db"), "")
+	assert.NoError(t, err)
+	assert.NoError(t, target.Init())
+
+	// insert historical data
+	now := time.Now().UTC().Truncate(time.Second)
+	var feedback []data.Feedback
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: strconv.Itoa(i)}}))
+		assert.NoError(t, m.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: strconv.Itoa(i), Timestamp: now}}))
+		feedback = append(feedback, data.Feedback{
+			FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: strconv.Itoa(i), ItemId: strconv.Itoa(i)},
+			Timestamp:   now,
+		})
+	}
+	assert.NoError(t, m.DataClient.BatchInsertFeedback(ctx, feedback, false, false, false))
+
+	ready, err := m.copyDataStore(ctx, m.DataClient, target)
+	assert.NoError(t, err)
+	assert.True(t, ready)
+	_, items, err := target.GetItems(ctx, "", 100, nil)
+	assert.NoError(t, err)
+	assert.Len(t, items, 10)
+	_, copied, err := target.GetFeedback(ctx, "", 100, nil, nil, "", "")
+	assert.NoError(t, err)
+	assert.Len(t, copied, 10)
+	assert.NoError(t, target.Close())
+}
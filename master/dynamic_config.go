@@ -0,0 +1,221 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+)
+
+// maxConfigHistory is the max number of changes kept in the history of dynamic config.
+const maxConfigHistory = 100
+
+// DynamicConfig overrides recommendation settings in the config file. Settings are not overridden if they are null.
+type DynamicConfig struct {
+	ExploreRecommend             map[string]float64  `json:"explore_recommend,omitempty"`
+	FallbackRecommend            []string            `json:"fallback_recommend,omitempty"`
+	CategoryFallbackRecommend    map[string][]string `json:"category_fallback_recommend,omitempty"`
+	EnableClickThroughPrediction *bool               `json:"enable_click_through_prediction,omitempty"`
+}
+
+// Validate returns an error if the dynamic config is malformed.
+func (d DynamicConfig) Validate() error {
+	if err := server.ValidateExploreRecommend(d.ExploreRecommend); err != nil {
+		return errors.Trace(err)
+	}
+	if err := server.ValidateFallbackRecommend(d.FallbackRecommend); err != nil {
+		return errors.Trace(err)
+	}
+	for category, recommenders := range d.CategoryFallbackRecommend {
+		if err := server.ValidateFallbackRecommend(recommenders); err != nil {
+			return errors.Annotatef(err, "category `%s`", category)
+		}
+	}
+	return nil
+}
+
+// apply overrides settings of a config.
+func (d DynamicConfig) apply(cfg *config.Config) {
+	if d.ExploreRecommend != nil {
+		cfg.Recommend.Offline.ExploreRecommend = d.ExploreRecommend
+	}
+	if d.FallbackRecommend != nil {
+		cfg.Recommend.Online.FallbackRecommend = d.FallbackRecommend
+	}
+	if d.CategoryFallbackRecommend != nil {
+		cfg.Recommend.Online.CategoryFallbackRecommend = d.CategoryFallbackRecommend
+	}
+	if d.EnableClickThroughPrediction != nil {
+		cfg.Recommend.Offline.EnableClickThroughPrediction = *d.EnableClickThroughPrediction
+	}
+}
+
+// ConfigChange is a change of dynamic config.
+type ConfigChange struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Before    DynamicConfig `json:"before"`
+	After     DynamicConfig `json:"after"`
+}
+
+// SaveDynamicConfig validates and saves dynamic config to the cache store. The change is appended to the history.
+func SaveDynamicConfig(ctx context.Context, client cache.Database, dynamicConfig DynamicConfig) error {
+	if err := dynamicConfig.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	before, err := LoadDynamicConfig(ctx, client)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	history, err := LoadConfigHistory(ctx, client)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	history = append(history, ConfigChange{Timestamp: time.Now(), Before: before, After: dynamicConfig})
+	if len(history) > maxConfigHistory {
+		history = history[len(history)-maxConfigHistory:]
+	}
+	configBuf, err := cache.Marshal(client, dynamicConfig)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	historyBuf, err := cache.Marshal(client, history)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx,
+		cache.String(cache.Key(cache.GlobalMeta, cache.DynamicConfig), configBuf),
+		cache.String(cache.Key(cache.GlobalMeta, cache.DynamicConfigHistory), historyBuf))
+}
+
+// LoadDynamicConfig loads dynamic config from the cache store.
+func LoadDynamicConfig(ctx context.Context, client cache.Database) (DynamicConfig, error) {
+	var dynamicConfig DynamicConfig
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.DynamicConfig)).String()
+	if errors.Is(err, errors.NotFound) {
+		return dynamicConfig, nil
+	} else if err != nil {
+		return dynamicConfig, errors.Trace(err)
+	}
+	if err = cache.Unmarshal(buf, &dynamicConfig); err != nil {
+		return dynamicConfig, errors.Trace(err)
+	}
+	return dynamicConfig, nil
+}
+
+// LoadConfigHistory loads changes of dynamic config from the cache store, from the oldest to the latest.
+func LoadConfigHistory(ctx context.Context, client cache.Database) ([]ConfigChange, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.DynamicConfigHistory)).String()
+	if errors.Is(err, errors.NotFound) {
+		return []ConfigChange{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var history []ConfigChange
+	if err = cache.Unmarshal(buf, &history); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return history, nil
+}
+
+// applyDynamicConfig overrides the static config by dynamic config and applies changed settings. Servers and workers
+// receive new settings in the next meta sync.
+func (m *Master) applyDynamicConfig(ctx context.Context) ([]string, error) {
+	m.dynamicConfigLock.Lock()
+	defer m.dynamicConfigLock.Unlock()
+	if m.staticConfig == nil {
+		staticConfig, err := copyConfig(m.Config())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		m.staticConfig = staticConfig
+	}
+	cfg, err := copyConfig(m.staticConfig)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if m.CacheClient != nil {
+		dynamicConfig, err := LoadDynamicConfig(ctx, m.CacheClient)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		dynamicConfig.apply(cfg)
+	}
+	cfg, changed := m.Config().UpdateRecommend(cfg)
+	m.SetConfig(cfg)
+	return changed, nil
+}
+
+// copyConfig returns a deep copy of a config.
+func copyConfig(cfg *config.Config) (*config.Config, error) {
+	buf, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var copied config.Config
+	if err = json.Unmarshal(buf, &copied); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &copied, nil
+}
+
+func (m *Master) getDynamicConfig(request *restful.Request, response *restful.Response) {
+	dynamicConfig, err := LoadDynamicConfig(request.Request.Context(), m.CacheClient)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, dynamicConfig)
+}
+
+// setDynamicConfig replaces dynamic config and returns keys of changed settings.
+func (m *Master) setDynamicConfig(request *restful.Request, response *restful.Response) {
+	var dynamicConfig DynamicConfig
+	if err := request.ReadEntity(&dynamicConfig); err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	if err := SaveDynamicConfig(request.Request.Context(), m.CacheClient, dynamicConfig); errors.IsNotValid(err) {
+		server.BadRequest(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	changed, err := m.applyDynamicConfig(request.Request.Context())
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	log.Logger().Info("update dynamic config", zap.Strings("changed", changed))
+	server.Ok(response, changed)
+}
+
+func (m *Master) getConfigHistory(request *restful.Request, response *restful.Response) {
+	history, err := LoadConfigHistory(request.Request.Context(), m.CacheClient)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, history)
+}
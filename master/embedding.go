@@ -0,0 +1,445 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/encoding"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/model/ranking"
+	"go.uber.org/zap"
+)
+
+// Formats of exported embeddings.
+const (
+	EmbeddingFormatJSON    = "json"
+	EmbeddingFormatParquet = "parquet"
+	EmbeddingFormatNPY     = "npy"
+)
+
+// Embedding is the latent factor of a user or an item in the ranking model.
+type Embedding struct {
+	Id        string    `json:"id"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// embeddings are latent factors of users or items with the same dimension.
+type embeddings struct {
+	ids     []string
+	factors [][]float32
+}
+
+func (m *Master) exportUserEmbeddings(response http.ResponseWriter, request *http.Request) {
+	m.exportEmbeddings(response, request, "users", func(model ranking.MatrixFactorization) embeddings {
+		var e embeddings
+		for userIndex, userId := range model.GetUserIndex().GetNames() {
+			if model.IsUserPredictable(int32(userIndex)) {
+				e.ids = append(e.ids, userId)
+				e.factors = append(e.factors, model.GetUserFactor(int32(userIndex)))
+			}
+		}
+		return e
+	})
+}
+
+func (m *Master) exportItemEmbeddings(response http.ResponseWriter, request *http.Request) {
+	m.exportEmbeddings(response, request, "items", func(model ranking.MatrixFactorization) embeddings {
+		var e embeddings
+		for itemIndex, itemId := range model.GetItemIndex().GetNames() {
+			if model.IsItemPredictable(int32(itemIndex)) {
+				e.ids = append(e.ids, itemId)
+				e.factors = append(e.factors, model.GetItemFactor(int32(itemIndex)))
+			}
+		}
+		return e
+	})
+}
+
+// exportEmbeddings exports latent factors of the current ranking model in JSON, Parquet or NPY. Users and items never
+// trained are skipped since their latent factors are random.
+func (m *Master) exportEmbeddings(response http.ResponseWriter, request *http.Request, name string,
+	collect func(model ranking.MatrixFactorization) embeddings) {
+	if !m.checkAccess(response, request) {
+		return
+	}
+	if request.Method != http.MethodGet {
+		writeError(response, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	format := formValue(request, "format", EmbeddingFormatJSON)
+	if format != EmbeddingFormatJSON && format != EmbeddingFormatParquet && format != EmbeddingFormatNPY {
+		writeError(response, http.StatusBadRequest, fmt.Sprintf("unsupported format: %s", format))
+		return
+	}
+	// The ranking model is replaced instead of modified once fitted, so that latent factors are read without lock.
+	m.rankingModelMutex.RLock()
+	model, version := m.RankingModel, m.RankingModelVersion
+	m.rankingModelMutex.RUnlock()
+	if model == nil || model.Invalid() {
+		writeError(response, http.StatusNotFound, "no valid ranking model found")
+		return
+	}
+	e := collect(model)
+
+	response.Header().Set("X-Model-Version", encoding.Hex(version))
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s.%s", name, format))
+	writer := bufio.NewWriter(response)
+	var err error
+	switch format {
+	case EmbeddingFormatJSON:
+		response.Header().Set("Content-Type", "application/json")
+		err = writeEmbeddingsJSON(writer, e)
+	case EmbeddingFormatParquet:
+		response.Header().Set("Content-Type", "application/vnd.apache.parquet")
+		err = writeEmbeddingsParquet(writer, e)
+	case EmbeddingFormatNPY:
+		response.Header().Set("Content-Type", "application/octet-stream")
+		err = writeEmbeddingsNPY(writer, e)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		// The response has been partially written, so that the error is logged only.
+		log.Logger().Error("failed to export embeddings", zap.String("format", format), zap.Error(err))
+	}
+}
+
+// dim returns the number of factors, which is 0 if there are no embeddings.
+func (e embeddings) dim() int {
+	if len(e.factors) == 0 {
+		return 0
+	}
+	return len(e.factors[0])
+}
+
+// writeEmbeddingsJSON writes embeddings as a JSON array of Embedding.
+func writeEmbeddingsJSON(w io.Writer, e embeddings) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return errors.Trace(err)
+	}
+	for i := range e.ids {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		buf, err := json.Marshal(Embedding{Id: e.ids[i], Embedding: e.factors[i]})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, err = w.Write(buf); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return errors.Trace(err)
+}
+
+// writeEmbeddingsNPY writes embeddings as a NPY file of a structured array with fields id and embedding, which is
+// loaded by numpy.load.
+func writeEmbeddingsNPY(w io.Writer, e embeddings) error {
+	dim := e.dim()
+	idLen := 1
+	for _, id := range e.ids {
+		if n := utf8.RuneCountInString(id); n > idLen {
+			idLen = n
+		}
+	}
+	header := fmt.Sprintf("{'descr': [('id', '<U%d'), ('embedding', '<f4', (%d,))], 'fortran_order': False, 'shape': (%d,), }",
+		idLen, dim, len(e.ids))
+	// The length of magic string, version, header length and header is aligned to 64 bytes.
+	const prefixLen = 10
+	header += strings.Repeat(" ", 63-(prefixLen+len(header))%64) + "\n"
+	if len(header) > math.MaxUint16 {
+		return errors.New("header of NPY is too long")
+	}
+	prefix := []byte{0x93, 'N', 'U', 'M', 'P', 'Y', 1, 0, 0, 0}
+	binary.LittleEndian.PutUint16(prefix[8:], uint16(len(header)))
+	if _, err := w.Write(prefix); err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return errors.Trace(err)
+	}
+	// ids are fixed-length UTF-32 strings padded by zeros
+	row := make([]byte, 4*(idLen+dim))
+	for i, id := range e.ids {
+		for j := range row {
+			row[j] = 0
+		}
+		offset := 0
+		for _, r := range id {
+			binary.LittleEndian.PutUint32(row[offset:], uint32(r))
+			offset += 4
+		}
+		for j, value := range e.factors[i] {
+			binary.LittleEndian.PutUint32(row[4*(idLen+j):], math.Float32bits(value))
+		}
+		if _, err := w.Write(row); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Types, repetitions and encodings of Parquet.
+const (
+	parquetFloat     = 4
+	parquetByteArray = 6
+	parquetRequired  = 0
+	parquetUTF8      = 0
+	parquetPlain     = 0
+	parquetRLE       = 3
+	parquetDataPage  = 0
+)
+
+// writeEmbeddingsParquet writes embeddings as a Parquet file with a column of ids and a column of each factor named
+// factor_0, factor_1, ... Columns are stored in a row group with plain encoding and no compression.
+func writeEmbeddingsParquet(w io.Writer, e embeddings) error {
+	dim := e.dim()
+	offset := int64(0)
+	write := func(buf []byte) error {
+		n, err := w.Write(buf)
+		offset += int64(n)
+		return errors.Trace(err)
+	}
+	if err := write([]byte("PAR1")); err != nil {
+		return err
+	}
+	// write columns
+	var columns []thriftCompact
+	var totalSize int64
+	for column := -1; column < dim; column++ {
+		var page bytes.Buffer
+		var columnType int32
+		var columnName string
+		if column < 0 {
+			columnType, columnName = parquetByteArray, "id"
+			for _, id := range e.ids {
+				_ = binary.Write(&page, binary.LittleEndian, uint32(len(id)))
+				page.WriteString(id)
+			}
+		} else {
+			columnType, columnName = parquetFloat, fmt.Sprintf("factor_%d", column)
+			for _, factor := range e.factors {
+				_ = binary.Write(&page, binary.LittleEndian, math.Float32bits(factor[column]))
+			}
+		}
+		if page.Len() > math.MaxInt32 {
+			return errors.Errorf("column %s of Parquet is too large", columnName)
+		}
+		var pageHeader thriftCompact
+		pageHeader.writeI32(1, parquetDataPage)
+		pageHeader.writeI32(2, int32(page.Len()))
+		pageHeader.writeI32(3, int32(page.Len()))
+		pageHeader.beginStruct(5)
+		pageHeader.writeI32(1, int32(len(e.ids)))
+		pageHeader.writeI32(2, parquetPlain)
+		pageHeader.writeI32(3, parquetRLE)
+		pageHeader.writeI32(4, parquetRLE)
+		pageHeader.endStruct()
+		pageHeader.endStruct()
+		pageOffset, chunkSize := offset, int64(pageHeader.Len()+page.Len())
+		if err := write(pageHeader.Bytes()); err != nil {
+			return err
+		}
+		if err := write(page.Bytes()); err != nil {
+			return err
+		}
+		// column chunk
+		var chunk thriftCompact
+		chunk.writeI64(2, pageOffset)
+		chunk.beginStruct(3)
+		chunk.writeI32(1, columnType)
+		chunk.writeI32List(2, parquetPlain)
+		chunk.writeStringList(3, columnName)
+		chunk.writeI32(4, 0)
+		chunk.writeI64(5, int64(len(e.ids)))
+		chunk.writeI64(6, chunkSize)
+		chunk.writeI64(7, chunkSize)
+		chunk.writeI64(9, pageOffset)
+		chunk.endStruct()
+		chunk.endStruct()
+		columns = append(columns, chunk)
+		totalSize += chunkSize
+	}
+	// write file metadata
+	var metadata thriftCompact
+	metadata.writeI32(1, 1)
+	metadata.beginList(2, thriftStruct, dim+2)
+	metadata.beginElement()
+	metadata.writeString(4, "schema")
+	metadata.writeI32(5, int32(dim+1))
+	metadata.endStruct()
+	metadata.beginElement()
+	metadata.writeI32(1, parquetByteArray)
+	metadata.writeI32(3, parquetRequired)
+	metadata.writeString(4, "id")
+	metadata.writeI32(6, parquetUTF8)
+	metadata.endStruct()
+	for column := 0; column < dim; column++ {
+		metadata.beginElement()
+		metadata.writeI32(1, parquetFloat)
+		metadata.writeI32(3, parquetRequired)
+		metadata.writeString(4, fmt.Sprintf("factor_%d", column))
+		metadata.endStruct()
+	}
+	metadata.endList()
+	metadata.writeI64(3, int64(len(e.ids)))
+	metadata.beginList(4, thriftStruct, 1)
+	metadata.beginElement()
+	metadata.beginList(1, thriftStruct, len(columns))
+	for _, column := range columns {
+		metadata.Write(column.Bytes())
+	}
+	metadata.endList()
+	metadata.writeI64(2, totalSize)
+	metadata.writeI64(3, int64(len(e.ids)))
+	metadata.endStruct()
+	metadata.endList()
+	metadata.writeString(6, "gorse")
+	metadata.endStruct()
+	if err := write(metadata.Bytes()); err != nil {
+		return err
+	}
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer, uint32(metadata.Len()))
+	copy(footer[4:], "PAR1")
+	return write(footer)
+}
+
+// Types of the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompact encodes a struct in the Thrift compact protocol used by metadata of Parquet. The outermost struct is
+// begun implicitly.
+type thriftCompact struct {
+	bytes.Buffer
+	lastField  int16
+	lastFields []int16
+}
+
+func (t *thriftCompact) writeUvarint(value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	t.Write(buf[:binary.PutUvarint(buf, value)])
+}
+
+func (t *thriftCompact) writeVarint(value int64) {
+	t.writeUvarint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (t *thriftCompact) writeField(id int16, fieldType byte) {
+	if delta := id - t.lastField; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		t.WriteByte(fieldType)
+		t.writeVarint(int64(id))
+	}
+	t.lastField = id
+}
+
+func (t *thriftCompact) writeI32(id int16, value int32) {
+	t.writeField(id, thriftI32)
+	t.writeVarint(int64(value))
+}
+
+func (t *thriftCompact) writeI64(id int16, value int64) {
+	t.writeField(id, thriftI64)
+	t.writeVarint(value)
+}
+
+func (t *thriftCompact) writeString(id int16, value string) {
+	t.writeField(id, thriftBinary)
+	t.writeUvarint(uint64(len(value)))
+	t.WriteString(value)
+}
+
+func (t *thriftCompact) writeListHeader(elemType byte, size int) {
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.WriteByte(0xf0 | elemType)
+		t.writeUvarint(uint64(size))
+	}
+}
+
+func (t *thriftCompact) writeI32List(id int16, values ...int32) {
+	t.writeField(id, thriftList)
+	t.writeListHeader(thriftI32, len(values))
+	for _, value := range values {
+		t.writeVarint(int64(value))
+	}
+}
+
+func (t *thriftCompact) writeStringList(id int16, values ...string) {
+	t.writeField(id, thriftList)
+	t.writeListHeader(thriftBinary, len(values))
+	for _, value := range values {
+		t.writeUvarint(uint64(len(value)))
+		t.WriteString(value)
+	}
+}
+
+func (t *thriftCompact) beginStruct(id int16) {
+	t.writeField(id, thriftStruct)
+	t.beginElement()
+}
+
+// beginElement begins a struct as an element of a list.
+func (t *thriftCompact) beginElement() {
+	t.lastFields = append(t.lastFields, t.lastField)
+	t.lastField = 0
+}
+
+// endStruct ends a struct. The outermost struct is ended at last.
+func (t *thriftCompact) endStruct() {
+	t.WriteByte(0)
+	t.pop()
+}
+
+func (t *thriftCompact) beginList(id int16, elemType byte, size int) {
+	t.writeField(id, thriftList)
+	t.writeListHeader(elemType, size)
+	t.lastFields = append(t.lastFields, t.lastField)
+}
+
+func (t *thriftCompact) endList() {
+	t.pop()
+}
+
+func (t *thriftCompact) pop() {
+	if len(t.lastFields) > 0 {
+		t.lastField = t.lastFields[len(t.lastFields)-1]
+		t.lastFields = t.lastFields[:len(t.lastFields)-1]
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/model"
+	"github.com/zhenghaoz/gorse/model/ranking"
+)
+
+func newEmbeddingModel() ranking.MatrixFactorization {
+	dataset := ranking.NewMapIndexDataset()
+	dataset.AddFeedback("0", "0", true)
+	dataset.AddFeedback("10", "1", true)
+	// user without feedback
+	dataset.UserIndex.Add("2")
+	dataset.UserFeedback = append(dataset.UserFeedback, nil)
+	bpr := ranking.NewBPR(model.Params{model.NFactors: 2})
+	bpr.Init(dataset)
+	bpr.UserFactor = [][]float32{{1, 2}, {3, 4}, {5, 6}}
+	bpr.ItemFactor = [][]float32{{7, 8}, {9, 10}}
+	return bpr
+}
+
+// readThrift decodes a struct in the Thrift compact protocol. Integers are decoded into int64, binaries into string,
+// lists into []interface{} and structs into map[int16]interface{}.
+func readThrift(t *testing.T, r *bytes.Reader) map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var lastField int16
+	for {
+		header, err := r.ReadByte()
+		assert.NoError(t, err)
+		if header == 0 {
+			return fields
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			lastField += delta
+		} else {
+			id, err := binary.ReadVarint(r)
+			assert.NoError(t, err)
+			lastField = int16(id)
+		}
+		fields[lastField] = readThriftValue(t, r, header&0x0f)
+	}
+}
+
+func readThriftValue(t *testing.T, r *bytes.Reader, valueType byte) interface{} {
+	switch valueType {
+	case thriftI32, thriftI64:
+		value, err := binary.ReadVarint(r)
+		assert.NoError(t, err)
+		return value
+	case thriftBinary:
+		n, err := binary.ReadUvarint(r)
+		assert.NoError(t, err)
+		buf := make([]byte, n)
+		_, err = r.Read(buf)
+		assert.NoError(t, err)
+		return string(buf)
+	case thriftList:
+		header, err := r.ReadByte()
+		assert.NoError(t, err)
+		size := uint64(header >> 4)
+		if size == 15 {
+			size, err = binary.ReadUvarint(r)
+			assert.NoError(t, err)
+		}
+		values := make([]interface{}, size)
+		for i := range values {
+			values[i] = readThriftValue(t, r, header&0x0f)
+		}
+		return values
+	case thriftStruct:
+		return readThrift(t, r)
+	}
+	t.Fatalf("unexpected type %d", valueType)
+	return nil
+}
+
+func TestMaster_ExportEmbeddings(t *testing.T) {
+	s, cookie := newMockServer(t)
+	defer s.Close(t)
+
+	// no valid ranking model
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.Header.Set("Cookie", cookie)
+	w := httptest.NewRecorder()
+	s.exportUserEmbeddings(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+
+	// unsupported format
+	s.RankingModel = newEmbeddingModel()
+	s.RankingModelVersion = 123
+	req = httptest.NewRequest("GET", "https://example.com/?format=csv", nil)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	s.exportUserEmbeddings(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Result().StatusCode)
+
+	// export users in JSON
+	req = httptest.NewRequest("GET", "https://example.com/", nil)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	s.exportUserEmbeddings(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment;filename=users.json", w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "7b", w.Header().Get("X-Model-Version"))
+	assert.JSONEq(t, marshal(t, []Embedding{{"0", []float32{1, 2}}, {"10", []float32{3, 4}}}), w.Body.String())
+
+	// export items in NPY
+	req = httptest.NewRequest("GET", "https://example.com/?format=npy", nil)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	s.exportItemEmbeddings(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "attachment;filename=items.npy", w.Header().Get("Content-Disposition"))
+	body := w.Body.Bytes()
+	assert.Equal(t, []byte("\x93NUMPY\x01\x00"), body[:8])
+	headerLen := int(binary.LittleEndian.Uint16(body[8:]))
+	assert.Zero(t, (10+headerLen)%64)
+	assert.Regexp(t, `^\{'descr': \[\('id', '<U1'\), \('embedding', '<f4', \(2,\)\)\], 'fortran_order': False, 'shape': \(2,\), \} *\n$`,
+		string(body[10:10+headerLen]))
+	var rows [2]struct {
+		Id        uint32
+		Embedding [2]float32
+	}
+	assert.NoError(t, binary.Read(bytes.NewReader(body[10+headerLen:]), binary.LittleEndian, &rows))
+	assert.Equal(t, uint32('0'), rows[0].Id)
+	assert.Equal(t, [2]float32{7, 8}, rows[0].Embedding)
+	assert.Equal(t, uint32('1'), rows[1].Id)
+	assert.Equal(t, [2]float32{9, 10}, rows[1].Embedding)
+	assert.Len(t, body, 10+headerLen+2*12)
+
+	// export users in Parquet
+	req = httptest.NewRequest("GET", "https://example.com/?format=parquet", nil)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	s.exportUserEmbeddings(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "attachment;filename=users.parquet", w.Header().Get("Content-Disposition"))
+	body = w.Body.Bytes()
+	assert.Equal(t, "PAR1", string(body[:4]))
+	assert.Equal(t, "PAR1", string(body[len(body)-4:]))
+	metadataLen := int(binary.LittleEndian.Uint32(body[len(body)-8:]))
+	metadata := readThrift(t, bytes.NewReader(body[len(body)-8-metadataLen:len(body)-8]))
+	assert.Equal(t, int64(2), metadata[3])
+	assert.Equal(t, []interface{}{
+		map[int16]interface{}{4: "schema", 5: int64(3)},
+		map[int16]interface{}{1: int64(parquetByteArray), 3: int64(parquetRequired), 4: "id", 6: int64(parquetUTF8)},
+		map[int16]interface{}{1: int64(parquetFloat), 3: int64(parquetRequired), 4: "factor_0"},
+		map[int16]interface{}{1: int64(parquetFloat), 3: int64(parquetRequired), 4: "factor_1"},
+	}, metadata[2])
+	rowGroups := metadata[4].([]interface{})
+	assert.Len(t, rowGroups, 1)
+	columns := rowGroups[0].(map[int16]interface{})[1].([]interface{})
+	assert.Len(t, columns, 3)
+	var values []interface{}
+	for _, column := range columns {
+		columnMetadata := column.(map[int16]interface{})[3].(map[int16]interface{})
+		assert.Equal(t, int64(2), columnMetadata[5])
+		r := bytes.NewReader(body[columnMetadata[9].(int64):])
+		pageHeader := readThrift(t, r)
+		assert.Equal(t, int64(2), pageHeader[5].(map[int16]interface{})[1])
+		page := make([]byte, pageHeader[3].(int64))
+		_, err := r.Read(page)
+		assert.NoError(t, err)
+		if columnMetadata[1] == int64(parquetByteArray) {
+			for len(page) > 0 {
+				n := binary.LittleEndian.Uint32(page)
+				values = append(values, string(page[4:4+n]))
+				page = page[4+n:]
+			}
+		} else {
+			for i := 0; i < len(page); i += 4 {
+				values = append(values, math.Float32frombits(binary.LittleEndian.Uint32(page[i:])))
+			}
+		}
+	}
+	assert.Equal(t, []interface{}{"0", "10", float32(1), float32(3), float32(2), float32(4)}, values)
+}
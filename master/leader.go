@@ -0,0 +1,332 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+)
+
+const (
+	LeaderElectionRedis      = "redis"
+	LeaderElectionKubernetes = "kubernetes"
+)
+
+// newLeaderLock creates the lock of leader election. Nil is returned if leader election is disabled.
+func (m *Master) newLeaderLock() (cache.Locker, error) {
+	switch m.Config().Master.LeaderElection {
+	case LeaderElectionRedis:
+		locker, ok := m.CacheClient.(cache.Locker)
+		if !ok {
+			return nil, errors.NotSupportedf("leader election in cache store %s",
+				log.RedactDBURL(m.Config().Database.CacheStore))
+		}
+		return locker, nil
+	case LeaderElectionKubernetes:
+		locker, err := newKubernetesLease(serviceAccountPath)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return locker, nil
+	}
+	return nil, nil
+}
+
+// leaderIdentity returns the identity of the master node in leader election.
+func leaderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "master"
+	}
+	return fmt.Sprintf("%s_%d", hostname, os.Getpid())
+}
+
+// waitForLeadership blocks until the master node holds the leader lock or the context is done. The lock is retried
+// every third of the lease duration.
+func (m *Master) waitForLeadership(ctx context.Context) error {
+	name, ttl := m.Config().Master.LeaderLeaseName, m.Config().Master.LeaderLeaseDuration
+	log.Logger().Info("wait for leadership", zap.String("name", name), zap.String("identity", m.leaderIdentity))
+	for {
+		locked, err := m.leaderLock.Lock(ctx, name, m.leaderIdentity, ttl)
+		if err != nil {
+			log.Logger().Error("failed to acquire leader lock", zap.Error(err))
+		} else if locked {
+			log.Logger().Info("become leader", zap.String("name", name), zap.String("identity", m.leaderIdentity))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(ttl / 3):
+		}
+	}
+}
+
+// renewLeadership renews the leader lock every third of the lease duration until the context is done. The leadership is
+// lost if the lock is held by another master node or the lease expires before renewed, and onLost is called.
+func (m *Master) renewLeadership(ctx context.Context, onLost func()) {
+	name, ttl := m.Config().Master.LeaderLeaseName, m.Config().Master.LeaderLeaseDuration
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	lastRenewTime := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		locked, err := m.leaderLock.Lock(ctx, name, m.leaderIdentity, ttl)
+		if err == nil && locked {
+			lastRenewTime = time.Now()
+			continue
+		} else if err != nil && time.Since(lastRenewTime) < ttl {
+			log.Logger().Error("failed to renew leader lock", zap.Error(err))
+			continue
+		}
+		log.Logger().Error("lost leadership", zap.String("name", name), zap.Error(err))
+		onLost()
+		return
+	}
+}
+
+// elect blocks until the master node becomes the leader if leader election is enabled. The process exits once the
+// leadership is lost so that the new leader serves alone.
+func (m *Master) elect() {
+	var err error
+	if m.leaderLock, err = m.newLeaderLock(); err != nil {
+		log.Logger().Fatal("failed to create leader lock", zap.Error(err))
+	} else if m.leaderLock == nil {
+		return
+	}
+	m.leaderIdentity = leaderIdentity()
+	var ctx context.Context
+	ctx, m.stopLeadership = context.WithCancel(context.Background())
+	if err = m.waitForLeadership(ctx); err != nil {
+		log.Logger().Fatal("failed to become leader", zap.Error(err))
+	}
+	go m.renewLeadership(ctx, func() {
+		log.Logger().Fatal("exit since the leadership is lost")
+	})
+}
+
+// resign stops renewing the leader lock and releases it so that another master node takes over at once.
+func (m *Master) resign() {
+	if m.leaderLock == nil {
+		return
+	}
+	m.stopLeadership()
+	if err := m.leaderLock.Unlock(context.Background(), m.Config().Master.LeaderLeaseName, m.leaderIdentity); err != nil {
+		log.Logger().Error("failed to release leader lock", zap.Error(err))
+	}
+}
+
+// serviceAccountPath is the directory of the service account mounted in Kubernetes pods.
+const serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTimeLayout is the layout of MicroTime in Kubernetes APIs.
+const microTimeLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// kubernetesLease is a lock implemented by Lease objects of Kubernetes (coordination.k8s.io/v1). Leases are updated
+// with resource versions, so that only one of concurrent updates succeeds.
+type kubernetesLease struct {
+	client    *http.Client
+	endpoint  string // endpoint of leases in the namespace
+	tokenFile string // token is read for each request since it is rotated
+}
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// newKubernetesLease creates the lock by the service account of the pod.
+func newKubernetesLease(accountPath string) (*kubernetesLease, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.NotSupportedf("leader election out of Kubernetes")
+	}
+	namespace, err := os.ReadFile(filepath.Join(accountPath, "namespace"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	caCert, err := os.ReadFile(filepath.Join(accountPath, "ca.crt"))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, errors.NotValidf("CA certificate of service account")
+	}
+	return &kubernetesLease{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+			Timeout:   10 * time.Second,
+		},
+		endpoint: fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+			net.JoinHostPort(host, port), strings.TrimSpace(string(namespace))),
+		tokenFile: filepath.Join(accountPath, "token"),
+	}, nil
+}
+
+// Lock acquires the Lease for the owner if it is not held or expired, or renews the Lease if the owner holds it.
+func (k *kubernetesLease) Lock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now().Format(microTimeLayout)
+	duration := int(math.Ceil(ttl.Seconds()))
+	l, err := k.get(ctx, name)
+	if errors.Is(err, errors.NotFound) {
+		l = &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: name},
+			Spec: leaseSpec{
+				HolderIdentity:       owner,
+				LeaseDurationSeconds: duration,
+				AcquireTime:          now,
+				RenewTime:            now,
+			},
+		}
+		err = k.request(ctx, http.MethodPost, k.endpoint, l, nil)
+		if errors.Is(err, errors.AlreadyExists) {
+			return false, nil
+		}
+		return err == nil, errors.Trace(err)
+	} else if err != nil {
+		return false, errors.Trace(err)
+	}
+	if l.Spec.HolderIdentity != owner {
+		if l.Spec.HolderIdentity != "" && !l.expired() {
+			return false, nil
+		}
+		l.Spec.AcquireTime = now
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.HolderIdentity = owner
+	l.Spec.LeaseDurationSeconds = duration
+	l.Spec.RenewTime = now
+	err = k.request(ctx, http.MethodPut, k.endpoint+"/"+name, l, nil)
+	if errors.Is(err, errors.AlreadyExists) {
+		return false, nil
+	}
+	return err == nil, errors.Trace(err)
+}
+
+// Unlock releases the Lease if the owner holds it.
+func (k *kubernetesLease) Unlock(ctx context.Context, name, owner string) error {
+	l, err := k.get(ctx, name)
+	if errors.Is(err, errors.NotFound) {
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	if l.Spec.HolderIdentity != owner {
+		return nil
+	}
+	l.Spec.HolderIdentity = ""
+	err = k.request(ctx, http.MethodPut, k.endpoint+"/"+name, l, nil)
+	if errors.Is(err, errors.AlreadyExists) {
+		return nil
+	}
+	return errors.Trace(err)
+}
+
+func (k *kubernetesLease) get(ctx context.Context, name string) (*lease, error) {
+	var l lease
+	if err := k.request(ctx, http.MethodGet, k.endpoint+"/"+name, nil, &l); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &l, nil
+}
+
+// request sends a request to the Kubernetes API server. NotFound is returned for 404 and AlreadyExists is returned for
+// 409, which means the Lease exists or has been updated by others.
+func (k *kubernetesLease) request(ctx context.Context, method, url string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	token, err := os.ReadFile(k.tokenFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.NotFoundf("lease %s", url)
+	case resp.StatusCode == http.StatusConflict:
+		return errors.AlreadyExistsf("lease %s", url)
+	case resp.StatusCode >= http.StatusMultipleChoices:
+		message, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("%s %s: %s %s", method, url, resp.Status, message)
+	}
+	if result != nil {
+		return errors.Trace(json.NewDecoder(resp.Body).Decode(result))
+	}
+	return nil
+}
+
+// expired returns true if the Lease has not been renewed within its duration.
+func (l *lease) expired() bool {
+	renewTime, err := time.Parse(time.RFC3339Nano, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return time.Since(renewTime) > time.Duration(l.Spec.LeaseDurationSeconds)*time.Second
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+func newLeaderCandidate(locker cache.Locker, identity string) *Master {
+	m := &Master{leaderLock: locker, leaderIdentity: identity}
+	m.Settings = config.NewSettings()
+	m.SetConfig(config.GetDefaultConfig())
+	m.Config().Master.LeaderLeaseDuration = 300 * time.Millisecond
+	return m
+}
+
+func TestLeaderElection(t *testing.T) {
+	redisServer, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer redisServer.Close()
+	client, err := cache.Open("redis://"+redisServer.Addr(), "")
+	assert.NoError(t, err)
+	defer client.Close()
+	locker := client.(cache.Locker)
+
+	// the first candidate becomes leader
+	leader := newLeaderCandidate(locker, "1")
+	var ctx context.Context
+	ctx, leader.stopLeadership = context.WithCancel(context.Background())
+	assert.NoError(t, leader.waitForLeadership(ctx))
+	lost := make(chan struct{})
+	go leader.renewLeadership(ctx, func() { close(lost) })
+
+	// the second candidate waits until the leader resigns
+	standby := newLeaderCandidate(locker, "2")
+	elected := make(chan error)
+	go func() {
+		elected <- standby.waitForLeadership(context.Background())
+	}()
+	select {
+	case <-elected:
+		assert.Fail(t, "standby became leader while the leader was alive")
+	case <-time.After(500 * time.Millisecond):
+	}
+	leader.resign()
+	select {
+	case err = <-elected:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "standby didn't become leader after the leader resigned")
+	}
+	select {
+	case <-lost:
+		assert.Fail(t, "resigned leader called onLost")
+	default:
+	}
+
+	// the leadership is lost once the lock is taken by others
+	ctx, standby.stopLeadership = context.WithCancel(context.Background())
+	defer standby.stopLeadership()
+	lost = make(chan struct{})
+	go standby.renewLeadership(ctx, func() { close(lost) })
+	assert.NoError(t, redisServer.Set(cache.Key(cache.LeaderLease, standby.Config().Master.LeaderLeaseName), "3"))
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		assert.Fail(t, "lost leadership wasn't detected")
+	}
+}
+
+// mockLeaseServer serves Leases of a namespace like the Kubernetes API server.
+type mockLeaseServer struct {
+	mu      sync.Mutex
+	leases  map[string]lease
+	version int
+}
+
+func (s *mockLeaseServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		l, exist := s.leases[path.Base(r.URL.Path)]
+		if !exist {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(l)
+	case http.MethodPost, http.MethodPut:
+		var l lease
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		prev, exist := s.leases[l.Metadata.Name]
+		if (r.Method == http.MethodPost && exist) ||
+			(r.Method == http.MethodPut && (!exist || prev.Metadata.ResourceVersion != l.Metadata.ResourceVersion)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		s.version++
+		l.Metadata.ResourceVersion = strconv.Itoa(s.version)
+		s.leases[l.Metadata.Name] = l
+		_ = json.NewEncoder(w).Encode(l)
+	}
+}
+
+func TestKubernetesLease(t *testing.T) {
+	server := &mockLeaseServer{leases: make(map[string]lease)}
+	apiServer := httptest.NewServer(server)
+	defer apiServer.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("token\n"), 0600))
+	locker := &kubernetesLease{
+		client:    apiServer.Client(),
+		endpoint:  apiServer.URL + "/apis/coordination.k8s.io/v1/namespaces/default/leases",
+		tokenFile: tokenFile,
+	}
+	ctx := context.Background()
+
+	// create lease
+	locked, err := locker.Lock(ctx, "gorse-master", "1", 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.Equal(t, "1", server.leases["gorse-master"].Spec.HolderIdentity)
+	assert.Equal(t, 2, server.leases["gorse-master"].Spec.LeaseDurationSeconds)
+	// renew lease
+	locked, err = locker.Lock(ctx, "gorse-master", "1", 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	// lease held by another owner
+	locked, err = locker.Lock(ctx, "gorse-master", "2", 2*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, locked)
+	assert.NoError(t, locker.Unlock(ctx, "gorse-master", "2"))
+	assert.Equal(t, "1", server.leases["gorse-master"].Spec.HolderIdentity)
+	// take over expired lease
+	l := server.leases["gorse-master"]
+	l.Spec.RenewTime = time.Now().Add(-time.Minute).Format(microTimeLayout)
+	server.leases["gorse-master"] = l
+	locked, err = locker.Lock(ctx, "gorse-master", "2", 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.Equal(t, "2", server.leases["gorse-master"].Spec.HolderIdentity)
+	assert.Equal(t, 1, server.leases["gorse-master"].Spec.LeaseTransitions)
+	// take over released lease
+	assert.NoError(t, locker.Unlock(ctx, "gorse-master", "2"))
+	locked, err = locker.Lock(ctx, "gorse-master", "1", 2*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.Equal(t, 2, server.leases["gorse-master"].Spec.LeaseTransitions)
+}
@@ -37,6 +37,7 @@ type LocalCache struct {
 	ClickModelVersion   int64
 	ClickModelScore     click.Score
 	ClickModel          click.FactorizationMachine
+	ObjectiveModels     map[string]click.FactorizationMachine
 }
 
 // LoadLocalCache loads local cache from a file.
@@ -98,6 +99,11 @@ func LoadLocalCache(path string) (*LocalCache, error) {
 	if err != nil {
 		return state, errors.Trace(err)
 	}
+	// 10. objective models
+	state.ObjectiveModels, err = click.UnmarshalObjectives(f)
+	if err != nil {
+		return state, errors.Trace(err)
+	}
 	return state, nil
 }
 
@@ -157,5 +163,10 @@ func (c *LocalCache) WriteLocalCache() error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// 10. objective models
+	err = click.MarshalObjectives(f, c.ObjectiveModels)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
@@ -72,6 +72,7 @@ func TestLocalCache(t *testing.T) {
 	cache.ClickModel = fm
 	cache.ClickModelVersion = 456
 	cache.ClickModelScore = click.Score{Precision: 1, RMSE: 100, Task: click.FMClassification}
+	cache.ObjectiveModels = map[string]click.FactorizationMachine{"purchase": fm}
 	assert.NoError(t, cache.WriteLocalCache())
 
 	read, err := LoadLocalCache(path)
@@ -83,6 +84,7 @@ func TestLocalCache(t *testing.T) {
 	assert.NotNil(t, read.ClickModel)
 	assert.Equal(t, int64(456), read.ClickModelVersion)
 	assert.Equal(t, click.Score{Precision: 1, RMSE: 100, Task: click.FMClassification}, read.ClickModelScore)
+	assert.Contains(t, read.ObjectiveModels, "purchase")
 
 	// delete test file
 	assert.NoError(t, os.Remove(path))
@@ -27,7 +27,9 @@ import (
 	"github.com/ReneKroon/ttlcache/v2"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/juju/errors"
+	"github.com/samber/lo"
 	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/accel"
 	"github.com/zhenghaoz/gorse/base/encoding"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/base/parallel"
@@ -39,6 +41,8 @@ import (
 	"github.com/zhenghaoz/gorse/server"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/feature"
+	"github.com/zhenghaoz/gorse/storage/search"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
@@ -61,11 +65,27 @@ type Master struct {
 	jobsScheduler *task.JobsScheduler
 	cacheFile     string
 	managedMode   bool
+	configFile    string // config file to reload
+	oneModel      bool
+
+	// dynamic config
+	staticConfig      *config.Config // config without dynamic overrides
+	dynamicConfigLock sync.Mutex
+
+	// leader election
+	leaderLock     cache.Locker
+	leaderIdentity string
+	stopLeadership context.CancelFunc
 
 	// cluster meta cache
 	ttlCache       *ttlcache.Cache
 	nodesInfo      map[string]*Node
 	nodesInfoMutex sync.RWMutex
+	startTime      time.Time
+
+	// user shards of workers
+	userShards      *UserShards
+	userShardsMutex sync.Mutex
 
 	// ranking dataset
 	rankingTrainSet  *ranking.DataSet
@@ -73,9 +93,11 @@ type Master struct {
 	rankingDataMutex sync.RWMutex
 
 	// click dataset
-	clickTrainSet  *click.Dataset
-	clickTestSet   *click.Dataset
-	clickDataMutex sync.RWMutex
+	clickTrainSet      *click.Dataset
+	clickTestSet       *click.Dataset
+	objectiveTrainSets map[string]*click.Dataset
+	objectiveTestSets  map[string]*click.Dataset
+	clickDataMutex     sync.RWMutex
 
 	// ranking model
 	rankingModelName     string
@@ -89,8 +111,15 @@ type Master struct {
 	clickModelSearcher *click.ModelSearcher
 
 	localCache *LocalCache
+	alerter    *Alerter
+
+	// consistency check
+	consistencyCheckMutex  sync.Mutex
+	consistencyReport      *ConsistencyReport
+	consistencyReportMutex sync.RWMutex
 
 	// events
+	schedule     *Schedule
 	fitTicker    *time.Ticker
 	importedChan *parallel.ConditionChannel // feedback inserted events
 	loadDataChan *parallel.ConditionChannel // dataset loaded events
@@ -113,13 +142,25 @@ func NewMaster(cfg *config.Config, cacheFile string, managedMode bool) *Master {
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 	// create task monitor
 	taskMonitor := task.NewTaskMonitor()
+	taskMonitor.Journal = task.NewJournal(cfg.Master.TaskLogSize)
 	for _, taskName := range []string{TaskLoadDataset, TaskFindItemNeighbors, TaskFindUserNeighbors,
-		TaskFitRankingModel, TaskFitClickModel, TaskSearchRankingModel, TaskSearchClickModel,
+		TaskFindAlsoLikedItems, TaskFitRankingModel, TaskFitClickModel, TaskSearchRankingModel, TaskSearchClickModel,
 		TaskCacheGarbageCollection} {
 		taskMonitor.Pending(taskName)
 	}
-	return &Master{
+	// create schedule of offline jobs
+	schedule, err := NewSchedule(&cfg.Master, time.Now())
+	if err != nil {
+		log.Logger().Fatal("failed to create schedule", zap.Error(err))
+	}
+	// select accelerator to train models
+	if err = accel.Use(cfg.Recommend.Collaborative.Accelerator); err != nil {
+		log.Logger().Fatal("failed to use accelerator",
+			zap.String("accelerator", cfg.Recommend.Collaborative.Accelerator), zap.Error(err))
+	}
+	m := &Master{
 		nodesInfo: make(map[string]*Node),
+		startTime: time.Now(),
 		// create task monitor
 		cacheFile:     cacheFile,
 		managedMode:   managedMode,
@@ -140,7 +181,6 @@ func NewMaster(cfg *config.Config, cacheFile string, managedMode bool) *Master {
 		),
 		RestServer: server.RestServer{
 			Settings: &config.Settings{
-				Config:       cfg,
 				CacheClient:  cache.NoDatabase{},
 				DataClient:   data.NoDatabase{},
 				RankingModel: ranking.NewBPR(nil),
@@ -153,16 +193,23 @@ func NewMaster(cfg *config.Config, cacheFile string, managedMode bool) *Master {
 			HttpPort:   cfg.Master.HttpPort,
 			WebService: new(restful.WebService),
 		},
+		schedule:     schedule,
+		alerter:      NewAlerter(cfg.Alert),
 		fitTicker:    time.NewTicker(cfg.Recommend.Collaborative.ModelFitPeriod),
 		importedChan: parallel.NewConditionChannel(),
 		loadDataChan: parallel.NewConditionChannel(),
 		triggerChan:  parallel.NewConditionChannel(),
 	}
+	m.SetConfig(cfg)
+	return m
 }
 
 // Serve starts the master node.
 func (m *Master) Serve() {
 
+	// reload config once modified
+	m.watchConfig()
+
 	// load local cached model
 	var err error
 	m.localCache, err = LoadLocalCache(m.cacheFile)
@@ -196,6 +243,7 @@ func (m *Master) Serve() {
 		m.ClickModel = m.localCache.ClickModel
 		m.clickScore = m.localCache.ClickModelScore
 		m.ClickModelVersion = m.localCache.ClickModelVersion
+		m.ObjectiveModels = m.localCache.ObjectiveModels
 		RankingPrecision.Set(float64(m.clickScore.Precision))
 		RankingRecall.Set(float64(m.clickScore.Recall))
 		RankingAUC.Set(float64(m.clickScore.AUC))
@@ -206,52 +254,147 @@ func (m *Master) Serve() {
 	m.ttlCache = ttlcache.NewCache()
 	m.ttlCache.SetExpirationCallback(m.nodeDown)
 	m.ttlCache.SetNewItemCallback(m.nodeUp)
-	if err = m.ttlCache.SetTTL(m.Config.Master.MetaTimeout + 10*time.Second); err != nil {
+	if err = m.ttlCache.SetTTL(m.Config().Master.MetaTimeout + 10*time.Second); err != nil {
 		log.Logger().Fatal("failed to set TTL", zap.Error(err))
 	}
 
 	// connect data database
-	m.DataClient, err = data.Open(m.Config.Database.DataStore, m.Config.Database.DataTablePrefix)
+	m.DataClient, err = data.OpenWithReplica(m.Config().Database.DataStore, m.Config().Database.DataStoreReplica,
+		m.Config().Database.DataTablePrefix)
 	if err != nil {
 		log.Logger().Fatal("failed to connect data database", zap.Error(err),
-			zap.String("database", log.RedactDBURL(m.Config.Database.DataStore)))
+			zap.String("database", log.RedactDBURL(m.Config().Database.DataStore)))
+	}
+	m.DataClient = data.WithQueryLog(m.DataClient, m.Config().Database.DataStoreQueryLog())
+	if err = m.partitionFeedback(context.Background()); err != nil {
+		log.Logger().Fatal("failed to partition feedback", zap.Error(err))
 	}
 	if err = m.DataClient.Init(); err != nil {
 		log.Logger().Fatal("failed to init database", zap.Error(err))
 	}
 
+	// connect target data store and write to both data stores
+	var dataSource, dataTarget data.Database
+	if m.Config().Database.DataStoreTarget != "" {
+		dataTarget, err = data.Open(m.Config().Database.DataStoreTarget, m.Config().Database.DataTablePrefix)
+		if err != nil {
+			log.Logger().Fatal("failed to connect target data store", zap.Error(err),
+				zap.String("database", log.RedactDBURL(m.Config().Database.DataStoreTarget)))
+		}
+		if err = dataTarget.Init(); err != nil {
+			log.Logger().Fatal("failed to init target data store", zap.Error(err))
+		}
+		dataSource = m.DataClient
+		m.DataClient = data.WithDualWrite(m.DataClient, dataTarget)
+	}
+
 	// connect cache database
-	m.CacheClient, err = cache.Open(m.Config.Database.CacheStore, m.Config.Database.CacheTablePrefix)
+	m.CacheClient, err = cache.Open(m.Config().Database.CacheStore, m.Config().Database.CachePrefix())
 	if err != nil {
 		log.Logger().Fatal("failed to connect cache database", zap.Error(err),
-			zap.String("database", log.RedactDBURL(m.Config.Database.CacheStore)))
+			zap.String("database", log.RedactDBURL(m.Config().Database.CacheStore)))
 	}
+	cache.Configure(m.CacheClient, m.Config().Database.CacheStoreOptions())
 	if err = m.CacheClient.Init(); err != nil {
 		log.Logger().Fatal("failed to init database", zap.Error(err))
 	}
 
+	// connect search database
+	if m.Config().Database.SearchStore != "" {
+		m.SearchClient, err = search.Open(m.Config().Database.SearchStore, m.Config().Database.DataTablePrefix)
+		if err != nil {
+			log.Logger().Fatal("failed to connect search database", zap.Error(err),
+				zap.String("database", log.RedactDBURL(m.Config().Database.SearchStore)))
+		}
+		if err = m.SearchClient.Init(context.Background()); err != nil {
+			log.Logger().Fatal("failed to init database", zap.Error(err))
+		}
+	}
+
+	// connect feature store
+	if m.Config().Database.FeatureStore != "" {
+		m.FeatureClient, err = feature.Open(m.Config().Database.FeatureStore, m.Config().Database.FeatureStoreOptions())
+		if err != nil {
+			log.Logger().Fatal("failed to connect feature store", zap.Error(err),
+				zap.String("database", log.RedactDBURL(m.Config().Database.FeatureStore)))
+		}
+	}
+
+	// wait for leadership before serving
+	m.elect()
+
+	// override config by dynamic config
+	if _, err = m.applyDynamicConfig(context.Background()); err != nil {
+		log.Logger().Error("failed to apply dynamic config", zap.Error(err))
+	}
+
 	m.RestServer.HiddenItemsManager = server.NewHiddenItemsManager(&m.RestServer)
 	m.RestServer.PopularItemsCache = server.NewPopularItemsCache(&m.RestServer)
+	m.RestServer.FallbackUsageTracker = server.NewFallbackUsageTracker(&m.RestServer)
+	m.RestServer.QuotaManager = server.NewQuotaManager(&m.RestServer)
+	m.RestServer.SourceFeedbackTracker = server.NewSourceFeedbackTracker(&m.RestServer)
+	m.RestServer.AuditLogger = server.NewAuditLogger(&m.RestServer)
+	m.RestServer.FeedbackWAL = server.NewFeedbackWAL(&m.RestServer)
+	m.RestServer.FeedbackDeduplicator = server.NewFeedbackDeduplicator(&m.RestServer)
+	m.RestServer.SortedListCache = server.NewSortedListCache(&m.RestServer)
+	m.RestServer.ResultCache = server.NewResultCache(&m.RestServer)
+	m.RestServer.RuleManager = server.NewRuleManager(&m.RestServer)
+	m.RestServer.CategoryManager = server.NewCategoryManager(&m.RestServer)
+	m.RestServer.AliasManager = server.NewAliasManager(&m.RestServer)
+	m.RestServer.SegmentManager = server.NewSegmentManager(&m.RestServer)
+	m.RestServer.DataStoreBreaker = server.NewCircuitBreaker(&m.RestServer)
+	m.RestServer.Bidder = server.NewHTTPBidder(&m.RestServer)
+	m.RestServer.RerankScript = server.NewRerankScript(&m.RestServer)
+	if m.Config().Server.AsyncFeedback {
+		go m.RestServer.FeedbackWAL.Run()
+	}
+	if m.Config().Database.FeedbackPartitionInterval > 0 {
+		go m.RunFeedbackPartitionLoop()
+	}
+	if m.Config().Master.StoreMetricsPeriod > 0 {
+		go m.RunStoreMetricsLoop()
+	}
+	if dataTarget != nil {
+		go m.RunDataStoreCopy(dataSource, dataTarget)
+	}
+	if m.Config().Master.CacheWarmupPeriod > 0 {
+		go m.RunCacheWarmupLoop()
+	}
+	if m.Config().Master.ConsistencyPeriod > 0 {
+		go m.RunConsistencyCheckLoop()
+	}
 
 	if m.managedMode {
 		go m.RunManagedTasksLoop()
 	} else {
 		go m.RunPrivilegedTasksLoop()
-		log.Logger().Info("start model fit", zap.Duration("period", m.Config.Recommend.Collaborative.ModelFitPeriod))
+		log.Logger().Info("start model fit", zap.Duration("period", m.Config().Recommend.Collaborative.ModelFitPeriod))
 		go m.RunRagtagTasksLoop()
-		log.Logger().Info("start model searcher", zap.Duration("period", m.Config.Recommend.Collaborative.ModelSearchPeriod))
+		log.Logger().Info("start model searcher", zap.Duration("period", m.Config().Recommend.Collaborative.ModelSearchPeriod))
 	}
 
 	// start rpc server
 	go func() {
 		log.Logger().Info("start rpc server",
-			zap.String("host", m.Config.Master.Host),
-			zap.Int("port", m.Config.Master.Port))
-		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", m.Config.Master.Host, m.Config.Master.Port))
+			zap.String("host", m.Config().Master.Host),
+			zap.Int("port", m.Config().Master.Port))
+		lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", m.Config().Master.Host, m.Config().Master.Port))
 		if err != nil {
 			log.Logger().Fatal("failed to listen", zap.Error(err))
 		}
-		m.grpcServer = grpc.NewServer(grpc.MaxSendMsgSize(math.MaxInt))
+		opts := []grpc.ServerOption{grpc.MaxSendMsgSize(math.MaxInt)}
+		if m.Config().Master.SSLMode {
+			creds, err := protocol.NewServerCreds(&protocol.TLSConfig{
+				SSLCA:   m.Config().Master.SSLCA,
+				SSLCert: m.Config().Master.SSLCert,
+				SSLKey:  m.Config().Master.SSLKey,
+			})
+			if err != nil {
+				log.Logger().Fatal("failed to load tls config", zap.Error(err))
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		m.grpcServer = grpc.NewServer(opts...)
 		protocol.RegisterMasterServer(m.grpcServer, m)
 		if err = m.grpcServer.Serve(lis); err != nil {
 			log.Logger().Fatal("failed to start rpc server", zap.Error(err))
@@ -259,6 +402,7 @@ func (m *Master) Serve() {
 	}()
 
 	// start http server
+	m.RestServer.Health.Start()
 	m.StartHttpServer()
 }
 
@@ -268,8 +412,16 @@ func (m *Master) Shutdown() {
 	if err != nil {
 		log.Logger().Error("failed to shutdown http server", zap.Error(err))
 	}
+	// flush feedback
+	if m.Config().Server.AsyncFeedback {
+		if err = m.RestServer.FeedbackWAL.Flush(context.Background()); err != nil {
+			log.Logger().Error("failed to flush feedback write-ahead log", zap.Error(err))
+		}
+	}
 	// stop grpc server
 	m.grpcServer.GracefulStop()
+	// release leadership
+	m.resign()
 }
 
 func (m *Master) RunPrivilegedTasksLoop() {
@@ -281,7 +433,9 @@ func (m *Master) RunPrivilegedTasksLoop() {
 			NewFitRankingModelTask(m),
 			NewFindUserNeighborsTask(m),
 			NewFindItemNeighborsTask(m),
+			NewFindAlsoLikedItemsTask(m),
 		}
+		taskNames = lo.Map(tasks, func(t Task, _ int) string { return t.name() })
 		firstLoop = true
 	)
 	go func() {
@@ -293,12 +447,35 @@ func (m *Master) RunPrivilegedTasksLoop() {
 			time.Sleep(time.Second)
 		}
 	}()
+	if !m.schedule.NextWake(time.Now(), taskNames...).IsZero() {
+		// wake up for cron jobs
+		go func() {
+			for {
+				now := time.Now()
+				time.Sleep(sleepUntil(now, m.schedule.NextWake(now, taskNames...)))
+				m.importedChan.Signal()
+			}
+		}()
+	}
 	for {
 		select {
 		case <-m.fitTicker.C:
 		case <-m.importedChan.C:
 		}
 
+		// skip jobs in blackout windows
+		if !firstLoop && m.schedule.InBlackout(time.Now()) {
+			log.Logger().Debug("skip offline jobs in blackout window")
+			continue
+		}
+
+		// skip loading dataset if none of the jobs is due
+		now := time.Now()
+		dueTasks := lo.Filter(tasks, func(t Task, _ int) bool { return m.schedule.Due(t.name(), now) })
+		if !firstLoop && len(dueTasks) == 0 {
+			continue
+		}
+
 		// download dataset
 		err = m.runLoadDatasetTask()
 		if err != nil {
@@ -307,7 +484,7 @@ func (m *Master) RunPrivilegedTasksLoop() {
 		}
 		if m.rankingTrainSet.UserCount() == 0 && m.rankingTrainSet.ItemCount() == 0 && m.rankingTrainSet.Count() == 0 {
 			log.Logger().Warn("empty ranking dataset",
-				zap.Strings("positive_feedback_type", m.Config.Recommend.DataSource.PositiveFeedbackTypes))
+				zap.Strings("positive_feedback_type", m.Config().Recommend.DataSource.PositiveFeedbackTypes))
 			continue
 		}
 
@@ -317,8 +494,9 @@ func (m *Master) RunPrivilegedTasksLoop() {
 		}
 
 		var registeredTask []Task
-		for _, t := range tasks {
+		for _, t := range dueTasks {
 			if m.jobsScheduler.Register(t.name(), t.priority(), true) {
+				m.schedule.Commit(t.name(), now)
 				registeredTask = append(registeredTask, t)
 			}
 		}
@@ -348,6 +526,7 @@ func (m *Master) RunRagtagTasksLoop() {
 			NewSearchRankingModelTask(m),
 			NewSearchClickModelTask(m),
 		}
+		taskNames = lo.Map(tasks, func(t Task, _ int) string { return t.name() })
 	)
 	for {
 		if m.rankingTrainSet == nil || m.clickTrainSet == nil {
@@ -355,8 +534,13 @@ func (m *Master) RunRagtagTasksLoop() {
 			continue
 		}
 		var registeredTask []Task
+		now := time.Now()
 		for _, t := range tasks {
+			if !m.schedule.Due(t.name(), now) {
+				continue
+			}
 			if m.jobsScheduler.Register(t.name(), t.priority(), false) {
+				m.schedule.Commit(t.name(), now)
 				registeredTask = append(registeredTask, t)
 			}
 		}
@@ -371,7 +555,12 @@ func (m *Master) RunRagtagTasksLoop() {
 				}
 			}(t)
 		}
-		time.Sleep(m.Config.Recommend.Collaborative.ModelSearchPeriod)
+		// sleep until the next period or the next cron job
+		wait := m.Config().Recommend.Collaborative.ModelSearchPeriod
+		if wake := m.schedule.NextWake(now, taskNames...); !wake.IsZero() && sleepUntil(now, wake) < wait {
+			wait = sleepUntil(now, wake)
+		}
+		time.Sleep(wait)
 	}
 }
 
@@ -382,6 +571,7 @@ func (m *Master) RunManagedTasksLoop() {
 			NewFitRankingModelTask(m),
 			NewFindUserNeighborsTask(m),
 			NewFindItemNeighborsTask(m),
+			NewFindAlsoLikedItemsTask(m),
 		}
 		ragtagTasks = []Task{
 			NewCacheGarbageCollectionTask(m),
@@ -411,7 +601,7 @@ func (m *Master) RunManagedTasksLoop() {
 			}
 			if m.rankingTrainSet.UserCount() == 0 && m.rankingTrainSet.ItemCount() == 0 && m.rankingTrainSet.Count() == 0 {
 				log.Logger().Warn("empty ranking dataset",
-					zap.Strings("positive_feedback_type", m.Config.Recommend.DataSource.PositiveFeedbackTypes))
+					zap.Strings("positive_feedback_type", m.Config().Recommend.DataSource.PositiveFeedbackTypes))
 				return
 			}
 
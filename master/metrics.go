@@ -15,20 +15,34 @@
 package master
 
 import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/juju/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/samber/lo"
 	"github.com/scylladb/go-set/i32set"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/model/ranking"
 	"github.com/zhenghaoz/gorse/server"
 	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
 )
 
 const (
 	LabelFeedbackType = "feedback_type"
 	LabelStep         = "step"
 	LabelData         = "data"
+	LabelKey          = "key"
+	LabelQuantile     = "quantile"
+	LabelIssue        = "issue"
 )
 
 var (
@@ -224,8 +238,209 @@ var (
 		Subsystem: "master",
 		Name:      "memory_inuse_bytes",
 	}, []string{LabelData})
+
+	DataStoreRowsVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "data_store_rows",
+	}, []string{LabelData})
+	CacheKeysVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "cache_keys",
+	}, []string{LabelKey})
+	CacheSortedSetMembersVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "cache_sorted_set_members",
+	}, []string{LabelKey})
+	CacheSortedSetMaxMembersVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "cache_sorted_set_max_members",
+	}, []string{LabelKey})
+	CacheEstimatedBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "cache_estimated_bytes",
+	})
+	RecommendStalenessSecondsVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "recommend_staleness_seconds",
+	}, []string{LabelQuantile})
+	DataStoreTargetCopiedVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "data_store_target_copied",
+	}, []string{LabelData})
+	DataStoreTargetDifferences = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "data_store_target_differences",
+	})
+	ConsistencyIssuesVec = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "master",
+		Name:      "consistency_issues",
+	}, []string{LabelIssue})
 )
 
+// sortedSets are prefixes of sorted sets in the cache store, whose sizes are exported.
+var sortedSets = []string{
+	cache.OfflineRecommend, cache.CollaborativeRecommend, cache.UserNeighbors, cache.ItemNeighbors,
+	cache.IgnoreItems, cache.SuppressedItems, cache.AlsoLikedItems, cache.PopularItems, cache.LatestItems,
+	cache.ColdStartItems, cache.HiddenItemsV2, cache.ItemFrequency, cache.ItemImpressions, cache.LabeledItems,
+	cache.ActiveUsers,
+}
+
+// stalenessQuantiles are quantiles of ages of offline recommendations to be exported.
+var stalenessQuantiles = []float64{0.5, 0.9, 0.99, 1}
+
+// RunStoreMetricsLoop exports sizes of the data store and the cache store periodically, which surfaces capacity issues
+// before stores run out of memory.
+func (m *Master) RunStoreMetricsLoop() {
+	defer base.CheckPanic()
+	ticker := time.NewTicker(m.Config().Master.StoreMetricsPeriod)
+	defer ticker.Stop()
+	for {
+		ctx := context.Background()
+		if err := m.exportDataStoreMetrics(ctx); err != nil {
+			log.Logger().Error("failed to export data store metrics", zap.Error(err))
+		}
+		if err := m.exportCacheStoreMetrics(ctx); err != nil {
+			log.Logger().Error("failed to export cache store metrics", zap.Error(err))
+		}
+		<-ticker.C
+	}
+}
+
+// exportDataStoreMetrics counts users, items and feedback in the data store. Counts are saved to the cache store as
+// well, which are served by GET /api/stats/counts. Numbers of users and items of labels are served by GET /api/labels.
+func (m *Master) exportDataStoreMetrics(ctx context.Context) error {
+	batchSize := m.Config().Master.StoreMetricsBatch
+	counts := server.Counts{
+		FeedbackTypes: make(map[string]int),
+		Categories:    make(map[string]int),
+		UpdateTime:    time.Now(),
+	}
+	labelCounts := server.LabelCounts{
+		Users:      make(map[string]int),
+		Items:      make(map[string]int),
+		UpdateTime: counts.UpdateTime,
+	}
+	users, errChan := m.DataClient.GetUserStream(ctx, batchSize)
+	for batch := range users {
+		counts.Users += len(batch)
+		for _, user := range batch {
+			for _, label := range user.Labels {
+				labelCounts.Users[label]++
+			}
+		}
+	}
+	if err := <-errChan; err != nil {
+		return errors.Trace(err)
+	}
+	items, errChan := m.DataClient.GetItemStream(ctx, batchSize, nil)
+	for batch := range items {
+		counts.Items += len(batch)
+		for _, item := range batch {
+			for _, category := range item.Categories {
+				counts.Categories[category]++
+			}
+			for _, label := range item.Labels {
+				labelCounts.Items[label]++
+			}
+		}
+	}
+	if err := <-errChan; err != nil {
+		return errors.Trace(err)
+	}
+	feedback, errChan := m.DataClient.GetFeedbackStream(ctx, batchSize, nil, nil)
+	for batch := range feedback {
+		counts.Feedback += len(batch)
+		for _, f := range batch {
+			counts.FeedbackTypes[f.FeedbackType]++
+		}
+	}
+	if err := <-errChan; err != nil {
+		return errors.Trace(err)
+	}
+	DataStoreRowsVec.WithLabelValues("users").Set(float64(counts.Users))
+	DataStoreRowsVec.WithLabelValues("items").Set(float64(counts.Items))
+	DataStoreRowsVec.WithLabelValues("feedback").Set(float64(counts.Feedback))
+	if err := server.SaveLabelCounts(ctx, m.CacheClient, labelCounts); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(server.SaveCounts(ctx, m.CacheClient, counts))
+}
+
+// exportCacheStoreMetrics scans the cache store for numbers of keys, sizes of sorted sets, the estimated payload size
+// and ages of offline recommendations. The estimated size only counts names of keys and members and scores of sorted
+// sets, which is a lower bound of the memory used by the cache store.
+func (m *Master) exportCacheStoreMetrics(ctx context.Context) error {
+	isSortedSet := lo.SliceToMap(sortedSets, func(prefix string) (string, bool) { return prefix, true })
+	numKeys := make(map[string]int)
+	numMembers := make(map[string]int)
+	maxMembers := make(map[string]int)
+	var estimatedBytes int
+	var ages []float64
+	now := time.Now()
+	err := m.CacheClient.Scan(func(key string) error {
+		prefix := strings.Split(key, "/")[0]
+		numKeys[prefix]++
+		estimatedBytes += len(key)
+		switch {
+		case isSortedSet[prefix]:
+			scores, err := m.CacheClient.GetSorted(ctx, key, 0, -1)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			numMembers[prefix] += len(scores)
+			if len(scores) > maxMembers[prefix] {
+				maxMembers[prefix] = len(scores)
+			}
+			for _, score := range scores {
+				estimatedBytes += len(score.Id) + 8
+			}
+		case prefix == cache.LastUpdateUserRecommendTime:
+			updateTime, err := m.CacheClient.Get(ctx, key).Time()
+			if errors.Is(err, errors.NotFound) {
+				// the user has been removed during the scan
+				return nil
+			} else if err != nil {
+				return errors.Trace(err)
+			}
+			ages = append(ages, now.Sub(updateTime).Seconds())
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// labels of removed keys are dropped
+	CacheKeysVec.Reset()
+	for prefix, count := range numKeys {
+		CacheKeysVec.WithLabelValues(prefix).Set(float64(count))
+	}
+	CacheSortedSetMembersVec.Reset()
+	CacheSortedSetMaxMembersVec.Reset()
+	for prefix, count := range numMembers {
+		CacheSortedSetMembersVec.WithLabelValues(prefix).Set(float64(count))
+		CacheSortedSetMaxMembersVec.WithLabelValues(prefix).Set(float64(maxMembers[prefix]))
+	}
+	CacheEstimatedBytes.Set(float64(estimatedBytes))
+	RecommendStalenessSecondsVec.Reset()
+	if len(ages) > 0 {
+		sort.Float64s(ages)
+		for _, q := range stalenessQuantiles {
+			RecommendStalenessSecondsVec.WithLabelValues(strconv.FormatFloat(q, 'g', -1, 64)).
+				Set(ages[int(q*float64(len(ages)-1))])
+		}
+	}
+	return nil
+}
+
 type OnlineEvaluator struct {
 	ReadFeedbacks      []map[int32]*i32set.Set
 	PositiveFeedbacks  map[string][]lo.Tuple3[int32, int32, time.Time]
@@ -305,3 +520,102 @@ func (evaluator *OnlineEvaluator) Evaluate() []server.Measurement {
 	}
 	return measurements
 }
+
+// offlineMeasurements are names of measurements of models and offline recommendations.
+var offlineMeasurements = []string{
+	RankingModelNDCG, RankingModelRecall, RankingModelPrecision,
+	ClickModelPrecision, ClickModelRecall, ClickModelAUC,
+	RecommendationCoverage, RecommendationNovelty, RecommendationDiversity,
+}
+
+const (
+	// evaluateSampleSize is the number of users sampled to evaluate offline recommendations.
+	evaluateSampleSize = 1000
+	// evaluateTopK is the number of top recommended items to evaluate novelty and diversity.
+	evaluateTopK = 10
+)
+
+// evaluateRecommendations evaluates cached offline recommendations of sampled users:
+//   - coverage is the ratio of recommended items to all items.
+//   - novelty is the mean self-information -log2(p) of top items, where p is the smoothed ratio of users with positive
+//     feedback on an item.
+//   - diversity is the mean Jaccard distance between users with positive feedback on pairs of top items in a list.
+func (m *Master) evaluateRecommendations(ctx context.Context, dataset *ranking.DataSet, timestamp time.Time) ([]server.Measurement, error) {
+	users := dataset.UserIndex.GetNames()
+	if len(users) > evaluateSampleSize {
+		sample := make([]string, evaluateSampleSize)
+		for i, j := range rand.Perm(len(users))[:evaluateSampleSize] {
+			sample[i] = users[j]
+		}
+		users = sample
+	}
+	recommended := i32set.New()
+	itemUsers := make(map[int32][]int32)
+	var novelty, diversity float64
+	var numItems, numPairs int
+	for _, userId := range users {
+		recommends, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, userId), 0, -1)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var topItems []int32
+		for _, recommend := range recommends {
+			itemIndex := dataset.ItemIndex.ToNumber(recommend.Id)
+			if itemIndex == base.NotId {
+				continue
+			}
+			recommended.Add(itemIndex)
+			if len(topItems) < evaluateTopK {
+				topItems = append(topItems, itemIndex)
+			}
+		}
+		for i, itemIndex := range topItems {
+			if _, exist := itemUsers[itemIndex]; !exist {
+				itemUsers[itemIndex] = sortedUnique(dataset.ItemFeedback[itemIndex])
+			}
+			p := float64(len(itemUsers[itemIndex])+1) / float64(dataset.UserCount()+1)
+			novelty -= math.Log2(p)
+			numItems++
+			for _, otherIndex := range topItems[:i] {
+				diversity += 1 - jaccard(itemUsers[itemIndex], itemUsers[otherIndex])
+				numPairs++
+			}
+		}
+	}
+	var coverage float32
+	if dataset.ItemCount() > 0 {
+		coverage = float32(recommended.Size()) / float32(dataset.ItemCount())
+	}
+	if numItems > 0 {
+		novelty /= float64(numItems)
+	}
+	if numPairs > 0 {
+		diversity /= float64(numPairs)
+	}
+	return []server.Measurement{
+		{Name: RecommendationCoverage, Timestamp: timestamp, Value: coverage},
+		{Name: RecommendationNovelty, Timestamp: timestamp, Value: float32(novelty)},
+		{Name: RecommendationDiversity, Timestamp: timestamp, Value: float32(diversity)},
+	}, nil
+}
+
+// jaccard returns the Jaccard similarity between two sorted sets.
+func jaccard(a, b []int32) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			common++
+			i++
+			j++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
@@ -15,8 +15,16 @@
 package master
 
 import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/model/ranking"
 	"github.com/zhenghaoz/gorse/server"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"math"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -54,3 +62,105 @@ func TestOnlineEvaluator(t *testing.T) {
 		{"PositiveFeedbackRate/fork", time.Date(2005, 6, 15, 0, 0, 0, 0, time.UTC), 0},
 	}, result)
 }
+
+func TestMaster_EvaluateRecommendations(t *testing.T) {
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	timestamp := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	dataset := ranking.NewMapIndexDataset()
+	measurements, err := m.evaluateRecommendations(ctx, dataset, timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, []server.Measurement{
+		{Name: RecommendationCoverage, Timestamp: timestamp},
+		{Name: RecommendationNovelty, Timestamp: timestamp},
+		{Name: RecommendationDiversity, Timestamp: timestamp},
+	}, measurements)
+
+	// item i is liked by users 0..i
+	for i := 0; i < 4; i++ {
+		for u := 0; u <= i; u++ {
+			dataset.AddFeedback(strconv.Itoa(u), strconv.Itoa(i), true)
+		}
+	}
+	err = m.CacheClient.AddSorted(ctx,
+		cache.Sorted(cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"0", 2}, {"1", 1}, {"unknown", 0}}),
+		cache.Sorted(cache.Key(cache.OfflineRecommend, "1"), []cache.Scored{{"3", 1}}))
+	assert.NoError(t, err)
+	measurements, err = m.evaluateRecommendations(ctx, dataset, timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0.75), measurements[0].Value)
+	assert.InDelta(t, (-math.Log2(2.0/5)-math.Log2(3.0/5)-math.Log2(5.0/5))/3, measurements[1].Value, 1e-6)
+	assert.InDelta(t, 0.5, measurements[2].Value, 1e-6)
+}
+
+func TestJaccard(t *testing.T) {
+	assert.Zero(t, jaccard(nil, nil))
+	assert.Equal(t, 0.5, jaccard([]int32{1, 2}, []int32{2}))
+	assert.Equal(t, 0.4, jaccard([]int32{1, 2, 3}, []int32{2, 3, 4, 5}))
+}
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var metric dto.Metric
+	err := gauge.Write(&metric)
+	assert.NoError(t, err)
+	return metric.GetGauge().GetValue()
+}
+
+func TestMaster_ExportStoreMetrics(t *testing.T) {
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+
+	// count rows in the data store
+	err := m.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: "0", Labels: []string{"x"}}, {UserId: "1"}})
+	assert.NoError(t, err)
+	err = m.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "0", Categories: []string{"a", "b"}, Labels: []string{"x", "y"}},
+		{ItemId: "1", Categories: []string{"a"}, Labels: []string{"y"}},
+	})
+	assert.NoError(t, err)
+	err = m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "1", ItemId: "1"}},
+	}, false, false, false)
+	assert.NoError(t, err)
+	err = m.exportDataStoreMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gaugeValue(t, DataStoreRowsVec.WithLabelValues("users")))
+	assert.Equal(t, 2.0, gaugeValue(t, DataStoreRowsVec.WithLabelValues("items")))
+	assert.Equal(t, 3.0, gaugeValue(t, DataStoreRowsVec.WithLabelValues("feedback")))
+	counts, err := server.LoadCounts(ctx, m.CacheClient)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, counts.Users)
+	assert.Equal(t, 2, counts.Items)
+	assert.Equal(t, 3, counts.Feedback)
+	assert.Equal(t, map[string]int{"click": 2, "like": 1}, counts.FeedbackTypes)
+	assert.Equal(t, map[string]int{"a": 2, "b": 1}, counts.Categories)
+	labelCounts, err := server.LoadLabelCounts(ctx, m.CacheClient)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"x": 1}, labelCounts.Users)
+	assert.Equal(t, map[string]int{"x": 1, "y": 2}, labelCounts.Items)
+
+	// scan the cache store
+	err = m.CacheClient.AddSorted(ctx,
+		cache.Sorted(cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "0", Score: 2}, {Id: "1", Score: 1}}),
+		cache.Sorted(cache.Key(cache.OfflineRecommend, "1"), []cache.Scored{{Id: "2", Score: 1}}),
+		cache.Sorted(cache.PopularItems, []cache.Scored{{Id: "1", Score: 1}}))
+	assert.NoError(t, err)
+	err = m.CacheClient.Set(ctx,
+		cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "0"), time.Now().Add(-time.Hour)),
+		cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "1"), time.Now().Add(-3*time.Hour)))
+	assert.NoError(t, err)
+	err = m.exportCacheStoreMetrics(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, gaugeValue(t, CacheKeysVec.WithLabelValues(cache.OfflineRecommend)))
+	assert.Equal(t, 2.0, gaugeValue(t, CacheKeysVec.WithLabelValues(cache.LastUpdateUserRecommendTime)))
+	assert.Equal(t, 3.0, gaugeValue(t, CacheSortedSetMembersVec.WithLabelValues(cache.OfflineRecommend)))
+	assert.Equal(t, 2.0, gaugeValue(t, CacheSortedSetMaxMembersVec.WithLabelValues(cache.OfflineRecommend)))
+	assert.Equal(t, 1.0, gaugeValue(t, CacheSortedSetMembersVec.WithLabelValues(cache.PopularItems)))
+	assert.Positive(t, gaugeValue(t, CacheEstimatedBytes))
+	assert.InDelta(t, time.Hour.Seconds(), gaugeValue(t, RecommendStalenessSecondsVec.WithLabelValues("0.5")), 60)
+	assert.InDelta(t, 3*time.Hour.Seconds(), gaugeValue(t, RecommendStalenessSecondsVec.WithLabelValues("1")), 60)
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chewxy/math32"
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/base/task"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/model/click"
+	"github.com/zhenghaoz/gorse/model/ranking"
+	"go.uber.org/zap"
+)
+
+// loadObjectiveDataset creates the click dataset of an objective. Users, items and labels are shared with the click
+// dataset, while feedback of the objective types is positive and other positive or read feedback is negative.
+func (m *Master) loadObjectiveDataset(ctx context.Context, rankingDataset *ranking.DataSet, clickDataset *click.Dataset, objective config.ObjectiveConfig) (*click.Dataset, error) {
+	var feedbackTimeLimit *time.Time
+	if m.Config().Recommend.DataSource.PositiveFeedbackTTL > 0 {
+		feedbackTimeLimit = lo.ToPtr(time.Now().AddDate(0, 0, -int(m.Config().Recommend.DataSource.PositiveFeedbackTTL)))
+	}
+	pullFeedback := func(feedbackTypes []string) ([][]int32, error) {
+		userItems := make([][]int32, rankingDataset.UserCount())
+		if len(feedbackTypes) == 0 {
+			return userItems, nil
+		}
+		feedbackChan, errChan := m.DataClient.GetFeedbackStream(ctx, batchSize, feedbackTimeLimit, m.Config().Now(), feedbackTypes...)
+		for feedback := range feedbackChan {
+			for _, f := range feedback {
+				userIndex := rankingDataset.UserIndex.ToNumber(f.UserId)
+				itemIndex := rankingDataset.ItemIndex.ToNumber(f.ItemId)
+				if userIndex != base.NotId && itemIndex != base.NotId {
+					userItems[userIndex] = append(userItems[userIndex], itemIndex)
+				}
+			}
+		}
+		if err := <-errChan; err != nil {
+			return nil, errors.Trace(err)
+		}
+		return userItems, nil
+	}
+	positiveSet, err := pullFeedback(objective.FeedbackTypes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	negativeSet, err := pullFeedback(lo.Without(lo.Union(
+		m.Config().Recommend.DataSource.PositiveFeedbackTypes,
+		m.Config().Recommend.DataSource.ReadFeedbackTypes), objective.FeedbackTypes...))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	dataset := &click.Dataset{
+		Index:        clickDataset.Index,
+		UserFeatures: clickDataset.UserFeatures,
+		ItemFeatures: clickDataset.ItemFeatures,
+	}
+	appendSample := func(userIndex, itemIndex int32, target float32) {
+		dataset.Users.Append(userIndex)
+		dataset.Items.Append(itemIndex)
+		dataset.NormValues.Append(1 / math32.Sqrt(float32(len(dataset.UserFeatures[userIndex])+len(dataset.ItemFeatures[itemIndex]))))
+		dataset.Target.Append(target)
+	}
+	for userIndex := range positiveSet {
+		positives := sortedUnique(positiveSet[userIndex])
+		negatives := lo.Filter(sortedUnique(negativeSet[userIndex]), func(itemIndex int32, _ int) bool {
+			return !containsSorted(positives, itemIndex)
+		})
+		if len(positives) == 0 || len(negatives) == 0 {
+			continue
+		}
+		for _, itemIndex := range positives {
+			appendSample(int32(userIndex), itemIndex, 1)
+			dataset.PositiveCount++
+		}
+		for _, itemIndex := range negatives {
+			appendSample(int32(userIndex), itemIndex, -1)
+			dataset.NegativeCount++
+		}
+	}
+	log.Logger().Debug("created objective dataset",
+		zap.String("objective", objective.Name),
+		zap.Int("n_valid_positive", dataset.PositiveCount),
+		zap.Int("n_valid_negative", dataset.NegativeCount))
+	return dataset, nil
+}
+
+// objectivesDigest returns the digest of objectives whose models need to be refitted once changed.
+func objectivesDigest(objectives []config.ObjectiveConfig) string {
+	digests := make([]string, len(objectives))
+	for i, objective := range objectives {
+		digests[i] = fmt.Sprintf("%s:%s", objective.Name, strings.Join(objective.FeedbackTypes, ","))
+	}
+	return strings.Join(digests, ";")
+}
+
+// fitObjectiveModels fits click models of objectives with hyper-parameters of the click model. Objectives without
+// positive or negative feedback are skipped.
+func (t *FitClickModelTask) fitObjectiveModels(clickModel click.FactorizationMachine, j *task.JobsAllocator) map[string]click.FactorizationMachine {
+	objectiveModels := make(map[string]click.FactorizationMachine)
+	for _, objective := range t.Config().Recommend.Offline.Objectives {
+		trainSet, testSet := t.objectiveTrainSets[objective.Name], t.objectiveTestSets[objective.Name]
+		if trainSet == nil || trainSet.PositiveCount == 0 || trainSet.NegativeCount == 0 {
+			log.Logger().Warn("empty objective dataset",
+				zap.String("objective", objective.Name),
+				zap.Strings("feedback_types", objective.FeedbackTypes))
+			continue
+		}
+		objectiveModel := click.NewFM(click.FMClassification, clickModel.GetParams())
+		score := objectiveModel.Fit(trainSet, testSet, click.NewFitConfig().SetJobsAllocator(j))
+		t.calibrate(objective.Name, objectiveModel, testSet)
+		log.Logger().Info("fit objective model complete",
+			append([]zap.Field{zap.String("objective", objective.Name)}, score.ZapFields()...)...)
+		objectiveModels[objective.Name] = objectiveModel
+	}
+	return objectiveModels
+}
@@ -0,0 +1,462 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/gorilla/securecookie"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/server"
+	"go.uber.org/zap"
+)
+
+const (
+	oidcCookie   = "oidc"
+	oidcCallback = "/login/oidc/callback"
+	// oidcAccountPrefix is the prefix of names of OpenID Connect accounts, which are never password accounts.
+	oidcAccountPrefix = "oidc:"
+)
+
+// oidcClient is the HTTP client to access the OpenID provider.
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider is the metadata of an OpenID provider.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcKey is a JSON web key of the OpenID provider to verify signatures of ID tokens.
+type oidcKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcAccountName returns the account name of an OpenID Connect identity, which is unique by the issuer and the
+// subject. Emails are not used since they might be set arbitrarily at the OpenID provider.
+func oidcAccountName(issuer, subject string) string {
+	return oidcAccountPrefix + strings.TrimSuffix(issuer, "/") + "#" + subject
+}
+
+// oidcAudience is the audience of an ID token, which is either a string or an array of strings.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var audience string
+	if err := json.Unmarshal(data, &audience); err == nil {
+		*a = oidcAudience{audience}
+		return nil
+	}
+	var audiences []string
+	if err := json.Unmarshal(data, &audiences); err != nil {
+		return errors.Trace(err)
+	}
+	*a = audiences
+	return nil
+}
+
+// oidcClaims are claims of an ID token used by the dashboard.
+type oidcClaims struct {
+	Issuer   string       `json:"iss"`
+	Subject  string       `json:"sub"`
+	Audience oidcAudience `json:"aud"`
+	Expiry   int64        `json:"exp"`
+	Nonce    string       `json:"nonce"`
+	Email    string       `json:"email"`
+}
+
+// hasOIDC returns true if the dashboard login via OpenID Connect is enabled.
+func (m *Master) hasOIDC() bool {
+	return m.Config().Master.OIDCIssuer != ""
+}
+
+// discoverOIDC fetches the metadata of the OpenID provider.
+func (m *Master) discoverOIDC(ctx context.Context) (*oidcProvider, error) {
+	issuer := strings.TrimSuffix(m.Config().Master.OIDCIssuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("failed to discover OpenID provider: %s", message)
+	}
+	var provider oidcProvider
+	if err = json.NewDecoder(resp.Body).Decode(&provider); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != issuer {
+		return nil, errors.Errorf("issuer of OpenID provider mismatched: %s", provider.Issuer)
+	}
+	return &provider, nil
+}
+
+// fetchOIDCKeys fetches JSON web keys of the OpenID provider.
+func fetchOIDCKeys(ctx context.Context, provider *oidcProvider) ([]oidcKey, error) {
+	if provider.JWKSURI == "" {
+		return nil, errors.New("JWKS URI of OpenID provider not found")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.JWKSURI, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("failed to fetch keys of OpenID provider: %s", message)
+	}
+	var keys struct {
+		Keys []oidcKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return keys.Keys, nil
+}
+
+// oidcRedirectURL returns the URL the OpenID provider redirects to after login.
+func (m *Master) oidcRedirectURL(request *http.Request) string {
+	if redirectURL := m.Config().Master.OIDCRedirectURL; redirectURL != "" {
+		return redirectURL
+	}
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, request.Host, oidcCallback)
+}
+
+// secureCookie returns true if cookies should be sent over TLS only.
+func (m *Master) secureCookie(request *http.Request) bool {
+	return request.TLS != nil || strings.HasPrefix(m.oidcRedirectURL(request), "https://")
+}
+
+// loginOIDC redirects the browser to the authorization endpoint of the OpenID provider.
+func (m *Master) loginOIDC(response http.ResponseWriter, request *http.Request) {
+	if !m.hasOIDC() {
+		server.PageNotFound(restful.NewResponse(response), errors.New("OpenID Connect is not enabled"))
+		return
+	}
+	provider, err := m.discoverOIDC(request.Context())
+	if err != nil {
+		server.InternalServerError(restful.NewResponse(response), err)
+		return
+	}
+	// the state protects the callback from forged requests and the nonce binds the ID token to the browser
+	value := map[string]string{
+		"state": base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32)),
+		"nonce": base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32)),
+	}
+	encoded, err := cookieHandler.Encode(oidcCookie, value)
+	if err != nil {
+		server.InternalServerError(restful.NewResponse(response), err)
+		return
+	}
+	http.SetCookie(response, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    encoded,
+		Path:     "/login/oidc",
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   m.secureCookie(request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {m.Config().Master.OIDCClientID},
+		"redirect_uri":  {m.oidcRedirectURL(request)},
+		"scope":         {"openid email profile"},
+		"state":         {value["state"]},
+		"nonce":         {value["nonce"]},
+	}
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(response, request, provider.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+	log.Logger().Info("GET /login/oidc", zap.Int("status_code", http.StatusFound))
+}
+
+// callbackOIDC exchanges the authorization code for an ID token and saves the login session.
+func (m *Master) callbackOIDC(response http.ResponseWriter, request *http.Request) {
+	if !m.hasOIDC() {
+		server.PageNotFound(restful.NewResponse(response), errors.New("OpenID Connect is not enabled"))
+		return
+	}
+	// check state
+	value := make(map[string]string)
+	if cookie, err := request.Cookie(oidcCookie); err != nil {
+		server.BadRequest(restful.NewResponse(response), errors.New("login session not found"))
+		return
+	} else if err = cookieHandler.Decode(oidcCookie, cookie.Value, &value); err != nil {
+		server.BadRequest(restful.NewResponse(response), errors.Trace(err))
+		return
+	}
+	http.SetCookie(response, &http.Cookie{Name: oidcCookie, Path: "/login/oidc", MaxAge: -1})
+	if state := request.URL.Query().Get("state"); state == "" || state != value["state"] {
+		server.BadRequest(restful.NewResponse(response), errors.New("state mismatched"))
+		return
+	}
+	if message := request.URL.Query().Get("error"); message != "" {
+		http.Redirect(response, request, "/login?msg=incorrect", http.StatusFound)
+		log.Logger().Info("GET "+oidcCallback, zap.Int("status_code", http.StatusUnauthorized), zap.String("error", message))
+		return
+	}
+	// exchange code
+	provider, err := m.discoverOIDC(request.Context())
+	if err != nil {
+		server.InternalServerError(restful.NewResponse(response), err)
+		return
+	}
+	idToken, err := m.exchangeOIDC(request, provider, request.URL.Query().Get("code"))
+	if err != nil {
+		server.InternalServerError(restful.NewResponse(response), err)
+		return
+	}
+	keys, err := fetchOIDCKeys(request.Context(), provider)
+	if err != nil {
+		server.InternalServerError(restful.NewResponse(response), err)
+		return
+	}
+	claims, err := parseIDToken(idToken, keys, provider.Issuer, m.Config().Master.OIDCClientID, value["nonce"], time.Now())
+	if err != nil {
+		http.Redirect(response, request, "/login?msg=incorrect", http.StatusFound)
+		log.Logger().Info("GET "+oidcCallback, zap.Int("status_code", http.StatusUnauthorized), zap.Error(err))
+		return
+	}
+	// save session
+	name := oidcAccountName(claims.Issuer, claims.Subject)
+	encoded, err := cookieHandler.Encode("session", map[string]string{
+		"user_name": name,
+		"issuer":    claims.Issuer,
+		"subject":   claims.Subject,
+		"expiry":    strconv.FormatInt(claims.Expiry, 10),
+	})
+	if err != nil {
+		server.InternalServerError(restful.NewResponse(response), err)
+		return
+	}
+	http.SetCookie(response, &http.Cookie{
+		Name:     "session",
+		Value:    encoded,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   m.secureCookie(request),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(response, request, "/", http.StatusFound)
+	log.Logger().Info("GET "+oidcCallback, zap.Int("status_code", http.StatusFound),
+		zap.String("user_name", name), zap.String("email", claims.Email))
+}
+
+// exchangeOIDC exchanges an authorization code for an ID token at the token endpoint.
+func (m *Master) exchangeOIDC(request *http.Request, provider *oidcProvider, code string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {m.oidcRedirectURL(request)},
+	}
+	req, err := http.NewRequestWithContext(request.Context(), http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(m.Config().Master.OIDCClientID), url.QueryEscape(m.Config().Master.OIDCClientSecret))
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return "", errors.Errorf("failed to exchange authorization code: %s", message)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", errors.Trace(err)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("ID token not found")
+	}
+	return token.IDToken, nil
+}
+
+// parseIDToken verifies the signature of an ID token by keys of the OpenID provider, then parses and validates claims.
+func parseIDToken(idToken string, keys []oidcKey, issuer, clientId, nonce string, now time.Time) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.NotValidf("ID token")
+	}
+	if err := verifyIDToken(parts, keys); err != nil {
+		return nil, errors.Trace(err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var claims oidcClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, errors.NotValidf("issuer `%s`", claims.Issuer)
+	}
+	found := false
+	for _, audience := range claims.Audience {
+		found = found || audience == clientId
+	}
+	if !found {
+		return nil, errors.NotValidf("audience %v", claims.Audience)
+	}
+	if !now.Before(time.Unix(claims.Expiry, 0)) {
+		return nil, errors.NotValidf("expired ID token")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.NotValidf("nonce")
+	}
+	if claims.Subject == "" {
+		return nil, errors.NotValidf("empty subject")
+	}
+	return &claims, nil
+}
+
+// verifyIDToken verifies the signature of an ID token split into the header, the payload and the signature. RS256,
+// RS384, RS512, ES256, ES384 and ES512 are supported, and unsigned tokens are rejected.
+func verifyIDToken(parts []string, keys []oidcKey) error {
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err = json.Unmarshal(buf, &header); err != nil {
+		return errors.Trace(err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var hash crypto.Hash
+	switch header.Alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return errors.NotValidf("algorithm `%s` of ID token", header.Alg)
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	digest := hasher.Sum(nil)
+	for _, key := range keys {
+		if (header.Kid != "" && key.Kid != header.Kid) || (key.Alg != "" && key.Alg != header.Alg) {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(header.Alg, "RS") && key.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(key.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(key.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			if rsa.VerifyPKCS1v15(publicKey, hash, digest, signature) == nil {
+				return nil
+			}
+		case strings.HasPrefix(header.Alg, "ES") && key.Kty == "EC":
+			var curve elliptic.Curve
+			switch key.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(key.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(key.Y)
+			if err1 != nil || err2 != nil || len(signature)%2 != 0 {
+				continue
+			}
+			publicKey := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			r := new(big.Int).SetBytes(signature[:len(signature)/2])
+			s := new(big.Int).SetBytes(signature[len(signature)/2:])
+			if ecdsa.Verify(publicKey, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.NotValidf("signature of ID token")
+}
+
+// checkOIDCSession returns the account of a session created by OpenID Connect login. The role of the account is
+// saved in dashboard accounts of the OpenID Connect identity, otherwise the default role of OpenID Connect users.
+// Password accounts are never matched since names of OpenID Connect accounts are in their own namespace.
+func (m *Master) checkOIDCSession(cookieValue map[string]string, accounts map[string]accountRecord) *Account {
+	if !m.hasOIDC() || strings.TrimSuffix(cookieValue["issuer"], "/") != strings.TrimSuffix(m.Config().Master.OIDCIssuer, "/") {
+		return nil
+	}
+	expiry, err := strconv.ParseInt(cookieValue["expiry"], 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(expiry, 0)) || cookieValue["subject"] == "" {
+		return nil
+	}
+	account := &Account{Name: oidcAccountName(cookieValue["issuer"], cookieValue["subject"]), Role: Role(m.Config().Master.OIDCRole)}
+	if record, exist := accounts[account.Name]; exist && record.PasswordHash == "" {
+		account.Role = record.Role
+	}
+	return account
+}
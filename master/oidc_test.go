@@ -0,0 +1,210 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeIDToken(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"1"}`))
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newOIDCKey(t *testing.T) (*rsa.PrivateKey, oidcKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return key, oidcKey{
+		Kty: "RSA",
+		Kid: "1",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func TestParseIDToken(t *testing.T) {
+	key, jwk := newOIDCKey(t)
+	keys := []oidcKey{jwk}
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	claims := map[string]any{
+		"iss":   "https://accounts.example.com",
+		"sub":   "1",
+		"aud":   []string{"gorse", "other"},
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "nonce",
+		"email": "alice@example.com",
+	}
+	parsed, err := parseIDToken(encodeIDToken(t, key, claims), keys, "https://accounts.example.com/", "gorse", "nonce", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", parsed.Email)
+	assert.Equal(t, oidcAudience{"gorse", "other"}, parsed.Audience)
+
+	// invalid tokens
+	_, err = parseIDToken("token", keys, "https://accounts.example.com", "gorse", "nonce", now)
+	assert.Error(t, err)
+	_, err = parseIDToken(encodeIDToken(t, key, claims), keys, "https://evil.example.com", "gorse", "nonce", now)
+	assert.Error(t, err)
+	_, err = parseIDToken(encodeIDToken(t, key, claims), keys, "https://accounts.example.com", "other-client", "nonce", now)
+	assert.Error(t, err)
+	_, err = parseIDToken(encodeIDToken(t, key, claims), keys, "https://accounts.example.com", "gorse", "replayed", now)
+	assert.Error(t, err)
+	_, err = parseIDToken(encodeIDToken(t, key, claims), keys, "https://accounts.example.com", "gorse", "nonce", now.Add(time.Hour))
+	assert.Error(t, err)
+
+	// invalid signatures
+	otherKey, _ := newOIDCKey(t)
+	_, err = parseIDToken(encodeIDToken(t, otherKey, claims), keys, "https://accounts.example.com", "gorse", "nonce", now)
+	assert.True(t, errors.IsNotValid(err))
+	payload, err := json.Marshal(claims)
+	assert.NoError(t, err)
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+	_, err = parseIDToken(unsigned, keys, "https://accounts.example.com", "gorse", "nonce", now)
+	assert.True(t, errors.IsNotValid(err))
+	parts := strings.Split(encodeIDToken(t, key, claims), ".")
+	claims["sub"] = "2"
+	forged := strings.Split(encodeIDToken(t, otherKey, claims), ".")
+	_, err = parseIDToken(parts[0]+"."+forged[1]+"."+parts[2], keys, "https://accounts.example.com", "gorse", "nonce", now)
+	assert.True(t, errors.IsNotValid(err))
+}
+
+func TestMaster_OIDC(t *testing.T) {
+	s, _ := newMockServer(t)
+	defer s.Close(t)
+	s.Config().Master.DashboardUserName = ""
+	s.Config().Master.DashboardPassword = ""
+
+	// create a mock OpenID provider
+	key, jwk := newOIDCKey(t)
+	var nonce string
+	mux := http.NewServeMux()
+	provider := httptest.NewServer(mux)
+	defer provider.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                provider.URL,
+			AuthorizationEndpoint: provider.URL + "/authorize",
+			TokenEndpoint:         provider.URL + "/token",
+			JWKSURI:               provider.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string][]oidcKey{"keys": {jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientId, clientSecret, _ := r.BasicAuth()
+		if clientId != "gorse" || clientSecret != "secret" || r.FormValue("code") != "code" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access_token",
+			"id_token": encodeIDToken(t, key, map[string]any{
+				"iss":   provider.URL,
+				"sub":   "1",
+				"aud":   "gorse",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"nonce": nonce,
+				"email": "alice@example.com",
+			}),
+		})
+	})
+	s.Config().Master.OIDCIssuer = provider.URL
+	s.Config().Master.OIDCClientID = "gorse"
+	s.Config().Master.OIDCClientSecret = "secret"
+
+	// redirect to the OpenID provider
+	resp := httptest.NewRecorder()
+	s.dashboard(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/login/oidc", resp.Header().Get("Location"))
+	resp = httptest.NewRecorder()
+	s.loginOIDC(resp, httptest.NewRequest(http.MethodGet, "http://gorse.example.com/login/oidc", nil))
+	assert.Equal(t, http.StatusFound, resp.Code)
+	location, err := url.Parse(resp.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, provider.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	assert.Equal(t, "gorse", location.Query().Get("client_id"))
+	assert.Equal(t, "http://gorse.example.com/login/oidc/callback", location.Query().Get("redirect_uri"))
+	state := location.Query().Get("state")
+	nonce = location.Query().Get("nonce")
+	stateCookie := resp.Result().Cookies()[0]
+
+	// reject forged callbacks
+	resp = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?code=code&state=forged", nil)
+	req.AddCookie(stateCookie)
+	s.callbackOIDC(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// login via the OpenID provider
+	resp = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/login/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	req.AddCookie(stateCookie)
+	s.callbackOIDC(resp, req)
+	assert.Equal(t, http.StatusFound, resp.Code)
+	assert.Equal(t, "/", resp.Header().Get("Location"))
+	var session *http.Cookie
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == "session" {
+			session = cookie
+		}
+	}
+	assert.NotNil(t, session)
+	assert.True(t, session.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+	req = httptest.NewRequest(http.MethodGet, "/api/dashboard/cluster", nil)
+	req.AddCookie(session)
+	name := oidcAccountName(provider.URL, "1")
+	assert.Equal(t, &Account{Name: name, Role: RoleViewer}, s.checkLogin(req))
+
+	// password accounts with the same email are not merged
+	err = SaveAccount(context.Background(), s.CacheClient, Account{Name: "alice@example.com", Role: RoleAdmin, Password: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Account{Name: name, Role: RoleViewer}, s.checkLogin(req))
+
+	// roles of OpenID Connect accounts override the default role
+	err = SaveAccount(context.Background(), s.CacheClient, Account{Name: name, Role: RoleAdmin, Password: "alice"})
+	assert.True(t, errors.IsNotValid(err))
+	err = SaveAccount(context.Background(), s.CacheClient, Account{Name: name, Role: RoleAdmin})
+	assert.NoError(t, err)
+	assert.Equal(t, &Account{Name: name, Role: RoleAdmin}, s.checkLogin(req))
+
+	// sessions are invalid once OpenID Connect is disabled
+	s.Config().Master.OIDCIssuer = ""
+	assert.Nil(t, s.checkLogin(req))
+}
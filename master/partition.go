@@ -0,0 +1,78 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"go.uber.org/zap"
+)
+
+// numAheadPartitions is the number of partitions of feedback created ahead of the current time.
+const numAheadPartitions = 2
+
+// partitionFeedback creates partitions of feedback ahead of the current time if feedback partitioning is enabled.
+func (m *Master) partitionFeedback(ctx context.Context) error {
+	interval := m.Config().Database.FeedbackPartitionInterval
+	if interval == 0 {
+		return nil
+	}
+	partitioner, ok := m.DataClient.(data.FeedbackPartitioner)
+	if !ok {
+		return errors.NotSupportedf("partitions of feedback in data store %s",
+			log.RedactDBURL(m.Config().Database.DataStore))
+	}
+	return errors.Trace(partitioner.PartitionFeedback(ctx, interval, time.Now().Add(numAheadPartitions*interval)))
+}
+
+// pruneFeedback drops partitions of feedback older than the retention if the retention is set.
+func (m *Master) pruneFeedback(ctx context.Context) error {
+	retention := m.Config().Database.FeedbackPartitionRetention
+	partitioner, ok := m.DataClient.(data.FeedbackPartitioner)
+	if retention == 0 || !ok {
+		return nil
+	}
+	n, err := partitioner.PruneFeedback(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if n > 0 {
+		log.Logger().Info("prune partitions of feedback", zap.Int("n_partitions", n), zap.Duration("retention", retention))
+	}
+	return nil
+}
+
+// RunFeedbackPartitionLoop creates partitions of feedback in advance and prunes expired partitions every half of the
+// partition interval.
+func (m *Master) RunFeedbackPartitionLoop() {
+	defer base.CheckPanic()
+	ticker := time.NewTicker(m.Config().Database.FeedbackPartitionInterval / 2)
+	defer ticker.Stop()
+	for {
+		ctx := context.Background()
+		if err := m.partitionFeedback(ctx); err != nil {
+			log.Logger().Error("failed to create partitions of feedback", zap.Error(err))
+		}
+		if err := m.pruneFeedback(ctx); err != nil {
+			log.Logger().Error("failed to prune partitions of feedback", zap.Error(err))
+		}
+		<-ticker.C
+	}
+}
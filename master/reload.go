@@ -0,0 +1,82 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/fsnotify/fsnotify"
+	"github.com/juju/errors"
+	"github.com/spf13/viper"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+	"go.uber.org/zap"
+)
+
+// SetConfigFile sets the config file to reload. The config file is watched once the master starts.
+func (m *Master) SetConfigFile(path string, oneModel bool) {
+	m.configFile = path
+	m.oneModel = oneModel
+}
+
+// ReloadConfig loads the config file again and applies changed recommendation parameters live. Changes of other
+// sections require restarts and are ignored. Dynamic config still overrides the config file. Servers and workers
+// receive new parameters in the next meta sync.
+func (m *Master) ReloadConfig() ([]string, error) {
+	if m.configFile == "" {
+		return nil, errors.NotSupportedf("reload config without config file")
+	}
+	cfg, err := config.LoadConfig(m.configFile, m.oneModel)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	m.dynamicConfigLock.Lock()
+	m.staticConfig = cfg
+	m.dynamicConfigLock.Unlock()
+	changed, err := m.applyDynamicConfig(context.Background())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Logger().Info("reload config", zap.String("config", m.configFile), zap.Strings("changed", changed))
+	return changed, nil
+}
+
+// watchConfig reloads the config file once it is modified.
+func (m *Master) watchConfig() {
+	if m.configFile == "" {
+		return
+	}
+	viper.OnConfigChange(func(_ fsnotify.Event) {
+		if _, err := m.ReloadConfig(); err != nil {
+			log.Logger().Error("failed to reload config", zap.Error(err))
+		}
+	})
+	viper.WatchConfig()
+}
+
+// reloadConfig reloads the config file and returns keys of changed parameters.
+func (m *Master) reloadConfig(_ *restful.Request, response *restful.Response) {
+	changed, err := m.ReloadConfig()
+	if errors.IsNotSupported(err) {
+		server.BadRequest(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, changed)
+}
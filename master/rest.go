@@ -49,6 +49,8 @@ import (
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/websocket"
 )
 
 func (m *Master) CreateWebService() {
@@ -72,6 +74,33 @@ func (m *Master) CreateWebService() {
 		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
 		Returns(http.StatusOK, "OK", config.Config{}).
 		Writes(config.Config{}))
+	ws.Route(ws.POST("/dashboard/config/reload").To(m.reloadConfig).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Reload the config file and apply changed recommendation parameters.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Returns(http.StatusOK, "OK", []string{}).
+		Writes([]string{}))
+	ws.Route(ws.GET("/dashboard/config/dynamic").To(m.getDynamicConfig).
+		Doc("Get dynamic config overriding recommendation settings in the config file.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Returns(http.StatusOK, "OK", DynamicConfig{}).
+		Writes(DynamicConfig{}))
+	ws.Route(ws.POST("/dashboard/config/dynamic").To(m.setDynamicConfig).
+		Doc("Replace dynamic config and apply changed recommendation settings.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Reads(DynamicConfig{}).
+		Returns(http.StatusOK, "OK", []string{}).
+		Writes([]string{}))
+	ws.Route(ws.GET("/dashboard/config/history").To(m.getConfigHistory).
+		Doc("Get changes of dynamic config.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Returns(http.StatusOK, "OK", []ConfigChange{}).
+		Writes([]ConfigChange{}))
+	ws.Route(ws.GET("/dashboard/config/validate").To(m.validateConfig).
+		Doc("Validate the config file, or the running config if the config file doesn't exist.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Returns(http.StatusOK, "OK", []config.ValidationIssue{}).
+		Writes([]config.ValidationIssue{}))
 	ws.Route(ws.GET("/dashboard/stats").To(m.getStats).
 		Doc("Get global status.").
 		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
@@ -84,12 +113,107 @@ func (m *Master) CreateWebService() {
 		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
 		Returns(http.StatusOK, "OK", []task.Task{}).
 		Writes([]task.Task{}))
+	ws.Route(ws.GET("/dashboard/tasks/stream").To(m.streamTasks).
+		Doc("Stream status and progress changes of tasks over WebSocket.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.QueryParameter("since", "id of the first event to stream").DataType("integer")).
+		Returns(http.StatusSwitchingProtocols, "Switching Protocols", task.Event{}))
+	ws.Route(ws.POST("/dashboard/tasks/{task-name}/cancel").To(m.cancelTask).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Cancel a running task.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Param(ws.PathParameter("task-name", "name of the task").DataType("string")).
+		Returns(http.StatusOK, "OK", server.Success{}).
+		Writes(server.Success{}))
+	ws.Route(ws.POST("/dashboard/tasks/{task-name}/retry").To(m.retryTask).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Retry a failed or cancelled task.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Param(ws.PathParameter("task-name", "name of the task").DataType("string")).
+		Returns(http.StatusOK, "OK", server.Success{}).
+		Writes(server.Success{}))
+	ws.Route(ws.GET("/dashboard/consistency").To(m.getConsistencyReport).
+		Doc("Get the report of the last consistency check between the data store and the cache store.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Returns(http.StatusOK, "OK", ConsistencyReport{}).
+		Writes(ConsistencyReport{}))
+	ws.Route(ws.POST("/dashboard/consistency/check").To(m.checkConsistencyNow).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Doc("Check consistency between the data store and the cache store.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Param(ws.QueryParameter("repair", "remove inconsistent entries from the cache store").DataType("boolean")).
+		Returns(http.StatusOK, "OK", ConsistencyReport{}).
+		Writes(ConsistencyReport{}))
 	ws.Route(ws.GET("/dashboard/rates").To(m.getRates).
-		Doc("Get positive feedback rates.").
+		Doc("Get positive feedback rates, or measurements of models and offline recommendations.").
 		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
 		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Param(ws.QueryParameter("group", "group rates by feedback_type (default) or source, or get offline measurements").DataType("string")).
 		Returns(http.StatusOK, "OK", map[string][]server.Measurement{}).
 		Writes(map[string][]server.Measurement{}))
+	ws.Route(ws.GET("/dashboard/rules").To(m.getRules).
+		Doc("Get business rules to pin, boost and block items.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Returns(http.StatusOK, "OK", []server.Rule{}).
+		Writes([]server.Rule{}))
+	ws.Route(ws.POST("/dashboard/rules").To(m.setRules).
+		Doc("Replace business rules to pin, boost and block items.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Reads([]server.Rule{}).
+		Returns(http.StatusOK, "OK", server.Success{}).
+		Writes(server.Success{}))
+	ws.Route(ws.GET("/dashboard/segments").To(m.getSegments).
+		Doc("Get user segments to override configurations of recommendation.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Returns(http.StatusOK, "OK", []server.Segment{}).
+		Writes([]server.Segment{}))
+	ws.Route(ws.POST("/dashboard/segments").To(m.setSegments).
+		Doc("Replace user segments to override configurations of recommendation.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Reads([]server.Segment{}).
+		Returns(http.StatusOK, "OK", server.Success{}).
+		Writes(server.Success{}))
+	ws.Route(ws.GET("/dashboard/accounts").To(m.getAccounts).
+		Doc("Get dashboard accounts and roles.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Returns(http.StatusOK, "OK", []Account{}).
+		Writes([]Account{}))
+	ws.Route(ws.POST("/dashboard/accounts").To(m.setAccount).
+		Doc("Create a dashboard account or update the role and the password of an account.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Reads(Account{}).
+		Returns(http.StatusOK, "OK", server.Success{}).
+		Writes(server.Success{}))
+	ws.Route(ws.DELETE("/dashboard/accounts/{name}").To(m.deleteAccount).
+		Doc("Delete a dashboard account.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.PathParameter("name", "name of the account").DataType("string")).
+		Returns(http.StatusOK, "OK", server.Success{}).
+		Writes(server.Success{}))
+	ws.Route(ws.GET("/dashboard/usage").To(m.getUsage).
+		Doc("Get usage of API keys.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Param(ws.QueryParameter("days", "number of recent days").DataType("int")).
+		Returns(http.StatusOK, "OK", []server.APIKeyUsage{}).
+		Writes([]server.APIKeyUsage{}))
+	ws.Route(ws.GET("/dashboard/audit").To(m.getAuditLogs).
+		Doc("Get audit logs of write operations.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.HeaderParameter("X-API-Key", "secret key for RESTful API")).
+		Param(ws.QueryParameter("n", "number of returned audit logs").DataType("int")).
+		Param(ws.QueryParameter("begin", "begin time of returned audit logs").DataType("string")).
+		Param(ws.QueryParameter("end", "end time of returned audit logs").DataType("string")).
+		Returns(http.StatusOK, "OK", []data.AuditLog{}).
+		Writes([]data.AuditLog{}))
 	// Get a user
 	ws.Route(ws.GET("/dashboard/user/{user-id}").To(m.getUser).
 		Doc("Get a user.").
@@ -112,8 +236,23 @@ func (m *Master) CreateWebService() {
 		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
 		Param(ws.QueryParameter("n", "number of returned users").DataType("int")).
 		Param(ws.QueryParameter("cursor", "cursor for next page").DataType("string")).
+		Param(ws.QueryParameter("prefix", "prefix of user IDs").DataType("string")).
+		Param(ws.QueryParameter("label", "label of users").DataType("string")).
+		Param(ws.QueryParameter("sort", "sort by user ID (default) or last_active").DataType("string")).
 		Returns(http.StatusOK, "OK", UserIterator{}).
 		Writes(UserIterator{}))
+	// Get items
+	ws.Route(ws.GET("/dashboard/items").To(m.getItems).
+		Doc("Get items.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.QueryParameter("n", "number of returned items").DataType("int")).
+		Param(ws.QueryParameter("cursor", "cursor for next page").DataType("string")).
+		Param(ws.QueryParameter("prefix", "prefix of item IDs").DataType("string")).
+		Param(ws.QueryParameter("label", "label of items").DataType("string")).
+		Param(ws.QueryParameter("category", "category of items").DataType("string")).
+		Param(ws.QueryParameter("hidden", "hidden or visible items").DataType("boolean")).
+		Returns(http.StatusOK, "OK", server.ItemIterator{}).
+		Writes(server.ItemIterator{}))
 	// Get popular items
 	ws.Route(ws.GET("/dashboard/popular/").To(m.getPopular).
 		Doc("get popular items").
@@ -170,6 +309,22 @@ func (m *Master) CreateWebService() {
 		Param(ws.QueryParameter("n", "number of returned items").DataType("int")).
 		Returns(http.StatusOK, "OK", []data.Item{}).
 		Writes([]data.Item{}))
+	ws.Route(ws.GET("/dashboard/debug/{user-id}").To(m.getDebugRecommend).
+		Doc("Explain recommendation for user.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+		Param(ws.QueryParameter("category", "category of items").DataType("string")).
+		Param(ws.QueryParameter("n", "number of returned items").DataType("int")).
+		Returns(http.StatusOK, "OK", server.DebugRecommendation{}).
+		Writes(server.DebugRecommendation{}))
+	ws.Route(ws.GET("/dashboard/item/{item-id}/stats").To(m.getItemStats).
+		Doc("Get statistics of an item.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{"dashboard"}).
+		Param(ws.PathParameter("item-id", "identifier of the item").DataType("string")).
+		Param(ws.QueryParameter("days", "number of days of daily statistics").DataType("int")).
+		Param(ws.QueryParameter("n", "number of returned neighbors").DataType("int")).
+		Returns(http.StatusOK, "OK", ItemStats{}).
+		Writes(ItemStats{}))
 	ws.Route(ws.GET("/dashboard/item/{item-id}/neighbors").To(m.getItemNeighbors).
 		Doc("get neighbors of a item").
 		Metadata(restfulspec.KeyOpenAPITags, []string{"recommendation"}).
@@ -221,10 +376,14 @@ func (m *Master) StartHttpServer() {
 	container.Handle("/", http.HandlerFunc(m.dashboard))
 	container.Handle("/login", http.HandlerFunc(m.login))
 	container.Handle("/logout", http.HandlerFunc(m.logout))
+	container.Handle("/login/oidc", http.HandlerFunc(m.loginOIDC))
+	container.Handle(oidcCallback, http.HandlerFunc(m.callbackOIDC))
 	container.Handle("/api/purge", http.HandlerFunc(m.purge))
 	container.Handle("/api/bulk/users", http.HandlerFunc(m.importExportUsers))
 	container.Handle("/api/bulk/items", http.HandlerFunc(m.importExportItems))
 	container.Handle("/api/bulk/feedback", http.HandlerFunc(m.importExportFeedback))
+	container.Handle("/api/bulk/embeddings/users", http.HandlerFunc(m.exportUserEmbeddings))
+	container.Handle("/api/bulk/embeddings/items", http.HandlerFunc(m.exportItemEmbeddings))
 	if m.workerScheduleHandler == nil {
 		container.Handle("/api/admin/schedule", http.HandlerFunc(m.scheduleAPIHandler))
 	} else {
@@ -303,8 +462,12 @@ func noCache(h http.Handler) http.Handler {
 func (m *Master) dashboard(response http.ResponseWriter, request *http.Request) {
 	_, err := staticFileSystem.Open(request.RequestURI)
 	if request.RequestURI == "/" || os.IsNotExist(err) {
-		if !m.checkLogin(request) {
-			http.Redirect(response, request, "/login", http.StatusFound)
+		if m.checkLogin(request) == nil {
+			if m.hasOIDC() && !m.hasDashboardPassword() {
+				http.Redirect(response, request, "/login/oidc", http.StatusFound)
+			} else {
+				http.Redirect(response, request, "/login", http.StatusFound)
+			}
 			log.Logger().Info(fmt.Sprintf("%s %s", request.Method, request.URL), zap.Int("status_code", http.StatusFound))
 			return
 		}
@@ -315,7 +478,7 @@ func (m *Master) dashboard(response http.ResponseWriter, request *http.Request)
 }
 
 func (m *Master) checkToken(token string) (bool, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/auth/dashboard/%s", m.Config.Master.DashboardAuthServer, token))
+	resp, err := http.Get(fmt.Sprintf("%s/auth/dashboard/%s", m.Config().Master.DashboardAuthServer, token))
 	if err != nil {
 		return false, errors.Trace(err)
 	}
@@ -341,7 +504,7 @@ func (m *Master) login(response http.ResponseWriter, request *http.Request) {
 		token := request.FormValue("token")
 		name := request.FormValue("user_name")
 		pass := request.FormValue("password")
-		if m.Config.Master.DashboardAuthServer != "" {
+		if m.Config().Master.DashboardAuthServer != "" {
 			// check access token
 			if isValid, err := m.checkToken(token); err != nil {
 				server.InternalServerError(restful.NewResponse(response), err)
@@ -366,16 +529,25 @@ func (m *Master) login(response http.ResponseWriter, request *http.Request) {
 				log.Logger().Info("POST /login", zap.Int("status_code", http.StatusUnauthorized))
 				return
 			}
-		} else if m.Config.Master.DashboardUserName != "" || m.Config.Master.DashboardPassword != "" {
-			if name != m.Config.Master.DashboardUserName || pass != m.Config.Master.DashboardPassword {
+		}
+		accounts, err := m.loadAccounts(request.Context())
+		if err != nil {
+			server.InternalServerError(restful.NewResponse(response), err)
+			return
+		}
+		if len(accounts) > 0 || m.hasDashboardPassword() {
+			value := map[string]string{"user_name": name}
+			record, exist := accounts[name]
+			if exist && bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(pass)) == nil {
+				value["password_hash"] = record.PasswordHash
+			} else if !exist && m.hasDashboardPassword() &&
+				name == m.Config().Master.DashboardUserName && pass == m.Config().Master.DashboardPassword {
+				value["password"] = pass
+			} else {
 				http.Redirect(response, request, "login?msg=incorrect", http.StatusFound)
 				log.Logger().Info("POST /login", zap.Int("status_code", http.StatusUnauthorized))
 				return
 			}
-			value := map[string]string{
-				"user_name": name,
-				"password":  pass,
-			}
 			if encoded, err := cookieHandler.Encode("session", value); err != nil {
 				server.InternalServerError(restful.NewResponse(response), err)
 				return
@@ -412,8 +584,15 @@ func (m *Master) logout(response http.ResponseWriter, request *http.Request) {
 }
 
 func (m *Master) LoginFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
-	if m.checkLogin(req.Request) {
-		req.Request.Header.Set("X-API-Key", m.Config.Server.APIKey)
+	if account := m.checkLogin(req.Request); account != nil {
+		if !account.Role.allows(requiredRole(req.Request)) {
+			if err := resp.WriteError(http.StatusForbidden, fmt.Errorf("forbidden")); err != nil {
+				log.ResponseLogger(resp).Error("failed to write error", zap.Error(err))
+			}
+			return
+		}
+		req.Request.Header.Set("X-API-Key", m.Config().Server.APIKey)
+		req.SetAttribute(server.ActorAttribute, account.Name)
 		chain.ProcessFilter(req, resp)
 	} else if !strings.HasPrefix(req.SelectedRoutePath(), "/api/dashboard") {
 		chain.ProcessFilter(req, resp)
@@ -424,36 +603,68 @@ func (m *Master) LoginFilter(req *restful.Request, resp *restful.Response, chain
 	}
 }
 
-func (m *Master) checkLogin(request *http.Request) bool {
-	if m.Config.Master.AdminAPIKey != "" && m.Config.Master.AdminAPIKey == request.Header.Get("X-Api-Key") {
-		return true
+// hasDashboardPassword returns true if the user name or the password of the dashboard is set in the config.
+func (m *Master) hasDashboardPassword() bool {
+	return m.Config().Master.DashboardUserName != "" || m.Config().Master.DashboardPassword != ""
+}
+
+// loadAccounts loads dashboard accounts. There is no account before the cache store is connected.
+func (m *Master) loadAccounts(ctx context.Context) (map[string]accountRecord, error) {
+	accounts, err := loadAccounts(ctx, m.CacheClient)
+	if errors.Is(err, cache.ErrNoDatabase) {
+		return map[string]accountRecord{}, nil
 	}
-	if m.Config.Master.DashboardAuthServer != "" {
+	return accounts, errors.Trace(err)
+}
+
+// checkLogin returns the account of a request, or nil if the request is not logged in. The account is an admin if
+// the admin API key is used, the access token is verified by the auth server, the user name and the password in the
+// config are used, or the dashboard is not protected. Users logged in via OpenID Connect are assigned roles of their
+// accounts or the default role.
+func (m *Master) checkLogin(request *http.Request) *Account {
+	admin := &Account{Name: server.AdminActor, Role: RoleAdmin}
+	if m.Config().Master.AdminAPIKey != "" && m.Config().Master.AdminAPIKey == request.Header.Get("X-Api-Key") {
+		return admin
+	}
+	if m.Config().Master.DashboardAuthServer != "" {
 		if tokenCookie, err := request.Cookie("token"); err == nil {
 			var token string
 			if err = cookieHandler.Decode("token", tokenCookie.Value, &token); err == nil {
 				if isValid, err := m.checkToken(token); err != nil {
 					log.Logger().Error("failed to check access token", zap.Error(err))
 				} else if isValid {
-					return true
+					return admin
 				}
 			}
 		}
-		return false
-	} else if m.Config.Master.DashboardUserName != "" || m.Config.Master.DashboardPassword != "" {
+		return nil
+	}
+	accounts, err := m.loadAccounts(request.Context())
+	if err != nil {
+		log.Logger().Error("failed to load dashboard accounts", zap.Error(err))
+		return nil
+	}
+	if len(accounts) > 0 || m.hasDashboardPassword() || m.hasOIDC() {
 		if sessionCookie, err := request.Cookie("session"); err == nil {
 			cookieValue := make(map[string]string)
 			if err = cookieHandler.Decode("session", sessionCookie.Value, &cookieValue); err == nil {
 				userName := cookieValue["user_name"]
-				password := cookieValue["password"]
-				if userName == m.Config.Master.DashboardUserName && password == m.Config.Master.DashboardPassword {
-					return true
+				if _, isOIDC := cookieValue["issuer"]; isOIDC {
+					return m.checkOIDCSession(cookieValue, accounts)
+				} else if record, exist := accounts[userName]; exist {
+					// sessions are invalidated once passwords are changed
+					if record.PasswordHash != "" && cookieValue["password_hash"] == record.PasswordHash {
+						return &Account{Name: userName, Role: record.Role}
+					}
+				} else if m.hasDashboardPassword() && userName == m.Config().Master.DashboardUserName &&
+					cookieValue["password"] == m.Config().Master.DashboardPassword {
+					return &Account{Name: userName, Role: RoleAdmin}
 				}
 			}
 		}
-		return false
+		return nil
 	}
-	return true
+	return admin
 }
 
 func (m *Master) getCategories(request *restful.Request, response *restful.Response) {
@@ -512,13 +723,14 @@ func formatConfig(configMap map[string]interface{}) map[string]interface{} {
 
 func (m *Master) getConfig(_ *restful.Request, response *restful.Response) {
 	var configMap map[string]interface{}
-	err := mapstructure.Decode(m.Config, &configMap)
+	err := mapstructure.Decode(m.Config(), &configMap)
 	if err != nil {
 		server.InternalServerError(response, err)
 		return
 	}
-	if m.Config.Master.DashboardRedacted {
+	if m.Config().Master.DashboardRedacted {
 		delete(configMap, "database")
+		delete(configMap, "alert")
 	}
 	server.Ok(response, formatConfig(configMap))
 }
@@ -611,7 +823,7 @@ func (m *Master) getStats(request *restful.Request, response *restful.Response)
 	}
 	// read user neighbor index recall
 	var temp string
-	if m.Config.Recommend.UserNeighbors.EnableIndex {
+	if m.Config().Recommend.UserNeighbors.EnableIndex {
 		if temp, err = m.CacheClient.Get(ctx, cache.Key(cache.GlobalMeta, cache.UserNeighborIndexRecall)).String(); err != nil {
 			log.ResponseLogger(response).Warn("failed to get user neighbor index recall", zap.Error(err))
 		} else {
@@ -619,7 +831,7 @@ func (m *Master) getStats(request *restful.Request, response *restful.Response)
 		}
 	}
 	// read item neighbor index recall
-	if m.Config.Recommend.ItemNeighbors.EnableIndex {
+	if m.Config().Recommend.ItemNeighbors.EnableIndex {
 		if temp, err = m.CacheClient.Get(ctx, cache.Key(cache.GlobalMeta, cache.ItemNeighborIndexRecall)).String(); err != nil {
 			log.ResponseLogger(response).Warn("failed to get item neighbor index recall", zap.Error(err))
 		} else {
@@ -627,7 +839,7 @@ func (m *Master) getStats(request *restful.Request, response *restful.Response)
 		}
 	}
 	// read matching index recall
-	if m.Config.Recommend.Collaborative.EnableIndex {
+	if m.Config().Recommend.Collaborative.EnableIndex {
 		if temp, err = m.CacheClient.Get(ctx, cache.Key(cache.GlobalMeta, cache.MatchingIndexRecall)).String(); err != nil {
 			log.ResponseLogger(response).Warn("failed to get matching index recall", zap.Error(err))
 		} else {
@@ -652,6 +864,176 @@ func (m *Master) getTasks(_ *restful.Request, response *restful.Response) {
 	server.Ok(response, tasks)
 }
 
+// streamTasks sends retained task events since the requested id to the dashboard over WebSocket, followed by new
+// events until the connection is closed. Each event is a JSON message.
+func (m *Master) streamTasks(request *restful.Request, response *restful.Response) {
+	var since uint64
+	if value := request.QueryParameter("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			server.BadRequest(response, err)
+			return
+		}
+	}
+	stream := websocket.Server{
+		Handshake: checkSameOrigin,
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			// the client closes the connection if nothing is read
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+			for {
+				events, notify := m.taskMonitor.Journal.Since(since)
+				for _, event := range events {
+					if err := websocket.JSON.Send(conn, event); err != nil {
+						log.Logger().Warn("failed to send task event", zap.Error(err))
+						return
+					}
+					since = event.Id + 1
+				}
+				select {
+				case <-notify:
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+	stream.ServeHTTP(response.ResponseWriter, request.Request)
+}
+
+// checkSameOrigin rejects WebSocket connections from other sites, since the dashboard session is kept in cookies.
+func checkSameOrigin(config *websocket.Config, request *http.Request) error {
+	origin, err := websocket.Origin(config, request)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if origin == nil || origin.Host != request.Host {
+		return errors.Forbiddenf("origin %v", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// isCancellable returns true if the task stops when it is cancelled, including model training, neighbor searching and
+// offline recommendation on workers.
+func isCancellable(name string) bool {
+	switch name {
+	case TaskFitRankingModel, TaskFitClickModel, TaskFindItemNeighbors, TaskFindUserNeighbors:
+		return true
+	}
+	return strings.HasPrefix(name, "Generate offline recommendation")
+}
+
+func (m *Master) cancelTask(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("task-name")
+	if !isCancellable(name) {
+		server.BadRequest(response, errors.NotSupportedf("cancelling task %v", name))
+		return
+	}
+	if err := m.taskMonitor.Cancel(name); errors.IsNotFound(err) {
+		server.PageNotFound(response, err)
+		return
+	} else if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	server.Ok(response, server.Success{RowAffected: 1})
+}
+
+// retryTask marks a failed or cancelled task as pending and triggers the next round of tasks. Tasks on workers are
+// retried in the next round of offline recommendation.
+func (m *Master) retryTask(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("task-name")
+	if err := m.taskMonitor.Retry(name); errors.IsNotFound(err) {
+		server.PageNotFound(response, err)
+		return
+	} else if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	if m.managedMode {
+		m.triggerChan.Signal()
+	} else if m.importedChan != nil {
+		m.importedChan.Signal()
+	}
+	server.Ok(response, server.Success{RowAffected: 1})
+}
+
+func (m *Master) getConsistencyReport(_ *restful.Request, response *restful.Response) {
+	m.consistencyReportMutex.RLock()
+	report := m.consistencyReport
+	m.consistencyReportMutex.RUnlock()
+	if report == nil {
+		server.PageNotFound(response, errors.NotFoundf("consistency report"))
+		return
+	}
+	server.Ok(response, report)
+}
+
+// checkConsistencyNow checks consistency between the data store and the cache store on demand. Inconsistent entries
+// are removed if the query parameter repair is true.
+func (m *Master) checkConsistencyNow(request *restful.Request, response *restful.Response) {
+	repair, _ := strconv.ParseBool(request.QueryParameter("repair"))
+	report, err := m.checkConsistency(request.Request.Context(), repair)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, report)
+}
+
+func (m *Master) getUsage(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	// Parse parameters
+	days, err := server.ParseInt(request, "days", 7)
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	usage, err := m.RestServer.QuotaManager.Usage(ctx, days)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, usage)
+}
+
+func (m *Master) getAuditLogs(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	// Parse parameters
+	n, err := server.ParseInt(request, "n", 100)
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	beginTime, err := server.ParseTime(request, "begin")
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	endTime, err := server.ParseTime(request, "end")
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	logs, err := m.RestServer.AuditLogger.Query(ctx, n, beginTime, endTime)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, logs)
+}
+
 func (m *Master) getRates(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
@@ -663,17 +1045,95 @@ func (m *Master) getRates(request *restful.Request, response *restful.Response)
 		server.BadRequest(response, err)
 		return
 	}
-	measurements := make(map[string][]server.Measurement, len(m.Config.Recommend.DataSource.PositiveFeedbackTypes))
-	for _, feedbackType := range m.Config.Recommend.DataSource.PositiveFeedbackTypes {
-		measurements[feedbackType], err = m.RestServer.GetMeasurements(ctx, cache.Key(PositiveFeedbackRate, feedbackType), n)
-		if err != nil {
-			server.InternalServerError(response, err)
-			return
+	var measurements map[string][]server.Measurement
+	switch group := request.QueryParameter("group"); group {
+	case "", "feedback_type":
+		measurements = make(map[string][]server.Measurement, len(m.Config().Recommend.DataSource.PositiveFeedbackTypes))
+		for _, feedbackType := range m.Config().Recommend.DataSource.PositiveFeedbackTypes {
+			measurements[feedbackType], err = m.RestServer.GetMeasurements(ctx, cache.Key(PositiveFeedbackRate, feedbackType), n)
+			if err != nil {
+				server.InternalServerError(response, err)
+				return
+			}
+		}
+	case "source":
+		measurements = make(map[string][]server.Measurement, len(server.RecommendSources))
+		for _, source := range server.RecommendSources {
+			measurements[source], err = m.RestServer.GetMeasurements(ctx, cache.Key(server.SourcePositiveFeedbackRate, source), n)
+			if err != nil {
+				server.InternalServerError(response, err)
+				return
+			}
 		}
+	case "offline":
+		measurements = make(map[string][]server.Measurement, len(offlineMeasurements))
+		for _, name := range offlineMeasurements {
+			measurements[name], err = m.RestServer.GetMeasurements(ctx, name, n)
+			if err != nil {
+				server.InternalServerError(response, err)
+				return
+			}
+		}
+	default:
+		server.BadRequest(response, fmt.Errorf("unknown group `%s`", group))
+		return
 	}
 	server.Ok(response, measurements)
 }
 
+func (m *Master) getRules(request *restful.Request, response *restful.Response) {
+	rules, err := server.LoadRules(request.Request.Context(), m.CacheClient)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, rules)
+}
+
+// setRules replaces business rules. Servers apply new rules after their cache expire.
+func (m *Master) setRules(request *restful.Request, response *restful.Response) {
+	var rules []server.Rule
+	if err := request.ReadEntity(&rules); err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	if err := server.SaveRules(request.Request.Context(), m.CacheClient, rules); errors.IsNotValid(err) {
+		server.BadRequest(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, server.Success{RowAffected: len(rules)})
+}
+
+func (m *Master) getSegments(request *restful.Request, response *restful.Response) {
+	segments, err := server.LoadSegments(request.Request.Context(), m.CacheClient)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, segments)
+}
+
+// setSegments replaces user segments. Servers apply new segments after their cache expire, and workers apply new
+// exploration weights in the next offline recommendation.
+func (m *Master) setSegments(request *restful.Request, response *restful.Response) {
+	var segments []server.Segment
+	if err := request.ReadEntity(&segments); err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	if err := server.SaveSegments(request.Request.Context(), m.CacheClient, segments); errors.IsNotValid(err) {
+		server.BadRequest(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, server.Success{RowAffected: len(segments)})
+}
+
 type UserIterator struct {
 	Cursor string
 	Users  []User
@@ -721,14 +1181,34 @@ func (m *Master) getUsers(request *restful.Request, response *restful.Response)
 	}
 	// Authorize
 	cursor := request.QueryParameter("cursor")
-	n, err := server.ParseInt(request, "n", m.Config.Server.DefaultN)
+	n, err := server.ParseInt(request, "n", m.Config().Server.DefaultN)
 	if err != nil {
 		server.BadRequest(response, err)
 		return
 	}
-	// get all users
-	cursor, users, err := m.DataClient.GetUsers(ctx, cursor, n)
-	if err != nil {
+	prefix, label := request.QueryParameter("prefix"), request.QueryParameter("label")
+	match := func(user data.User) bool {
+		return strings.HasPrefix(user.UserId, prefix) && (label == "" || lo.Contains(user.Labels, label))
+	}
+	// get users
+	var users []data.User
+	switch sortBy := request.QueryParameter("sort"); sortBy {
+	case "":
+		cursor, users, err = scanFiltered(cursor, n, func(cursor string, n int) (string, []data.User, error) {
+			return m.DataClient.GetUsers(ctx, cursor, n)
+		}, match)
+	case "last_active":
+		cursor, users, err = scanFiltered(cursor, n, func(cursor string, n int) (string, []data.User, error) {
+			return m.getActiveUsers(ctx, cursor, n)
+		}, match)
+	default:
+		server.BadRequest(response, errors.NotValidf("sort %s", sortBy))
+		return
+	}
+	if errors.Is(err, strconv.ErrSyntax) {
+		server.BadRequest(response, err)
+		return
+	} else if err != nil {
 		server.InternalServerError(response, err)
 		return
 	}
@@ -747,6 +1227,103 @@ func (m *Master) getUsers(request *restful.Request, response *restful.Response)
 	server.Ok(response, UserIterator{Cursor: cursor, Users: details})
 }
 
+// getActiveUsers returns users sorted by last active time. The cursor is the offset in the sorted set of active users.
+// Users deleted from the data store are skipped.
+func (m *Master) getActiveUsers(ctx context.Context, cursor string, n int) (string, []data.User, error) {
+	offset := 0
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(cursor); err != nil {
+			return "", nil, errors.Trace(err)
+		}
+	}
+	scores, err := m.CacheClient.GetSorted(ctx, cache.ActiveUsers, offset, offset+n-1)
+	if err != nil {
+		return "", nil, errors.Trace(err)
+	}
+	users := make([]data.User, 0, len(scores))
+	for _, score := range scores {
+		user, err := m.DataClient.GetUser(ctx, score.Id)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return "", nil, errors.Trace(err)
+		}
+		users = append(users, user)
+	}
+	if len(scores) < n {
+		return "", users, nil
+	}
+	return strconv.Itoa(offset + n), users, nil
+}
+
+func (m *Master) getItems(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	cursor := request.QueryParameter("cursor")
+	n, err := server.ParseInt(request, "n", m.Config().Server.DefaultN)
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	prefix, label, category := request.QueryParameter("prefix"), request.QueryParameter("label"), request.QueryParameter("category")
+	var hidden *bool
+	if value := request.QueryParameter("hidden"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			server.BadRequest(response, err)
+			return
+		}
+		hidden = &parsed
+	}
+	cursor, items, err := scanFiltered(cursor, n, func(cursor string, n int) (string, []data.Item, error) {
+		return m.DataClient.GetItems(ctx, cursor, n, nil)
+	}, func(item data.Item) bool {
+		return strings.HasPrefix(item.ItemId, prefix) &&
+			(label == "" || lo.Contains(item.Labels, label)) &&
+			(category == "" || lo.Contains(item.Categories, category)) &&
+			(hidden == nil || item.IsHidden == *hidden)
+	})
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, server.ItemIterator{Cursor: cursor, Items: items})
+}
+
+// dashboardScanLimit is the maximum number of rows scanned to fill a page of filtered users or items. A page with
+// fewer results and a non-empty cursor is returned if the limit is exceeded, and following pages continue scanning.
+const dashboardScanLimit = 10000
+
+// scanFiltered reads pages from the cursor until n matched records are found, the scan limit is exceeded or the end
+// is reached. The size of each read never exceeds the number of missing records, so that no matched record is skipped
+// by the returned cursor.
+func scanFiltered[T any](cursor string, n int, next func(cursor string, n int) (string, []T, error), match func(T) bool) (string, []T, error) {
+	results := make([]T, 0, n)
+	for scanned := 0; len(results) < n && scanned < dashboardScanLimit; {
+		var (
+			batch []T
+			err   error
+		)
+		cursor, batch, err = next(cursor, n-len(results))
+		if err != nil {
+			return "", nil, errors.Trace(err)
+		}
+		for _, record := range batch {
+			if match(record) {
+				results = append(results, record)
+			}
+		}
+		scanned += len(batch)
+		if cursor == "" {
+			break
+		}
+	}
+	return cursor, results, nil
+}
+
 func (m *Master) getRecommend(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
@@ -756,7 +1333,7 @@ func (m *Master) getRecommend(request *restful.Request, response *restful.Respon
 	recommender := request.PathParameter("recommender")
 	userId := request.PathParameter("user-id")
 	category := request.PathParameter("category")
-	n, err := server.ParseInt(request, "n", m.Config.Server.DefaultN)
+	n, err := server.ParseInt(request, "n", m.Config().Server.DefaultN)
 	if err != nil {
 		server.BadRequest(response, err)
 		return
@@ -772,23 +1349,10 @@ func (m *Master) getRecommend(request *restful.Request, response *restful.Respon
 	case "item_based":
 		results, err = m.Recommend(ctx, response, userId, category, n, m.RecommendItemBased)
 	case "_":
-		recommenders := []server.Recommender{m.RecommendOffline}
-		for _, recommender := range m.Config.Recommend.Online.FallbackRecommend {
-			switch recommender {
-			case "collaborative":
-				recommenders = append(recommenders, m.RecommendCollaborative)
-			case "item_based":
-				recommenders = append(recommenders, m.RecommendItemBased)
-			case "user_based":
-				recommenders = append(recommenders, m.RecommendUserBased)
-			case "latest":
-				recommenders = append(recommenders, m.RecommendLatest)
-			case "popular":
-				recommenders = append(recommenders, m.RecommendPopular)
-			default:
-				server.InternalServerError(response, fmt.Errorf("unknown fallback recommendation method `%s`", recommender))
-				return
-			}
+		var recommenders []server.Recommender
+		if _, recommenders, err = m.OnlineRecommenders(ctx, userId, category); err != nil {
+			server.InternalServerError(response, err)
+			return
 		}
 		results, err = m.Recommend(ctx, response, userId, category, n, recommenders...)
 	}
@@ -808,6 +1372,25 @@ func (m *Master) getRecommend(request *restful.Request, response *restful.Respon
 	server.Ok(response, details)
 }
 
+// getDebugRecommend explains the online recommendation of a user by candidates of each recommender, filtered items
+// and the final ranking.
+func (m *Master) getDebugRecommend(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	userId := request.PathParameter("user-id")
+	category := request.QueryParameter("category")
+	n, err := server.ParseInt(request, "n", m.Config().Server.DefaultN)
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	debug, err := m.DebugRecommend(ctx, response, userId, category, n)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	server.Ok(response, debug)
+}
+
 type Feedback struct {
 	FeedbackType string
 	UserId       string
@@ -824,7 +1407,7 @@ func (m *Master) getTypedFeedbackByUser(request *restful.Request, response *rest
 	}
 	feedbackType := request.PathParameter("feedback-type")
 	userId := request.PathParameter("user-id")
-	feedback, err := m.DataClient.GetUserFeedback(ctx, userId, m.Config.Now(), feedbackType)
+	feedback, err := m.DataClient.GetUserFeedback(ctx, userId, m.Config().Now(), feedbackType)
 	if err != nil {
 		server.InternalServerError(response, err)
 		return
@@ -868,12 +1451,12 @@ func (m *Master) getSort(key, category string, isItem bool, request *restful.Req
 		server.BadRequest(response, err)
 		return
 	}
-	if n, err = server.ParseInt(request, "n", m.Config.Server.DefaultN); err != nil {
+	if n, err = server.ParseInt(request, "n", m.Config().Server.DefaultN); err != nil {
 		server.BadRequest(response, err)
 		return
 	}
 	// Get the popular list
-	scores, err := m.CacheClient.GetSorted(ctx, cache.Key(key, category), offset, m.Config.Recommend.CacheSize)
+	scores, err := m.CacheClient.GetSorted(ctx, cache.Key(key, category), offset, m.Config().Recommend.CacheSize)
 	if err != nil {
 		server.InternalServerError(response, err)
 		return
@@ -924,6 +1507,94 @@ func (m *Master) getLatest(request *restful.Request, response *restful.Response)
 	m.getSort(cache.LatestItems, category, true, request, response, data.Item{})
 }
 
+// DailyItemStats are the number of feedback of each type and the number of times the item is recommended in a day.
+type DailyItemStats struct {
+	Date     time.Time
+	Feedback map[string]int
+	Served   int
+}
+
+// ItemStats are statistics of an item for catalog managers.
+type ItemStats struct {
+	Item      data.Item
+	Feedback  map[string]int // total number of feedback of each type
+	Daily     []DailyItemStats
+	Neighbors []ScoredItem
+}
+
+func (m *Master) getItemStats(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	itemId := request.PathParameter("item-id")
+	days, err := server.ParseInt(request, "days", 30)
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	} else if days <= 0 {
+		server.BadRequest(response, fmt.Errorf("invalid days %d", days))
+		return
+	}
+	n, err := server.ParseInt(request, "n", m.Config().Server.DefaultN)
+	if err != nil {
+		server.BadRequest(response, err)
+		return
+	}
+	stats := ItemStats{Feedback: make(map[string]int), Daily: make([]DailyItemStats, days)}
+	if stats.Item, err = m.DataClient.GetItem(ctx, itemId); errors.Is(err, errors.NotFound) {
+		server.PageNotFound(response, err)
+		return
+	} else if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	// count feedback and served times of each day, from the earliest day to today
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	first := today.AddDate(0, 0, 1-days)
+	for i := range stats.Daily {
+		stats.Daily[i].Date = first.AddDate(0, 0, i)
+		stats.Daily[i].Feedback = make(map[string]int)
+		if stats.Daily[i].Served, err = m.SourceFeedbackTracker.ItemServed(ctx, itemId, stats.Daily[i].Date); err != nil {
+			server.InternalServerError(response, err)
+			return
+		}
+	}
+	feedback, err := m.DataClient.GetItemFeedback(ctx, itemId)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	for _, f := range feedback {
+		stats.Feedback[f.FeedbackType]++
+		if day := int(f.Timestamp.UTC().Truncate(24*time.Hour).Sub(first) / (24 * time.Hour)); day >= 0 && day < days {
+			stats.Daily[day].Feedback[f.FeedbackType]++
+		}
+	}
+	// load neighbors
+	neighbors, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, itemId), 0, m.Config().Recommend.CacheSize)
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	neighbors = m.FilterOutHiddenScores(response, neighbors, "")
+	if n > 0 && len(neighbors) > n {
+		neighbors = neighbors[:n]
+	}
+	stats.Neighbors = make([]ScoredItem, 0, len(neighbors))
+	for _, neighbor := range neighbors {
+		item, err := m.DataClient.GetItem(ctx, neighbor.Id)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			server.InternalServerError(response, err)
+			return
+		}
+		stats.Neighbors = append(stats.Neighbors, ScoredItem{Item: item, Score: neighbor.Score})
+	}
+	server.Ok(response, stats)
+}
+
 func (m *Master) getItemNeighbors(request *restful.Request, response *restful.Response) {
 	itemId := request.PathParameter("item-id")
 	m.getSort(cache.Key(cache.ItemNeighbors, itemId), "", true, request, response, data.Item{})
@@ -945,13 +1616,7 @@ func (m *Master) importExportUsers(response http.ResponseWriter, request *http.R
 	if request != nil {
 		ctx = request.Context()
 	}
-	if !m.checkLogin(request) {
-		resp := restful.NewResponse(response)
-		err := resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
-		if err != nil {
-			server.InternalServerError(resp, err)
-			return
-		}
+	if !m.checkAccess(response, request) {
 		return
 	}
 	switch request.Method {
@@ -1071,13 +1736,7 @@ func (m *Master) importExportItems(response http.ResponseWriter, request *http.R
 	if request != nil {
 		ctx = request.Context()
 	}
-	if !m.checkLogin(request) {
-		resp := restful.NewResponse(response)
-		err := resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
-		if err != nil {
-			server.InternalServerError(resp, err)
-			return
-		}
+	if !m.checkAccess(response, request) {
 		return
 	}
 	switch request.Method {
@@ -1193,6 +1852,7 @@ func (m *Master) importItems(ctx context.Context, response http.ResponseWriter,
 		}
 		// 6. comment
 		item.Comment = splits[5]
+		item.Categories = m.Config().Recommend.DataSource.DeriveCategories(item.Categories, item.Labels)
 		items = append(items, item)
 		// batch insert
 		if len(items) == batchSize {
@@ -1201,6 +1861,7 @@ func (m *Master) importItems(ctx context.Context, response http.ResponseWriter,
 				server.InternalServerError(restful.NewResponse(response), err)
 				return false
 			}
+			m.IndexItems(ctx, items)
 			items = nil
 		}
 		lineCount++
@@ -1216,6 +1877,7 @@ func (m *Master) importItems(ctx context.Context, response http.ResponseWriter,
 			server.InternalServerError(restful.NewResponse(response), err)
 			return
 		}
+		m.IndexItems(ctx, items)
 	}
 	m.notifyDataImported()
 	timeUsed := time.Since(timeStart)
@@ -1259,8 +1921,7 @@ func (m *Master) importExportFeedback(response http.ResponseWriter, request *htt
 	if request != nil {
 		ctx = request.Context()
 	}
-	if !m.checkLogin(request) {
-		writeError(response, http.StatusUnauthorized, "unauthorized")
+	if !m.checkAccess(response, request) {
 		return
 	}
 	switch request.Method {
@@ -1274,7 +1935,7 @@ func (m *Master) importExportFeedback(response http.ResponseWriter, request *htt
 			return
 		}
 		// write rows
-		feedbackChan, errChan := m.DataClient.GetFeedbackStream(ctx, batchSize, nil, m.Config.Now())
+		feedbackChan, errChan := m.DataClient.GetFeedbackStream(ctx, batchSize, nil, m.Config().Now())
 		for feedback := range feedbackChan {
 			for _, v := range feedback {
 				if _, err = response.Write([]byte(fmt.Sprintf("%s,%s,%s,%v\r\n",
@@ -1360,8 +2021,8 @@ func (m *Master) importFeedback(ctx context.Context, response http.ResponseWrite
 		if len(feedbacks) == batchSize {
 			// batch insert to data store
 			err = m.DataClient.BatchInsertFeedback(ctx, feedbacks,
-				m.Config.Server.AutoInsertUser,
-				m.Config.Server.AutoInsertItem, true)
+				m.Config().Server.AutoInsertUser,
+				m.Config().Server.AutoInsertItem, true)
 			if err != nil {
 				server.InternalServerError(restful.NewResponse(response), err)
 				return false
@@ -1385,8 +2046,8 @@ func (m *Master) importFeedback(ctx context.Context, response http.ResponseWrite
 	if len(feedbacks) > 0 {
 		// insert to data store
 		err = m.DataClient.BatchInsertFeedback(ctx, feedbacks,
-			m.Config.Server.AutoInsertUser,
-			m.Config.Server.AutoInsertItem, true)
+			m.Config().Server.AutoInsertUser,
+			m.Config().Server.AutoInsertItem, true)
 		if err != nil {
 			server.InternalServerError(restful.NewResponse(response), err)
 			return
@@ -1415,17 +2076,16 @@ func (m *Master) purge(response http.ResponseWriter, request *http.Request) {
 		return
 	}
 	// check login
-	if !m.checkLogin(request) {
-		resp := restful.NewResponse(response)
-		err := resp.WriteErrorString(http.StatusUnauthorized, "unauthorized")
-		if err != nil {
-			server.InternalServerError(resp, err)
-			return
-		}
+	if !m.checkAccess(response, request) {
 		return
 	}
 	// check password
-	if m.Config.Master.DashboardPassword == "" {
+	accounts, err := m.loadAccounts(request.Context())
+	if err != nil {
+		writeError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if m.Config().Master.DashboardPassword == "" && len(accounts) == 0 {
 		writeError(response, http.StatusUnauthorized, "purge is not allowed without dashboard password")
 		return
 	}
@@ -1491,10 +2151,10 @@ func writeError(response http.ResponseWriter, httpStatus int, message string) {
 }
 
 func (s *Master) checkAdmin(request *http.Request) bool {
-	if s.Config.Master.AdminAPIKey == "" {
+	if s.Config().Master.AdminAPIKey == "" {
 		return true
 	}
-	if request.FormValue("X-API-Key") == s.Config.Master.AdminAPIKey {
+	if request.FormValue("X-API-Key") == s.Config().Master.AdminAPIKey {
 		return true
 	}
 	return false
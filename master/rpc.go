@@ -17,8 +17,10 @@ package master
 import (
 	"context"
 	"encoding/json"
+	"github.com/ReneKroon/ttlcache/v2"
 	"github.com/juju/errors"
 	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/base/task"
 	"github.com/zhenghaoz/gorse/model/click"
 	"github.com/zhenghaoz/gorse/model/ranking"
 	"github.com/zhenghaoz/gorse/protocol"
@@ -73,7 +75,7 @@ func (m *Master) GetMeta(ctx context.Context, nodeInfo *protocol.NodeInfo) (*pro
 		}
 	}
 	// marshall config
-	s, err := json.Marshal(m.Config)
+	s, err := json.Marshal(m.Config())
 	if err != nil {
 		return nil, err
 	}
@@ -104,14 +106,29 @@ func (m *Master) GetMeta(ctx context.Context, nodeInfo *protocol.NodeInfo) (*pro
 		}
 	}
 	m.nodesInfoMutex.RUnlock()
-	return &protocol.Meta{
+	meta := &protocol.Meta{
 		Config:              string(s),
 		RankingModelVersion: rankingModelVersion,
 		ClickModelVersion:   clickModelVersion,
 		Me:                  nodeInfo.NodeName,
 		Workers:             workers,
 		Servers:             servers,
-	}, nil
+	}
+	// assign user shards
+	if node.Type == WorkerNode {
+		meta.NumUserShards = numUserShards
+		meta.UserShards = m.getUserShards(ctx, workers, nodeInfo.NodeName)
+	}
+	return meta, nil
+}
+
+// Deregister removes a node before it shuts down, so that it is neither listed nor assigned user shards any longer.
+func (m *Master) Deregister(_ context.Context, nodeInfo *protocol.NodeInfo) (*protocol.DeregisterResponse, error) {
+	if err := m.ttlCache.Remove(nodeInfo.NodeName); err != nil && err != ttlcache.ErrNotFound {
+		log.Logger().Error("failed to remove node from ttl cache", zap.Error(err))
+		return nil, err
+	}
+	return &protocol.DeregisterResponse{}, nil
 }
 
 // GetRankingModel returns latest ranking model.
@@ -128,37 +145,20 @@ func (m *Master) GetRankingModel(version *protocol.VersionInfo, sender protocol.
 	}
 	// encode model
 	reader, writer := io.Pipe()
-	var encoderError error
+	defer reader.Close()
 	go func() {
-		defer func(writer *io.PipeWriter) {
-			err := writer.Close()
-			if err != nil {
-				log.Logger().Error("fail to close pipe", zap.Error(err))
-			}
-		}(writer)
 		err := ranking.MarshalModel(writer, m.RankingModel)
 		if err != nil {
 			log.Logger().Error("fail to marshal ranking model", zap.Error(err))
-			encoderError = err
-			return
 		}
+		_ = writer.CloseWithError(err)
 	}()
 	// send model
-	for {
-		buf := make([]byte, batchSize)
-		n, err := reader.Read(buf)
-		if err == io.EOF {
-			log.Logger().Debug("complete sending ranking model")
-			break
-		} else if err != nil {
-			return err
-		}
-		err = sender.Send(&protocol.Fragment{Data: buf[:n]})
-		if err != nil {
-			return err
-		}
+	if err := protocol.SendFragments(sender, reader, version.Offset, version.Compress); err != nil {
+		return err
 	}
-	return encoderError
+	log.Logger().Debug("complete sending ranking model", zap.Int64("offset", version.Offset), zap.Bool("compress", version.Compress))
+	return nil
 }
 
 // GetClickModel returns latest click model.
@@ -175,37 +175,23 @@ func (m *Master) GetClickModel(version *protocol.VersionInfo, sender protocol.Ma
 	}
 	// encode model
 	reader, writer := io.Pipe()
-	var encoderError error
+	defer reader.Close()
 	go func() {
-		defer func(writer *io.PipeWriter) {
-			err := writer.Close()
-			if err != nil {
-				log.Logger().Error("fail to close pipe", zap.Error(err))
-			}
-		}(writer)
 		err := click.MarshalModel(writer, m.ClickModel)
+		if err == nil {
+			err = click.MarshalObjectives(writer, m.ObjectiveModels)
+		}
 		if err != nil {
 			log.Logger().Error("fail to marshal click model", zap.Error(err))
-			encoderError = err
-			return
 		}
+		_ = writer.CloseWithError(err)
 	}()
 	// send model
-	for {
-		buf := make([]byte, batchSize)
-		n, err := reader.Read(buf)
-		if err == io.EOF {
-			log.Logger().Debug("complete sending click model")
-			break
-		} else if err != nil {
-			return err
-		}
-		err = sender.Send(&protocol.Fragment{Data: buf[:n]})
-		if err != nil {
-			return err
-		}
+	if err := protocol.SendFragments(sender, reader, version.Offset, version.Compress); err != nil {
+		return err
 	}
-	return encoderError
+	log.Logger().Debug("complete sending click model", zap.Int64("offset", version.Offset), zap.Bool("compress", version.Compress))
+	return nil
 }
 
 // nodeUp handles node information inserted events.
@@ -237,6 +223,21 @@ func (m *Master) PushTaskInfo(
 	in *protocol.PushTaskInfoRequest) (*protocol.PushTaskInfoResponse, error) {
 	m.taskMonitor.TaskLock.Lock()
 	defer m.taskMonitor.TaskLock.Unlock()
-	m.taskMonitor.Tasks[in.GetName()] = protocol.DecodeTask(in)
+	t := protocol.DecodeTask(in)
+	t.Estimate()
+	// keep the cancelled task until the worker stops it
+	prev, exist := m.taskMonitor.Tasks[in.GetName()]
+	if exist && prev.IsCancelled() &&
+		prev.StartTime.Equal(t.StartTime) && (t.Status == task.StatusRunning || t.Status == task.StatusSuspended) {
+		prev.Done = t.Done
+		prev.Estimate()
+		return &protocol.PushTaskInfoResponse{Cancelled: true}, nil
+	}
+	m.taskMonitor.Tasks[in.GetName()] = t
+	if exist && prev.StartTime.Equal(t.StartTime) {
+		m.taskMonitor.Journal.Observe(prev.Status, prev.Progress, t)
+	} else {
+		m.taskMonitor.Journal.Observe("", 0, t)
+	}
 	return &protocol.PushTaskInfoResponse{}, nil
 }
@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/ReneKroon/ttlcache/v2"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/zhenghaoz/gorse/base/task"
 	"github.com/zhenghaoz/gorse/config"
@@ -50,25 +51,27 @@ func newMockMasterRPC(_ *testing.T) *mockMasterRPC {
 	trainSet, testSet := newRankingDataset()
 	bpr := ranking.NewBPR(model.Params{model.NEpochs: 0})
 	bpr.Fit(trainSet, testSet, nil)
-	return &mockMasterRPC{
+	m := &mockMasterRPC{
 		Master: Master{
 			taskMonitor:      task.NewTaskMonitor(),
 			nodesInfo:        make(map[string]*Node),
 			rankingModelName: "bpr",
 			RestServer: server.RestServer{
 				Settings: &config.Settings{
-					Config:              config.GetDefaultConfig(),
 					CacheClient:         cache.NoDatabase{},
 					DataClient:          data.NoDatabase{},
 					RankingModel:        bpr,
 					ClickModel:          fm,
 					RankingModelVersion: 123,
 					ClickModelVersion:   456,
+					ObjectiveModels:     map[string]click.FactorizationMachine{"purchase": fm},
 				},
 			},
 		},
 		addr: make(chan string),
 	}
+	m.SetConfig(config.GetDefaultConfig())
+	return m
 }
 
 func (m *mockMasterRPC) Start(t *testing.T) {
@@ -101,6 +104,7 @@ func TestRPC(t *testing.T) {
 	client := protocol.NewMasterClient(conn)
 	ctx := context.Background()
 
+	rpcServer.taskMonitor.Journal = task.NewJournal(100)
 	testTask := task.NewTask("a", 12)
 	_, err = client.PushTaskInfo(ctx, protocol.EncodeTask(testTask))
 	assert.NoError(t, err)
@@ -121,13 +125,42 @@ func TestRPC(t *testing.T) {
 	assert.Equal(t, 12, rpcServer.taskMonitor.Tasks["a"].Total)
 	assert.Equal(t, 12, rpcServer.taskMonitor.Tasks["a"].Done)
 	assert.Equal(t, task.StatusComplete, rpcServer.taskMonitor.Tasks["a"].Status)
+	events, _ := rpcServer.taskMonitor.Journal.Since(0)
+	assert.Equal(t, []int{0, 10, 12}, lo.Map(events, func(event task.Event, _ int) int { return event.Done }))
+
+	// cancel task on worker
+	testTask = task.NewTask("b", 12)
+	_, err = client.PushTaskInfo(ctx, protocol.EncodeTask(testTask))
+	assert.NoError(t, err)
+	assert.NoError(t, rpcServer.taskMonitor.Cancel("b"))
+	testTask.Update(6)
+	resp, err := client.PushTaskInfo(ctx, protocol.EncodeTask(testTask))
+	assert.NoError(t, err)
+	assert.True(t, resp.Cancelled)
+	assert.Equal(t, 6, rpcServer.taskMonitor.Tasks["b"].Done)
+	assert.Equal(t, task.StatusCancelled, rpcServer.taskMonitor.Tasks["b"].Status)
+	testTask = task.NewTask("b", 12)
+	testTask.StartTime = testTask.StartTime.Add(time.Second)
+	resp, err = client.PushTaskInfo(ctx, protocol.EncodeTask(testTask))
+	assert.NoError(t, err)
+	assert.False(t, resp.Cancelled)
+	assert.Equal(t, task.StatusRunning, rpcServer.taskMonitor.Tasks["b"].Status)
 
 	// test get click model
 	clickModelReceiver, err := client.GetClickModel(ctx, &protocol.VersionInfo{Version: 456})
 	assert.NoError(t, err)
-	clickModel, err := protocol.UnmarshalClickModel(clickModelReceiver)
+	clickModel, objectiveModels, err := protocol.UnmarshalClickModel(clickModelReceiver)
+	assert.NoError(t, err)
+	assert.Equal(t, rpcServer.ClickModel, clickModel)
+	assert.Equal(t, rpcServer.ObjectiveModels, objectiveModels)
+
+	// test get compressed click model
+	clickModelReceiver, err = client.GetClickModel(ctx, &protocol.VersionInfo{Version: 456, Compress: true})
+	assert.NoError(t, err)
+	clickModel, objectiveModels, err = protocol.UnmarshalClickModel(clickModelReceiver)
 	assert.NoError(t, err)
 	assert.Equal(t, rpcServer.ClickModel, clickModel)
+	assert.Equal(t, rpcServer.ObjectiveModels, objectiveModels)
 
 	// test get ranking model
 	rankingModelReceiver, err := client.GetRankingModel(ctx, &protocol.VersionInfo{Version: 123})
@@ -148,11 +181,13 @@ func TestRPC(t *testing.T) {
 	assert.Equal(t, int64(456), metaResp.ClickModelVersion)
 	assert.Equal(t, "worker1", metaResp.Me)
 	assert.Equal(t, []string{"server1"}, metaResp.Servers)
+	assert.Equal(t, int32(numUserShards), metaResp.NumUserShards)
+	assert.Len(t, metaResp.UserShards, numUserShards)
 	assert.Equal(t, []string{"worker1"}, metaResp.Workers)
 	var cfg config.Config
 	err = json.Unmarshal([]byte(metaResp.Config), &cfg)
 	assert.NoError(t, err)
-	assert.Equal(t, rpcServer.Config, &cfg)
+	assert.Equal(t, rpcServer.Config(), &cfg)
 
 	time.Sleep(time.Second * 2)
 	metaResp, err = client.GetMeta(ctx,
@@ -160,5 +195,17 @@ func TestRPC(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"worker2"}, metaResp.Workers)
 
+	// test deregister
+	_, err = client.Deregister(ctx, &protocol.NodeInfo{NodeType: protocol.NodeType_WorkerNode, NodeName: "worker2"})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		rpcServer.nodesInfoMutex.RLock()
+		defer rpcServer.nodesInfoMutex.RUnlock()
+		_, exist := rpcServer.nodesInfo["worker2"]
+		return !exist
+	}, time.Second, 10*time.Millisecond)
+	_, err = client.Deregister(ctx, &protocol.NodeInfo{NodeType: protocol.NodeType_WorkerNode, NodeName: "worker2"})
+	assert.NoError(t, err)
+
 	rpcServer.Stop()
 }
@@ -0,0 +1,137 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/robfig/cron/v3"
+	"github.com/zhenghaoz/gorse/config"
+)
+
+// Schedule decides when offline jobs are started by cron expressions and blackout windows. Jobs without cron
+// expressions are started whenever their loops wake up.
+type Schedule struct {
+	mutex     sync.Mutex
+	crons     map[string]cron.Schedule
+	next      map[string]time.Time
+	blackouts []config.BlackoutWindow
+}
+
+// NewSchedule creates a Schedule for tasks of the master. Cron jobs are due for the first time after now.
+func NewSchedule(cfg *config.MasterConfig, now time.Time) (*Schedule, error) {
+	s := &Schedule{
+		crons:     make(map[string]cron.Schedule),
+		next:      make(map[string]time.Time),
+		blackouts: cfg.BlackoutWindows,
+	}
+	for _, job := range []struct {
+		expr  string
+		tasks []string
+	}{
+		{cfg.FitCron, []string{TaskFitRankingModel, TaskFitClickModel}},
+		{cfg.NeighborCron, []string{TaskFindItemNeighbors, TaskFindUserNeighbors, TaskFindAlsoLikedItems}},
+		{cfg.SearchCron, []string{TaskSearchRankingModel, TaskSearchClickModel}},
+	} {
+		if job.expr == "" {
+			continue
+		}
+		schedule, err := cron.ParseStandard(job.expr)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid cron expression `%v`", job.expr)
+		}
+		for _, name := range job.tasks {
+			s.crons[name] = schedule
+			s.next[name] = schedule.Next(now)
+		}
+	}
+	return s, nil
+}
+
+// InBlackout returns true if jobs must not be started at the time.
+func (s *Schedule) InBlackout(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+	for _, window := range s.blackouts {
+		if window.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Due returns true if the task should be started at the time. A due cron job stays due until it is committed.
+func (s *Schedule) Due(name string, now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if s.InBlackout(now) {
+		return false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exist := s.crons[name]; !exist {
+		return true
+	}
+	return !now.Before(s.next[name])
+}
+
+// Commit advances the next time of a cron job once it has been started at the time.
+func (s *Schedule) Commit(name string, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if schedule, exist := s.crons[name]; exist {
+		s.next[name] = schedule.Next(now)
+	}
+}
+
+// NextWake returns the earliest time one of the cron tasks becomes due, or zero if none of the tasks has a cron
+// expression. Times in blackout windows are postponed to the ends of the windows.
+func (s *Schedule) NextWake(now time.Time, names ...string) time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mutex.Lock()
+	var wake time.Time
+	for _, name := range names {
+		if next, exist := s.next[name]; exist && (wake.IsZero() || next.Before(wake)) {
+			wake = next
+		}
+	}
+	s.mutex.Unlock()
+	for i := 0; i < len(s.blackouts) && !wake.IsZero(); i++ {
+		for _, window := range s.blackouts {
+			if window.Contains(wake) {
+				wake = window.NextEnd(wake)
+			}
+		}
+	}
+	return wake
+}
+
+// sleepUntil returns the duration to sleep until the time. It sleeps a minute if the time has passed, since cron
+// expressions are accurate to minutes.
+func sleepUntil(now, wake time.Time) time.Duration {
+	if d := wake.Sub(now); d > 0 {
+		return d
+	}
+	return time.Minute
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/config"
+)
+
+func TestSchedule(t *testing.T) {
+	// jobs without cron expressions are always due
+	var schedule *Schedule
+	assert.True(t, schedule.Due(TaskFitRankingModel, time.Now()))
+	assert.True(t, schedule.NextWake(time.Now(), TaskFitRankingModel).IsZero())
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.Local)
+	schedule, err := NewSchedule(&config.MasterConfig{
+		FitCron:         "0 3 * * *",
+		BlackoutWindows: []config.BlackoutWindow{{Start: "18:00", End: "22:00"}},
+	}, now)
+	assert.NoError(t, err)
+	assert.True(t, schedule.Due(TaskFindItemNeighbors, now))
+	assert.True(t, schedule.NextWake(now, TaskFindItemNeighbors).IsZero())
+
+	// cron jobs are due at scheduled time
+	assert.False(t, schedule.Due(TaskFitRankingModel, now))
+	assert.Equal(t, now.Add(15*time.Hour), schedule.NextWake(now, TaskFitRankingModel, TaskFindItemNeighbors))
+	now = now.Add(15 * time.Hour)
+	assert.True(t, schedule.Due(TaskFitRankingModel, now))
+	assert.True(t, schedule.Due(TaskFitRankingModel, now))
+	schedule.Commit(TaskFitRankingModel, now)
+	assert.False(t, schedule.Due(TaskFitRankingModel, now))
+	assert.True(t, schedule.Due(TaskFitClickModel, now))
+
+	// jobs are not started in blackout windows
+	now = time.Date(2022, 1, 2, 19, 0, 0, 0, time.Local)
+	assert.True(t, schedule.InBlackout(now))
+	assert.False(t, schedule.Due(TaskFindItemNeighbors, now))
+	schedule, err = NewSchedule(&config.MasterConfig{
+		FitCron:         "0 20 * * *",
+		BlackoutWindows: []config.BlackoutWindow{{Start: "18:00", End: "22:00"}},
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 2, 22, 0, 0, 0, time.Local), schedule.NextWake(now, TaskFitRankingModel))
+	now = time.Date(2022, 1, 2, 22, 0, 0, 0, time.Local)
+	assert.True(t, schedule.Due(TaskFitRankingModel, now))
+
+	// invalid cron expression
+	_, err = NewSchedule(&config.MasterConfig{FitCron: "every day"}, now)
+	assert.Error(t, err)
+}
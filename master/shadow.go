@@ -0,0 +1,81 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"time"
+
+	"github.com/zhenghaoz/gorse/base/task"
+	"github.com/zhenghaoz/gorse/model"
+	"github.com/zhenghaoz/gorse/model/ranking"
+)
+
+// FeedbackReplay records the latest positive feedback of each user in a time window. Recorded feedback is held out
+// from training and replayed to evaluate models before they are promoted.
+type FeedbackReplay struct {
+	since      int64
+	positions  []int32
+	timestamps []int64
+}
+
+// NewFeedbackReplay creates a FeedbackReplay of feedback after the time.
+func NewFeedbackReplay(since time.Time) *FeedbackReplay {
+	return &FeedbackReplay{since: since.UnixNano()}
+}
+
+// Record positive feedback at the position of the feedback list of a user.
+func (r *FeedbackReplay) Record(userIndex int32, position int, timestamp time.Time) {
+	if r == nil || timestamp.UnixNano() <= r.since {
+		return
+	}
+	for int(userIndex) >= len(r.positions) {
+		r.positions = append(r.positions, -1)
+		r.timestamps = append(r.timestamps, 0)
+	}
+	if r.positions[userIndex] < 0 || timestamp.UnixNano() >= r.timestamps[userIndex] {
+		r.positions[userIndex] = int32(position)
+		r.timestamps[userIndex] = timestamp.UnixNano()
+	}
+}
+
+// Positions returns positions of the latest feedback of users, -1 for users without recent feedback.
+func (r *FeedbackReplay) Positions() []int32 {
+	return r.positions
+}
+
+// Count returns the number of users with recent feedback.
+func (r *FeedbackReplay) Count() int {
+	count := 0
+	for _, position := range r.positions {
+		if position >= 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// shadowScore scores a copy of the model on the test set without training. Parameters of the model are relocated to
+// indices of the dataset by fitting zero epochs.
+func shadowScore(m ranking.MatrixFactorization, trainSet, testSet *ranking.DataSet, j *task.JobsAllocator) ranking.Score {
+	shadow := ranking.Clone(m)
+	shadow.SetParams(shadow.GetParams().Overwrite(model.Params{model.NEpochs: 0}))
+	return shadow.Fit(trainSet, testSet, ranking.NewFitConfig().SetJobsAllocator(j))
+}
+
+// regressed returns true if NDCG or recall of the candidate drops by more than maxRegression relative to the baseline.
+func regressed(candidate, baseline ranking.Score, maxRegression float32) bool {
+	return candidate.NDCG < baseline.NDCG*(1-maxRegression) ||
+		candidate.Recall < baseline.Recall*(1-maxRegression)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/base/task"
+	"github.com/zhenghaoz/gorse/model"
+	"github.com/zhenghaoz/gorse/model/ranking"
+)
+
+func TestFeedbackReplay(t *testing.T) {
+	now := time.Now()
+	replay := NewFeedbackReplay(now.Add(-time.Hour))
+	replay.Record(0, 0, now.Add(-2*time.Hour))
+	replay.Record(1, 0, now.Add(-time.Minute))
+	replay.Record(1, 1, now.Add(-2*time.Minute))
+	replay.Record(1, 2, now)
+	replay.Record(3, 5, now)
+	assert.Equal(t, []int32{-1, 2, -1, 5}, replay.Positions())
+	assert.Equal(t, 2, replay.Count())
+
+	// nil replay records nothing
+	var nilReplay *FeedbackReplay
+	nilReplay.Record(0, 0, now)
+}
+
+func TestRegressed(t *testing.T) {
+	baseline := ranking.Score{NDCG: 0.5, Recall: 0.4}
+	assert.False(t, regressed(ranking.Score{NDCG: 0.5, Recall: 0.4}, baseline, 0))
+	assert.False(t, regressed(ranking.Score{NDCG: 0.48, Recall: 0.39}, baseline, 0.05))
+	assert.True(t, regressed(ranking.Score{NDCG: 0.47, Recall: 0.4}, baseline, 0.05))
+	assert.True(t, regressed(ranking.Score{NDCG: 0.5, Recall: 0.37}, baseline, 0.05))
+}
+
+func TestShadowScore(t *testing.T) {
+	dataset := ranking.NewMapIndexDataset()
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 5; j++ {
+			dataset.AddFeedback(strconv.Itoa(i), strconv.Itoa(i+j), true)
+		}
+	}
+	trainSet, testSet := dataset.Split(0, 0)
+	bpr := ranking.NewBPR(model.Params{model.NEpochs: 3})
+	score := bpr.Fit(trainSet, testSet, ranking.NewFitConfig().SetJobsAllocator(task.NewConstantJobsAllocator(1)))
+
+	// score the model without training
+	shadow := shadowScore(bpr, trainSet, testSet, task.NewConstantJobsAllocator(1))
+	assert.Equal(t, score, shadow)
+	assert.Equal(t, 3, bpr.Complexity())
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+)
+
+// numUserShards is the number of user shards assigned to workers.
+const numUserShards = 1024
+
+// UserShards assigns shards of users to workers. Shards only move when their owners leave or workers are unbalanced,
+// so that workers don't recompute users of shards they keep.
+type UserShards struct {
+	Owners []string `json:"owners"` // owner of each shard, empty if unassigned
+}
+
+// NewUserShards creates n unassigned shards.
+func NewUserShards(n int) *UserShards {
+	return &UserShards{Owners: make([]string, n)}
+}
+
+// Rebalance assigns shards to workers so that the numbers of shards owned by workers differ by at most one. Shards of
+// workers not in the list are reassigned, and shards are taken from workers owning more than their share only. It
+// returns true if any shard is moved.
+func (s *UserShards) Rebalance(workers []string) bool {
+	if len(workers) == 0 {
+		return false
+	}
+	workers = strset.New(workers...).List()
+	alive := strset.New(workers...)
+	owned := make(map[string][]int)
+	var free []int
+	for shard, owner := range s.Owners {
+		if alive.Has(owner) {
+			owned[owner] = append(owned[owner], shard)
+		} else {
+			free = append(free, shard)
+		}
+	}
+	// workers owning more shards keep the remainder
+	sort.Slice(workers, func(i, j int) bool {
+		if len(owned[workers[i]]) != len(owned[workers[j]]) {
+			return len(owned[workers[i]]) > len(owned[workers[j]])
+		}
+		return workers[i] < workers[j]
+	})
+	targets := make(map[string]int)
+	for i, worker := range workers {
+		targets[worker] = len(s.Owners) / len(workers)
+		if i < len(s.Owners)%len(workers) {
+			targets[worker]++
+		}
+		if len(owned[worker]) > targets[worker] {
+			free = append(free, owned[worker][targets[worker]:]...)
+			owned[worker] = owned[worker][:targets[worker]]
+		}
+	}
+	// assign free shards
+	sort.Ints(free)
+	changed := false
+	for _, worker := range workers {
+		for ; len(owned[worker]) < targets[worker]; free = free[1:] {
+			owned[worker] = append(owned[worker], free[0])
+			if s.Owners[free[0]] != worker {
+				s.Owners[free[0]] = worker
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// Shards returns shards owned by a worker.
+func (s *UserShards) Shards(worker string) []int32 {
+	shards := make([]int32, 0)
+	for shard, owner := range s.Owners {
+		if owner == worker {
+			shards = append(shards, int32(shard))
+		}
+	}
+	return shards
+}
+
+// getUserShards rebalances user shards among workers and returns shards owned by the worker. The assignment is
+// persisted in the cache store. Shards of workers that haven't reconnected are kept within the node timeout after the
+// master starts.
+func (m *Master) getUserShards(ctx context.Context, workers []string, worker string) []int32 {
+	m.userShardsMutex.Lock()
+	defer m.userShardsMutex.Unlock()
+	// load assignment
+	if m.userShards == nil {
+		m.userShards = NewUserShards(numUserShards)
+		if data, err := m.CacheClient.Get(ctx, cache.Key(cache.GlobalMeta, cache.UserShards)).String(); err == nil {
+			var userShards UserShards
+			if err = cache.Unmarshal(data, &userShards); err != nil {
+				log.Logger().Warn("failed to unmarshal user shards", zap.Error(err))
+			} else if len(userShards.Owners) == numUserShards {
+				m.userShards = &userShards
+			}
+		} else if !errors.Is(err, errors.NotFound) {
+			log.Logger().Warn("failed to load user shards", zap.Error(err))
+		}
+	}
+	// keep shards of workers within the node timeout
+	if time.Since(m.startTime) < m.Config().Master.MetaTimeout+10*time.Second {
+		owners := strset.New(m.userShards.Owners...)
+		owners.Remove("")
+		workers = append(owners.List(), workers...)
+	}
+	// rebalance and save assignment
+	if m.userShards.Rebalance(workers) {
+		log.Logger().Info("rebalance user shards", zap.Strings("workers", workers))
+		data, err := cache.Marshal(m.CacheClient, m.userShards)
+		if err != nil {
+			log.Logger().Warn("failed to marshal user shards", zap.Error(err))
+		} else if err = m.CacheClient.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.UserShards), data)); err != nil {
+			log.Logger().Warn("failed to save user shards", zap.Error(err))
+		}
+	}
+	return m.userShards.Shards(worker)
+}
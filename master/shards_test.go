@@ -0,0 +1,96 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func countMoved(before, after []string) int {
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+		}
+	}
+	return moved
+}
+
+func TestUserShards_Rebalance(t *testing.T) {
+	shards := NewUserShards(10)
+	assert.False(t, shards.Rebalance(nil))
+
+	// assign all shards to the first worker
+	assert.True(t, shards.Rebalance([]string{"a"}))
+	assert.Len(t, shards.Shards("a"), 10)
+	assert.False(t, shards.Rebalance([]string{"a"}))
+
+	// move half of shards to the new worker
+	before := append([]string(nil), shards.Owners...)
+	assert.True(t, shards.Rebalance([]string{"a", "b"}))
+	assert.Len(t, shards.Shards("a"), 5)
+	assert.Len(t, shards.Shards("b"), 5)
+	assert.Equal(t, 5, countMoved(before, shards.Owners))
+
+	// move shards to the new worker only
+	before = append([]string(nil), shards.Owners...)
+	assert.True(t, shards.Rebalance([]string{"c", "b", "a"}))
+	assert.Len(t, shards.Shards("a"), 4)
+	assert.Len(t, shards.Shards("b"), 3)
+	assert.Len(t, shards.Shards("c"), 3)
+	assert.Equal(t, 3, countMoved(before, shards.Owners))
+	for i, owner := range before {
+		if shards.Owners[i] != owner {
+			assert.Equal(t, "c", shards.Owners[i])
+		}
+	}
+
+	// move shards of the removed worker only
+	before = append([]string(nil), shards.Owners...)
+	assert.True(t, shards.Rebalance([]string{"a", "c"}))
+	assert.Len(t, shards.Shards("a"), 5)
+	assert.Len(t, shards.Shards("c"), 5)
+	assert.Equal(t, 3, countMoved(before, shards.Owners))
+	for i, owner := range before {
+		if shards.Owners[i] != owner {
+			assert.Equal(t, "b", owner)
+		}
+	}
+}
+
+func TestMaster_GetUserShards(t *testing.T) {
+	s, _ := newMockServer(t)
+	defer s.Close(t)
+	ctx := context.Background()
+	assert.Len(t, s.getUserShards(ctx, []string{"a", "b"}, "a"), numUserShards/2)
+	assert.Len(t, s.getUserShards(ctx, []string{"a", "b"}, "b"), numUserShards/2)
+
+	// load assignment after restart
+	s.userShards = nil
+	s.startTime = time.Now()
+	shardsA := s.getUserShards(ctx, []string{"a"}, "a")
+	assert.Len(t, shardsA, numUserShards/2)
+	shardsB := s.getUserShards(ctx, []string{"a", "b"}, "b")
+	assert.Len(t, shardsB, numUserShards/2)
+	assert.NotEqual(t, shardsA, shardsB)
+
+	// reassign shards of left workers after timeout
+	s.startTime = time.Time{}
+	assert.Len(t, s.getUserShards(ctx, []string{"a"}, "a"), numUserShards)
+}
@@ -25,17 +25,18 @@ import (
 	"github.com/chewxy/math32"
 	"github.com/juju/errors"
 	"github.com/samber/lo"
-	"github.com/scylladb/go-set/i32set"
 	"github.com/zhenghaoz/gorse/base"
 	"github.com/zhenghaoz/gorse/base/encoding"
 	"github.com/zhenghaoz/gorse/base/heap"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/base/parallel"
 	"github.com/zhenghaoz/gorse/base/search"
+	"github.com/zhenghaoz/gorse/base/spill"
 	"github.com/zhenghaoz/gorse/base/task"
 	"github.com/zhenghaoz/gorse/config"
 	"github.com/zhenghaoz/gorse/model/click"
 	"github.com/zhenghaoz/gorse/model/ranking"
+	"github.com/zhenghaoz/gorse/server"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
 	"go.uber.org/atomic"
@@ -44,11 +45,21 @@ import (
 )
 
 const (
-	PositiveFeedbackRate = "PositiveFeedbackRate"
+	PositiveFeedbackRate    = "PositiveFeedbackRate"
+	RankingModelNDCG        = "RankingModelNDCG"
+	RankingModelRecall      = "RankingModelRecall"
+	RankingModelPrecision   = "RankingModelPrecision"
+	ClickModelPrecision     = "ClickModelPrecision"
+	ClickModelRecall        = "ClickModelRecall"
+	ClickModelAUC           = "ClickModelAUC"
+	RecommendationCoverage  = "RecommendationCoverage"
+	RecommendationNovelty   = "RecommendationNovelty"
+	RecommendationDiversity = "RecommendationDiversity"
 
 	TaskLoadDataset            = "Load dataset"
 	TaskFindItemNeighbors      = "Find neighbors of items"
 	TaskFindUserNeighbors      = "Find neighbors of users"
+	TaskFindAlsoLikedItems     = "Find also liked items"
 	TaskFitRankingModel        = "Fit collaborative filtering model"
 	TaskFitClickModel          = "Fit click-through rate prediction model"
 	TaskSearchRankingModel     = "Search collaborative filtering  model"
@@ -70,17 +81,21 @@ func (m *Master) runLoadDatasetTask() error {
 	ctx := context.Background()
 	initialStartTime := time.Now()
 	log.Logger().Info("load dataset",
-		zap.Strings("positive_feedback_types", m.Config.Recommend.DataSource.PositiveFeedbackTypes),
-		zap.Strings("read_feedback_types", m.Config.Recommend.DataSource.ReadFeedbackTypes),
-		zap.Uint("item_ttl", m.Config.Recommend.DataSource.ItemTTL),
-		zap.Uint("feedback_ttl", m.Config.Recommend.DataSource.PositiveFeedbackTTL))
+		zap.Strings("positive_feedback_types", m.Config().Recommend.DataSource.PositiveFeedbackTypes),
+		zap.Strings("read_feedback_types", m.Config().Recommend.DataSource.ReadFeedbackTypes),
+		zap.Uint("item_ttl", m.Config().Recommend.DataSource.ItemTTL),
+		zap.Uint("feedback_ttl", m.Config().Recommend.DataSource.PositiveFeedbackTTL))
 	evaluator := NewOnlineEvaluator()
-	rankingDataset, clickDataset, latestItems, popularItems, err := m.LoadDataFromDatabase(m.DataClient,
-		m.Config.Recommend.DataSource.PositiveFeedbackTypes,
-		m.Config.Recommend.DataSource.ReadFeedbackTypes,
-		m.Config.Recommend.DataSource.ItemTTL,
-		m.Config.Recommend.DataSource.PositiveFeedbackTTL,
-		evaluator)
+	var replay *FeedbackReplay
+	if m.Config().Recommend.Collaborative.EnableShadow {
+		replay = NewFeedbackReplay(time.Now().Add(-m.Config().Recommend.Collaborative.ShadowWindow))
+	}
+	rankingDataset, clickDataset, latestItems, popularItems, coldStartItems, err := m.LoadDataFromDatabase(m.DataClient,
+		m.Config().Recommend.DataSource.PositiveFeedbackTypes,
+		m.Config().Recommend.DataSource.ReadFeedbackTypes,
+		m.Config().Recommend.DataSource.ItemTTL,
+		m.Config().Recommend.DataSource.PositiveFeedbackTTL,
+		evaluator, replay)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -95,6 +110,20 @@ func (m *Master) runLoadDatasetTask() error {
 		log.Logger().Error("failed to write latest update popular items time", zap.Error(err))
 	}
 
+	// save item frequency to cache to penalize popular items
+	if m.Config().Recommend.Offline.PopularityPenalty > 0 {
+		frequency := make([]cache.Scored, 0, rankingDataset.ItemCount())
+		for itemIndex, itemFeedback := range rankingDataset.ItemFeedback {
+			frequency = append(frequency, cache.Scored{
+				Id:    rankingDataset.ItemIndex.ToName(int32(itemIndex)),
+				Score: float64(len(itemFeedback)),
+			})
+		}
+		if err = m.CacheClient.SetSorted(ctx, cache.ItemFrequency, frequency); err != nil {
+			log.Logger().Error("failed to cache item frequency", zap.Error(err))
+		}
+	}
+
 	// save the latest items to cache
 	for category, items := range latestItems {
 		if err = m.CacheClient.AddSorted(ctx, cache.Sorted(cache.Key(cache.LatestItems, category), items)); err != nil {
@@ -112,6 +141,13 @@ func (m *Master) runLoadDatasetTask() error {
 		log.Logger().Error("failed to write latest update latest items time", zap.Error(err))
 	}
 
+	// save cold-start items to cache
+	for category, items := range coldStartItems {
+		if err = m.CacheClient.SetSorted(ctx, cache.Key(cache.ColdStartItems, category), items); err != nil {
+			log.Logger().Error("failed to cache cold-start items", zap.Error(err))
+		}
+	}
+
 	// write statistics to database
 	UsersTotal.Set(float64(rankingDataset.UserCount()))
 	if err = m.CacheClient.Set(ctx, cache.Integer(cache.Key(cache.GlobalMeta, cache.NumUsers), rankingDataset.UserCount())); err != nil {
@@ -143,11 +179,14 @@ func (m *Master) runLoadDatasetTask() error {
 		log.Logger().Error("failed to write number of negative feedbacks", zap.Error(err))
 	}
 
-	// evaluate positive feedback rate
-	measurement := evaluator.Evaluate()
-	if err = m.RestServer.InsertMeasurement(ctx, measurement...); err != nil {
-		log.Logger().Error("failed to insert measurement", zap.Error(err))
+	// evaluate positive feedback rate and offline recommendations
+	measurements := evaluator.Evaluate()
+	if recommendMeasurements, err := m.evaluateRecommendations(ctx, rankingDataset, time.Now()); err != nil {
+		log.Logger().Error("failed to evaluate offline recommendations", zap.Error(err))
+	} else {
+		measurements = append(measurements, recommendMeasurements...)
 	}
+	m.insertMeasurements(ctx, measurements...)
 
 	// collect active users and items
 	activeUsers, activeItems, inactiveUsers, inactiveItems := 0, 0, 0, 0
@@ -175,10 +214,27 @@ func (m *Master) runLoadDatasetTask() error {
 		log.Logger().Error("failed to write categories to cache", zap.Error(err))
 	}
 
+	// create click datasets of objectives
+	objectiveTrainSets := make(map[string]*click.Dataset, len(m.Config().Recommend.Offline.Objectives))
+	objectiveTestSets := make(map[string]*click.Dataset, len(m.Config().Recommend.Offline.Objectives))
+	for _, objective := range m.Config().Recommend.Offline.Objectives {
+		objectiveDataset, err := m.loadObjectiveDataset(ctx, rankingDataset, clickDataset, objective)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		objectiveTrainSets[objective.Name], objectiveTestSets[objective.Name] = objectiveDataset.Split(0.2, 0)
+	}
+
 	// split ranking dataset
 	startTime := time.Now()
 	m.rankingDataMutex.Lock()
-	m.rankingTrainSet, m.rankingTestSet = rankingDataset.Split(0, 0)
+	if replay != nil && replay.Count() > 0 {
+		// hold out recent feedback to replay
+		m.rankingTrainSet, m.rankingTestSet = rankingDataset.SplitBy(replay.Positions())
+		log.Logger().Info("hold out recent feedback for shadow evaluation", zap.Int("n_users", replay.Count()))
+	} else {
+		m.rankingTrainSet, m.rankingTestSet = rankingDataset.Split(0, 0)
+	}
 	rankingDataset = nil
 	m.rankingDataMutex.Unlock()
 	LoadDatasetStepSecondsVec.WithLabelValues("split_ranking_dataset").Set(time.Since(startTime).Seconds())
@@ -189,6 +245,7 @@ func (m *Master) runLoadDatasetTask() error {
 	startTime = time.Now()
 	m.clickDataMutex.Lock()
 	m.clickTrainSet, m.clickTestSet = clickDataset.Split(0.2, 0)
+	m.objectiveTrainSets, m.objectiveTestSets = objectiveTrainSets, objectiveTestSets
 	clickDataset = nil
 	m.clickDataMutex.Unlock()
 	LoadDatasetStepSecondsVec.WithLabelValues("split_click_dataset").Set(time.Since(startTime).Seconds())
@@ -201,16 +258,16 @@ func (m *Master) runLoadDatasetTask() error {
 
 func (m *Master) estimateFindItemNeighborsComplexity(dataset *ranking.DataSet) int {
 	complexity := dataset.ItemCount() * dataset.ItemCount()
-	if m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeRelated ||
-		m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
+	if m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeRelated ||
+		m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
 		complexity += len(dataset.UserFeedback) + len(dataset.ItemFeedback)
 	}
-	if m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeSimilar ||
-		m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
+	if m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeSimilar ||
+		m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
 		complexity += len(dataset.ItemLabels) + int(dataset.NumItemLabels)
 	}
-	if m.Config.Recommend.ItemNeighbors.EnableIndex {
-		complexity += search.EstimateIVFBuilderComplexity(dataset.ItemCount(), m.Config.Recommend.ItemNeighbors.IndexFitEpoch)
+	if m.Config().Recommend.ItemNeighbors.EnableIndex {
+		complexity += search.EstimateIVFBuilderComplexity(dataset.ItemCount(), m.Config().Recommend.ItemNeighbors.IndexFitEpoch)
 	}
 	return complexity
 }
@@ -253,7 +310,7 @@ func (t *FindItemNeighborsTask) run(j *task.JobsAllocator) error {
 	startTaskTime := time.Now()
 	t.taskMonitor.Start(TaskFindItemNeighbors, t.estimateFindItemNeighborsComplexity(dataset))
 	log.Logger().Info("start searching neighbors of items",
-		zap.Int("n_cache", t.Config.Recommend.CacheSize))
+		zap.Int("n_cache", t.Config().Recommend.CacheSize))
 	// create progress tracker
 	completed := make(chan struct{}, 1000)
 	go func() {
@@ -281,8 +338,8 @@ func (t *FindItemNeighborsTask) run(j *task.JobsAllocator) error {
 	}()
 
 	userIDF := make([]float32, dataset.UserCount())
-	if t.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeRelated ||
-		t.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
+	if t.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeRelated ||
+		t.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
 		for _, feedbacks := range dataset.ItemFeedback {
 			sort.Sort(sortutil.Int32Slice(feedbacks))
 		}
@@ -299,8 +356,8 @@ func (t *FindItemNeighborsTask) run(j *task.JobsAllocator) error {
 	}
 	labeledItems := make([][]int32, dataset.NumItemLabels)
 	labelIDF := make([]float32, dataset.NumItemLabels)
-	if t.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeSimilar ||
-		t.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
+	if t.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeSimilar ||
+		t.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
 		for i, itemLabels := range dataset.ItemLabels {
 			sort.Sort(sortutil.Int32Slice(itemLabels))
 			for _, label := range itemLabels {
@@ -322,7 +379,7 @@ func (t *FindItemNeighborsTask) run(j *task.JobsAllocator) error {
 
 	start := time.Now()
 	var err error
-	if t.Config.Recommend.ItemNeighbors.EnableIndex {
+	if t.Config().Recommend.ItemNeighbors.EnableIndex {
 		err = t.findItemNeighborsIVF(dataset, labelIDF, userIDF, completed, j)
 	} else {
 		err = t.findItemNeighborsBruteForce(dataset, labeledItems, labelIDF, userIDF, completed, j)
@@ -330,7 +387,10 @@ func (t *FindItemNeighborsTask) run(j *task.JobsAllocator) error {
 	searchTime := time.Since(start)
 
 	close(completed)
-	if err != nil {
+	if t.taskMonitor.GetTask(TaskFindItemNeighbors).IsCancelled() {
+		log.Logger().Info("searching neighbors of items cancelled")
+		return nil
+	} else if err != nil {
 		log.Logger().Error("failed to searching neighbors of items", zap.Error(err))
 		t.taskMonitor.Fail(TaskFindItemNeighbors, err.Error())
 		FindItemNeighborsTotalSeconds.Set(0)
@@ -358,7 +418,7 @@ func (m *Master) findItemNeighborsBruteForce(dataset *ranking.DataSet, labeledIt
 	)
 
 	var vector VectorsInterface
-	switch m.Config.Recommend.ItemNeighbors.NeighborType {
+	switch m.Config().Recommend.ItemNeighbors.NeighborType {
 	case config.NeighborTypeSimilar:
 		vector = NewVectors(dataset.ItemLabels, labeledItems, labelIDF)
 	case config.NeighborTypeRelated:
@@ -368,13 +428,17 @@ func (m *Master) findItemNeighborsBruteForce(dataset *ranking.DataSet, labeledIt
 			NewVectors(dataset.ItemLabels, labeledItems, labelIDF),
 			NewVectors(dataset.ItemFeedback, dataset.UserFeedback, userIDF))
 	default:
-		return errors.NotImplementedf("item neighbor type `%v`", m.Config.Recommend.ItemNeighbors.NeighborType)
+		return errors.NotImplementedf("item neighbor type `%v`", m.Config().Recommend.ItemNeighbors.NeighborType)
 	}
 
+	neighborTask := m.taskMonitor.GetTask(TaskFindItemNeighbors)
 	err := parallel.DynamicParallel(dataset.ItemCount(), j, func(workerId, itemIndex int) error {
 		defer func() {
 			completed <- struct{}{}
 		}()
+		if neighborTask.IsCancelled() {
+			return nil
+		}
 		itemId := dataset.ItemIndex.ToName(int32(itemIndex))
 		if !m.checkItemNeighborCacheTimeout(itemId, dataset.CategorySet.List()) {
 			return nil
@@ -382,9 +446,9 @@ func (m *Master) findItemNeighborsBruteForce(dataset *ranking.DataSet, labeledIt
 		updateItemCount.Add(1)
 		startTime := time.Now()
 		nearItemsFilters := make(map[string]*heap.TopKFilter[int32, float32])
-		nearItemsFilters[""] = heap.NewTopKFilter[int32, float32](m.Config.Recommend.CacheSize)
+		nearItemsFilters[""] = heap.NewTopKFilter[int32, float32](m.Config().Recommend.CacheSize)
 		for _, category := range dataset.CategorySet.List() {
-			nearItemsFilters[category] = heap.NewTopKFilter[int32, float32](m.Config.Recommend.CacheSize)
+			nearItemsFilters[category] = heap.NewTopKFilter[int32, float32](m.Config().Recommend.CacheSize)
 		}
 
 		adjacencyItems := vector.Neighbors(itemIndex)
@@ -414,7 +478,7 @@ func (m *Master) findItemNeighborsBruteForce(dataset *ranking.DataSet, labeledIt
 		if err := m.CacheClient.Set(
 			ctx,
 			cache.Time(cache.Key(cache.LastUpdateItemNeighborsTime, itemId), time.Now()),
-			cache.String(cache.Key(cache.ItemNeighborsDigest, itemId), m.Config.ItemNeighborDigest())); err != nil {
+			cache.String(cache.Key(cache.ItemNeighborsDigest, itemId), m.Config().ItemNeighborDigest())); err != nil {
 			return errors.Trace(err)
 		}
 		findNeighborSeconds.Add(time.Since(startTime).Seconds())
@@ -442,7 +506,7 @@ func (m *Master) findItemNeighborsIVF(dataset *ranking.DataSet, labelIDF, userID
 	buildStart := time.Now()
 	var index search.VectorIndex
 	var vectors []search.Vector
-	switch m.Config.Recommend.ItemNeighbors.NeighborType {
+	switch m.Config().Recommend.ItemNeighbors.NeighborType {
 	case config.NeighborTypeSimilar:
 		vectors = lo.Map(dataset.ItemLabels, func(_ []int32, i int) search.Vector {
 			return search.NewDictionaryVector(dataset.ItemLabels[i], labelIDF, dataset.ItemCategories[i], dataset.HiddenItems[i])
@@ -456,14 +520,14 @@ func (m *Master) findItemNeighborsIVF(dataset *ranking.DataSet, labelIDF, userID
 			return NewDualDictionaryVector(dataset.ItemLabels[i], labelIDF, dataset.ItemFeedback[i], userIDF, dataset.ItemCategories[i], dataset.HiddenItems[i])
 		})
 	default:
-		return errors.NotImplementedf("item neighbor type `%v`", m.Config.Recommend.ItemNeighbors.NeighborType)
+		return errors.NotImplementedf("item neighbor type `%v`", m.Config().Recommend.ItemNeighbors.NeighborType)
 	}
 
-	builder := search.NewIVFBuilder(vectors, m.Config.Recommend.CacheSize,
+	builder := search.NewIVFBuilder(vectors, m.Config().Recommend.CacheSize,
 		search.SetIVFJobsAllocator(j))
 	var recall float32
-	index, recall = builder.Build(m.Config.Recommend.ItemNeighbors.IndexRecall,
-		m.Config.Recommend.ItemNeighbors.IndexFitEpoch,
+	index, recall = builder.Build(m.Config().Recommend.ItemNeighbors.IndexRecall,
+		m.Config().Recommend.ItemNeighbors.IndexFitEpoch,
 		true,
 		m.taskMonitor.GetTask(TaskFindItemNeighbors))
 	ItemNeighborIndexRecall.Set(float64(recall))
@@ -472,10 +536,14 @@ func (m *Master) findItemNeighborsIVF(dataset *ranking.DataSet, labelIDF, userID
 	}
 	buildIndexSeconds.Add(time.Since(buildStart).Seconds())
 
+	neighborTask := m.taskMonitor.GetTask(TaskFindItemNeighbors)
 	err := parallel.DynamicParallel(dataset.ItemCount(), j, func(workerId, itemIndex int) error {
 		defer func() {
 			completed <- struct{}{}
 		}()
+		if neighborTask.IsCancelled() {
+			return nil
+		}
 		itemId := dataset.ItemIndex.ToName(int32(itemIndex))
 		if !m.checkItemNeighborCacheTimeout(itemId, dataset.CategorySet.List()) {
 			return nil
@@ -484,15 +552,15 @@ func (m *Master) findItemNeighborsIVF(dataset *ranking.DataSet, labelIDF, userID
 		startTime := time.Now()
 		var neighbors map[string][]int32
 		var scores map[string][]float32
-		if m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeSimilar ||
-			m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
+		if m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeSimilar ||
+			m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto {
 			neighbors, scores = index.MultiSearch(vectors[itemIndex], dataset.CategorySet.List(),
-				m.Config.Recommend.CacheSize, true)
+				m.Config().Recommend.CacheSize, true)
 		}
-		if m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeRelated ||
-			m.Config.Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto && len(neighbors[""]) == 0 {
+		if m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeRelated ||
+			m.Config().Recommend.ItemNeighbors.NeighborType == config.NeighborTypeAuto && len(neighbors[""]) == 0 {
 			neighbors, scores = index.MultiSearch(vectors[itemIndex], dataset.CategorySet.List(),
-				m.Config.Recommend.CacheSize, true)
+				m.Config().Recommend.CacheSize, true)
 		}
 		for category := range neighbors {
 			if categoryNeighbors, exist := neighbors[category]; exist && len(categoryNeighbors) > 0 {
@@ -509,7 +577,7 @@ func (m *Master) findItemNeighborsIVF(dataset *ranking.DataSet, labelIDF, userID
 		if err := m.CacheClient.Set(
 			ctx,
 			cache.Time(cache.Key(cache.LastUpdateItemNeighborsTime, itemId), time.Now()),
-			cache.String(cache.Key(cache.ItemNeighborsDigest, itemId), m.Config.ItemNeighborDigest())); err != nil {
+			cache.String(cache.Key(cache.ItemNeighborsDigest, itemId), m.Config().ItemNeighborDigest())); err != nil {
 			return errors.Trace(err)
 		}
 		findNeighborSeconds.Add(time.Since(startTime).Seconds())
@@ -526,16 +594,16 @@ func (m *Master) findItemNeighborsIVF(dataset *ranking.DataSet, labelIDF, userID
 
 func (m *Master) estimateFindUserNeighborsComplexity(dataset *ranking.DataSet) int {
 	complexity := dataset.UserCount() * dataset.UserCount()
-	if m.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeRelated ||
-		m.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
+	if m.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeRelated ||
+		m.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
 		complexity += len(dataset.UserFeedback) + len(dataset.ItemFeedback)
 	}
-	if m.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeSimilar ||
-		m.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
+	if m.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeSimilar ||
+		m.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
 		complexity += len(dataset.UserLabels) + int(dataset.NumUserLabels)
 	}
-	if m.Config.Recommend.UserNeighbors.EnableIndex {
-		complexity += search.EstimateIVFBuilderComplexity(dataset.UserCount(), m.Config.Recommend.UserNeighbors.IndexFitEpoch)
+	if m.Config().Recommend.UserNeighbors.EnableIndex {
+		complexity += search.EstimateIVFBuilderComplexity(dataset.UserCount(), m.Config().Recommend.UserNeighbors.IndexFitEpoch)
 	}
 	return complexity
 }
@@ -578,7 +646,7 @@ func (t *FindUserNeighborsTask) run(j *task.JobsAllocator) error {
 	startTaskTime := time.Now()
 	t.taskMonitor.Start(TaskFindUserNeighbors, t.estimateFindUserNeighborsComplexity(dataset))
 	log.Logger().Info("start searching neighbors of users",
-		zap.Int("n_cache", t.Config.Recommend.CacheSize))
+		zap.Int("n_cache", t.Config().Recommend.CacheSize))
 	// create progress tracker
 	completed := make(chan struct{}, 1000)
 	go func() {
@@ -606,8 +674,8 @@ func (t *FindUserNeighborsTask) run(j *task.JobsAllocator) error {
 	}()
 
 	itemIDF := make([]float32, dataset.ItemCount())
-	if t.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeRelated ||
-		t.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
+	if t.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeRelated ||
+		t.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
 		for _, feedbacks := range dataset.UserFeedback {
 			sort.Sort(sortutil.Int32Slice(feedbacks))
 		}
@@ -624,8 +692,8 @@ func (t *FindUserNeighborsTask) run(j *task.JobsAllocator) error {
 	}
 	labeledUsers := make([][]int32, dataset.NumUserLabels)
 	labelIDF := make([]float32, dataset.NumUserLabels)
-	if t.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeSimilar ||
-		t.Config.Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
+	if t.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeSimilar ||
+		t.Config().Recommend.UserNeighbors.NeighborType == config.NeighborTypeAuto {
 		for i, userLabels := range dataset.UserLabels {
 			sort.Sort(sortutil.Int32Slice(userLabels))
 			for _, label := range userLabels {
@@ -647,7 +715,7 @@ func (t *FindUserNeighborsTask) run(j *task.JobsAllocator) error {
 
 	start := time.Now()
 	var err error
-	if t.Config.Recommend.UserNeighbors.EnableIndex {
+	if t.Config().Recommend.UserNeighbors.EnableIndex {
 		err = t.findUserNeighborsIVF(dataset, labelIDF, itemIDF, completed, j)
 	} else {
 		err = t.findUserNeighborsBruteForce(dataset, labeledUsers, labelIDF, itemIDF, completed, j)
@@ -655,7 +723,10 @@ func (t *FindUserNeighborsTask) run(j *task.JobsAllocator) error {
 	searchTime := time.Since(start)
 
 	close(completed)
-	if err != nil {
+	if t.taskMonitor.GetTask(TaskFindUserNeighbors).IsCancelled() {
+		log.Logger().Info("searching neighbors of users cancelled")
+		return nil
+	} else if err != nil {
 		log.Logger().Error("failed to searching neighbors of users", zap.Error(err))
 		t.taskMonitor.Fail(TaskFindUserNeighbors, err.Error())
 		FindUserNeighborsTotalSeconds.Set(0)
@@ -680,9 +751,10 @@ func (m *Master) findUserNeighborsBruteForce(dataset *ranking.DataSet, labeledUs
 		findNeighborSeconds atomic.Float64
 	)
 	ctx := context.Background()
+	categories := userCategories(dataset)
 
 	var vectors VectorsInterface
-	switch m.Config.Recommend.UserNeighbors.NeighborType {
+	switch m.Config().Recommend.UserNeighbors.NeighborType {
 	case config.NeighborTypeSimilar:
 		vectors = NewVectors(dataset.UserLabels, labeledUsers, labelIDF)
 	case config.NeighborTypeRelated:
@@ -692,44 +764,57 @@ func (m *Master) findUserNeighborsBruteForce(dataset *ranking.DataSet, labeledUs
 			NewVectors(dataset.UserLabels, labeledUsers, labelIDF),
 			NewVectors(dataset.UserFeedback, dataset.ItemFeedback, itemIDF))
 	default:
-		return errors.NotImplementedf("user neighbor type `%v`", m.Config.Recommend.UserNeighbors.NeighborType)
+		return errors.NotImplementedf("user neighbor type `%v`", m.Config().Recommend.UserNeighbors.NeighborType)
 	}
 
+	neighborTask := m.taskMonitor.GetTask(TaskFindUserNeighbors)
 	err := parallel.DynamicParallel(dataset.UserCount(), j, func(workerId, userIndex int) error {
 		defer func() {
 			completed <- struct{}{}
 		}()
+		if neighborTask.IsCancelled() {
+			return nil
+		}
 		userId := dataset.UserIndex.ToName(int32(userIndex))
 		if !m.checkUserNeighborCacheTimeout(userId) {
 			return nil
 		}
 		updateUserCount.Add(1)
 		startTime := time.Now()
-		nearUsers := heap.NewTopKFilter[int32, float32](m.Config.Recommend.CacheSize)
+		nearUsersFilters := make(map[string]*heap.TopKFilter[int32, float32])
+		nearUsersFilters[""] = heap.NewTopKFilter[int32, float32](m.Config().Recommend.CacheSize)
+		for _, category := range dataset.CategorySet.List() {
+			nearUsersFilters[category] = heap.NewTopKFilter[int32, float32](m.Config().Recommend.CacheSize)
+		}
 
 		adjacencyUsers := vectors.Neighbors(userIndex)
 		for _, j := range adjacencyUsers {
 			if j != int32(userIndex) {
 				score := vectors.Distance(userIndex, int(j))
 				if score > 0 {
-					nearUsers.Push(j, score)
+					nearUsersFilters[""].Push(j, score)
+					for _, category := range categories[j] {
+						nearUsersFilters[category].Push(j, score)
+					}
 				}
 			}
 		}
 
-		elem, scores := nearUsers.PopAll()
-		recommends := make([]string, len(elem))
-		for i := range recommends {
-			recommends[i] = dataset.UserIndex.ToName(elem[i])
-		}
-		if err := m.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, userId),
-			cache.CreateScoredItems(recommends, scores)); err != nil {
-			return errors.Trace(err)
+		for category, nearUsersFilter := range nearUsersFilters {
+			elem, scores := nearUsersFilter.PopAll()
+			recommends := make([]string, len(elem))
+			for i := range recommends {
+				recommends[i] = dataset.UserIndex.ToName(elem[i])
+			}
+			if err := m.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, userId, category),
+				cache.CreateScoredItems(recommends, scores)); err != nil {
+				return errors.Trace(err)
+			}
 		}
 		if err := m.CacheClient.Set(
 			ctx,
 			cache.Time(cache.Key(cache.LastUpdateUserNeighborsTime, userId), time.Now()),
-			cache.String(cache.Key(cache.UserNeighborsDigest, userId), m.Config.UserNeighborDigest())); err != nil {
+			cache.String(cache.Key(cache.UserNeighborsDigest, userId), m.Config().UserNeighborDigest())); err != nil {
 			return errors.Trace(err)
 		}
 		findNeighborSeconds.Add(time.Since(startTime).Seconds())
@@ -756,30 +841,31 @@ func (m *Master) findUserNeighborsIVF(dataset *ranking.DataSet, labelIDF, itemID
 	buildStart := time.Now()
 	var index search.VectorIndex
 	var vectors []search.Vector
-	switch m.Config.Recommend.UserNeighbors.NeighborType {
+	categories := userCategories(dataset)
+	switch m.Config().Recommend.UserNeighbors.NeighborType {
 	case config.NeighborTypeSimilar:
-		vectors = lo.Map(dataset.UserLabels, func(indices []int32, _ int) search.Vector {
-			return search.NewDictionaryVector(indices, labelIDF, nil, false)
+		vectors = lo.Map(dataset.UserLabels, func(indices []int32, i int) search.Vector {
+			return search.NewDictionaryVector(indices, labelIDF, categories[i], false)
 		})
 	case config.NeighborTypeRelated:
-		vectors = lo.Map(dataset.UserFeedback, func(indices []int32, _ int) search.Vector {
-			return search.NewDictionaryVector(indices, itemIDF, nil, false)
+		vectors = lo.Map(dataset.UserFeedback, func(indices []int32, i int) search.Vector {
+			return search.NewDictionaryVector(indices, itemIDF, categories[i], false)
 		})
 	case config.NeighborTypeAuto:
 		vectors = make([]search.Vector, dataset.UserCount())
 		for i := range vectors {
-			vectors[i] = NewDualDictionaryVector(dataset.UserLabels[i], labelIDF, dataset.UserFeedback[i], itemIDF, nil, false)
+			vectors[i] = NewDualDictionaryVector(dataset.UserLabels[i], labelIDF, dataset.UserFeedback[i], itemIDF, categories[i], false)
 		}
 	default:
-		return errors.NotImplementedf("user neighbor type `%v`", m.Config.Recommend.UserNeighbors.NeighborType)
+		return errors.NotImplementedf("user neighbor type `%v`", m.Config().Recommend.UserNeighbors.NeighborType)
 	}
 
-	builder := search.NewIVFBuilder(vectors, m.Config.Recommend.CacheSize,
+	builder := search.NewIVFBuilder(vectors, m.Config().Recommend.CacheSize,
 		search.SetIVFJobsAllocator(j))
 	var recall float32
 	index, recall = builder.Build(
-		m.Config.Recommend.UserNeighbors.IndexRecall,
-		m.Config.Recommend.UserNeighbors.IndexFitEpoch,
+		m.Config().Recommend.UserNeighbors.IndexRecall,
+		m.Config().Recommend.UserNeighbors.IndexFitEpoch,
 		true,
 		m.taskMonitor.GetTask(TaskFindUserNeighbors))
 	UserNeighborIndexRecall.Set(float64(recall))
@@ -788,31 +874,36 @@ func (m *Master) findUserNeighborsIVF(dataset *ranking.DataSet, labelIDF, itemID
 	}
 	buildIndexSeconds.Add(time.Since(buildStart).Seconds())
 
+	neighborTask := m.taskMonitor.GetTask(TaskFindUserNeighbors)
 	err := parallel.DynamicParallel(dataset.UserCount(), j, func(workerId, userIndex int) error {
 		defer func() {
 			completed <- struct{}{}
 		}()
+		if neighborTask.IsCancelled() {
+			return nil
+		}
 		userId := dataset.UserIndex.ToName(int32(userIndex))
 		if !m.checkUserNeighborCacheTimeout(userId) {
 			return nil
 		}
 		updateUserCount.Add(1)
 		startTime := time.Now()
-		var neighbors []int32
-		var scores []float32
-		neighbors, scores = index.Search(vectors[userIndex], m.Config.Recommend.CacheSize, true)
-		itemScores := make([]cache.Scored, len(neighbors))
-		for i := range scores {
-			itemScores[i].Id = dataset.UserIndex.ToName(neighbors[i])
-			itemScores[i].Score = float64(-scores[i])
-		}
-		if err := m.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, userId), itemScores); err != nil {
-			return errors.Trace(err)
+		neighbors, scores := index.MultiSearch(vectors[userIndex], dataset.CategorySet.List(),
+			m.Config().Recommend.CacheSize, true)
+		for category := range neighbors {
+			userScores := make([]cache.Scored, len(neighbors[category]))
+			for i := range scores[category] {
+				userScores[i].Id = dataset.UserIndex.ToName(neighbors[category][i])
+				userScores[i].Score = float64(-scores[category][i])
+			}
+			if err := m.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, userId, category), userScores); err != nil {
+				return errors.Trace(err)
+			}
 		}
 		if err := m.CacheClient.Set(
 			ctx,
 			cache.Time(cache.Key(cache.LastUpdateUserNeighborsTime, userId), time.Now()),
-			cache.String(cache.Key(cache.UserNeighborsDigest, userId), m.Config.UserNeighborDigest())); err != nil {
+			cache.String(cache.Key(cache.UserNeighborsDigest, userId), m.Config().UserNeighborDigest())); err != nil {
 			return errors.Trace(err)
 		}
 		findNeighborSeconds.Add(time.Since(startTime).Seconds())
@@ -844,6 +935,35 @@ func commonElements(a, b []int32, weights []float32) (float32, float32) {
 	return sum, count
 }
 
+// sortedUnique returns a sorted copy of the slice without duplicates.
+func sortedUnique(a []int32) []int32 {
+	sorted := make([]int32, len(a))
+	copy(sorted, a)
+	sort.Sort(sortutil.Int32Slice(sorted))
+	return sorted[:sortutil.Dedupe(sortutil.Int32Slice(sorted))]
+}
+
+// userCategories returns categories of items each user has feedback on.
+func userCategories(dataset *ranking.DataSet) [][]string {
+	categories := make([][]string, dataset.UserCount())
+	for userIndex, items := range dataset.UserFeedback {
+		var names []string
+		for _, itemIndex := range items {
+			if int(itemIndex) < len(dataset.ItemCategories) {
+				names = append(names, dataset.ItemCategories[itemIndex]...)
+			}
+		}
+		categories[userIndex] = lo.Uniq(names)
+	}
+	return categories
+}
+
+// containsSorted returns true if the sorted slice contains the element.
+func containsSorted(a []int32, x int32) bool {
+	i := sortutil.SearchInt32s(a, x)
+	return i < len(a) && a[i] == x
+}
+
 func weightedSum(a []int32, weights []float32) float32 {
 	var sum float32
 	for _, i := range a {
@@ -852,7 +972,8 @@ func weightedSum(a []int32, weights []float32) float32 {
 	return sum
 }
 
-// checkUserNeighborCacheTimeout checks if user neighbor cache stale.
+// checkUserNeighborCacheTimeout checks if user neighbor cache stale. Neighbors in categories are updated with neighbors
+// without categories, which might be empty for users without neighbors interested in the categories.
 // 1. if cache is empty, stale.
 // 2. if modified time > update time, stale.
 func (m *Master) checkUserNeighborCacheTimeout(userId string) bool {
@@ -878,7 +999,7 @@ func (m *Master) checkUserNeighborCacheTimeout(userId string) bool {
 		}
 		return true
 	}
-	if cacheDigest != m.Config.UserNeighborDigest() {
+	if cacheDigest != m.Config().UserNeighborDigest() {
 		return true
 	}
 	// read modified time
@@ -898,7 +1019,7 @@ func (m *Master) checkUserNeighborCacheTimeout(userId string) bool {
 		return true
 	}
 	// check cache expire
-	if updateTime.Before(time.Now().Add(-m.Config.Recommend.CacheExpire)) {
+	if updateTime.Before(time.Now().Add(-m.Config().Recommend.CacheExpire)) {
 		return true
 	}
 	// check time
@@ -935,7 +1056,7 @@ func (m *Master) checkItemNeighborCacheTimeout(itemId string, categories []strin
 		}
 		return true
 	}
-	if cacheDigest != m.Config.ItemNeighborDigest() {
+	if cacheDigest != m.Config().ItemNeighborDigest() {
 		return true
 	}
 	// read modified time
@@ -955,7 +1076,7 @@ func (m *Master) checkItemNeighborCacheTimeout(itemId string, categories []strin
 		return true
 	}
 	// check cache expire
-	if updateTime.Before(time.Now().Add(-m.Config.Recommend.CacheExpire)) {
+	if updateTime.Before(time.Now().Add(-m.Config().Recommend.CacheExpire)) {
 		return true
 	}
 	// check time
@@ -1016,11 +1137,24 @@ func (t *FitRankingModelTask) run(j *task.JobsAllocator) error {
 	}
 
 	startFitTime := time.Now()
-	score := rankingModel.Fit(t.rankingTrainSet, t.rankingTestSet, ranking.NewFitConfig().
+	fitConfig := ranking.NewFitConfig().
 		SetJobsAllocator(j).
-		SetTask(t.taskMonitor.Start(TaskFitRankingModel, rankingModel.Complexity())))
+		SetWarmStartEpochs(t.Config().Recommend.Collaborative.WarmStartEpoch)
+	fitTask := t.taskMonitor.Start(TaskFitRankingModel, fitConfig.Epochs(rankingModel, rankingModel.Complexity()))
+	score := rankingModel.Fit(t.rankingTrainSet, t.rankingTestSet, fitConfig.SetTask(fitTask))
+	if fitTask.IsCancelled() {
+		log.Logger().Info("fit ranking model cancelled")
+		return nil
+	}
 	CollaborativeFilteringFitSeconds.Set(time.Since(startFitTime).Seconds())
 
+	// shadow evaluation against the model in use
+	if t.Config().Recommend.Collaborative.EnableShadow && !t.promoteRankingModel(score, j) {
+		t.taskMonitor.Fail(TaskFitRankingModel, "Ranking model regressed in shadow evaluation.")
+		t.lastNumFeedback = numFeedback
+		return nil
+	}
+
 	// update ranking model
 	t.rankingModelMutex.Lock()
 	t.RankingModel = rankingModel
@@ -1032,6 +1166,11 @@ func (t *FitRankingModelTask) run(j *task.JobsAllocator) error {
 	CollaborativeFilteringNDCG10.Set(float64(score.NDCG))
 	CollaborativeFilteringRecall10.Set(float64(score.Recall))
 	CollaborativeFilteringPrecision10.Set(float64(score.Precision))
+	fitTime := time.Now()
+	t.insertMeasurements(ctx,
+		server.Measurement{Name: RankingModelNDCG, Timestamp: fitTime, Value: score.NDCG},
+		server.Measurement{Name: RankingModelRecall, Timestamp: fitTime, Value: score.Recall},
+		server.Measurement{Name: RankingModelPrecision, Timestamp: fitTime, Value: score.Precision})
 	MemoryInUseBytesVec.WithLabelValues("collaborative_filtering_model").Set(float64(t.RankingModel.Bytes()))
 	if err := t.CacheClient.Set(ctx, cache.Time(cache.Key(cache.GlobalMeta, cache.LastFitMatchingModelTime), time.Now())); err != nil {
 		log.Logger().Error("failed to write meta", zap.Error(err))
@@ -1061,7 +1200,37 @@ func (t *FitRankingModelTask) run(j *task.JobsAllocator) error {
 	return nil
 }
 
-// FitClickModelTask fits click model using latest data. After model fitted, following states are changed:
+// promoteRankingModel scores the ranking model in use on the test set and returns true if the score of the candidate
+// doesn't regress. The ranking model in use is restored if the candidate is rejected.
+func (t *FitRankingModelTask) promoteRankingModel(candidate ranking.Score, j *task.JobsAllocator) bool {
+	t.rankingModelMutex.RLock()
+	servingName, servingModel, servingScore := t.localCache.RankingModelName, t.localCache.RankingModel, t.localCache.RankingModelScore
+	t.rankingModelMutex.RUnlock()
+	if servingModel == nil || servingModel.Invalid() {
+		return true
+	}
+	baseline := shadowScore(servingModel, t.rankingTrainSet, t.rankingTestSet, j)
+	log.Logger().Info("shadow evaluation of ranking model",
+		zap.Any("candidate", candidate),
+		zap.Any("baseline", baseline))
+	if !regressed(candidate, baseline, t.Config().Recommend.Collaborative.ShadowMaxRegression) {
+		return true
+	}
+	log.Logger().Warn("reject ranking model regressed in shadow evaluation",
+		zap.Float32("candidate_ndcg", candidate.NDCG),
+		zap.Float32("baseline_ndcg", baseline.NDCG),
+		zap.Float32("candidate_recall", candidate.Recall),
+		zap.Float32("baseline_recall", baseline.Recall))
+	t.rankingModelMutex.Lock()
+	t.RankingModel = servingModel
+	t.rankingModelName = servingName
+	t.rankingScore = servingScore
+	t.rankingModelMutex.Unlock()
+	return false
+}
+
+// FitClickModelTask fits click model and models of objectives using latest data. After model fitted, following states
+// are changed:
 // 1. Click model version are increased.
 // 2. Click model score are updated.
 // 3. Click model, version and score are persisted to local cache.
@@ -1070,6 +1239,8 @@ type FitClickModelTask struct {
 	lastNumUsers    int
 	lastNumItems    int
 	lastNumFeedback int
+	lastObjectives  string
+	lastCalibration string
 }
 
 func NewFitClickModelTask(m *Master) *FitClickModelTask {
@@ -1085,7 +1256,7 @@ func (t *FitClickModelTask) priority() int {
 }
 
 func (t *FitClickModelTask) run(j *task.JobsAllocator) error {
-	log.Logger().Info("prepare to fit click model", zap.Int("n_jobs", t.Config.Master.NumJobs))
+	log.Logger().Info("prepare to fit click model", zap.Int("n_jobs", t.Config().Master.NumJobs))
 	t.clickDataMutex.RLock()
 	defer t.clickDataMutex.RUnlock()
 	numUsers := t.clickTrainSet.UserCount()
@@ -1096,12 +1267,14 @@ func (t *FitClickModelTask) run(j *task.JobsAllocator) error {
 
 	if t.clickTrainSet == nil || numUsers == 0 || numItems == 0 || numFeedback == 0 {
 		log.Logger().Warn("empty ranking dataset",
-			zap.Strings("positive_feedback_type", t.Config.Recommend.DataSource.PositiveFeedbackTypes))
+			zap.Strings("positive_feedback_type", t.Config().Recommend.DataSource.PositiveFeedbackTypes))
 		t.taskMonitor.Fail(TaskFitClickModel, "No feedback found.")
 		return nil
 	} else if numUsers != t.lastNumUsers ||
 		numItems != t.lastNumItems ||
-		numFeedback != t.lastNumFeedback {
+		numFeedback != t.lastNumFeedback ||
+		objectivesDigest(t.Config().Recommend.Offline.Objectives) != t.lastObjectives ||
+		t.Config().Recommend.Offline.ClickCalibration != t.lastCalibration {
 		shouldFit = true
 	}
 
@@ -1130,14 +1303,23 @@ func (t *FitClickModelTask) run(j *task.JobsAllocator) error {
 		return nil
 	}
 	startFitTime := time.Now()
-	score := clickModel.Fit(t.clickTrainSet, t.clickTestSet, click.NewFitConfig().
+	fitConfig := click.NewFitConfig().
 		SetJobsAllocator(j).
-		SetTask(t.taskMonitor.Start(TaskFitClickModel, clickModel.Complexity())))
+		SetWarmStartEpochs(t.Config().Recommend.Collaborative.WarmStartEpoch)
+	fitTask := t.taskMonitor.Start(TaskFitClickModel, fitConfig.Epochs(clickModel, clickModel.Complexity()))
+	score := clickModel.Fit(t.clickTrainSet, t.clickTestSet, fitConfig.SetTask(fitTask))
+	if fitTask.IsCancelled() {
+		log.Logger().Info("fit click model cancelled")
+		return nil
+	}
+	t.calibrate("click", clickModel, t.clickTestSet)
+	objectiveModels := t.fitObjectiveModels(clickModel, j)
 	RankingFitSeconds.Set(time.Since(startFitTime).Seconds())
 
 	// update match model
 	t.clickModelMutex.Lock()
 	t.ClickModel = clickModel
+	t.ObjectiveModels = objectiveModels
 	t.clickScore = score
 	t.ClickModelVersion++
 	t.clickModelMutex.Unlock()
@@ -1146,6 +1328,11 @@ func (t *FitClickModelTask) run(j *task.JobsAllocator) error {
 	RankingPrecision.Set(float64(score.Precision))
 	RankingRecall.Set(float64(score.Recall))
 	RankingAUC.Set(float64(score.AUC))
+	fitTime := time.Now()
+	t.insertMeasurements(ctx,
+		server.Measurement{Name: ClickModelPrecision, Timestamp: fitTime, Value: score.Precision},
+		server.Measurement{Name: ClickModelRecall, Timestamp: fitTime, Value: score.Recall},
+		server.Measurement{Name: ClickModelAUC, Timestamp: fitTime, Value: score.AUC})
 	MemoryInUseBytesVec.WithLabelValues("ranking_model").Set(float64(t.ClickModel.Bytes()))
 	if err := t.CacheClient.Set(ctx, cache.Time(cache.Key(cache.GlobalMeta, cache.LastFitRankingModelTime), time.Now())); err != nil {
 		log.Logger().Error("failed to write meta", zap.Error(err))
@@ -1156,6 +1343,7 @@ func (t *FitClickModelTask) run(j *task.JobsAllocator) error {
 	t.localCache.ClickModelScore = t.clickScore
 	t.localCache.ClickModelVersion = t.ClickModelVersion
 	t.localCache.ClickModel = t.ClickModel
+	t.localCache.ObjectiveModels = t.ObjectiveModels
 	t.clickModelMutex.RUnlock()
 	if t.localCache.RankingModel == nil || t.localCache.RankingModel.Invalid() {
 		log.Logger().Info("wait ranking model")
@@ -1172,9 +1360,32 @@ func (t *FitClickModelTask) run(j *task.JobsAllocator) error {
 	t.lastNumItems = numItems
 	t.lastNumUsers = numUsers
 	t.lastNumFeedback = numFeedback
+	t.lastObjectives = objectivesDigest(t.Config().Recommend.Offline.Objectives)
+	t.lastCalibration = t.Config().Recommend.Offline.ClickCalibration
 	return nil
 }
 
+// calibrate fits the calibration of a click model on its validation set. The model is left uncalibrated if the
+// calibration fails.
+func (t *FitClickModelTask) calibrate(name string, m click.FactorizationMachine, validSet *click.Dataset) {
+	fm, ok := m.(*click.FM)
+	method := click.CalibrationMethod(t.Config().Recommend.Offline.ClickCalibration)
+	if !ok || validSet == nil || validSet.Count() == 0 || method == click.NoCalibration {
+		return
+	}
+	calibration, err := click.FitCalibration(method, fm, validSet)
+	if err != nil {
+		log.Logger().Error("failed to calibrate click model", zap.String("model", name), zap.Error(err))
+		return
+	}
+	fm.Calibration = calibration
+	log.Logger().Info("calibrate click model complete",
+		zap.String("model", name),
+		zap.String("method", string(method)),
+		zap.Float64("log_loss_before", click.Calibration{}.LogLoss(fm, validSet)),
+		zap.Float64("log_loss_after", calibration.LogLoss(fm, validSet)))
+}
+
 // SearchRankingModelTask searches best hyper-parameters for ranking models.
 // It requires read lock on the ranking dataset.
 type SearchRankingModelTask struct {
@@ -1210,7 +1421,7 @@ func (t *SearchRankingModelTask) run(j *task.JobsAllocator) error {
 
 	if numUsers == 0 || numItems == 0 || numFeedback == 0 {
 		log.Logger().Warn("empty ranking dataset",
-			zap.Strings("positive_feedback_type", t.Config.Recommend.DataSource.PositiveFeedbackTypes))
+			zap.Strings("positive_feedback_type", t.Config().Recommend.DataSource.PositiveFeedbackTypes))
 		t.taskMonitor.Fail(TaskSearchRankingModel, "No feedback found.")
 		return nil
 	} else if numUsers == t.lastNumUsers &&
@@ -1273,7 +1484,7 @@ func (t *SearchClickModelTask) run(j *task.JobsAllocator) error {
 
 	if numUsers == 0 || numItems == 0 || numFeedback == 0 {
 		log.Logger().Warn("empty click dataset",
-			zap.Strings("positive_feedback_type", t.Config.Recommend.DataSource.PositiveFeedbackTypes))
+			zap.Strings("positive_feedback_type", t.Config().Recommend.DataSource.PositiveFeedbackTypes))
 		t.taskMonitor.Fail(TaskSearchClickModel, "No feedback found.")
 		return nil
 	} else if numUsers == t.lastNumUsers &&
@@ -1357,8 +1568,12 @@ func (t *CacheGarbageCollectionTask) run(j *task.JobsAllocator) error {
 			case cache.UserNeighbors, cache.IgnoreItems, cache.CollaborativeRecommend, cache.OfflineRecommend:
 				err = t.CacheClient.SetSorted(ctx, s, nil)
 			case cache.UserNeighborsDigest, cache.OfflineRecommendDigest,
-				cache.LastModifyUserTime, cache.LastUpdateUserNeighborsTime, cache.LastUpdateUserRecommendTime:
+				cache.LastUpdateUserNeighborsTime, cache.LastUpdateUserRecommendTime:
 				err = t.CacheClient.Delete(ctx, s)
+			case cache.LastModifyUserTime:
+				if err = t.CacheClient.Delete(ctx, s); err == nil {
+					err = t.CacheClient.RemSorted(ctx, cache.Member(cache.ActiveUsers, userId))
+				}
 			}
 			if err != nil {
 				return errors.Trace(err)
@@ -1389,11 +1604,46 @@ func (t *CacheGarbageCollectionTask) run(j *task.JobsAllocator) error {
 				return errors.Trace(err)
 			}
 			reclaimCount++
+		case cache.FeedbackEvents:
+			if len(splits) < 4 {
+				return nil
+			}
+			userId := splits[2]
+			// check user in dataset
+			if t.rankingTrainSet != nil && t.rankingTrainSet.UserIndex.ToNumber(userId) != base.NotId {
+				return nil
+			}
+			// check user in database
+			_, err := t.DataClient.GetUser(ctx, userId)
+			if !errors.Is(err, errors.NotFound) {
+				if err != nil {
+					log.Logger().Error("failed to load user", zap.String("user_id", userId), zap.Error(err))
+				}
+				return err
+			}
+			// delete events of the removed user
+			if err = t.CacheClient.SetSorted(ctx, s, nil); err != nil {
+				return errors.Trace(err)
+			}
+			reclaimCount++
+		case cache.Session:
+			// delete expired session
+			session, err := server.LoadSession(ctx, t.CacheClient, splits[1])
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if !session.Expired(t.Config().Server.SessionTTL) {
+				return nil
+			}
+			if err = t.CacheClient.Delete(ctx, s); err != nil {
+				return errors.Trace(err)
+			}
+			reclaimCount++
 		}
 		return nil
 	})
 	// remove stale hidden items
-	if err := t.CacheClient.RemSortedByScore(ctx, cache.HiddenItemsV2, math.Inf(-1), float64(time.Now().Add(-t.Config.Recommend.CacheExpire).Unix())); err != nil {
+	if err := t.CacheClient.RemSortedByScore(ctx, cache.HiddenItemsV2, math.Inf(-1), float64(time.Now().Add(-t.Config().Recommend.CacheExpire).Unix())); err != nil {
 		return errors.Trace(err)
 	}
 	t.taskMonitor.Finish(TaskCacheGarbageCollection)
@@ -1403,9 +1653,44 @@ func (t *CacheGarbageCollectionTask) run(j *task.JobsAllocator) error {
 	return errors.Trace(err)
 }
 
+// appendStoreFeatures returns labels of users or items followed by their features from the feature store, which are
+// added to the label index. Labels are copied instead of modified since labels are used by neighbors as well. Features
+// of a batch are skipped if the feature store fails.
+func appendStoreFeatures(ctx context.Context, ids []string, labels [][]int32, labelIndex base.Index,
+	getFeatures func(ctx context.Context, ids []string) (map[string][]string, error)) [][]int32 {
+	merged := make([][]int32, len(ids))
+	copy(merged, labels)
+	numFeatures := 0
+	for begin := 0; begin < len(ids); begin += batchSize {
+		end := lo.Min([]int{begin + batchSize, len(ids)})
+		features, err := getFeatures(ctx, ids[begin:end])
+		if err != nil {
+			log.Logger().Warn("failed to fetch features from feature store", zap.Error(err))
+			continue
+		}
+		for i := begin; i < end; i++ {
+			if len(features[ids[i]]) == 0 {
+				continue
+			}
+			row := make([]int32, len(merged[i]), len(merged[i])+len(features[ids[i]]))
+			copy(row, merged[i])
+			for _, feature := range features[ids[i]] {
+				labelIndex.Add(feature)
+				if featureIndex := labelIndex.ToNumber(feature); !lo.Contains(row, featureIndex) {
+					row = append(row, featureIndex)
+					numFeatures++
+				}
+			}
+			merged[i] = row
+		}
+	}
+	log.Logger().Debug("fetched features from feature store", zap.Int("n_features", numFeatures))
+	return merged
+}
+
 // LoadDataFromDatabase loads dataset from data store.
-func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes, readTypes []string, itemTTL, positiveFeedbackTTL uint, evaluator *OnlineEvaluator) (
-	rankingDataset *ranking.DataSet, clickDataset *click.Dataset, latestItems map[string][]cache.Scored, popularItems map[string][]cache.Scored, err error) {
+func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes, readTypes []string, itemTTL, positiveFeedbackTTL uint, evaluator *OnlineEvaluator, replay *FeedbackReplay) (
+	rankingDataset *ranking.DataSet, clickDataset *click.Dataset, latestItems, popularItems, coldStartItems map[string][]cache.Scored, err error) {
 	m.taskMonitor.Start(TaskLoadDataset, 5)
 	ctx := context.Background()
 	// setup time limit
@@ -1419,14 +1704,24 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 		feedbackTimeLimit = &temp
 	}
 	timeWindowLimit := time.Time{}
-	if m.Config.Recommend.Popular.PopularWindow > 0 {
-		timeWindowLimit = time.Now().Add(-m.Config.Recommend.Popular.PopularWindow)
+	if m.Config().Recommend.Popular.PopularWindow > 0 {
+		timeWindowLimit = time.Now().Add(-m.Config().Recommend.Popular.PopularWindow)
 	}
 	rankingDataset = ranking.NewMapIndexDataset()
 
 	// create filers for latest items
 	latestItemsFilters := make(map[string]*heap.TopKFilter[string, float64])
-	latestItemsFilters[""] = heap.NewTopKFilter[string, float64](m.Config.Recommend.CacheSize)
+	latestItemsFilters[""] = heap.NewTopKFilter[string, float64](m.Config().Recommend.CacheSize)
+	// timestamps of new items are memorized to collect cold-start items
+	coldStartTimeLimit := time.Now().Add(-m.Config().Recommend.Offline.ColdStartItemAge)
+	newItemTimes := make(map[int32]time.Time)
+	newItemImpressions := make(map[int32]int)
+
+	// blocked labels are excluded from datasets
+	blockedLabels, err := server.LoadBlockedLabels(ctx, m.CacheClient)
+	if err != nil {
+		return nil, nil, nil, nil, nil, errors.Trace(err)
+	}
 
 	// STEP 1: pull users
 	userLabelCount := make(map[string]int)
@@ -1436,6 +1731,7 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 	userChan, errChan := database.GetUserStream(ctx, batchSize)
 	for users := range userChan {
 		for _, user := range users {
+			user.Labels = lo.Reject(user.Labels, func(label string, _ int) bool { return blockedLabels.Has(label) })
 			rankingDataset.AddUser(user.UserId)
 			userIndex := rankingDataset.UserIndex.ToNumber(user.UserId)
 			if len(rankingDataset.UserLabels) == int(userIndex) {
@@ -1463,7 +1759,7 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 		}
 	}
 	if err = <-errChan; err != nil {
-		return nil, nil, nil, nil, errors.Trace(err)
+		return nil, nil, nil, nil, nil, errors.Trace(err)
 	}
 	rankingDataset.NumUserLabels = userLabelIndex.Len()
 	m.taskMonitor.Update(TaskLoadDataset, 1)
@@ -1473,7 +1769,11 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 		zap.Duration("used_time", time.Since(start)))
 	LoadDatasetStepSecondsVec.WithLabelValues("load_users").Set(time.Since(start).Seconds())
 
-	// STEP 2: pull items
+	// STEP 2: pull items, categories of items are rolled up into ancestors
+	tree, err := server.LoadCategories(ctx, m.CacheClient)
+	if err != nil {
+		return nil, nil, nil, nil, nil, errors.Trace(err)
+	}
 	itemLabelCount := make(map[string]int)
 	itemLabelFirst := make(map[string]int32)
 	itemLabelIndex := base.NewMapIndex()
@@ -1481,6 +1781,8 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 	itemChan, errChan := database.GetItemStream(ctx, batchSize, itemTimeLimit)
 	for items := range itemChan {
 		for _, item := range items {
+			item.Categories = tree.Expand(item.Categories)
+			item.Labels = lo.Reject(item.Labels, func(label string, _ int) bool { return blockedLabels.Has(label) })
 			rankingDataset.AddItem(item.ItemId)
 			itemIndex := rankingDataset.ItemIndex.ToNumber(item.ItemId)
 			if len(rankingDataset.ItemLabels) == int(itemIndex) {
@@ -1514,15 +1816,18 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 				latestItemsFilters[""].Push(item.ItemId, float64(item.Timestamp.Unix()))
 				for _, category := range item.Categories {
 					if _, exist := latestItemsFilters[category]; !exist {
-						latestItemsFilters[category] = heap.NewTopKFilter[string, float64](m.Config.Recommend.CacheSize)
+						latestItemsFilters[category] = heap.NewTopKFilter[string, float64](m.Config().Recommend.CacheSize)
 					}
 					latestItemsFilters[category].Push(item.ItemId, float64(item.Timestamp.Unix()))
 				}
+				if item.Timestamp.After(coldStartTimeLimit) {
+					newItemTimes[itemIndex] = item.Timestamp
+				}
 			}
 		}
 	}
 	if err = <-errChan; err != nil {
-		return nil, nil, nil, nil, errors.Trace(err)
+		return nil, nil, nil, nil, nil, errors.Trace(err)
 	}
 	rankingDataset.NumItemLabels = itemLabelIndex.Len()
 	m.taskMonitor.Update(TaskLoadDataset, 2)
@@ -1532,22 +1837,31 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 		zap.Duration("used_time", time.Since(start)))
 	LoadDatasetStepSecondsVec.WithLabelValues("load_items").Set(time.Since(start).Seconds())
 
-	// create positive set
 	popularCount := make([]int32, rankingDataset.ItemCount())
-	positiveSet := make([]*i32set.Set, rankingDataset.UserCount())
-	for i := range positiveSet {
-		positiveSet[i] = i32set.New()
+	// timestamps of feedback are memorized as time contexts of the click dataset
+	var feedbackTimes map[lo.Tuple2[int32, int32]]time.Time
+	if m.Config().Recommend.Offline.EnableTimeContext {
+		feedbackTimes = make(map[lo.Tuple2[int32, int32]]time.Time)
 	}
 
 	// STEP 3: pull positive feedback
 	var feedbackCount float64
 	start = time.Now()
-	feedbackChan, errChan := database.GetFeedbackStream(ctx, batchSize, feedbackTimeLimit, m.Config.Now(), posFeedbackTypes...)
+	// feedback of counted types is positive only if there are enough events
+	dataSource := m.Config().Recommend.DataSource
+	countedTypes := lo.Map(dataSource.PositiveThresholds, func(threshold config.FeedbackThreshold, _ int) string {
+		return threshold.FeedbackType
+	})
+	feedbackChan, errChan := database.GetFeedbackStream(ctx, batchSize, feedbackTimeLimit, m.Config().Now(),
+		append(append([]string{}, posFeedbackTypes...), countedTypes...)...)
 	for feedback := range feedbackChan {
 		for _, f := range feedback {
 			feedbackCount++
+			if threshold, isCounted := dataSource.PositiveThreshold(f.FeedbackType); isCounted &&
+				!threshold.Reached(server.ParseEventsComment(f).Events, time.Now()) {
+				continue
+			}
 			rankingDataset.AddFeedback(f.UserId, f.ItemId, false)
-			// insert feedback to positive set
 			userIndex := rankingDataset.UserIndex.ToNumber(f.UserId)
 			if userIndex == base.NotId {
 				continue
@@ -1556,16 +1870,25 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 			if itemIndex == base.NotId {
 				continue
 			}
-			positiveSet[userIndex].Add(itemIndex)
 			// insert feedback to popularity counter
 			if f.Timestamp.After(timeWindowLimit) && !rankingDataset.HiddenItems[itemIndex] {
 				popularCount[itemIndex]++
 			}
+			if _, exist := newItemTimes[itemIndex]; exist {
+				newItemImpressions[itemIndex]++
+			}
+			if feedbackTimes != nil {
+				key := lo.Tuple2[int32, int32]{A: userIndex, B: itemIndex}
+				if f.Timestamp.After(feedbackTimes[key]) {
+					feedbackTimes[key] = f.Timestamp
+				}
+			}
 			evaluator.Positive(f.FeedbackType, userIndex, itemIndex, f.Timestamp)
+			replay.Record(userIndex, len(rankingDataset.UserFeedback[userIndex])-1, f.Timestamp)
 		}
 	}
 	if err = <-errChan; err != nil {
-		return nil, nil, nil, nil, errors.Trace(err)
+		return nil, nil, nil, nil, nil, errors.Trace(err)
 	}
 	m.taskMonitor.Update(TaskLoadDataset, 3)
 	log.Logger().Debug("pulled positive feedback from database",
@@ -1573,15 +1896,46 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 		zap.Duration("used_time", time.Since(start)))
 	LoadDatasetStepSecondsVec.WithLabelValues("load_positive_feedback").Set(time.Since(start).Seconds())
 
-	// create negative set
-	negativeSet := make([]*i32set.Set, rankingDataset.UserCount())
-	for i := range negativeSet {
-		negativeSet[i] = i32set.New()
+	// positive feedback is spilled to a file instead of sorted copies in memory
+	positiveSet, err := spill.NewPairs(m.Config().Master.SpillDir)
+	if err != nil {
+		return nil, nil, nil, nil, nil, errors.Trace(err)
+	}
+	defer func() {
+		if err := positiveSet.Close(); err != nil {
+			log.Logger().Warn("failed to remove spilled positive feedback", zap.Error(err))
+		}
+	}()
+	for userIndex, items := range rankingDataset.UserFeedback {
+		for _, itemIndex := range items {
+			if err = positiveSet.Append(int32(userIndex), itemIndex); err != nil {
+				return nil, nil, nil, nil, nil, errors.Trace(err)
+			}
+		}
+	}
+	if err = positiveSet.Sort(); err != nil {
+		return nil, nil, nil, nil, nil, errors.Trace(err)
 	}
 
+	// negative feedback is spilled to a file instead of sets in memory
+	negativeSet, err := spill.NewPairs(m.Config().Master.SpillDir)
+	if err != nil {
+		return nil, nil, nil, nil, nil, errors.Trace(err)
+	}
+	defer func() {
+		if err := negativeSet.Close(); err != nil {
+			log.Logger().Warn("failed to remove spilled negative feedback", zap.Error(err))
+		}
+	}()
+
 	// STEP 4: pull negative feedback
 	start = time.Now()
-	feedbackChan, errChan = database.GetFeedbackStream(ctx, batchSize, feedbackTimeLimit, m.Config.Now(), readTypes...)
+	impressionType := m.Config().Recommend.DataSource.ImpressionFeedbackType
+	if impressionType != "" && !lo.Contains(readTypes, impressionType) {
+		readTypes = append(append([]string{}, readTypes...), impressionType)
+	}
+	impressionPositions := make(map[lo.Tuple2[int32, int32]]int)
+	feedbackChan, errChan = database.GetFeedbackStream(ctx, batchSize, feedbackTimeLimit, m.Config().Now(), readTypes...)
 	for feedback := range feedbackChan {
 		for _, f := range feedback {
 			feedbackCount++
@@ -1593,14 +1947,31 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 			if itemIndex == base.NotId {
 				continue
 			}
-			if !positiveSet[userIndex].Has(itemIndex) {
-				negativeSet[userIndex].Add(itemIndex)
+			if _, exist := newItemTimes[itemIndex]; exist {
+				newItemImpressions[itemIndex]++
+			}
+			if !positiveSet.Contains(userIndex, itemIndex) {
+				if err = negativeSet.Append(userIndex, itemIndex); err != nil {
+					return nil, nil, nil, nil, nil, errors.Trace(err)
+				}
+				if feedbackTimes != nil {
+					key := lo.Tuple2[int32, int32]{A: userIndex, B: itemIndex}
+					if f.Timestamp.After(feedbackTimes[key]) {
+						feedbackTimes[key] = f.Timestamp
+					}
+				}
+			}
+			if impressionType != "" && f.FeedbackType == impressionType {
+				// the position of an impression is stored in the comment
+				if comment, err := server.ParseImpressionComment(f.Comment); err == nil && comment.Position >= 0 && comment.Position < m.Config().Recommend.CacheSize {
+					impressionPositions[lo.Tuple2[int32, int32]{A: userIndex, B: itemIndex}] = comment.Position
+				}
 			}
 			evaluator.Read(userIndex, itemIndex, f.Timestamp)
 		}
 	}
 	if err = <-errChan; err != nil {
-		return nil, nil, nil, nil, errors.Trace(err)
+		return nil, nil, nil, nil, nil, errors.Trace(err)
 	}
 	m.taskMonitor.Update(TaskLoadDataset, 4)
 	FeedbacksTotal.Set(feedbackCount)
@@ -1610,42 +1981,130 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 
 	// STEP 5: create click dataset
 	start = time.Now()
+	var propensity []float32
+	if len(impressionPositions) > 0 {
+		// estimate propensities of positions by impressions
+		var impressions, clicks []int
+		for key, position := range impressionPositions {
+			if position >= len(impressions) {
+				impressions = append(impressions, make([]int, position+1-len(impressions))...)
+				clicks = append(clicks, make([]int, position+1-len(clicks))...)
+			}
+			impressions[position]++
+			if positiveSet.Contains(key.A, key.B) {
+				clicks[position]++
+			}
+		}
+		propensity = click.EstimatePropensity(impressions, clicks)
+		log.Logger().Debug("estimated propensities of positions", zap.Any("propensity", propensity))
+	}
+	// append features from the feature store to labels for the click model only
+	userFeatures, itemFeatures := rankingDataset.UserLabels, rankingDataset.ItemLabels
+	if m.FeatureClient != nil {
+		userFeatures = appendStoreFeatures(ctx, rankingDataset.UserIndex.GetNames(), rankingDataset.UserLabels,
+			userLabelIndex, m.FeatureClient.GetUserFeatures)
+		itemFeatures = appendStoreFeatures(ctx, rankingDataset.ItemIndex.GetNames(), rankingDataset.ItemLabels,
+			itemLabelIndex, m.FeatureClient.GetItemFeatures)
+	}
 	unifiedIndex := click.NewUnifiedMapIndexBuilder()
 	unifiedIndex.ItemIndex = rankingDataset.ItemIndex
 	unifiedIndex.UserIndex = rankingDataset.UserIndex
 	unifiedIndex.ItemLabelIndex = itemLabelIndex
 	unifiedIndex.UserLabelIndex = userLabelIndex
+	if feedbackTimes != nil {
+		for hour := 0; hour < 24; hour++ {
+			for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+				for _, label := range click.TimeContextLabels(hour, weekday) {
+					unifiedIndex.AddCtxLabel(label)
+				}
+			}
+		}
+	}
 	clickDataset = &click.Dataset{
 		Index:        unifiedIndex.Build(),
-		UserFeatures: rankingDataset.UserLabels,
-		ItemFeatures: rankingDataset.ItemLabels,
-	}
-	for userIndex := range positiveSet {
-		if positiveSet[userIndex].IsEmpty() || negativeSet[userIndex].IsEmpty() {
-			// release positive set and negative set
-			positiveSet[userIndex] = nil
-			negativeSet[userIndex] = nil
+		UserFeatures: userFeatures,
+		ItemFeatures: itemFeatures,
+	}
+	appendTimeContext := func(userIndex, itemIndex int32) {
+		if feedbackTimes == nil {
+			return
+		}
+		var features []int32
+		var values []float32
+		if timestamp, exist := feedbackTimes[lo.Tuple2[int32, int32]{A: userIndex, B: itemIndex}]; exist && !timestamp.IsZero() {
+			timestamp = timestamp.UTC()
+			for _, label := range click.TimeContextLabels(timestamp.Hour(), timestamp.Weekday()) {
+				features = append(features, clickDataset.Index.EncodeContextLabel(label))
+				values = append(values, 1)
+			}
+		}
+		clickDataset.CtxFeatures = append(clickDataset.CtxFeatures, features)
+		clickDataset.CtxValues = append(clickDataset.CtxValues, values)
+	}
+	if err = negativeSet.Sort(); err != nil {
+		return nil, nil, nil, nil, nil, errors.Trace(err)
+	}
+	// userEnd returns the end of pairs of the user beginning at begin
+	userEnd := func(pairs *spill.Pairs, begin, userIndex int) int {
+		end := begin
+		for end < pairs.Len() {
+			if u, _ := pairs.Get(end); int(u) != userIndex {
+				break
+			}
+			end++
+		}
+		return end
+	}
+	for userIndex, positiveBegin, negativeBegin := 0, 0, 0; userIndex < rankingDataset.UserCount(); userIndex++ {
+		// feedback of the user is sorted in [positiveBegin, positiveEnd) and [negativeBegin, negativeEnd)
+		positiveEnd := userEnd(positiveSet, positiveBegin, userIndex)
+		negativeEnd := userEnd(negativeSet, negativeBegin, userIndex)
+		if positiveBegin == positiveEnd || negativeBegin == negativeEnd {
+			positiveBegin, negativeBegin = positiveEnd, negativeEnd
 			continue
 		}
 		// insert positive feedback
-		for _, itemIndex := range positiveSet[userIndex].List() {
+		for i := positiveBegin; i < positiveEnd; i++ {
+			_, itemIndex := positiveSet.Get(i)
+			if i > positiveBegin {
+				if _, prevIndex := positiveSet.Get(i - 1); prevIndex == itemIndex {
+					continue
+				}
+			}
 			clickDataset.Users.Append(int32(userIndex))
 			clickDataset.Items.Append(itemIndex)
 			clickDataset.NormValues.Append(1 / math32.Sqrt(float32(len(clickDataset.UserFeatures[userIndex])+len(clickDataset.ItemFeatures[itemIndex]))))
 			clickDataset.Target.Append(1)
 			clickDataset.PositiveCount++
+			appendTimeContext(int32(userIndex), itemIndex)
+			if propensity != nil {
+				// inverse propensity weighting for clicks on impressions
+				weight := float32(1)
+				if position, exist := impressionPositions[lo.Tuple2[int32, int32]{A: int32(userIndex), B: itemIndex}]; exist {
+					weight = 1 / propensity[position]
+				}
+				clickDataset.Weights.Append(weight)
+			}
 		}
 		// insert negative feedback
-		for _, itemIndex := range negativeSet[userIndex].List() {
+		for i := negativeBegin; i < negativeEnd; i++ {
+			_, itemIndex := negativeSet.Get(i)
+			if i > negativeBegin {
+				if _, prevIndex := negativeSet.Get(i - 1); prevIndex == itemIndex {
+					continue
+				}
+			}
 			clickDataset.Users.Append(int32(userIndex))
 			clickDataset.Items.Append(itemIndex)
 			clickDataset.NormValues.Append(1 / math32.Sqrt(float32(len(clickDataset.UserFeatures[userIndex])+len(clickDataset.ItemFeatures[itemIndex]))))
 			clickDataset.Target.Append(-1)
 			clickDataset.NegativeCount++
+			appendTimeContext(int32(userIndex), itemIndex)
+			if propensity != nil {
+				clickDataset.Weights.Append(1)
+			}
 		}
-		// release positive set and negative set
-		positiveSet[userIndex] = nil
-		negativeSet[userIndex] = nil
+		positiveBegin, negativeBegin = positiveEnd, negativeEnd
 	}
 	log.Logger().Debug("created ranking dataset",
 		zap.Int("n_valid_positive", clickDataset.PositiveCount),
@@ -1663,13 +2122,13 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 
 	// collect popular items
 	popularItemFilters := make(map[string]*heap.TopKFilter[string, float64])
-	popularItemFilters[""] = heap.NewTopKFilter[string, float64](m.Config.Recommend.CacheSize)
+	popularItemFilters[""] = heap.NewTopKFilter[string, float64](m.Config().Recommend.CacheSize)
 	for itemIndex, val := range popularCount {
 		itemId := rankingDataset.ItemIndex.ToName(int32(itemIndex))
 		popularItemFilters[""].Push(itemId, float64(val))
 		for _, category := range rankingDataset.ItemCategories[itemIndex] {
 			if _, exist := popularItemFilters[category]; !exist {
-				popularItemFilters[category] = heap.NewTopKFilter[string, float64](m.Config.Recommend.CacheSize)
+				popularItemFilters[category] = heap.NewTopKFilter[string, float64](m.Config().Recommend.CacheSize)
 			}
 			popularItemFilters[category].Push(itemId, float64(val))
 		}
@@ -1680,6 +2139,28 @@ func (m *Master) LoadDataFromDatabase(database data.Database, posFeedbackTypes,
 		popularItems[category] = cache.CreateScoredItems(items, scores)
 	}
 
+	// collect cold-start items, and categories without cold-start items are cleared
+	coldStartItemFilters := make(map[string]*heap.TopKFilter[string, float64])
+	coldStartItemFilters[""] = heap.NewTopKFilter[string, float64](m.Config().Recommend.CacheSize)
+	for _, category := range rankingDataset.CategorySet.List() {
+		coldStartItemFilters[category] = heap.NewTopKFilter[string, float64](m.Config().Recommend.CacheSize)
+	}
+	for itemIndex, timestamp := range newItemTimes {
+		if newItemImpressions[itemIndex] > m.Config().Recommend.Offline.ColdStartMaxImpressions {
+			continue
+		}
+		itemId := rankingDataset.ItemIndex.ToName(itemIndex)
+		coldStartItemFilters[""].Push(itemId, float64(timestamp.Unix()))
+		for _, category := range rankingDataset.ItemCategories[itemIndex] {
+			coldStartItemFilters[category].Push(itemId, float64(timestamp.Unix()))
+		}
+	}
+	coldStartItems = make(map[string][]cache.Scored)
+	for category, coldStartItemFilter := range coldStartItemFilters {
+		items, scores := coldStartItemFilter.PopAll()
+		coldStartItems[category] = cache.CreateScoredItems(items, scores)
+	}
+
 	m.taskMonitor.Finish(TaskLoadDataset)
-	return rankingDataset, clickDataset, latestItems, popularItems, nil
+	return rankingDataset, clickDataset, latestItems, popularItems, coldStartItems, nil
 }
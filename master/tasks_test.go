@@ -16,14 +16,18 @@ package master
 
 import (
 	"context"
+	"os"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/juju/errors"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/zhenghaoz/gorse/base/task"
 	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/model/click"
+	"github.com/zhenghaoz/gorse/server"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
 )
@@ -34,21 +38,21 @@ func TestMaster_FindItemNeighborsBruteForce(t *testing.T) {
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Master.NumJobs = 4
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
 	// collect similar
 	items := []data.Item{
-		{"0", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d"}, ""},
-		{"1", false, []string{"*"}, time.Now(), []string{}, ""},
-		{"2", false, []string{"*"}, time.Now(), []string{"b", "c", "d"}, ""},
-		{"3", false, nil, time.Now(), []string{}, ""},
-		{"4", false, nil, time.Now(), []string{"b", "c"}, ""},
-		{"5", false, []string{"*"}, time.Now(), []string{}, ""},
-		{"6", false, []string{"*"}, time.Now(), []string{"c"}, ""},
-		{"7", false, []string{"*"}, time.Now(), []string{}, ""},
-		{"8", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d", "e"}, ""},
-		{"9", false, nil, time.Now(), []string{}, ""},
+		{"0", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d"}, "", nil, nil},
+		{"1", false, []string{"*"}, time.Now(), []string{}, "", nil, nil},
+		{"2", false, []string{"*"}, time.Now(), []string{"b", "c", "d"}, "", nil, nil},
+		{"3", false, nil, time.Now(), []string{}, "", nil, nil},
+		{"4", false, nil, time.Now(), []string{"b", "c"}, "", nil, nil},
+		{"5", false, []string{"*"}, time.Now(), []string{}, "", nil, nil},
+		{"6", false, []string{"*"}, time.Now(), []string{"c"}, "", nil, nil},
+		{"7", false, []string{"*"}, time.Now(), []string{}, "", nil, nil},
+		{"8", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d", "e"}, "", nil, nil},
+		{"9", false, nil, time.Now(), []string{}, "", nil, nil},
 	}
 	feedbacks := make([]data.Feedback, 0)
 	for i := 0; i < 10; i++ {
@@ -86,12 +90,12 @@ func TestMaster_FindItemNeighborsBruteForce(t *testing.T) {
 	}
 
 	// load mock dataset
-	dataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator())
+	dataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator(), nil)
 	assert.NoError(t, err)
 	m.rankingTrainSet = dataset
 
 	// similar items (common users)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeRelated
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeRelated
 	neighborTask := NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "9"), 0, 100)
@@ -107,7 +111,7 @@ func TestMaster_FindItemNeighborsBruteForce(t *testing.T) {
 	// similar items (common labels)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyItemTime, "8"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeSimilar
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeSimilar
 	neighborTask = NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "8"), 0, 100)
@@ -125,7 +129,7 @@ func TestMaster_FindItemNeighborsBruteForce(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyItemTime, "9"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeAuto
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeAuto
 	neighborTask = NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "8"), 0, 100)
@@ -144,24 +148,24 @@ func TestMaster_FindItemNeighborsIVF(t *testing.T) {
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Master.NumJobs = 4
-	m.Config.Recommend.ItemNeighbors.EnableIndex = true
-	m.Config.Recommend.ItemNeighbors.IndexRecall = 1
-	m.Config.Recommend.ItemNeighbors.IndexFitEpoch = 10
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
+	m.Config().Recommend.ItemNeighbors.EnableIndex = true
+	m.Config().Recommend.ItemNeighbors.IndexRecall = 1
+	m.Config().Recommend.ItemNeighbors.IndexFitEpoch = 10
 	// collect similar
 	items := []data.Item{
-		{"0", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d"}, ""},
-		{"1", false, []string{"*"}, time.Now(), []string{}, ""},
-		{"2", false, []string{"*"}, time.Now(), []string{"b", "c", "d"}, ""},
-		{"3", false, nil, time.Now(), []string{}, ""},
-		{"4", false, nil, time.Now(), []string{"b", "c"}, ""},
-		{"5", false, []string{"*"}, time.Now(), []string{}, ""},
-		{"6", false, []string{"*"}, time.Now(), []string{"c"}, ""},
-		{"7", false, []string{"*"}, time.Now(), []string{}, ""},
-		{"8", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d", "e"}, ""},
-		{"9", false, nil, time.Now(), []string{}, ""},
+		{"0", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d"}, "", nil, nil},
+		{"1", false, []string{"*"}, time.Now(), []string{}, "", nil, nil},
+		{"2", false, []string{"*"}, time.Now(), []string{"b", "c", "d"}, "", nil, nil},
+		{"3", false, nil, time.Now(), []string{}, "", nil, nil},
+		{"4", false, nil, time.Now(), []string{"b", "c"}, "", nil, nil},
+		{"5", false, []string{"*"}, time.Now(), []string{}, "", nil, nil},
+		{"6", false, []string{"*"}, time.Now(), []string{"c"}, "", nil, nil},
+		{"7", false, []string{"*"}, time.Now(), []string{}, "", nil, nil},
+		{"8", false, []string{"*"}, time.Now(), []string{"a", "b", "c", "d", "e"}, "", nil, nil},
+		{"9", false, nil, time.Now(), []string{}, "", nil, nil},
 	}
 	feedbacks := make([]data.Feedback, 0)
 	for i := 0; i < 10; i++ {
@@ -199,12 +203,12 @@ func TestMaster_FindItemNeighborsIVF(t *testing.T) {
 	}
 
 	// load mock dataset
-	dataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator())
+	dataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator(), nil)
 	assert.NoError(t, err)
 	m.rankingTrainSet = dataset
 
 	// similar items (common users)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeRelated
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeRelated
 	neighborTask := NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "9"), 0, 100)
@@ -220,7 +224,7 @@ func TestMaster_FindItemNeighborsIVF(t *testing.T) {
 	// similar items (common labels)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyItemTime, "8"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeSimilar
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeSimilar
 	neighborTask = NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "8"), 0, 100)
@@ -238,7 +242,7 @@ func TestMaster_FindItemNeighborsIVF(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyItemTime, "9"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeAuto
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeAuto
 	neighborTask = NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "8"), 0, 100)
@@ -257,17 +261,17 @@ func TestMaster_FindItemNeighborsIVF_ZeroIDF(t *testing.T) {
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Master.NumJobs = 4
-	m.Config.Recommend.ItemNeighbors.EnableIndex = true
-	m.Config.Recommend.ItemNeighbors.IndexRecall = 1
-	m.Config.Recommend.ItemNeighbors.IndexFitEpoch = 10
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
+	m.Config().Recommend.ItemNeighbors.EnableIndex = true
+	m.Config().Recommend.ItemNeighbors.IndexRecall = 1
+	m.Config().Recommend.ItemNeighbors.IndexFitEpoch = 10
 
 	// create dataset
 	err := m.DataClient.BatchInsertItems(ctx, []data.Item{
-		{"0", false, []string{"*"}, time.Now(), []string{"a", "a"}, ""},
-		{"1", false, []string{"*"}, time.Now(), []string{"a", "a"}, ""},
+		{"0", false, []string{"*"}, time.Now(), []string{"a", "a"}, "", nil, nil},
+		{"1", false, []string{"*"}, time.Now(), []string{"a", "a"}, "", nil, nil},
 	})
 	assert.NoError(t, err)
 	err = m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
@@ -275,12 +279,12 @@ func TestMaster_FindItemNeighborsIVF_ZeroIDF(t *testing.T) {
 		{FeedbackKey: data.FeedbackKey{FeedbackType: "FeedbackType", UserId: "0", ItemId: "1"}},
 	}, true, true, true)
 	assert.NoError(t, err)
-	dataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator())
+	dataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator(), nil)
 	assert.NoError(t, err)
 	m.rankingTrainSet = dataset
 
 	// similar items (common users)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeRelated
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeRelated
 	neighborTask := NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "0"), 0, 100)
@@ -288,7 +292,7 @@ func TestMaster_FindItemNeighborsIVF_ZeroIDF(t *testing.T) {
 	assert.Equal(t, []string{"1"}, cache.RemoveScores(similar))
 
 	// similar items (common labels)
-	m.Config.Recommend.ItemNeighbors.NeighborType = config.NeighborTypeSimilar
+	m.Config().Recommend.ItemNeighbors.NeighborType = config.NeighborTypeSimilar
 	neighborTask = NewFindItemNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "0"), 0, 100)
@@ -302,9 +306,9 @@ func TestMaster_FindUserNeighborsBruteForce(t *testing.T) {
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Master.NumJobs = 4
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
 	// collect similar
 	users := []data.User{
 		{"0", []string{"a", "b", "c", "d"}, nil, ""},
@@ -338,24 +342,29 @@ func TestMaster_FindUserNeighborsBruteForce(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.DataClient.BatchInsertFeedback(ctx, feedbacks, true, true, true)
 	assert.NoError(t, err)
-	dataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator())
+	err = m.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "7", Categories: []string{"x"}}, {ItemId: "8", Categories: []string{"x"}}})
+	assert.NoError(t, err)
+	dataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator(), nil)
 	assert.NoError(t, err)
 	m.rankingTrainSet = dataset
 
 	// similar items (common users)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeRelated
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeRelated
 	neighborTask := NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "9"), 0, 100)
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"7", "5", "3"}, cache.RemoveScores(similar))
+	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "9", "x"), 0, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"7"}, cache.RemoveScores(similar))
 	assert.Equal(t, m.estimateFindUserNeighborsComplexity(dataset), m.taskMonitor.Tasks[TaskFindUserNeighbors].Done)
 	assert.Equal(t, task.StatusComplete, m.taskMonitor.Tasks[TaskFindUserNeighbors].Status)
 
 	// similar items (common labels)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "8"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeSimilar
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeSimilar
 	neighborTask = NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "8"), 0, 100)
@@ -369,7 +378,7 @@ func TestMaster_FindUserNeighborsBruteForce(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "9"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeAuto
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeAuto
 	neighborTask = NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "8"), 0, 100)
@@ -388,12 +397,12 @@ func TestMaster_FindUserNeighborsIVF(t *testing.T) {
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Master.NumJobs = 4
-	m.Config.Recommend.UserNeighbors.EnableIndex = true
-	m.Config.Recommend.UserNeighbors.IndexRecall = 1
-	m.Config.Recommend.UserNeighbors.IndexFitEpoch = 10
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
+	m.Config().Recommend.UserNeighbors.EnableIndex = true
+	m.Config().Recommend.UserNeighbors.IndexRecall = 1
+	m.Config().Recommend.UserNeighbors.IndexFitEpoch = 10
 	// collect similar
 	users := []data.User{
 		{"0", []string{"a", "b", "c", "d"}, nil, ""},
@@ -427,24 +436,29 @@ func TestMaster_FindUserNeighborsIVF(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.DataClient.BatchInsertFeedback(ctx, feedbacks, true, true, true)
 	assert.NoError(t, err)
-	dataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator())
+	err = m.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "7", Categories: []string{"x"}}, {ItemId: "8", Categories: []string{"x"}}})
+	assert.NoError(t, err)
+	dataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator(), nil)
 	assert.NoError(t, err)
 	m.rankingTrainSet = dataset
 
 	// similar items (common users)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeRelated
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeRelated
 	neighborTask := NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "9"), 0, 100)
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"7", "5", "3"}, cache.RemoveScores(similar))
+	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "9", "x"), 0, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"7"}, cache.RemoveScores(similar))
 	assert.Equal(t, m.estimateFindUserNeighborsComplexity(dataset), m.taskMonitor.Tasks[TaskFindUserNeighbors].Done)
 	assert.Equal(t, task.StatusComplete, m.taskMonitor.Tasks[TaskFindUserNeighbors].Status)
 
 	// similar items (common labels)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "8"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeSimilar
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeSimilar
 	neighborTask = NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "8"), 0, 100)
@@ -458,7 +472,7 @@ func TestMaster_FindUserNeighborsIVF(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "9"), time.Now()))
 	assert.NoError(t, err)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeAuto
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeAuto
 	neighborTask = NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "8"), 0, 100)
@@ -477,12 +491,12 @@ func TestMaster_FindUserNeighborsIVF_ZeroIDF(t *testing.T) {
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Master.NumJobs = 4
-	m.Config.Recommend.UserNeighbors.EnableIndex = true
-	m.Config.Recommend.UserNeighbors.IndexRecall = 1
-	m.Config.Recommend.UserNeighbors.IndexFitEpoch = 10
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
+	m.Config().Recommend.UserNeighbors.EnableIndex = true
+	m.Config().Recommend.UserNeighbors.IndexRecall = 1
+	m.Config().Recommend.UserNeighbors.IndexFitEpoch = 10
 
 	// create dataset
 	err := m.DataClient.BatchInsertUsers(ctx, []data.User{
@@ -495,12 +509,12 @@ func TestMaster_FindUserNeighborsIVF_ZeroIDF(t *testing.T) {
 		{FeedbackKey: data.FeedbackKey{FeedbackType: "FeedbackType", UserId: "1", ItemId: "0"}},
 	}, true, true, true)
 	assert.NoError(t, err)
-	dataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator())
+	dataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"FeedbackType"}, nil, 0, 0, NewOnlineEvaluator(), nil)
 	assert.NoError(t, err)
 	m.rankingTrainSet = dataset
 
 	// similar users (common items)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeRelated
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeRelated
 	neighborTask := NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "0"), 0, 100)
@@ -508,7 +522,7 @@ func TestMaster_FindUserNeighborsIVF_ZeroIDF(t *testing.T) {
 	assert.Equal(t, []string{"1"}, cache.RemoveScores(similar))
 
 	// similar users (common labels)
-	m.Config.Recommend.UserNeighbors.NeighborType = config.NeighborTypeSimilar
+	m.Config().Recommend.UserNeighbors.NeighborType = config.NeighborTypeSimilar
 	neighborTask = NewFindUserNeighborsTask(&m.Master)
 	assert.NoError(t, neighborTask.run(nil))
 	similar, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "0"), 0, 100)
@@ -516,16 +530,70 @@ func TestMaster_FindUserNeighborsIVF_ZeroIDF(t *testing.T) {
 	assert.Equal(t, []string{"1"}, cache.RemoveScores(similar))
 }
 
+func TestMaster_FindAlsoLikedItems(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Master.NumJobs = 4
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"like"}
+
+	// create dataset
+	now := time.Now()
+	err := m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "0"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "1"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "2"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "1", ItemId: "0"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "1", ItemId: "1"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "2", ItemId: "0"}, Timestamp: now.Add(-60 * 24 * time.Hour)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "2", ItemId: "3"}, Timestamp: now.Add(-60 * 24 * time.Hour)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "star", UserId: "3", ItemId: "1"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "star", UserId: "3", ItemId: "2"}, Timestamp: now},
+	}, true, true, true)
+	assert.NoError(t, err)
+
+	// all positive feedback
+	alsoLikedTask := NewFindAlsoLikedItemsTask(&m.Master)
+	assert.NoError(t, alsoLikedTask.run(nil))
+	alsoLiked, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.AlsoLikedItems, "0"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"1", 2}}, alsoLiked[:1])
+	alsoLiked, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.AlsoLikedItems, "3"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"0", 1}}, alsoLiked)
+	assert.Equal(t, task.StatusComplete, m.taskMonitor.Tasks[TaskFindAlsoLikedItems].Status)
+
+	// feedback in the time window
+	m.Config().Recommend.AlsoLiked.TimeWindow = 30 * 24 * time.Hour
+	m.Config().Recommend.AlsoLiked.FeedbackTypes = []string{"like", "star"}
+	assert.NoError(t, alsoLikedTask.run(nil))
+	alsoLiked, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.AlsoLikedItems, "0"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"1", 2}, {"2", 1}}, alsoLiked)
+	alsoLiked, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.AlsoLikedItems, "2"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"1", 2}, {"0", 1}}, alsoLiked)
+	alsoLiked, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.AlsoLikedItems, "3"), 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, alsoLiked)
+}
+
 func TestMaster_LoadDataFromDatabase(t *testing.T) {
 	// create mock master
 	m := newMockMaster(t)
 	defer m.Close()
 	ctx := context.Background()
 	// create config
-	m.Config = &config.Config{}
-	m.Config.Recommend.CacheSize = 3
-	m.Config.Recommend.DataSource.PositiveFeedbackTypes = []string{"positive"}
-	m.Config.Recommend.DataSource.ReadFeedbackTypes = []string{"negative"}
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"positive"}
+	m.Config().Recommend.DataSource.ReadFeedbackTypes = []string{"negative"}
+	m.Config().Recommend.Offline.PopularityPenalty = 0.5
+	m.Config().Master.SpillDir = t.TempDir()
 
 	// insert items
 	var items []data.Item
@@ -611,6 +679,9 @@ func TestMaster_LoadDataFromDatabase(t *testing.T) {
 	assert.Equal(t, 90, m.clickTrainSet.Count()+m.clickTestSet.Count())
 	assert.Equal(t, 45, m.clickTrainSet.PositiveCount+m.clickTestSet.PositiveCount)
 	assert.Equal(t, 45, m.clickTrainSet.NegativeCount+m.clickTestSet.NegativeCount)
+	spilled, err := os.ReadDir(m.Config().Master.SpillDir)
+	assert.NoError(t, err)
+	assert.Empty(t, spilled)
 
 	// check latest items
 	latest, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.LatestItems, ""), 0, 100)
@@ -644,17 +715,351 @@ func TestMaster_LoadDataFromDatabase(t *testing.T) {
 		{Id: items[2].ItemId, Score: 3},
 	}, popular)
 
+	// check item frequency
+	frequency, err := m.CacheClient.GetSorted(ctx, cache.ItemFrequency, 0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{
+		{Id: "9", Score: 10},
+		{Id: items[8].ItemId, Score: 9},
+		{Id: items[7].ItemId, Score: 8},
+	}, frequency)
+
 	// check categories
 	categories, err := m.CacheClient.GetSet(ctx, cache.ItemCategories)
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"0", "1", "2"}, categories)
 }
 
+func TestMaster_LoadDataFromDatabaseWithImpressions(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"positive"}
+	m.Config().Recommend.DataSource.ImpressionFeedbackType = "impression"
+
+	// insert impressions: item i is shown at position i to every user
+	var feedbacks []data.Feedback
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 3; j++ {
+			comment := server.ImpressionComment{Position: j}.String()
+			if i == 0 {
+				// positions written as plain numbers are accepted
+				comment = strconv.Itoa(j)
+			}
+			feedbacks = append(feedbacks, data.Feedback{
+				FeedbackKey: data.FeedbackKey{FeedbackType: "impression", UserId: strconv.Itoa(i), ItemId: strconv.Itoa(j)},
+				Timestamp:   time.Now(),
+				Comment:     comment,
+			})
+		}
+	}
+	// insert clicks: click-through rates of positions are 0.5, 0.25 and 0
+	feedbacks = append(feedbacks,
+		data.Feedback{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "0", ItemId: "0"}, Timestamp: time.Now()},
+		data.Feedback{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "1", ItemId: "0"}, Timestamp: time.Now()},
+		data.Feedback{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "0", ItemId: "1"}, Timestamp: time.Now()})
+	err := m.DataClient.BatchInsertFeedback(ctx, feedbacks, true, true, true)
+	assert.NoError(t, err)
+
+	// load dataset
+	_, clickDataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"positive"}, nil, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, clickDataset.Count())
+	assert.Equal(t, 3, clickDataset.PositiveCount)
+	assert.Equal(t, 3, clickDataset.NegativeCount)
+	weights := make(map[lo.Tuple2[string, string]]float32)
+	for i := 0; i < clickDataset.Count(); i++ {
+		userId := clickDataset.Index.GetUsers()[clickDataset.Users.Get(i)]
+		itemId := clickDataset.Index.GetItems()[clickDataset.Items.Get(i)]
+		weights[lo.Tuple2[string, string]{A: userId, B: itemId}] = clickDataset.GetWeight(i)
+	}
+	assert.Equal(t, map[lo.Tuple2[string, string]]float32{
+		{A: "0", B: "0"}: 1,
+		{A: "0", B: "1"}: 2,
+		{A: "0", B: "2"}: 1,
+		{A: "1", B: "0"}: 1,
+		{A: "1", B: "1"}: 1,
+		{A: "1", B: "2"}: 1,
+	}, weights)
+}
+
+func TestMaster_LoadDataFromDatabaseWithThresholds(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"positive"}
+	m.Config().Recommend.DataSource.PositiveThresholds = []config.FeedbackThreshold{
+		{FeedbackType: "play", Count: 2, Window: time.Hour},
+	}
+
+	// insert plays: only user 0 plays item 0 twice within the window
+	now := time.Now()
+	err := m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{
+			FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"},
+			Timestamp:   now,
+			Comment:     server.EventsComment{Events: []time.Time{now.Add(-time.Minute), now}}.String(),
+		},
+		{
+			FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "1"},
+			Timestamp:   now,
+			Comment:     server.EventsComment{Events: []time.Time{now.Add(-2 * time.Hour), now}}.String(),
+		},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "1", ItemId: "0"}, Timestamp: now},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "1", ItemId: "1"}, Timestamp: now},
+	}, true, true, true)
+	assert.NoError(t, err)
+
+	// load dataset
+	rankingDataset, _, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"positive"}, nil, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rankingDataset.Count())
+	assert.Equal(t, []int32{rankingDataset.ItemIndex.ToNumber("0")}, rankingDataset.UserFeedback[rankingDataset.UserIndex.ToNumber("0")])
+	assert.Equal(t, []int32{rankingDataset.ItemIndex.ToNumber("1")}, rankingDataset.UserFeedback[rankingDataset.UserIndex.ToNumber("1")])
+}
+
+func TestMaster_LoadDataFromDatabaseWithBlockedLabels(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"positive"}
+
+	// insert users and items labeled by a junk label
+	err := m.DataClient.BatchInsertUsers(ctx, []data.User{
+		{UserId: "0", Labels: []string{"male", "junk"}},
+		{UserId: "1", Labels: []string{"male", "junk"}},
+	})
+	assert.NoError(t, err)
+	err = m.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "0", Labels: []string{"news", "junk"}},
+		{ItemId: "1", Labels: []string{"news", "junk"}},
+	})
+	assert.NoError(t, err)
+	err = m.CacheClient.AddSet(ctx, cache.BlockedLabels, "junk")
+	assert.NoError(t, err)
+
+	// load dataset
+	rankingDataset, clickDataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"positive"}, nil, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), rankingDataset.NumUserLabels)
+	assert.Equal(t, int32(1), rankingDataset.NumItemLabels)
+	assert.Equal(t, int32(1), clickDataset.Index.CountUserLabels())
+	assert.Equal(t, int32(1), clickDataset.Index.CountItemLabels())
+}
+
+func TestMaster_LoadDataFromDatabaseWithTimeContext(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.Offline.EnableTimeContext = true
+
+	// insert feedback at 08:00 on Monday and 20:00 on Saturday
+	monday := time.Date(2023, 1, 2, 8, 0, 0, 0, time.UTC)
+	saturday := time.Date(2023, 1, 7, 20, 0, 0, 0, time.UTC)
+	err := m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "0", ItemId: "0"}, Timestamp: monday},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "1"}, Timestamp: saturday},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "1", ItemId: "1"}, Timestamp: saturday},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "1", ItemId: "0"}, Timestamp: monday},
+	}, true, true, true)
+	assert.NoError(t, err)
+
+	// load dataset
+	_, clickDataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"positive"}, []string{"read"}, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, clickDataset.Count())
+	assert.Equal(t, int32(24+7), clickDataset.Index.CountContextLabels())
+	contexts := make(map[lo.Tuple2[string, string]][]int32)
+	for i := 0; i < clickDataset.Count(); i++ {
+		userId := clickDataset.Index.GetUsers()[clickDataset.Users.Get(i)]
+		itemId := clickDataset.Index.GetItems()[clickDataset.Items.Get(i)]
+		contexts[lo.Tuple2[string, string]{A: userId, B: itemId}] = clickDataset.CtxFeatures[i]
+		assert.Equal(t, []float32{1, 1}, clickDataset.CtxValues[i])
+	}
+	encode := func(labels []string) []int32 {
+		return lo.Map(labels, func(label string, _ int) int32 {
+			return clickDataset.Index.EncodeContextLabel(label)
+		})
+	}
+	assert.Equal(t, map[lo.Tuple2[string, string]][]int32{
+		{A: "0", B: "0"}: encode(click.TimeContextLabels(8, time.Monday)),
+		{A: "0", B: "1"}: encode(click.TimeContextLabels(20, time.Saturday)),
+		{A: "1", B: "0"}: encode(click.TimeContextLabels(8, time.Monday)),
+		{A: "1", B: "1"}: encode(click.TimeContextLabels(20, time.Saturday)),
+	}, contexts)
+}
+
+// mockFeatureStore returns features of users and items in maps.
+type mockFeatureStore struct {
+	users map[string][]string
+	items map[string][]string
+}
+
+func (m *mockFeatureStore) GetUserFeatures(_ context.Context, userIds []string) (map[string][]string, error) {
+	return lo.PickByKeys(m.users, userIds), nil
+}
+
+func (m *mockFeatureStore) GetItemFeatures(_ context.Context, itemIds []string) (map[string][]string, error) {
+	return lo.PickByKeys(m.items, itemIds), nil
+}
+
+func (m *mockFeatureStore) Close() error {
+	return nil
+}
+
+func TestMaster_LoadDataFromDatabaseWithFeatureStore(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.FeatureClient = &mockFeatureStore{
+		users: map[string][]string{"0": {"segment:vip", "a"}},
+		items: map[string][]string{"1": {"brand:gorse"}},
+	}
+
+	// insert users with labels and feedback
+	err := m.DataClient.BatchInsertUsers(ctx, []data.User{
+		{UserId: "0", Labels: []string{"a"}},
+		{UserId: "1", Labels: []string{"a"}},
+	})
+	assert.NoError(t, err)
+	err = m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "0", ItemId: "0"}, Timestamp: time.Now()},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "1"}, Timestamp: time.Now()},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "1", ItemId: "1"}, Timestamp: time.Now()},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "1", ItemId: "0"}, Timestamp: time.Now()},
+	}, true, true, true)
+	assert.NoError(t, err)
+
+	// load dataset
+	rankingDataset, clickDataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient, []string{"positive"}, []string{"read"}, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, clickDataset.Count())
+	assert.Equal(t, int32(2), clickDataset.Index.CountUserLabels())
+	assert.Equal(t, int32(1), clickDataset.Index.CountItemLabels())
+	// features are appended to labels of the click dataset only
+	user0, user1 := rankingDataset.UserIndex.ToNumber("0"), rankingDataset.UserIndex.ToNumber("1")
+	item0, item1 := rankingDataset.ItemIndex.ToNumber("0"), rankingDataset.ItemIndex.ToNumber("1")
+	assert.Equal(t, []int32{0, 1}, clickDataset.UserFeatures[user0])
+	assert.Equal(t, []int32{0}, clickDataset.UserFeatures[user1])
+	assert.Empty(t, clickDataset.ItemFeatures[item0])
+	assert.Equal(t, []int32{0}, clickDataset.ItemFeatures[item1])
+	assert.Equal(t, []int32{0}, rankingDataset.UserLabels[user0])
+	assert.Empty(t, rankingDataset.ItemLabels[item1])
+	assert.Equal(t, int32(1), rankingDataset.NumUserLabels)
+}
+
+func TestMaster_LoadColdStartItems(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.Offline.ColdStartItemAge = 24 * time.Hour
+	m.Config().Recommend.Offline.ColdStartMaxImpressions = 1
+
+	// insert items
+	now := time.Now()
+	err := m.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "0", Timestamp: now.Add(-time.Hour), Categories: []string{"a"}},
+		{ItemId: "1", Timestamp: now.Add(-2 * time.Hour), Categories: []string{"a", "b"}},
+		{ItemId: "2", Timestamp: now.Add(-3 * time.Hour), Categories: []string{"b"}},
+		{ItemId: "3", Timestamp: now.Add(-48 * time.Hour), Categories: []string{"a"}},
+		{ItemId: "4", Timestamp: now.Add(-time.Hour), IsHidden: true},
+	})
+	assert.NoError(t, err)
+	// insert feedback
+	err = m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "positive", UserId: "0", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "1", ItemId: "2"}},
+	}, true, true, true)
+	assert.NoError(t, err)
+
+	// load cold-start items
+	_, _, _, _, coldStartItems, err := m.LoadDataFromDatabase(m.DataClient, []string{"positive"}, []string{"read"}, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"":  {"0", "1"},
+		"a": {"0", "1"},
+		"b": {"1"},
+	}, lo.MapValues(coldStartItems, func(items []cache.Scored, _ string) []string {
+		return cache.RemoveScores(items)
+	}))
+	assert.Equal(t, float64(now.Add(-time.Hour).Unix()), coldStartItems[""][0].Score)
+}
+
+func TestMaster_LoadObjectiveDataset(t *testing.T) {
+	// create mock master
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	// create config
+	m.SetConfig(&config.Config{})
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"click", "purchase"}
+	m.Config().Recommend.DataSource.ReadFeedbackTypes = []string{"read"}
+
+	// insert feedback
+	err := m.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "purchase", UserId: "0", ItemId: "0"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "1", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "purchase", UserId: "2", ItemId: "2"}},
+	}, true, true, true)
+	assert.NoError(t, err)
+
+	// load dataset of purchases
+	rankingDataset, clickDataset, _, _, _, err := m.LoadDataFromDatabase(m.DataClient,
+		m.Config().Recommend.DataSource.PositiveFeedbackTypes, m.Config().Recommend.DataSource.ReadFeedbackTypes, 0, 0, NewOnlineEvaluator(), nil)
+	assert.NoError(t, err)
+	dataset, err := m.loadObjectiveDataset(ctx, rankingDataset, clickDataset, config.ObjectiveConfig{
+		Name: "purchase", FeedbackTypes: []string{"purchase"}, Weight: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, clickDataset.Index, dataset.Index)
+	assert.Equal(t, 1, dataset.PositiveCount)
+	assert.Equal(t, 2, dataset.NegativeCount)
+	samples := make(map[lo.Tuple2[string, string]]float32)
+	for i := 0; i < dataset.Count(); i++ {
+		userId := dataset.Index.GetUsers()[dataset.Users.Get(i)]
+		itemId := dataset.Index.GetItems()[dataset.Items.Get(i)]
+		samples[lo.Tuple2[string, string]{A: userId, B: itemId}] = dataset.Target.Get(i)
+	}
+	assert.Equal(t, map[lo.Tuple2[string, string]]float32{
+		{A: "0", B: "0"}: 1,
+		{A: "0", B: "1"}: -1,
+		{A: "0", B: "2"}: -1,
+	}, samples)
+}
+
 func TestCheckItemNeighborCacheTimeout(t *testing.T) {
 	// create mock master
 	m := newMockMaster(t)
 	defer m.Close()
-	m.Config = config.GetDefaultConfig()
+	m.SetConfig(config.GetDefaultConfig())
 	ctx := context.Background()
 
 	// empty cache
@@ -672,7 +1077,7 @@ func TestCheckItemNeighborCacheTimeout(t *testing.T) {
 	assert.True(t, m.checkItemNeighborCacheTimeout("1", nil))
 
 	// staled cache
-	err = m.CacheClient.Set(ctx, cache.String(cache.Key(cache.ItemNeighborsDigest, "1"), m.Config.ItemNeighborDigest()))
+	err = m.CacheClient.Set(ctx, cache.String(cache.Key(cache.ItemNeighborsDigest, "1"), m.Config().ItemNeighborDigest()))
 	assert.NoError(t, err)
 	assert.True(t, m.checkItemNeighborCacheTimeout("1", nil))
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyItemTime, "1"), time.Now().Add(-time.Minute)))
@@ -693,7 +1098,7 @@ func TestCheckUserNeighborCacheTimeout(t *testing.T) {
 	m := newMockMaster(t)
 	defer m.Close()
 	ctx := context.Background()
-	m.Config = config.GetDefaultConfig()
+	m.SetConfig(config.GetDefaultConfig())
 
 	// empty cache
 	assert.True(t, m.checkUserNeighborCacheTimeout("1"))
@@ -710,7 +1115,7 @@ func TestCheckUserNeighborCacheTimeout(t *testing.T) {
 	assert.True(t, m.checkUserNeighborCacheTimeout("1"))
 
 	// staled cache
-	err = m.CacheClient.Set(ctx, cache.String(cache.Key(cache.UserNeighborsDigest, "1"), m.Config.UserNeighborDigest()))
+	err = m.CacheClient.Set(ctx, cache.String(cache.Key(cache.UserNeighborsDigest, "1"), m.Config().UserNeighborDigest()))
 	assert.NoError(t, err)
 	assert.True(t, m.checkUserNeighborCacheTimeout("1"))
 	err = m.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "1"), time.Now().Add(-time.Minute)))
@@ -730,7 +1135,7 @@ func TestRunCacheGarbageCollectionTask(t *testing.T) {
 	// create mock master
 	m := newMockMaster(t)
 	defer m.Close()
-	m.Config = config.GetDefaultConfig()
+	m.SetConfig(config.GetDefaultConfig())
 	ctx := context.Background()
 
 	// insert data
@@ -786,6 +1191,14 @@ func TestRunCacheGarbageCollectionTask(t *testing.T) {
 	assert.NoError(t, err)
 	err = m.CacheClient.SetSorted(ctx, cache.Key(cache.ItemNeighbors, "20"), []cache.Scored{{Id: "2", Score: 1}})
 	assert.NoError(t, err)
+	err = m.CacheClient.SetSorted(ctx, cache.Key(cache.FeedbackEvents, "play", "1", "10"), []cache.Scored{{Id: "1", Score: 1}})
+	assert.NoError(t, err)
+	err = m.CacheClient.SetSorted(ctx, cache.Key(cache.FeedbackEvents, "play", "2", "10"), []cache.Scored{{Id: "1", Score: 1}})
+	assert.NoError(t, err)
+	err = server.SaveSession(ctx, m.CacheClient, "active", server.Session{UpdateTime: timestamp})
+	assert.NoError(t, err)
+	err = server.SaveSession(ctx, m.CacheClient, "expired", server.Session{UpdateTime: timestamp.Add(-time.Hour)})
+	assert.NoError(t, err)
 
 	// remove cache
 	assert.NotNil(t, m.rankingTrainSet)
@@ -862,4 +1275,16 @@ func TestRunCacheGarbageCollectionTask(t *testing.T) {
 	sorted, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, "20"), 0, -1)
 	assert.NoError(t, err)
 	assert.Empty(t, sorted)
+
+	sorted, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.FeedbackEvents, "play", "1", "10"), 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, sorted, 1)
+	sorted, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.FeedbackEvents, "play", "2", "10"), 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, sorted)
+
+	_, err = m.CacheClient.Get(ctx, cache.Key(cache.Session, "active")).String()
+	assert.NoError(t, err)
+	_, err = m.CacheClient.Get(ctx, cache.Key(cache.Session, "expired")).String()
+	assert.True(t, errors.Is(err, errors.NotFound))
 }
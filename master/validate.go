@@ -0,0 +1,96 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// ValidateConfig validates the config file and checks whether the databases are reachable. An error is returned if the
+// config file fails to be read.
+func ValidateConfig(path string, oneModel bool) ([]config.ValidationIssue, error) {
+	cfg, issues, err := config.ValidateFile(path, oneModel)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !config.HasError(issues) {
+		issues = append(issues, checkDatabases(cfg)...)
+	}
+	return issues, nil
+}
+
+// checkDatabases connects to the data store, the target data store and the cache store and returns issues of
+// unreachable databases.
+func checkDatabases(cfg *config.Config) []config.ValidationIssue {
+	var issues []config.ValidationIssue
+	if dataClient, err := data.OpenWithReplica(cfg.Database.DataStore, cfg.Database.DataStoreReplica,
+		cfg.Database.DataTablePrefix); err != nil {
+		issues = append(issues, unreachable("database.data_store", err))
+	} else {
+		if err = dataClient.Ping(); err != nil {
+			issues = append(issues, unreachable("database.data_store", err))
+		}
+		_ = dataClient.Close()
+	}
+	if cfg.Database.DataStoreTarget != "" {
+		if targetClient, err := data.Open(cfg.Database.DataStoreTarget, cfg.Database.DataTablePrefix); err != nil {
+			issues = append(issues, unreachable("database.data_store_target", err))
+		} else {
+			if err = targetClient.Ping(); err != nil {
+				issues = append(issues, unreachable("database.data_store_target", err))
+			}
+			_ = targetClient.Close()
+		}
+	}
+	if cacheClient, err := cache.Open(cfg.Database.CacheStore, cfg.Database.CachePrefix()); err != nil {
+		issues = append(issues, unreachable("database.cache_store", err))
+	} else {
+		if err = cacheClient.Ping(); err != nil {
+			issues = append(issues, unreachable("database.cache_store", err))
+		}
+		_ = cacheClient.Close()
+	}
+	return issues
+}
+
+func unreachable(key string, err error) config.ValidationIssue {
+	return config.ValidationIssue{Level: config.LevelError, Key: key, Message: "unreachable database: " + err.Error()}
+}
+
+// validateConfig validates the config file if it exists, or the running config otherwise.
+func (m *Master) validateConfig(_ *restful.Request, response *restful.Response) {
+	var (
+		issues []config.ValidationIssue
+		err    error
+	)
+	if m.configFile != "" {
+		issues, err = ValidateConfig(m.configFile, m.oneModel)
+	} else if issues, err = m.Config().Check(m.oneModel); err == nil && !config.HasError(issues) {
+		issues = append(issues, checkDatabases(m.Config())...)
+	}
+	if err != nil {
+		server.InternalServerError(response, err)
+		return
+	}
+	if issues == nil {
+		issues = []config.ValidationIssue{}
+	}
+	server.Ok(response, issues)
+}
@@ -0,0 +1,173 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/heap"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+)
+
+// cacheEmpty returns true if the cache store is empty. The time of the last update of latest items is written by every
+// load of the dataset, so that its absence means the cache store has been lost or never been filled.
+func (m *Master) cacheEmpty(ctx context.Context) (bool, error) {
+	_, err := m.CacheClient.Get(ctx, cache.Key(cache.GlobalMeta, cache.LastUpdateLatestItemsTime)).Time()
+	if errors.Is(err, errors.NotFound) {
+		return true, nil
+	} else if err != nil {
+		return false, errors.Trace(err)
+	}
+	return false, nil
+}
+
+// warmUpCache rebuilds latest items, popular items and categories in the cache store from the data store. Unlike
+// loading the dataset, users and negative feedback are not pulled, so that recommendations are available again soon.
+func (m *Master) warmUpCache(ctx context.Context) error {
+	startTime := time.Now()
+	dataSource := m.Config().Recommend.DataSource
+	cacheSize := m.Config().Recommend.CacheSize
+	var itemTimeLimit, feedbackTimeLimit *time.Time
+	if dataSource.ItemTTL > 0 {
+		temp := time.Now().AddDate(0, 0, -int(dataSource.ItemTTL))
+		itemTimeLimit = &temp
+	}
+	if dataSource.PositiveFeedbackTTL > 0 {
+		temp := time.Now().AddDate(0, 0, -int(dataSource.PositiveFeedbackTTL))
+		feedbackTimeLimit = &temp
+	}
+	if m.Config().Recommend.Popular.PopularWindow > 0 {
+		temp := time.Now().Add(-m.Config().Recommend.Popular.PopularWindow)
+		if feedbackTimeLimit == nil || temp.After(*feedbackTimeLimit) {
+			feedbackTimeLimit = &temp
+		}
+	}
+
+	// pull items for latest items and categories, categories of items are rolled up into ancestors
+	tree, err := server.LoadCategories(ctx, m.CacheClient)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	latestItems := map[string]*heap.TopKFilter[string, float64]{"": heap.NewTopKFilter[string, float64](cacheSize)}
+	itemCategories := make(map[string][]string)
+	categories := strset.New()
+	itemChan, errChan := m.DataClient.GetItemStream(ctx, batchSize, itemTimeLimit)
+	for items := range itemChan {
+		for _, item := range items {
+			expanded := tree.Expand(item.Categories)
+			categories.Add(expanded...)
+			if item.IsHidden {
+				continue
+			}
+			itemCategories[item.ItemId] = expanded
+			if !item.Timestamp.IsZero() {
+				for _, category := range append([]string{""}, expanded...) {
+					if _, exist := latestItems[category]; !exist {
+						latestItems[category] = heap.NewTopKFilter[string, float64](cacheSize)
+					}
+					latestItems[category].Push(item.ItemId, float64(item.Timestamp.Unix()))
+				}
+			}
+		}
+	}
+	if err := <-errChan; err != nil {
+		return errors.Trace(err)
+	}
+
+	// pull positive feedback in the popular window for popular items
+	countedTypes := lo.Map(dataSource.PositiveThresholds, func(threshold config.FeedbackThreshold, _ int) string {
+		return threshold.FeedbackType
+	})
+	popularCount := make(map[string]int)
+	feedbackChan, errChan := m.DataClient.GetFeedbackStream(ctx, batchSize, feedbackTimeLimit, m.Config().Now(),
+		append(append([]string{}, dataSource.PositiveFeedbackTypes...), countedTypes...)...)
+	for feedback := range feedbackChan {
+		for _, f := range feedback {
+			if threshold, isCounted := dataSource.PositiveThreshold(f.FeedbackType); isCounted &&
+				!threshold.Reached(server.ParseEventsComment(f).Events, time.Now()) {
+				continue
+			}
+			if _, exist := itemCategories[f.ItemId]; exist {
+				popularCount[f.ItemId]++
+			}
+		}
+	}
+	if err := <-errChan; err != nil {
+		return errors.Trace(err)
+	}
+	popularItems := map[string]*heap.TopKFilter[string, float64]{"": heap.NewTopKFilter[string, float64](cacheSize)}
+	for itemId, count := range popularCount {
+		for _, category := range append([]string{""}, itemCategories[itemId]...) {
+			if _, exist := popularItems[category]; !exist {
+				popularItems[category] = heap.NewTopKFilter[string, float64](cacheSize)
+			}
+			popularItems[category].Push(itemId, float64(count))
+		}
+	}
+
+	// write to cache
+	for category, filter := range latestItems {
+		items, scores := filter.PopAll()
+		if err := m.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems, category), cache.CreateScoredItems(items, scores)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for category, filter := range popularItems {
+		items, scores := filter.PopAll()
+		if err := m.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems, category), cache.CreateScoredItems(items, scores)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := m.CacheClient.SetSet(ctx, cache.ItemCategories, categories.List()...); err != nil {
+		return errors.Trace(err)
+	}
+	if err := m.CacheClient.Set(ctx,
+		cache.Time(cache.Key(cache.GlobalMeta, cache.LastUpdatePopularItemsTime), time.Now()),
+		cache.Time(cache.Key(cache.GlobalMeta, cache.LastUpdateLatestItemsTime), time.Now())); err != nil {
+		return errors.Trace(err)
+	}
+	log.Logger().Info("warm up cache store",
+		zap.Int("n_items", len(itemCategories)),
+		zap.Int("n_categories", categories.Size()),
+		zap.Duration("used_time", time.Since(startTime)))
+	return nil
+}
+
+// RunCacheWarmupLoop checks whether the cache store is empty periodically, and warms up the cache store if it is empty.
+func (m *Master) RunCacheWarmupLoop() {
+	defer base.CheckPanic()
+	ticker := time.NewTicker(m.Config().Master.CacheWarmupPeriod)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		ctx := context.Background()
+		if empty, err := m.cacheEmpty(ctx); err != nil {
+			log.Logger().Error("failed to check whether cache store is empty", zap.Error(err))
+		} else if empty {
+			if err = m.warmUpCache(ctx); err != nil {
+				log.Logger().Error("failed to warm up cache store", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/server"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+func TestMaster_WarmUpCache(t *testing.T) {
+	m := newMockMaster(t)
+	defer m.Close()
+	ctx := context.Background()
+	m.SetConfig(config.GetDefaultConfig())
+	m.Config().Recommend.CacheSize = 3
+	m.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"click"}
+
+	// item i has i clicks, item 9 is hidden
+	now := time.Now()
+	var feedback []data.Feedback
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.DataClient.BatchInsertItems(ctx, []data.Item{{
+			ItemId:     strconv.Itoa(i),
+			IsHidden:   i == 9,
+			Categories: []string{strconv.Itoa(i % 2)},
+			Timestamp:  now.Add(time.Duration(i) * time.Minute),
+		}}))
+		for j := 0; j < i; j++ {
+			feedback = append(feedback, data.Feedback{
+				FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: strconv.Itoa(j), ItemId: strconv.Itoa(i)},
+				Timestamp:   now,
+			})
+		}
+	}
+	assert.NoError(t, m.DataClient.BatchInsertFeedback(ctx, feedback, true, false, false))
+	// categories are rolled up into the parent
+	assert.NoError(t, server.SaveCategories(ctx, m.CacheClient, []server.Category{
+		{Name: "all"}, {Name: "0", Parent: "all"}, {Name: "1", Parent: "all"},
+	}))
+
+	empty, err := m.cacheEmpty(ctx)
+	assert.NoError(t, err)
+	assert.True(t, empty)
+	assert.NoError(t, m.warmUpCache(ctx))
+	empty, err = m.cacheEmpty(ctx)
+	assert.NoError(t, err)
+	assert.False(t, empty)
+
+	// latest items
+	latest, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.LatestItems, ""), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8", "7", "6"}, cache.RemoveScores(latest))
+	latest, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.LatestItems, "1"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"7", "5", "3"}, cache.RemoveScores(latest))
+	// popular items
+	popular, err := m.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, ""), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8", "7", "6"}, cache.RemoveScores(popular))
+	assert.Equal(t, float64(8), popular[0].Score)
+	popular, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, "0"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8", "6", "4"}, cache.RemoveScores(popular))
+	popular, err = m.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, "all"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"8", "7", "6"}, cache.RemoveScores(popular))
+	// categories
+	categories, err := m.CacheClient.GetSet(ctx, cache.ItemCategories)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0", "1", "all"}, categories)
+}
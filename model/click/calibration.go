@@ -0,0 +1,257 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package click
+
+import (
+	"math"
+	"sort"
+
+	"github.com/juju/errors"
+)
+
+// CalibrationMethod is the method to calibrate scores of a click model to click probabilities.
+type CalibrationMethod string
+
+const (
+	NoCalibration      CalibrationMethod = "none"
+	PlattScaling       CalibrationMethod = "platt"
+	IsotonicRegression CalibrationMethod = "isotonic"
+)
+
+// Calibration maps raw scores of a click model to click probabilities. Scores are mapped by the sigmoid function if
+// not calibrated.
+type Calibration struct {
+	Method CalibrationMethod
+	// Platt scaling: p = sigmoid(A * score + B)
+	A float64
+	B float64
+	// Isotonic regression: probabilities are interpolated between non-decreasing steps
+	Scores        []float64
+	Probabilities []float64
+}
+
+// Calibrate maps a raw score to a click probability.
+func (c Calibration) Calibrate(score float32) float64 {
+	x := float64(score)
+	switch c.Method {
+	case PlattScaling:
+		return sigmoid(c.A*x + c.B)
+	case IsotonicRegression:
+		if len(c.Scores) == 0 {
+			return sigmoid(x)
+		}
+		i := sort.SearchFloat64s(c.Scores, x)
+		if i == 0 {
+			return c.Probabilities[0]
+		} else if i == len(c.Scores) {
+			return c.Probabilities[len(c.Probabilities)-1]
+		}
+		ratio := (x - c.Scores[i-1]) / (c.Scores[i] - c.Scores[i-1])
+		return c.Probabilities[i-1] + ratio*(c.Probabilities[i]-c.Probabilities[i-1])
+	default:
+		return sigmoid(x)
+	}
+}
+
+// Calibrated returns true if scores are calibrated by Platt scaling or isotonic regression.
+func (c Calibration) Calibrated() bool {
+	return c.Method == PlattScaling || c.Method == IsotonicRegression
+}
+
+// FitCalibration fits the calibration of a click model on a validation set, which should not be used to train the
+// model.
+func FitCalibration(method CalibrationMethod, m FactorizationMachine, validSet *Dataset) (Calibration, error) {
+	scores := make([]float64, validSet.Count())
+	labels := make([]bool, validSet.Count())
+	for i := 0; i < validSet.Count(); i++ {
+		features, values, target := validSet.Get(i)
+		scores[i] = float64(m.InternalPredict(features, values))
+		labels[i] = target > 0
+	}
+	switch method {
+	case NoCalibration, "":
+		return Calibration{Method: NoCalibration}, nil
+	case PlattScaling:
+		a, b := fitPlatt(scores, labels)
+		return Calibration{Method: PlattScaling, A: a, B: b}, nil
+	case IsotonicRegression:
+		xs, ys := fitIsotonic(scores, labels)
+		return Calibration{Method: IsotonicRegression, Scores: xs, Probabilities: ys}, nil
+	default:
+		return Calibration{}, errors.NotSupportedf("calibration method %s", method)
+	}
+}
+
+// LogLoss returns the average negative log-likelihood of labels of a validation set under the calibration.
+func (c Calibration) LogLoss(m FactorizationMachine, validSet *Dataset) float64 {
+	if validSet.Count() == 0 {
+		return 0
+	}
+	const eps = 1e-15
+	var loss float64
+	for i := 0; i < validSet.Count(); i++ {
+		features, values, target := validSet.Get(i)
+		p := math.Min(math.Max(c.Calibrate(m.InternalPredict(features, values)), eps), 1-eps)
+		if target > 0 {
+			loss -= math.Log(p)
+		} else {
+			loss -= math.Log(1 - p)
+		}
+	}
+	return loss / float64(validSet.Count())
+}
+
+// fitPlatt fits Platt scaling by Newton's method with backtracking line search, which follows "A note on Platt's
+// probabilistic outputs for support vector machines" by Lin et al. Targets are smoothed to avoid overfitting.
+func fitPlatt(scores []float64, labels []bool) (float64, float64) {
+	var numPos, numNeg float64
+	for _, label := range labels {
+		if label {
+			numPos++
+		} else {
+			numNeg++
+		}
+	}
+	hiTarget, loTarget := (numPos+1)/(numPos+2), 1/(numNeg+2)
+	targets := make([]float64, len(labels))
+	for i, label := range labels {
+		if label {
+			targets[i] = hiTarget
+		} else {
+			targets[i] = loTarget
+		}
+	}
+	// f(a, b) = -sum(t * log(p) + (1 - t) * log(1 - p)), p = sigmoid(a * x + b)
+	objective := func(a, b float64) float64 {
+		var f float64
+		for i, x := range scores {
+			z := a*x + b
+			// avoid overflow of exp(z)
+			if z >= 0 {
+				f += (1-targets[i])*z + math.Log1p(math.Exp(-z))
+			} else {
+				f += targets[i]*(-z) + math.Log1p(math.Exp(z))
+			}
+		}
+		return f
+	}
+	a, b := 1.0, 0.0 // raw scores of factorization machines are logits
+	const (
+		maxIter = 100
+		minStep = 1e-10
+		sigma   = 1e-12 // ensure the Hessian is positive definite
+		eps     = 1e-5
+	)
+	f := objective(a, b)
+	for iter := 0; iter < maxIter; iter++ {
+		// gradient and Hessian
+		h11, h22, h21, g1, g2 := sigma, sigma, 0.0, 0.0, 0.0
+		for i, x := range scores {
+			p := sigmoid(a*x + b)
+			d1 := p - targets[i]
+			d2 := p * (1 - p)
+			h11 += x * x * d2
+			h22 += d2
+			h21 += x * d2
+			g1 += x * d1
+			g2 += d1
+		}
+		if math.Abs(g1) < eps && math.Abs(g2) < eps {
+			break
+		}
+		// Newton direction
+		det := h11*h22 - h21*h21
+		da := -(h22*g1 - h21*g2) / det
+		db := -(-h21*g1 + h11*g2) / det
+		gd := g1*da + g2*db
+		step := 1.0
+		for step >= minStep {
+			newA, newB := a+step*da, b+step*db
+			if newF := objective(newA, newB); newF < f+1e-4*step*gd {
+				a, b, f = newA, newB, newF
+				break
+			}
+			step /= 2
+		}
+		if step < minStep {
+			break
+		}
+	}
+	return a, b
+}
+
+// fitIsotonic fits isotonic regression by the pool adjacent violators algorithm. Steps are represented by mean scores
+// and click rates of pooled blocks.
+func fitIsotonic(scores []float64, labels []bool) ([]float64, []float64) {
+	indices := make([]int, len(scores))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return scores[indices[i]] < scores[indices[j]]
+	})
+	type block struct {
+		sumScore float64
+		sumLabel float64
+		weight   float64
+	}
+	var blocks []block
+	for _, i := range indices {
+		b := block{sumScore: scores[i], weight: 1}
+		if labels[i] {
+			b.sumLabel = 1
+		}
+		blocks = append(blocks, b)
+		// pool adjacent violators
+		for len(blocks) > 1 {
+			last, prev := blocks[len(blocks)-1], blocks[len(blocks)-2]
+			if prev.sumLabel/prev.weight < last.sumLabel/last.weight {
+				break
+			}
+			blocks = blocks[:len(blocks)-2]
+			blocks = append(blocks, block{
+				sumScore: prev.sumScore + last.sumScore,
+				sumLabel: prev.sumLabel + last.sumLabel,
+				weight:   prev.weight + last.weight,
+			})
+		}
+	}
+	xs := make([]float64, len(blocks))
+	ys := make([]float64, len(blocks))
+	for i, b := range blocks {
+		xs[i] = b.sumScore / b.weight
+		ys[i] = b.sumLabel / b.weight
+	}
+	return xs, ys
+}
+
+// Probability returns the click probability of a raw score predicted by a click model. The score is calibrated if the
+// model has been calibrated.
+func Probability(m FactorizationMachine, score float32) float64 {
+	if fm, ok := m.(*FM); ok {
+		return fm.Calibration.Calibrate(score)
+	}
+	return sigmoid(float64(score))
+}
+
+// IsCalibrated returns true if a click model has been calibrated.
+func IsCalibrated(m FactorizationMachine) bool {
+	fm, ok := m.(*FM)
+	return ok && fm.Calibration.Calibrated()
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package click
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockFactorizationMachineForCalibration predicts the first context value as the raw score.
+type mockFactorizationMachineForCalibration struct {
+	mockFactorizationMachineForSearch
+}
+
+func (m *mockFactorizationMachineForCalibration) InternalPredict(_ []int32, values []float32) float32 {
+	return values[0]
+}
+
+// newCalibrationDataset creates a dataset whose click probabilities are sigmoid(2 * score - 1).
+func newCalibrationDataset(n int) *Dataset {
+	rng := rand.New(rand.NewSource(0))
+	dataset := NewMapIndexDataset()
+	for i := 0; i < n; i++ {
+		score := rng.Float64()*6 - 3
+		dataset.CtxFeatures = append(dataset.CtxFeatures, []int32{0})
+		dataset.CtxValues = append(dataset.CtxValues, []float32{float32(score)})
+		if rng.Float64() < sigmoid(2*score-1) {
+			dataset.Target.Append(1)
+			dataset.PositiveCount++
+		} else {
+			dataset.Target.Append(-1)
+			dataset.NegativeCount++
+		}
+	}
+	return dataset
+}
+
+func TestPlattScaling(t *testing.T) {
+	m := new(mockFactorizationMachineForCalibration)
+	dataset := newCalibrationDataset(10000)
+	calibration, err := FitCalibration(PlattScaling, m, dataset)
+	assert.NoError(t, err)
+	assert.True(t, calibration.Calibrated())
+	assert.InDelta(t, 2, calibration.A, 0.2)
+	assert.InDelta(t, -1, calibration.B, 0.2)
+	assert.InDelta(t, sigmoid(-1), calibration.Calibrate(0), 0.05)
+	assert.Less(t, calibration.LogLoss(m, dataset), Calibration{}.LogLoss(m, dataset))
+}
+
+func TestIsotonicRegression(t *testing.T) {
+	m := new(mockFactorizationMachineForCalibration)
+	dataset := newCalibrationDataset(10000)
+	calibration, err := FitCalibration(IsotonicRegression, m, dataset)
+	assert.NoError(t, err)
+	assert.True(t, calibration.Calibrated())
+	assert.Equal(t, len(calibration.Scores), len(calibration.Probabilities))
+	for i := 1; i < len(calibration.Scores); i++ {
+		assert.Less(t, calibration.Scores[i-1], calibration.Scores[i])
+		assert.LessOrEqual(t, calibration.Probabilities[i-1], calibration.Probabilities[i])
+	}
+	// probabilities are interpolated between steps and clipped outside
+	assert.Equal(t, calibration.Probabilities[0], calibration.Calibrate(-10))
+	assert.Equal(t, calibration.Probabilities[len(calibration.Probabilities)-1], calibration.Calibrate(10))
+	assert.InDelta(t, sigmoid(-1), calibration.Calibrate(0), 0.1)
+	assert.Less(t, calibration.LogLoss(m, dataset), Calibration{}.LogLoss(m, dataset))
+
+	// pool adjacent violators
+	scores, probabilities := fitIsotonic([]float64{1, 2, 3, 4}, []bool{false, true, false, true})
+	assert.Equal(t, []float64{1, 2.5, 4}, scores)
+	assert.Equal(t, []float64{0, 0.5, 1}, probabilities)
+}
+
+func TestNoCalibration(t *testing.T) {
+	m := new(mockFactorizationMachineForCalibration)
+	calibration, err := FitCalibration(NoCalibration, m, newCalibrationDataset(10))
+	assert.NoError(t, err)
+	assert.False(t, calibration.Calibrated())
+	assert.Equal(t, 0.5, calibration.Calibrate(0))
+	assert.Equal(t, 0.5, Probability(m, 0))
+	assert.False(t, IsCalibrated(m))
+	_, err = FitCalibration("unknown", m, newCalibrationDataset(10))
+	assert.Error(t, err)
+
+	fm := NewFM(FMClassification, nil)
+	fm.Calibration = Calibration{Method: PlattScaling, A: 2, B: -1}
+	assert.True(t, IsCalibrated(fm))
+	assert.InDelta(t, 1/(1+math.Exp(1)), Probability(fm, 0), 1e-9)
+}
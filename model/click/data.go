@@ -16,6 +16,8 @@ package click
 
 import (
 	"bufio"
+	"fmt"
+	"github.com/chewxy/math32"
 	"github.com/juju/errors"
 	"github.com/scylladb/go-set"
 	"github.com/zhenghaoz/gorse/base"
@@ -24,6 +26,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Dataset for click-through-rate models.
@@ -39,6 +42,7 @@ type Dataset struct {
 	CtxValues   [][]float32
 	NormValues  base.Array[float32]
 	Target      base.Array[float32]
+	Weights     base.Array[float32] // sample weights, all samples are weighted 1 if empty
 
 	PositiveCount int
 	NegativeCount int
@@ -61,6 +65,7 @@ func (dataset *Dataset) Bytes() int {
 	bytes += uintptr(dataset.Items.Bytes())
 	bytes += uintptr(dataset.NormValues.Bytes())
 	bytes += uintptr(dataset.Target.Bytes())
+	bytes += uintptr(dataset.Weights.Bytes())
 	return int(bytes)
 }
 
@@ -81,9 +86,20 @@ func (dataset *Dataset) Count() int {
 	if dataset.CtxFeatures != nil && len(dataset.CtxFeatures) != dataset.Target.Len() {
 		panic("len(dataset.CtxFeatures) != len(dataset.Target)")
 	}
+	if dataset.Weights.Len() > 0 && dataset.Weights.Len() != dataset.Target.Len() {
+		panic("dataset.Weights.Len() != dataset.Target.Len()")
+	}
 	return dataset.Target.Len()
 }
 
+// GetWeight returns the weight of the i-th sample.
+func (dataset *Dataset) GetWeight(i int) float32 {
+	if dataset.Weights.Len() == 0 {
+		return 1
+	}
+	return dataset.Weights.Get(i)
+}
+
 // Get returns the i-th sample.
 func (dataset *Dataset) Get(i int) ([]int32, []float32, float32) {
 	var features []int32
@@ -126,6 +142,12 @@ func (dataset *Dataset) Get(i int) ([]int32, []float32, float32) {
 	return features, values, dataset.Target.Get(i)
 }
 
+// TimeContextLabels returns context labels of an hour and a weekday, which are used by click models to learn
+// time-dependent preferences.
+func TimeContextLabels(hour int, weekday time.Weekday) []string {
+	return []string{fmt.Sprintf("hour:%d", hour), fmt.Sprintf("weekday:%d", weekday)}
+}
+
 // LoadLibFMFile loads libFM format file.
 func LoadLibFMFile(path string) (features [][]int32, values [][]float32, targets base.Array[float32], maxLabel int32, err error) {
 	// open file
@@ -227,6 +249,9 @@ func (dataset *Dataset) Split(ratio float32, seed int64) (*Dataset, *Dataset) {
 			}
 			testSet.NormValues.Append(dataset.NormValues.Get(i))
 			testSet.Target.Append(dataset.Target.Get(i))
+			if dataset.Weights.Len() > 0 {
+				testSet.Weights.Append(dataset.Weights.Get(i))
+			}
 			if dataset.Target.Get(i) > 0 {
 				testSet.PositiveCount++
 			} else {
@@ -244,6 +269,9 @@ func (dataset *Dataset) Split(ratio float32, seed int64) (*Dataset, *Dataset) {
 			}
 			trainSet.NormValues.Append(dataset.NormValues.Get(i))
 			trainSet.Target.Append(dataset.Target.Get(i))
+			if dataset.Weights.Len() > 0 {
+				trainSet.Weights.Append(dataset.Weights.Get(i))
+			}
 			if dataset.Target.Get(i) > 0 {
 				trainSet.PositiveCount++
 			} else {
@@ -253,3 +281,32 @@ func (dataset *Dataset) Split(ratio float32, seed int64) (*Dataset, *Dataset) {
 	}
 	return trainSet, testSet
 }
+
+// MinPropensity is the lower bound of estimated propensities, which limits weights of samples to 1 / MinPropensity.
+const MinPropensity = 0.1
+
+// EstimatePropensity estimates examination propensities of positions by click-through rates relative to the first
+// position. Propensities are clipped to [MinPropensity, 1] and non-increasing with positions. Positions without
+// impressions inherit the propensity of the previous position.
+func EstimatePropensity(impressions, clicks []int) []float32 {
+	propensity := make([]float32, len(impressions))
+	if len(impressions) == 0 {
+		return propensity
+	}
+	propensity[0] = 1
+	if impressions[0] == 0 || clicks[0] == 0 {
+		for i := range propensity {
+			propensity[i] = 1
+		}
+		return propensity
+	}
+	topRate := float32(clicks[0]) / float32(impressions[0])
+	for i := 1; i < len(impressions); i++ {
+		propensity[i] = propensity[i-1]
+		if impressions[i] > 0 {
+			rate := float32(clicks[i]) / float32(impressions[i]) / topRate
+			propensity[i] = math32.Max(MinPropensity, math32.Min(propensity[i-1], rate))
+		}
+	}
+	return propensity
+}
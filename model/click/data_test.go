@@ -53,6 +53,7 @@ func TestDataset_Split(t *testing.T) {
 				dataset.CtxValues = append(dataset.CtxValues, []float32{float32(i + j)})
 				dataset.NormValues.Append(1.5)
 				dataset.Target.Append(1)
+				dataset.Weights.Append(2)
 				dataset.PositiveCount++
 			} else {
 				dataset.Users.Append(int32(i))
@@ -61,6 +62,7 @@ func TestDataset_Split(t *testing.T) {
 				dataset.CtxValues = append(dataset.CtxValues, []float32{float32(i + j)})
 				dataset.NormValues.Append(1.5)
 				dataset.Target.Append(-1)
+				dataset.Weights.Append(1)
 				dataset.NegativeCount++
 			}
 		}
@@ -86,6 +88,7 @@ func TestDataset_Split(t *testing.T) {
 	}, features)
 	assert.Equal(t, []float32{1, 1, 1.5, 1.5, 1.5, 1.5, 1.5, 2}, values)
 	assert.Equal(t, float32(-1), target)
+	assert.Equal(t, float32(1), dataset.GetWeight(2))
 
 	// split
 	train, test := dataset.Split(0.2, 0)
@@ -99,4 +102,19 @@ func TestDataset_Split(t *testing.T) {
 	assert.Equal(t, 6, test.Count())
 	assert.Equal(t, 3, test.PositiveCount)
 	assert.Equal(t, 3, test.NegativeCount)
+	for i := 0; i < train.Count(); i++ {
+		_, _, target = train.Get(i)
+		assert.Equal(t, (target+3)/2, train.GetWeight(i))
+	}
+}
+
+func TestEstimatePropensity(t *testing.T) {
+	assert.Empty(t, EstimatePropensity(nil, nil))
+	// no clicks on the first position
+	assert.Equal(t, []float32{1, 1, 1}, EstimatePropensity([]int{10, 10, 10}, []int{0, 5, 5}))
+	// clip and inherit propensities
+	assert.Equal(t, []float32{1, 0.5, 0.5, 0.25, 0.1},
+		EstimatePropensity([]int{100, 100, 0, 100, 100}, []int{40, 20, 0, 10, 1}))
+	// non-increasing propensities
+	assert.Equal(t, []float32{1, 0.5, 0.5}, EstimatePropensity([]int{10, 10, 10}, []int{4, 2, 3}))
 }
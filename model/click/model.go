@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/chewxy/math32"
@@ -93,8 +94,9 @@ func (score Score) BetterThan(s Score) bool {
 
 type FitConfig struct {
 	*task.JobsAllocator
-	Verbose int
-	Task    *task.Task
+	Verbose         int
+	Task            *task.Task
+	WarmStartEpochs int // number of epochs to fit a valid model from its parameters, zero for full epochs
 }
 
 func NewFitConfig() *FitConfig {
@@ -118,6 +120,20 @@ func (config *FitConfig) SetTask(t *task.Task) *FitConfig {
 	return config
 }
 
+func (config *FitConfig) SetWarmStartEpochs(epochs int) *FitConfig {
+	config.WarmStartEpochs = epochs
+	return config
+}
+
+// Epochs returns the number of epochs to fit a model. A valid model is warm-started and fitted by at most
+// WarmStartEpochs epochs.
+func (config *FitConfig) Epochs(m FactorizationMachine, nEpochs int) int {
+	if config.WarmStartEpochs > 0 && !m.Invalid() && config.WarmStartEpochs < nEpochs {
+		return config.WarmStartEpochs
+	}
+	return nEpochs
+}
+
 func (config *FitConfig) LoadDefaultIfNil() *FitConfig {
 	if config == nil {
 		return NewFitConfig()
@@ -128,6 +144,7 @@ func (config *FitConfig) LoadDefaultIfNil() *FitConfig {
 type FactorizationMachine interface {
 	model.Model
 	Predict(userId, itemId string, userLabels, itemLabels []string) float32
+	PredictWithContext(userId, itemId string, userLabels, itemLabels, ctxLabels []string) float32
 	InternalPredict(x []int32, values []float32) float32
 	Fit(trainSet *Dataset, testSet *Dataset, config *FitConfig) Score
 	Marshal(w io.Writer) error
@@ -160,6 +177,8 @@ type FM struct {
 	MinTarget float32
 	MaxTarget float32
 	Task      FMTask
+	// Calibration of scores, which is fitted after training
+	Calibration Calibration
 	// Hyper parameters
 	nFactors   int
 	nEpochs    int
@@ -198,6 +217,11 @@ func (fm *FM) SetParams(params model.Params) {
 }
 
 func (fm *FM) Predict(userId, itemId string, userLabels, itemLabels []string) float32 {
+	return fm.PredictWithContext(userId, itemId, userLabels, itemLabels, nil)
+}
+
+// PredictWithContext predicts the score of an item for a user in the context. Context labels are not normalized.
+func (fm *FM) PredictWithContext(userId, itemId string, userLabels, itemLabels, ctxLabels []string) float32 {
 	var features []int32
 	var values []float32
 	// encode user
@@ -226,6 +250,13 @@ func (fm *FM) Predict(userId, itemId string, userLabels, itemLabels []string) fl
 			values = append(values, 1/norm)
 		}
 	}
+	// encode context labels
+	for _, ctxLabel := range ctxLabels {
+		if ctxLabelIndex := fm.Index.EncodeContextLabel(ctxLabel); ctxLabelIndex != base.NotId {
+			features = append(features, ctxLabelIndex)
+			values = append(values, 1)
+		}
+	}
 	return fm.InternalPredict(features, values)
 }
 
@@ -279,7 +310,9 @@ func (fm *FM) Fit(trainSet, testSet *Dataset, config *FitConfig) Score {
 		zap.String("task", string(fm.Task)),
 		zap.Any("params", fm.GetParams()),
 		zap.Any("config", config))
+	nEpochs := config.Epochs(fm, fm.nEpochs)
 	fm.Init(trainSet)
+	fm.Calibration = Calibration{}
 	maxJobs := config.MaxJobs()
 	temp := base.NewMatrix32(maxJobs, fm.nFactors)
 	vGrad := base.NewMatrix32(maxJobs, fm.nFactors)
@@ -297,10 +330,13 @@ func (fm *FM) Fit(trainSet, testSet *Dataset, config *FitConfig) Score {
 	}
 	evalTime := time.Since(evalStart)
 	fields := append([]zap.Field{zap.String("eval_time", evalTime.String())}, score.ZapFields()...)
-	log.Logger().Debug(fmt.Sprintf("fit fm %v/%v", 0, fm.nEpochs), fields...)
+	log.Logger().Debug(fmt.Sprintf("fit fm %v/%v", 0, nEpochs), fields...)
 	snapshots.AddSnapshot(score, fm.V, fm.W, fm.B)
 
-	for epoch := 1; epoch <= fm.nEpochs; epoch++ {
+	for epoch := 1; epoch <= nEpochs; epoch++ {
+		if config.Task.IsCancelled() {
+			break
+		}
 		for i := 0; i < trainSet.Target.Len(); i++ {
 			fm.MinTarget = math32.Min(fm.MinTarget, trainSet.Target.Get(i))
 			fm.MaxTarget = math32.Max(fm.MaxTarget, trainSet.Target.Get(i))
@@ -310,19 +346,21 @@ func (fm *FM) Fit(trainSet, testSet *Dataset, config *FitConfig) Score {
 		_ = parallel.BatchParallel(trainSet.Count(), config.AvailableJobs(config.Task), 128, func(workerId, beginJobId, endJobId int) error {
 			for i := beginJobId; i < endJobId; i++ {
 				features, values, target := trainSet.Get(i)
+				weight := trainSet.GetWeight(i)
 				prediction := fm.internalPredictImpl(features, values)
 				var grad float32
 				switch fm.Task {
 				case FMRegression:
 					grad = prediction - target
-					cost += grad * grad / 2
+					cost += weight * grad * grad / 2
 				case FMClassification:
 					grad = -target * (1 - 1/(1+math32.Exp(-target*prediction)))
-					cost += (1 + target) * math32.Log(1+math32.Exp(-prediction)) / 2
-					cost += (1 - target) * math32.Log(1+math32.Exp(prediction)) / 2
+					cost += weight * (1 + target) * math32.Log(1+math32.Exp(-prediction)) / 2
+					cost += weight * (1 - target) * math32.Log(1+math32.Exp(prediction)) / 2
 				default:
 					log.Logger().Fatal("unknown task", zap.String("task", string(fm.Task)))
 				}
+				grad *= weight
 				// \sum^n_{j=1}v_j,fx_j
 				floats.Zero(temp[workerId])
 				for it, j := range features {
@@ -345,7 +383,7 @@ func (fm *FM) Fit(trainSet, testSet *Dataset, config *FitConfig) Score {
 		})
 		fitTime := time.Since(fitStart)
 		// Cross validation
-		if epoch%config.Verbose == 0 || epoch == fm.nEpochs {
+		if epoch%config.Verbose == 0 || epoch == nEpochs {
 			evalStart = time.Now()
 			switch fm.Task {
 			case FMRegression:
@@ -361,7 +399,7 @@ func (fm *FM) Fit(trainSet, testSet *Dataset, config *FitConfig) Score {
 				zap.String("eval_time", evalTime.String()),
 				zap.Float32("loss", cost),
 			}, score.ZapFields()...)
-			log.Logger().Debug(fmt.Sprintf("fit fm %v/%v", epoch, fm.nEpochs), fields...)
+			log.Logger().Debug(fmt.Sprintf("fit fm %v/%v", epoch, nEpochs), fields...)
 			// check NaN
 			if math32.IsNaN(cost) || math32.IsNaN(score.GetValue()) {
 				log.Logger().Warn("model diverged", zap.Float32("lr", fm.lr))
@@ -482,6 +520,48 @@ func UnmarshalModel(r io.Reader) (FactorizationMachine, error) {
 	return &fm, nil
 }
 
+// MarshalObjectives marshals click models of objectives into byte stream in order of names.
+func MarshalObjectives(w io.Writer, objectives map[string]FactorizationMachine) error {
+	err := binary.Write(w, binary.LittleEndian, int64(len(objectives)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	names := lo.Keys(objectives)
+	sort.Strings(names)
+	for _, name := range names {
+		if err = encoding.WriteString(w, name); err != nil {
+			return errors.Trace(err)
+		}
+		if err = MarshalModel(w, objectives[name]); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// UnmarshalObjectives unmarshal click models of objectives from byte stream. Nil is returned if the stream ends before
+// objectives, which is written without objectives.
+func UnmarshalObjectives(r io.Reader) (map[string]FactorizationMachine, error) {
+	var n int64
+	err := binary.Read(r, binary.LittleEndian, &n)
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	objectives := make(map[string]FactorizationMachine, n)
+	for i := int64(0); i < n; i++ {
+		name, err := encoding.ReadString(r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if objectives[name], err = UnmarshalModel(r); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return objectives, nil
+}
+
 // Clone a model with deep copy.
 func Clone(m FactorizationMachine) FactorizationMachine {
 	var copied FactorizationMachine
@@ -532,6 +612,11 @@ func (fm *FM) Marshal(w io.Writer) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// write calibration
+	err = encoding.WriteGob(w, fm.Calibration)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
 
@@ -577,5 +662,10 @@ func (fm *FM) Unmarshal(r io.Reader) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// read calibration
+	err = encoding.ReadGob(r, &fm.Calibration)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
@@ -114,6 +114,42 @@ func TestFM_Regression_Criteo(t *testing.T) {
 	assert.True(t, m.Invalid())
 }
 
+func TestMarshalObjectives(t *testing.T) {
+	objectives := make(map[string]FactorizationMachine)
+	for i, name := range []string{"purchase", "like"} {
+		builder := NewUnifiedMapIndexBuilder()
+		builder.AddItem("1")
+		m := NewFM(FMClassification, model.Params{model.NFactors: 1})
+		m.Index = builder.Build()
+		m.B = float32(i)
+		m.W = []float32{1}
+		m.V = [][]float32{{1}}
+		objectives[name] = m
+	}
+	buf := bytes.NewBuffer(nil)
+	err := MarshalObjectives(buf, objectives)
+	assert.NoError(t, err)
+	unmarshalled, err := UnmarshalObjectives(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, objectives, unmarshalled)
+	// no objectives at the end of stream
+	unmarshalled, err = UnmarshalObjectives(buf)
+	assert.NoError(t, err)
+	assert.Nil(t, unmarshalled)
+}
+
+func TestFitConfig_Epochs(t *testing.T) {
+	m := NewFM(FMClassification, model.Params{model.NEpochs: 10})
+	fitConfig := NewFitConfig().SetWarmStartEpochs(2)
+	assert.Equal(t, 10, fitConfig.Epochs(m, m.Complexity()))
+	// warm start from a valid model
+	m.Index = NewUnifiedMapIndexBuilder().Build()
+	m.V = [][]float32{}
+	m.W = []float32{}
+	assert.Equal(t, 2, fitConfig.Epochs(m, m.Complexity()))
+	assert.Equal(t, 10, NewFitConfig().Epochs(m, m.Complexity()))
+}
+
 //func TestFM_Regression_Frappe(t *testing.T) {
 //	// LibFM command:
 //	// libfm.exe -train train.libfm -test test.libfm -task r \
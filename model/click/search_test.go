@@ -70,6 +70,10 @@ func (m *mockFactorizationMachineForSearch) Predict(_, _ string, _, _ []string)
 	panic("don't call me")
 }
 
+func (m *mockFactorizationMachineForSearch) PredictWithContext(_, _ string, _, _, _ []string) float32 {
+	panic("don't call me")
+}
+
 func (m *mockFactorizationMachineForSearch) InternalPredict(_ []int32, _ []float32) float32 {
 	panic("don't call me")
 }
@@ -17,6 +17,7 @@ package ranking
 import (
 	"bufio"
 	"fmt"
+	"github.com/araddon/dateparse"
 	"github.com/juju/errors"
 	"github.com/scylladb/go-set"
 	"github.com/scylladb/go-set/i32set"
@@ -29,6 +30,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // DataSet contains preprocessed data structures for recommendation models.
@@ -195,20 +197,7 @@ func (dataset *DataSet) NegativeSample(excludeSet *DataSet, numCandidates int) [
 // set. If numTestUsers is equal or greater than the number of total users or numTestUsers <= 0, all users are presented
 // in the test set.
 func (dataset *DataSet) Split(numTestUsers int, seed int64) (*DataSet, *DataSet) {
-	trainSet, testSet := new(DataSet), new(DataSet)
-	trainSet.NumItemLabels, testSet.NumItemLabels = dataset.NumItemLabels, dataset.NumItemLabels
-	trainSet.NumUserLabels, testSet.NumUserLabels = dataset.NumUserLabels, dataset.NumUserLabels
-	trainSet.HiddenItems, testSet.HiddenItems = dataset.HiddenItems, dataset.HiddenItems
-	trainSet.ItemCategories, testSet.ItemCategories = dataset.ItemCategories, dataset.ItemCategories
-	trainSet.CategorySet, testSet.CategorySet = dataset.CategorySet, dataset.CategorySet
-	trainSet.ItemLabels, testSet.ItemLabels = dataset.ItemLabels, dataset.ItemLabels
-	trainSet.UserLabels, testSet.UserLabels = dataset.UserLabels, dataset.UserLabels
-	trainSet.NumItemLabelUsed, testSet.NumItemLabelUsed = dataset.NumItemLabelUsed, dataset.NumItemLabelUsed
-	trainSet.NumUserLabelUsed, testSet.NumUserLabelUsed = dataset.NumUserLabelUsed, dataset.NumUserLabelUsed
-	trainSet.UserIndex, testSet.UserIndex = dataset.UserIndex, dataset.UserIndex
-	trainSet.ItemIndex, testSet.ItemIndex = dataset.ItemIndex, dataset.ItemIndex
-	trainSet.UserFeedback, testSet.UserFeedback = createSliceOfSlice(dataset.UserCount()), createSliceOfSlice(dataset.UserCount())
-	trainSet.ItemFeedback, testSet.ItemFeedback = createSliceOfSlice(dataset.ItemCount()), createSliceOfSlice(dataset.ItemCount())
+	trainSet, testSet := dataset.emptySplit()
 	rng := base.NewRandomGenerator(seed)
 	if numTestUsers >= dataset.UserCount() || numTestUsers <= 0 {
 		for userIndex := int32(0); userIndex < int32(dataset.UserCount()); userIndex++ {
@@ -262,6 +251,69 @@ func (dataset *DataSet) Split(numTestUsers int, seed int64) (*DataSet, *DataSet)
 	return trainSet, testSet
 }
 
+// SplitBy holds out feedback of users at given positions of their feedback lists. Feedback of users whose positions
+// are negative or absent are all put into the train set.
+func (dataset *DataSet) SplitBy(positions []int32) (*DataSet, *DataSet) {
+	trainSet, testSet := dataset.emptySplit()
+	for userIndex := int32(0); userIndex < int32(dataset.UserCount()); userIndex++ {
+		k := -1
+		if int(userIndex) < len(positions) {
+			k = int(positions[userIndex])
+		}
+		for i, itemIndex := range dataset.UserFeedback[userIndex] {
+			if i == k {
+				testSet.FeedbackUsers.Append(userIndex)
+				testSet.FeedbackItems.Append(itemIndex)
+				testSet.UserFeedback[userIndex] = append(testSet.UserFeedback[userIndex], itemIndex)
+				testSet.ItemFeedback[itemIndex] = append(testSet.ItemFeedback[itemIndex], userIndex)
+			} else {
+				trainSet.FeedbackUsers.Append(userIndex)
+				trainSet.FeedbackItems.Append(itemIndex)
+				trainSet.UserFeedback[userIndex] = append(trainSet.UserFeedback[userIndex], itemIndex)
+				trainSet.ItemFeedback[itemIndex] = append(trainSet.ItemFeedback[itemIndex], userIndex)
+			}
+		}
+	}
+	return trainSet, testSet
+}
+
+// SplitByTime puts feedback before the split time into the train set and others into the test set, where timestamps
+// are timestamps of feedback in the order of feedback in the data set.
+func (dataset *DataSet) SplitByTime(timestamps []time.Time, split time.Time) (*DataSet, *DataSet) {
+	trainSet, testSet := dataset.emptySplit()
+	for i := 0; i < dataset.Count(); i++ {
+		userIndex, itemIndex := dataset.GetIndex(i)
+		target := trainSet
+		if !timestamps[i].Before(split) {
+			target = testSet
+		}
+		target.FeedbackUsers.Append(userIndex)
+		target.FeedbackItems.Append(itemIndex)
+		target.UserFeedback[userIndex] = append(target.UserFeedback[userIndex], itemIndex)
+		target.ItemFeedback[itemIndex] = append(target.ItemFeedback[itemIndex], userIndex)
+	}
+	return trainSet, testSet
+}
+
+// emptySplit creates a train set and a test set sharing indices, labels and items of the dataset without feedback.
+func (dataset *DataSet) emptySplit() (*DataSet, *DataSet) {
+	trainSet, testSet := new(DataSet), new(DataSet)
+	trainSet.NumItemLabels, testSet.NumItemLabels = dataset.NumItemLabels, dataset.NumItemLabels
+	trainSet.NumUserLabels, testSet.NumUserLabels = dataset.NumUserLabels, dataset.NumUserLabels
+	trainSet.HiddenItems, testSet.HiddenItems = dataset.HiddenItems, dataset.HiddenItems
+	trainSet.ItemCategories, testSet.ItemCategories = dataset.ItemCategories, dataset.ItemCategories
+	trainSet.CategorySet, testSet.CategorySet = dataset.CategorySet, dataset.CategorySet
+	trainSet.ItemLabels, testSet.ItemLabels = dataset.ItemLabels, dataset.ItemLabels
+	trainSet.UserLabels, testSet.UserLabels = dataset.UserLabels, dataset.UserLabels
+	trainSet.NumItemLabelUsed, testSet.NumItemLabelUsed = dataset.NumItemLabelUsed, dataset.NumItemLabelUsed
+	trainSet.NumUserLabelUsed, testSet.NumUserLabelUsed = dataset.NumUserLabelUsed, dataset.NumUserLabelUsed
+	trainSet.UserIndex, testSet.UserIndex = dataset.UserIndex, dataset.UserIndex
+	trainSet.ItemIndex, testSet.ItemIndex = dataset.ItemIndex, dataset.ItemIndex
+	trainSet.UserFeedback, testSet.UserFeedback = createSliceOfSlice(dataset.UserCount()), createSliceOfSlice(dataset.UserCount())
+	trainSet.ItemFeedback, testSet.ItemFeedback = createSliceOfSlice(dataset.ItemCount()), createSliceOfSlice(dataset.ItemCount())
+	return trainSet, testSet
+}
+
 // GetIndex gets the i-th record by <user index, item index, rating>.
 func (dataset *DataSet) GetIndex(i int) (int32, int32) {
 	return dataset.FeedbackUsers.Get(i), dataset.FeedbackItems.Get(i)
@@ -321,6 +373,55 @@ func loadTrain(path string) (*DataSet, error) {
 	return dataset, scanner.Err()
 }
 
+// LoadDataFromCSV loads feedback in the format exported by the master, whose fields are feedback types, user ids, item
+// ids and timestamps. Feedback of other types are skipped if feedbackTypes is not empty, and only the first feedback
+// between a user and an item is kept. Timestamps of feedback are returned in the order of feedback in the data set.
+func LoadDataFromCSV(path, sep string, hasHeader bool, feedbackTypes []string) (*DataSet, []time.Time, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer file.Close()
+	types := strset.New(feedbackTypes...)
+	dataset := NewMapIndexDataset()
+	var (
+		timestamps []time.Time
+		parseErr   error
+		pairs      = make(map[[2]string]struct{})
+	)
+	err = base.ReadLines(bufio.NewScanner(file), sep, func(lineNumber int, fields []string) bool {
+		if hasHeader {
+			hasHeader = false
+			return true
+		}
+		if len(fields) < 4 {
+			parseErr = errors.NotValidf("number of fields at line %d", lineNumber)
+			return false
+		}
+		if !types.IsEmpty() && !types.Has(fields[0]) {
+			return true
+		}
+		var timestamp time.Time
+		if timestamp, parseErr = dateparse.ParseAny(fields[3]); parseErr != nil {
+			parseErr = errors.Annotatef(parseErr, "failed to parse datetime `%v` at line %d", fields[3], lineNumber)
+			return false
+		}
+		if _, exist := pairs[[2]string{fields[1], fields[2]}]; exist {
+			return true
+		}
+		pairs[[2]string{fields[1], fields[2]}] = struct{}{}
+		dataset.AddFeedback(fields[1], fields[2], true)
+		timestamps = append(timestamps, timestamp)
+		return true
+	})
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	} else if parseErr != nil {
+		return nil, nil, errors.Trace(parseErr)
+	}
+	return dataset, timestamps, nil
+}
+
 // LoadDataFromBuiltIn loads a built-in Data set. Now support:
 func LoadDataFromBuiltIn(dataSetName string) (*DataSet, *DataSet, error) {
 	// Extract Data set information
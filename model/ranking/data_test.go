@@ -16,8 +16,11 @@ package ranking
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestNewMapIndexDataset(t *testing.T) {
@@ -69,3 +72,65 @@ func TestDataSet_Split(t *testing.T) {
 	assert.Equal(t, numItems, test2.ItemCount())
 	assert.Equal(t, 2, test2.Count())
 }
+
+func TestDataSet_SplitBy(t *testing.T) {
+	numUsers, numItems := 3, 5
+	// create dataset
+	dataset := NewMapIndexDataset()
+	for i := 0; i < numUsers; i++ {
+		dataset.AddUser(fmt.Sprintf("user%v", i))
+	}
+	for i := 0; i < numItems; i++ {
+		dataset.AddItem(fmt.Sprintf("item%v", i))
+	}
+	for i := 0; i < numUsers; i++ {
+		for j := i + 1; j < numItems; j++ {
+			dataset.AddFeedback(fmt.Sprintf("user%v", i), fmt.Sprintf("item%v", j), false)
+		}
+	}
+	// hold out the last feedback of the first user and the first feedback of the second user
+	train, test := dataset.SplitBy([]int32{3, 0})
+	assert.Equal(t, numUsers, train.UserCount())
+	assert.Equal(t, numItems, test.ItemCount())
+	assert.Equal(t, 7, train.Count())
+	assert.Equal(t, 2, test.Count())
+	assert.Equal(t, []int32{4}, test.UserFeedback[0])
+	assert.Equal(t, []int32{2}, test.UserFeedback[1])
+	assert.Empty(t, test.UserFeedback[2])
+	assert.Equal(t, []int32{3, 4}, train.UserFeedback[2])
+}
+
+func TestLoadDataFromCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.csv")
+	err := os.WriteFile(path, []byte("feedback_type,user_id,item_id,time_stamp\r\n"+
+		"star,1,10,2022-12-01 00:00:00\r\n"+
+		"read,1,20,2022-12-01 00:00:00\r\n"+
+		"star,2,20,2023-01-02 00:00:00\r\n"+
+		"like,1,30,2023-01-03 00:00:00\r\n"+
+		"like,1,10,2023-01-04 00:00:00\r\n"), 0644)
+	assert.NoError(t, err)
+	dataset, timestamps, err := LoadDataFromCSV(path, ",", true, []string{"star", "like"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dataset.Count())
+	assert.Equal(t, 2, dataset.UserCount())
+	assert.Equal(t, 3, dataset.ItemCount())
+	assert.Equal(t, []time.Time{
+		time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC),
+	}, timestamps)
+
+	// split by time
+	train, test := dataset.SplitByTime(timestamps, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 1, train.Count())
+	assert.Equal(t, 2, test.Count())
+	assert.Equal(t, []int32{0}, train.UserFeedback[0])
+	assert.Equal(t, []int32{2}, test.UserFeedback[0])
+	assert.Equal(t, []int32{1}, test.UserFeedback[1])
+
+	// invalid timestamp
+	err = os.WriteFile(path, []byte("star,1,10,yesterday\r\n"), 0644)
+	assert.NoError(t, err)
+	_, _, err = LoadDataFromCSV(path, ",", false, nil)
+	assert.Error(t, err)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/samber/lo"
 	"github.com/scylladb/go-set/i32set"
 	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/accel"
 	"github.com/zhenghaoz/gorse/base/copier"
 	"github.com/zhenghaoz/gorse/base/encoding"
 	"github.com/zhenghaoz/gorse/base/floats"
@@ -44,10 +45,11 @@ type Score struct {
 
 type FitConfig struct {
 	*task.JobsAllocator
-	Verbose    int
-	Candidates int
-	TopK       int
-	Task       *task.Task
+	Verbose         int
+	Candidates      int
+	TopK            int
+	Task            *task.Task
+	WarmStartEpochs int // number of epochs to fit a valid model from its parameters, zero for full epochs
 }
 
 func NewFitConfig() *FitConfig {
@@ -73,6 +75,20 @@ func (config *FitConfig) SetTask(t *task.Task) *FitConfig {
 	return config
 }
 
+func (config *FitConfig) SetWarmStartEpochs(epochs int) *FitConfig {
+	config.WarmStartEpochs = epochs
+	return config
+}
+
+// Epochs returns the number of epochs to fit a model. A valid model is warm-started and fitted by at most
+// WarmStartEpochs epochs.
+func (config *FitConfig) Epochs(m Model, nEpochs int) int {
+	if config.WarmStartEpochs > 0 && !m.Invalid() && config.WarmStartEpochs < nEpochs {
+		return config.WarmStartEpochs
+	}
+	return nEpochs
+}
+
 func (config *FitConfig) LoadDefaultIfNil() *FitConfig {
 	if config == nil {
 		return NewFitConfig()
@@ -400,6 +416,7 @@ func (bpr *BPR) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 		zap.Int("test_set_size", valSet.Count()),
 		zap.Any("params", bpr.GetParams()),
 		zap.Any("config", config))
+	nEpochs := config.Epochs(bpr, bpr.nEpochs)
 	bpr.Init(trainSet)
 	// Create buffers
 	maxJobs := config.MaxJobs()
@@ -423,14 +440,17 @@ func (bpr *BPR) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 	evalStart := time.Now()
 	scores := Evaluate(bpr, valSet, trainSet, config.TopK, config.Candidates, config.AvailableJobs(config.Task), NDCG, Precision, Recall)
 	evalTime := time.Since(evalStart)
-	log.Logger().Debug(fmt.Sprintf("fit bpr %v/%v", 0, bpr.nEpochs),
+	log.Logger().Debug(fmt.Sprintf("fit bpr %v/%v", 0, nEpochs),
 		zap.String("eval_time", evalTime.String()),
 		zap.Float32(fmt.Sprintf("NDCG@%v", config.TopK), scores[0]),
 		zap.Float32(fmt.Sprintf("Precision@%v", config.TopK), scores[1]),
 		zap.Float32(fmt.Sprintf("Recall@%v", config.TopK), scores[2]))
 	snapshots.AddSnapshot(Score{NDCG: scores[0], Precision: scores[1], Recall: scores[2]}, bpr.UserFactor, bpr.ItemFactor)
 	// Training
-	for epoch := 1; epoch <= bpr.nEpochs; epoch++ {
+	for epoch := 1; epoch <= nEpochs; epoch++ {
+		if config.Task.IsCancelled() {
+			break
+		}
 		fitStart := time.Now()
 		// Training epoch
 		numJobs := config.AvailableJobs(config.Task)
@@ -480,11 +500,11 @@ func (bpr *BPR) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 		})
 		fitTime := time.Since(fitStart)
 		// Cross validation
-		if epoch%config.Verbose == 0 || epoch == bpr.nEpochs {
+		if epoch%config.Verbose == 0 || epoch == nEpochs {
 			evalStart = time.Now()
 			scores = Evaluate(bpr, valSet, trainSet, config.TopK, config.Candidates, config.AvailableJobs(config.Task), NDCG, Precision, Recall)
 			evalTime = time.Since(evalStart)
-			log.Logger().Debug(fmt.Sprintf("fit bpr %v/%v", epoch, bpr.nEpochs),
+			log.Logger().Debug(fmt.Sprintf("fit bpr %v/%v", epoch, nEpochs),
 				zap.String("fit_time", fitTime.String()),
 				zap.String("eval_time", evalTime.String()),
 				zap.Float32(fmt.Sprintf("NDCG@%v", config.TopK), scores[0]),
@@ -722,6 +742,7 @@ func (ccd *CCD) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 		zap.Int("test_set_size", valSet.Count()),
 		zap.Any("params", ccd.GetParams()),
 		zap.Any("config", config))
+	nEpochs := config.Epochs(ccd, ccd.nEpochs)
 	ccd.Init(trainSet)
 	// Create temporary matrix
 	maxJobs := config.MaxJobs()
@@ -741,25 +762,21 @@ func (ccd *CCD) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 	evalStart := time.Now()
 	scores := Evaluate(ccd, valSet, trainSet, config.TopK, config.Candidates, config.AvailableJobs(config.Task), NDCG, Precision, Recall)
 	evalTime := time.Since(evalStart)
-	log.Logger().Debug(fmt.Sprintf("fit ccd %v/%v", 0, ccd.nEpochs),
+	log.Logger().Debug(fmt.Sprintf("fit ccd %v/%v", 0, nEpochs),
 		zap.String("eval_time", evalTime.String()),
 		zap.Float32(fmt.Sprintf("NDCG@%v", config.TopK), scores[0]),
 		zap.Float32(fmt.Sprintf("Precision@%v", config.TopK), scores[1]),
 		zap.Float32(fmt.Sprintf("Recall@%v", config.TopK), scores[2]))
 	snapshots.AddSnapshot(Score{NDCG: scores[0], Precision: scores[1], Recall: scores[2]}, ccd.UserFactor, ccd.ItemFactor)
-	for ep := 1; ep <= ccd.nEpochs; ep++ {
+	for ep := 1; ep <= nEpochs; ep++ {
+		if config.Task.IsCancelled() {
+			break
+		}
 		fitStart := time.Now()
 		// Update user factors
 		// S^q <- \sum^N_{itemIndex=1} c_i q_i q_i^T
-		floats.MatZero(s)
-		for itemIndex := 0; itemIndex < trainSet.ItemCount(); itemIndex++ {
-			if len(trainSet.ItemFeedback[itemIndex]) > 0 {
-				for i := 0; i < ccd.nFactors; i++ {
-					for j := 0; j < ccd.nFactors; j++ {
-						s[i][j] += ccd.ItemFactor[itemIndex][i] * ccd.ItemFactor[itemIndex][j]
-					}
-				}
-			}
+		if err := accel.Gram(s, ccd.ItemFactor, func(i int) bool { return len(trainSet.ItemFeedback[i]) > 0 }); err != nil {
+			log.Logger().Error("failed to compute gram matrix", zap.Error(err))
 		}
 		_ = parallel.Parallel(trainSet.UserCount(), config.AvailableJobs(config.Task), func(workerId, userIndex int) error {
 			userFeedback := trainSet.UserFeedback[userIndex]
@@ -792,15 +809,8 @@ func (ccd *CCD) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 		})
 		// Update item factors
 		// S^p <- P^T P
-		floats.MatZero(s)
-		for userIndex := 0; userIndex < trainSet.UserCount(); userIndex++ {
-			if len(trainSet.UserFeedback[userIndex]) > 0 {
-				for i := 0; i < ccd.nFactors; i++ {
-					for j := 0; j < ccd.nFactors; j++ {
-						s[i][j] += ccd.UserFactor[userIndex][i] * ccd.UserFactor[userIndex][j]
-					}
-				}
-			}
+		if err := accel.Gram(s, ccd.UserFactor, func(i int) bool { return len(trainSet.UserFeedback[i]) > 0 }); err != nil {
+			log.Logger().Error("failed to compute gram matrix", zap.Error(err))
 		}
 		_ = parallel.Parallel(trainSet.ItemCount(), config.AvailableJobs(config.Task), func(workerId, itemIndex int) error {
 			itemFeedback := trainSet.ItemFeedback[itemIndex]
@@ -833,11 +843,11 @@ func (ccd *CCD) Fit(trainSet, valSet *DataSet, config *FitConfig) Score {
 		})
 		fitTime := time.Since(fitStart)
 		// Cross validation
-		if ep%config.Verbose == 0 || ep == ccd.nEpochs {
+		if ep%config.Verbose == 0 || ep == nEpochs {
 			evalStart = time.Now()
 			scores = Evaluate(ccd, valSet, trainSet, config.TopK, config.Candidates, config.AvailableJobs(config.Task), NDCG, Precision, Recall)
 			evalTime = time.Since(evalStart)
-			log.Logger().Debug(fmt.Sprintf("fit ccd %v/%v", ep, ccd.nEpochs),
+			log.Logger().Debug(fmt.Sprintf("fit ccd %v/%v", ep, nEpochs),
 				zap.String("fit_time", fitTime.String()),
 				zap.String("eval_time", evalTime.String()),
 				zap.Float32(fmt.Sprintf("NDCG@%v", config.TopK), scores[0]),
@@ -21,6 +21,7 @@ import (
 	"github.com/zhenghaoz/gorse/model"
 	"math"
 	"runtime"
+	"strconv"
 	"testing"
 )
 
@@ -137,6 +138,27 @@ func TestCCD_MovieLens(t *testing.T) {
 	assert.True(t, m.Invalid())
 }
 
+func TestFitConfig_Epochs(t *testing.T) {
+	dataset := NewMapIndexDataset()
+	for i := 0; i < 10; i++ {
+		dataset.AddFeedback(strconv.Itoa(i), strconv.Itoa(i), true)
+	}
+	m := NewBPR(model.Params{model.NEpochs: 10})
+	fitConfig := newFitConfig(10).SetWarmStartEpochs(2)
+	assert.Equal(t, 10, fitConfig.Epochs(m, m.Complexity()))
+	m.Fit(dataset, dataset, fitConfig)
+	assert.Equal(t, 10, fitConfig.Task.Done)
+
+	// warm start from the fitted model
+	fitConfig = newFitConfig(2).SetWarmStartEpochs(2)
+	assert.Equal(t, 2, fitConfig.Epochs(m, m.Complexity()))
+	m.Fit(dataset, dataset, fitConfig)
+	assert.Equal(t, 2, fitConfig.Task.Done)
+
+	// fit full epochs if warm start is disabled
+	assert.Equal(t, 10, newFitConfig(10).Epochs(m, m.Complexity()))
+}
+
 //func TestCCD_Pinterest(t *testing.T) {
 //	trainSet, testSet, err := LoadDataFromBuiltIn("pinterest-20")
 //	assert.NoError(t, err)
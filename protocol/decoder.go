@@ -15,95 +15,141 @@
 package protocol
 
 import (
+	"io"
+
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/model/click"
 	"github.com/zhenghaoz/gorse/model/ranking"
 	"go.uber.org/zap"
-	"io"
 )
 
-// UnmarshalClickModel unmarshal click model from gRPC.
-func UnmarshalClickModel(receiver Master_GetClickModelClient) (click.FactorizationMachine, error) {
-	// receive model
-	reader, writer := io.Pipe()
-	var finalError error
-	go func() {
-		defer func(writer *io.PipeWriter) {
-			err := writer.Close()
-			if err != nil {
-				log.Logger().Error("fail to close pipe", zap.Error(err))
+// fragmentSize is the maximal number of uncompressed bytes in a fragment.
+var fragmentSize = 1 << 20
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// FragmentSender sends fragments of a model.
+type FragmentSender interface {
+	Send(*Fragment) error
+}
+
+// FragmentReceiver receives fragments of a model.
+type FragmentReceiver interface {
+	Recv() (*Fragment, error)
+}
+
+// SendFragments sends a serialized model in fragments, starting from the offset. Each fragment is compressed into an
+// independent zstd frame if compression is required, so that offsets always refer to uncompressed bytes.
+func SendFragments(sender FragmentSender, reader io.Reader, offset int64, compress bool) error {
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, reader, offset); err == io.EOF {
+			return errors.NotValidf("offset %d beyond model size", offset)
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	buf := make([]byte, fragmentSize)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			fragment := &Fragment{Data: buf[:n], Offset: offset}
+			if compress {
+				fragment.Data = zstdEncoder.EncodeAll(buf[:n], nil)
+				fragment.Compressed = true
 			}
-		}(writer)
-		for {
-			// receive from stream
-			fragment, err := receiver.Recv()
-			if err == io.EOF {
-				log.Logger().Info("complete receiving click model")
-				break
-			} else if err != nil {
-				finalError = err
-				log.Logger().Error("fail to receive stream", zap.Error(err))
-				return
+			if err := sender.Send(fragment); err != nil {
+				return errors.Trace(err)
 			}
-			// send to pipe
-			_, err = writer.Write(fragment.Data)
-			if err != nil {
-				finalError = err
-				log.Logger().Error("fail to write pipe", zap.Error(err))
-				return
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// ReceiveFragments writes fragments of a model starting from the offset to the writer, and returns the number of
+// written bytes. Bytes before the offset are skipped if the sender starts from an earlier position.
+func ReceiveFragments(receiver FragmentReceiver, writer io.Writer, offset int64) (int64, error) {
+	var written, position int64
+	for i := 0; ; i++ {
+		fragment, err := receiver.Recv()
+		if err == io.EOF {
+			return written, nil
+		} else if err != nil {
+			return written, errors.Trace(err)
+		}
+		data := fragment.Data
+		if fragment.Compressed {
+			if data, err = zstdDecoder.DecodeAll(data, nil); err != nil {
+				return written, errors.Trace(err)
 			}
 		}
+		// senders without offsets only set zero offsets
+		if i == 0 {
+			position = fragment.Offset
+		} else if fragment.Offset != 0 && fragment.Offset != position {
+			return written, errors.NotValidf("fragment at offset %d while expecting %d", fragment.Offset, position)
+		}
+		if position > offset+written {
+			return written, errors.NotValidf("fragment at offset %d while expecting %d", position, offset+written)
+		}
+		skip := offset + written - position
+		position += int64(len(data))
+		if skip >= int64(len(data)) {
+			continue
+		}
+		n, err := writer.Write(data[skip:])
+		written += int64(n)
+		if err != nil {
+			return written, errors.Trace(err)
+		}
+	}
+}
+
+// UnmarshalClickModel unmarshal click model and models of objectives from gRPC.
+func UnmarshalClickModel(receiver FragmentReceiver) (click.FactorizationMachine, map[string]click.FactorizationMachine, error) {
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	go func() {
+		_, err := ReceiveFragments(receiver, writer, 0)
+		if err != nil {
+			log.Logger().Error("fail to receive stream", zap.Error(err))
+		} else {
+			log.Logger().Info("complete receiving click model")
+		}
+		_ = writer.CloseWithError(err)
 	}()
-	// unmarshal model
-	model, err := click.UnmarshalModel(reader)
+	clickModel, err := click.UnmarshalModel(reader)
 	if err != nil {
-		return nil, err
+		return nil, nil, errors.Trace(err)
 	}
-	if finalError != nil {
-		return nil, finalError
+	objectives, err := click.UnmarshalObjectives(reader)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
 	}
-	return model, nil
+	return clickModel, objectives, nil
 }
 
 // UnmarshalRankingModel unmarshal ranking model from gRPC.
-func UnmarshalRankingModel(receiver Master_GetRankingModelClient) (ranking.MatrixFactorization, error) {
-	// receive model
+func UnmarshalRankingModel(receiver FragmentReceiver) (ranking.MatrixFactorization, error) {
 	reader, writer := io.Pipe()
-	var receiverError error
+	defer reader.Close()
 	go func() {
-		defer func(writer *io.PipeWriter) {
-			err := writer.Close()
-			if err != nil {
-				log.Logger().Error("fail to close pipe", zap.Error(err))
-			}
-		}(writer)
-		for {
-			// receive from stream
-			fragment, err := receiver.Recv()
-			if err == io.EOF {
-				log.Logger().Info("complete receiving ranking model")
-				break
-			} else if err != nil {
-				receiverError = err
-				log.Logger().Error("fail to receive stream", zap.Error(err))
-				return
-			}
-			// send to pipe
-			_, err = writer.Write(fragment.Data)
-			if err != nil {
-				receiverError = err
-				log.Logger().Error("fail to write pipe", zap.Error(err))
-				return
-			}
+		_, err := ReceiveFragments(receiver, writer, 0)
+		if err != nil {
+			log.Logger().Error("fail to receive stream", zap.Error(err))
+		} else {
+			log.Logger().Info("complete receiving ranking model")
 		}
+		_ = writer.CloseWithError(err)
 	}()
-	// unmarshal model
-	model, err := ranking.UnmarshalModel(reader)
-	if err != nil {
-		return nil, err
-	}
-	if receiverError != nil {
-		return nil, receiverError
-	}
-	return model, nil
+	return ranking.UnmarshalModel(reader)
 }
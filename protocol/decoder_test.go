@@ -0,0 +1,109 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockStream struct {
+	fragments []*Fragment
+}
+
+func (s *mockStream) Send(fragment *Fragment) error {
+	s.fragments = append(s.fragments, &Fragment{
+		Data:       append([]byte(nil), fragment.Data...),
+		Offset:     fragment.Offset,
+		Compressed: fragment.Compressed,
+	})
+	return nil
+}
+
+func (s *mockStream) Recv() (*Fragment, error) {
+	if len(s.fragments) == 0 {
+		return nil, io.EOF
+	}
+	fragment := s.fragments[0]
+	s.fragments = s.fragments[1:]
+	return fragment, nil
+}
+
+func TestFragments(t *testing.T) {
+	fragmentSize = 100
+	defer func() { fragmentSize = 1 << 20 }()
+	data := bytes.Repeat([]byte("gorse"), 200)
+
+	// send and receive uncompressed fragments
+	stream := &mockStream{}
+	assert.NoError(t, SendFragments(stream, bytes.NewReader(data), 0, false))
+	assert.Len(t, stream.fragments, 10)
+	assert.False(t, stream.fragments[0].Compressed)
+	var buf bytes.Buffer
+	n, err := ReceiveFragments(stream, &buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, buf.Bytes())
+
+	// send and receive compressed fragments
+	stream = &mockStream{}
+	assert.NoError(t, SendFragments(stream, bytes.NewReader(data), 0, true))
+	assert.Len(t, stream.fragments, 10)
+	assert.True(t, stream.fragments[0].Compressed)
+	assert.Less(t, len(stream.fragments[0].Data), 100)
+	buf.Reset()
+	n, err = ReceiveFragments(stream, &buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, buf.Bytes())
+
+	// resume from offset
+	stream = &mockStream{}
+	assert.NoError(t, SendFragments(stream, bytes.NewReader(data), 450, true))
+	assert.Len(t, stream.fragments, 6)
+	assert.Equal(t, int64(450), stream.fragments[0].Offset)
+	buf.Reset()
+	n, err = ReceiveFragments(stream, &buf, 450)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(550), n)
+	assert.Equal(t, data[450:], buf.Bytes())
+
+	// resume from sender ignoring offset
+	stream = &mockStream{}
+	for i := 0; i < len(data); i += 300 {
+		end := i + 300
+		if end > len(data) {
+			end = len(data)
+		}
+		stream.fragments = append(stream.fragments, &Fragment{Data: data[i:end]})
+	}
+	buf.Reset()
+	n, err = ReceiveFragments(stream, &buf, 450)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(550), n)
+	assert.Equal(t, data[450:], buf.Bytes())
+
+	// offset beyond model size
+	err = SendFragments(&mockStream{}, bytes.NewReader(data), 2000, true)
+	assert.True(t, errors.IsNotValid(err))
+	// missing fragments
+	stream = &mockStream{fragments: []*Fragment{{Data: data[:100], Offset: 500}}}
+	_, err = ReceiveFragments(stream, &buf, 450)
+	assert.True(t, errors.IsNotValid(err))
+}
@@ -24,6 +24,7 @@ const _ = grpc.SupportPackageIsVersion7
 type MasterClient interface {
 	// meta distribute
 	GetMeta(ctx context.Context, in *NodeInfo, opts ...grpc.CallOption) (*Meta, error)
+	Deregister(ctx context.Context, in *NodeInfo, opts ...grpc.CallOption) (*DeregisterResponse, error)
 	// data distribute
 	GetRankingModel(ctx context.Context, in *VersionInfo, opts ...grpc.CallOption) (Master_GetRankingModelClient, error)
 	GetClickModel(ctx context.Context, in *VersionInfo, opts ...grpc.CallOption) (Master_GetClickModelClient, error)
@@ -48,6 +49,15 @@ func (c *masterClient) GetMeta(ctx context.Context, in *NodeInfo, opts ...grpc.C
 	return out, nil
 }
 
+func (c *masterClient) Deregister(ctx context.Context, in *NodeInfo, opts ...grpc.CallOption) (*DeregisterResponse, error) {
+	out := new(DeregisterResponse)
+	err := c.cc.Invoke(ctx, "/protocol.Master/Deregister", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *masterClient) GetRankingModel(ctx context.Context, in *VersionInfo, opts ...grpc.CallOption) (Master_GetRankingModelClient, error) {
 	stream, err := c.cc.NewStream(ctx, &Master_ServiceDesc.Streams[0], "/protocol.Master/GetRankingModel", opts...)
 	if err != nil {
@@ -127,6 +137,7 @@ func (c *masterClient) PushTaskInfo(ctx context.Context, in *PushTaskInfoRequest
 type MasterServer interface {
 	// meta distribute
 	GetMeta(context.Context, *NodeInfo) (*Meta, error)
+	Deregister(context.Context, *NodeInfo) (*DeregisterResponse, error)
 	// data distribute
 	GetRankingModel(*VersionInfo, Master_GetRankingModelServer) error
 	GetClickModel(*VersionInfo, Master_GetClickModelServer) error
@@ -142,6 +153,9 @@ type UnimplementedMasterServer struct {
 func (UnimplementedMasterServer) GetMeta(context.Context, *NodeInfo) (*Meta, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetMeta not implemented")
 }
+func (UnimplementedMasterServer) Deregister(context.Context, *NodeInfo) (*DeregisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deregister not implemented")
+}
 func (UnimplementedMasterServer) GetRankingModel(*VersionInfo, Master_GetRankingModelServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetRankingModel not implemented")
 }
@@ -182,6 +196,24 @@ func _Master_GetMeta_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Master_Deregister_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MasterServer).Deregister(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.Master/Deregister",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MasterServer).Deregister(ctx, req.(*NodeInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Master_GetRankingModel_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(VersionInfo)
 	if err := stream.RecvMsg(m); err != nil {
@@ -253,6 +285,10 @@ var Master_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetMeta",
 			Handler:    _Master_GetMeta_Handler,
 		},
+		{
+			MethodName: "Deregister",
+			Handler:    _Master_Deregister_Handler,
+		},
 		{
 			MethodName: "PushTaskInfo",
 			Handler:    _Master_PushTaskInfo_Handler,
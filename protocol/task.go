@@ -22,14 +22,21 @@ import (
 //go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative protocol.proto
 
 func DecodeTask(in *PushTaskInfoRequest) *task.Task {
-	return &task.Task{
+	t := &task.Task{
 		Name:       in.GetName(),
 		Status:     task.Status(in.GetStatus()),
 		Done:       int(in.GetDone()),
 		Total:      int(in.GetTotal()),
 		StartTime:  time.UnixMilli(in.GetStartTime()),
 		FinishTime: time.UnixMilli(in.GetFinishTime()),
+		Error:      in.GetError(),
 	}
+	if t.Status == task.StatusCancelled {
+		// mark the task cancelled but keep the finish time
+		t.Cancel()
+		t.FinishTime = time.UnixMilli(in.GetFinishTime())
+	}
+	return t
 }
 
 func EncodeTask(t *task.Task) *PushTaskInfoRequest {
@@ -40,5 +47,6 @@ func EncodeTask(t *task.Task) *PushTaskInfoRequest {
 		Total:      int64(t.Total),
 		StartTime:  t.StartTime.UnixMilli(),
 		FinishTime: t.FinishTime.UnixMilli(),
+		Error:      t.Error,
 	}
 }
@@ -29,7 +29,18 @@ func TestEncodeDecode(t *testing.T) {
 		Status:     task.StatusRunning,
 		StartTime:  time.Date(2018, time.January, 1, 0, 0, 0, 0, time.Local),
 		FinishTime: time.Date(2018, time.January, 2, 0, 0, 0, 0, time.Local),
+		Error:      "error",
 	}
 	pb := EncodeTask(tk)
 	assert.Equal(t, tk, DecodeTask(pb))
+
+	// cancelled task
+	tk.Status = task.StatusCancelled
+	decoded := DecodeTask(EncodeTask(tk))
+	assert.True(t, decoded.IsCancelled())
+	assert.Equal(t, tk.Done, decoded.Done)
+	assert.Equal(t, tk.Total, decoded.Total)
+	assert.Equal(t, tk.StartTime, decoded.StartTime)
+	assert.Equal(t, tk.FinishTime, decoded.FinishTime)
+	assert.Equal(t, tk.Error, decoded.Error)
 }
@@ -0,0 +1,99 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/juju/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLSConfig is the configuration of TLS for gRPC connections between nodes.
+type TLSConfig struct {
+	SSLCA   string // path of the CA certificate
+	SSLCert string // path of the certificate
+	SSLKey  string // path of the private key
+}
+
+// NewServerCreds creates transport credentials for the gRPC server. Clients are required to present certificates
+// signed by the CA if the CA certificate is given.
+func NewServerCreds(c *TLSConfig) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(c.SSLCert, c.SSLKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.SSLCA != "" {
+		if tlsConfig.ClientCAs, err = loadCertPool(c.SSLCA); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// NewClientCreds creates transport credentials for gRPC clients. The certificate of the server is verified by the CA
+// certificate if given, otherwise by system roots. The certificate of the client is presented if given.
+func NewClientCreds(c *TLSConfig) (credentials.TransportCredentials, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	var err error
+	if c.SSLCA != "" {
+		if tlsConfig.RootCAs, err = loadCertPool(c.SSLCA); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if c.SSLCert != "" || c.SSLKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.SSLCert, c.SSLKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// NewDialOption creates the dial option of transport credentials. Connections are insecure if TLS is not configured.
+func NewDialOption(c *TLSConfig) (grpc.DialOption, error) {
+	if c == nil {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil
+	}
+	creds, err := NewClientCreds(c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return grpc.WithTransportCredentials(creds), nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.NotValidf("CA certificate %s", path)
+	}
+	return pool, nil
+}
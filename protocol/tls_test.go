@@ -0,0 +1,164 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCertificate creates a certificate signed by the parent, or a self-signed CA certificate if the parent is nil.
+func newCertificate(t *testing.T, dir, name string, serial int64, parent *certificate) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signerCert, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	require.NoError(t, err)
+	return &certificate{cert: cert, key: key}
+}
+
+func newTestServer(t *testing.T, c *TLSConfig) string {
+	creds, err := NewServerCreds(c)
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer(grpc.Creds(creds))
+	RegisterMasterServer(grpcServer, &UnimplementedMasterServer{})
+	go func() {
+		_ = grpcServer.Serve(lis)
+	}()
+	t.Cleanup(grpcServer.Stop)
+	return lis.Addr().String()
+}
+
+func getMeta(t *testing.T, address string, c *TLSConfig) error {
+	opt, err := NewDialOption(c)
+	require.NoError(t, err)
+	conn, err := grpc.Dial(address, opt)
+	require.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = NewMasterClient(conn).GetMeta(ctx, &NodeInfo{})
+	return err
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCertificate(t, dir, "ca", 1, nil)
+	newCertificate(t, dir, "master", 2, ca)
+	newCertificate(t, dir, "worker", 3, ca)
+	address := newTestServer(t, &TLSConfig{
+		SSLCert: filepath.Join(dir, "master.pem"),
+		SSLKey:  filepath.Join(dir, "master.key"),
+	})
+
+	// connect with TLS
+	err := getMeta(t, address, &TLSConfig{SSLCA: filepath.Join(dir, "ca.pem")})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	// connect without TLS
+	err = getMeta(t, address, nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	// connect without CA
+	err = getMeta(t, address, &TLSConfig{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCertificate(t, dir, "ca", 1, nil)
+	newCertificate(t, dir, "master", 2, ca)
+	newCertificate(t, dir, "worker", 3, ca)
+	newCertificate(t, dir, "untrusted", 4, newCertificate(t, dir, "other", 5, nil))
+	address := newTestServer(t, &TLSConfig{
+		SSLCA:   filepath.Join(dir, "ca.pem"),
+		SSLCert: filepath.Join(dir, "master.pem"),
+		SSLKey:  filepath.Join(dir, "master.key"),
+	})
+
+	// connect with trusted client certificate
+	err := getMeta(t, address, &TLSConfig{
+		SSLCA:   filepath.Join(dir, "ca.pem"),
+		SSLCert: filepath.Join(dir, "worker.pem"),
+		SSLKey:  filepath.Join(dir, "worker.key"),
+	})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	// connect without client certificate
+	err = getMeta(t, address, &TLSConfig{SSLCA: filepath.Join(dir, "ca.pem")})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	// connect with untrusted client certificate
+	err = getMeta(t, address, &TLSConfig{
+		SSLCA:   filepath.Join(dir, "ca.pem"),
+		SSLCert: filepath.Join(dir, "untrusted.pem"),
+		SSLKey:  filepath.Join(dir, "untrusted.key"),
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewClientCreds(&TLSConfig{SSLCA: filepath.Join(dir, "ca.pem")})
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("invalid"), 0600))
+	_, err = NewClientCreds(&TLSConfig{SSLCA: filepath.Join(dir, "ca.pem")})
+	assert.True(t, errors.IsNotValid(err))
+}
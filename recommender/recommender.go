@@ -0,0 +1,107 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package recommender registers custom recommenders implemented out of tree. A custom recommender is registered in
+// the init function of its package and the package is imported by a custom build of the worker and the server:
+//
+//	import _ "example.com/recommenders/vip"
+//
+// Registered recommenders are enabled by names in recommend.offline.custom_recommenders for offline recommendation and
+// in recommend.online.fallback_recommend for online recommendation.
+package recommender
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+// builtinRecommenders are names reserved by recommenders of gorse.
+var builtinRecommenders = []string{"offline", "collaborative", "item_based", "user_based", "latest", "popular"}
+
+// Request is a request of recommendation for a user.
+type Request struct {
+	Settings *config.Settings // configuration, database clients and models of the worker or the server
+	UserId   string
+	Category string // empty category means all items
+	N        int    // max number of items to recommend
+}
+
+// Recommender generates candidate items for a user and scores them. Recommenders are called concurrently.
+type Recommender interface {
+	// Recommend returns items sorted by scores in descending order. Items read by the user and unavailable items
+	// are filtered out by the caller.
+	Recommend(ctx context.Context, request *Request) ([]cache.Scored, error)
+}
+
+// Func is an adapter to use a function as a recommender.
+type Func func(ctx context.Context, request *Request) ([]cache.Scored, error)
+
+func (f Func) Recommend(ctx context.Context, request *Request) ([]cache.Scored, error) {
+	return f(ctx, request)
+}
+
+var (
+	recommendersMu sync.RWMutex
+	recommenders   = make(map[string]Recommender)
+)
+
+// Register makes a recommender available by the name. It panics if the name is empty, reserved by builtin
+// recommenders or registered twice, or if the recommender is nil.
+func Register(name string, recommender Recommender) {
+	recommendersMu.Lock()
+	defer recommendersMu.Unlock()
+	if recommender == nil {
+		panic("recommender: Register recommender is nil")
+	}
+	if name == "" {
+		panic("recommender: Register name is empty")
+	}
+	for _, builtin := range builtinRecommenders {
+		if name == builtin {
+			panic("recommender: Register builtin recommender " + name)
+		}
+	}
+	if _, dup := recommenders[name]; dup {
+		panic("recommender: Register called twice for recommender " + name)
+	}
+	recommenders[name] = recommender
+}
+
+// Get returns the recommender registered by the name.
+func Get(name string) (Recommender, error) {
+	recommendersMu.RLock()
+	defer recommendersMu.RUnlock()
+	recommender, exist := recommenders[name]
+	if !exist {
+		return nil, errors.NotFoundf("recommender %s", name)
+	}
+	return recommender, nil
+}
+
+// Names returns sorted names of registered recommenders.
+func Names() []string {
+	recommendersMu.RLock()
+	defer recommendersMu.RUnlock()
+	names := make([]string, 0, len(recommenders))
+	for name := range recommenders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
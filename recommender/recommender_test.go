@@ -0,0 +1,46 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package recommender
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+func TestRegister(t *testing.T) {
+	vip := Func(func(ctx context.Context, request *Request) ([]cache.Scored, error) {
+		return []cache.Scored{{Id: request.UserId + request.Category, Score: 1}}, nil
+	})
+	Register("test_vip", vip)
+	Register("test_new", vip)
+	assert.Equal(t, []string{"test_new", "test_vip"}, Names())
+
+	recommender, err := Get("test_vip")
+	assert.NoError(t, err)
+	items, err := recommender.Recommend(context.Background(), &Request{UserId: "1", Category: "a", N: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{Id: "1a", Score: 1}}, items)
+	_, err = Get("unknown")
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	assert.Panics(t, func() { Register("test_vip", vip) })
+	assert.Panics(t, func() { Register("popular", vip) })
+	assert.Panics(t, func() { Register("", vip) })
+	assert.Panics(t, func() { Register("test_nil", nil) })
+}
@@ -0,0 +1,316 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// ItemAlias maps a duplicate item to its canonical item, e.g., SKUs of the same product. Feedback on the alias is merged
+// into the canonical item, and the alias in recommendations is redirected to the canonical item.
+type ItemAlias struct {
+	Alias     string `json:"alias"`
+	Canonical string `json:"canonical"`
+}
+
+// ItemAliases are canonical items indexed by aliases.
+type ItemAliases map[string]string
+
+// NewItemAliases validates aliases. Canonical items must not be aliases, so that aliases are redirected in one step.
+func NewItemAliases(aliases []ItemAlias) (ItemAliases, error) {
+	index := make(ItemAliases, len(aliases))
+	for _, alias := range aliases {
+		if err := base.ValidateId(alias.Alias); err != nil {
+			return nil, errors.NotValidf("alias `%s`", alias.Alias)
+		} else if err = base.ValidateId(alias.Canonical); err != nil {
+			return nil, errors.NotValidf("canonical item `%s` of alias `%s`", alias.Canonical, alias.Alias)
+		} else if alias.Alias == alias.Canonical {
+			return nil, errors.NotValidf("alias `%s` of itself", alias.Alias)
+		} else if _, exist := index[alias.Alias]; exist {
+			return nil, errors.NotValidf("duplicate alias `%s`", alias.Alias)
+		}
+		index[alias.Alias] = alias.Canonical
+	}
+	for alias, canonical := range index {
+		if _, exist := index[canonical]; exist {
+			return nil, errors.NotValidf("canonical item `%s` of alias `%s` is an alias", canonical, alias)
+		}
+	}
+	return index, nil
+}
+
+// List returns aliases sorted by aliases.
+func (aliases ItemAliases) List() []ItemAlias {
+	list := make([]ItemAlias, 0, len(aliases))
+	for alias, canonical := range aliases {
+		list = append(list, ItemAlias{Alias: alias, Canonical: canonical})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Alias < list[j].Alias })
+	return list
+}
+
+// Canonical returns the canonical item of an item. An item is canonical if it is not an alias.
+func (aliases ItemAliases) Canonical(itemId string) string {
+	if canonical, exist := aliases[itemId]; exist {
+		return canonical
+	}
+	return itemId
+}
+
+// SaveItemAliases validates and saves aliases to the cache store.
+func SaveItemAliases(ctx context.Context, client cache.Database, aliases []ItemAlias) error {
+	if _, err := NewItemAliases(aliases); err != nil {
+		return errors.Trace(err)
+	}
+	buf, err := cache.Marshal(client, aliases)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.ItemAliases), buf))
+}
+
+// LoadItemAliases loads aliases from the cache store.
+func LoadItemAliases(ctx context.Context, client cache.Database) (ItemAliases, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.ItemAliases)).String()
+	if errors.Is(err, errors.NotFound) {
+		return ItemAliases{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var aliases []ItemAlias
+	if err = cache.Unmarshal(buf, &aliases); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewItemAliases(aliases)
+}
+
+// AliasManager caches aliases in the server. Aliases are reloaded from the cache store after the cache expire.
+type AliasManager struct {
+	server     *RestServer
+	mu         sync.Mutex
+	aliases    ItemAliases
+	updateTime time.Time
+}
+
+func NewAliasManager(s *RestServer) *AliasManager {
+	return &AliasManager{server: s}
+}
+
+// Aliases returns canonical items indexed by aliases.
+func (am *AliasManager) Aliases(ctx context.Context) (ItemAliases, error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if time.Since(am.updateTime) > am.server.Config().Server.CacheExpire {
+		aliases, err := LoadItemAliases(ctx, am.server.CacheClient)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		am.aliases, am.updateTime = aliases, time.Now()
+	}
+	return am.aliases, nil
+}
+
+// invalidate reloads aliases in the next access.
+func (am *AliasManager) invalidate() {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.updateTime = time.Time{}
+}
+
+// itemAliases returns aliases of items. There is no alias if there is no alias manager.
+func (s *RestServer) itemAliases(ctx context.Context) (ItemAliases, error) {
+	if s.AliasManager == nil {
+		return ItemAliases{}, nil
+	}
+	return s.AliasManager.Aliases(ctx)
+}
+
+// saveItemAliases saves aliases and invalidates aliases cached in this server. Other servers apply new aliases after
+// their cache expire.
+func (s *RestServer) saveItemAliases(ctx context.Context, aliases []ItemAlias) error {
+	if err := SaveItemAliases(ctx, s.CacheClient, aliases); err != nil {
+		return errors.Trace(err)
+	}
+	if s.AliasManager != nil {
+		s.AliasManager.invalidate()
+	}
+	return nil
+}
+
+// canonicalizeFeedback replaces aliases in feedback by canonical items. Items of feedback are returned.
+func (s *RestServer) canonicalizeFeedback(ctx context.Context, feedback []data.Feedback, items *strset.Set) (*strset.Set, error) {
+	aliases, err := s.itemAliases(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(aliases) == 0 {
+		return items, nil
+	}
+	for i := range feedback {
+		feedback[i].ItemId = aliases.Canonical(feedback[i].ItemId)
+	}
+	return strset.New(lo.Map(items.List(), func(itemId string, _ int) string {
+		return aliases.Canonical(itemId)
+	})...), nil
+}
+
+// applyAliases redirects aliases in results to canonical items. Duplicate items are removed, and so are canonical items
+// which are neither results nor candidates, e.g., items read by the user.
+func (s *RestServer) applyAliases(ctx *recommendContext) error {
+	aliases, err := s.itemAliases(ctx.context)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(aliases) == 0 {
+		return nil
+	}
+	results := make([]string, 0, len(ctx.results))
+	sources := make([]string, 0, len(ctx.sources))
+	recommended := strset.New(ctx.results...)
+	visited := strset.New()
+	for i, itemId := range ctx.results {
+		canonical := aliases.Canonical(itemId)
+		if visited.Has(canonical) || (!recommended.Has(canonical) && !ctx.isCandidate(canonical)) {
+			continue
+		}
+		visited.Add(canonical)
+		results = append(results, canonical)
+		sources = append(sources, ctx.sources[i])
+	}
+	ctx.results, ctx.sources = results, sources
+	return nil
+}
+
+// mergeFeedback moves feedback on an alias to its canonical item. Feedback on the canonical item is kept if both items
+// have feedback of the same type from a user. The number of moved feedback is returned.
+func (s *RestServer) mergeFeedback(ctx context.Context, alias, canonical string) (int, error) {
+	feedback, err := s.DataClient.GetItemFeedback(ctx, alias)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	if len(feedback) == 0 {
+		return 0, nil
+	}
+	merged := lo.Map(feedback, func(f data.Feedback, _ int) data.Feedback {
+		f.ItemId = canonical
+		return f
+	})
+	if err = s.DataClient.BatchInsertFeedback(ctx, merged, false, false, false); err != nil {
+		return 0, errors.Trace(err)
+	}
+	for _, f := range feedback {
+		if _, err = s.DataClient.DeleteUserItemFeedback(ctx, f.UserId, alias, f.FeedbackType); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	if err = s.InsertFeedbackToCache(ctx, merged); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return len(feedback), nil
+}
+
+func (s *RestServer) getItemAliases(request *restful.Request, response *restful.Response) {
+	aliases, err := LoadItemAliases(request.Request.Context(), s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, aliases.List())
+}
+
+func (s *RestServer) getItemAlias(request *restful.Request, response *restful.Response) {
+	alias := request.PathParameter("item-id")
+	aliases, err := LoadItemAliases(request.Request.Context(), s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	canonical, exist := aliases[alias]
+	if !exist {
+		PageNotFound(response, errors.NotFoundf("alias %s", alias))
+		return
+	}
+	Ok(response, ItemAlias{Alias: alias, Canonical: canonical})
+}
+
+// putItemAlias maps an item to a canonical item and merges feedback on the item into the canonical item. The alias in
+// the path overrides the alias in the body.
+func (s *RestServer) putItemAlias(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	var alias ItemAlias
+	if err := request.ReadEntity(&alias); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	alias.Alias = request.PathParameter("item-id")
+	if _, err := s.DataClient.GetItem(ctx, alias.Canonical); errors.Is(err, errors.NotFound) {
+		PageNotFound(response, errors.Annotatef(err, "canonical item %s", alias.Canonical))
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	aliases, err := LoadItemAliases(ctx, s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	aliases[alias.Alias] = alias.Canonical
+	if err = s.saveItemAliases(ctx, aliases.List()); errors.IsNotValid(err) {
+		BadRequest(response, err)
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	count, err := s.mergeFeedback(ctx, alias.Alias, alias.Canonical)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: count})
+}
+
+// deleteItemAlias removes an alias. Feedback merged into the canonical item is not moved back.
+func (s *RestServer) deleteItemAlias(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	alias := request.PathParameter("item-id")
+	aliases, err := LoadItemAliases(ctx, s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	if _, exist := aliases[alias]; !exist {
+		Ok(response, Success{RowAffected: 0})
+		return
+	}
+	delete(aliases, alias)
+	if err = s.saveItemAliases(ctx, aliases.List()); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: 1})
+}
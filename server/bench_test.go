@@ -85,7 +85,7 @@ func newBenchServer(b *testing.B) *benchServer {
 
 	// configuration
 	s := &benchServer{}
-	s.Config = config.GetDefaultConfig()
+	s.SetConfig(config.GetDefaultConfig())
 	s.DisableLog = true
 	s.WebService = new(restful.WebService)
 	cacheStoreURL := s.prepareCache(b, benchCacheStore, benchName)
@@ -812,7 +812,7 @@ func BenchmarkGetRecommendCache(b *testing.B) {
 			lo.Reverse(expects)
 			err := s.CacheClient.SetSorted(ctx, cache.PopularItems, scores)
 			require.NoError(b, err)
-			s.Config.Recommend.CacheSize = len(scores)
+			s.Config().Recommend.CacheSize = len(scores)
 
 			response := make([]*resty.Response, b.N)
 			client := resty.New()
@@ -858,7 +858,7 @@ func BenchmarkRecommendFromOfflineCache(b *testing.B) {
 			lo.Reverse(expects)
 			err := s.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "init_user_1"), scores)
 			require.NoError(b, err)
-			s.Config.Recommend.CacheSize = len(scores)
+			s.Config().Recommend.CacheSize = len(scores)
 
 			response := make([]*resty.Response, b.N)
 			client := resty.New()
@@ -910,7 +910,7 @@ func BenchmarkRecommendFromLatest(b *testing.B) {
 			lo.Reverse(expects)
 			err := s.CacheClient.SetSorted(ctx, cache.LatestItems, scores)
 			require.NoError(b, err)
-			s.Config.Recommend.CacheSize = len(scores)
+			s.Config().Recommend.CacheSize = len(scores)
 
 			response := make([]*resty.Response, b.N)
 			client := resty.New()
@@ -945,7 +945,7 @@ func BenchmarkRecommendFromItemBased(b *testing.B) {
 			for i := range scores {
 				scores[i].Id = fmt.Sprintf("init_item_%d", i)
 				scores[i].Score = float64(i)
-				if i < s.Config.Recommend.Online.NumFeedbackFallbackItemBased {
+				if i < s.Config().Recommend.Online.NumFeedbackFallbackItemBased {
 					err := s.DataClient.BatchInsertFeedback(ctx, []data.Feedback{{
 						FeedbackKey: data.FeedbackKey{
 							FeedbackType: "feedback_type_positive",
@@ -959,14 +959,14 @@ func BenchmarkRecommendFromItemBased(b *testing.B) {
 			}
 
 			// insert user neighbors
-			for i := 0; i < s.Config.Recommend.Online.NumFeedbackFallbackItemBased; i++ {
+			for i := 0; i < s.Config().Recommend.Online.NumFeedbackFallbackItemBased; i++ {
 				err := s.CacheClient.SetSorted(ctx, cache.Key(cache.ItemNeighbors, fmt.Sprintf("init_item_%d", i)), scores)
 				require.NoError(b, err)
 			}
 
-			s.Config.Recommend.CacheSize = len(scores)
-			s.Config.Recommend.DataSource.PositiveFeedbackTypes = []string{"feedback_type_positive"}
-			s.Config.Recommend.Online.FallbackRecommend = []string{"item_based"}
+			s.Config().Recommend.CacheSize = len(scores)
+			s.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"feedback_type_positive"}
+			s.Config().Recommend.Online.FallbackRecommend = []string{"item_based"}
 
 			response := make([]*resty.Response, b.N)
 			client := resty.New()
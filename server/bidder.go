@@ -0,0 +1,164 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"go.uber.org/zap"
+)
+
+// BidRequest is sent to a bidder with candidates of online recommendation in ranking order.
+type BidRequest struct {
+	UserId     string
+	Category   string
+	Candidates []string
+	url        string // url of the external bidder, resolved before the request is sent
+}
+
+// Bid boosts the score of a candidate. Scores of candidates are multiplied by boosts.
+type Bid struct {
+	ItemId string
+	Boost  float64
+}
+
+// Bidder returns bids for candidates during online re-ranking. Bids for unknown candidates and non-positive boosts
+// are ignored.
+type Bidder interface {
+	Bid(ctx context.Context, request BidRequest) ([]Bid, error)
+}
+
+// HTTPBidder posts bid requests to the external bidder in the configuration.
+type HTTPBidder struct {
+	server *RestServer
+	client *http.Client
+}
+
+// NewHTTPBidder creates a bidder calling the external bidder in the configuration of the server.
+func NewHTTPBidder(s *RestServer) *HTTPBidder {
+	return &HTTPBidder{server: s, client: &http.Client{}}
+}
+
+// Bid posts the request to the bidder. No bids are returned if the bidder is not configured.
+func (b *HTTPBidder) Bid(ctx context.Context, request BidRequest) ([]Bid, error) {
+	url := request.url
+	if url == "" {
+		url = b.server.Config().Recommend.Online.BidderURL
+	}
+	if url == "" {
+		return nil, nil
+	}
+	buf, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Errorf("failed to bid: %s", resp.Status)
+	}
+	var bids []Bid
+	if err = json.NewDecoder(resp.Body).Decode(&bids); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return bids, nil
+}
+
+// applyBids re-ranks results by bids. Results are scored by reciprocal ranks multiplied by boosts. Results are left
+// unchanged if the bidder is not configured, fails or times out. Traced recommendation for debugging is not bid, so
+// that the bidder is not charged for results never served.
+func (s *RestServer) applyBids(ctx *recommendContext) {
+	if s.Bidder == nil || s.Config().Recommend.Online.BidderURL == "" || ctx.trace != nil || len(ctx.results) == 0 {
+		return
+	}
+	bids, err := s.bid(ctx)
+	if err != nil {
+		log.ResponseLogger(ctx.response).Warn("failed to bid, fallback to recommendation without bids", zap.Error(err))
+		return
+	}
+	boosts := make(map[string]float64, len(bids))
+	for _, bid := range bids {
+		if bid.Boost > 0 {
+			boosts[bid.ItemId] = bid.Boost
+		}
+	}
+	if len(boosts) == 0 {
+		return
+	}
+	scores := make([]float64, len(ctx.results))
+	order := make([]int, len(ctx.results))
+	for i, itemId := range ctx.results {
+		scores[i] = 1 / float64(i+1)
+		if boost, exist := boosts[itemId]; exist {
+			scores[i] *= boost
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	results := make([]string, len(order))
+	sources := make([]string, len(order))
+	for i, j := range order {
+		results[i], sources[i] = ctx.results[j], ctx.sources[j]
+	}
+	ctx.results, ctx.sources = results, sources
+}
+
+// bid calls the bidder with a timeout. The bidder is abandoned after the timeout even if it ignores the context, so
+// the abandoned bidder never reads the configuration.
+func (s *RestServer) bid(ctx *recommendContext) (_ []Bid, err error) {
+	defer observeStage("bidder", time.Now(), &err)
+	online := s.Config().Recommend.Online
+	bidder := s.Bidder
+	bidCtx, cancel := context.WithTimeout(ctx.context, online.BidderTimeout)
+	defer cancel()
+	request := BidRequest{
+		UserId:     ctx.userId,
+		Category:   ctx.category,
+		Candidates: append([]string(nil), ctx.results...),
+		url:        online.BidderURL,
+	}
+	type result struct {
+		bids []Bid
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		bids, err := bidder.Bid(bidCtx, request)
+		done <- result{bids: bids, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.bids, errors.Trace(r.err)
+	case <-bidCtx.Done():
+		return nil, errors.Trace(bidCtx.Err())
+	}
+}
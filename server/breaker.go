@@ -0,0 +1,104 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"go.uber.org/zap"
+)
+
+// DataStoreDegraded is the value of the degradation header if recommendation doesn't access the data store.
+const DataStoreDegraded = "data_store"
+
+// CircuitBreaker stops accessing the data store after consecutive failures. The data store is accessed again after
+// the cooldown, and the circuit breaker opens again at once if the access still fails.
+type CircuitBreaker struct {
+	server    *RestServer
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func NewCircuitBreaker(s *RestServer) *CircuitBreaker {
+	return &CircuitBreaker{server: s}
+}
+
+// Allow returns false if the circuit breaker is open.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return !time.Now().Before(cb.openUntil)
+}
+
+// Enabled returns true if the circuit breaker degrades recommendation on failures.
+func (cb *CircuitBreaker) Enabled() bool {
+	return cb.server.Config().Server.BreakerThreshold > 0
+}
+
+// Record counts consecutive failures of the data store. Not found errors are not failures of the data store.
+func (cb *CircuitBreaker) Record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err == nil || errors.Is(err, errors.NotFound) {
+		if cb.failures >= cb.server.Config().Server.BreakerThreshold && cb.Enabled() {
+			log.Logger().Info("data store recovered, close circuit breaker")
+		}
+		cb.failures = 0
+		DataStoreBreakerOpen.Set(0)
+		return
+	}
+	cb.failures++
+	if cb.Enabled() && cb.failures >= cb.server.Config().Server.BreakerThreshold {
+		if !time.Now().Before(cb.openUntil) {
+			log.Logger().Warn("data store failed, open circuit breaker",
+				zap.Int("failures", cb.failures), zap.Duration("cooldown", cb.server.Config().Server.BreakerCooldown), zap.Error(err))
+		}
+		cb.openUntil = time.Now().Add(cb.server.Config().Server.BreakerCooldown)
+		DataStoreBreakerOpen.Set(1)
+	}
+}
+
+// dataStoreAvailable returns false if the circuit breaker of the data store is open.
+func (s *RestServer) dataStoreAvailable() bool {
+	return s.DataStoreBreaker == nil || !s.DataStoreBreaker.Enabled() || s.DataStoreBreaker.Allow()
+}
+
+// dataStoreFailed records the result of accessing the data store. It returns true if the access failed and the caller
+// should degrade, otherwise errors should be returned as usual.
+func (s *RestServer) dataStoreFailed(err error) bool {
+	if s.DataStoreBreaker == nil {
+		return false
+	}
+	s.DataStoreBreaker.Record(err)
+	if err == nil || errors.Is(err, errors.NotFound) || !s.DataStoreBreaker.Enabled() {
+		return false
+	}
+	log.Logger().Warn("degrade since data store failed", zap.Error(err))
+	return true
+}
+
+// useDataStore returns true if the data store could be accessed for recommendation. Otherwise, the recommendation
+// degrades to results in the cache store.
+func (s *RestServer) useDataStore(ctx *recommendContext) bool {
+	if ctx.degraded || !s.dataStoreAvailable() {
+		ctx.degraded = true
+		return false
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+// RecordImpressions counts items returned to a user for frequency capping. It does nothing if frequency capping is
+// disabled.
+func (s *RestServer) RecordImpressions(ctx context.Context, userId string, items []string) error {
+	if s.Config().Recommend.Online.FrequencyCap <= 0 || len(items) == 0 {
+		return nil
+	}
+	now := time.Now()
+	scores := make([]cache.Scored, len(items))
+	for i, itemId := range items {
+		scores[i] = cache.Scored{Id: cache.Key(itemId, strconv.FormatInt(now.UnixNano(), 10)), Score: float64(now.Unix())}
+	}
+	key := cache.Key(cache.ItemImpressions, userId)
+	if err := s.CacheClient.AddSorted(ctx, cache.Sorted(key, scores)); err != nil {
+		return errors.Trace(err)
+	}
+	// remove impressions out of the window
+	expire := now.Add(-s.Config().Recommend.Online.FrequencyCapWindow)
+	if err := s.CacheClient.RemSortedByScore(ctx, key, math.Inf(-1), float64(expire.Unix()-1)); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// cappedItems returns items returned to a user as many times as the frequency cap in the window.
+func (s *RestServer) cappedItems(ctx context.Context, userId string) ([]string, error) {
+	if s.Config().Recommend.Online.FrequencyCap <= 0 {
+		return nil, nil
+	}
+	expire := time.Now().Add(-s.Config().Recommend.Online.FrequencyCapWindow)
+	impressions, err := s.CacheClient.GetSortedByScore(ctx, cache.Key(cache.ItemImpressions, userId), float64(expire.Unix()), math.Inf(1))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	counts := make(map[string]int)
+	var items []string
+	for _, impression := range impressions {
+		sep := strings.LastIndex(impression.Id, "/")
+		if sep < 0 {
+			continue
+		}
+		itemId := impression.Id[:sep]
+		counts[itemId]++
+		if counts[itemId] == s.Config().Recommend.Online.FrequencyCap {
+			items = append(items, itemId)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+// Category is the metadata of an item category. Categories form a hierarchy by parents, and items in a category are
+// rolled up into its ancestors, e.g., popular items of a category include popular items of its descendants. Categories
+// of items without metadata are flat categories without parents.
+type Category struct {
+	Name        string `json:"name"`
+	Parent      string `json:"parent,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// CategoryTree is the hierarchy of categories indexed by names.
+type CategoryTree map[string]Category
+
+// NewCategoryTree validates categories and builds the hierarchy. Parents must be categories in the tree and the
+// hierarchy must be acyclic.
+func NewCategoryTree(categories []Category) (CategoryTree, error) {
+	tree := make(CategoryTree, len(categories))
+	for _, category := range categories {
+		if category.Name == "" {
+			return nil, errors.NotValidf("category without name")
+		} else if _, exist := tree[category.Name]; exist {
+			return nil, errors.NotValidf("duplicate category `%s`", category.Name)
+		}
+		tree[category.Name] = category
+	}
+	for _, category := range categories {
+		visited := strset.New(category.Name)
+		for parent := category.Parent; parent != ""; parent = tree[parent].Parent {
+			if _, exist := tree[parent]; !exist {
+				return nil, errors.NotValidf("parent `%s` of category `%s`", parent, category.Name)
+			} else if visited.Has(parent) {
+				return nil, errors.NotValidf("cyclic parents of category `%s`", category.Name)
+			}
+			visited.Add(parent)
+		}
+	}
+	return tree, nil
+}
+
+// List returns categories in the tree sorted by names.
+func (tree CategoryTree) List() []Category {
+	categories := make([]Category, 0, len(tree))
+	for _, category := range tree {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories
+}
+
+// Expand returns categories and their ancestors without duplicates. Categories are followed by ancestors in order.
+func (tree CategoryTree) Expand(categories []string) []string {
+	if len(tree) == 0 {
+		return categories
+	}
+	expanded := make([]string, 0, len(categories))
+	visited := strset.New()
+	for _, category := range categories {
+		if !visited.Has(category) {
+			visited.Add(category)
+			expanded = append(expanded, category)
+		}
+	}
+	for _, category := range categories {
+		for parent := tree[category].Parent; parent != "" && !visited.Has(parent); parent = tree[parent].Parent {
+			visited.Add(parent)
+			expanded = append(expanded, parent)
+		}
+	}
+	return expanded
+}
+
+// SaveCategories validates and saves categories to the cache store.
+func SaveCategories(ctx context.Context, client cache.Database, categories []Category) error {
+	if _, err := NewCategoryTree(categories); err != nil {
+		return errors.Trace(err)
+	}
+	buf, err := cache.Marshal(client, categories)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.CategoryMetadata), buf))
+}
+
+// LoadCategories loads the hierarchy of categories from the cache store.
+func LoadCategories(ctx context.Context, client cache.Database) (CategoryTree, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.CategoryMetadata)).String()
+	if errors.Is(err, errors.NotFound) {
+		return CategoryTree{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var categories []Category
+	if err = cache.Unmarshal(buf, &categories); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return NewCategoryTree(categories)
+}
+
+// CategoryManager caches the hierarchy of categories in the server. The hierarchy is reloaded from the cache store
+// after the cache expire.
+type CategoryManager struct {
+	server     *RestServer
+	mu         sync.Mutex
+	tree       CategoryTree
+	updateTime time.Time
+}
+
+func NewCategoryManager(s *RestServer) *CategoryManager {
+	return &CategoryManager{server: s}
+}
+
+// Tree returns the hierarchy of categories.
+func (cm *CategoryManager) Tree(ctx context.Context) (CategoryTree, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if time.Since(cm.updateTime) > cm.server.Config().Server.CacheExpire {
+		tree, err := LoadCategories(ctx, cm.server.CacheClient)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cm.tree, cm.updateTime = tree, time.Now()
+	}
+	return cm.tree, nil
+}
+
+// invalidate reloads the hierarchy in the next access.
+func (cm *CategoryManager) invalidate() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.updateTime = time.Time{}
+}
+
+// categoryTree returns the hierarchy of categories to roll up categories of items. Categories are flat if there is no
+// category manager.
+func (s *RestServer) categoryTree(ctx context.Context) (CategoryTree, error) {
+	if s.CategoryManager == nil {
+		return CategoryTree{}, nil
+	}
+	return s.CategoryManager.Tree(ctx)
+}
+
+// saveCategories saves categories and invalidates the hierarchy cached in this server. Other servers apply the new
+// hierarchy after their cache expire, and roll-up lists of existed items are refreshed by the master.
+func (s *RestServer) saveCategories(ctx context.Context, categories []Category) error {
+	if err := SaveCategories(ctx, s.CacheClient, categories); err != nil {
+		return errors.Trace(err)
+	}
+	if s.CategoryManager != nil {
+		s.CategoryManager.invalidate()
+	}
+	return nil
+}
+
+func (s *RestServer) getCategories(request *restful.Request, response *restful.Response) {
+	tree, err := LoadCategories(request.Request.Context(), s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, tree.List())
+}
+
+// setCategories replaces all categories.
+func (s *RestServer) setCategories(request *restful.Request, response *restful.Response) {
+	var categories []Category
+	if err := request.ReadEntity(&categories); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	if err := s.saveCategories(request.Request.Context(), categories); errors.IsNotValid(err) {
+		BadRequest(response, err)
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: len(categories)})
+}
+
+func (s *RestServer) getCategory(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("category")
+	tree, err := LoadCategories(request.Request.Context(), s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	category, exist := tree[name]
+	if !exist {
+		PageNotFound(response, errors.NotFoundf("category %s", name))
+		return
+	}
+	Ok(response, category)
+}
+
+// putCategory inserts or replaces a category. The name in the path overrides the name in the body.
+func (s *RestServer) putCategory(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	var category Category
+	if err := request.ReadEntity(&category); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	category.Name = request.PathParameter("category")
+	tree, err := LoadCategories(ctx, s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	tree[category.Name] = category
+	if err = s.saveCategories(ctx, tree.List()); errors.IsNotValid(err) {
+		BadRequest(response, err)
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: 1})
+}
+
+// deleteCategory deletes the metadata of a category. Categories with children are not deleted.
+func (s *RestServer) deleteCategory(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	name := request.PathParameter("category")
+	tree, err := LoadCategories(ctx, s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	if _, exist := tree[name]; !exist {
+		Ok(response, Success{RowAffected: 0})
+		return
+	}
+	delete(tree, name)
+	if err = s.saveCategories(ctx, tree.List()); errors.IsNotValid(err) {
+		BadRequest(response, errors.Annotatef(err, "category %s has children", name))
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: 1})
+}
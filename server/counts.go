@@ -0,0 +1,85 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+// Counts are numbers of users, items and feedback in the data store. Counts are maintained by the master periodically,
+// so that clients don't have to page through the data store to count rows.
+type Counts struct {
+	Users         int            `json:"users"`
+	Items         int            `json:"items"`
+	Feedback      int            `json:"feedback"`
+	FeedbackTypes map[string]int `json:"feedback_types,omitempty"` // number of feedback of each type
+	Categories    map[string]int `json:"categories,omitempty"`     // number of items in each category
+	UpdateTime    time.Time      `json:"update_time"`
+}
+
+// SaveCounts saves counts of the data store to the cache store.
+func SaveCounts(ctx context.Context, client cache.Database, counts Counts) error {
+	buf, err := cache.Marshal(client, counts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.DataStoreCounts), buf))
+}
+
+// LoadCounts loads counts of the data store from the cache store. A not found error is returned if the data store has
+// not been counted.
+func LoadCounts(ctx context.Context, client cache.Database) (Counts, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.DataStoreCounts)).String()
+	if err != nil {
+		return Counts{}, errors.Trace(err)
+	}
+	var counts Counts
+	if err = cache.Unmarshal(buf, &counts); err != nil {
+		return Counts{}, errors.Trace(err)
+	}
+	return counts, nil
+}
+
+// getCounts returns numbers of users, items and feedback. Numbers of feedback of types and items in categories are
+// returned only for feedback types and categories in the query parameters.
+func (s *RestServer) getCounts(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	counts, err := LoadCounts(ctx, s.CacheClient)
+	if errors.Is(err, errors.NotFound) {
+		PageNotFound(response, errors.New("the data store has not been counted"))
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	filter := func(all map[string]int, keys []string) map[string]int {
+		if len(keys) == 0 {
+			return nil
+		}
+		filtered := make(map[string]int, len(keys))
+		for _, key := range keys {
+			filtered[key] = all[key]
+		}
+		return filtered
+	}
+	counts.FeedbackTypes = filter(counts.FeedbackTypes, request.QueryParameters("feedback-type"))
+	counts.Categories = filter(counts.Categories, request.QueryParameters("category"))
+	Ok(response, counts)
+}
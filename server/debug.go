@@ -0,0 +1,176 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sort"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"go.uber.org/zap"
+)
+
+// Reasons why candidates are filtered out from recommendation.
+const (
+	FilterHidden     = "hidden"       // the item is hidden
+	FilterIgnored    = "ignored"      // the item is ignored by the user, e.g. read
+	FilterSuppressed = "suppressed"   // the item is suppressed by negative feedback
+	FilterCapped     = "capped"       // the item reaches the frequency cap
+	FilterFeedback   = "feedback"     // the user has feedback on the item, followed by the feedback type
+	FilterOutOfRange = "out_of_range" // the item is out of the distance
+	FilterDuplicate  = "duplicate"    // the item is recommended by a previous recommender
+	FilterDropped    = "dropped"      // the item is truncated or removed by re-ranking
+)
+
+// DebugCandidate is a candidate from a recommender. Filter is the reason if the candidate is filtered out.
+type DebugCandidate struct {
+	Id     string
+	Score  float64
+	Filter string `json:",omitempty"`
+}
+
+// DebugSource is the list of candidates from a recommender. A recommender is skipped if previous recommenders have
+// recommended enough items.
+type DebugSource struct {
+	Name       string
+	Skipped    bool
+	Candidates []DebugCandidate
+}
+
+// DebugResult is an item in the final recommendation with its source.
+type DebugResult struct {
+	Id     string
+	Source string
+}
+
+// DebugRecommendation explains the online recommendation of a user.
+type DebugRecommendation struct {
+	UserId   string
+	Category string
+	Degraded bool
+	Sources  []DebugSource
+	Results  []DebugResult
+}
+
+type recommendTraceKey struct{}
+
+// recommendTrace records candidates of recommenders and reasons of excluded items.
+type recommendTrace struct {
+	sources []DebugSource
+	reasons map[string]string
+}
+
+// exclude records the reason of excluded items. The first reason of an item is kept.
+func (trace *recommendTrace) exclude(reason string, itemIds ...string) {
+	if trace == nil {
+		return
+	}
+	if trace.reasons == nil {
+		trace.reasons = make(map[string]string)
+	}
+	for _, itemId := range itemIds {
+		if _, exist := trace.reasons[itemId]; !exist {
+			trace.reasons[itemId] = reason
+		}
+	}
+}
+
+// traceCandidates records candidates of a recommender before they are added to results. It should be called before
+// candidates are filtered.
+func (s *RestServer) traceCandidates(ctx *recommendContext, source string, items []cache.Scored) {
+	if ctx.trace == nil {
+		return
+	}
+	isHidden, err := s.HiddenItemsManager.IsHidden(cache.RemoveScores(items), ctx.category)
+	if err != nil {
+		log.ResponseLogger(ctx.response).Error("failed to check hidden items", zap.Error(err))
+		isHidden = make([]bool, len(items))
+	}
+	candidates := make([]DebugCandidate, len(items))
+	for i, item := range items {
+		candidates[i] = DebugCandidate{Id: item.Id, Score: item.Score}
+		if isHidden[i] {
+			candidates[i].Filter = FilterHidden
+		} else if reason, exist := ctx.trace.reasons[item.Id]; exist {
+			candidates[i].Filter = reason
+		} else if ctx.includeSet != nil && !ctx.includeSet.Has(item.Id) {
+			candidates[i].Filter = FilterOutOfRange
+		} else if ctx.excludeSet.Has(item.Id) {
+			candidates[i].Filter = FilterDuplicate
+		}
+	}
+	ctx.trace.sources = append(ctx.trace.sources, DebugSource{Name: source, Candidates: candidates})
+}
+
+// sortedScores converts scores of candidates to a list sorted by scores from high to low.
+func sortedScores(scores map[string]float64) []cache.Scored {
+	items := make([]cache.Scored, 0, len(scores))
+	for id, score := range scores {
+		items = append(items, cache.Scored{Id: id, Score: score})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Id < items[j].Id
+	})
+	return items
+}
+
+// DebugRecommend runs the online recommendation of the user and explains it by candidates of each recommender, why
+// candidates are filtered out and the final ranking.
+func (s *RestServer) DebugRecommend(ctx context.Context, response *restful.Response, userId, category string, n int) (*DebugRecommendation, error) {
+	names, recommenders, err := s.OnlineRecommenders(ctx, userId, category)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	trace := &recommendTrace{}
+	recommendCtx, err := s.recommend(context.WithValue(ctx, recommendTraceKey{}, trace), response, userId, category, n, nil, nil, recommenders...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	debug := &DebugRecommendation{
+		UserId:   userId,
+		Category: category,
+		Degraded: recommendCtx.degraded,
+		Results:  make([]DebugResult, len(recommendCtx.results)),
+	}
+	for i, itemId := range recommendCtx.results {
+		debug.Results[i] = DebugResult{Id: itemId, Source: recommendCtx.sources[i]}
+	}
+	// candidates passing filters but not in results are truncated or removed by re-ranking
+	results := strset.New(recommendCtx.results...)
+	traced := make(map[string]DebugSource, len(trace.sources))
+	for _, source := range trace.sources {
+		for i := range source.Candidates {
+			if source.Candidates[i].Filter == "" && !results.Has(source.Candidates[i].Id) {
+				source.Candidates[i].Filter = FilterDropped
+			}
+		}
+		traced[source.Name] = source
+	}
+	for _, name := range names {
+		if source, exist := traced[name]; exist {
+			debug.Sources = append(debug.Sources, source)
+		} else {
+			debug.Sources = append(debug.Sources, DebugSource{Name: name, Skipped: true, Candidates: []DebugCandidate{}})
+		}
+	}
+	return debug, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/storage/cache"
+)
+
+// Location of a recommendation request. Items out of the radius are not recommended if the radius is positive.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Radius    float64 // in kilometers
+}
+
+// ParseLocation parses the location from the query parameters latitude, longitude and radius. Nil is returned if the
+// location is not given.
+func ParseLocation(request *restful.Request) (*Location, error) {
+	if request.QueryParameter("latitude") == "" && request.QueryParameter("longitude") == "" {
+		if request.QueryParameter("radius") != "" {
+			return nil, errors.NotValidf("radius without location")
+		}
+		return nil, nil
+	}
+	var (
+		location Location
+		err      error
+	)
+	if location.Latitude, err = strconv.ParseFloat(request.QueryParameter("latitude"), 64); err != nil {
+		return nil, errors.NotValidf("latitude `%s`", request.QueryParameter("latitude"))
+	}
+	if location.Longitude, err = strconv.ParseFloat(request.QueryParameter("longitude"), 64); err != nil {
+		return nil, errors.NotValidf("longitude `%s`", request.QueryParameter("longitude"))
+	}
+	if radius := request.QueryParameter("radius"); radius != "" {
+		if location.Radius, err = strconv.ParseFloat(radius, 64); err != nil || location.Radius < 0 {
+			return nil, errors.NotValidf("radius `%s`", radius)
+		}
+	}
+	if err = validateLocation(&location.Latitude, &location.Longitude); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &location, nil
+}
+
+// validateLocation checks that a location is either missing or complete and in range.
+func validateLocation(latitude, longitude *float64) error {
+	if latitude == nil && longitude == nil {
+		return nil
+	} else if latitude == nil || longitude == nil {
+		return errors.NotValidf("location without latitude or longitude")
+	} else if math.IsNaN(*latitude) || *latitude < -90 || *latitude > 90 {
+		return errors.NotValidf("latitude `%v`", *latitude)
+	} else if math.IsNaN(*longitude) || *longitude < -180 || *longitude > 180 {
+		return errors.NotValidf("longitude `%v`", *longitude)
+	}
+	return nil
+}
+
+// setLocation sets the location of a recommendation. Candidates are restricted to items in the radius, which are
+// searched in the geohash index.
+func (s *RestServer) setLocation(ctx *recommendContext, location *Location) error {
+	ctx.location = location
+	if location == nil || location.Radius <= 0 {
+		return nil
+	}
+	ctx.includeSet = strset.New()
+	for _, r := range cache.GeohashRanges(location.Latitude, location.Longitude, location.Radius) {
+		scores, err := s.CacheClient.GetSortedByScore(ctx.context, cache.ItemLocations, r[0], r[1])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, score := range scores {
+			latitude, longitude := cache.DecodeGeohash(score.Score)
+			if cache.GeoDistance(location.Latitude, location.Longitude, latitude, longitude) <= location.Radius {
+				ctx.includeSet.Add(score.Id)
+			}
+		}
+	}
+	return nil
+}
+
+// applyGeoBoost re-ranks results by distances to the location. Results are scored by reciprocal ranks multiplied by
+// 1 + 2^(-distance/decay), so that results without locations or far away are boosted least.
+func (s *RestServer) applyGeoBoost(ctx *recommendContext) error {
+	decay := s.Config().Recommend.Online.GeoDecayDistance
+	if ctx.location == nil || decay <= 0 || len(ctx.results) == 0 || !s.useDataStore(ctx) {
+		return nil
+	}
+	items, err := s.DataClient.BatchGetItems(ctx.context, ctx.results)
+	if s.dataStoreFailed(err) {
+		ctx.degraded = true
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	boosts := make(map[string]float64, len(items))
+	for _, item := range items {
+		if item.HasLocation() {
+			distance := cache.GeoDistance(ctx.location.Latitude, ctx.location.Longitude, *item.Latitude, *item.Longitude)
+			boosts[item.ItemId] = 1 + math.Exp2(-distance/decay)
+		}
+	}
+	scores := make([]float64, len(ctx.results))
+	order := make([]int, len(ctx.results))
+	for i, itemId := range ctx.results {
+		scores[i] = 1 / float64(i+1)
+		if boost, exist := boosts[itemId]; exist {
+			scores[i] *= boost
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	results := make([]string, len(order))
+	sources := make([]string, len(order))
+	for i, j := range order {
+		results[i], sources[i] = ctx.results[j], ctx.sources[j]
+	}
+	ctx.results, ctx.sources = results, sources
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// DependencyStatus is the health of a dependency of a node.
+type DependencyStatus struct {
+	Connected bool
+	Latency   time.Duration
+	Error     string `json:",omitempty"`
+}
+
+// NewDependencyStatus creates the status of a dependency from a request started at start and failed if err is not nil.
+func NewDependencyStatus(start time.Time, err error) DependencyStatus {
+	status := DependencyStatus{Connected: err == nil, Latency: time.Since(start)}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// HealthTracker tracks the startup of a node, syncs with the master and versions of models for health checks. The
+// zero value is ready to use.
+type HealthTracker struct {
+	mu         sync.Mutex
+	started    bool
+	lastSync   time.Time
+	master     *DependencyStatus
+	outdatedAt map[string]time.Time
+}
+
+// Start marks the node as started.
+func (h *HealthTracker) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = true
+}
+
+// Started returns true if the node has started.
+func (h *HealthTracker) Started() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.started
+}
+
+// Sync records a sync with the master, which started at start and failed if err is not nil.
+func (h *HealthTracker) Sync(start time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := NewDependencyStatus(start, err)
+	if err == nil {
+		h.lastSync = time.Now()
+	}
+	h.master = &status
+}
+
+// LastSync returns the time of the last successful sync with the master, or nil if never synced.
+func (h *HealthTracker) LastSync() *time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.lastSync.IsZero() {
+		return nil
+	}
+	lastSync := h.lastSync
+	return &lastSync
+}
+
+// Master returns the status of the connection to the master, or nil if never synced.
+func (h *HealthTracker) Master() *DependencyStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.master == nil {
+		return nil
+	}
+	status := *h.master
+	return &status
+}
+
+// TrackModel records the version of a model used by the node and the latest version on the master.
+func (h *HealthTracker) TrackModel(name string, version, latestVersion int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.outdatedAt == nil {
+		h.outdatedAt = make(map[string]time.Time)
+	}
+	if version == latestVersion {
+		h.outdatedAt[name] = time.Time{}
+	} else if outdatedAt, exist := h.outdatedAt[name]; !exist || outdatedAt.IsZero() {
+		h.outdatedAt[name] = time.Now()
+	}
+}
+
+// ModelStaleness returns how long models have been outdated since newer versions were found on the master. The
+// staleness of an up-to-date model is zero.
+func (h *HealthTracker) ModelStaleness() map[string]time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.outdatedAt) == 0 {
+		return nil
+	}
+	staleness := make(map[string]time.Duration, len(h.outdatedAt))
+	for name, outdatedAt := range h.outdatedAt {
+		if !outdatedAt.IsZero() {
+			staleness[name] = time.Since(outdatedAt)
+		} else {
+			staleness[name] = 0
+		}
+	}
+	return staleness
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// labelBatchSize is the batch size to stream users and items when labels are renamed.
+const labelBatchSize = 1000
+
+// Label is a label in the vocabulary with numbers of users and items labeled by it. Blocked labels are excluded from
+// model training.
+type Label struct {
+	Name    string `json:"name"`
+	Users   int    `json:"users"`
+	Items   int    `json:"items"`
+	Blocked bool   `json:"blocked"`
+}
+
+// LabelCounts are numbers of users and items of each label, which are counted by the master periodically.
+type LabelCounts struct {
+	Users      map[string]int `json:"users"`
+	Items      map[string]int `json:"items"`
+	UpdateTime time.Time      `json:"update_time"`
+}
+
+// SaveLabelCounts saves numbers of users and items of labels to the cache store.
+func SaveLabelCounts(ctx context.Context, client cache.Database, counts LabelCounts) error {
+	buf, err := cache.Marshal(client, counts)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.LabelCounts), buf))
+}
+
+// LoadLabelCounts loads numbers of users and items of labels from the cache store. Counts are empty if labels have not
+// been counted.
+func LoadLabelCounts(ctx context.Context, client cache.Database) (LabelCounts, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.LabelCounts)).String()
+	if errors.Is(err, errors.NotFound) {
+		return LabelCounts{}, nil
+	} else if err != nil {
+		return LabelCounts{}, errors.Trace(err)
+	}
+	var counts LabelCounts
+	if err = cache.Unmarshal(buf, &counts); err != nil {
+		return LabelCounts{}, errors.Trace(err)
+	}
+	return counts, nil
+}
+
+// LoadBlockedLabels loads labels excluded from model training.
+func LoadBlockedLabels(ctx context.Context, client cache.Database) (*strset.Set, error) {
+	labels, err := client.GetSet(ctx, cache.BlockedLabels)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return strset.New(labels...), nil
+}
+
+// LabelRename renames labels of users and items. Labels are merged into the new label if there are multiple labels to
+// rename.
+type LabelRename struct {
+	From []string `json:"from"`
+	To   string   `json:"to"`
+}
+
+// rename replaces labels to rename by the new label and removes duplicate labels. The second return value is false if
+// there is no label to rename.
+func (rename LabelRename) rename(labels []string) ([]string, bool) {
+	from := strset.New(rename.From...)
+	renamed := make([]string, 0, len(labels))
+	visited := strset.New()
+	found := false
+	for _, label := range labels {
+		if from.Has(label) {
+			label, found = rename.To, true
+		}
+		if !visited.Has(label) {
+			visited.Add(label)
+			renamed = append(renamed, label)
+		}
+	}
+	return renamed, found
+}
+
+// getLabels returns labels sorted by numbers of users and items in descending order. Blocked labels are returned even
+// if they are not used.
+func (s *RestServer) getLabels(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	n, err := ParseInt(request, "n", 100)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	offset, err := ParseInt(request, "offset", 0)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	counts, err := LoadLabelCounts(ctx, s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	blocked, err := LoadBlockedLabels(ctx, s.CacheClient)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	names := strset.New(blocked.List()...)
+	for label := range counts.Users {
+		names.Add(label)
+	}
+	for label := range counts.Items {
+		names.Add(label)
+	}
+	labels := make([]Label, 0, names.Size())
+	names.Each(func(name string) bool {
+		labels = append(labels, Label{
+			Name:    name,
+			Users:   counts.Users[name],
+			Items:   counts.Items[name],
+			Blocked: blocked.Has(name),
+		})
+		return true
+	})
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Users+labels[i].Items != labels[j].Users+labels[j].Items {
+			return labels[i].Users+labels[i].Items > labels[j].Users+labels[j].Items
+		}
+		return labels[i].Name < labels[j].Name
+	})
+	if offset >= len(labels) {
+		Ok(response, []Label{})
+		return
+	}
+	end := len(labels)
+	if n > 0 && offset+n < end {
+		end = offset + n
+	}
+	Ok(response, labels[offset:end])
+}
+
+// renameLabels renames labels of all users and items. The number of modified users and items is returned. Counts of
+// labels are updated by the master in the next count.
+func (s *RestServer) renameLabels(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	var rename LabelRename
+	if err := request.ReadEntity(&rename); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	if len(rename.From) == 0 {
+		BadRequest(response, errors.NotValidf("empty labels to rename"))
+		return
+	}
+	for _, label := range append([]string{rename.To}, rename.From...) {
+		if err := base.ValidateLabel(label); err != nil {
+			BadRequest(response, err)
+			return
+		}
+	}
+
+	// users and items are collected before modification since the data store might be locked by streams
+	var users []data.User
+	userChan, errChan := s.DataClient.GetUserStream(ctx, labelBatchSize)
+	for batch := range userChan {
+		for _, user := range batch {
+			if labels, found := rename.rename(user.Labels); found {
+				user.Labels = labels
+				users = append(users, user)
+			}
+		}
+	}
+	if err := <-errChan; err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	var items, prevItems []data.Item
+	itemChan, errChan := s.DataClient.GetItemStream(ctx, labelBatchSize, nil)
+	for batch := range itemChan {
+		for _, item := range batch {
+			if labels, found := rename.rename(item.Labels); found {
+				prevItems = append(prevItems, item)
+				item.Labels = labels
+				items = append(items, item)
+			}
+		}
+	}
+	if err := <-errChan; err != nil {
+		InternalServerError(response, err)
+		return
+	}
+
+	if len(users)+len(items) == 0 {
+		Ok(response, Success{RowAffected: 0})
+		return
+	}
+
+	// modify users
+	userIds := make([]string, 0, len(users))
+	for _, user := range users {
+		if err := s.DataClient.ModifyUser(ctx, user.UserId, data.UserPatch{Labels: user.Labels}); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		userIds = append(userIds, user.UserId)
+	}
+	if err := s.touchUsers(ctx, time.Now(), userIds...); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	// modify items and the label index
+	modification := NewCacheModification(s.CacheClient, s.HiddenItemsManager)
+	history := make([]data.ItemHistory, 0, len(items))
+	values := make([]cache.Value, 0, len(items))
+	for i, item := range items {
+		if err := s.DataClient.ModifyItem(ctx, item.ItemId, data.ItemPatch{Labels: item.Labels}); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		modification.modifyItemLabels(item.ItemId, prevItems[i].Labels, item.Labels)
+		history = append(history, data.NewItemHistory(prevItems[i], time.Now()))
+		values = append(values, cache.Time(cache.Key(cache.LastModifyItemTime, item.ItemId), time.Now()))
+	}
+	if err := s.DataClient.InsertItemHistory(ctx, history); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	if err := s.CacheClient.Set(ctx, values...); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	if err := modification.Exec(); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: len(users) + len(items)})
+}
+
+// blockLabel excludes a label from model training since the next training.
+func (s *RestServer) blockLabel(request *restful.Request, response *restful.Response) {
+	label := request.PathParameter("label")
+	if err := base.ValidateLabel(label); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	if err := s.CacheClient.AddSet(request.Request.Context(), cache.BlockedLabels, label); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: 1})
+}
+
+func (s *RestServer) unblockLabel(request *restful.Request, response *restful.Response) {
+	if err := s.CacheClient.RemSet(request.Request.Context(), cache.BlockedLabels, request.PathParameter("label")); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: 1})
+}
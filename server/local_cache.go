@@ -51,7 +51,8 @@ func LoadLocalCache(path string) (*LocalCache, error) {
 	return state, nil
 }
 
-// WriteLocalCache writes local cache to a file.
+// WriteLocalCache writes local cache to a file. The file is written to a temporary file and renamed, so that the
+// previous file is kept if writing is interrupted.
 func (s *LocalCache) WriteLocalCache() error {
 	// create parent folder if not exists
 	parent := filepath.Dir(s.path)
@@ -61,13 +62,24 @@ func (s *LocalCache) WriteLocalCache() error {
 			return errors.Trace(err)
 		}
 	}
-	// create file
-	f, err := os.Create(s.path)
+	// create temporary file
+	f, err := os.CreateTemp(parent, filepath.Base(s.path)+".*.tmp")
 	if err != nil {
 		return errors.Trace(err)
 	}
-	defer f.Close()
+	defer os.Remove(f.Name())
 	// write file
 	encoder := gob.NewEncoder(f)
-	return errors.Trace(encoder.Encode(s.ServerName))
+	if err = encoder.Encode(s.ServerName); err != nil {
+		_ = f.Close()
+		return errors.Trace(err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.Trace(err)
+	}
+	if err = f.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(f.Name(), s.path))
 }
@@ -36,6 +36,15 @@ func TestLocalCache(t *testing.T) {
 	read, err := LoadLocalCache(path)
 	assert.NoError(t, err)
 	assert.Equal(t, "Server", read.ServerName)
+	// overwrite without leaving temporary files
+	cache.ServerName = "Server2"
+	assert.NoError(t, cache.WriteLocalCache())
+	read, err = LoadLocalCache(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "Server2", read.ServerName)
+	matches, err := filepath.Glob(path + ".*.tmp")
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
 	// delete test file
 	assert.NoError(t, os.Remove(path))
 }
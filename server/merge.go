@@ -0,0 +1,155 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// maxUserRedirects is the max number of redirects followed to resolve a merged user.
+const maxUserRedirects = 10
+
+// MergeReport is the report of merging a user into another user.
+type MergeReport struct {
+	From     string
+	To       string
+	Feedback int // number of feedback reassigned to the user merged into
+	Labels   int // number of labels added to the user merged into
+}
+
+// resolveUser returns the user that a merged user is redirected to. A user not merged is returned as it is.
+func (s *RestServer) resolveUser(ctx context.Context, userId string) (string, error) {
+	for i := 0; i < maxUserRedirects; i++ {
+		to, err := s.CacheClient.Get(ctx, cache.Key(cache.UserRedirect, userId)).String()
+		if errors.Is(err, errors.NotFound) {
+			return userId, nil
+		} else if err != nil {
+			return "", errors.Trace(err)
+		}
+		userId = to
+	}
+	return userId, nil
+}
+
+// resolveFeedbackUsers replaces merged users in feedback by users they are redirected to. Users of feedback are
+// returned.
+func (s *RestServer) resolveFeedbackUsers(ctx context.Context, feedback []data.Feedback, users *strset.Set) (*strset.Set, error) {
+	redirects := make(map[string]string, users.Size())
+	resolved := strset.New()
+	for _, userId := range users.List() {
+		to, err := s.resolveUser(ctx, userId)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		redirects[userId] = to
+		resolved.Add(to)
+	}
+	for i := range feedback {
+		if to, exist := redirects[feedback[i].UserId]; exist {
+			feedback[i].UserId = to
+		}
+	}
+	return resolved, nil
+}
+
+// MergeUser merges a user into another user, e.g., an anonymous user into the account after login. Feedback of the
+// user is reassigned, labels are merged, and the user is erased and redirected to the user merged into. Feedback of
+// the user merged into is kept if both users have feedback of the same type on an item. The recommendation of the user
+// merged into is regenerated since the user is modified.
+func (s *RestServer) MergeUser(ctx context.Context, from, to string) (MergeReport, error) {
+	report := MergeReport{From: from}
+	to, err := s.resolveUser(ctx, to)
+	if err != nil {
+		return report, errors.Trace(err)
+	}
+	report.To = to
+	if from == to {
+		return report, errors.NotValidf("merge user %s into itself", from)
+	}
+	if err = s.FeedbackWAL.Flush(ctx); err != nil {
+		return report, errors.Trace(err)
+	}
+	// merge labels
+	fromUser, err := s.DataClient.GetUser(ctx, from)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return report, errors.Trace(err)
+	}
+	toUser, err := s.DataClient.GetUser(ctx, to)
+	if errors.Is(err, errors.NotFound) {
+		report.Labels = len(fromUser.Labels)
+		if err = s.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: to, Labels: fromUser.Labels}}); err != nil {
+			return report, errors.Trace(err)
+		}
+	} else if err != nil {
+		return report, errors.Trace(err)
+	} else if labels := lo.Union(toUser.Labels, fromUser.Labels); len(labels) > len(toUser.Labels) {
+		report.Labels = len(labels) - len(toUser.Labels)
+		if err = s.DataClient.ModifyUser(ctx, to, data.UserPatch{Labels: labels}); err != nil {
+			return report, errors.Trace(err)
+		}
+	}
+	// reassign feedback
+	feedback, err := s.DataClient.GetUserFeedback(ctx, from, nil)
+	if err != nil {
+		return report, errors.Trace(err)
+	}
+	merged := lo.Map(feedback, func(f data.Feedback, _ int) data.Feedback {
+		f.UserId = to
+		return f
+	})
+	if err = s.DataClient.BatchInsertFeedback(ctx, merged, false, false, false); err != nil {
+		return report, errors.Trace(err)
+	}
+	report.Feedback = len(merged)
+	if err = s.InsertFeedbackToCache(ctx, merged); err != nil {
+		return report, errors.Trace(err)
+	}
+	// erase the user and leave a redirect
+	if _, err = s.EraseUserData(ctx, from); err != nil {
+		return report, errors.Trace(err)
+	}
+	if err = s.CacheClient.Set(ctx, cache.String(cache.Key(cache.UserRedirect, from), to)); err != nil {
+		return report, errors.Trace(err)
+	}
+	return report, errors.Trace(s.touchUsers(ctx, time.Now(), to))
+}
+
+func (s *RestServer) mergeUser(request *restful.Request, response *restful.Response) {
+	from, to := request.PathParameter("from"), request.PathParameter("to")
+	for _, userId := range []string{from, to} {
+		if err := base.ValidateId(userId); err != nil {
+			BadRequest(response, err)
+			return
+		}
+	}
+	report, err := s.MergeUser(request.Request.Context(), from, to)
+	if errors.IsNotValid(err) {
+		BadRequest(response, err)
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, report)
+}
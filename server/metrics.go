@@ -25,4 +25,72 @@ var (
 		Subsystem: "server",
 		Name:      "rest_api_request_seconds",
 	}, []string{"api"})
+	RecommendSecondsVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "recommend_seconds",
+	}, []string{"status"})
+	RecommendStageSecondsVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "recommend_stage_seconds",
+	}, []string{"stage", "status"})
+	RecommendRequestsTotalVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "recommend_requests_total",
+	}, []string{"cohort", "source"})
+	APIKeyRequestsTotalVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "api_key_requests_total",
+	}, []string{"name"})
+	APIKeyWritesTotalVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "api_key_writes_total",
+	}, []string{"name"})
+	LocalCacheRequestsTotalVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "local_cache_requests_total",
+	}, []string{"result"})
+	ResultCacheRequestsTotalVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "result_cache_requests_total",
+	}, []string{"result"})
+	DegradedRecommendTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "degraded_recommend_total",
+	})
+	DataStoreBreakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "data_store_breaker_open",
+	})
+	DuplicateFeedbackTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "server",
+		Name:      "duplicate_feedback_total",
+	})
+)
+
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
 )
+
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+)
+
+// metricStatus returns the status label of an error.
+func metricStatus(err error) string {
+	if err != nil {
+		return StatusError
+	}
+	return StatusSuccess
+}
@@ -15,9 +15,14 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"strconv"
@@ -34,9 +39,11 @@ import (
 	"github.com/scylladb/go-set"
 	"github.com/scylladb/go-set/strset"
 	"github.com/thoas/go-funk"
+	"github.com/zhenghaoz/gorse/base"
 	"github.com/zhenghaoz/gorse/base/heap"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/recommender"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/emicklei/go-restful/otelrestful"
@@ -51,6 +58,8 @@ const (
 	FeedbackAPITag       = "feedback"
 	RecommendationAPITag = "recommendation"
 	MeasurementsAPITag   = "measurements"
+	StatsAPITag          = "stats"
+	LabelsAPITag         = "labels"
 	DepractedAPITag      = "deprecated"
 )
 
@@ -65,8 +74,24 @@ type RestServer struct {
 	WebService *restful.WebService
 	HttpServer *http.Server
 
-	PopularItemsCache  *PopularItemsCache
-	HiddenItemsManager *HiddenItemsManager
+	PopularItemsCache     *PopularItemsCache
+	HiddenItemsManager    *HiddenItemsManager
+	FallbackUsageTracker  *FallbackUsageTracker
+	QuotaManager          *QuotaManager
+	SourceFeedbackTracker *SourceFeedbackTracker
+	AuditLogger           *AuditLogger
+	FeedbackWAL           *FeedbackWAL
+	FeedbackDeduplicator  *FeedbackDeduplicator
+	SortedListCache       *SortedListCache
+	ResultCache           *ResultCache
+	RuleManager           *RuleManager
+	CategoryManager       *CategoryManager
+	AliasManager          *AliasManager
+	SegmentManager        *SegmentManager
+	DataStoreBreaker      *CircuitBreaker
+	Bidder                Bidder
+	RerankScript          *RerankScript
+	Health                HealthTracker
 }
 
 type ScoredItem struct {
@@ -74,6 +99,37 @@ type ScoredItem struct {
 	Score float64
 }
 
+// HydratedItem is a returned item joined with selected fields of the item.
+type HydratedItem struct {
+	ItemId     string
+	Score      *float64   `json:",omitempty"`
+	IsHidden   *bool      `json:",omitempty"`
+	Categories []string   `json:",omitempty"`
+	Timestamp  *time.Time `json:",omitempty"`
+	Labels     []string   `json:",omitempty"`
+	Comment    *string    `json:",omitempty"`
+}
+
+// HydrateFields are fields of items could be joined into returned items.
+var HydrateFields = []string{"is_hidden", "categories", "timestamp", "labels", "comment"}
+
+type ScoredUser struct {
+	data.User
+	Score float64
+}
+
+// HydratedUser is a returned user joined with selected fields of the user.
+type HydratedUser struct {
+	UserId    string
+	Score     *float64 `json:",omitempty"`
+	Labels    []string `json:",omitempty"`
+	Subscribe []string `json:",omitempty"`
+	Comment   *string  `json:",omitempty"`
+}
+
+// UserHydrateFields are fields of users could be joined into returned users.
+var UserHydrateFields = []string{"labels", "subscribe", "comment"}
+
 // StartHttpServer starts the REST-ful API server.
 func (s *RestServer) StartHttpServer(container *restful.Container) {
 	// register restful APIs
@@ -92,17 +148,18 @@ func (s *RestServer) StartHttpServer(container *restful.Container) {
 
 	// Add container filter to enable CORS
 	cors := restful.CrossOriginResourceSharing{
-		AllowedHeaders: []string{"Content-Type", "Accept", "X-API-Key"},
-		AllowedDomains: s.Config.Master.HttpCorsDomains,
-		AllowedMethods: s.Config.Master.HttpCorsMethods,
+		AllowedHeaders: []string{"Content-Type", "Accept", "X-API-Key", "If-Match", "If-None-Match", "X-Request-ID"},
+		ExposeHeaders:  []string{"ETag", NextCursorHeader, "X-Request-ID"},
+		AllowedDomains: s.Config().Master.HttpCorsDomains,
+		AllowedMethods: s.Config().Master.HttpCorsMethods,
 		CookiesAllowed: false,
 		Container:      container}
 	container.Filter(cors.Filter)
 
 	log.Logger().Info("start http server",
 		zap.String("url", fmt.Sprintf("http://%s:%d", s.HttpHost, s.HttpPort)),
-		zap.Strings("cors_methods", s.Config.Master.HttpCorsMethods),
-		zap.Strings("cors_doamins", s.Config.Master.HttpCorsDomains),
+		zap.Strings("cors_methods", s.Config().Master.HttpCorsMethods),
+		zap.Strings("cors_doamins", s.Config().Master.HttpCorsDomains),
 	)
 	s.HttpServer = &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", s.HttpHost, s.HttpPort),
@@ -114,9 +171,13 @@ func (s *RestServer) StartHttpServer(container *restful.Container) {
 }
 
 func (s *RestServer) LogFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
-	// generate request id
-	requestId := uuid.New().String()
+	// use the request id from the client or generate one
+	requestId := req.Request.Header.Get("X-Request-ID")
+	if !isValidRequestId(requestId) {
+		requestId = uuid.New().String()
+	}
 	resp.AddHeader("X-Request-ID", requestId)
+	req.Request = req.Request.WithContext(log.WithRequestId(req.Request.Context(), requestId))
 
 	start := time.Now()
 	chain.ProcessFilter(req, resp)
@@ -129,31 +190,132 @@ func (s *RestServer) LogFilter(req *restful.Request, resp *restful.Response, cha
 	}
 }
 
+// isValidRequestId returns true if a request id from a client is safe to be logged and returned.
+func isValidRequestId(requestId string) bool {
+	if len(requestId) == 0 || len(requestId) > 128 {
+		return false
+	}
+	for _, c := range requestId {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *RestServer) AuthFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
 	if strings.HasPrefix(req.SelectedRoute().Path(), "/api/health/") {
 		// Health check APIs don't need API key,
 		chain.ProcessFilter(req, resp)
 		return
 	}
-	if s.Config.Server.APIKey == "" {
+	apikey := req.HeaderParameter("X-API-Key")
+	for name, keyConfig := range s.Config().Server.APIKeys {
+		if keyConfig.Key != "" && apikey == keyConfig.Key {
+			// API keys of consumers are counted by the quota filter
+			req.SetAttribute(APIKeyNameAttribute, name)
+			chain.ProcessFilter(req, resp)
+			return
+		}
+	}
+	if s.Config().Server.APIKey == "" {
 		chain.ProcessFilter(req, resp)
 		return
 	}
-	apikey := req.HeaderParameter("X-API-Key")
-	if apikey == s.Config.Server.APIKey {
+	if apikey == s.Config().Server.APIKey {
 		chain.ProcessFilter(req, resp)
 		return
 	}
 	log.ResponseLogger(resp).Error("unauthorized",
-		zap.String("api_key", s.Config.Server.APIKey),
+		zap.String("api_key", s.Config().Server.APIKey),
 		zap.String("X-API-Key", apikey))
 	if err := resp.WriteError(http.StatusUnauthorized, fmt.Errorf("unauthorized")); err != nil {
 		log.ResponseLogger(resp).Error("failed to write error", zap.Error(err))
 	}
 }
 
+// AuditFilter writes audit logs of write operations if audit is enabled.
+func (s *RestServer) AuditFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if !s.Config().Audit.EnableAudit || req.Request.Method == http.MethodGet {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	timestamp := time.Now()
+	// the payload is restored for following filters and handlers after digested
+	var payload []byte
+	if req.Request.Body != nil {
+		var err error
+		if payload, err = io.ReadAll(req.Request.Body); err != nil {
+			BadRequest(resp, err)
+			return
+		}
+		req.Request.Body = io.NopCloser(bytes.NewReader(payload))
+	}
+	chain.ProcessFilter(req, resp)
+	actor, ok := req.Attribute(APIKeyNameAttribute).(string)
+	if !ok {
+		if actor, ok = req.Attribute(ActorAttribute).(string); !ok {
+			actor = AdminActor
+		}
+	}
+	digest := sha256.Sum256(payload)
+	if err := s.AuditLogger.Write(req.Request.Context(), data.AuditLog{
+		Timestamp: timestamp,
+		Actor:     actor,
+		Method:    req.Request.Method,
+		Path:      req.Request.URL.Path,
+		Status:    resp.StatusCode(),
+		Digest:    hex.EncodeToString(digest[:]),
+	}); err != nil {
+		log.ResponseLogger(resp).Error("failed to write audit log", zap.Error(err))
+	}
+}
+
+// QuotaFilter counts requests and written rows of API keys of consumers, and rejects requests exceeding daily quotas.
+// Written rows are unknown until requests are served, so the write quota rejects requests after it is used up.
+func (s *RestServer) QuotaFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	name, ok := req.Attribute(APIKeyNameAttribute).(string)
+	if !ok {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	keyConfig := s.Config().Server.APIKeys[name]
+	isWrite := req.Request.Method != http.MethodGet
+	if isWrite && keyConfig.DailyWrites > 0 && s.QuotaManager.writes.Count(name) >= keyConfig.DailyWrites {
+		Error(resp, http.StatusTooManyRequests, fmt.Errorf("daily write quota of %s exceeded", name))
+		return
+	}
+	if !s.QuotaManager.RecordRequest(name, keyConfig.DailyRequests) {
+		Error(resp, http.StatusTooManyRequests, fmt.Errorf("daily request quota of %s exceeded", name))
+		return
+	}
+	if !isWrite {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	// written rows are reported by write APIs to the writer
+	writer := &writeCountingWriter{ResponseWriter: resp.ResponseWriter}
+	resp.ResponseWriter = writer
+	chain.ProcessFilter(req, resp)
+	resp.ResponseWriter = writer.ResponseWriter
+	if resp.StatusCode() == http.StatusOK && writer.rows > 0 {
+		s.QuotaManager.RecordWrites(name, writer.rows)
+	}
+}
+
+// writeCountingWriter counts written rows in successful responses of write APIs.
+type writeCountingWriter struct {
+	http.ResponseWriter
+	rows int
+}
+
 func (s *RestServer) MetricsFilter(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
 	startTime := time.Now()
+	if req.SelectedRoute() != nil {
+		// attribute statements to the data store to the endpoint
+		endpoint := fmt.Sprintf("%s %s", req.Request.Method, req.SelectedRoutePath())
+		req.Request = req.Request.WithContext(data.WithEndpoint(req.Request.Context(), endpoint))
+	}
 	chain.ProcessFilter(req, resp)
 	if req.SelectedRoute() != nil && resp.StatusCode() == http.StatusOK {
 		routePath := req.SelectedRoutePath()
@@ -172,12 +334,19 @@ func (s *RestServer) CreateWebService() {
 		Produces(restful.MIME_JSON).
 		Filter(s.LogFilter).
 		Filter(s.AuthFilter).
+		Filter(s.AuditFilter).
+		Filter(s.QuotaFilter).
 		Filter(s.MetricsFilter).
 		Filter(otelrestful.OTelFilter("gorse"))
 
 	/* Health check */
+	ws.Route(ws.GET("/health/startup").To(s.checkStartup).
+		Doc("Probe the startup of this node. Return OK once the node has connected to stores and synced with the master.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{HealthAPITag}).
+		Returns(http.StatusOK, "OK", HealthStatus{}).
+		Writes(HealthStatus{}))
 	ws.Route(ws.GET("/health/live").To(s.checkLive).
-		Doc("Probe the liveness of this node. Return OK once the server starts.").
+		Doc("Probe the liveness of this node. Return OK once the server starts. Dependencies are not checked.").
 		Metadata(restfulspec.KeyOpenAPITags, []string{HealthAPITag}).
 		Returns(http.StatusOK, "OK", HealthStatus{}).
 		Writes(HealthStatus{}))
@@ -201,8 +370,10 @@ func (s *RestServer) CreateWebService() {
 		Metadata(restfulspec.KeyOpenAPITags, []string{UsersAPITag}).
 		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
 		Param(ws.PathParameter("user-id", "ID of the user to modify").DataType("string")).
+		Param(ws.HeaderParameter("If-Match", "Modify the user only if its ETag matches").DataType("string")).
 		Reads(data.UserPatch{}).
 		Returns(http.StatusOK, "OK", Success{}).
+		Returns(http.StatusPreconditionFailed, "Precondition Failed", nil).
 		Writes(Success{}))
 	// Get a user
 	ws.Route(ws.GET("/user/{user-id}").To(s.getUser).
@@ -237,6 +408,23 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("user-id", "ID of the user to delete").DataType("string")).
 		Returns(http.StatusOK, "OK", Success{}).
 		Writes(Success{}))
+	// Erase data of a user
+	ws.Route(ws.DELETE("/user/{user-id}/data").To(s.eraseUserData).
+		Doc("Erase a user, his or her feedback and all related data in cache.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{UsersAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("user-id", "ID of the user to erase").DataType("string")).
+		Returns(http.StatusOK, "OK", ErasureReport{}).
+		Writes(ErasureReport{}))
+	// Merge a user into another user
+	ws.Route(ws.POST("/user/{from}/merge/{to}").To(s.mergeUser).
+		Doc("Merge a user into another user, e.g., an anonymous user into the account after login. Feedback is reassigned, labels are merged and the merged user is redirected.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{UsersAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("from", "ID of the user to merge").DataType("string")).
+		Param(ws.PathParameter("to", "ID of the user to merge into").DataType("string")).
+		Returns(http.StatusOK, "OK", MergeReport{}).
+		Writes(MergeReport{}))
 
 	// Insert an item
 	ws.Route(ws.POST("/item").To(s.insertItem).
@@ -252,8 +440,10 @@ func (s *RestServer) CreateWebService() {
 		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
 		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
 		Param(ws.PathParameter("item-id", "ID of the item to modify").DataType("string")).
+		Param(ws.HeaderParameter("If-Match", "Modify the item only if its ETag matches").DataType("string")).
 		Reads(data.ItemPatch{}).
 		Returns(http.StatusOK, "OK", Success{}).
+		Returns(http.StatusPreconditionFailed, "Precondition Failed", nil).
 		Writes(Success{}))
 	// Get items
 	ws.Route(ws.GET("/items").To(s.getItems).
@@ -272,6 +462,15 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("item-id", "ID of the item to get.").DataType("string")).
 		Returns(http.StatusOK, "OK", data.Item{}).
 		Writes(data.Item{}))
+	// Get history of an item
+	ws.Route(ws.GET("/item/{item-id}/history").To(s.getItemHistory).
+		Doc("Get previous versions of an item from the latest to the oldest.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("item-id", "ID of the item to get.").DataType("string")).
+		Param(ws.QueryParameter("n", "Number of returned versions").DataType("integer")).
+		Returns(http.StatusOK, "OK", []data.ItemHistory{}).
+		Writes([]data.ItemHistory{}))
 	// Insert items
 	ws.Route(ws.POST("/items").To(s.insertItems).
 		Doc("Insert items. Overwrite if items exist").
@@ -306,6 +505,101 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("category", "Category to delete").DataType("string")).
 		Returns(http.StatusOK, "OK", Success{}).
 		Writes(Success{}))
+	// Category metadata
+	ws.Route(ws.GET("/categories").To(s.getCategories).
+		Doc("Get metadata and hierarchy of categories.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Returns(http.StatusOK, "OK", []Category{}).
+		Writes([]Category{}))
+	ws.Route(ws.PUT("/categories").To(s.setCategories).
+		Doc("Replace metadata and hierarchy of categories. Items in a category are rolled up into its ancestors.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Reads([]Category{}).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	ws.Route(ws.GET("/category/{category}").To(s.getCategory).
+		Doc("Get metadata of a category.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("category", "Name of the category").DataType("string")).
+		Returns(http.StatusOK, "OK", Category{}).
+		Writes(Category{}))
+	ws.Route(ws.PUT("/category/{category}").To(s.putCategory).
+		Doc("Insert or replace metadata of a category.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("category", "Name of the category").DataType("string")).
+		Reads(Category{}).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	ws.Route(ws.DELETE("/category/{category}").To(s.deleteCategory).
+		Doc("Delete metadata of a category without children.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("category", "Name of the category").DataType("string")).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	// Label vocabulary
+	ws.Route(ws.GET("/labels").To(s.getLabels).
+		Doc("Get labels with numbers of users and items, which are counted by the master periodically.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{LabelsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.QueryParameter("n", "Number of returned labels").DataType("integer")).
+		Param(ws.QueryParameter("offset", "Offset of returned labels").DataType("integer")).
+		Returns(http.StatusOK, "OK", []Label{}).
+		Writes([]Label{}))
+	ws.Route(ws.POST("/labels/rename").To(s.renameLabels).
+		Doc("Rename labels of all users and items. Multiple labels are merged into the new label.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{LabelsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Reads(LabelRename{}).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	ws.Route(ws.PUT("/label/{label}/block").To(s.blockLabel).
+		Doc("Exclude a label from model training.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{LabelsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("label", "Label to block").DataType("string")).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	ws.Route(ws.DELETE("/label/{label}/block").To(s.unblockLabel).
+		Doc("Include a blocked label in model training again.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{LabelsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("label", "Label to unblock").DataType("string")).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	// Item aliases
+	ws.Route(ws.GET("/aliases").To(s.getItemAliases).
+		Doc("Get aliases of duplicate items.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Returns(http.StatusOK, "OK", []ItemAlias{}).
+		Writes([]ItemAlias{}))
+	ws.Route(ws.GET("/alias/{item-id}").To(s.getItemAlias).
+		Doc("Get the canonical item of an alias.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("item-id", "ID of the alias").DataType("string")).
+		Returns(http.StatusOK, "OK", ItemAlias{}).
+		Writes(ItemAlias{}))
+	ws.Route(ws.PUT("/alias/{item-id}").To(s.putItemAlias).
+		Doc("Map a duplicate item to a canonical item. Feedback on the alias is merged into the canonical item, and the alias in recommendations is redirected to the canonical item.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("item-id", "ID of the alias").DataType("string")).
+		Reads(ItemAlias{}).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
+	ws.Route(ws.DELETE("/alias/{item-id}").To(s.deleteItemAlias).
+		Doc("Delete an alias. Merged feedback is kept in the canonical item.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("item-id", "ID of the alias").DataType("string")).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
 	// Insert feedback
 	ws.Route(ws.POST("/feedback").To(s.insertFeedback(false)).
 		Doc("Insert feedbacks. Ignore insertion if feedback exists.").
@@ -314,6 +608,13 @@ func (s *RestServer) CreateWebService() {
 		Reads([]data.Feedback{}).
 		Returns(http.StatusOK, "OK", Success{}).
 		Writes(Success{}))
+	ws.Route(ws.POST("/impressions").To(s.insertImpressions).
+		Doc("Insert impressions of recommended items. Existed impressions will be overwritten.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{FeedbackAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Reads([]Impression{}).
+		Returns(http.StatusOK, "OK", Success{}).
+		Writes(Success{}))
 	ws.Route(ws.PUT("/feedback").To(s.insertFeedback(true)).
 		Doc("Insert feedbacks. Existed feedback will be overwritten.").
 		Metadata(restfulspec.KeyOpenAPITags, []string{FeedbackAPITag}).
@@ -328,6 +629,11 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
 		Param(ws.QueryParameter("cursor", "Cursor for the next page").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned feedback").DataType("integer")).
+		Param(ws.QueryParameter("begin-time", "Begin time of returned feedback").DataType("string")).
+		Param(ws.QueryParameter("end-time", "End time of returned feedback, no later than now").DataType("string")).
+		Param(ws.QueryParameter("feedback-type", "Types of returned feedback, which could be repeated").DataType("string")).
+		Param(ws.QueryParameter("user-id", "User ID of returned feedback").DataType("string")).
+		Param(ws.QueryParameter("item-id", "Item ID of returned feedback").DataType("string")).
 		Returns(http.StatusOK, "OK", FeedbackIterator{}).
 		Writes(FeedbackIterator{}))
 	ws.Route(ws.GET("/feedback/{user-id}/{item-id}").To(s.getUserItemFeedback).
@@ -353,6 +659,10 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("feedback-type", "Type of returned feedbacks").DataType("string")).
 		Param(ws.QueryParameter("cursor", "Cursor for the next page").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned feedbacks").DataType("integer")).
+		Param(ws.QueryParameter("begin-time", "Begin time of returned feedback").DataType("string")).
+		Param(ws.QueryParameter("end-time", "End time of returned feedback, no later than now").DataType("string")).
+		Param(ws.QueryParameter("user-id", "User ID of returned feedback").DataType("string")).
+		Param(ws.QueryParameter("item-id", "Item ID of returned feedback").DataType("string")).
 		Returns(http.StatusOK, "OK", FeedbackIterator{}).
 		Writes(FeedbackIterator{}))
 	ws.Route(ws.GET("/feedback/{feedback-type}/{user-id}/{item-id}").To(s.getTypedUserItemFeedback).
@@ -434,8 +744,11 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned recommendations").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned recommendations").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
 		Param(ws.QueryParameter("user-id", "Remove read items of a user").DataType("string")).
 		Param(ws.QueryParameter("more-details", "If more details of items are needed").DataType("boolean")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	ws.Route(ws.GET("/popular/{category}").To(s.getPopular).
@@ -445,8 +758,11 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("category", "Category of returned items.").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
 		Param(ws.QueryParameter("user-id", "Remove read items of a user").DataType("string")).
 		Param(ws.QueryParameter("more-details", "If more details of items are needed").DataType("boolean")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	// Get latest items
@@ -456,8 +772,11 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
 		Param(ws.QueryParameter("user-id", "Remove read items of a user").DataType("string")).
 		Param(ws.QueryParameter("more-details", "If more details of items are needed").DataType("boolean")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	ws.Route(ws.GET("/latest/{category}").To(s.getLatest).
@@ -467,8 +786,11 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("category", "Category of returned items.").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
 		Param(ws.QueryParameter("user-id", "Remove read items of a user").DataType("string")).
 		Param(ws.QueryParameter("more-details", "If more details of items are needed").DataType("boolean")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	// Get neighbors
@@ -479,6 +801,9 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("item-id", "ID of the item to get neighbors").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
+		Param(ws.QueryParameter("label", "Only return items with the label").DataType("string")).
+		Param(ws.QueryParameter("share-labels", "Only return items sharing at least a label with the item").DataType("boolean")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	ws.Route(ws.GET("/item/{item-id}/neighbors/{category}").To(s.getItemNeighbors).
@@ -489,6 +814,31 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("category", "Category of returned items").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
+		Param(ws.QueryParameter("label", "Only return items with the label").DataType("string")).
+		Param(ws.QueryParameter("share-labels", "Only return items sharing at least a label with the item").DataType("boolean")).
+		Returns(http.StatusOK, "OK", []cache.Scored{}).
+		Writes([]cache.Scored{}))
+	ws.Route(ws.GET("/search/items").To(s.searchItems).
+		Doc("Search items by full text of labels and comments.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{ItemsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.QueryParameter("q", "Text to search in labels and comments of items").DataType("string")).
+		Param(ws.QueryParameter("label", "Only return items with any of the labels").DataType("string").AllowMultiple(true)).
+		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
+		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
+		Returns(http.StatusOK, "OK", []cache.Scored{}).
+		Writes([]cache.Scored{}))
+	ws.Route(ws.GET("/item/{item-id}/also-liked").To(s.getAlsoLikedItems).
+		Doc("Get items liked by users who liked a item.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{RecommendationAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("item-id", "ID of the item liked by users").DataType("string")).
+		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
+		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned items, which is returned in the X-Next-Cursor header").DataType("string")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	ws.Route(ws.GET("/user/{user-id}/neighbors/").To(s.getUserNeighbors).
@@ -498,6 +848,22 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("user-id", "ID of the user to get neighbors").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned users").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned users").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned users, which is returned in the X-Next-Cursor header").DataType("string")).
+		Param(ws.QueryParameter("hydrate", "Join user fields into returned users").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated user fields to join (labels, subscribe, comment), all fields by default").DataType("string")).
+		Returns(http.StatusOK, "OK", []cache.Scored{}).
+		Writes([]cache.Scored{}))
+	ws.Route(ws.GET("/user/{user-id}/neighbors/{category}").To(s.getUserNeighbors).
+		Doc("Get neighbors of a user interested in category.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{RecommendationAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("user-id", "ID of the user to get neighbors").DataType("string")).
+		Param(ws.PathParameter("category", "Category of items returned users have feedback on").DataType("string")).
+		Param(ws.QueryParameter("n", "Number of returned users").DataType("integer")).
+		Param(ws.QueryParameter("offset", "Offset of returned users").DataType("integer")).
+		Param(ws.QueryParameter("cursor", "Cursor to the next page of returned users, which is returned in the X-Next-Cursor header").DataType("string")).
+		Param(ws.QueryParameter("hydrate", "Join user fields into returned users").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated user fields to join (labels, subscribe, comment), all fields by default").DataType("string")).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 	ws.Route(ws.GET("/recommend/{user-id}").To(s.getRecommend).
@@ -507,9 +873,18 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("user-id", "ID of the user to get recommendation").DataType("string")).
 		Param(ws.QueryParameter("write-back-type", "Type of write back feedback").DataType("string")).
 		Param(ws.QueryParameter("write-back-delay", "Timestamp delay of write back feedback (format 0h0m0s)").DataType("string")).
+		Param(ws.QueryParameter("latitude", "Latitude of the user to boost nearby items").DataType("number")).
+		Param(ws.QueryParameter("longitude", "Longitude of the user to boost nearby items").DataType("number")).
+		Param(ws.QueryParameter("radius", "Only return items within the radius (km) of the user location").DataType("number")).
+		Param(ws.QueryParameter("hour", "Hour (0 to 23 in UTC) of the request to re-rank items by the click model").DataType("integer")).
+		Param(ws.QueryParameter("weekday", "Weekday (0 to 6 from Sunday in UTC) of the request to re-rank items by the click model").DataType("integer")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
+		Param(ws.HeaderParameter("If-None-Match", "Return 304 if the ETag of recommendation matches").DataType("string")).
 		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusNotModified, "Not Modified", nil).
 		Writes([]string{}))
 	ws.Route(ws.GET("/recommend/{user-id}/{category}").To(s.getRecommend).
 		Doc("Get recommendation for user.").
@@ -519,16 +894,45 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("category", "Category of the returned items").DataType("string")).
 		Param(ws.QueryParameter("write-back-type", "Type of write back feedback").DataType("string")).
 		Param(ws.QueryParameter("write-back-delay", "Timestamp delay of write back feedback (format 0h0m0s)").DataType("string")).
+		Param(ws.QueryParameter("latitude", "Latitude of the user to boost nearby items").DataType("number")).
+		Param(ws.QueryParameter("longitude", "Longitude of the user to boost nearby items").DataType("number")).
+		Param(ws.QueryParameter("radius", "Only return items within the radius (km) of the user location").DataType("number")).
+		Param(ws.QueryParameter("hour", "Hour (0 to 23 in UTC) of the request to re-rank items by the click model").DataType("integer")).
+		Param(ws.QueryParameter("weekday", "Weekday (0 to 6 from Sunday in UTC) of the request to re-rank items by the click model").DataType("integer")).
+		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
+		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("hydrate", "Join item fields into returned items").DataType("boolean")).
+		Param(ws.QueryParameter("fields", "Comma-separated item fields to join (is_hidden, categories, timestamp, labels, comment), all fields by default").DataType("string")).
+		Param(ws.HeaderParameter("If-None-Match", "Return 304 if the ETag of recommendation matches").DataType("string")).
+		Returns(http.StatusOK, "OK", []string{}).
+		Returns(http.StatusNotModified, "Not Modified", nil).
+		Writes([]string{}))
+	ws.Route(ws.GET("/recommend/{user-id}/context/{item-id}").To(s.contextRecommend).
+		Doc("Get recommendation for user in the context of the currently viewed item.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{RecommendationAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("user-id", "ID of the user to get recommendation").DataType("string")).
+		Param(ws.PathParameter("item-id", "ID of the currently viewed item").DataType("string")).
+		Param(ws.QueryParameter("weight", "Weight of neighbors of the viewed item (between 0 and 1)").DataType("number")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
 		Returns(http.StatusOK, "OK", []string{}).
 		Writes([]string{}))
+	ws.Route(ws.POST("/slate/{user-id}").To(s.composeSlate).
+		Doc("Compose a slate for user from multiple recommenders with quotas.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{RecommendationAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.PathParameter("user-id", "ID of the user to get recommendation").DataType("string")).
+		Reads([]SlateQuota{}).
+		Returns(http.StatusOK, "OK", []SlateItem{}).
+		Writes([]SlateItem{}))
 	ws.Route(ws.POST("/session/recommend").To(s.sessionRecommend).
 		Doc("Get recommendation for session.").
 		Metadata(restfulspec.KeyOpenAPITags, []string{RecommendationAPITag}).
 		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("session-id", "ID of the anonymous session to keep feedback in the cache store").DataType("string")).
 		Reads([]Feedback{}).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
@@ -539,10 +943,21 @@ func (s *RestServer) CreateWebService() {
 		Param(ws.PathParameter("category", "Category of the returned items").DataType("string")).
 		Param(ws.QueryParameter("n", "Number of returned items").DataType("integer")).
 		Param(ws.QueryParameter("offset", "Offset of returned items").DataType("integer")).
+		Param(ws.QueryParameter("session-id", "ID of the anonymous session to keep feedback in the cache store").DataType("string")).
 		Reads([]Feedback{}).
 		Returns(http.StatusOK, "OK", []cache.Scored{}).
 		Writes([]cache.Scored{}))
 
+	// Get counts
+	ws.Route(ws.GET("/stats/counts").To(s.getCounts).
+		Doc("Get numbers of users, items and feedback, which are counted by the master periodically.").
+		Metadata(restfulspec.KeyOpenAPITags, []string{StatsAPITag}).
+		Param(ws.HeaderParameter("X-API-Key", "API key").DataType("string")).
+		Param(ws.QueryParameter("feedback-type", "Count feedback of the type, which could be repeated").DataType("string")).
+		Param(ws.QueryParameter("category", "Count items in the category, which could be repeated").DataType("string")).
+		Returns(http.StatusOK, "OK", Counts{}).
+		Writes(Counts{}))
+
 	ws.Route(ws.GET("/measurements/{name}").To(s.getMeasurements).
 		Doc("Get measurements.").
 		Metadata(restfulspec.KeyOpenAPITags, []string{MeasurementsAPITag}).
@@ -573,7 +988,21 @@ func ParseDuration(request *restful.Request, name string) (time.Duration, error)
 	return time.ParseDuration(valueString)
 }
 
-func (s *RestServer) getSort(key, category string, isItem bool, request *restful.Request, response *restful.Response) {
+// ParseTime parses timestamp from the query parameter. Nil is returned if the parameter is empty.
+func ParseTime(request *restful.Request, name string) (*time.Time, error) {
+	valueString := request.QueryParameter(name)
+	if valueString == "" {
+		return nil, nil
+	}
+	value, err := dateparse.ParseAny(valueString)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// getSort returns a page of a sorted list. Items out of candidates are removed if candidates are not nil.
+func (s *RestServer) getSort(key, category string, isItem bool, candidates *strset.Set, request *restful.Request, response *restful.Response) {
 	var (
 		ctx    = request.Request.Context()
 		n      int
@@ -587,28 +1016,96 @@ func (s *RestServer) getSort(key, category string, isItem bool, request *restful
 		BadRequest(response, err)
 		return
 	}
-	if n, err = ParseInt(request, "n", s.Config.Server.DefaultN); err != nil {
+	if n, err = ParseInt(request, "n", s.Config().Server.DefaultN); err != nil {
 		BadRequest(response, err)
 		return
 	}
 	userId = request.QueryParameter("user-id")
 	isDetailsRequired, _ := strconv.ParseBool(request.QueryParameter("more-details"))
-
-	// Get the popular list
-	items, err := s.CacheClient.GetSorted(ctx, cache.Key(key, category), offset, s.Config.Recommend.CacheSize)
+	var hydrateFields *strset.Set
+	if isItem {
+		hydrateFields, err = ParseHydrateFields(request)
+	} else {
+		hydrateFields, err = ParseUserHydrateFields(request)
+	}
 	if err != nil {
-		InternalServerError(response, err)
+		BadRequest(response, err)
 		return
 	}
 
-	if isItem {
-		items = s.FilterOutHiddenScores(response, items, category)
+	if cursor := request.QueryParameter("cursor"); cursor != "" {
+		if offset, err = DecodeSortedCursor(cursor); err != nil {
+			BadRequest(response, err)
+			return
+		}
+	}
+
+	// Load read items
+	var readItems *strset.Set
+	if userId != "" && !isDetailsRequired {
+		feedback, err := s.DataClient.GetUserFeedback(ctx, userId, s.Config().Now())
+		if err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		readItems = strset.New()
+		for _, f := range feedback {
+			readItems.Add(f.ItemId)
+		}
+	}
+
+	// Get the sorted list
+	items, next, err := s.scanSorted(ctx, cache.Key(key, category), offset, n, func(scores []cache.Scored) []bool {
+		isKept := make([]bool, len(scores))
+		for i := range isKept {
+			isKept[i] = true
+		}
+		if isItem {
+			isHidden, err := s.HiddenItemsManager.IsHidden(cache.RemoveScores(scores), category)
+			if err != nil {
+				log.ResponseLogger(response).Error("failed to check hidden items", zap.Error(err))
+			} else {
+				for i := range isHidden {
+					isKept[i] = !isHidden[i]
+				}
+			}
+		}
+		if readItems != nil {
+			for i, score := range scores {
+				if readItems.Has(score.Id) {
+					isKept[i] = false
+				}
+			}
+		}
+		if candidates != nil {
+			for i, score := range scores {
+				if !candidates.Has(score.Id) {
+					isKept[i] = false
+				}
+			}
+		}
+		return isKept
+	})
+	if err != nil {
+		InternalServerError(response, err)
+		return
 	}
-	if n > 0 && len(items) > n {
-		items = items[:n]
+	if next > 0 {
+		response.AddHeader(NextCursorHeader, EncodeSortedCursor(next))
 	}
 
-	if isDetailsRequired {
+	if isDetailsRequired && !isItem {
+		details := make([]ScoredUser, len(items))
+		for i := range items {
+			details[i].Score = items[i].Score
+			details[i].User, err = s.DataClient.GetUser(ctx, items[i].Id)
+			if err != nil {
+				InternalServerError(response, err)
+				return
+			}
+		}
+		Ok(response, details)
+	} else if isDetailsRequired {
 		details := make([]ScoredItem, len(items))
 		for i := range items {
 			details[i].Score = items[i].Score
@@ -621,41 +1118,197 @@ func (s *RestServer) getSort(key, category string, isItem bool, request *restful
 		Ok(response, details)
 		// Send result
 	} else {
-		// Remove read items
-		if userId != "" {
-			feedback, err := s.DataClient.GetUserFeedback(ctx, userId, s.Config.Now())
+		if hydrateFields != nil && !isItem {
+			hydratedUsers, err := s.HydrateUsers(ctx, items, hydrateFields)
 			if err != nil {
 				InternalServerError(response, err)
 				return
 			}
-			readItems := strset.New()
-			for _, f := range feedback {
-				readItems.Add(f.ItemId)
-			}
-			prunedItems := make([]cache.Scored, 0, len(items))
-			for _, item := range items {
-				if !readItems.Has(item.Id) {
-					prunedItems = append(prunedItems, item)
-				}
+			Ok(response, hydratedUsers)
+			return
+		} else if hydrateFields != nil {
+			hydratedItems, err := s.HydrateItems(ctx, items, true, hydrateFields)
+			if err != nil {
+				InternalServerError(response, err)
+				return
 			}
-			items = prunedItems
+			Ok(response, hydratedItems)
+			return
 		}
 		Ok(response, items)
 	}
 
 }
 
+// NextCursorHeader is the response header of the cursor to the next page of a sorted list.
+const NextCursorHeader = "X-Next-Cursor"
+
+// EncodeSortedCursor encodes the position in a sorted list to an opaque cursor.
+func EncodeSortedCursor(position int) string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(position)))
+}
+
+// DecodeSortedCursor decodes the position in a sorted list from an opaque cursor.
+func DecodeSortedCursor(cursor string) (int, error) {
+	buf, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	position, err := strconv.Atoi(string(buf))
+	if err != nil || position < 0 {
+		return 0, errors.NotValidf("cursor %s", cursor)
+	}
+	return position, nil
+}
+
+// scanSorted scans a sorted list from the begin position until n items are kept by the filter. The position next to
+// the last scanned item is returned as well, which is 0 if the sorted list is exhausted. If n is not positive, items
+// up to the cache size are returned.
+func (s *RestServer) scanSorted(ctx context.Context, key string, begin, n int, filter func([]cache.Scored) []bool) ([]cache.Scored, int, error) {
+	if n <= 0 {
+		scores, err := s.SortedListCache.GetSorted(ctx, key, begin, s.Config().Recommend.CacheSize)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		isKept := filter(scores)
+		return lo.Filter(scores, func(_ cache.Scored, i int) bool {
+			return isKept[i]
+		}), 0, nil
+	}
+	results := make([]cache.Scored, 0, n)
+	for {
+		scores, err := s.SortedListCache.GetSorted(ctx, key, begin, begin+n-1)
+		if err != nil {
+			return nil, 0, errors.Trace(err)
+		}
+		isKept := filter(scores)
+		for i := range scores {
+			if isKept[i] {
+				results = append(results, scores[i])
+				if len(results) == n {
+					return results, begin + i + 1, nil
+				}
+			}
+		}
+		if len(scores) < n {
+			return results, 0, nil
+		}
+		begin += len(scores)
+	}
+}
+
 func (s *RestServer) getPopular(request *restful.Request, response *restful.Response) {
 	category := request.PathParameter("category")
 	log.ResponseLogger(response).Debug("get category popular items in category", zap.String("category", category))
-	s.getSort(cache.PopularItems, category, true, request, response)
+	s.getSort(cache.PopularItems, category, true, nil, request, response)
 }
 
 func (s *RestServer) getLatest(request *restful.Request, response *restful.Response) {
 	category := request.PathParameter("category")
 	log.ResponseLogger(response).Debug("get category popular items in category", zap.String("category", category))
 	log.ResponseLogger(response).Debug("get category latest items in category", zap.String("category", category))
-	s.getSort(cache.LatestItems, category, true, request, response)
+	s.getSort(cache.LatestItems, category, true, nil, request, response)
+}
+
+// ParseHydrateFields parses fields to join into returned items. Nil is returned if hydration is not required.
+func ParseHydrateFields(request *restful.Request) (*strset.Set, error) {
+	return parseHydrateFields(request, "item", HydrateFields)
+}
+
+// ParseUserHydrateFields parses fields of users to join into returned users. It returns nil if hydration is not
+// requested.
+func ParseUserHydrateFields(request *restful.Request) (*strset.Set, error) {
+	return parseHydrateFields(request, "user", UserHydrateFields)
+}
+
+func parseHydrateFields(request *restful.Request, kind string, allFields []string) (*strset.Set, error) {
+	if request.QueryParameter("hydrate") == "" {
+		return nil, nil
+	}
+	hydrate, err := strconv.ParseBool(request.QueryParameter("hydrate"))
+	if err != nil {
+		return nil, err
+	} else if !hydrate {
+		return nil, nil
+	}
+	if request.QueryParameter("fields") == "" {
+		return strset.New(allFields...), nil
+	}
+	fields := strset.New()
+	for _, field := range strings.Split(request.QueryParameter("fields"), ",") {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !lo.Contains(allFields, field) {
+			return nil, fmt.Errorf("unknown %s field `%s`", kind, field)
+		}
+		fields.Add(field)
+	}
+	return fields, nil
+}
+
+// HydrateItems joins selected fields of items into returned items. Items not found in the data store are returned
+// with their IDs only.
+func (s *RestServer) HydrateItems(ctx context.Context, scores []cache.Scored, withScore bool, fields *strset.Set) ([]HydratedItem, error) {
+	items, err := s.DataClient.BatchGetItems(ctx, cache.RemoveScores(scores))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	itemsMap := make(map[string]data.Item, len(items))
+	for _, item := range items {
+		itemsMap[item.ItemId] = item
+	}
+	hydratedItems := make([]HydratedItem, len(scores))
+	for i, score := range scores {
+		hydratedItems[i].ItemId = score.Id
+		if withScore {
+			hydratedItems[i].Score = lo.ToPtr(score.Score)
+		}
+		item, exist := itemsMap[score.Id]
+		if !exist {
+			continue
+		}
+		if fields.Has("is_hidden") {
+			hydratedItems[i].IsHidden = lo.ToPtr(item.IsHidden)
+		}
+		if fields.Has("categories") {
+			hydratedItems[i].Categories = item.Categories
+		}
+		if fields.Has("timestamp") {
+			hydratedItems[i].Timestamp = lo.ToPtr(item.Timestamp)
+		}
+		if fields.Has("labels") {
+			hydratedItems[i].Labels = item.Labels
+		}
+		if fields.Has("comment") {
+			hydratedItems[i].Comment = lo.ToPtr(item.Comment)
+		}
+	}
+	return hydratedItems, nil
+}
+
+// HydrateUsers joins selected fields of users into returned users. Users not found in the data store are returned
+// with their IDs only.
+func (s *RestServer) HydrateUsers(ctx context.Context, scores []cache.Scored, fields *strset.Set) ([]HydratedUser, error) {
+	hydratedUsers := make([]HydratedUser, len(scores))
+	for i, score := range scores {
+		hydratedUsers[i].UserId = score.Id
+		hydratedUsers[i].Score = lo.ToPtr(score.Score)
+		user, err := s.DataClient.GetUser(ctx, score.Id)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if fields.Has("labels") {
+			hydratedUsers[i].Labels = user.Labels
+		}
+		if fields.Has("subscribe") {
+			hydratedUsers[i].Subscribe = user.Subscribe
+		}
+		if fields.Has("comment") {
+			hydratedUsers[i].Comment = lo.ToPtr(user.Comment)
+		}
+	}
+	return hydratedUsers, nil
 }
 
 // get feedback by item-id with feedback type
@@ -694,22 +1347,76 @@ func (s *RestServer) getItemNeighbors(request *restful.Request, response *restfu
 	// Get item id
 	itemId := request.PathParameter("item-id")
 	category := request.PathParameter("category")
-	s.getSort(cache.Key(cache.ItemNeighbors, itemId), category, true, request, response)
-}
-
-// getUserNeighbors gets neighbors of a user from database.
-func (s *RestServer) getUserNeighbors(request *restful.Request, response *restful.Response) {
-	// Get item id
-	userId := request.PathParameter("user-id")
-	s.getSort(cache.Key(cache.UserNeighbors, userId), "", false, request, response)
-}
-
-// getCollaborative gets cached recommended items from database.
-func (s *RestServer) getCollaborative(request *restful.Request, response *restful.Response) {
-	// Get user id
-	userId := request.PathParameter("user-id")
-	category := request.PathParameter("category")
-	s.getSort(cache.Key(cache.OfflineRecommend, userId), category, true, request, response)
+	// restrict neighbors to items with labels
+	var labels []string
+	if label := request.QueryParameter("label"); label != "" {
+		labels = []string{label}
+	} else if shareLabels, _ := strconv.ParseBool(request.QueryParameter("share-labels")); shareLabels {
+		item, err := s.DataClient.GetItem(request.Request.Context(), itemId)
+		if errors.Is(err, errors.NotFound) {
+			PageNotFound(response, err)
+			return
+		} else if err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		if labels = item.Labels; len(labels) == 0 {
+			Ok(response, []cache.Scored{})
+			return
+		}
+	}
+	var candidates *strset.Set
+	if labels != nil {
+		var err error
+		if candidates, err = s.labeledItems(request.Request.Context(), labels); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+	}
+	s.getSort(cache.Key(cache.ItemNeighbors, itemId), category, true, candidates, request, response)
+}
+
+// getAlsoLikedItems gets items liked by users who liked the item, which are updated by the master.
+func (s *RestServer) getAlsoLikedItems(request *restful.Request, response *restful.Response) {
+	itemId := request.PathParameter("item-id")
+	s.getSort(cache.Key(cache.AlsoLikedItems, itemId), "", true, nil, request, response)
+}
+
+// labeledItems returns items with any of the labels. Items are searched in the search store if configured, otherwise
+// collected from label indices in the cache store.
+func (s *RestServer) labeledItems(ctx context.Context, labels []string) (*strset.Set, error) {
+	if s.SearchClient != nil {
+		items, err := s.searchLabeledItems(ctx, labels)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return strset.New(items...), nil
+	}
+	items := strset.New()
+	for _, label := range labels {
+		scores, err := s.CacheClient.GetSorted(ctx, cache.Key(cache.LabeledItems, label), 0, -1)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		items.Add(cache.RemoveScores(scores)...)
+	}
+	return items, nil
+}
+
+// getUserNeighbors gets neighbors of a user from database.
+func (s *RestServer) getUserNeighbors(request *restful.Request, response *restful.Response) {
+	// Get item id
+	userId := request.PathParameter("user-id")
+	category := request.PathParameter("category")
+	s.getSort(cache.Key(cache.UserNeighbors, userId), category, false, nil, request, response)
+}
+
+// getCollaborative gets cached recommended items from database.
+func (s *RestServer) getCollaborative(request *restful.Request, response *restful.Response) {
+	// Get user id
+	userId := request.PathParameter("user-id")
+	category := request.PathParameter("category")
+	s.getSort(cache.Key(cache.OfflineRecommend, userId), category, true, nil, request, response)
 }
 
 // Recommend items to users.
@@ -717,13 +1424,32 @@ func (s *RestServer) getCollaborative(request *restful.Request, response *restfu
 // 2. If there are historical interactions of the users, return similar items.
 // 3. Otherwise, return fallback recommendation (popular/latest).
 func (s *RestServer) Recommend(ctx context.Context, response *restful.Response, userId, category string, n int, recommenders ...Recommender) ([]string, error) {
+	recommendCtx, err := s.recommend(ctx, response, userId, category, n, nil, nil, recommenders...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return recommendCtx.results, nil
+}
+
+func (s *RestServer) recommend(ctx context.Context, response *restful.Response, userId, category string, n int, location *Location, timeContext *TimeContext, recommenders ...Recommender) (_ *recommendContext, err error) {
 	initStart := time.Now()
+	defer func() {
+		RecommendSecondsVec.WithLabelValues(metricStatus(err)).Observe(time.Since(initStart).Seconds())
+	}()
 
-	// create context
+	// create context, merged users are redirected
+	if userId, err = s.resolveUser(ctx, userId); err != nil {
+		return nil, errors.Trace(err)
+	}
 	recommendCtx, err := s.createRecommendContext(ctx, userId, category, n)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	recommendCtx.response = response
+	recommendCtx.timeContext = timeContext
+	if err = s.setLocation(recommendCtx, location); err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	// execute recommenders
 	for _, recommender := range recommenders {
@@ -732,10 +1458,33 @@ func (s *RestServer) Recommend(ctx context.Context, response *restful.Response,
 			return nil, errors.Trace(err)
 		}
 	}
+	if err = s.applyAliases(recommendCtx); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// re-rank by time contexts, distances, bids, business rules and the script
+	if err = s.applyTimeContext(recommendCtx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = s.applyGeoBoost(recommendCtx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	s.applyBids(recommendCtx)
+	if err = s.applyRules(recommendCtx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = s.applyRerankScript(recommendCtx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if recommendCtx.degraded {
+		response.AddHeader("X-Degraded", DataStoreDegraded)
+		DegradedRecommendTotal.Inc()
+	}
 
 	// return recommendations
 	if len(recommendCtx.results) > n {
 		recommendCtx.results = recommendCtx.results[:n]
+		recommendCtx.sources = recommendCtx.sources[:n]
 	}
 	totalTime := time.Since(initStart)
 	log.ResponseLogger(response).Info("complete recommendation",
@@ -745,6 +1494,7 @@ func (s *RestServer) Recommend(ctx context.Context, response *restful.Response,
 		zap.Int("num_from_user_based", recommendCtx.numFromUserBased),
 		zap.Int("num_from_latest", recommendCtx.numFromLatest),
 		zap.Int("num_from_poplar", recommendCtx.numFromPopular),
+		zap.Bool("degraded", recommendCtx.degraded),
 		zap.Duration("total_time", totalTime),
 		zap.Duration("load_final_recommend_time", recommendCtx.loadOfflineRecTime),
 		zap.Duration("load_col_recommend_time", recommendCtx.loadColRecTime),
@@ -753,7 +1503,67 @@ func (s *RestServer) Recommend(ctx context.Context, response *restful.Response,
 		zap.Duration("user_based_recommend_time", recommendCtx.userBasedTime),
 		zap.Duration("load_latest_time", recommendCtx.loadLatestTime),
 		zap.Duration("load_popular_time", recommendCtx.loadPopularTime))
-	return recommendCtx.results, nil
+	return recommendCtx, nil
+}
+
+// isCandidate returns true if the item could be added to results.
+func (ctx *recommendContext) isCandidate(itemId string) bool {
+	return !ctx.excludeSet.Has(itemId) && (ctx.includeSet == nil || ctx.includeSet.Has(itemId))
+}
+
+// RuleSource is the source of items inserted by business rules.
+const RuleSource = "rule"
+
+// ColdStartSource is the source of cold-start items injected into offline recommendation.
+const ColdStartSource = "cold_start"
+
+// RecommendSources are names of recommenders in online recommendation.
+var RecommendSources = []string{"offline", "collaborative", "item_based", "user_based", "latest", "popular", RuleSource, ColdStartSource}
+
+// observeStage records the latency of a recommender to the stage histogram. It should be deferred at the beginning of
+// the recommender.
+func observeStage(stage string, start time.Time, err *error) {
+	RecommendStageSecondsVec.WithLabelValues(stage, metricStatus(*err)).Observe(time.Since(start).Seconds())
+}
+
+// endStage attributes results added by the current recommender to the source and returns the number of them.
+func (ctx *recommendContext) endStage(source string) int {
+	num := len(ctx.results) - ctx.numPrevStage
+	for i := 0; i < num; i++ {
+		ctx.sources = append(ctx.sources, source)
+	}
+	ctx.numPrevStage = len(ctx.results)
+	return num
+}
+
+// source returns the name of the recommender contributing most of the results.
+func (ctx *recommendContext) source() string {
+	counts := lo.CountValues(ctx.sources)
+	source, num := "none", 0
+	for _, candidate := range RecommendSources {
+		if counts[candidate] > num {
+			source, num = candidate, counts[candidate]
+		}
+	}
+	return source
+}
+
+// userCohort classifies a user into new users (never generated offline recommendation), active users and dormant
+// users (inactive for longer than the dormant user threshold).
+func (s *RestServer) userCohort(ctx context.Context, userId string) (string, error) {
+	if _, err := s.CacheClient.Get(ctx, cache.Key(cache.LastUpdateUserRecommendTime, userId)).Time(); errors.Is(err, errors.NotFound) {
+		return NewUserCohort, nil
+	} else if err != nil {
+		return "", errors.Trace(err)
+	}
+	activeTime, err := s.CacheClient.Get(ctx, cache.Key(cache.LastModifyUserTime, userId)).Time()
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return "", errors.Trace(err)
+	}
+	if time.Since(activeTime) > s.Config().Recommend.Online.DormantUserThreshold {
+		return DormantUserCohort, nil
+	}
+	return ActiveUserCohort, nil
 }
 
 type recommendContext struct {
@@ -764,7 +1574,13 @@ type recommendContext struct {
 	userFeedback []data.Feedback
 	n            int
 	results      []string
+	sources      []string // sources of results
 	excludeSet   *strset.Set
+	includeSet   *strset.Set // candidates are restricted to the set if not nil
+	location     *Location
+	timeContext  *TimeContext
+	degraded     bool            // results are served without the data store
+	trace        *recommendTrace // candidates of recommenders are traced if not nil
 
 	numPrevStage         int
 	numFromLatest        int
@@ -786,33 +1602,65 @@ type recommendContext struct {
 func (s *RestServer) createRecommendContext(ctx context.Context, userId, category string, n int) (*recommendContext, error) {
 	// pull ignored items
 	ignoreItems, err := s.CacheClient.GetSortedByScore(ctx, cache.Key(cache.IgnoreItems, userId),
-		math.Inf(-1), float64(time.Now().Add(s.Config.Server.ClockError).Unix()))
+		math.Inf(-1), float64(time.Now().Add(s.Config().Server.ClockError).Unix()))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	trace, _ := ctx.Value(recommendTraceKey{}).(*recommendTrace)
 	excludeSet := strset.New()
 	for _, item := range ignoreItems {
 		excludeSet.Add(item.Id)
+		trace.exclude(FilterIgnored, item.Id)
+	}
+	// pull suppressed items
+	if len(s.Config().Recommend.DataSource.NegativeFeedbackTTL) > 0 {
+		suppressedItems, err := s.CacheClient.GetSortedByScore(ctx, cache.Key(cache.SuppressedItems, userId),
+			float64(time.Now().Unix()), math.Inf(1))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, item := range suppressedItems {
+			excludeSet.Add(item.Id)
+			trace.exclude(FilterSuppressed, item.Id)
+		}
 	}
+	// pull items reaching the frequency cap
+	cappedItems, err := s.cappedItems(ctx, userId)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	excludeSet.Add(cappedItems...)
+	trace.exclude(FilterCapped, cappedItems...)
 	return &recommendContext{
 		userId:     userId,
 		category:   category,
 		n:          n,
 		excludeSet: excludeSet,
 		context:    ctx,
+		trace:      trace,
 	}, nil
 }
 
+// requireUserFeedback loads feedback of the user and excludes items in the feedback. Feedback is left empty if the
+// recommendation degrades, so that read items might be recommended.
 func (s *RestServer) requireUserFeedback(ctx *recommendContext) error {
 	if ctx.userFeedback == nil {
+		if !s.useDataStore(ctx) {
+			ctx.userFeedback = []data.Feedback{}
+			return nil
+		}
 		start := time.Now()
 		var err error
-		ctx.userFeedback, err = s.DataClient.GetUserFeedback(ctx.context, ctx.userId, s.Config.Now())
-		if err != nil {
+		ctx.userFeedback, err = s.DataClient.GetUserFeedback(ctx.context, ctx.userId, s.Config().Now())
+		if s.dataStoreFailed(err) {
+			ctx.userFeedback, ctx.degraded = []data.Feedback{}, true
+			return nil
+		} else if err != nil {
 			return errors.Trace(err)
 		}
 		for _, feedback := range ctx.userFeedback {
 			ctx.excludeSet.Add(feedback.ItemId)
+			ctx.trace.exclude(FilterFeedback+":"+feedback.FeedbackType, feedback.ItemId)
 		}
 		ctx.loadLoadHistTime = time.Since(start)
 	}
@@ -855,81 +1703,127 @@ func (s *RestServer) filterOutHiddenFeedback(response *restful.Response, feedbac
 
 type Recommender func(ctx *recommendContext) error
 
-func (s *RestServer) RecommendOffline(ctx *recommendContext) error {
+func (s *RestServer) RecommendOffline(ctx *recommendContext) (err error) {
 	if len(ctx.results) < ctx.n {
+		defer observeStage("offline", time.Now(), &err)
 		start := time.Now()
-		recommendation, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.OfflineRecommend, ctx.userId, ctx.category), 0, s.Config.Recommend.CacheSize)
+		recommendation, err := s.SortedListCache.GetSorted(ctx.context, cache.Key(cache.OfflineRecommend, ctx.userId, ctx.category), 0, s.Config().Recommend.CacheSize)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		s.traceCandidates(ctx, "offline", recommendation)
 		recommendation = s.FilterOutHiddenScores(ctx.response, recommendation, ctx.category)
 		for _, item := range recommendation {
-			if !ctx.excludeSet.Has(item.Id) {
+			if ctx.isCandidate(item.Id) {
 				ctx.results = append(ctx.results, item.Id)
 				ctx.excludeSet.Add(item.Id)
 			}
 		}
 		ctx.loadOfflineRecTime = time.Since(start)
-		ctx.numFromOffline = len(ctx.results) - ctx.numPrevStage
-		ctx.numPrevStage = len(ctx.results)
+		begin := ctx.numPrevStage
+		ctx.numFromOffline = ctx.endStage("offline")
+		if err := s.attributeColdStart(ctx, begin); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// attributeColdStart attributes cold-start items in offline recommendation since the beginning to the cold-start
+// source, so that exploration of new items is measured separately.
+func (s *RestServer) attributeColdStart(ctx *recommendContext, begin int) error {
+	if threshold, exist := s.Config().Recommend.Offline.GetExploreRecommend("cold_start"); !exist || threshold <= 0 || begin == len(ctx.results) {
+		return nil
+	}
+	coldStartItems, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.ColdStartItems, ctx.category), 0, -1)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	coldStartSet := strset.New(cache.RemoveScores(coldStartItems)...)
+	for i := begin; i < len(ctx.results); i++ {
+		if coldStartSet.Has(ctx.results[i]) {
+			ctx.sources[i] = ColdStartSource
+		}
 	}
 	return nil
 }
 
-func (s *RestServer) RecommendCollaborative(ctx *recommendContext) error {
+func (s *RestServer) RecommendCollaborative(ctx *recommendContext) (err error) {
 	if len(ctx.results) < ctx.n {
+		defer observeStage("collaborative", time.Now(), &err)
 		start := time.Now()
-		collaborativeRecommendation, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.CollaborativeRecommend, ctx.userId, ctx.category), 0, s.Config.Recommend.CacheSize)
+		collaborativeRecommendation, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.CollaborativeRecommend, ctx.userId, ctx.category), 0, s.Config().Recommend.CacheSize)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		s.traceCandidates(ctx, "collaborative", collaborativeRecommendation)
 		collaborativeRecommendation = s.FilterOutHiddenScores(ctx.response, collaborativeRecommendation, ctx.category)
 		for _, item := range collaborativeRecommendation {
-			if !ctx.excludeSet.Has(item.Id) {
+			if ctx.isCandidate(item.Id) {
 				ctx.results = append(ctx.results, item.Id)
 				ctx.excludeSet.Add(item.Id)
 			}
 		}
 		ctx.loadColRecTime = time.Since(start)
-		ctx.numFromCollaborative = len(ctx.results) - ctx.numPrevStage
-		ctx.numPrevStage = len(ctx.results)
+		ctx.numFromCollaborative = ctx.endStage("collaborative")
 	}
 	return nil
 }
 
-func (s *RestServer) RecommendUserBased(ctx *recommendContext) error {
+func (s *RestServer) RecommendUserBased(ctx *recommendContext) (err error) {
 	if len(ctx.results) < ctx.n {
+		defer observeStage("user_based", time.Now(), &err)
 		err := s.requireUserFeedback(ctx)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		if !s.useDataStore(ctx) {
+			return nil
+		}
 		start := time.Now()
 		candidates := make(map[string]float64)
+		traced := make(map[string]float64)
 		// load similar users
-		similarUsers, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.UserNeighbors, ctx.userId), 0, s.Config.Recommend.CacheSize)
+		similarUsers, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.UserNeighbors, ctx.userId), 0, s.Config().Recommend.CacheSize)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tree, err := s.categoryTree(ctx.context)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		for _, user := range similarUsers {
 			// load historical feedback
-			feedbacks, err := s.DataClient.GetUserFeedback(ctx.context, user.Id, s.Config.Now(), s.Config.Recommend.DataSource.PositiveFeedbackTypes...)
-			if err != nil {
+			feedbacks, err := s.DataClient.GetUserFeedback(ctx.context, user.Id, s.Config().Now(), s.Config().Recommend.DataSource.PositiveFeedbackTypes...)
+			if s.dataStoreFailed(err) {
+				ctx.degraded = true
+				return nil
+			} else if err != nil {
 				return errors.Trace(err)
 			}
+			if ctx.trace != nil {
+				for _, feedback := range feedbacks {
+					traced[feedback.ItemId] += user.Score
+				}
+			}
 			feedbacks = s.filterOutHiddenFeedback(ctx.response, feedbacks)
 			// add unseen items
 			for _, feedback := range feedbacks {
-				if !ctx.excludeSet.Has(feedback.ItemId) {
+				if ctx.isCandidate(feedback.ItemId) {
 					item, err := s.DataClient.GetItem(ctx.context, feedback.ItemId)
-					if err != nil {
+					if s.dataStoreFailed(err) {
+						ctx.degraded = true
+						return nil
+					} else if err != nil {
 						return errors.Trace(err)
 					}
-					if ctx.category == "" || funk.ContainsString(item.Categories, ctx.category) {
+					if ctx.category == "" || funk.ContainsString(tree.Expand(item.Categories), ctx.category) {
 						candidates[feedback.ItemId] += user.Score
 					}
 				}
 			}
 		}
+		s.traceCandidates(ctx, "user_based", sortedScores(traced))
 		// collect top k
 		k := ctx.n - len(ctx.results)
 		filter := heap.NewTopKFilter[string, float64](k)
@@ -940,14 +1834,14 @@ func (s *RestServer) RecommendUserBased(ctx *recommendContext) error {
 		ctx.results = append(ctx.results, ids...)
 		ctx.excludeSet.Add(ids...)
 		ctx.userBasedTime = time.Since(start)
-		ctx.numFromUserBased = len(ctx.results) - ctx.numPrevStage
-		ctx.numPrevStage = len(ctx.results)
+		ctx.numFromUserBased = ctx.endStage("user_based")
 	}
 	return nil
 }
 
-func (s *RestServer) RecommendItemBased(ctx *recommendContext) error {
+func (s *RestServer) RecommendItemBased(ctx *recommendContext) (err error) {
 	if len(ctx.results) < ctx.n {
+		defer observeStage("item_based", time.Now(), &err)
 		err := s.requireUserFeedback(ctx)
 		if err != nil {
 			return errors.Trace(err)
@@ -955,31 +1849,38 @@ func (s *RestServer) RecommendItemBased(ctx *recommendContext) error {
 		start := time.Now()
 		// truncate user feedback
 		data.SortFeedbacks(ctx.userFeedback)
-		userFeedback := make([]data.Feedback, 0, s.Config.Recommend.Online.NumFeedbackFallbackItemBased)
+		userFeedback := make([]data.Feedback, 0, s.Config().Recommend.Online.NumFeedbackFallbackItemBased)
 		for _, feedback := range ctx.userFeedback {
-			if s.Config.Recommend.Online.NumFeedbackFallbackItemBased <= len(userFeedback) {
+			if s.Config().Recommend.Online.NumFeedbackFallbackItemBased <= len(userFeedback) {
 				break
 			}
-			if funk.ContainsString(s.Config.Recommend.DataSource.PositiveFeedbackTypes, feedback.FeedbackType) {
+			if funk.ContainsString(s.Config().Recommend.DataSource.PositiveFeedbackTypes, feedback.FeedbackType) {
 				userFeedback = append(userFeedback, feedback)
 			}
 		}
 		// collect candidates
 		candidates := make(map[string]float64)
+		traced := make(map[string]float64)
 		for _, feedback := range userFeedback {
 			// load similar items
-			similarItems, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.ItemNeighbors, feedback.ItemId, ctx.category), 0, s.Config.Recommend.CacheSize)
+			similarItems, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.ItemNeighbors, feedback.ItemId, ctx.category), 0, s.Config().Recommend.CacheSize)
 			if err != nil {
 				return errors.Trace(err)
 			}
+			if ctx.trace != nil {
+				for _, item := range similarItems {
+					traced[item.Id] += item.Score
+				}
+			}
 			// add unseen items
 			similarItems = s.FilterOutHiddenScores(ctx.response, similarItems, ctx.category)
 			for _, item := range similarItems {
-				if !ctx.excludeSet.Has(item.Id) {
+				if ctx.isCandidate(item.Id) {
 					candidates[item.Id] += item.Score
 				}
 			}
 		}
+		s.traceCandidates(ctx, "item_based", sortedScores(traced))
 		// collect top k
 		k := ctx.n - len(ctx.results)
 		filter := heap.NewTopKFilter[string, float64](k)
@@ -990,62 +1891,131 @@ func (s *RestServer) RecommendItemBased(ctx *recommendContext) error {
 		ctx.results = append(ctx.results, ids...)
 		ctx.excludeSet.Add(ids...)
 		ctx.itemBasedTime = time.Since(start)
-		ctx.numFromItemBased = len(ctx.results) - ctx.numPrevStage
-		ctx.numPrevStage = len(ctx.results)
+		ctx.numFromItemBased = ctx.endStage("item_based")
 	}
 	return nil
 }
 
-func (s *RestServer) RecommendLatest(ctx *recommendContext) error {
+func (s *RestServer) RecommendLatest(ctx *recommendContext) (err error) {
 	if len(ctx.results) < ctx.n {
+		defer observeStage("latest", time.Now(), &err)
 		err := s.requireUserFeedback(ctx)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		start := time.Now()
-		items, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.LatestItems, ctx.category), 0, s.Config.Recommend.CacheSize)
+		items, err := s.SortedListCache.GetSorted(ctx.context, cache.Key(cache.LatestItems, ctx.category), 0, s.Config().Recommend.CacheSize)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		s.traceCandidates(ctx, "latest", items)
 		items = s.FilterOutHiddenScores(ctx.response, items, ctx.category)
 		for _, item := range items {
-			if !ctx.excludeSet.Has(item.Id) {
+			if ctx.isCandidate(item.Id) {
 				ctx.results = append(ctx.results, item.Id)
 				ctx.excludeSet.Add(item.Id)
 			}
 		}
 		ctx.loadLatestTime = time.Since(start)
-		ctx.numFromLatest = len(ctx.results) - ctx.numPrevStage
-		ctx.numPrevStage = len(ctx.results)
+		ctx.numFromLatest = ctx.endStage("latest")
 	}
 	return nil
 }
 
-func (s *RestServer) RecommendPopular(ctx *recommendContext) error {
+func (s *RestServer) RecommendPopular(ctx *recommendContext) (err error) {
 	if len(ctx.results) < ctx.n {
+		defer observeStage("popular", time.Now(), &err)
 		err := s.requireUserFeedback(ctx)
 		if err != nil {
 			return errors.Trace(err)
 		}
 		start := time.Now()
-		items, err := s.CacheClient.GetSorted(ctx.context, cache.Key(cache.PopularItems, ctx.category), 0, s.Config.Recommend.CacheSize)
+		items, err := s.SortedListCache.GetSorted(ctx.context, cache.Key(cache.PopularItems, ctx.category), 0, s.Config().Recommend.CacheSize)
 		if err != nil {
 			return errors.Trace(err)
 		}
+		s.traceCandidates(ctx, "popular", items)
 		items = s.FilterOutHiddenScores(ctx.response, items, ctx.category)
 		for _, item := range items {
-			if !ctx.excludeSet.Has(item.Id) {
+			if ctx.isCandidate(item.Id) {
 				ctx.results = append(ctx.results, item.Id)
 				ctx.excludeSet.Add(item.Id)
 			}
 		}
 		ctx.loadPopularTime = time.Since(start)
-		ctx.numFromPopular = len(ctx.results) - ctx.numPrevStage
-		ctx.numPrevStage = len(ctx.results)
+		ctx.numFromPopular = ctx.endStage("popular")
 	}
 	return nil
 }
 
+// RecommendCustom creates a recommender from a registered custom recommender. Items are attributed to the name of the
+// custom recommender.
+func (s *RestServer) RecommendCustom(name string, custom recommender.Recommender) Recommender {
+	return func(ctx *recommendContext) (err error) {
+		if len(ctx.results) < ctx.n {
+			defer observeStage(name, time.Now(), &err)
+			err := s.requireUserFeedback(ctx)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			items, err := custom.Recommend(ctx.context, &recommender.Request{
+				Settings: s.Settings,
+				UserId:   ctx.userId,
+				Category: ctx.category,
+				N:        s.Config().Recommend.CacheSize,
+			})
+			if err != nil {
+				return errors.Trace(err)
+			}
+			s.traceCandidates(ctx, name, items)
+			items = s.FilterOutHiddenScores(ctx.response, items, ctx.category)
+			for _, item := range items {
+				if ctx.isCandidate(item.Id) {
+					ctx.results = append(ctx.results, item.Id)
+					ctx.excludeSet.Add(item.Id)
+				}
+			}
+			ctx.endStage(name)
+		}
+		return nil
+	}
+}
+
+// OnlineRecommenders returns names of recommenders and recommenders for online recommendation of the user, which are
+// offline recommendation followed by fallback recommenders.
+func (s *RestServer) OnlineRecommenders(ctx context.Context, userId, category string) ([]string, []Recommender, error) {
+	fallbackRecommenders, err := s.FallbackRecommend(ctx, userId, category)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	names := []string{"offline"}
+	recommenders := []Recommender{s.RecommendOffline}
+	for _, name := range fallbackRecommenders {
+		switch name {
+		case "collaborative":
+			recommenders = append(recommenders, s.RecommendCollaborative)
+		case "item_based":
+			recommenders = append(recommenders, s.RecommendItemBased)
+		case "user_based":
+			recommenders = append(recommenders, s.RecommendUserBased)
+		case "latest":
+			recommenders = append(recommenders, s.RecommendLatest)
+		case "popular":
+			recommenders = append(recommenders, s.RecommendPopular)
+		default:
+			custom, err := recommender.Get(name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unknown fallback recommendation method `%s`", name)
+			}
+			recommenders = append(recommenders, s.RecommendCustom(name, custom))
+		}
+		names = append(names, name)
+	}
+	return names, recommenders, nil
+}
+
+// getRecommend recommends items for a user. The result is cached by the request if the result cache is enabled, and
+// impressions and write-back are skipped for cached results since they have been recorded for the identical request.
 func (s *RestServer) getRecommend(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
@@ -1053,7 +2023,7 @@ func (s *RestServer) getRecommend(request *restful.Request, response *restful.Re
 	}
 	// parse arguments
 	userId := request.PathParameter("user-id")
-	n, err := ParseInt(request, "n", s.Config.Server.DefaultN)
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
 	if err != nil {
 		BadRequest(response, err)
 		return
@@ -1070,61 +2040,122 @@ func (s *RestServer) getRecommend(request *restful.Request, response *restful.Re
 		BadRequest(response, err)
 		return
 	}
-	// online recommendation
-	recommenders := []Recommender{s.RecommendOffline}
-	for _, recommender := range s.Config.Recommend.Online.FallbackRecommend {
-		switch recommender {
-		case "collaborative":
-			recommenders = append(recommenders, s.RecommendCollaborative)
-		case "item_based":
-			recommenders = append(recommenders, s.RecommendItemBased)
-		case "user_based":
-			recommenders = append(recommenders, s.RecommendUserBased)
-		case "latest":
-			recommenders = append(recommenders, s.RecommendLatest)
-		case "popular":
-			recommenders = append(recommenders, s.RecommendPopular)
-		default:
-			InternalServerError(response, fmt.Errorf("unknown fallback recommendation method `%s`", recommender))
+	hydrateFields, err := ParseHydrateFields(request)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	location, err := ParseLocation(request)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	timeContext, err := ParseTimeContext(request)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	// serve the cached result of an identical request
+	cacheKey := request.Request.URL.Path + "?" + request.Request.URL.Query().Encode()
+	if s.ResultCache != nil {
+		if etag, body, exist := s.ResultCache.Get(cacheKey); exist {
+			writeWithETag(request, response, etag, body)
 			return
 		}
 	}
-	results, err := s.Recommend(ctx, response, userId, category, offset+n, recommenders...)
+	// online recommendation
+	_, recommenders, err := s.OnlineRecommenders(ctx, userId, category)
 	if err != nil {
 		InternalServerError(response, err)
 		return
 	}
-	results = results[mathutil.Min(offset, len(results)):]
+	recommendCtx, err := s.recommend(ctx, response, userId, category, offset+n, location, timeContext, recommenders...)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	results := recommendCtx.results[mathutil.Min(offset, len(recommendCtx.results)):]
+	sources := recommendCtx.sources[mathutil.Min(offset, len(recommendCtx.sources)):]
+	if err = s.SourceFeedbackTracker.Serve(ctx, userId, results, sources); err != nil {
+		log.ResponseLogger(response).Error("failed to record sources of recommendation", zap.Error(err))
+	}
+	if err = s.RecordImpressions(ctx, userId, results); err != nil {
+		log.ResponseLogger(response).Error("failed to record impressions of recommendation", zap.Error(err))
+	}
+	// track fallback usage
+	cohort, err := s.userCohort(ctx, userId)
+	if err != nil {
+		log.ResponseLogger(response).Error("failed to classify user cohort", zap.String("user_id", userId), zap.Error(err))
+		cohort = ActiveUserCohort
+	}
+	s.FallbackUsageTracker.Record(cohort, recommendCtx.source())
 	// write back
-	if writeBackFeedback != "" {
-		startTime := time.Now()
-		for _, itemId := range results {
-			// insert to data store
-			feedback := data.Feedback{
+	if writeBackFeedback != "" && len(results) > 0 {
+		timestamp := time.Now().Add(writeBackDelay)
+		feedback := make([]data.Feedback, len(results))
+		for i, itemId := range results {
+			feedback[i] = data.Feedback{
 				FeedbackKey: data.FeedbackKey{
 					UserId:       userId,
 					ItemId:       itemId,
 					FeedbackType: writeBackFeedback,
 				},
-				Timestamp: startTime.Add(writeBackDelay),
-			}
-			err = s.DataClient.BatchInsertFeedback(ctx, []data.Feedback{feedback}, false, false, false)
-			if err != nil {
-				InternalServerError(response, err)
-				return
-			}
-			// insert to cache store
-			err = s.InsertFeedbackToCache(ctx, []data.Feedback{feedback})
-			if err != nil {
-				InternalServerError(response, err)
-				return
+				Timestamp: timestamp,
 			}
 		}
+		if err = s.writeBack(ctx, feedback); err != nil {
+			InternalServerError(response, err)
+			return
+		}
 	}
-	// Send result
-	Ok(response, results)
+	// Send result if modified
+	digest, err := s.CacheClient.Get(ctx, cache.Key(cache.OfflineRecommendDigest, userId)).String()
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		InternalServerError(response, err)
+		return
+	}
+	var body any = results
+	if hydrateFields != nil {
+		body, err = s.HydrateItems(ctx, lo.Map(results, func(itemId string, _ int) cache.Scored {
+			return cache.Scored{Id: itemId}
+		}), false, hydrateFields)
+		if err != nil {
+			InternalServerError(response, err)
+			return
+		}
+	}
+	etag := computeETag([]any{digest, body})
+	if s.ResultCache != nil {
+		s.ResultCache.Set(cacheKey, etag, body)
+	}
+	writeWithETag(request, response, etag, body)
+}
+
+// writeWithETag sends the body with the entity tag, or not modified if the entity tag matches If-None-Match.
+func writeWithETag(request *restful.Request, response *restful.Response, etag string, body any) {
+	response.AddHeader("ETag", etag)
+	// If-None-Match uses weak comparison
+	if matchETag(strings.ReplaceAll(request.HeaderParameter("If-None-Match"), "W/", ""), etag) {
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+	Ok(response, body)
+}
+
+// writeBack inserts write-back feedback of recommendation in a batch. Write-back feedback is always appended to the
+// write-ahead log and flushed to the data store asynchronously, even if asynchronous feedback is disabled. Appending
+// doesn't wait for the disk since the write-ahead log is synced once per flush period, so recommendation is not
+// blocked by write-back or the data store.
+func (s *RestServer) writeBack(ctx context.Context, feedback []data.Feedback) error {
+	if err := s.FeedbackWAL.Append(feedback, false); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.InsertFeedbackToCache(ctx, feedback))
 }
 
+// sessionRecommend recommends items similar to feedback in the request. Feedback is merged into the anonymous session
+// if session-id is given, and items in the session are excluded from following recommendation before the session
+// expires. Session feedback is never inserted into the data store.
 func (s *RestServer) sessionRecommend(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
@@ -1136,7 +2167,7 @@ func (s *RestServer) sessionRecommend(request *restful.Request, response *restfu
 		BadRequest(response, err)
 		return
 	}
-	n, err := ParseInt(request, "n", s.Config.Server.DefaultN)
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
 	if err != nil {
 		BadRequest(response, err)
 		return
@@ -1158,6 +2189,17 @@ func (s *RestServer) sessionRecommend(request *restful.Request, response *restfu
 			return
 		}
 	}
+	// merge feedback into the anonymous session
+	if sessionId := request.QueryParameter("session-id"); sessionId != "" {
+		if err = base.ValidateId(sessionId); err != nil {
+			BadRequest(response, err)
+			return
+		}
+		if dataFeedback, err = s.updateSession(ctx, sessionId, dataFeedback); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+	}
 	data.SortFeedbacks(dataFeedback)
 
 	// item-based recommendation
@@ -1165,7 +2207,7 @@ func (s *RestServer) sessionRecommend(request *restful.Request, response *restfu
 	var userFeedback []data.Feedback
 	for _, feedback := range dataFeedback {
 		excludeSet.Add(feedback.ItemId)
-		if funk.ContainsString(s.Config.Recommend.DataSource.PositiveFeedbackTypes, feedback.FeedbackType) {
+		if funk.ContainsString(s.Config().Recommend.DataSource.PositiveFeedbackTypes, feedback.FeedbackType) {
 			userFeedback = append(userFeedback, feedback)
 		}
 	}
@@ -1174,7 +2216,7 @@ func (s *RestServer) sessionRecommend(request *restful.Request, response *restfu
 	usedFeedbackCount := 0
 	for _, feedback := range userFeedback {
 		// load similar items
-		similarItems, err := s.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, feedback.ItemId, category), 0, s.Config.Recommend.CacheSize)
+		similarItems, err := s.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, feedback.ItemId, category), 0, s.Config().Recommend.CacheSize)
 		if err != nil {
 			BadRequest(response, err)
 			return
@@ -1189,7 +2231,7 @@ func (s *RestServer) sessionRecommend(request *restful.Request, response *restfu
 		// finish recommendation if the number of used feedbacks is enough
 		if len(similarItems) > 0 {
 			usedFeedbackCount++
-			if usedFeedbackCount >= s.Config.Recommend.Online.NumFeedbackFallbackItemBased {
+			if usedFeedbackCount >= s.Config().Recommend.Online.NumFeedbackFallbackItemBased {
 				break
 			}
 		}
@@ -1210,6 +2252,83 @@ func (s *RestServer) sessionRecommend(request *restful.Request, response *restfu
 	Ok(response, result)
 }
 
+// contextRecommend blends offline recommendation of a user with neighbors of the currently viewed item. Both lists
+// are scored by reciprocal ranks so that scores from different recommenders are comparable.
+func (s *RestServer) contextRecommend(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	// parse arguments
+	userId := request.PathParameter("user-id")
+	itemId := request.PathParameter("item-id")
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	offset, err := ParseInt(request, "offset", 0)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	weight := s.Config().Recommend.Online.ContextBlendWeight
+	if weightString := request.QueryParameter("weight"); weightString != "" {
+		if weight, err = strconv.ParseFloat(weightString, 64); err != nil {
+			BadRequest(response, err)
+			return
+		} else if weight < 0 || weight > 1 {
+			BadRequest(response, fmt.Errorf("weight should be between 0 and 1"))
+			return
+		}
+	}
+
+	// exclude read items and the viewed item
+	recommendCtx, err := s.createRecommendContext(ctx, userId, "", n+offset)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	recommendCtx.response = response
+	if err = s.requireUserFeedback(recommendCtx); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	recommendCtx.excludeSet.Add(itemId)
+
+	// load offline recommendation and neighbors
+	candidates := make(map[string]float64)
+	sources := []lo.Tuple2[string, float64]{
+		{A: cache.Key(cache.OfflineRecommend, userId), B: 1 - weight},
+		{A: cache.Key(cache.ItemNeighbors, itemId), B: weight},
+	}
+	for _, source := range sources {
+		items, err := s.CacheClient.GetSorted(ctx, source.A, 0, s.Config().Recommend.CacheSize)
+		if err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		items = s.FilterOutHiddenScores(response, items, "")
+		for rank, item := range items {
+			if !recommendCtx.excludeSet.Has(item.Id) {
+				candidates[item.Id] += source.B / float64(rank+1)
+			}
+		}
+	}
+
+	// collect top k
+	filter := heap.NewTopKFilter[string, float64](n + offset)
+	for id, score := range candidates {
+		filter.Push(id, score)
+	}
+	results, _ := filter.PopAll()
+	results = results[mathutil.Min(offset, len(results)):]
+	if err = s.RecordImpressions(ctx, userId, results); err != nil {
+		log.ResponseLogger(response).Error("failed to record impressions of recommendation", zap.Error(err))
+	}
+	Ok(response, results)
+}
+
 // Success is the returned data structure for data insert operations.
 type Success struct {
 	RowAffected int
@@ -1231,7 +2350,7 @@ func (s *RestServer) insertUser(request *restful.Request, response *restful.Resp
 		return
 	}
 	// insert modify timestamp
-	if err := s.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, temp.UserId), time.Now())); err != nil {
+	if err := s.touchUsers(ctx, time.Now(), temp.UserId); err != nil {
 		InternalServerError(response, err)
 		return
 	}
@@ -1251,14 +2370,30 @@ func (s *RestServer) modifyUser(request *restful.Request, response *restful.Resp
 		BadRequest(response, err)
 		return
 	}
-	if err := s.DataClient.ModifyUser(ctx, userId, patch); err != nil {
+	// check precondition and modify user
+	var err error
+	ifMatch := request.HeaderParameter("If-Match")
+	if ifMatch != "" {
+		err = s.DataClient.ModifyUserIf(ctx, userId, patch, func(user data.User) bool {
+			return matchETag(ifMatch, userETag(user))
+		})
+	} else {
+		err = s.DataClient.ModifyUser(ctx, userId, patch)
+	}
+	if errors.Is(err, data.ErrPreconditionFailed) || (ifMatch != "" && errors.Is(err, errors.NotFound)) {
+		Error(response, http.StatusPreconditionFailed, fmt.Errorf("user %s has been modified", userId))
+		return
+	} else if err != nil {
 		InternalServerError(response, err)
 		return
 	}
 	// insert modify timestamp
-	if err := s.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, userId), time.Now())); err != nil {
+	if err := s.touchUsers(ctx, time.Now(), userId); err != nil {
 		return
 	}
+	if user, err := s.DataClient.GetUser(ctx, userId); err == nil {
+		response.AddHeader("ETag", userETag(user))
+	}
 	Ok(response, Success{RowAffected: 1})
 }
 
@@ -1279,9 +2414,42 @@ func (s *RestServer) getUser(request *restful.Request, response *restful.Respons
 		}
 		return
 	}
+	response.AddHeader("ETag", userETag(user))
 	Ok(response, user)
 }
 
+// userETag returns the entity tag of a user.
+func userETag(user data.User) string {
+	return computeETag(user)
+}
+
+// itemETag returns the entity tag of an item. The timestamp is converted to UTC since data stores might return
+// timestamps in different time zones.
+func itemETag(item data.Item) string {
+	item.Timestamp = item.Timestamp.In(time.UTC)
+	return computeETag(item)
+}
+
+func computeETag(v any) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		log.Logger().Error("failed to marshal entity", zap.Error(err))
+	}
+	digest := sha256.Sum256(buf)
+	return `"` + hex.EncodeToString(digest[:16]) + `"`
+}
+
+// matchETag checks whether the entity tag matches the If-Match header, which is "*" or a list of entity tags.
+func matchETag(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *RestServer) insertUsers(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
@@ -1299,17 +2467,34 @@ func (s *RestServer) insertUsers(request *restful.Request, response *restful.Res
 		return
 	}
 	// insert modify timestamp
-	values := make([]cache.Value, len(temp))
+	userIds := make([]string, len(temp))
 	for i, user := range temp {
-		values[i] = cache.Time(cache.Key(cache.LastModifyUserTime, user.UserId), time.Now())
+		userIds[i] = user.UserId
 	}
-	if err := s.CacheClient.Set(ctx, values...); err != nil {
+	if err := s.touchUsers(ctx, time.Now(), userIds...); err != nil {
 		InternalServerError(response, err)
 		return
 	}
 	Ok(response, Success{RowAffected: len(temp)})
 }
 
+// touchUsers saves the latest timestamp that users were modified, and adds users to the sorted set of active users.
+func (s *RestServer) touchUsers(ctx context.Context, timestamp time.Time, userIds ...string) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	values := make([]cache.Value, len(userIds))
+	scores := make([]cache.Scored, len(userIds))
+	for i, userId := range userIds {
+		values[i] = cache.Time(cache.Key(cache.LastModifyUserTime, userId), timestamp)
+		scores[i] = cache.Scored{Id: userId, Score: float64(timestamp.Unix())}
+	}
+	if err := s.CacheClient.Set(ctx, values...); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.CacheClient.AddSorted(ctx, cache.Sorted(cache.ActiveUsers, scores)))
+}
+
 type UserIterator struct {
 	Cursor string
 	Users  []data.User
@@ -1320,34 +2505,152 @@ func (s *RestServer) getUsers(request *restful.Request, response *restful.Respon
 	if request != nil && request.Request != nil {
 		ctx = request.Request.Context()
 	}
-	cursor := request.QueryParameter("cursor")
-	n, err := ParseInt(request, "n", s.Config.Server.DefaultN)
+	cursor := request.QueryParameter("cursor")
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	// get all users
+	cursor, users, err := s.DataClient.GetUsers(ctx, cursor, n)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, UserIterator{Cursor: cursor, Users: users})
+}
+
+// delete a user by user-id
+func (s *RestServer) deleteUser(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	// get user-id and put into temp
+	userId := request.PathParameter("user-id")
+	if err := s.DataClient.DeleteUser(ctx, userId); err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, Success{RowAffected: 1})
+}
+
+// ErasureReport is the report of erasing data of a user.
+type ErasureReport struct {
+	UserId        string
+	User          bool // whether the user is deleted from the data store
+	Feedback      int  // number of deleted feedback
+	CacheEntries  int  // number of deleted entries of the user in the cache store
+	NeighborLists int  // number of neighbor lists of other users that the user is removed from
+}
+
+// EraseUserData removes a user, feedback of the user, and data of the user in the cache store. Data in the data store
+// is removed before the cache store, so that erasure could be retried if it fails halfway. Feedback pending in the
+// write-ahead log is flushed first, otherwise it would be written back after erasure.
+func (s *RestServer) EraseUserData(ctx context.Context, userId string) (ErasureReport, error) {
+	report := ErasureReport{UserId: userId}
+	if err := s.FeedbackWAL.Flush(ctx); err != nil {
+		return report, errors.Trace(err)
+	}
+	// delete user and feedback
+	if _, err := s.DataClient.GetUser(ctx, userId); err == nil {
+		report.User = true
+	} else if !errors.Is(err, errors.NotFound) {
+		return report, errors.Trace(err)
+	}
+	feedback, err := s.DataClient.GetUserFeedback(ctx, userId, nil)
+	if err != nil {
+		return report, errors.Trace(err)
+	}
+	if report.User || len(feedback) > 0 {
+		if err = s.DataClient.DeleteUser(ctx, userId); err != nil {
+			return report, errors.Trace(err)
+		}
+	}
+	report.Feedback = len(feedback)
+	// remove the user from neighbor lists of neighbors
+	categories, err := s.CacheClient.GetSet(ctx, cache.ItemCategories)
 	if err != nil {
-		BadRequest(response, err)
-		return
+		return report, errors.Trace(err)
 	}
-	// get all users
-	cursor, users, err := s.DataClient.GetUsers(ctx, cursor, n)
+	neighbors, err := s.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, userId), 0, -1)
 	if err != nil {
-		InternalServerError(response, err)
-		return
+		return report, errors.Trace(err)
 	}
-	Ok(response, UserIterator{Cursor: cursor, Users: users})
+	if len(neighbors) > 0 {
+		var members []cache.SetMember
+		for _, neighbor := range neighbors {
+			for _, category := range append([]string{""}, categories...) {
+				members = append(members, cache.Member(cache.Key(cache.UserNeighbors, neighbor.Id, category), userId))
+			}
+		}
+		if err = s.CacheClient.RemSorted(ctx, members...); err != nil {
+			return report, errors.Trace(err)
+		}
+	}
+	report.NeighborLists = len(neighbors)
+	if err = s.CacheClient.RemSorted(ctx, cache.Member(cache.ActiveUsers, userId)); err != nil {
+		return report, errors.Trace(err)
+	}
+	// delete sorted sets of the user
+	sortedSets := []string{
+		cache.Key(cache.IgnoreItems, userId),
+		cache.Key(cache.SuppressedItems, userId),
+		cache.Key(cache.RecommendSources, userId),
+		cache.Key(cache.ItemImpressions, userId),
+	}
+	for _, category := range append([]string{""}, categories...) {
+		sortedSets = append(sortedSets,
+			cache.Key(cache.UserNeighbors, userId, category),
+			cache.Key(cache.OfflineRecommend, userId, category),
+			cache.Key(cache.CollaborativeRecommend, userId, category))
+	}
+	for _, key := range sortedSets {
+		scores, err := s.CacheClient.GetSorted(ctx, key, 0, 0)
+		if err != nil {
+			return report, errors.Trace(err)
+		}
+		if len(scores) > 0 {
+			if err = s.CacheClient.SetSorted(ctx, key, nil); err != nil {
+				return report, errors.Trace(err)
+			}
+			s.SortedListCache.Remove(key)
+			report.CacheEntries++
+		}
+	}
+	// delete values of the user
+	for _, key := range []string{
+		cache.Key(cache.UserNeighborsDigest, userId),
+		cache.Key(cache.OfflineRecommendDigest, userId),
+		cache.Key(cache.LastModifyUserTime, userId),
+		cache.Key(cache.LastUpdateUserNeighborsTime, userId),
+		cache.Key(cache.LastUpdateUserRecommendTime, userId),
+	} {
+		if _, err = s.CacheClient.Get(ctx, key).String(); errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return report, errors.Trace(err)
+		}
+		if err = s.CacheClient.Delete(ctx, key); err != nil {
+			return report, errors.Trace(err)
+		}
+		report.CacheEntries++
+	}
+	return report, nil
 }
 
-// delete a user by user-id
-func (s *RestServer) deleteUser(request *restful.Request, response *restful.Response) {
+func (s *RestServer) eraseUserData(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
 		ctx = request.Request.Context()
 	}
-	// get user-id and put into temp
 	userId := request.PathParameter("user-id")
-	if err := s.DataClient.DeleteUser(ctx, userId); err != nil {
+	report, err := s.EraseUserData(ctx, userId)
+	if err != nil {
 		InternalServerError(response, err)
 		return
 	}
-	Ok(response, Success{RowAffected: 1})
+	Ok(response, report)
 }
 
 // get feedback by user-id with feedback type
@@ -1358,7 +2661,7 @@ func (s *RestServer) getTypedFeedbackByUser(request *restful.Request, response *
 	}
 	feedbackType := request.PathParameter("feedback-type")
 	userId := request.PathParameter("user-id")
-	feedback, err := s.DataClient.GetUserFeedback(ctx, userId, s.Config.Now(), feedbackType)
+	feedback, err := s.DataClient.GetUserFeedback(ctx, userId, s.Config().Now(), feedbackType)
 	if err != nil {
 		InternalServerError(response, err)
 		return
@@ -1373,7 +2676,7 @@ func (s *RestServer) getFeedbackByUser(request *restful.Request, response *restf
 		ctx = request.Request.Context()
 	}
 	userId := request.PathParameter("user-id")
-	feedback, err := s.DataClient.GetUserFeedback(ctx, userId, s.Config.Now())
+	feedback, err := s.DataClient.GetUserFeedback(ctx, userId, s.Config().Now())
 	if err != nil {
 		InternalServerError(response, err)
 		return
@@ -1389,6 +2692,8 @@ type Item struct {
 	Timestamp  string
 	Labels     []string
 	Comment    string
+	Latitude   *float64
+	Longitude  *float64
 }
 
 func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Response, temp []Item) {
@@ -1399,6 +2704,7 @@ func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Res
 			return s.PopularItemsCache.GetSortedScore(item.ItemId)
 		})
 		modification = NewCacheModification(s.CacheClient, s.HiddenItemsManager)
+		history      []data.ItemHistory
 
 		loadExistedItemsTime time.Duration
 		parseTimesatmpTime   time.Duration
@@ -1418,6 +2724,11 @@ func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Res
 	for _, item := range existedItems {
 		existedItemsSet[item.ItemId] = item
 	}
+	tree, err := s.categoryTree(ctx)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
 	loadExistedItemsTime = time.Since(start)
 
 	start = time.Now()
@@ -1431,6 +2742,11 @@ func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Res
 				return
 			}
 		}
+		if err = validateLocation(item.Latitude, item.Longitude); err != nil {
+			BadRequest(response, err)
+			return
+		}
+		item.Categories = s.Config().Recommend.DataSource.DeriveCategories(item.Categories, item.Labels)
 		items = append(items, data.Item{
 			ItemId:     item.ItemId,
 			IsHidden:   item.IsHidden,
@@ -1438,12 +2754,21 @@ func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Res
 			Timestamp:  timestamp,
 			Labels:     item.Labels,
 			Comment:    item.Comment,
+			Latitude:   item.Latitude,
+			Longitude:  item.Longitude,
 		})
 		// collect latest items and poplar items
 		if existedItem, exist := existedItemsSet[item.ItemId]; exist {
-			modification.modifyItem(item.ItemId, existedItem.Categories, item.Categories, float64(items[i].Timestamp.Unix()), popularScore[i])
+			if isItemChanged(existedItem, items[i]) {
+				history = append(history, data.NewItemHistory(existedItem, time.Now()))
+			}
+			modification.modifyItem(item.ItemId, tree.Expand(existedItem.Categories), tree.Expand(item.Categories), float64(items[i].Timestamp.Unix()), popularScore[i])
+			modification.modifyItemLabels(item.ItemId, existedItem.Labels, item.Labels)
+			modification.modifyItemLocation(existedItem, items[i])
 		} else {
-			modification.addItem(item.ItemId, item.Categories, float64(timestamp.Unix()), popularScore[i])
+			modification.addItem(item.ItemId, tree.Expand(item.Categories), float64(timestamp.Unix()), popularScore[i])
+			modification.modifyItemLabels(item.ItemId, nil, item.Labels)
+			modification.modifyItemLocation(data.Item{}, items[i])
 		}
 		// handle hidden items
 		if item.IsHidden {
@@ -1455,12 +2780,17 @@ func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Res
 	}
 	parseTimesatmpTime = time.Since(start)
 
-	// insert items
+	// insert items and previous versions of changed items
 	start = time.Now()
+	if err = s.DataClient.InsertItemHistory(ctx, history); err != nil {
+		InternalServerError(response, err)
+		return
+	}
 	if err = s.DataClient.BatchInsertItems(ctx, items); err != nil {
 		InternalServerError(response, err)
 		return
 	}
+	s.IndexItems(ctx, items)
 	insertItemsTime = time.Since(start)
 
 	// insert modify timestamp
@@ -1469,7 +2799,7 @@ func (s *RestServer) batchInsertItems(ctx context.Context, response *restful.Res
 	values := make([]cache.Value, len(items))
 	for i, item := range items {
 		values[i] = cache.Time(cache.Key(cache.LastModifyItemTime, item.ItemId), time.Now())
-		categories.Add(item.Categories...)
+		categories.Add(tree.Expand(item.Categories)...)
 	}
 	if err = s.CacheClient.Set(ctx, values...); err != nil {
 		InternalServerError(response, err)
@@ -1542,24 +2872,74 @@ func (s *RestServer) modifyItem(request *restful.Request, response *restful.Resp
 			modification.unHideItem(itemId)
 		}
 	}
+	item, err := s.DataClient.GetItem(ctx, itemId)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		InternalServerError(response, err)
+		return
+	}
+	exist := err == nil
+	// fail fast before modifying caches, the precondition is checked again by the data store
+	if request.HeaderParameter("If-Match") != "" && (!exist || !matchETag(request.HeaderParameter("If-Match"), itemETag(item))) {
+		Error(response, http.StatusPreconditionFailed, fmt.Errorf("item %s has been modified", itemId))
+		return
+	}
 	// insert new timestamp to the latest scores
 	if patch.Timestamp != nil || patch.Categories != nil {
-		item, err := s.DataClient.GetItem(ctx, itemId)
+		if !exist {
+			InternalServerError(response, err)
+			return
+		}
+		tree, err := s.categoryTree(ctx)
 		if err != nil {
 			InternalServerError(response, err)
 			return
 		}
 		popularScore := s.PopularItemsCache.GetSortedScore(itemId)
-		modification.modifyItem(itemId, item.Categories,
-			lo.If(patch.Categories != nil, patch.Categories).Else(item.Categories),
+		modification.modifyItem(itemId, tree.Expand(item.Categories),
+			tree.Expand(lo.If(patch.Categories != nil, patch.Categories).Else(item.Categories)),
 			float64(lo.If(patch.Timestamp != nil, patch.Timestamp).Else(&item.Timestamp).Unix()),
 			popularScore)
 	}
-	// modify item
-	if err := s.DataClient.ModifyItem(ctx, itemId, patch); err != nil {
+	// update label index
+	if patch.Labels != nil && exist {
+		modification.modifyItemLabels(itemId, item.Labels, patch.Labels)
+	}
+	// update location index
+	if patch.Latitude != nil || patch.Longitude != nil {
+		if !exist {
+			InternalServerError(response, err)
+			return
+		}
+		next := patchItem(item, patch)
+		if err = validateLocation(next.Latitude, next.Longitude); err != nil {
+			BadRequest(response, err)
+			return
+		}
+		modification.modifyItemLocation(item, next)
+	}
+	// check precondition and modify item
+	ifMatch := request.HeaderParameter("If-Match")
+	if ifMatch != "" {
+		err = s.DataClient.ModifyItemIf(ctx, itemId, patch, func(item data.Item) bool {
+			return matchETag(ifMatch, itemETag(item))
+		})
+	} else {
+		err = s.DataClient.ModifyItem(ctx, itemId, patch)
+	}
+	if errors.Is(err, data.ErrPreconditionFailed) || (ifMatch != "" && errors.Is(err, errors.NotFound)) {
+		Error(response, http.StatusPreconditionFailed, fmt.Errorf("item %s has been modified", itemId))
+		return
+	} else if err != nil {
 		InternalServerError(response, err)
 		return
 	}
+	// insert previous version
+	if exist && isItemChanged(item, patchItem(item, patch)) {
+		if err = s.DataClient.InsertItemHistory(ctx, []data.ItemHistory{data.NewItemHistory(item, time.Now())}); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+	}
 	// insert modify timestamp
 	if err := s.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyItemTime, itemId), time.Now())); err != nil {
 		return
@@ -1569,6 +2949,10 @@ func (s *RestServer) modifyItem(request *restful.Request, response *restful.Resp
 		InternalServerError(response, err)
 		return
 	}
+	if item, err := s.DataClient.GetItem(ctx, itemId); err == nil {
+		s.IndexItems(ctx, []data.Item{item})
+		response.AddHeader("ETag", itemETag(item))
+	}
 	Ok(response, Success{RowAffected: 1})
 }
 
@@ -1584,7 +2968,7 @@ func (s *RestServer) getItems(request *restful.Request, response *restful.Respon
 		ctx = request.Request.Context()
 	}
 	cursor := request.QueryParameter("cursor")
-	n, err := ParseInt(request, "n", s.Config.Server.DefaultN)
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
 	if err != nil {
 		BadRequest(response, err)
 		return
@@ -1614,22 +2998,91 @@ func (s *RestServer) getItem(request *restful.Request, response *restful.Respons
 		}
 		return
 	}
+	response.AddHeader("ETag", itemETag(item))
 	Ok(response, item)
 }
 
+func (s *RestServer) getItemHistory(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	itemId := request.PathParameter("item-id")
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	history, err := s.DataClient.GetItemHistory(ctx, itemId, n)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	Ok(response, history)
+}
+
+// patchItem returns the item after a patch is applied.
+func patchItem(item data.Item, patch data.ItemPatch) data.Item {
+	if patch.IsHidden != nil {
+		item.IsHidden = *patch.IsHidden
+	}
+	if patch.Categories != nil {
+		item.Categories = patch.Categories
+	}
+	if patch.Timestamp != nil {
+		item.Timestamp = *patch.Timestamp
+	}
+	if patch.Labels != nil {
+		item.Labels = patch.Labels
+	}
+	if patch.Comment != nil {
+		item.Comment = *patch.Comment
+	}
+	if patch.Latitude != nil {
+		item.Latitude = patch.Latitude
+	}
+	if patch.Longitude != nil {
+		item.Longitude = patch.Longitude
+	}
+	return item
+}
+
+// isItemChanged checks whether an item is changed by the new version.
+func isItemChanged(prev, next data.Item) bool {
+	return prev.IsHidden != next.IsHidden ||
+		!strset.New(prev.Categories...).IsEqual(strset.New(next.Categories...)) ||
+		!prev.Timestamp.Equal(next.Timestamp) ||
+		!strset.New(prev.Labels...).IsEqual(strset.New(next.Labels...)) ||
+		prev.Comment != next.Comment
+}
+
 func (s *RestServer) deleteItem(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
 		ctx = request.Request.Context()
 	}
 	itemId := request.PathParameter("item-id")
+	modification := NewCacheModification(s.CacheClient, s.HiddenItemsManager).HideItem(itemId)
+	// insert the last version
+	if item, err := s.DataClient.GetItem(ctx, itemId); err == nil {
+		if err = s.DataClient.InsertItemHistory(ctx, []data.ItemHistory{data.NewItemHistory(item, time.Now())}); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		modification.modifyItemLabels(itemId, item.Labels, nil)
+		modification.modifyItemLocation(item, data.Item{ItemId: itemId})
+	} else if !errors.Is(err, errors.NotFound) {
+		InternalServerError(response, err)
+		return
+	}
 	// delete item
 	if err := s.DataClient.DeleteItem(ctx, itemId); err != nil {
 		InternalServerError(response, err)
 		return
 	}
+	s.UnindexItems(ctx, itemId)
 	// refresh cache
-	if err := NewCacheModification(s.CacheClient, s.HiddenItemsManager).HideItem(itemId).Exec(); err != nil {
+	if err := modification.Exec(); err != nil {
 		InternalServerError(response, err)
 		return
 	}
@@ -1651,6 +3104,10 @@ func (s *RestServer) insertItemCategory(request *restful.Request, response *rest
 		return
 	}
 	if !funk.ContainsString(item.Categories, category) {
+		if err = s.DataClient.InsertItemHistory(ctx, []data.ItemHistory{data.NewItemHistory(item, time.Now())}); err != nil {
+			InternalServerError(response, err)
+			return
+		}
 		item.Categories = append(item.Categories, category)
 	}
 	err = s.DataClient.BatchInsertItems(ctx, []data.Item{item})
@@ -1658,10 +3115,18 @@ func (s *RestServer) insertItemCategory(request *restful.Request, response *rest
 		InternalServerError(response, err)
 		return
 	}
-	// refresh cache
+	s.IndexItems(ctx, []data.Item{item})
+	// refresh cache, the item is inserted into ancestors of the category as well
+	tree, err := s.categoryTree(ctx)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
 	popularScore := s.PopularItemsCache.GetSortedScore(itemId)
 	modification := NewCacheModification(s.CacheClient, s.HiddenItemsManager)
-	modification.addItemCategory(itemId, category, float64(item.Timestamp.Unix()), popularScore)
+	for _, category := range tree.Expand([]string{category}) {
+		modification.addItemCategory(itemId, category, float64(item.Timestamp.Unix()), popularScore)
+	}
 	if err = modification.Exec(); err != nil {
 		InternalServerError(response, err)
 		return
@@ -1689,14 +3154,34 @@ func (s *RestServer) deleteItemCategory(request *restful.Request, response *rest
 			categories = append(categories, cat)
 		}
 	}
+	if len(categories) < len(item.Categories) {
+		if err = s.DataClient.InsertItemHistory(ctx, []data.ItemHistory{data.NewItemHistory(item, time.Now())}); err != nil {
+			InternalServerError(response, err)
+			return
+		}
+	}
+	prevCategories := item.Categories
 	item.Categories = categories
 	err = s.DataClient.BatchInsertItems(ctx, []data.Item{item})
 	if err != nil {
 		InternalServerError(response, err)
 		return
 	}
-	// refresh cache
-	if err = NewCacheModification(s.CacheClient, s.HiddenItemsManager).deleteItemCategory(itemId, category).Exec(); err != nil {
+	s.IndexItems(ctx, []data.Item{item})
+	// refresh cache, the item is deleted from ancestors not implied by remaining categories
+	tree, err := s.categoryTree(ctx)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	modification := NewCacheModification(s.CacheClient, s.HiddenItemsManager)
+	remaining := strset.New(tree.Expand(categories)...)
+	for _, deleted := range tree.Expand(append([]string{category}, prevCategories...)) {
+		if !remaining.Has(deleted) {
+			modification.deleteItemCategory(itemId, deleted)
+		}
+	}
+	if err = modification.Exec(); err != nil {
 		InternalServerError(response, err)
 		return
 	}
@@ -1750,33 +3235,260 @@ func (s *RestServer) insertFeedback(overwrite bool) func(request *restful.Reques
 				return
 			}
 		}
+		// collapse repeated feedback
+		feedback = s.FeedbackDeduplicator.Deduplicate(feedback)
+		if err = s.writeFeedback(ctx, feedback, users, items, overwrite); err != nil {
+			s.FeedbackDeduplicator.Release(feedback)
+			InternalServerError(response, err)
+			return
+		}
+		if err = s.SourceFeedbackTracker.Attribute(ctx, feedback); err != nil {
+			log.ResponseLogger(response).Error("failed to attribute feedback to sources", zap.Error(err))
+		}
+		log.ResponseLogger(response).Info("Insert feedback successfully", zap.Int("num_feedback", len(feedback)))
+		Ok(response, Success{RowAffected: len(feedback)})
+	}
+}
+
+// writeFeedback inserts feedback to the data store and the cache store, and updates modification time of users and
+// items. If asynchronous feedback is enabled, feedback is written to the write-ahead log instead of the data store.
+// Feedback of counted types always overwrites stored feedback since events are accumulated in it. Merged users and
+// aliases of items are replaced by users they are redirected to and canonical items.
+func (s *RestServer) writeFeedback(ctx context.Context, feedback []data.Feedback, users, items *strset.Set, overwrite bool) error {
+	users, err := s.resolveFeedbackUsers(ctx, feedback, users)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if items, err = s.canonicalizeFeedback(ctx, feedback, items); err != nil {
+		return errors.Trace(err)
+	}
+	var counted []data.Feedback
+	if len(s.Config().Recommend.DataSource.PositiveThresholds) > 0 {
+		if feedback, counted, err = s.countEvents(ctx, feedback); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if s.Config().Server.AsyncFeedback {
+		// insert feedback to write-ahead log
+		if err = s.FeedbackWAL.Append(feedback, overwrite); err != nil {
+			return errors.Trace(err)
+		}
+		if len(counted) > 0 {
+			if err = s.FeedbackWAL.Append(counted, true); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	} else {
 		// insert feedback to data store
 		err = s.DataClient.BatchInsertFeedback(ctx, feedback,
-			s.Config.Server.AutoInsertUser,
-			s.Config.Server.AutoInsertItem, overwrite)
+			s.Config().Server.AutoInsertUser,
+			s.Config().Server.AutoInsertItem, overwrite)
 		if err != nil {
-			InternalServerError(response, err)
-			return
+			return errors.Trace(err)
 		}
-		// insert feedback to cache store
-		if err = s.InsertFeedbackToCache(ctx, feedback); err != nil {
-			InternalServerError(response, err)
-			return
+		if len(counted) > 0 {
+			err = s.DataClient.BatchInsertFeedback(ctx, counted,
+				s.Config().Server.AutoInsertUser,
+				s.Config().Server.AutoInsertItem, true)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	// insert feedback to cache store
+	if err = s.InsertFeedbackToCache(ctx, append(feedback, counted...)); err != nil {
+		return errors.Trace(err)
+	}
+	values := make([]cache.Value, 0, items.Size())
+	for _, itemId := range items.List() {
+		values = append(values, cache.Time(cache.Key(cache.LastModifyItemTime, itemId), time.Now()))
+	}
+	if err = s.CacheClient.Set(ctx, values...); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.touchUsers(ctx, time.Now(), users.List()...))
+}
+
+// Impression is the data structure for an item shown to a user at a position (starting from 0) of recommendation.
+type Impression struct {
+	UserId    string
+	ItemId    string
+	Position  int
+	Timestamp string
+	Comment   string
+}
+
+// ImpressionComment is the comment of impression feedback, which keeps the position of an impression along with the
+// comment from the client.
+type ImpressionComment struct {
+	Position int    `json:"position"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+func (c ImpressionComment) String() string {
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
+// ParseImpressionComment parses the comment of impression feedback. A plain position is accepted as well.
+func ParseImpressionComment(comment string) (ImpressionComment, error) {
+	var c ImpressionComment
+	if position, err := strconv.Atoi(comment); err == nil {
+		c.Position = position
+		return c, nil
+	}
+	if err := json.Unmarshal([]byte(comment), &c); err != nil {
+		return c, errors.Trace(err)
+	}
+	return c, nil
+}
+
+// EventsComment is the comment of feedback of counted types, which keeps timestamps of the latest events along with
+// the comment from the client.
+type EventsComment struct {
+	Events  []time.Time `json:"events"`
+	Comment string      `json:"comment,omitempty"`
+}
+
+func (c EventsComment) String() string {
+	b, _ := json.Marshal(c)
+	return string(b)
+}
+
+// ParseEventsComment parses the comment of feedback of a counted type. Feedback without events in the comment is
+// regarded as a single event at the time of the feedback.
+func ParseEventsComment(feedback data.Feedback) EventsComment {
+	var c EventsComment
+	if err := json.Unmarshal([]byte(feedback.Comment), &c); err != nil || len(c.Events) == 0 {
+		return EventsComment{Events: []time.Time{feedback.Timestamp}, Comment: feedback.Comment}
+	}
+	return c
+}
+
+// countEvents separates feedback of types with count thresholds from other feedback. Events of counted feedback are
+// added to sorted sets in the cache store atomically, so that concurrent events and events in the write-ahead log are
+// not lost, and the latest events up to the threshold count are kept in the comment, so that thresholds are checked
+// when datasets are loaded. Events of stored feedback are added once the sorted set is created. Counted feedback is
+// merged by keys.
+func (s *RestServer) countEvents(ctx context.Context, feedback []data.Feedback) ([]data.Feedback, []data.Feedback, error) {
+	var others []data.Feedback
+	var keys []data.FeedbackKey
+	events := make(map[data.FeedbackKey][]time.Time)
+	comments := make(map[data.FeedbackKey]string)
+	for _, f := range feedback {
+		if _, isCounted := s.Config().Recommend.DataSource.PositiveThreshold(f.FeedbackType); !isCounted {
+			others = append(others, f)
+			continue
 		}
-		values := make([]cache.Value, 0, users.Size()+items.Size())
-		for _, userId := range users.List() {
-			values = append(values, cache.Time(cache.Key(cache.LastModifyUserTime, userId), time.Now()))
+		if _, exist := events[f.FeedbackKey]; !exist {
+			keys = append(keys, f.FeedbackKey)
 		}
-		for _, itemId := range items.List() {
-			values = append(values, cache.Time(cache.Key(cache.LastModifyItemTime, itemId), time.Now()))
+		timestamp := f.Timestamp
+		if timestamp.IsZero() {
+			timestamp = time.Now()
 		}
-		if err = s.CacheClient.Set(ctx, values...); err != nil {
-			InternalServerError(response, err)
+		events[f.FeedbackKey] = append(events[f.FeedbackKey], timestamp)
+		comments[f.FeedbackKey] = f.Comment
+	}
+	countedFeedback := make([]data.Feedback, 0, len(keys))
+	for _, key := range keys {
+		threshold, _ := s.Config().Recommend.DataSource.PositiveThreshold(key.FeedbackType)
+		name := cache.Key(cache.FeedbackEvents, key.FeedbackType, key.UserId, key.ItemId)
+		// load events of stored feedback if there is no event in the cache store
+		timestamps := events[key]
+		existed, err := s.CacheClient.GetSorted(ctx, name, 0, 0)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if len(existed) == 0 {
+			stored, err := s.DataClient.GetUserItemFeedback(ctx, key.UserId, key.ItemId, key.FeedbackType)
+			if err != nil {
+				return nil, nil, errors.Trace(err)
+			}
+			if len(stored) > 0 {
+				timestamps = append(timestamps, ParseEventsComment(stored[0]).Events...)
+			}
+		}
+		// add events and keep the latest events up to the threshold count
+		scores := lo.Map(timestamps, func(timestamp time.Time, _ int) cache.Scored {
+			return cache.Scored{Id: timestamp.UTC().Format(time.RFC3339Nano), Score: float64(timestamp.UnixNano()) / float64(time.Second)}
+		})
+		if err = s.CacheClient.AddSorted(ctx, cache.Sorted(name, scores)); err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		latest, err := s.CacheClient.GetSorted(ctx, name, 0, -1)
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+		if len(latest) > threshold.Count {
+			members := lo.Map(latest[threshold.Count:], func(score cache.Scored, _ int) cache.SetMember {
+				return cache.Member(name, score.Id)
+			})
+			if err = s.CacheClient.RemSorted(ctx, members...); err != nil {
+				return nil, nil, errors.Trace(err)
+			}
+			latest = latest[:threshold.Count]
+		}
+		comment := EventsComment{Comment: comments[key]}
+		for i := len(latest) - 1; i >= 0; i-- {
+			timestamp, err := time.Parse(time.RFC3339Nano, latest[i].Id)
+			if err != nil {
+				return nil, nil, errors.Trace(err)
+			}
+			comment.Events = append(comment.Events, timestamp)
+		}
+		countedFeedback = append(countedFeedback, data.Feedback{
+			FeedbackKey: key,
+			Timestamp:   comment.Events[len(comment.Events)-1],
+			Comment:     comment.String(),
+		})
+	}
+	return others, countedFeedback, nil
+}
+
+func (s *RestServer) insertImpressions(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	impressionType := s.Config().Recommend.DataSource.ImpressionFeedbackType
+	if impressionType == "" {
+		BadRequest(response, errors.New("impression feedback type is not configured"))
+		return
+	}
+	var impressions []Impression
+	if err := request.ReadEntity(&impressions); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	// impressions are stored as feedback with positions in structured comments
+	feedback := make([]data.Feedback, len(impressions))
+	users := set.NewStringSet()
+	items := set.NewStringSet()
+	for i, impression := range impressions {
+		if impression.Position < 0 {
+			BadRequest(response, fmt.Errorf("invalid position %d", impression.Position))
 			return
 		}
-		log.ResponseLogger(response).Info("Insert feedback successfully", zap.Int("num_feedback", len(feedback)))
-		Ok(response, Success{RowAffected: len(feedback)})
+		users.Add(impression.UserId)
+		items.Add(impression.ItemId)
+		var err error
+		feedback[i], err = Feedback{
+			FeedbackKey: data.FeedbackKey{FeedbackType: impressionType, UserId: impression.UserId, ItemId: impression.ItemId},
+			Timestamp:   impression.Timestamp,
+			Comment:     ImpressionComment{Position: impression.Position, Comment: impression.Comment}.String(),
+		}.ToDataFeedback()
+		if err != nil {
+			BadRequest(response, err)
+			return
+		}
+	}
+	if err := s.writeFeedback(ctx, feedback, users, items, true); err != nil {
+		InternalServerError(response, err)
+		return
 	}
+	log.ResponseLogger(response).Info("Insert impressions successfully", zap.Int("num_impressions", len(impressions)))
+	Ok(response, Success{RowAffected: len(impressions)})
 }
 
 // FeedbackIterator is the iterator for feedback.
@@ -1785,6 +3497,21 @@ type FeedbackIterator struct {
 	Feedback []data.Feedback
 }
 
+// parseFeedbackTimeRange parses the time range of feedback from the query parameters begin-time and end-time. The end
+// time is no later than now so that future feedback is never returned.
+func (s *RestServer) parseFeedbackTimeRange(request *restful.Request) (beginTime, endTime *time.Time, err error) {
+	if beginTime, err = ParseTime(request, "begin-time"); err != nil {
+		return nil, nil, err
+	}
+	if endTime, err = ParseTime(request, "end-time"); err != nil {
+		return nil, nil, err
+	}
+	if now := s.Config().Now(); endTime == nil || endTime.After(*now) {
+		endTime = now
+	}
+	return beginTime, endTime, nil
+}
+
 func (s *RestServer) getFeedback(request *restful.Request, response *restful.Response) {
 	ctx := context.Background()
 	if request != nil && request.Request != nil {
@@ -1792,12 +3519,20 @@ func (s *RestServer) getFeedback(request *restful.Request, response *restful.Res
 	}
 	// Parse parameters
 	cursor := request.QueryParameter("cursor")
-	n, err := ParseInt(request, "n", s.Config.Server.DefaultN)
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	beginTime, endTime, err := s.parseFeedbackTimeRange(request)
 	if err != nil {
 		BadRequest(response, err)
 		return
 	}
-	cursor, feedback, err := s.DataClient.GetFeedback(ctx, cursor, n, nil, s.Config.Now())
+	userId := request.QueryParameter("user-id")
+	itemId := request.QueryParameter("item-id")
+	feedbackTypes := request.QueryParameters("feedback-type")
+	cursor, feedback, err := s.DataClient.GetFeedback(ctx, cursor, n, beginTime, endTime, userId, itemId, feedbackTypes...)
 	if err != nil {
 		InternalServerError(response, err)
 		return
@@ -1813,12 +3548,19 @@ func (s *RestServer) getTypedFeedback(request *restful.Request, response *restfu
 	// Parse parameters
 	feedbackType := request.PathParameter("feedback-type")
 	cursor := request.QueryParameter("cursor")
-	n, err := ParseInt(request, "n", s.Config.Server.DefaultN)
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	beginTime, endTime, err := s.parseFeedbackTimeRange(request)
 	if err != nil {
 		BadRequest(response, err)
 		return
 	}
-	cursor, feedback, err := s.DataClient.GetFeedback(ctx, cursor, n, nil, s.Config.Now(), feedbackType)
+	userId := request.QueryParameter("user-id")
+	itemId := request.QueryParameter("item-id")
+	cursor, feedback, err := s.DataClient.GetFeedback(ctx, cursor, n, beginTime, endTime, userId, itemId, feedbackType)
 	if err != nil {
 		InternalServerError(response, err)
 		return
@@ -1896,37 +3638,71 @@ type HealthStatus struct {
 	CacheStoreError     error
 	DataStoreConnected  bool
 	CacheStoreConnected bool
+	Started             bool
+	Dependencies        map[string]DependencyStatus `json:",omitempty"` // latencies of stores and the master
+	LastSync            *time.Time                  `json:",omitempty"` // last successful sync with the master
+	ModelStaleness      map[string]time.Duration    `json:",omitempty"` // durations since newer models were found
 }
 
-func (s *RestServer) checkHealth() HealthStatus {
-	healthStatus := HealthStatus{}
-	healthStatus.DataStoreError = s.DataClient.Ping()
-	healthStatus.CacheStoreError = s.CacheClient.Ping()
-	healthStatus.DataStoreConnected = healthStatus.DataStoreError == nil
-	healthStatus.CacheStoreConnected = healthStatus.CacheStoreError == nil
-	healthStatus.Ready = healthStatus.DataStoreConnected && healthStatus.CacheStoreConnected
+// checkHealth collects the health status of this node. Stores are pinged only if ping is true.
+func (s *RestServer) checkHealth(ping bool) HealthStatus {
+	healthStatus := HealthStatus{
+		Started:        s.Health.Started(),
+		LastSync:       s.Health.LastSync(),
+		ModelStaleness: s.Health.ModelStaleness(),
+	}
+	if master := s.Health.Master(); master != nil {
+		healthStatus.Dependencies = map[string]DependencyStatus{"master": *master}
+	}
+	if ping {
+		if healthStatus.Dependencies == nil {
+			healthStatus.Dependencies = make(map[string]DependencyStatus)
+		}
+		start := time.Now()
+		healthStatus.DataStoreError = s.DataClient.Ping()
+		healthStatus.Dependencies["data_store"] = NewDependencyStatus(start, healthStatus.DataStoreError)
+		start = time.Now()
+		healthStatus.CacheStoreError = s.CacheClient.Ping()
+		healthStatus.Dependencies["cache_store"] = NewDependencyStatus(start, healthStatus.CacheStoreError)
+		healthStatus.DataStoreConnected = healthStatus.DataStoreError == nil
+		healthStatus.CacheStoreConnected = healthStatus.CacheStoreError == nil
+		healthStatus.Ready = healthStatus.Started && healthStatus.DataStoreConnected && healthStatus.CacheStoreConnected
+	}
 	return healthStatus
 }
 
 func (s *RestServer) checkReady(_ *restful.Request, response *restful.Response) {
-	healthStatus := s.checkHealth()
+	healthStatus := s.checkHealth(true)
 	if healthStatus.Ready {
 		Ok(response, healthStatus)
 	} else {
-		errReason, err := json.Marshal(healthStatus)
-		if err != nil {
-			Error(response, http.StatusInternalServerError, err)
-		} else {
-			Error(response, http.StatusServiceUnavailable, errors.New(string(errReason)))
-		}
+		unavailable(response, healthStatus)
+	}
+}
+
+func (s *RestServer) checkStartup(_ *restful.Request, response *restful.Response) {
+	healthStatus := s.checkHealth(false)
+	if healthStatus.Started {
+		Ok(response, healthStatus)
+	} else {
+		unavailable(response, healthStatus)
 	}
 }
 
 func (s *RestServer) checkLive(_ *restful.Request, response *restful.Response) {
-	healthStatus := s.checkHealth()
+	healthStatus := s.checkHealth(false)
 	Ok(response, healthStatus)
 }
 
+func unavailable(response *restful.Response, healthStatus HealthStatus) {
+	errReason, err := json.Marshal(healthStatus)
+	if err != nil {
+		Error(response, http.StatusInternalServerError, err)
+	} else {
+		Error(response, http.StatusServiceUnavailable, errors.New(string(errReason)))
+	}
+}
+
 // Measurement stores a statistical value.
 type Measurement struct {
 	Name      string
@@ -1941,7 +3717,7 @@ type innerMeasurement struct {
 
 func NewMeasurementFromScore(name string, score cache.Scored) (Measurement, error) {
 	var m innerMeasurement
-	err := json.Unmarshal([]byte(score.Id), &m)
+	err := cache.Unmarshal(score.Id, &m)
 	if err != nil {
 		log.Logger().Error("failed to decode measurement", zap.Error(err))
 	}
@@ -1952,12 +3728,13 @@ func NewMeasurementFromScore(name string, score cache.Scored) (Measurement, erro
 	}, nil
 }
 
-func (m Measurement) GetScore() cache.Scored {
-	buf, _ := json.Marshal(innerMeasurement{
+// GetScore encodes the measurement as a member of a sorted set in the cache store by the encoding of the cache store.
+func (m Measurement) GetScore(database cache.Database) cache.Scored {
+	buf, _ := cache.Marshal(database, innerMeasurement{
 		Timestamp: m.Timestamp,
 		Value:     m.Value,
 	})
-	return cache.Scored{Id: string(buf), Score: float64(m.Timestamp.Unix())}
+	return cache.Scored{Id: buf, Score: float64(m.Timestamp.Unix())}
 }
 
 func (s *RestServer) GetMeasurements(ctx context.Context, name string, n int) ([]Measurement, error) {
@@ -1979,7 +3756,7 @@ func (s *RestServer) GetMeasurements(ctx context.Context, name string, n int) ([
 func (s *RestServer) InsertMeasurement(ctx context.Context, measurements ...Measurement) error {
 	sortedSets := lo.Map(measurements, func(m Measurement, _ int) cache.SortedSet {
 		name := cache.Key(cache.Measurements, m.Name)
-		score := m.GetScore()
+		score := m.GetScore(s.CacheClient)
 		err := s.CacheClient.RemSortedByScore(ctx, name, score.Score, score.Score)
 		if err != nil {
 			log.Logger().Warn("failed to remove stale measurement", zap.Error(err))
@@ -2018,9 +3795,17 @@ func BadRequest(response *restful.Response, err error) {
 	}
 }
 
-// InternalServerError returns a internal server error.
+// InternalServerError returns a internal server error, or a service unavailable error if the data store is busy.
 func InternalServerError(response *restful.Response, err error) {
 	response.Header().Set("Access-Control-Allow-Origin", "*")
+	if errors.Is(err, errors.Timeout) {
+		// the data store is busy, clients should retry later
+		log.ResponseLogger(response).Warn("service unavailable", zap.Error(err))
+		if err = response.WriteError(http.StatusServiceUnavailable, err); err != nil {
+			log.ResponseLogger(response).Error("failed to write error", zap.Error(err))
+		}
+		return
+	}
 	log.ResponseLogger(response).Error("internal server error", zap.Error(err))
 	if err = response.WriteError(http.StatusInternalServerError, err); err != nil {
 		log.ResponseLogger(response).Error("failed to write error", zap.Error(err))
@@ -2038,6 +3823,11 @@ func PageNotFound(response *restful.Response, err error) {
 // Ok sends the content as JSON to the client.
 func Ok(response *restful.Response, content interface{}) {
 	response.Header().Set("Access-Control-Allow-Origin", "*")
+	if success, ok := content.(Success); ok {
+		if writer, ok := response.ResponseWriter.(*writeCountingWriter); ok {
+			writer.rows += success.RowAffected
+		}
+	}
 	if err := response.WriteAsJson(content); err != nil {
 		log.ResponseLogger(response).Error("failed to write json", zap.Error(err))
 	}
@@ -2060,7 +3850,7 @@ func Text(response *restful.Response, content string) {
 
 // InsertFeedbackToCache inserts feedback to cache.
 func (s *RestServer) InsertFeedbackToCache(ctx context.Context, feedback []data.Feedback) error {
-	if !s.Config.Recommend.Replacement.EnableReplacement {
+	if !s.Config().Recommend.Replacement.EnableReplacement {
 		sortedSets := make([]cache.SortedSet, len(feedback))
 		for i, v := range feedback {
 			sortedSets[i] = cache.Sorted(cache.Key(cache.IgnoreItems, v.UserId), []cache.Scored{{Id: v.ItemId, Score: float64(v.Timestamp.Unix())}})
@@ -2069,5 +3859,18 @@ func (s *RestServer) InsertFeedbackToCache(ctx context.Context, feedback []data.
 			return errors.Trace(err)
 		}
 	}
+	// suppress items with negative feedback regardless of replacement
+	var sortedSets []cache.SortedSet
+	for _, v := range feedback {
+		if until, isNegative := s.Config().Recommend.DataSource.SuppressUntil(v.FeedbackType, v.Timestamp); isNegative {
+			sortedSets = append(sortedSets, cache.Sorted(cache.Key(cache.SuppressedItems, v.UserId),
+				[]cache.Scored{{Id: v.ItemId, Score: until}}))
+		}
+	}
+	if len(sortedSets) > 0 {
+		if err := s.CacheClient.AddSorted(ctx, sortedSets...); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
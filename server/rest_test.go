@@ -15,19 +15,33 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/samber/lo"
 	"github.com/steinfletcher/apitest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"github.com/zhenghaoz/gorse/config"
+	"github.com/zhenghaoz/gorse/model/click"
+	"github.com/zhenghaoz/gorse/recommender"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
 	"google.golang.org/protobuf/proto"
@@ -81,8 +95,25 @@ func (suite *ServerTestSuite) SetupTest() {
 	err = suite.CacheClient.Purge()
 	suite.NoError(err)
 	// configuration
-	suite.Config = config.GetDefaultConfig()
-	suite.Config.Server.APIKey = apiKey
+	suite.SetConfig(config.GetDefaultConfig())
+	suite.Config().Server.APIKey = apiKey
+	suite.Config().Server.FeedbackWALPath = filepath.Join(suite.T().TempDir(), "feedback.wal")
+	suite.FallbackUsageTracker = newFallbackUsageTrackerForTest(&suite.RestServer)
+	suite.QuotaManager = newQuotaManagerForTest(&suite.RestServer)
+	suite.SourceFeedbackTracker = newSourceFeedbackTrackerForTest(&suite.RestServer)
+	suite.AuditLogger = NewAuditLogger(&suite.RestServer)
+	suite.FeedbackWAL = NewFeedbackWAL(&suite.RestServer)
+	suite.FeedbackDeduplicator = NewFeedbackDeduplicator(&suite.RestServer)
+	suite.SortedListCache = NewSortedListCache(&suite.RestServer)
+	suite.ResultCache = NewResultCache(&suite.RestServer)
+	suite.RuleManager = NewRuleManager(&suite.RestServer)
+	suite.CategoryManager = NewCategoryManager(&suite.RestServer)
+	suite.AliasManager = NewAliasManager(&suite.RestServer)
+	suite.SegmentManager = NewSegmentManager(&suite.RestServer)
+	suite.DataStoreBreaker = NewCircuitBreaker(&suite.RestServer)
+	suite.Bidder = nil
+	suite.RerankScript = NewRerankScript(&suite.RestServer)
+	suite.SearchClient = nil
 }
 
 func (suite *ServerTestSuite) marshal(v interface{}) string {
@@ -652,6 +683,63 @@ func (suite *ServerTestSuite) TestFeedback() {
 		})).
 		Status(http.StatusOK).
 		End()
+	// get feedback with filters
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/feedback").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"user-id":       "1",
+			"feedback-type": "click",
+		}).
+		Expect(t).
+		Body(suite.marshal(FeedbackIterator{Feedback: []data.Feedback{feedback[1]}})).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/feedback").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"item-id":  "4",
+			"end-time": "2000-01-01",
+		}).
+		Expect(t).
+		Body(suite.marshal(FeedbackIterator{Feedback: []data.Feedback{feedback[2]}})).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/feedback/read").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"user-id": "1",
+		}).
+		Expect(t).
+		Body(suite.marshal(FeedbackIterator{Feedback: []data.Feedback{}})).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/feedback").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"begin-time": "2000-01-01",
+		}).
+		Expect(t).
+		Body(suite.marshal(FeedbackIterator{Feedback: []data.Feedback{}})).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/feedback").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"begin-time": "yesterday",
+		}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
 	// get feedback by user
 	apitest.New().
 		Handler(suite.handler).
@@ -733,7 +821,7 @@ func (suite *ServerTestSuite) TestFeedback() {
 		Status(http.StatusOK).
 		Body(`{"RowAffected": 1}`).
 		End()
-	ret, err := suite.DataClient.GetUserFeedback(ctx, "0", suite.Config.Now(), "click")
+	ret, err := suite.DataClient.GetUserFeedback(ctx, "0", suite.Config().Now(), "click")
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(ret))
 	assert.Equal(t, "override", ret[0].Comment)
@@ -750,7 +838,7 @@ func (suite *ServerTestSuite) TestFeedback() {
 		Status(http.StatusOK).
 		Body(`{"RowAffected": 1}`).
 		End()
-	ret, err = suite.DataClient.GetUserFeedback(ctx, "0", suite.Config.Now(), "click")
+	ret, err = suite.DataClient.GetUserFeedback(ctx, "0", suite.Config().Now(), "click")
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(ret))
 	assert.Equal(t, "override", ret[0].Comment)
@@ -767,6 +855,355 @@ func (suite *ServerTestSuite) TestFeedback() {
 		End()
 }
 
+func (suite *ServerTestSuite) TestImpressions() {
+	ctx := context.Background()
+	t := suite.T()
+	impressions := []Impression{
+		{UserId: "0", ItemId: "1", Position: 0, Timestamp: "2000-01-01"},
+		{UserId: "0", ItemId: "2", Position: 1, Timestamp: "2000-01-01", Comment: "banner"},
+	}
+	// impression feedback type is not configured
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/impressions").
+		Header("X-API-Key", apiKey).
+		JSON(impressions).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+	// insert impressions
+	suite.Config().Recommend.DataSource.ImpressionFeedbackType = "impression"
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/impressions").
+		Header("X-API-Key", apiKey).
+		JSON(impressions).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 2}`).
+		End()
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", suite.Config().Now(), "impression")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 2) {
+		assert.ElementsMatch(t, []lo.Tuple2[string, ImpressionComment]{
+			{A: "1", B: ImpressionComment{Position: 0}},
+			{A: "2", B: ImpressionComment{Position: 1, Comment: "banner"}},
+		}, lo.Map(feedback, func(f data.Feedback, _ int) lo.Tuple2[string, ImpressionComment] {
+			comment, err := ParseImpressionComment(f.Comment)
+			assert.NoError(t, err)
+			return lo.Tuple2[string, ImpressionComment]{A: f.ItemId, B: comment}
+		}))
+	}
+	// impressed items are excluded from recommendation
+	ignored, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.IgnoreItems, "0"), 0, -1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, cache.RemoveScores(ignored))
+	// invalid position
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/impressions").
+		Header("X-API-Key", apiKey).
+		JSON([]Impression{{UserId: "0", ItemId: "1", Position: -1}}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestCountedFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.DataSource.PositiveThresholds = []config.FeedbackThreshold{{FeedbackType: "play", Count: 2}}
+	// insert plays
+	for _, timestamp := range []string{"2000-01-01", "2000-01-03", "2000-01-02"} {
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/feedback").
+			Header("X-API-Key", apiKey).
+			JSON([]Feedback{
+				{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}, Timestamp: timestamp, Comment: "song"},
+				{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "1"}, Timestamp: timestamp},
+			}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(`{"RowAffected": 2}`).
+			End()
+	}
+	// the latest events are kept in the comment
+	feedback, err := suite.DataClient.GetUserItemFeedback(ctx, "0", "0", "play")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 1) {
+		assert.Equal(t, time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC), feedback[0].Timestamp.UTC())
+		comment := ParseEventsComment(feedback[0])
+		assert.Equal(t, "song", comment.Comment)
+		assert.Equal(t, []time.Time{
+			time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC),
+		}, lo.Map(comment.Events, func(event time.Time, _ int) time.Time { return event.UTC() }))
+	}
+	// feedback of other types is not counted
+	feedback, err = suite.DataClient.GetUserItemFeedback(ctx, "0", "1", "like")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 1) {
+		assert.Equal(t, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), feedback[0].Timestamp.UTC())
+		assert.Empty(t, feedback[0].Comment)
+	}
+	// plain comments are regarded as single events
+	assert.Equal(t, EventsComment{Events: []time.Time{{}}, Comment: "song"}, ParseEventsComment(data.Feedback{Comment: "song"}))
+}
+
+func (suite *ServerTestSuite) TestCountedFeedbackConcurrent() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.DataSource.PositiveThresholds = []config.FeedbackThreshold{{FeedbackType: "play", Count: 10}}
+	insertPlay := func(day int) {
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/feedback").
+			Header("X-API-Key", apiKey).
+			JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}, Timestamp: fmt.Sprintf("2000-01-%02dT00:00:00Z", day)}}).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+	}
+	// concurrent events are not lost
+	var wg sync.WaitGroup
+	for i := 1; i <= 10; i++ {
+		wg.Add(1)
+		go func(day int) {
+			defer wg.Done()
+			insertPlay(day)
+		}(i)
+	}
+	wg.Wait()
+	events, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.FeedbackEvents, "play", "0", "0"), 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, events, 10)
+	// the latest events are kept
+	insertPlay(11)
+	feedback, err := suite.DataClient.GetUserItemFeedback(ctx, "0", "0", "play")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 1) {
+		comment := ParseEventsComment(feedback[0])
+		if assert.Len(t, comment.Events, 10) {
+			assert.Equal(t, time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC), comment.Events[0].UTC())
+			assert.Equal(t, time.Date(2000, 1, 11, 0, 0, 0, 0, time.UTC), comment.Events[9].UTC())
+		}
+	}
+}
+
+func (suite *ServerTestSuite) TestCountedAsyncFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.AsyncFeedback = true
+	suite.Config().Server.FeedbackWALPath = filepath.Join(t.TempDir(), "feedback.wal")
+	suite.Config().Recommend.DataSource.PositiveThresholds = []config.FeedbackThreshold{{FeedbackType: "play", Count: 2}}
+	// events in the write-ahead log are counted
+	for _, timestamp := range []string{"2000-01-01", "2000-01-02"} {
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/feedback").
+			Header("X-API-Key", apiKey).
+			JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}, Timestamp: timestamp}}).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+	}
+	err := suite.FeedbackWAL.Flush(ctx)
+	assert.NoError(t, err)
+	feedback, err := suite.DataClient.GetUserItemFeedback(ctx, "0", "0", "play")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 1) {
+		assert.Len(t, ParseEventsComment(feedback[0]).Events, 2)
+	}
+}
+
+func (suite *ServerTestSuite) TestDuplicateFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.FeedbackDedupWindow = time.Minute
+	suite.Config().Recommend.DataSource.PositiveThresholds = []config.FeedbackThreshold{{FeedbackType: "play", Count: 3}}
+	// retries of a play are collapsed
+	for i, rowAffected := range []int{2, 0} {
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/feedback").
+			Header("X-API-Key", apiKey).
+			JSON([]Feedback{
+				{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}, Timestamp: fmt.Sprintf("2000-01-0%d", i+1)},
+				{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}, Timestamp: fmt.Sprintf("2000-01-0%d", i+1)},
+				{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "1"}, Timestamp: fmt.Sprintf("2000-01-0%d", i+1)},
+			}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(fmt.Sprintf(`{"RowAffected": %d}`, rowAffected)).
+			End()
+	}
+	feedback, err := suite.DataClient.GetUserItemFeedback(ctx, "0", "0", "play")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 1) {
+		assert.Len(t, ParseEventsComment(feedback[0]).Events, 1)
+	}
+	// feedback is kept once out of the window
+	suite.FeedbackDeduplicator.received[data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}] = time.Now().Add(-time.Minute)
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "play", UserId: "0", ItemId: "0"}, Timestamp: "2000-01-03"}}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 1}`).
+		End()
+	feedback, err = suite.DataClient.GetUserItemFeedback(ctx, "0", "0", "play")
+	assert.NoError(t, err)
+	if assert.Len(t, feedback, 1) {
+		assert.Len(t, ParseEventsComment(feedback[0]).Events, 2)
+	}
+}
+
+// failingDatabase fails to insert feedback for the given number of times.
+type failingDatabase struct {
+	data.Database
+	failures int
+}
+
+func (d *failingDatabase) BatchInsertFeedback(ctx context.Context, feedback []data.Feedback, insertUser, insertItem, overwrite bool) error {
+	if d.failures > 0 {
+		d.failures--
+		return errors.New("failed to insert feedback")
+	}
+	return d.Database.BatchInsertFeedback(ctx, feedback, insertUser, insertItem, overwrite)
+}
+
+func (suite *ServerTestSuite) TestDuplicateFeedbackRetry() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.FeedbackDedupWindow = time.Minute
+	dataClient := suite.DataClient
+	suite.DataClient = &failingDatabase{Database: dataClient, failures: 1}
+	defer func() { suite.DataClient = dataClient }()
+	// the retry of failed feedback is not a duplicate
+	for _, status := range []int{http.StatusInternalServerError, http.StatusOK} {
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/feedback").
+			Header("X-API-Key", apiKey).
+			JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "0"}, Timestamp: "2000-01-01"}}).
+			Expect(t).
+			Status(status).
+			End()
+	}
+	feedback, err := dataClient.GetUserItemFeedback(ctx, "0", "0", "like")
+	assert.NoError(t, err)
+	assert.Len(t, feedback, 1)
+}
+
+func (suite *ServerTestSuite) TestQuota() {
+	t := suite.T()
+	suite.Config().Server.APIKeys = map[string]config.APIKeyConfig{
+		"analytics": {Key: "analytics_key", DailyRequests: 3, DailyWrites: 2},
+	}
+	feedback := []Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}},
+	}
+	// unknown API key
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/items").
+		Header("X-API-Key", "unknown_key").
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+	// write feedback until write quota exceeded
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", "analytics_key").
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 2}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", "analytics_key").
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusTooManyRequests).
+		End()
+	// read until request quota exceeded
+	for i := 0; i < 2; i++ {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/items").
+			Header("X-API-Key", "analytics_key").
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+	}
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/items").
+		Header("X-API-Key", "analytics_key").
+		Expect(t).
+		Status(http.StatusTooManyRequests).
+		End()
+	// the API key of the server is unlimited
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/items").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	// check usage
+	usage, err := suite.QuotaManager.Usage(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []APIKeyUsage{{
+		Name:          "analytics",
+		Date:          time.Now().UTC().Truncate(24 * time.Hour),
+		Requests:      3,
+		Writes:        2,
+		DailyRequests: 3,
+		DailyWrites:   2,
+	}}, usage)
+}
+
+func (suite *ServerTestSuite) TestQuotaConcurrent() {
+	t := suite.T()
+	suite.Config().Server.APIKeys = map[string]config.APIKeyConfig{
+		"analytics": {Key: "analytics_key", DailyRequests: 5},
+	}
+	// concurrent requests never exceed the request quota together
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[int]int)
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+			req.Header.Set("X-API-Key", "analytics_key")
+			recorder := httptest.NewRecorder()
+			suite.handler.ServeHTTP(recorder, req)
+			mu.Lock()
+			statuses[recorder.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, map[int]int{http.StatusOK: 5, http.StatusTooManyRequests: 15}, statuses)
+	suite.QuotaManager.sync()
+	usage, err := suite.QuotaManager.Usage(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Len(t, usage, 1)
+	assert.Equal(t, 5, usage[0].Requests)
+}
+
 func (suite *ServerTestSuite) TestSort() {
 	ctx := context.Background()
 	type ListOperator struct {
@@ -779,6 +1216,7 @@ func (suite *ServerTestSuite) TestSort() {
 		//{"User Neighbors", cache.Key(cache.UserNeighbors, "0"), "/api/user/0/neighbors"},
 		{"Item Neighbors", cache.Key(cache.ItemNeighbors, "0"), "/api/item/0/neighbors"},
 		{"Item Neighbors in Category", cache.Key(cache.ItemNeighbors, "0", "0"), "/api/item/0/neighbors/0"},
+		{"Also Liked Items", cache.Key(cache.AlsoLikedItems, "0"), "/api/item/0/also-liked"},
 		{"Latest Items", cache.LatestItems, "/api/latest/"},
 		{"Latest Items in Category", cache.Key(cache.LatestItems, "0"), "/api/latest/0"},
 		{"Popular Items", cache.PopularItems, "/api/popular/"},
@@ -927,137 +1365,1843 @@ func (suite *ServerTestSuite) TestDeleteFeedback() {
 		End()
 }
 
-func (suite *ServerTestSuite) TestMeasurement() {
-	ctx := context.Background()
+func (suite *ServerTestSuite) TestCategories() {
 	t := suite.T()
-	measurements := []Measurement{
-		{"Test_NDCG", time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 0},
-		{"Test_NDCG", time.Date(2001, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 1},
-		{"Test_NDCG", time.Date(2002, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 2},
-		{"Test_NDCG", time.Date(2003, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 3},
-		{"Test_NDCG", time.Date(2004, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 4},
-		{"Test_Recall", time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 1},
+	// invalid hierarchies
+	for _, categories := range [][]Category{
+		{{Name: "phone", Parent: "electronics"}},
+		{{Name: "a", Parent: "b"}, {Name: "b", Parent: "a"}},
+		{{Name: ""}},
+	} {
+		apitest.New().
+			Handler(suite.handler).
+			Put("/api/categories").
+			Header("X-API-Key", apiKey).
+			JSON(categories).
+			Expect(t).
+			Status(http.StatusBadRequest).
+			End()
 	}
-	err := suite.InsertMeasurement(ctx, measurements...)
-	assert.NoError(t, err)
+	// manage categories
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/measurements/Test_NDCG").
-		Query("n", "3").
+		Put("/api/categories").
 		Header("X-API-Key", apiKey).
+		JSON([]Category{{Name: "electronics", DisplayName: "Electronics"}, {Name: "phone", Parent: "electronics"}}).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal([]Measurement{
-			measurements[4],
-			measurements[3],
-			measurements[2],
-		})).
+		Body(`{"RowAffected": 2}`).
 		End()
-	// test auth fail
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/measurements/Test_NDCG").
-		Query("n", "3").
+		Put("/api/category/laptop").
+		Header("X-API-Key", apiKey).
+		JSON(Category{Parent: "electronics", Description: "Portable computers"}).
 		Expect(t).
-		Status(http.StatusUnauthorized).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 1}`).
 		End()
-}
-
-func (suite *ServerTestSuite) TestGetRecommends() {
-	ctx := context.Background()
-	t := suite.T()
-	// insert hidden items
-	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"0", 100}})
-	assert.NoError(t, err)
-	err = NewCacheModification(suite.CacheClient, suite.HiddenItemsManager).HideItem("0").Exec()
-	assert.NoError(t, err)
-	// insert recommendation
-	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
-		{Id: "1", Score: 99},
-		{Id: "2", Score: 98},
-		{Id: "3", Score: 97},
-		{Id: "4", Score: 96},
-		{Id: "5", Score: 95},
-		{Id: "6", Score: 94},
-		{Id: "7", Score: 93},
-		{Id: "8", Score: 92},
-	})
-	assert.NoError(t, err)
-	// insert feedback
-	feedback := []data.Feedback{
-		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "2"}},
-		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "4"}},
-		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "1"}, Timestamp: time.Now().Add(time.Hour)},
-	}
 	apitest.New().
 		Handler(suite.handler).
-		Post("/api/feedback").
+		Get("/api/category/laptop").
 		Header("X-API-Key", apiKey).
-		JSON(feedback).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(`{"RowAffected": 3}`).
+		Body(suite.marshal(Category{Name: "laptop", Parent: "electronics", Description: "Portable computers"})).
 		End()
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/recommend/0").
+		Get("/api/category/tablet").
 		Header("X-API-Key", apiKey).
-		QueryParams(map[string]string{
-			"n": "3",
-		}).
 		Expect(t).
-		Status(http.StatusOK).
-		Body(suite.marshal([]string{"1", "3", "5"})).
+		Status(http.StatusNotFound).
 		End()
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/recommend/0").
+		Delete("/api/category/electronics").
 		Header("X-API-Key", apiKey).
-		QueryParams(map[string]string{
-			"n":      "3",
-			"offset": "3",
-		}).
 		Expect(t).
-		Status(http.StatusOK).
-		Body(suite.marshal([]string{"6", "7", "8"})).
+		Status(http.StatusBadRequest).
 		End()
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/recommend/0").
+		Delete("/api/category/laptop").
 		Header("X-API-Key", apiKey).
-		QueryParams(map[string]string{
-			"n":      "3",
-			"offset": "10000",
-		}).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal([]string{})).
+		Body(`{"RowAffected": 1}`).
 		End()
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/recommend/0").
+		Get("/api/categories").
 		Header("X-API-Key", apiKey).
-		QueryParams(map[string]string{
-			"n":               "3",
-			"write-back-type": "read",
-		}).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal([]string{"1", "3", "5"})).
+		Body(suite.marshal([]Category{{Name: "electronics", DisplayName: "Electronics"}, {Name: "phone", Parent: "electronics"}})).
 		End()
+
+	// items in children are rolled up into parents
+	items := []Item{
+		{ItemId: "1", Categories: []string{"phone"}, Timestamp: "2000-01-01"},
+		{ItemId: "2", Categories: []string{"electronics"}, Timestamp: "2000-01-02"},
+		{ItemId: "3", Timestamp: "2000-01-03"},
+	}
 	apitest.New().
 		Handler(suite.handler).
-		Get("/api/recommend/0").
+		Post("/api/items").
 		Header("X-API-Key", apiKey).
-		QueryParams(map[string]string{
-			"n":                "3",
+		JSON(items).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/item/3/category/phone").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/latest/electronics").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{
+			{Id: "3", Score: float64(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC).Unix())},
+			{Id: "2", Score: float64(time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC).Unix())},
+			{Id: "1", Score: float64(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix())},
+		})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/latest/phone").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{
+			{Id: "3", Score: float64(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC).Unix())},
+			{Id: "1", Score: float64(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix())},
+		})).
+		End()
+	// items removed from children are removed from parents
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/item/1/category/phone").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/latest/electronics").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{
+			{Id: "3", Score: float64(time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC).Unix())},
+			{Id: "2", Score: float64(time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC).Unix())},
+		})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestLabelCategories() {
+	t := suite.T()
+	suite.Config().Recommend.DataSource.LabelCategories = []config.LabelCategory{{Pattern: "^brand:(.+)$", Category: "brand-$1"}}
+	// categories are derived from labels at insert time
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/items").
+		Header("X-API-Key", apiKey).
+		JSON([]Item{
+			{ItemId: "1", Categories: []string{"phone"}, Labels: []string{"brand:apple"}, Timestamp: "2000-01-01"},
+			{ItemId: "2", Labels: []string{"brand:apple", "sale"}, Timestamp: "2000-01-02"},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/1").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(data.Item{
+			ItemId:     "1",
+			Categories: []string{"phone", "brand-apple"},
+			Labels:     []string{"brand:apple"},
+			Timestamp:  time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/latest/brand-apple").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{
+			{Id: "2", Score: float64(time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC).Unix())},
+			{Id: "1", Score: float64(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix())},
+		})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestLabels() {
+	ctx := context.Background()
+	t := suite.T()
+	// list labels counted by the master
+	suite.NoError(SaveLabelCounts(ctx, suite.CacheClient, LabelCounts{
+		Users: map[string]int{"a": 2, "c": 1},
+		Items: map[string]int{"a": 1, "b": 2},
+	}))
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/label/d/block").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/labels").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]Label{
+			{Name: "a", Users: 2, Items: 1},
+			{Name: "b", Items: 2},
+			{Name: "c", Users: 1},
+			{Name: "d", Blocked: true},
+		})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/labels").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"n": "2", "offset": "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]Label{{Name: "b", Items: 2}, {Name: "c", Users: 1}})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/label/d/block").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	blocked, err := LoadBlockedLabels(ctx, suite.CacheClient)
+	suite.NoError(err)
+	suite.Zero(blocked.Size())
+
+	// merge labels of users and items
+	suite.NoError(suite.DataClient.BatchInsertUsers(ctx, []data.User{
+		{UserId: "0", Labels: []string{"a", "b"}},
+		{UserId: "1", Labels: []string{"c"}},
+	}))
+	suite.NoError(suite.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "0", Labels: []string{"b", "c"}},
+		{ItemId: "1", Labels: []string{"a"}},
+	}))
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/labels/rename").
+		Header("X-API-Key", apiKey).
+		JSON(LabelRename{From: []string{"b", "c"}}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/labels/rename").
+		Header("X-API-Key", apiKey).
+		JSON(LabelRename{From: []string{"b", "c"}, To: "a"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 3}`).
+		End()
+	user, err := suite.DataClient.GetUser(ctx, "0")
+	suite.NoError(err)
+	suite.Equal([]string{"a"}, user.Labels)
+	user, err = suite.DataClient.GetUser(ctx, "1")
+	suite.NoError(err)
+	suite.Equal([]string{"a"}, user.Labels)
+	item, err := suite.DataClient.GetItem(ctx, "0")
+	suite.NoError(err)
+	suite.Equal([]string{"a"}, item.Labels)
+	items, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.LabeledItems, "a"), 0, -1)
+	suite.NoError(err)
+	suite.Equal([]string{"0"}, cache.RemoveScores(items))
+	items, err = suite.CacheClient.GetSorted(ctx, cache.Key(cache.LabeledItems, "b"), 0, -1)
+	suite.NoError(err)
+	suite.Empty(items)
+}
+
+func (suite *ServerTestSuite) TestCounts() {
+	ctx := context.Background()
+	t := suite.T()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/stats/counts").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+	counts := Counts{
+		Users:         3,
+		Items:         4,
+		Feedback:      5,
+		FeedbackTypes: map[string]int{"click": 3, "like": 2},
+		Categories:    map[string]int{"a": 4, "b": 1},
+		UpdateTime:    time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	suite.NoError(SaveCounts(ctx, suite.CacheClient, counts))
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/stats/counts").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(Counts{Users: 3, Items: 4, Feedback: 5, UpdateTime: counts.UpdateTime})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/stats/counts").
+		Header("X-API-Key", apiKey).
+		Query("feedback-type", "click").
+		Query("feedback-type", "read").
+		Query("category", "b").
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(Counts{
+			Users:         3,
+			Items:         4,
+			Feedback:      5,
+			FeedbackTypes: map[string]int{"click": 3, "read": 0},
+			Categories:    map[string]int{"b": 1},
+			UpdateTime:    counts.UpdateTime,
+		})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestMeasurement() {
+	ctx := context.Background()
+	t := suite.T()
+	measurements := []Measurement{
+		{"Test_NDCG", time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 0},
+		{"Test_NDCG", time.Date(2001, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 1},
+		{"Test_NDCG", time.Date(2002, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 2},
+		{"Test_NDCG", time.Date(2003, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 3},
+		{"Test_NDCG", time.Date(2004, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 4},
+		{"Test_Recall", time.Date(2000, 1, 1, 1, 1, 1, 0, time.UTC).Local(), 1},
+	}
+	err := suite.InsertMeasurement(ctx, measurements...)
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/measurements/Test_NDCG").
+		Query("n", "3").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]Measurement{
+			measurements[4],
+			measurements[3],
+			measurements[2],
+		})).
+		End()
+	// test auth fail
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/measurements/Test_NDCG").
+		Query("n", "3").
+		Expect(t).
+		Status(http.StatusUnauthorized).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithFrequencyCap() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.Online.FrequencyCap = 2
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}, {"5", 95}})
+	assert.NoError(t, err)
+
+	// items are not recommended after returned twice
+	for _, expected := range [][]string{{"1", "2"}, {"1", "2"}, {"3", "4"}} {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			QueryParams(map[string]string{"n": "2"}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal(expected)).
+			End()
+	}
+	capped, err := suite.cappedItems(ctx, "0")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, capped)
+
+	// impressions are not counted if frequency capping is disabled
+	suite.Config().Recommend.Online.FrequencyCap = 0
+	assert.NoError(t, suite.RecordImpressions(ctx, "1", []string{"1"}))
+	impressions, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.ItemImpressions, "1"), 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, impressions)
+}
+
+type mockBidder func(ctx context.Context, request BidRequest) ([]Bid, error)
+
+func (b mockBidder) Bid(ctx context.Context, request BidRequest) ([]Bid, error) {
+	return b(ctx, request)
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithBids() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}})
+	assert.NoError(t, err)
+
+	// boost candidates from the external bidder
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request BidRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, BidRequest{UserId: "0", Candidates: []string{"1", "2", "3", "4"}}, request)
+		assert.NoError(t, json.NewEncoder(w).Encode([]Bid{{ItemId: "3", Boost: 4}, {ItemId: "4", Boost: -1}, {ItemId: "5", Boost: 10}}))
+	}))
+	defer server.Close()
+	cfg := config.GetDefaultConfig()
+	cfg.Recommend.Online.BidderURL = server.URL
+	suite.SetConfig(cfg)
+	suite.Bidder = NewHTTPBidder(&suite.RestServer)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "1", "2", "4"})).
+		End()
+
+	// fallback if the bidder fails
+	suite.Bidder = mockBidder(func(ctx context.Context, request BidRequest) ([]Bid, error) {
+		return nil, errors.New("bidder failed")
+	})
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3", "4"})).
+		End()
+
+	// fallback if the bidder times out
+	cfg = config.GetDefaultConfig()
+	cfg.Recommend.Online.BidderURL = server.URL
+	cfg.Recommend.Online.BidderTimeout = time.Millisecond
+	suite.SetConfig(cfg)
+	suite.Bidder = mockBidder(func(ctx context.Context, request BidRequest) ([]Bid, error) {
+		time.Sleep(100 * time.Millisecond)
+		return []Bid{{ItemId: "4", Boost: 10}}, nil
+	})
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3", "4"})).
+		End()
+
+	// skip bidding if the bidder is not configured
+	suite.SetConfig(config.GetDefaultConfig())
+	suite.Bidder = mockBidder(func(ctx context.Context, request BidRequest) ([]Bid, error) {
+		assert.Fail(t, "bidder is not configured")
+		return nil, nil
+	})
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3", "4"})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithRerankScript() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/items").
+		Header("X-API-Key", apiKey).
+		JSON([]Item{
+			{ItemId: "1"},
+			{ItemId: "2", Labels: []string{"promoted"}},
+			{ItemId: "3", Labels: []string{"blocked"}},
+			{ItemId: "4", Comment: "four"},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	writeScript := func(name, script string) string {
+		path := filepath.Join(t.TempDir(), name)
+		assert.NoError(t, os.WriteFile(path, []byte(script), 0644))
+		return path
+	}
+
+	// promote, block and ignore unknown or duplicated items
+	suite.Config().Recommend.Online.RerankScript = writeScript("rerank.lua", `
+function rerank(request)
+  assert(request.user_id == "0" and request.category == "")
+  local results = {}
+  for _, item in ipairs(request.items) do
+    if item.labels[1] == "promoted" then
+      table.insert(results, 1, item.item_id)
+    elseif item.labels[1] ~= "blocked" and item.source == "offline" then
+      table.insert(results, item.item_id)
+    end
+  end
+  assert(request.items[4].comment == "four")
+  table.insert(results, "unknown")
+  table.insert(results, results[1])
+  return results
+end`)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"2", "1", "4"})).
+		End()
+
+	// fallback if the script fails, is not sandboxed or times out
+	suite.Config().Recommend.Online.RerankScriptTimeout = 10 * time.Millisecond
+	for i, script := range []string{
+		`function rerank(request) error("failed") end`,
+		`function rerank(request) return dofile("/etc/passwd") end`,
+		`function rerank(request) return os.exit(1) end`,
+		`function rerank(request) while true do end end`,
+		`function rerank(request) return "1" end`,
+		`function rank(request) return {} end`,
+		`function rerank(request)`,
+	} {
+		suite.Config().Recommend.Online.RerankScript = writeScript(fmt.Sprintf("rerank_%d.lua", i), script)
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal([]string{"1", "2", "3", "4"})).
+			End()
+	}
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithLocation() {
+	ctx := context.Background()
+	t := suite.T()
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/items").
+		Header("X-API-Key", apiKey).
+		JSON([]Item{
+			{ItemId: "1", Latitude: proto.Float64(31.23), Longitude: proto.Float64(121.47)},
+			{ItemId: "2", Latitude: proto.Float64(31.24), Longitude: proto.Float64(121.48)},
+			{ItemId: "3", Latitude: proto.Float64(39.9), Longitude: proto.Float64(116.4)},
+			{ItemId: "4"},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 4}`).
+		End()
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"3", 99}, {"4", 98}, {"1", 97}, {"2", 96}})
+	assert.NoError(t, err)
+
+	// boost nearby items
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"latitude": "31.23", "longitude": "121.47"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "1", "4", "2"})).
+		End()
+	// filter items out of the radius
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"latitude": "31.23", "longitude": "121.47", "radius": "10"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2"})).
+		End()
+	// move and delete items
+	apitest.New().
+		Handler(suite.handler).
+		Patch("/api/item/3").
+		Header("X-API-Key", apiKey).
+		JSON(data.ItemPatch{Latitude: proto.Float64(31.22), Longitude: proto.Float64(121.46)}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/item/1").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"latitude": "31.23", "longitude": "121.47", "radius": "10"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "2"})).
+		End()
+	// invalid locations
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"latitude": "91", "longitude": "121.47"}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"radius": "10"}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/items").
+		Header("X-API-Key", apiKey).
+		JSON([]Item{{ItemId: "5", Latitude: proto.Float64(31.23)}}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithTimeContext() {
+	ctx := context.Background()
+	t := suite.T()
+	// item 1 is clicked in the morning and item 2 is clicked in the evening
+	breakfast, dinner := click.TimeContextLabels(8, time.Monday), click.TimeContextLabels(20, time.Saturday)
+	builder := click.NewUnifiedMapIndexBuilder()
+	for _, itemId := range []string{"1", "2", "3"} {
+		builder.AddItem(itemId)
+	}
+	for _, label := range append(breakfast, dinner...) {
+		builder.AddCtxLabel(label)
+	}
+	clickModel := click.NewFM(click.FMClassification, nil)
+	clickModel.Index = builder.Build()
+	clickModel.W = make([]float32, clickModel.Index.Len())
+	clickModel.V = make([][]float32, clickModel.Index.Len())
+	for i := range clickModel.V {
+		clickModel.V[i] = make([]float32, 16)
+	}
+	clickModel.V[clickModel.Index.EncodeItem("1")][0] = 1
+	clickModel.V[clickModel.Index.EncodeContextLabel(breakfast[0])][0] = 1
+	clickModel.V[clickModel.Index.EncodeItem("2")][1] = 1
+	clickModel.V[clickModel.Index.EncodeContextLabel(dinner[0])][1] = 1
+	suite.ClickModel = clickModel
+	defer func() { suite.ClickModel = nil }()
+	suite.Config().Recommend.Offline.EnableTimeContext = true
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"3", 99}, {"1", 98}, {"2", 97}})
+	assert.NoError(t, err)
+
+	// re-rank by time contexts
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hour": "8", "weekday": "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "3", "2"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hour": "20", "weekday": "6"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"2", "3", "1"})).
+		End()
+	// keep results without time contexts
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "1", "2"})).
+		End()
+	// keep results if time contexts are disabled
+	suite.Config().Recommend.Offline.EnableTimeContext = false
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hour": "8", "weekday": "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "1", "2"})).
+		End()
+	// invalid time contexts
+	for _, params := range []map[string]string{{"hour": "24", "weekday": "1"}, {"hour": "8", "weekday": "7"}, {"hour": "8"}} {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			QueryParams(params).
+			Expect(t).
+			Status(http.StatusBadRequest).
+			End()
+	}
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithRules() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: "0", Labels: []string{"kid"}}, {UserId: "1"}})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "1"}, {ItemId: "2", Labels: []string{"promo"}}, {ItemId: "3", Labels: []string{"adult"}},
+		{ItemId: "4"}, {ItemId: "5"}, {ItemId: "9"},
+	})
+	assert.NoError(t, err)
+	recommends := []cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}, {"5", 95}}
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), recommends)
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "1"), recommends)
+	assert.NoError(t, err)
+	err = SaveRules(ctx, suite.CacheClient, []Rule{
+		{Name: "pin", Action: RulePin, ItemId: "9", Slot: 1},
+		{Name: "boost", Action: RuleBoost, ItemLabel: "promo", Factor: 3},
+		{Name: "block", Action: RuleBlock, ItemLabel: "adult", UserLabel: "kid"},
+		{Name: "category", Action: RulePin, Category: "c", ItemId: "5", Slot: 1},
+	})
+	assert.NoError(t, err)
+
+	// items with blocked labels are removed for users with the label
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"n": "4"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"9", "2", "1", "4"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/1").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"n": "4"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"9", "2", "1", "3"})).
+		End()
+
+	// invalid rules are rejected
+	assert.True(t, errors.IsNotValid(SaveRules(ctx, suite.CacheClient, []Rule{{Name: "pin", Action: RulePin, ItemId: "9"}})))
+	rules, err := LoadRules(ctx, suite.CacheClient)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 4)
+}
+
+func (suite *ServerTestSuite) TestItemAliases() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "1"}, {ItemId: "2"}, {ItemId: "3"}, {ItemId: "4"}})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "1", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "1", ItemId: "1"}},
+	}, true, false, false)
+	assert.NoError(t, err)
+
+	// feedback on the alias is merged into the canonical item
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/alias/2").
+		Header("X-API-Key", apiKey).
+		JSON(ItemAlias{Canonical: "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 2}`).
+		End()
+	feedback, err := suite.DataClient.GetItemFeedback(ctx, "1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0", "1"}, lo.Map(feedback, func(f data.Feedback, _ int) string { return f.UserId }))
+	feedback, err = suite.DataClient.GetItemFeedback(ctx, "2")
+	assert.NoError(t, err)
+	assert.Empty(t, feedback)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/alias/2").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(ItemAlias{Alias: "2", Canonical: "1"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/aliases").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]ItemAlias{{Alias: "2", Canonical: "1"}})).
+		End()
+	// canonical items must exist and must not be aliases
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/alias/5").
+		Header("X-API-Key", apiKey).
+		JSON(ItemAlias{Canonical: "6"}).
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/alias/1").
+		Header("X-API-Key", apiKey).
+		JSON(ItemAlias{Canonical: "3"}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+
+	// feedback on the alias is inserted to the canonical item
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "2", ItemId: "2"}}}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	feedback, err = suite.DataClient.GetUserFeedback(ctx, "2", suite.Config().Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, lo.Map(feedback, func(f data.Feedback, _ int) string { return f.ItemId }))
+
+	// aliases in recommendations are redirected to canonical items
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "3"),
+		[]cache.Scored{{Id: "2", Score: 4}, {Id: "3", Score: 3}, {Id: "1", Score: 2}, {Id: "4", Score: 1}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/3").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "3", "4"})).
+		End()
+
+	// delete the alias
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/alias/2").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 1}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/alias/2").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithSegments() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"latest"}
+	err := suite.DataClient.BatchInsertUsers(ctx, []data.User{
+		{UserId: "0", Labels: []string{"kid", "vip"}}, {UserId: "1", Labels: []string{"vip"}}, {UserId: "2"}})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "1"}, {ItemId: "2", Labels: []string{"adult"}}})
+	assert.NoError(t, err)
+	for _, userId := range []string{"0", "1", "2"} {
+		err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, userId), []cache.Scored{{"1", 99}, {"2", 98}})
+		assert.NoError(t, err)
+	}
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), []cache.Scored{{"3", 99}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems), []cache.Scored{{"4", 99}})
+	assert.NoError(t, err)
+	err = SaveSegments(ctx, suite.CacheClient, []Segment{
+		{Name: "kid", Labels: []string{"kid"}, Rules: []Rule{{Name: "block", Action: RuleBlock, ItemLabel: "adult"}}},
+		{Name: "vip", Labels: []string{"vip"}, FallbackRecommend: []string{"popular"}},
+	})
+	assert.NoError(t, err)
+
+	// users are assigned to the first segment matched
+	for userId, expected := range map[string][]string{
+		"0": {"1", "3"},
+		"1": {"1", "2", "4"},
+		"2": {"1", "2", "3"},
+	} {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/"+userId).
+			Header("X-API-Key", apiKey).
+			QueryParams(map[string]string{"n": "3"}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal(expected)).
+			End()
+	}
+
+	// invalid segments are rejected
+	assert.True(t, errors.IsNotValid(SaveSegments(ctx, suite.CacheClient, []Segment{{Name: "vip"}})))
+	assert.True(t, errors.IsNotValid(SaveSegments(ctx, suite.CacheClient, []Segment{
+		{Name: "vip", Labels: []string{"vip"}}, {Name: "vip", Labels: []string{"kid"}}})))
+	assert.True(t, errors.IsNotValid(SaveSegments(ctx, suite.CacheClient, []Segment{
+		{Name: "vip", Labels: []string{"vip"}, ExploreRecommend: map[string]float64{"latest": 2}}})))
+	segments, err := LoadSegments(ctx, suite.CacheClient)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 2)
+}
+
+func (suite *ServerTestSuite) TestGetRecommends() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert hidden items
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"0", 100}})
+	assert.NoError(t, err)
+	err = NewCacheModification(suite.CacheClient, suite.HiddenItemsManager).HideItem("0").Exec()
+	assert.NoError(t, err)
+	// insert recommendation
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
+		{Id: "1", Score: 99},
+		{Id: "2", Score: 98},
+		{Id: "3", Score: 97},
+		{Id: "4", Score: 96},
+		{Id: "5", Score: 95},
+		{Id: "6", Score: 94},
+		{Id: "7", Score: 93},
+		{Id: "8", Score: 92},
+	})
+	assert.NoError(t, err)
+	// insert feedback
+	feedback := []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "4"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "1"}, Timestamp: time.Now().Add(time.Hour)},
+	}
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 3}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "3",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "3", "5"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":      "3",
+			"offset": "3",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"6", "7", "8"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":      "3",
+			"offset": "10000",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":               "3",
+			"write-back-type": "read",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "3", "5"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":                "3",
 			"write-back-type":  "read",
 			"write-back-delay": "10m",
 		}).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal([]string{"6", "7", "8"})).
+		Body(suite.marshal([]string{"6", "7", "8"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "3",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"6", "7", "8"})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithReplacement() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.Replacement.EnableReplacement = true
+	// insert hidden items
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"0", 100}})
+	assert.NoError(t, err)
+	err = NewCacheModification(suite.CacheClient, suite.HiddenItemsManager).HideItem("0").Exec()
+	assert.NoError(t, err)
+	// insert recommendation
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
+		{Id: "1", Score: 99},
+		{Id: "2", Score: 98},
+		{Id: "3", Score: 97},
+		{Id: "4", Score: 96},
+		{Id: "5", Score: 95},
+		{Id: "6", Score: 94},
+		{Id: "7", Score: 93},
+		{Id: "8", Score: 92},
+	})
+	assert.NoError(t, err)
+	// insert feedback
+	feedback := []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "4"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "1"}, Timestamp: time.Now().Add(time.Hour)},
+	}
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 3}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "3",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3"})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetRecommendsWithNegativeFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.Replacement.EnableReplacement = true
+	suite.Config().Recommend.DataSource.NegativeFeedbackTTL = map[string]time.Duration{"dislike": time.Hour, "hide": 0}
+	// insert recommendation
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
+		{Id: "1", Score: 99},
+		{Id: "2", Score: 98},
+		{Id: "3", Score: 97},
+		{Id: "4", Score: 96},
+		{Id: "5", Score: 95},
+	})
+	assert.NoError(t, err)
+	// insert feedback
+	feedback := []Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "1"}, Timestamp: time.Now().Format(time.RFC3339)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "2"}, Timestamp: time.Now().Add(-2 * time.Hour).Format(time.RFC3339)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "hide", UserId: "0", ItemId: "3"}, Timestamp: time.Now().AddDate(-1, 0, 0).Format(time.RFC3339)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "4"}, Timestamp: time.Now().Format(time.RFC3339)},
+	}
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 4}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "3",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"2", "4", "5"})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestContextRecommend() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert recommendation
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
+		{Id: "1", Score: 99},
+		{Id: "2", Score: 98},
+		{Id: "3", Score: 97},
+		{Id: "4", Score: 96},
+	})
+	assert.NoError(t, err)
+	// insert neighbors of the viewed item
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.ItemNeighbors, "10"), []cache.Scored{
+		{Id: "3", Score: 100},
+		{Id: "5", Score: 99},
+		{Id: "6", Score: 98},
+	})
+	assert.NoError(t, err)
+	// insert feedback
+	feedback := []data.Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "1"}}}
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 1}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0/context/10").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":      "3",
+			"weight": "0.25",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "2", "4"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0/context/10").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":      "2",
+			"offset": "1",
+			"weight": "1",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"5", "6"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0/context/10").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"weight": "2",
+		}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetUserNeighbors() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.DataClient.BatchInsertUsers(ctx, []data.User{
+		{UserId: "1", Labels: []string{"a"}, Subscribe: []string{"x"}, Comment: "one"},
+		{UserId: "2", Labels: []string{"b"}, Comment: "two"},
+	})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, "0"), []cache.Scored{{"1", 100}, {"2", 99}, {"3", 98}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, "0", "x"), []cache.Scored{{"2", 100}})
+	assert.NoError(t, err)
+
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/user/0/neighbors/").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"1", 100}, {"2", 99}, {"3", 98}})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/user/0/neighbors/x").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"2", 100}})).
+		End()
+	// join user fields into returned users
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/user/0/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hydrate": "true"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]HydratedUser{
+			{UserId: "1", Score: lo.ToPtr(100.0), Labels: []string{"a"}, Subscribe: []string{"x"}, Comment: lo.ToPtr("one")},
+			{UserId: "2", Score: lo.ToPtr(99.0), Labels: []string{"b"}, Comment: lo.ToPtr("two")},
+			{UserId: "3", Score: lo.ToPtr(98.0)},
+		})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/user/0/neighbors/x").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hydrate": "true", "fields": "labels"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]HydratedUser{{UserId: "2", Score: lo.ToPtr(100.0), Labels: []string{"b"}}})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/user/0/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hydrate": "true", "fields": "is_hidden"}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestGetItemNeighborsWithLabels() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert items
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/items").
+		Header("X-API-Key", apiKey).
+		JSON([]data.Item{
+			{ItemId: "0", Labels: []string{"a", "b"}},
+			{ItemId: "1", Labels: []string{"a"}},
+			{ItemId: "2", Labels: []string{"b", "c"}},
+			{ItemId: "3", Labels: []string{"c"}},
+			{ItemId: "4"},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 5}`).
+		End()
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.ItemNeighbors, "0"), []cache.Scored{
+		{"1", 100}, {"2", 99}, {"3", 98}, {"4", 97},
+	})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/0/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"share-labels": "true"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"1", 100}, {"2", 99}})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/0/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"label": "c"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"2", 99}, {"3", 98}})).
+		End()
+	// modify and delete labeled items
+	apitest.New().
+		Handler(suite.handler).
+		Patch("/api/item/1").
+		Header("X-API-Key", apiKey).
+		JSON(data.ItemPatch{Labels: []string{"d"}}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/item/2").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/0/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"share-labels": "true"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/4/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"share-labels": "true"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/5/neighbors/").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"share-labels": "true"}).
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
+func (suite *ServerTestSuite) TestComposeSlate() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert recommendation, popular items and sponsored items
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems, ""), []cache.Scored{
+		{Id: "1", Score: 100}, {Id: "2", Score: 99}, {Id: "3", Score: 98},
+	})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
+		{Id: "1", Score: 100}, {Id: "3", Score: 99}, {Id: "4", Score: 98}, {Id: "5", Score: 97}, {Id: "6", Score: 96},
+	})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems, "sponsored"), []cache.Scored{
+		{Id: "3", Score: 100}, {Id: "7", Score: 99},
+	})
+	assert.NoError(t, err)
+	// insert feedback
+	feedback := []data.Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "4"}}}
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON(feedback).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 1}`).
+		End()
+
+	// items are de-duplicated across quotas and extra items are left to following quotas
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/slate/0").
+		Header("X-API-Key", apiKey).
+		JSON([]SlateQuota{
+			{Source: "popular", N: 2},
+			{Source: "offline", N: 2},
+			{Source: "latest", Category: "sponsored", N: 1},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]SlateItem{
+			{ItemId: "1", Source: "popular"},
+			{ItemId: "2", Source: "popular"},
+			{ItemId: "3", Source: "offline"},
+			{ItemId: "5", Source: "offline"},
+			{ItemId: "7", Source: "latest"},
+		})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/slate/0").
+		Header("X-API-Key", apiKey).
+		JSON([]SlateQuota{{Source: "unknown", N: 2}}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/slate/0").
+		Header("X-API-Key", apiKey).
+		JSON([]SlateQuota{{Source: "popular"}}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestItemHistory() {
+	t := suite.T()
+	// insert item twice
+	for i := 0; i < 2; i++ {
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/item").
+			Header("X-API-Key", apiKey).
+			JSON(Item{ItemId: "0", Categories: []string{"a"}, Labels: []string{"x"}, Timestamp: "1996-03-15"}).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+	}
+	// modify item
+	apitest.New().
+		Handler(suite.handler).
+		Patch("/api/item/0").
+		Header("X-API-Key", apiKey).
+		JSON(data.ItemPatch{IsHidden: proto.Bool(true)}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/item/0/category/b").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/item/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	// get history
+	var history []data.ItemHistory
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/0/history").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End().
+		JSON(&history)
+	timestamp := time.Date(1996, 3, 15, 0, 0, 0, 0, time.UTC)
+	if assert.Len(t, history, 3) {
+		for i, expected := range []data.Item{
+			{ItemId: "0", IsHidden: true, Categories: []string{"a", "b"}, Labels: []string{"x"}, Timestamp: timestamp},
+			{ItemId: "0", IsHidden: true, Categories: []string{"a"}, Labels: []string{"x"}, Timestamp: timestamp},
+			{ItemId: "0", IsHidden: false, Categories: []string{"a"}, Labels: []string{"x"}, Timestamp: timestamp},
+		} {
+			history[i].Timestamp = history[i].Timestamp.In(time.UTC)
+			assert.Equal(t, data.NewItemHistory(expected, history[i].ModifyTime), history[i])
+		}
+		assert.False(t, history[0].ModifyTime.Before(history[1].ModifyTime))
+	}
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/0/history").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"n": "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(history[:1])).
+		End()
+}
+
+func (suite *ServerTestSuite) TestOptimisticConcurrency() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: "0", Comment: "a"}})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "0", Comment: "a"}})
+	assert.NoError(t, err)
+	for _, kind := range []string{"user", "item"} {
+		// get entity tag
+		etag := apitest.New().
+			Handler(suite.handler).
+			Get("/api/"+kind+"/0").
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			HeaderPresent("ETag").
+			End().Response.Header.Get("ETag")
+		// modify with matched entity tag
+		newETag := apitest.New().
+			Handler(suite.handler).
+			Patch("/api/"+kind+"/0").
+			Header("X-API-Key", apiKey).
+			Header("If-Match", etag).
+			JSON(map[string]string{"Comment": "b"}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal(Success{RowAffected: 1})).
+			End().Response.Header.Get("ETag")
+		assert.NotEqual(t, etag, newETag)
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/"+kind+"/0").
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			Header("ETag", newETag).
+			End()
+		// modify with stale entity tag
+		apitest.New().
+			Handler(suite.handler).
+			Patch("/api/"+kind+"/0").
+			Header("X-API-Key", apiKey).
+			Header("If-Match", etag).
+			JSON(map[string]string{"Comment": "c"}).
+			Expect(t).
+			Status(http.StatusPreconditionFailed).
+			End()
+		// modify nonexistent entity with wildcard
+		apitest.New().
+			Handler(suite.handler).
+			Patch("/api/"+kind+"/1").
+			Header("X-API-Key", apiKey).
+			Header("If-Match", "*").
+			JSON(map[string]string{"Comment": "c"}).
+			Expect(t).
+			Status(http.StatusPreconditionFailed).
+			End()
+	}
+	user, err := suite.DataClient.GetUser(ctx, "0")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", user.Comment)
+	item, err := suite.DataClient.GetItem(ctx, "0")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", item.Comment)
+}
+
+func (suite *ServerTestSuite) TestEraseUserData() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert data
+	err := suite.DataClient.BatchInsertUsers(ctx, []data.User{{UserId: "0"}, {UserId: "1"}})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "1", ItemId: "1"}},
+	}, true, true, true)
+	assert.NoError(t, err)
+	// insert cache
+	err = suite.CacheClient.SetSet(ctx, cache.ItemCategories, "a")
+	assert.NoError(t, err)
+	for _, key := range []string{
+		cache.Key(cache.OfflineRecommend, "0"),
+		cache.Key(cache.OfflineRecommend, "0", "a"),
+		cache.Key(cache.CollaborativeRecommend, "0"),
+		cache.Key(cache.IgnoreItems, "0"),
+		cache.Key(cache.OfflineRecommend, "1"),
+	} {
+		err = suite.CacheClient.SetSorted(ctx, key, []cache.Scored{{Id: "2", Score: 1}})
+		assert.NoError(t, err)
+	}
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, "0"), []cache.Scored{{Id: "1", Score: 1}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, "1"), []cache.Scored{{Id: "0", Score: 1}, {Id: "2", Score: 0.5}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.Set(ctx,
+		cache.Time(cache.Key(cache.LastModifyUserTime, "0"), time.Now()),
+		cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "0"), time.Now()))
+	assert.NoError(t, err)
+	// erase user data
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/user/0/data").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(ErasureReport{UserId: "0", User: true, Feedback: 2, CacheEntries: 7, NeighborLists: 1})).
+		End()
+	_, err = suite.DataClient.GetUser(ctx, "0")
+	assert.ErrorIs(t, err, errors.NotFound)
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, feedback)
+	for _, key := range []string{
+		cache.Key(cache.OfflineRecommend, "0"),
+		cache.Key(cache.OfflineRecommend, "0", "a"),
+		cache.Key(cache.CollaborativeRecommend, "0"),
+		cache.Key(cache.IgnoreItems, "0"),
+		cache.Key(cache.UserNeighbors, "0"),
+	} {
+		scores, err := suite.CacheClient.GetSorted(ctx, key, 0, -1)
+		assert.NoError(t, err)
+		assert.Empty(t, scores, key)
+	}
+	_, err = suite.CacheClient.Get(ctx, cache.Key(cache.LastModifyUserTime, "0")).Time()
+	assert.ErrorIs(t, err, errors.NotFound)
+	// data of other users are kept
+	scores, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, "1"), 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{Id: "2", Score: 0.5}}, scores)
+	scores, err = suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "1"), 0, -1)
+	assert.NoError(t, err)
+	assert.Len(t, scores, 1)
+	feedback, err = suite.DataClient.GetUserFeedback(ctx, "1", nil)
+	assert.NoError(t, err)
+	assert.Len(t, feedback, 1)
+	// erase again
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/user/0/data").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(ErasureReport{UserId: "0"})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestEraseUserDataWithAsyncFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.AsyncFeedback = true
+	suite.Config().Server.FeedbackWALPath = filepath.Join(t.TempDir(), "feedback.wal")
+	// feedback pending in the write-ahead log
+	err := suite.FeedbackWAL.Append([]data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "1", ItemId: "0"}},
+	}, true)
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/user/0/data").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(ErasureReport{UserId: "0", User: true, Feedback: 1})).
+		End()
+	// erased feedback is not written back by the next flush
+	err = suite.FeedbackWAL.Flush(ctx)
+	assert.NoError(t, err)
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, feedback)
+	feedback, err = suite.DataClient.GetUserFeedback(ctx, "1", nil)
+	assert.NoError(t, err)
+	assert.Len(t, feedback, 1)
+}
+
+func (suite *ServerTestSuite) TestMergeUser() {
+	ctx := context.Background()
+	t := suite.T()
+	err := suite.DataClient.BatchInsertUsers(ctx, []data.User{
+		{UserId: "anonymous", Labels: []string{"a", "b"}},
+		{UserId: "account", Labels: []string{"b", "c"}},
+	})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "1"}, {ItemId: "2"}, {ItemId: "3"}})
+	assert.NoError(t, err)
+	err = suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "anonymous", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "anonymous", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "account", ItemId: "2"}},
+	}, false, false, false)
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "anonymous"), []cache.Scored{{Id: "3", Score: 1}})
+	assert.NoError(t, err)
+
+	// merge the anonymous user into the account
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/user/anonymous/merge/account").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(MergeReport{From: "anonymous", To: "account", Feedback: 2, Labels: 1})).
+		End()
+	user, err := suite.DataClient.GetUser(ctx, "account")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "a"}, user.Labels)
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "account", nil)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2"}, lo.Map(feedback, func(f data.Feedback, _ int) string { return f.ItemId }))
+	_, err = suite.DataClient.GetUser(ctx, "anonymous")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	recommends, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "anonymous"), 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, recommends)
+
+	// the merged user is redirected
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "anonymous", ItemId: "3"}}}).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	feedback, err = suite.DataClient.GetUserFeedback(ctx, "account", nil)
+	assert.NoError(t, err)
+	assert.Len(t, feedback, 3)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "account"), []cache.Scored{{Id: "4", Score: 2}, {Id: "3", Score: 1}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/anonymous").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"4"})).
+		End()
+
+	// users are not merged into themselves
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/user/account/merge/anonymous").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestAsyncFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.AsyncFeedback = true
+	suite.Config().Server.FeedbackWALPath = filepath.Join(t.TempDir(), "feedback.wal")
+	suite.Config().Server.FeedbackFlushSize = 2
+	// insert feedback
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON([]Feedback{
+			{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}, Timestamp: "2000-01-01"},
+			{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}, Timestamp: "2000-01-01"},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 2}`).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Put("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON([]Feedback{
+			{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}, Timestamp: "2000-01-02"},
+			{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "2"}, Timestamp: "2000-01-02"},
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 2}`).
+		End()
+	// feedback are not inserted to the data store but the cache store
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", suite.Config().Now())
+	assert.NoError(t, err)
+	assert.Empty(t, feedback)
+	_, err = suite.CacheClient.Get(ctx, cache.Key(cache.LastModifyUserTime, "0")).Time()
+	assert.NoError(t, err)
+	activeUsers, err := suite.CacheClient.GetSorted(ctx, cache.ActiveUsers, 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0"}, cache.RemoveScores(activeUsers))
+	assert.FileExists(t, suite.Config().Server.FeedbackWALPath)
+	// flush feedback
+	err = suite.FeedbackWAL.Flush(ctx)
+	assert.NoError(t, err)
+	feedback, err = suite.DataClient.GetUserFeedback(ctx, "0", suite.Config().Now())
+	assert.NoError(t, err)
+	timestamps := make(map[string]time.Time)
+	for _, f := range feedback {
+		timestamps[f.ItemId] = f.Timestamp.In(time.UTC)
+	}
+	assert.Equal(t, map[string]time.Time{
+		"0": time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		"1": time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+		"2": time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+	}, timestamps)
+	assert.NoFileExists(t, suite.Config().Server.FeedbackWALPath)
+	assert.NoFileExists(t, suite.Config().Server.FeedbackWALPath+".flushing")
+	// flush empty write-ahead log
+	err = suite.FeedbackWAL.Flush(ctx)
+	assert.NoError(t, err)
+}
+
+func (suite *ServerTestSuite) TestFeedbackWALQuarantine() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.FeedbackWALPath = filepath.Join(t.TempDir(), "feedback.wal")
+	err := suite.FeedbackWAL.Append([]data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "0"}},
+	}, false)
+	assert.NoError(t, err)
+	// append a broken entry
+	file, err := os.OpenFile(suite.Config().Server.FeedbackWALPath, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = file.WriteString("{\"Feedback\":[\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	err = suite.FeedbackWAL.Append([]data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "1"}},
+	}, false)
+	assert.NoError(t, err)
+	// valid entries are flushed and the broken entry is quarantined
+	err = suite.FeedbackWAL.Flush(ctx)
+	assert.NoError(t, err)
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", nil)
+	assert.NoError(t, err)
+	assert.Len(t, feedback, 2)
+	corrupt, err := os.ReadFile(suite.Config().Server.FeedbackWALPath + ".corrupt")
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"Feedback\":[\n", string(corrupt))
+}
+
+func (suite *ServerTestSuite) TestAsyncWriteBack() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.AsyncFeedback = true
+	suite.Config().Server.FeedbackWALPath = filepath.Join(t.TempDir(), "feedback.wal")
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}, {"5", 95}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n":               "3",
+			"write-back-type": "read",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3"})).
 		End()
+	// write-back feedback are not inserted to the data store but the cache store
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", suite.Config().Now())
+	assert.NoError(t, err)
+	assert.Empty(t, feedback)
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1067,36 +3211,500 @@ func (suite *ServerTestSuite) TestGetRecommends() {
 		}).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal([]string{"6", "7", "8"})).
+		Body(suite.marshal([]string{"4", "5"})).
 		End()
+	// flush write-back feedback
+	err = suite.FeedbackWAL.Flush(ctx)
+	assert.NoError(t, err)
+	feedback, err = suite.DataClient.GetUserFeedback(ctx, "0", nil)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, lo.Map(feedback, func(f data.Feedback, _ int) string {
+		return f.ItemId
+	}))
 }
 
-func (suite *ServerTestSuite) TestGetRecommendsWithReplacement() {
+// slowFeedbackDatabase blocks insertion of feedback until unblocked.
+type slowFeedbackDatabase struct {
+	data.Database
+	unblock chan struct{}
+}
+
+func (d *slowFeedbackDatabase) BatchInsertFeedback(ctx context.Context, feedback []data.Feedback, insertUser, insertItem, overwrite bool) error {
+	<-d.unblock
+	return d.Database.BatchInsertFeedback(ctx, feedback, insertUser, insertItem, overwrite)
+}
+
+func (suite *ServerTestSuite) TestWriteBackWithSlowDataStore() {
 	ctx := context.Background()
 	t := suite.T()
-	suite.Config.Recommend.Replacement.EnableReplacement = true
-	// insert hidden items
-	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"0", 100}})
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}, {"5", 95}})
 	assert.NoError(t, err)
-	err = NewCacheModification(suite.CacheClient, suite.HiddenItemsManager).HideItem("0").Exec()
+	dataClient := suite.DataClient
+	database := &slowFeedbackDatabase{Database: dataClient, unblock: make(chan struct{})}
+	suite.DataClient = database
+	// recommendation is not blocked by write-back even if asynchronous feedback is disabled
+	done := make(chan struct{})
+	go func() {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			QueryParams(map[string]string{
+				"n":               "3",
+				"write-back-type": "read",
+			}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal([]string{"1", "2", "3"})).
+			End()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "recommendation is blocked by the data store")
+	}
+	// write-back feedback is flushed once the data store recovers
+	close(database.unblock)
+	<-done
+	err = suite.FeedbackWAL.Flush(ctx)
 	assert.NoError(t, err)
+	suite.DataClient = dataClient
+	feedback, err := suite.DataClient.GetUserFeedback(ctx, "0", nil)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, lo.Map(feedback, func(f data.Feedback, _ int) string {
+		return f.ItemId
+	}))
+}
+
+func (suite *ServerTestSuite) TestAuditLog() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Audit.EnableAudit = true
+	suite.Config().Audit.Path = filepath.Join(t.TempDir(), "audit.log")
+	suite.Config().Server.APIKeys = map[string]config.APIKeyConfig{"analytics": {Key: "analytics_secret"}}
+	userBody := `{"UserId":"0"}`
+	itemBody := `[{"ItemId":"0"}]`
+	for _, sink := range []string{"file", "data_store"} {
+		suite.Config().Audit.Sink = sink
+		startTime := time.Now()
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/user").
+			Header("X-API-Key", apiKey).
+			JSON(userBody).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+		apitest.New().
+			Handler(suite.handler).
+			Post("/api/items").
+			Header("X-API-Key", "analytics_secret").
+			JSON(itemBody).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+		// read operations are not audited
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/user/0").
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+		logs, err := suite.AuditLogger.Query(ctx, 10, &startTime, nil)
+		assert.NoError(t, err)
+		if assert.Len(t, logs, 2, sink) {
+			userDigest := sha256.Sum256([]byte(userBody))
+			itemDigest := sha256.Sum256([]byte(itemBody))
+			assert.Equal(t, AdminActor, logs[1].Actor)
+			assert.Equal(t, http.MethodPost, logs[1].Method)
+			assert.Equal(t, "/api/user", logs[1].Path)
+			assert.Equal(t, http.StatusOK, logs[1].Status)
+			assert.Equal(t, hex.EncodeToString(userDigest[:]), logs[1].Digest)
+			assert.Equal(t, "analytics", logs[0].Actor)
+			assert.Equal(t, "/api/items", logs[0].Path)
+			assert.Equal(t, hex.EncodeToString(itemDigest[:]), logs[0].Digest)
+		}
+	}
+}
+
+func (suite *ServerTestSuite) TestRecommendLatency() {
+	ctx := context.Background()
+	t := suite.T()
+	sampleCount := func(observer prometheus.Observer) uint64 {
+		var metric dto.Metric
+		err := observer.(prometheus.Histogram).Write(&metric)
+		assert.NoError(t, err)
+		return metric.GetHistogram().GetSampleCount()
+	}
+	numRecommend := sampleCount(RecommendSecondsVec.WithLabelValues(StatusSuccess))
+	numOffline := sampleCount(RecommendStageSecondsVec.WithLabelValues("offline", StatusSuccess))
+	numLatest := sampleCount(RecommendStageSecondsVec.WithLabelValues("latest", StatusSuccess))
+	numPopular := sampleCount(RecommendStageSecondsVec.WithLabelValues("popular", StatusSuccess))
 	// insert recommendation
-	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{
-		{Id: "1", Score: 99},
-		{Id: "2", Score: 98},
-		{Id: "3", Score: 97},
-		{Id: "4", Score: 96},
-		{Id: "5", Score: 95},
-		{Id: "6", Score: 94},
-		{Id: "7", Score: 93},
-		{Id: "8", Score: 92},
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "1", Score: 99}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), []cache.Scored{{Id: "2", Score: 99}, {Id: "3", Score: 98}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"n": "3"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3"})).
+		End()
+	// stages are observed only if they are executed
+	assert.Equal(t, numRecommend+1, sampleCount(RecommendSecondsVec.WithLabelValues(StatusSuccess)))
+	assert.Equal(t, numOffline+1, sampleCount(RecommendStageSecondsVec.WithLabelValues("offline", StatusSuccess)))
+	assert.Equal(t, numLatest+1, sampleCount(RecommendStageSecondsVec.WithLabelValues("latest", StatusSuccess)))
+	assert.Equal(t, numPopular, sampleCount(RecommendStageSecondsVec.WithLabelValues("popular", StatusSuccess)))
+}
+
+func (suite *ServerTestSuite) TestRecommendETag() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert recommendation
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "1", Score: 99}, {Id: "2", Score: 98}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.Set(ctx, cache.String(cache.Key(cache.OfflineRecommendDigest, "0"), suite.Config().OfflineRecommendDigest()))
+	assert.NoError(t, err)
+	etag := apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		HeaderPresent("ETag").
+		Body(suite.marshal([]string{"1", "2"})).
+		End().Response.Header.Get("ETag")
+	// not modified
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"0", ` + etag} {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			Header("If-None-Match", ifNoneMatch).
+			Expect(t).
+			Status(http.StatusNotModified).
+			Header("ETag", etag).
+			Body("").
+			End()
+	}
+	// different query
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Header("If-None-Match", etag).
+		QueryParams(map[string]string{"n": "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1"})).
+		End()
+	// recommendation updated
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "3", Score: 99}, {Id: "2", Score: 98}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Header("If-None-Match", etag).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"3", "2"})).
+		End()
+}
+
+func (suite *ServerTestSuite) TestHydrateItems() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert items
+	err := suite.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "1", Categories: []string{"a"}, Labels: []string{"x"}, Comment: "one"},
+		{ItemId: "2", Categories: []string{"a"}, Labels: []string{"y"}, Comment: "two"},
 	})
 	assert.NoError(t, err)
+	scores := []cache.Scored{{Id: "1", Score: 99}, {Id: "2", Score: 98}, {Id: "3", Score: 97}}
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), scores)
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems), scores)
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), scores)
+	assert.NoError(t, err)
+	// hydrate recommendation
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hydrate": "true", "fields": "labels,comment"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]HydratedItem{
+			{ItemId: "1", Labels: []string{"x"}, Comment: proto.String("one")},
+			{ItemId: "2", Labels: []string{"y"}, Comment: proto.String("two")},
+			{ItemId: "3"},
+		})).
+		End()
+	// hydrate popular and latest items
+	for _, url := range []string{"/api/popular", "/api/latest"} {
+		apitest.New().
+			Handler(suite.handler).
+			Get(url).
+			Header("X-API-Key", apiKey).
+			QueryParams(map[string]string{"hydrate": "true", "fields": "categories", "n": "2"}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal([]HydratedItem{
+				{ItemId: "1", Score: proto.Float64(99), Categories: []string{"a"}},
+				{ItemId: "2", Score: proto.Float64(98), Categories: []string{"a"}},
+			})).
+			End()
+	}
+	// unknown fields
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/popular").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"hydrate": "true", "fields": "score"}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestSortedCursor() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert popular items
+	scores := []cache.Scored{{"0", 100}, {"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}}
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems), scores)
+	assert.NoError(t, err)
+	err = NewCacheModification(suite.CacheClient, suite.HiddenItemsManager).HideItem("1").Exec()
+	assert.NoError(t, err)
+	// scan pages
+	var (
+		pages  [][]cache.Scored
+		cursor string
+	)
+	for i := 0; i < 3; i++ {
+		var page []cache.Scored
+		params := map[string]string{"n": "2"}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		result := apitest.New().
+			Handler(suite.handler).
+			Get("/api/popular").
+			Header("X-API-Key", apiKey).
+			QueryParams(params).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+		result.JSON(&page)
+		cursor = result.Response.Header.Get(NextCursorHeader)
+		pages = append(pages, page)
+		if cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, [][]cache.Scored{
+		{{"0", 100}, {"2", 98}},
+		{{"3", 97}, {"4", 96}},
+		{},
+	}, pages)
+	// invalid cursor
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/popular").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"cursor": "invalid"}).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+}
+
+func (suite *ServerTestSuite) TestSortedListCache() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.LocalCacheSize = 1
+	count := func(result string) float64 {
+		var metric dto.Metric
+		err := LocalCacheRequestsTotalVec.WithLabelValues(result).Write(&metric)
+		assert.NoError(t, err)
+		return metric.GetCounter().GetValue()
+	}
+	numHit, numMiss := count(CacheHit), count(CacheMiss)
+	getSorted := func(url string, expected []cache.Scored) {
+		apitest.New().
+			Handler(suite.handler).
+			Get(url).
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal(expected)).
+			End()
+	}
+	// load popular items
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems), []cache.Scored{{"0", 100}})
+	assert.NoError(t, err)
+	getSorted("/api/popular", []cache.Scored{{"0", 100}})
+	assert.Equal(t, numMiss+1, count(CacheMiss))
+	// cached popular items
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.PopularItems), []cache.Scored{{"1", 100}})
+	assert.NoError(t, err)
+	getSorted("/api/popular", []cache.Scored{{"0", 100}})
+	assert.Equal(t, numHit+1, count(CacheHit))
+	// evict popular items by latest items
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), []cache.Scored{{"2", 100}})
+	assert.NoError(t, err)
+	getSorted("/api/latest", []cache.Scored{{"2", 100}})
+	getSorted("/api/popular", []cache.Scored{{"1", 100}})
+	assert.Equal(t, numMiss+3, count(CacheMiss))
+	// expired latest items
+	suite.Config().Server.LocalCacheTTL = time.Nanosecond
+	getSorted("/api/latest", []cache.Scored{{"2", 100}})
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), []cache.Scored{{"3", 100}})
+	assert.NoError(t, err)
+	getSorted("/api/latest", []cache.Scored{{"3", 100}})
+	assert.Equal(t, numHit+1, count(CacheHit))
+	assert.Equal(t, numMiss+5, count(CacheMiss))
+}
+
+func (suite *ServerTestSuite) TestResultCache() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Server.ResultCacheTTL = time.Minute
+	count := func(result string) float64 {
+		var metric dto.Metric
+		err := ResultCacheRequestsTotalVec.WithLabelValues(result).Write(&metric)
+		assert.NoError(t, err)
+		return metric.GetCounter().GetValue()
+	}
+	numHit, numMiss := count(CacheHit), count(CacheMiss)
+	getRecommend := func(n string, expected []string) {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			QueryParams(map[string]string{"n": n}).
+			Expect(t).
+			Status(http.StatusOK).
+			Body(suite.marshal(expected)).
+			End()
+	}
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"1", 100}, {"2", 99}})
+	assert.NoError(t, err)
+	getRecommend("1", []string{"1"})
+	assert.Equal(t, numMiss+1, count(CacheMiss))
+	// cached result of the identical request
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{"3", 100}, {"4", 99}})
+	assert.NoError(t, err)
+	getRecommend("1", []string{"1"})
+	assert.Equal(t, numHit+1, count(CacheHit))
+	// requests with different parameters are not cached
+	getRecommend("2", []string{"3", "4"})
+	assert.Equal(t, numMiss+2, count(CacheMiss))
+	// expired result
+	suite.Config().Server.ResultCacheTTL = time.Nanosecond
+	getRecommend("1", []string{"3"})
+	assert.Equal(t, numMiss+3, count(CacheMiss))
+}
+
+func (suite *ServerTestSuite) TestDailyCounterExpire() {
+	ctx := context.Background()
+	t := suite.T()
+	expired := cache.Key(cache.FallbackUsage, today().Add(-DailyCountTTL).Format("2006-01-02"))
+	err := suite.CacheClient.AddSorted(ctx, cache.Sorted(expired, []cache.Scored{{Id: "new/offline/node", Score: 1}}))
+	assert.NoError(t, err)
+	counter := NewDailyCounter(&suite.RestServer, cache.FallbackUsage)
+	counter.Add("new/offline", 1)
+	_, err = counter.Sync(ctx)
+	assert.NoError(t, err)
+	scores, err := suite.CacheClient.GetSorted(ctx, expired, 0, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, scores)
+	counts, err := counter.Load(ctx, today())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"new/offline": 1}, counts)
+}
+
+func (suite *ServerTestSuite) TestFallbackUsage() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert recommendation
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "1", Score: 99}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "3"), []cache.Scored{{Id: "1", Score: 99}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), []cache.Scored{{Id: "2", Score: 99}})
+	assert.NoError(t, err)
+	// insert activities
+	err = suite.CacheClient.Set(ctx,
+		cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "0"), time.Now()),
+		cache.Time(cache.Key(cache.LastModifyUserTime, "0"), time.Now()),
+		cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "2"), time.Now()),
+		cache.Time(cache.Key(cache.LastModifyUserTime, "2"), time.Now().AddDate(0, -2, 0)))
+	assert.NoError(t, err)
+	for _, userId := range []string{"0", "1", "2", "3"} {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/"+userId).
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+	}
+	// check fallback usage rates
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for _, expected := range []Measurement{
+		{Name: cache.Key(FallbackUsageRate, ActiveUserCohort, "offline"), Timestamp: today, Value: 1},
+		{Name: cache.Key(FallbackUsageRate, NewUserCohort, "latest"), Timestamp: today, Value: 0.5},
+		{Name: cache.Key(FallbackUsageRate, NewUserCohort, "offline"), Timestamp: today, Value: 0.5},
+		{Name: cache.Key(FallbackUsageRate, DormantUserCohort, "latest"), Timestamp: today, Value: 1},
+	} {
+		measurements, err := suite.GetMeasurements(ctx, expected.Name, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, measurements, 1) {
+			assert.Equal(t, expected.Value, measurements[0].Value)
+			assert.True(t, expected.Timestamp.Equal(measurements[0].Timestamp))
+		}
+	}
+}
+
+func (suite *ServerTestSuite) TestSourceFeedback() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"like", "star"}
+	// insert recommendation
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "1", Score: 99}, {Id: "2", Score: 98}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.LatestItems), []cache.Scored{{Id: "3", Score: 99}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"n": "3"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3"})).
+		End()
+	// check served times of items
+	for itemId, count := range map[string]int{"1": 1, "3": 1, "4": 0} {
+		served, err := suite.SourceFeedbackTracker.ItemServed(ctx, itemId, today())
+		assert.NoError(t, err)
+		assert.Equal(t, count, served)
+	}
 	// insert feedback
-	feedback := []data.Feedback{
-		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "2"}},
-		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "4"}},
-		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "1"}, Timestamp: time.Now().Add(time.Hour)},
+	feedback := []Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "star", UserId: "0", ItemId: "1"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "read", UserId: "0", ItemId: "2"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "3"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "4"}},
 	}
 	apitest.New().
 		Handler(suite.handler).
@@ -1105,26 +3713,62 @@ func (suite *ServerTestSuite) TestGetRecommendsWithReplacement() {
 		JSON(feedback).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(`{"RowAffected": 3}`).
+		Body(`{"RowAffected": 5}`).
 		End()
+	// check positive feedback rates
+	for source, rate := range map[string]float32{"offline": 0.5, "latest": 1} {
+		measurements, err := suite.GetMeasurements(ctx, cache.Key(SourcePositiveFeedbackRate, source), 10)
+		assert.NoError(t, err)
+		if assert.Len(t, measurements, 1) {
+			assert.Equal(t, rate, measurements[0].Value)
+		}
+	}
+}
+
+func (suite *ServerTestSuite) TestSourceFeedbackColdStart() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"like"}
+	suite.Config().Recommend.Offline.ExploreRecommend = map[string]float64{"cold_start": 0.1}
+	// insert recommendation with a cold-start item
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), []cache.Scored{{Id: "1", Score: 99}, {Id: "2", Score: 98}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.ColdStartItems), []cache.Scored{{Id: "2", Score: 99}})
+	assert.NoError(t, err)
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
 		Header("X-API-Key", apiKey).
-		QueryParams(map[string]string{
-			"n": "3",
-		}).
+		QueryParams(map[string]string{"n": "2"}).
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal([]string{"1", "2", "3"})).
+		Body(suite.marshal([]string{"1", "2"})).
+		End()
+	// insert feedback
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/feedback").
+		Header("X-API-Key", apiKey).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "like", UserId: "0", ItemId: "2"}}}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(`{"RowAffected": 1}`).
 		End()
+	// cold-start items are measured separately
+	for source, rate := range map[string]float32{"offline": 0, ColdStartSource: 1} {
+		measurements, err := suite.GetMeasurements(ctx, cache.Key(SourcePositiveFeedbackRate, source), 10)
+		assert.NoError(t, err)
+		if assert.Len(t, measurements, 1) {
+			assert.Equal(t, rate, measurements[0].Value)
+		}
+	}
 }
 
 func (suite *ServerTestSuite) TestServerGetRecommendsFallbackItemBasedSimilar() {
 	ctx := context.Background()
 	t := suite.T()
-	suite.Config.Recommend.Online.NumFeedbackFallbackItemBased = 4
-	suite.Config.Recommend.DataSource.PositiveFeedbackTypes = []string{"a"}
+	suite.Config().Recommend.Online.NumFeedbackFallbackItemBased = 4
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"a"}
 	// insert recommendation
 	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
 		[]cache.Scored{{"1", 99}, {"2", 98}, {"3", 97}, {"4", 96}})
@@ -1206,7 +3850,7 @@ func (suite *ServerTestSuite) TestServerGetRecommendsFallbackItemBasedSimilar()
 	assert.NoError(t, err)
 
 	// test fallback
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"item_based"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"item_based"}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1218,7 +3862,7 @@ func (suite *ServerTestSuite) TestServerGetRecommendsFallbackItemBasedSimilar()
 		Status(http.StatusOK).
 		Body(suite.marshal([]string{"9", "8", "7"})).
 		End()
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"item_based"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"item_based"}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0/*").
@@ -1283,7 +3927,7 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackUserBasedSimilar() {
 	})
 	assert.NoError(t, err)
 	// test fallback
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"user_based"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"user_based"}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1308,6 +3952,15 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackUserBasedSimilar() {
 		End()
 }
 
+func init() {
+	recommender.Register("test_custom", recommender.Func(func(ctx context.Context, request *recommender.Request) ([]cache.Scored, error) {
+		if request.Category == "*" {
+			return []cache.Scored{{"104", 4}, {"301", 3}, {"302", 2}, {"303", 1}}, nil
+		}
+		return []cache.Scored{{"3", 5}, {"201", 4}, {"202", 3}, {"203", 2}, {"204", 1}}, nil
+	}))
+}
+
 func (suite *ServerTestSuite) TestGetRecommendsFallbackPreCached() {
 	ctx := context.Background()
 	t := suite.T()
@@ -1340,7 +3993,7 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackPreCached() {
 		[]cache.Scored{{"113", 79}, {"114", 78}, {"115", 77}, {"116", 76}})
 	assert.NoError(t, err)
 	// test popular fallback
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"popular"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"popular"}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1364,7 +4017,7 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackPreCached() {
 		Body(suite.marshal([]string{"101", "102", "103", "104", "109", "110", "111", "112"})).
 		End()
 	// test latest fallback
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"latest"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"latest"}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1387,8 +4040,33 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackPreCached() {
 		Status(http.StatusOK).
 		Body(suite.marshal([]string{"101", "102", "103", "104", "105", "106", "107", "108"})).
 		End()
+	// test category fallback
+	suite.Config().Recommend.Online.CategoryFallbackRecommend = map[string][]string{"*": {"popular"}}
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "8",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3", "4", "5", "6", "7", "8"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0/*").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "8",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"101", "102", "103", "104", "109", "110", "111", "112"})).
+		End()
+	suite.Config().Recommend.Online.CategoryFallbackRecommend = nil
 	// test collaborative filtering
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"collaborative"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"collaborative"}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1411,8 +4089,33 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackPreCached() {
 		Status(http.StatusOK).
 		Body(suite.marshal([]string{"101", "102", "103", "104", "113", "114", "115", "116"})).
 		End()
+	// test custom fallback
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"test_custom"}
+	assert.NoError(t, ValidateFallbackRecommend(suite.Config().Recommend.Online.FallbackRecommend))
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "8",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"1", "2", "3", "4", "201", "202", "203", "204"})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0/*").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{
+			"n": "6",
+		}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]string{"101", "102", "103", "104", "301", "302"})).
+		End()
 	// test wrong fallback
-	suite.Config.Recommend.Online.FallbackRecommend = []string{""}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{""}
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/recommend/0").
@@ -1428,8 +4131,8 @@ func (suite *ServerTestSuite) TestGetRecommendsFallbackPreCached() {
 func (suite *ServerTestSuite) TestSessionRecommend() {
 	ctx := context.Background()
 	t := suite.T()
-	suite.Config.Recommend.Online.NumFeedbackFallbackItemBased = 4
-	suite.Config.Recommend.DataSource.PositiveFeedbackTypes = []string{"a"}
+	suite.Config().Recommend.Online.NumFeedbackFallbackItemBased = 4
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"a"}
 
 	// insert hidden items
 	apitest.New().
@@ -1533,7 +4236,7 @@ func (suite *ServerTestSuite) TestSessionRecommend() {
 		Status(http.StatusOK).
 		Body(suite.marshal([]cache.Scored(nil))).
 		End()
-	suite.Config.Recommend.Online.FallbackRecommend = []string{"item_based"}
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"item_based"}
 	apitest.New().
 		Handler(suite.handler).
 		Post("/api/session/recommend/*").
@@ -1548,6 +4251,61 @@ func (suite *ServerTestSuite) TestSessionRecommend() {
 		End()
 }
 
+func (suite *ServerTestSuite) TestSessionRecommendWithSessionId() {
+	ctx := context.Background()
+	t := suite.T()
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"a"}
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.ItemNeighbors, "1"), []cache.Scored{{"2", 3}, {"3", 2}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.ItemNeighbors, "2"), []cache.Scored{{"3", 2}, {"4", 1}})
+	assert.NoError(t, err)
+
+	// recommend for a new session
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/session/recommend").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"session-id": "0"}).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "a", ItemId: "1"}, Timestamp: "2010-01-01T00:00:00Z"}}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"2", 3}, {"3", 2}})).
+		End()
+	// items read in the session are excluded
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/session/recommend").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"session-id": "0"}).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "read", ItemId: "2"}, Timestamp: "2010-01-02T00:00:00Z"}}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"3", 2}})).
+		End()
+	session, err := LoadSession(ctx, suite.CacheClient, "0")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, lo.Map(session.Feedback, func(f data.Feedback, _ int) string { return f.ItemId }))
+	// feedback of expired sessions is discarded
+	err = SaveSession(ctx, suite.CacheClient, "1", Session{Feedback: session.Feedback, UpdateTime: time.Now().Add(-time.Hour)})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/session/recommend").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"session-id": "1"}).
+		JSON([]Feedback{{FeedbackKey: data.FeedbackKey{FeedbackType: "a", ItemId: "2"}, Timestamp: "2010-01-03T00:00:00Z"}}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"3", 2}, {"4", 1}})).
+		End()
+	// sessions are never inserted into the data store
+	_, users, err := suite.DataClient.GetUsers(ctx, "", 100)
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+	_, err = suite.DataClient.GetItem(ctx, "1")
+	assert.True(t, errors.Is(err, errors.NotFound))
+}
+
 func (suite *ServerTestSuite) TestVisibility() {
 	ctx := context.Background()
 	t := suite.T()
@@ -1862,68 +4620,363 @@ func (suite *ServerTestSuite) TestVisibility() {
 		End()
 }
 
+func (suite *ServerTestSuite) TestRequestId() {
+	t := suite.T()
+	// use the request id from the client
+	req := httptest.NewRequest(http.MethodGet, "/api/health/live", nil)
+	req.Header.Set("X-Request-ID", "client-request-1")
+	resp := httptest.NewRecorder()
+	suite.handler.ServeHTTP(resp, req)
+	assert.Equal(t, "client-request-1", resp.Header().Get("X-Request-ID"))
+	// replace invalid request id
+	req = httptest.NewRequest(http.MethodGet, "/api/health/live", nil)
+	req.Header.Set("X-Request-ID", "invalid request id")
+	resp = httptest.NewRecorder()
+	suite.handler.ServeHTTP(resp, req)
+	assert.NotEmpty(t, resp.Header().Get("X-Request-ID"))
+	assert.NotEqual(t, "invalid request id", resp.Header().Get("X-Request-ID"))
+	assert.False(t, isValidRequestId(strings.Repeat("a", 129)))
+}
+
 func (suite *ServerTestSuite) TestHealth() {
 	t := suite.T()
-	// ready
+	// not started
+	suite.Health = HealthTracker{}
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/health/startup").
+		Expect(t).
+		Status(http.StatusServiceUnavailable).
+		End()
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/health/live").
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal(HealthStatus{
-			Ready:               true,
-			DataStoreError:      nil,
-			CacheStoreError:     nil,
-			DataStoreConnected:  true,
-			CacheStoreConnected: true,
-		})).
+		Body(suite.marshal(HealthStatus{})).
 		End()
 	apitest.New().
+		Handler(suite.handler).
+		Get("/api/health/ready").
+		Expect(t).
+		Status(http.StatusServiceUnavailable).
+		End()
+
+	// ready
+	suite.Health.Sync(time.Now(), nil)
+	suite.Health.TrackModel("click_model", 1, 1)
+	suite.Health.Start()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/health/startup").
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	r := apitest.New().
 		Handler(suite.handler).
 		Get("/api/health/ready").
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal(HealthStatus{
-			Ready:               true,
-			DataStoreError:      nil,
-			CacheStoreError:     nil,
-			DataStoreConnected:  true,
-			CacheStoreConnected: true,
-		})).
 		End()
+	// errors are not decodable
+	type health struct {
+		Ready               bool
+		DataStoreConnected  bool
+		CacheStoreConnected bool
+		Started             bool
+		Dependencies        map[string]DependencyStatus
+		LastSync            *time.Time
+		ModelStaleness      map[string]time.Duration
+	}
+	var healthStatus health
+	r.JSON(&healthStatus)
+	assert.True(t, healthStatus.Ready)
+	assert.True(t, healthStatus.Started)
+	assert.True(t, healthStatus.DataStoreConnected)
+	assert.True(t, healthStatus.CacheStoreConnected)
+	assert.NotNil(t, healthStatus.LastSync)
+	assert.Equal(t, map[string]time.Duration{"click_model": 0}, healthStatus.ModelStaleness)
+	assert.ElementsMatch(t, []string{"master", "data_store", "cache_store"}, lo.Keys(healthStatus.Dependencies))
+	for _, dependency := range healthStatus.Dependencies {
+		assert.True(t, dependency.Connected)
+	}
 
 	// not ready
 	dataClient, cacheClient := suite.DataClient, suite.CacheClient
 	suite.DataClient, suite.CacheClient = data.NoDatabase{}, cache.NoDatabase{}
+	suite.Health.Sync(time.Now(), errors.New("connection refused"))
+	suite.Health.TrackModel("click_model", 1, 2)
 	apitest.New().
 		Handler(suite.handler).
 		Get("/api/health/live").
 		Expect(t).
 		Status(http.StatusOK).
-		Body(suite.marshal(HealthStatus{
-			Ready:               false,
-			DataStoreError:      data.ErrNoDatabase,
-			CacheStoreError:     cache.ErrNoDatabase,
-			DataStoreConnected:  false,
-			CacheStoreConnected: false,
-		})).
 		End()
-	apitest.New().
+	r = apitest.New().
 		Handler(suite.handler).
 		Get("/api/health/ready").
 		Expect(t).
 		Status(http.StatusServiceUnavailable).
-		Body(suite.marshal(HealthStatus{
-			Ready:               false,
-			DataStoreError:      data.ErrNoDatabase,
-			CacheStoreError:     cache.ErrNoDatabase,
-			DataStoreConnected:  false,
-			CacheStoreConnected: false,
-		})).
 		End()
+	healthStatus = health{}
+	r.JSON(&healthStatus)
+	assert.False(t, healthStatus.Ready)
+	assert.False(t, healthStatus.DataStoreConnected)
+	assert.False(t, healthStatus.CacheStoreConnected)
+	assert.Equal(t, data.ErrNoDatabase.Error(), healthStatus.Dependencies["data_store"].Error)
+	assert.Equal(t, cache.ErrNoDatabase.Error(), healthStatus.Dependencies["cache_store"].Error)
+	assert.Equal(t, "connection refused", healthStatus.Dependencies["master"].Error)
+	assert.NotNil(t, healthStatus.LastSync)
+	assert.Contains(t, healthStatus.ModelStaleness, "click_model")
 	suite.DataClient, suite.CacheClient = dataClient, cacheClient
 }
 
+func (suite *ServerTestSuite) TestDegradedRecommend() {
+	ctx := context.Background()
+	t := suite.T()
+	// insert offline recommendation and popular items
+	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"),
+		[]cache.Scored{{"1", 99}, {"2", 98}})
+	assert.NoError(t, err)
+	err = suite.CacheClient.SetSorted(ctx, cache.PopularItems,
+		[]cache.Scored{{"3", 91}, {"4", 90}})
+	assert.NoError(t, err)
+	suite.Config().Recommend.Online.FallbackRecommend = []string{"item_based", "popular"}
+	suite.Config().Server.BreakerThreshold = 2
+	suite.Config().Server.BreakerCooldown = 100 * time.Millisecond
+
+	// serve results in the cache store if the data store fails
+	dataClient := suite.DataClient
+	suite.DataClient = data.NoDatabase{}
+	for i := 0; i < 2; i++ {
+		assert.True(t, suite.DataStoreBreaker.Allow())
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/recommend/0").
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			Header("X-Degraded", DataStoreDegraded).
+			Body(suite.marshal([]string{"1", "2", "3", "4"})).
+			End()
+	}
+	// the data store isn't accessed once the circuit breaker opens
+	assert.False(t, suite.DataStoreBreaker.Allow())
+	suite.DataClient = dataClient
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		Header("X-Degraded", DataStoreDegraded).
+		Body(suite.marshal([]string{"1", "2", "3", "4"})).
+		End()
+	// the data store is accessed again after the cooldown
+	time.Sleep(100 * time.Millisecond)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		HeaderNotPresent("X-Degraded").
+		Body(suite.marshal([]string{"1", "2", "3", "4"})).
+		End()
+	assert.True(t, suite.DataStoreBreaker.Allow())
+
+	// return errors if the circuit breaker is disabled
+	suite.Config().Server.BreakerThreshold = 0
+	suite.DataClient = data.NoDatabase{}
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/recommend/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusInternalServerError).
+		End()
+	suite.DataClient = dataClient
+}
+
+// blockingDatabase blocks GetUser until unblocked.
+type blockingDatabase struct {
+	data.Database
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (d *blockingDatabase) GetUser(ctx context.Context, userId string) (data.User, error) {
+	d.entered <- struct{}{}
+	<-d.unblock
+	return d.Database.GetUser(ctx, userId)
+}
+
+func (suite *ServerTestSuite) TestDataStoreBusy() {
+	t := suite.T()
+	dataClient := suite.DataClient
+	database := &blockingDatabase{Database: dataClient, entered: make(chan struct{}), unblock: make(chan struct{})}
+	suite.DataClient = data.WithLimits(database, data.Limits{MaxConcurrency: 1, WaitTimeout: 10 * time.Millisecond})
+	err := suite.DataClient.BatchInsertUsers(context.Background(), []data.User{{UserId: "0"}})
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		apitest.New().
+			Handler(suite.handler).
+			Get("/api/user/0").
+			Header("X-API-Key", apiKey).
+			Expect(t).
+			Status(http.StatusOK).
+			End()
+		close(done)
+	}()
+	<-database.entered
+	// requests exceeding the concurrency limit are rejected
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/user/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusServiceUnavailable).
+		End()
+	close(database.unblock)
+	<-done
+	suite.DataClient = dataClient
+}
+
+// mockSearchStore is an in-memory search store matching the query against labels and comments of items.
+type mockSearchStore struct {
+	items map[string]data.Item
+}
+
+func (m *mockSearchStore) Init(_ context.Context) error { return nil }
+
+func (m *mockSearchStore) Close() error { return nil }
+
+func (m *mockSearchStore) IndexItems(_ context.Context, items []data.Item) error {
+	for _, item := range items {
+		m.items[item.ItemId] = item
+	}
+	return nil
+}
+
+func (m *mockSearchStore) DeleteItems(_ context.Context, itemIds []string) error {
+	for _, itemId := range itemIds {
+		delete(m.items, itemId)
+	}
+	return nil
+}
+
+func (m *mockSearchStore) SearchItems(_ context.Context, query string, labels []string, offset, n int) ([]cache.Scored, error) {
+	itemIds := lo.Keys(m.items)
+	sort.Strings(itemIds)
+	scores := make([]cache.Scored, 0)
+	for _, itemId := range itemIds {
+		item := m.items[itemId]
+		if item.IsHidden || !strings.Contains(strings.Join(item.Labels, " ")+" "+item.Comment, query) {
+			continue
+		}
+		if len(labels) > 0 && len(lo.Intersect(item.Labels, labels)) == 0 {
+			continue
+		}
+		scores = append(scores, cache.Scored{Id: itemId, Score: 1})
+	}
+	if offset >= len(scores) {
+		return []cache.Scored{}, nil
+	}
+	return scores[offset:lo.Min([]int{offset + n, len(scores)})], nil
+}
+
+func (suite *ServerTestSuite) TestSearchItems() {
+	t := suite.T()
+	// search store is not configured
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/search/items").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"q": "movie"}).
+		Expect(t).
+		Status(http.StatusNotImplemented).
+		End()
+
+	// index inserted items
+	store := &mockSearchStore{items: make(map[string]data.Item)}
+	suite.SearchClient = store
+	items := []data.Item{
+		{ItemId: "0", Labels: []string{"comedy"}, Comment: "a funny movie"},
+		{ItemId: "1", Labels: []string{"horror"}, Comment: "a scary movie"},
+		{ItemId: "2", Labels: []string{"comedy"}, Comment: "a funny show"},
+	}
+	apitest.New().
+		Handler(suite.handler).
+		Post("/api/items").
+		Header("X-API-Key", apiKey).
+		JSON(items).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal(Success{RowAffected: 3})).
+		End()
+	assert.Len(t, store.items, 3)
+
+	// search items
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/search/items").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusBadRequest).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/search/items").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"q": "movie"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"0", 1}, {"1", 1}})).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/search/items").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"label": "comedy", "offset": "1"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"2", 1}})).
+		End()
+
+	// filter labeled neighbors by the search store
+	err := suite.CacheClient.SetSorted(context.Background(), cache.Key(cache.ItemNeighbors, "0"),
+		[]cache.Scored{{"1", 3}, {"2", 2}})
+	assert.NoError(t, err)
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/item/0/neighbors").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"label": "comedy"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"2", 2}})).
+		End()
+
+	// remove deleted items
+	apitest.New().
+		Handler(suite.handler).
+		Delete("/api/item/0").
+		Header("X-API-Key", apiKey).
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+	apitest.New().
+		Handler(suite.handler).
+		Get("/api/search/items").
+		Header("X-API-Key", apiKey).
+		QueryParams(map[string]string{"q": "funny"}).
+		Expect(t).
+		Status(http.StatusOK).
+		Body(suite.marshal([]cache.Scored{{"2", 1}})).
+		End()
+}
+
 func TestServer(t *testing.T) {
 	suite.Run(t, new(ServerTestSuite))
 }
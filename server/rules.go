@@ -0,0 +1,239 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+const (
+	RulePin   = "pin"   // pin an item in a slot
+	RuleBoost = "boost" // boost items with a label
+	RuleBlock = "block" // never recommend items with a label
+)
+
+// Rule is a business rule applied in online recommendation. A rule applies to recommendations in its category only,
+// where the empty category means recommendations without categories, and to users with the user label if it is set.
+type Rule struct {
+	Name      string  `json:"name"`
+	Action    string  `json:"action"` // pin, boost or block
+	Category  string  `json:"category,omitempty"`
+	UserLabel string  `json:"user_label,omitempty"`
+	ItemId    string  `json:"item_id,omitempty"`    // item to pin
+	Slot      int     `json:"slot,omitempty"`       // 1-based slot to pin the item
+	ItemLabel string  `json:"item_label,omitempty"` // label of items to boost or block
+	Factor    float64 `json:"factor,omitempty"`     // factor to multiply scores of boosted items, e.g. 1.2 boosts by 20%
+}
+
+// Validate returns an error if the rule is malformed.
+func (r Rule) Validate() error {
+	switch r.Action {
+	case RulePin:
+		if r.ItemId == "" || r.Slot < 1 {
+			return errors.NotValidf("pin rule `%s` without item or slot", r.Name)
+		}
+	case RuleBoost:
+		if r.ItemLabel == "" || r.Factor <= 0 {
+			return errors.NotValidf("boost rule `%s` without label or positive factor", r.Name)
+		}
+	case RuleBlock:
+		if r.ItemLabel == "" {
+			return errors.NotValidf("block rule `%s` without label", r.Name)
+		}
+	default:
+		return errors.NotValidf("action `%s` of rule `%s`", r.Action, r.Name)
+	}
+	return nil
+}
+
+// SaveRules validates and saves business rules to the cache store.
+func SaveRules(ctx context.Context, client cache.Database, rules []Rule) error {
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	buf, err := cache.Marshal(client, rules)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.BusinessRules), buf))
+}
+
+// LoadRules loads business rules from the cache store.
+func LoadRules(ctx context.Context, client cache.Database) ([]Rule, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.BusinessRules)).String()
+	if errors.Is(err, errors.NotFound) {
+		return []Rule{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var rules []Rule
+	if err = cache.Unmarshal(buf, &rules); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return rules, nil
+}
+
+// RuleManager caches business rules in the server. Rules are reloaded from the cache store after the cache expire.
+type RuleManager struct {
+	server     *RestServer
+	mu         sync.Mutex
+	rules      []Rule
+	updateTime time.Time
+}
+
+func NewRuleManager(s *RestServer) *RuleManager {
+	return &RuleManager{server: s}
+}
+
+// Rules returns business rules applied to recommendations in the category.
+func (rm *RuleManager) Rules(ctx context.Context, category string) ([]Rule, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if time.Since(rm.updateTime) > rm.server.Config().Server.CacheExpire {
+		rules, err := LoadRules(ctx, rm.server.CacheClient)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		rm.rules, rm.updateTime = rules, time.Now()
+	}
+	return lo.Filter(rm.rules, func(rule Rule, _ int) bool {
+		return rule.Category == category
+	}), nil
+}
+
+// applyRules re-ranks results by business rules. Results are scored by reciprocal ranks, then items with blocked labels
+// are removed, scores of items with boosted labels are multiplied and pinned items are moved or inserted into slots.
+func (s *RestServer) applyRules(ctx *recommendContext) error {
+	if s.RuleManager == nil {
+		return nil
+	}
+	rules, err := s.RuleManager.Rules(ctx.context, ctx.category)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// append rules of the user segment
+	segment, err := s.userSegment(ctx.context, ctx.userId)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if segment != nil {
+		rules = append(rules, lo.Filter(segment.Rules, func(rule Rule, _ int) bool {
+			return rule.Category == ctx.category
+		})...)
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	// filter rules by user labels, user labels are unknown if the recommendation degrades
+	if lo.ContainsBy(rules, func(rule Rule) bool { return rule.UserLabel != "" }) {
+		var user data.User
+		if s.useDataStore(ctx) {
+			user, err = s.DataClient.GetUser(ctx.context, ctx.userId)
+			if s.dataStoreFailed(err) {
+				ctx.degraded = true
+			} else if err != nil && !errors.Is(err, errors.NotFound) {
+				return errors.Trace(err)
+			}
+		}
+		userLabels := strset.New(user.Labels...)
+		rules = lo.Filter(rules, func(rule Rule, _ int) bool {
+			return rule.UserLabel == "" || userLabels.Has(rule.UserLabel)
+		})
+	}
+	pins := lo.Filter(rules, func(rule Rule, _ int) bool { return rule.Action == RulePin })
+	sort.SliceStable(pins, func(i, j int) bool { return pins[i].Slot < pins[j].Slot })
+	// load labels of items, labels are unknown if the recommendation degrades
+	itemLabels := make(map[string]*strset.Set)
+	if len(pins) < len(rules) && s.useDataStore(ctx) {
+		itemIds := append(lo.Map(pins, func(pin Rule, _ int) string { return pin.ItemId }), ctx.results...)
+		items, err := s.DataClient.BatchGetItems(ctx.context, itemIds)
+		if s.dataStoreFailed(err) {
+			ctx.degraded = true
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+		for _, item := range items {
+			itemLabels[item.ItemId] = strset.New(item.Labels...)
+		}
+	}
+	// block items and boost scores
+	type scoredResult struct {
+		itemId string
+		source string
+		score  float64
+	}
+	isBlocked := func(itemId string) bool {
+		labels, exist := itemLabels[itemId]
+		return exist && lo.ContainsBy(rules, func(rule Rule) bool {
+			return rule.Action == RuleBlock && labels.Has(rule.ItemLabel)
+		})
+	}
+	results := make([]scoredResult, 0, len(ctx.results))
+	for i, itemId := range ctx.results {
+		if isBlocked(itemId) {
+			continue
+		}
+		result := scoredResult{itemId: itemId, source: ctx.sources[i], score: 1 / float64(i+1)}
+		if labels, exist := itemLabels[itemId]; exist {
+			for _, rule := range rules {
+				if rule.Action == RuleBoost && labels.Has(rule.ItemLabel) {
+					result.score *= rule.Factor
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+	// pin items
+	for _, pin := range pins {
+		pinned := scoredResult{itemId: pin.ItemId, source: RuleSource}
+		index := -1
+		for i := range results {
+			if results[i].itemId == pin.ItemId {
+				index = i
+				break
+			}
+		}
+		if index >= 0 {
+			pinned.source = results[index].source
+			results = append(results[:index], results[index+1:]...)
+		} else if !ctx.isCandidate(pin.ItemId) || isBlocked(pin.ItemId) {
+			continue
+		} else if hidden, err := s.HiddenItemsManager.IsHidden([]string{pin.ItemId}, ctx.category); err != nil {
+			return errors.Trace(err)
+		} else if hidden[0] {
+			continue
+		}
+		slot := lo.Min([]int{pin.Slot - 1, len(results)})
+		results = append(results[:slot], append([]scoredResult{pinned}, results[slot:]...)...)
+		ctx.excludeSet.Add(pin.ItemId)
+	}
+	ctx.results = lo.Map(results, func(r scoredResult, _ int) string { return r.itemId })
+	ctx.sources = lo.Map(results, func(r scoredResult, _ int) string { return r.source })
+	return nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"go.uber.org/zap"
+)
+
+// RerankFunction is the function defined by re-ranking scripts.
+const RerankFunction = "rerank"
+
+// unsafeScriptFunctions are functions of the base library removed from the sandbox of scripts, since they load code,
+// access files or write to the standard output.
+var unsafeScriptFunctions = []string{"dofile", "loadfile", "load", "loadstring", "module", "require", "print",
+	"_printregs", "collectgarbage"}
+
+// ScriptItem is an item passed to the re-ranking script with the recommender it comes from.
+type ScriptItem struct {
+	data.Item
+	Source string
+}
+
+// RerankScript re-ranks online recommendation by the Lua script in the configuration. The script is compiled once and
+// recompiled after the cache expire, while each call runs in a new sandboxed state so that calls are isolated.
+type RerankScript struct {
+	server     *RestServer
+	mu         sync.Mutex
+	path       string
+	proto      *lua.FunctionProto
+	updateTime time.Time
+}
+
+func NewRerankScript(s *RestServer) *RerankScript {
+	return &RerankScript{server: s}
+}
+
+// load returns the compiled script. Nil is returned if the script is not configured.
+func (rs *RerankScript) load() (*lua.FunctionProto, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	path := rs.server.Config().Recommend.Online.RerankScript
+	if path == "" {
+		return nil, nil
+	}
+	if path != rs.path || time.Since(rs.updateTime) > rs.server.Config().Server.CacheExpire {
+		proto, err := CompileScript(path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		rs.path, rs.proto, rs.updateTime = path, proto, time.Now()
+	}
+	return rs.proto, nil
+}
+
+// CompileScript compiles the Lua script in the file.
+func CompileScript(path string) (*lua.FunctionProto, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer file.Close()
+	chunk, err := parse.Parse(file, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return proto, nil
+}
+
+// Rerank calls the function rerank of the script and returns item ids in the order returned by the script. Ids not in
+// items and duplicated ids are ignored. Nil is returned if the script is not configured.
+func (rs *RerankScript) Rerank(ctx context.Context, userId, category string, items []ScriptItem) ([]string, error) {
+	proto, err := rs.load()
+	if err != nil || proto == nil {
+		return nil, errors.Trace(err)
+	}
+	L, err := newScriptState()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer L.Close()
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	if err = L.PCall(0, lua.MultRet, nil); err != nil {
+		return nil, errors.Trace(err)
+	}
+	rerank, ok := L.GetGlobal(RerankFunction).(*lua.LFunction)
+	if !ok {
+		return nil, errors.NotFoundf("function %s in %s", RerankFunction, proto.SourceName)
+	}
+	request := L.NewTable()
+	request.RawSetString("user_id", lua.LString(userId))
+	request.RawSetString("category", lua.LString(category))
+	request.RawSetString("items", newScriptItems(L, items))
+	if err = L.CallByParam(lua.P{Fn: rerank, NRet: 1, Protect: true}, request); err != nil {
+		return nil, errors.Trace(err)
+	}
+	ret, ok := L.Get(-1).(*lua.LTable)
+	if !ok {
+		return nil, errors.NotValidf("return value `%s` of %s", L.Get(-1).Type(), RerankFunction)
+	}
+	candidates := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		candidates[item.ItemId] = struct{}{}
+	}
+	var results []string
+	for i := 1; i <= ret.Len(); i++ {
+		itemId := lua.LVAsString(ret.RawGetInt(i))
+		if _, exist := candidates[itemId]; exist {
+			results = append(results, itemId)
+			delete(candidates, itemId)
+		}
+	}
+	return results, nil
+}
+
+// newScriptState creates a state with base, string, table and math libraries only.
+func newScriptState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 128, RegistrySize: 1024, RegistryMaxSize: 64 * 1024})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		if err := L.CallByParam(lua.P{Fn: L.NewFunction(lib.open), NRet: 0, Protect: true}, lua.LString(lib.name)); err != nil {
+			L.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+	for _, name := range unsafeScriptFunctions {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L, nil
+}
+
+func newScriptItems(L *lua.LState, items []ScriptItem) *lua.LTable {
+	newStrings := func(values []string) *lua.LTable {
+		table := L.CreateTable(len(values), 0)
+		for _, value := range values {
+			table.Append(lua.LString(value))
+		}
+		return table
+	}
+	table := L.CreateTable(len(items), 0)
+	for _, item := range items {
+		row := L.CreateTable(0, 7)
+		row.RawSetString("item_id", lua.LString(item.ItemId))
+		row.RawSetString("source", lua.LString(item.Source))
+		row.RawSetString("is_hidden", lua.LBool(item.IsHidden))
+		row.RawSetString("categories", newStrings(item.Categories))
+		row.RawSetString("labels", newStrings(item.Labels))
+		if !item.Timestamp.IsZero() {
+			row.RawSetString("timestamp", lua.LNumber(item.Timestamp.Unix()))
+		}
+		row.RawSetString("comment", lua.LString(item.Comment))
+		table.Append(row)
+	}
+	return table
+}
+
+// applyRerankScript re-ranks results by the script. Items of results are loaded from the data store, only item ids are
+// passed to the script if the recommendation degrades. Results are left unchanged if the script fails or times out.
+func (s *RestServer) applyRerankScript(ctx *recommendContext) error {
+	if s.RerankScript == nil || s.Config().Recommend.Online.RerankScript == "" || len(ctx.results) == 0 {
+		return nil
+	}
+	itemIndex := make(map[string]data.Item, len(ctx.results))
+	if s.useDataStore(ctx) {
+		loaded, err := s.DataClient.BatchGetItems(ctx.context, ctx.results)
+		if s.dataStoreFailed(err) {
+			ctx.degraded = true
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+		for _, item := range loaded {
+			itemIndex[item.ItemId] = item
+		}
+	}
+	items := make([]ScriptItem, len(ctx.results))
+	sources := make(map[string]string, len(ctx.results))
+	for i, itemId := range ctx.results {
+		item, exist := itemIndex[itemId]
+		if !exist {
+			item = data.Item{ItemId: itemId}
+		}
+		items[i] = ScriptItem{Item: item, Source: ctx.sources[i]}
+		sources[itemId] = ctx.sources[i]
+	}
+	results, err := s.rerankByScript(ctx, items)
+	if err != nil {
+		log.ResponseLogger(ctx.response).Warn("failed to run re-ranking script, fallback to recommendation without the script", zap.Error(err))
+		return nil
+	}
+	ctx.results = results
+	ctx.sources = make([]string, len(results))
+	for i, itemId := range results {
+		ctx.sources[i] = sources[itemId]
+	}
+	return nil
+}
+
+// rerankByScript calls the script with a timeout.
+func (s *RestServer) rerankByScript(ctx *recommendContext, items []ScriptItem) (_ []string, err error) {
+	defer observeStage("script", time.Now(), &err)
+	scriptCtx, cancel := context.WithTimeout(ctx.context, s.Config().Recommend.Online.RerankScriptTimeout)
+	defer cancel()
+	results, err := s.RerankScript.Rerank(scriptCtx, ctx.userId, ctx.category, items)
+	return results, errors.Trace(err)
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/search"
+	"go.uber.org/zap"
+)
+
+// IndexItems inserts or replaces items in the search store if configured. Failures are logged only since items have
+// been saved in the data store, and they would be indexed again once modified.
+func (s *RestServer) IndexItems(ctx context.Context, items []data.Item) {
+	if s.SearchClient == nil || len(items) == 0 {
+		return
+	}
+	if err := s.SearchClient.IndexItems(ctx, items); err != nil {
+		log.Logger().Error("failed to index items", zap.Int("n_items", len(items)), zap.Error(err))
+	}
+}
+
+// UnindexItems removes items from the search store if configured.
+func (s *RestServer) UnindexItems(ctx context.Context, itemIds ...string) {
+	if s.SearchClient == nil || len(itemIds) == 0 {
+		return
+	}
+	if err := s.SearchClient.DeleteItems(ctx, itemIds); err != nil {
+		log.Logger().Error("failed to remove items from index", zap.Strings("item_ids", itemIds), zap.Error(err))
+	}
+}
+
+// searchItems searches items by full text of labels and comments, and filters items by labels.
+func (s *RestServer) searchItems(request *restful.Request, response *restful.Response) {
+	if s.SearchClient == nil {
+		Error(response, http.StatusNotImplemented, errors.NotSupportedf("item search without search store"))
+		return
+	}
+	ctx := request.Request.Context()
+	query := request.QueryParameter("q")
+	labels := request.QueryParameters("label")
+	if query == "" && len(labels) == 0 {
+		BadRequest(response, errors.New("query or labels are required"))
+		return
+	}
+	offset, err := ParseInt(request, "offset", 0)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	n, err := ParseInt(request, "n", s.Config().Server.DefaultN)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	hydrateFields, err := ParseHydrateFields(request)
+	if err != nil {
+		BadRequest(response, err)
+		return
+	}
+	scores, err := s.SearchClient.SearchItems(ctx, query, labels, offset, n)
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	// remove items hidden by the cache
+	isHidden, err := s.HiddenItemsManager.IsHidden(cache.RemoveScores(scores), "")
+	if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	items := make([]cache.Scored, 0, len(scores))
+	for i, score := range scores {
+		if !isHidden[i] {
+			items = append(items, score)
+		}
+	}
+	if hydrateFields != nil {
+		hydratedItems, err := s.HydrateItems(ctx, items, true, hydrateFields)
+		if err != nil {
+			InternalServerError(response, err)
+			return
+		}
+		Ok(response, hydratedItems)
+		return
+	}
+	Ok(response, items)
+}
+
+// searchLabeledItems returns up to search.MaxResults visible items with any of the labels from the search store.
+func (s *RestServer) searchLabeledItems(ctx context.Context, labels []string) ([]string, error) {
+	scores, err := s.SearchClient.SearchItems(ctx, "", labels, 0, search.MaxResults)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cache.RemoveScores(scores), nil
+}
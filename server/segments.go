@@ -0,0 +1,212 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
+	"github.com/zhenghaoz/gorse/recommender"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+var (
+	// exploreRecommenders are recommenders whose items are explored in offline recommendation.
+	exploreRecommenders = []string{"popular", "latest", "cold_start"}
+	// fallbackRecommenders are recommenders used when offline recommendation drained out.
+	fallbackRecommenders = []string{"collaborative", "item_based", "user_based", "latest", "popular"}
+)
+
+// Segment is a named cohort of users. Users with all the labels of a segment are assigned to it, and a user is in the
+// first segment matched only. Configurations of recommendation are overridden for users in the segment:
+//   - exploration weights override explore_recommend, which apply after offline recommendation is refreshed.
+//   - fallback recommenders override fallback_recommend of all categories.
+//   - business rules are applied together with global rules.
+type Segment struct {
+	Name              string             `json:"name"`
+	Labels            []string           `json:"labels"`
+	ExploreRecommend  map[string]float64 `json:"explore_recommend,omitempty"`
+	FallbackRecommend []string           `json:"fallback_recommend,omitempty"`
+	Rules             []Rule             `json:"rules,omitempty"`
+}
+
+// Validate returns an error if the segment is malformed.
+func (s Segment) Validate() error {
+	if s.Name == "" {
+		return errors.NotValidf("segment without name")
+	}
+	if len(s.Labels) == 0 {
+		return errors.NotValidf("segment `%s` without labels", s.Name)
+	}
+	if err := ValidateExploreRecommend(s.ExploreRecommend); err != nil {
+		return errors.Annotatef(err, "segment `%s`", s.Name)
+	}
+	if err := ValidateFallbackRecommend(s.FallbackRecommend); err != nil {
+		return errors.Annotatef(err, "segment `%s`", s.Name)
+	}
+	for _, rule := range s.Rules {
+		if err := rule.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// ValidateExploreRecommend returns an error if there are unknown recommenders or weights out of [0, 1].
+func ValidateExploreRecommend(weights map[string]float64) error {
+	for recommender, weight := range weights {
+		if !lo.Contains(exploreRecommenders, recommender) {
+			return errors.NotValidf("explore recommender `%s`", recommender)
+		} else if weight < 0 || weight > 1 {
+			return errors.NotValidf("explore weight `%v` of `%s`", weight, recommender)
+		}
+	}
+	return nil
+}
+
+// ValidateFallbackRecommend returns an error if there are unknown recommenders. Registered custom recommenders are
+// valid as well.
+func ValidateFallbackRecommend(recommenders []string) error {
+	for _, name := range recommenders {
+		if !lo.Contains(fallbackRecommenders, name) && !lo.Contains(recommender.Names(), name) {
+			return errors.NotValidf("fallback recommender `%s`", name)
+		}
+	}
+	return nil
+}
+
+// GetExploreRecommend returns the exploration weight of a recommender in the segment. The second return value is false
+// if the weight is not overridden.
+func (s *Segment) GetExploreRecommend(recommender string) (float64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	weight, exist := s.ExploreRecommend[recommender]
+	return weight, exist
+}
+
+// MatchSegment returns the first segment whose labels are all in user labels. Nil is returned if no segment matches.
+func MatchSegment(segments []Segment, userLabels []string) *Segment {
+	if len(segments) == 0 {
+		return nil
+	}
+	labels := strset.New(userLabels...)
+	for i := range segments {
+		if labels.Has(segments[i].Labels...) {
+			return &segments[i]
+		}
+	}
+	return nil
+}
+
+// SaveSegments validates and saves user segments to the cache store.
+func SaveSegments(ctx context.Context, client cache.Database, segments []Segment) error {
+	names := strset.New()
+	for _, segment := range segments {
+		if err := segment.Validate(); err != nil {
+			return errors.Trace(err)
+		} else if names.Has(segment.Name) {
+			return errors.NotValidf("duplicate segment `%s`", segment.Name)
+		}
+		names.Add(segment.Name)
+	}
+	buf, err := cache.Marshal(client, segments)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.UserSegments), buf))
+}
+
+// LoadSegments loads user segments from the cache store.
+func LoadSegments(ctx context.Context, client cache.Database) ([]Segment, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.GlobalMeta, cache.UserSegments)).String()
+	if errors.Is(err, errors.NotFound) {
+		return []Segment{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var segments []Segment
+	if err = cache.Unmarshal(buf, &segments); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return segments, nil
+}
+
+// SegmentManager caches user segments in the server. Segments are reloaded from the cache store after the cache expire.
+type SegmentManager struct {
+	server     *RestServer
+	mu         sync.Mutex
+	segments   []Segment
+	updateTime time.Time
+}
+
+func NewSegmentManager(s *RestServer) *SegmentManager {
+	return &SegmentManager{server: s}
+}
+
+// Segments returns all user segments.
+func (sm *SegmentManager) Segments(ctx context.Context) ([]Segment, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if time.Since(sm.updateTime) > sm.server.Config().Server.CacheExpire {
+		segments, err := LoadSegments(ctx, sm.server.CacheClient)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sm.segments, sm.updateTime = segments, time.Now()
+	}
+	return sm.segments, nil
+}
+
+// userSegment returns the segment of a user. Nil is returned if there are no segments or the user is in no segment.
+func (s *RestServer) userSegment(ctx context.Context, userId string) (*Segment, error) {
+	if s.SegmentManager == nil {
+		return nil, nil
+	}
+	segments, err := s.SegmentManager.Segments(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	// user labels are unknown if the data store is unavailable
+	var user data.User
+	if s.dataStoreAvailable() {
+		user, err = s.DataClient.GetUser(ctx, userId)
+		if !s.dataStoreFailed(err) && err != nil && !errors.Is(err, errors.NotFound) {
+			return nil, errors.Trace(err)
+		}
+	}
+	return MatchSegment(segments, user.Labels), nil
+}
+
+// FallbackRecommend returns fallback recommenders for a user in a category. Fallback recommenders of the segment of the
+// user take precedence over those of the category.
+func (s *RestServer) FallbackRecommend(ctx context.Context, userId, category string) ([]string, error) {
+	segment, err := s.userSegment(ctx, userId)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if segment != nil && len(segment.FallbackRecommend) > 0 {
+		return segment.FallbackRecommend, nil
+	}
+	return s.Config().Recommend.Online.GetFallbackRecommend(category), nil
+}
@@ -15,27 +15,35 @@
 package server
 
 import (
+	"bufio"
+	"container/list"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
 	"github.com/juju/errors"
 	"github.com/samber/lo"
 	"github.com/scylladb/go-set/strset"
 	"github.com/zhenghaoz/gorse/base"
+	"github.com/zhenghaoz/gorse/base/encoding"
 	"github.com/zhenghaoz/gorse/base/log"
 	"github.com/zhenghaoz/gorse/cmd/version"
 	"github.com/zhenghaoz/gorse/config"
 	"github.com/zhenghaoz/gorse/protocol"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/feature"
+	"github.com/zhenghaoz/gorse/storage/search"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 	"math"
 	"math/rand"
+	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -43,25 +51,40 @@ import (
 // Server manages states of a server node.
 type Server struct {
 	RestServer
-	traceConfig  config.TracingConfig
-	cachePath    string
-	cachePrefix  string
-	dataPath     string
-	dataPrefix   string
-	masterClient protocol.MasterClient
-	serverName   string
-	masterHost   string
-	masterPort   int
-	testMode     bool
-	cacheFile    string
+	traceConfig    config.TracingConfig
+	cachePath      string
+	cachePrefix    string
+	cacheOptions   cache.Options
+	dataPath       string
+	dataReplica    string
+	dataTarget     string
+	dataPrefix     string
+	dataLimits     data.Limits
+	dataQueryLog   data.QueryLogOptions
+	searchPath     string
+	searchPrefix   string
+	featurePath    string
+	featureOptions feature.Options
+	masterClient   protocol.MasterClient
+	serverName     string
+	masterHost     string
+	masterPort     int
+	testMode       bool
+	cacheFile      string
+	localCache     *LocalCache
+	tlsConfig      *protocol.TLSConfig
+	stopSync       chan struct{}
+	stopOnce       sync.Once
 }
 
 // NewServer creates a server node.
-func NewServer(masterHost string, masterPort int, serverHost string, serverPort int, cacheFile string) *Server {
+func NewServer(masterHost string, masterPort int, serverHost string, serverPort int, cacheFile string, tlsConfig *protocol.TLSConfig) *Server {
 	s := &Server{
 		masterHost: masterHost,
 		masterPort: masterPort,
 		cacheFile:  cacheFile,
+		tlsConfig:  tlsConfig,
+		stopSync:   make(chan struct{}),
 		RestServer: RestServer{
 			Settings:   config.NewSettings(),
 			HttpHost:   serverHost,
@@ -71,6 +94,21 @@ func NewServer(masterHost string, masterPort int, serverHost string, serverPort
 	}
 	s.RestServer.PopularItemsCache = NewPopularItemsCache(&s.RestServer)
 	s.RestServer.HiddenItemsManager = NewHiddenItemsManager(&s.RestServer)
+	s.RestServer.FallbackUsageTracker = NewFallbackUsageTracker(&s.RestServer)
+	s.RestServer.QuotaManager = NewQuotaManager(&s.RestServer)
+	s.RestServer.SourceFeedbackTracker = NewSourceFeedbackTracker(&s.RestServer)
+	s.RestServer.AuditLogger = NewAuditLogger(&s.RestServer)
+	s.RestServer.FeedbackWAL = NewFeedbackWAL(&s.RestServer)
+	s.RestServer.FeedbackDeduplicator = NewFeedbackDeduplicator(&s.RestServer)
+	s.RestServer.SortedListCache = NewSortedListCache(&s.RestServer)
+	s.RestServer.ResultCache = NewResultCache(&s.RestServer)
+	s.RestServer.RuleManager = NewRuleManager(&s.RestServer)
+	s.RestServer.CategoryManager = NewCategoryManager(&s.RestServer)
+	s.RestServer.AliasManager = NewAliasManager(&s.RestServer)
+	s.RestServer.SegmentManager = NewSegmentManager(&s.RestServer)
+	s.RestServer.DataStoreBreaker = NewCircuitBreaker(&s.RestServer)
+	s.RestServer.Bidder = NewHTTPBidder(&s.RestServer)
+	s.RestServer.RerankScript = NewRerankScript(&s.RestServer)
 	return s
 }
 
@@ -95,6 +133,7 @@ func (s *Server) Serve() {
 		}
 	}
 	s.serverName = state.ServerName
+	s.localCache = state
 	log.Logger().Info("start server",
 		zap.String("server_name", s.serverName),
 		zap.String("server_host", s.HttpHost),
@@ -103,91 +142,221 @@ func (s *Server) Serve() {
 		zap.Int("master_port", s.masterPort))
 
 	// connect to master
-	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", s.masterHost, s.masterPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opt, err := protocol.NewDialOption(s.tlsConfig)
+	if err != nil {
+		log.Logger().Fatal("failed to load tls config", zap.Error(err))
+	}
+	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", s.masterHost, s.masterPort), opt)
 	if err != nil {
 		log.Logger().Fatal("failed to connect master", zap.Error(err))
 	}
 	s.masterClient = protocol.NewMasterClient(conn)
 
 	go s.Sync()
+	go s.RunFeedbackWAL()
 	container := restful.NewContainer()
 	s.StartHttpServer(container)
 }
 
+// Shutdown stops accepting new requests and waits for in-flight requests until the shutdown timeout. Then, the server
+// node deregisters from the master and flushes feedback and the local cache file.
 func (s *Server) Shutdown() {
-	err := s.HttpServer.Shutdown(context.TODO())
-	if err != nil {
-		log.Logger().Fatal("failed to shutdown http server", zap.Error(err))
+	s.stopOnce.Do(func() { close(s.stopSync) })
+	ctx, cancel := context.WithTimeout(context.Background(), s.Config().Server.ShutdownTimeout)
+	defer cancel()
+	if err := s.HttpServer.Shutdown(ctx); err != nil {
+		log.Logger().Error("failed to finish in-flight requests", zap.Error(err))
+		if err = s.HttpServer.Close(); err != nil {
+			log.Logger().Error("failed to close http server", zap.Error(err))
+		}
+	}
+	if err := s.FeedbackWAL.Flush(context.Background()); err != nil {
+		log.Logger().Error("failed to flush feedback write-ahead log", zap.Error(err))
+	}
+	if s.masterClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Config().Master.MetaTimeout)
+		defer cancel()
+		if _, err := s.masterClient.Deregister(ctx, &protocol.NodeInfo{
+			NodeType: protocol.NodeType_ServerNode,
+			NodeName: s.serverName,
+		}); err != nil {
+			log.Logger().Error("failed to deregister from master", zap.Error(err))
+		}
+	}
+	if s.localCache != nil {
+		if err := s.localCache.WriteLocalCache(); err != nil {
+			log.Logger().Error("failed to write local cache", zap.Error(err), zap.String("path", s.cacheFile))
+		}
 	}
 }
 
+// RunFeedbackWAL flushes the write-ahead log of feedback. Write-back feedback is appended to the write-ahead log even
+// if asynchronous feedback is disabled.
+func (s *Server) RunFeedbackWAL() {
+	defer base.CheckPanic()
+	s.FeedbackWAL.Run()
+}
+
 // Sync this server to the master.
 func (s *Server) Sync() {
 	defer base.CheckPanic()
-	log.Logger().Info("start meta sync", zap.Duration("meta_timeout", s.Config.Master.MetaTimeout))
+	log.Logger().Info("start meta sync", zap.Duration("meta_timeout", s.Config().Master.MetaTimeout))
 	for {
 		var meta *protocol.Meta
+		var masterConfig *config.Config
 		var err error
-		if meta, err = s.masterClient.GetMeta(context.Background(),
+		start := time.Now()
+		meta, err = s.masterClient.GetMeta(context.Background(),
 			&protocol.NodeInfo{
 				NodeType:      protocol.NodeType_ServerNode,
 				NodeName:      s.serverName,
 				HttpPort:      int64(s.HttpPort),
 				BinaryVersion: version.Version,
-			}); err != nil {
+			})
+		s.Health.Sync(start, err)
+		if err != nil {
 			log.Logger().Error("failed to get meta", zap.Error(err))
 			goto sleep
 		}
 
 		// load master config
-		err = json.Unmarshal([]byte(meta.Config), &s.Config)
-		if err != nil {
+		masterConfig = new(config.Config)
+		if err = json.Unmarshal([]byte(meta.Config), masterConfig); err != nil {
 			log.Logger().Error("failed to parse master config", zap.Error(err))
 			goto sleep
 		}
+		if cfg, changed := s.Config().Update(masterConfig); len(changed) > 0 {
+			s.SetConfig(cfg)
+			log.Logger().Info("update master config", zap.Strings("changed", changed))
+		}
 
 		// connect to data store
-		if s.dataPath != s.Config.Database.DataStore || s.dataPrefix != s.Config.Database.DataTablePrefix {
+		if s.dataPath != s.Config().Database.DataStore || s.dataReplica != s.Config().Database.DataStoreReplica ||
+			s.dataPrefix != s.Config().Database.DataTablePrefix || s.dataLimits != s.Config().Database.DataStoreLimits() ||
+			s.dataQueryLog != s.Config().Database.DataStoreQueryLog() || s.dataTarget != s.Config().Database.DataStoreTarget {
 			log.Logger().Info("connect data store",
-				zap.String("database", log.RedactDBURL(s.Config.Database.DataStore)))
-			if s.DataClient, err = data.Open(s.Config.Database.DataStore, s.Config.Database.DataTablePrefix); err != nil {
+				zap.String("database", log.RedactDBURL(s.Config().Database.DataStore)))
+			var dataClient data.Database
+			if dataClient, err = data.OpenWithReplica(s.Config().Database.DataStore,
+				s.Config().Database.DataStoreReplica, s.Config().Database.DataTablePrefix); err != nil {
 				log.Logger().Error("failed to connect data store", zap.Error(err))
 				goto sleep
 			}
-			s.dataPath = s.Config.Database.DataStore
-			s.dataPrefix = s.Config.Database.DataTablePrefix
+			dataClient = data.WithQueryLog(dataClient, s.Config().Database.DataStoreQueryLog())
+			if s.Config().Database.DataStoreTarget != "" {
+				// writes are sent to both data stores while the master copies data to the target data store
+				var targetClient data.Database
+				if targetClient, err = data.Open(s.Config().Database.DataStoreTarget, s.Config().Database.DataTablePrefix); err != nil {
+					log.Logger().Error("failed to connect target data store", zap.Error(err))
+					goto sleep
+				}
+				dataClient = data.WithDualWrite(dataClient, targetClient)
+			}
+			s.DataClient = data.WithLimits(dataClient, s.Config().Database.DataStoreLimits())
+			s.dataLimits = s.Config().Database.DataStoreLimits()
+			s.dataQueryLog = s.Config().Database.DataStoreQueryLog()
+			s.dataPath = s.Config().Database.DataStore
+			s.dataReplica = s.Config().Database.DataStoreReplica
+			s.dataTarget = s.Config().Database.DataStoreTarget
+			s.dataPrefix = s.Config().Database.DataTablePrefix
 		}
 
 		// connect to cache store
-		if s.cachePath != s.Config.Database.CacheStore || s.cachePrefix != s.Config.Database.CacheTablePrefix {
+		if s.cachePath != s.Config().Database.CacheStore || s.cachePrefix != s.Config().Database.CachePrefix() ||
+			s.cacheOptions != s.Config().Database.CacheStoreOptions() {
 			log.Logger().Info("connect cache store",
-				zap.String("database", log.RedactDBURL(s.Config.Database.CacheStore)))
-			if s.CacheClient, err = cache.Open(s.Config.Database.CacheStore, s.Config.Database.CacheTablePrefix); err != nil {
+				zap.String("database", log.RedactDBURL(s.Config().Database.CacheStore)))
+			if s.CacheClient, err = cache.Open(s.Config().Database.CacheStore, s.Config().Database.CachePrefix()); err != nil {
 				log.Logger().Error("failed to connect cache store", zap.Error(err))
 				goto sleep
 			}
-			s.cachePath = s.Config.Database.CacheStore
-			s.cachePrefix = s.Config.Database.CacheTablePrefix
+			cache.Configure(s.CacheClient, s.Config().Database.CacheStoreOptions())
+			s.cachePath = s.Config().Database.CacheStore
+			s.cachePrefix = s.Config().Database.CachePrefix()
+			s.cacheOptions = s.Config().Database.CacheStoreOptions()
+		}
+
+		// connect to search store
+		if s.searchPath != s.Config().Database.SearchStore || s.searchPrefix != s.Config().Database.DataTablePrefix {
+			if s.Config().Database.SearchStore == "" {
+				s.SearchClient = nil
+			} else {
+				log.Logger().Info("connect search store",
+					zap.String("database", log.RedactDBURL(s.Config().Database.SearchStore)))
+				var searchClient search.Database
+				if searchClient, err = search.Open(s.Config().Database.SearchStore, s.Config().Database.DataTablePrefix); err != nil {
+					log.Logger().Error("failed to connect search store", zap.Error(err))
+					goto sleep
+				}
+				s.SearchClient = searchClient
+			}
+			s.searchPath = s.Config().Database.SearchStore
+			s.searchPrefix = s.Config().Database.DataTablePrefix
+		}
+
+		// connect to feature store
+		if s.featurePath != s.Config().Database.FeatureStore || s.featureOptions != s.Config().Database.FeatureStoreOptions() {
+			var featureClient feature.Store
+			if s.Config().Database.FeatureStore != "" {
+				log.Logger().Info("connect feature store",
+					zap.String("database", log.RedactDBURL(s.Config().Database.FeatureStore)))
+				if featureClient, err = feature.Open(s.Config().Database.FeatureStore, s.Config().Database.FeatureStoreOptions()); err != nil {
+					log.Logger().Error("failed to connect feature store", zap.Error(err))
+					goto sleep
+				}
+			}
+			if s.FeatureClient != nil {
+				_ = s.FeatureClient.Close()
+			}
+			s.FeatureClient = featureClient
+			s.featurePath = s.Config().Database.FeatureStore
+			s.featureOptions = s.Config().Database.FeatureStoreOptions()
+		}
+
+		// pull click model for time contexts
+		if s.Config().Recommend.Offline.EnableTimeContext && meta.ClickModelVersion != 0 && meta.ClickModelVersion != s.ClickModelVersion {
+			log.Logger().Info("start pull click model", zap.String("version", encoding.Hex(meta.ClickModelVersion)))
+			var receiver protocol.Master_GetClickModelClient
+			if receiver, err = s.masterClient.GetClickModel(context.Background(),
+				&protocol.VersionInfo{Version: meta.ClickModelVersion, Compress: true},
+				grpc.MaxCallRecvMsgSize(math.MaxInt)); err != nil {
+				log.Logger().Error("failed to pull click model", zap.Error(err))
+			} else if clickModel, _, err := protocol.UnmarshalClickModel(receiver); err != nil {
+				log.Logger().Error("failed to pull click model", zap.Error(err))
+			} else {
+				s.ClickModel = clickModel
+				s.ClickModelVersion = meta.ClickModelVersion
+				log.Logger().Info("synced click model", zap.String("version", encoding.Hex(s.ClickModelVersion)))
+			}
+		}
+		if s.Config().Recommend.Offline.EnableTimeContext {
+			s.Health.TrackModel("click_model", s.ClickModelVersion, meta.ClickModelVersion)
 		}
 
 		// create trace provider
-		if !s.traceConfig.Equal(s.Config.Tracing) {
-			log.Logger().Info("create trace provider", zap.Any("tracing_config", s.Config.Tracing))
-			tp, err := s.Config.Tracing.NewTracerProvider()
+		if !s.traceConfig.Equal(s.Config().Tracing) {
+			log.Logger().Info("create trace provider", zap.Any("tracing_config", s.Config().Tracing))
+			tp, err := s.Config().Tracing.NewTracerProvider()
 			if err != nil {
 				log.Logger().Fatal("failed to create trace provider", zap.Error(err))
 			}
 			otel.SetTracerProvider(tp)
 			otel.SetErrorHandler(log.GetErrorHandler())
 			otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
-			s.traceConfig = s.Config.Tracing
+			s.traceConfig = s.Config().Tracing
 		}
+		s.Health.Start()
 
 	sleep:
 		if s.testMode {
 			return
 		}
-		time.Sleep(s.Config.Master.MetaTimeout)
+		select {
+		case <-s.stopSync:
+			log.Logger().Info("stop meta sync")
+			return
+		case <-time.After(s.Config().Master.MetaTimeout):
+		}
 	}
 }
 
@@ -206,8 +375,8 @@ func NewPopularItemsCache(s *RestServer) *PopularItemsCache {
 	go func() {
 		for {
 			sc.sync()
-			log.Logger().Debug("refresh server side popular items cache", zap.String("cache_expire", s.Config.Server.CacheExpire.String()))
-			time.Sleep(s.Config.Server.CacheExpire)
+			log.Logger().Debug("refresh server side popular items cache", zap.String("cache_expire", s.Config().Server.CacheExpire.String()))
+			time.Sleep(s.Config().Server.CacheExpire)
 		}
 	}()
 	return sc
@@ -250,6 +419,151 @@ func (sc *PopularItemsCache) GetSortedScore(member string) float64 {
 	return sc.scores[member]
 }
 
+type sortedListEntry struct {
+	key    string
+	scores []cache.Scored
+	expire time.Time
+}
+
+// SortedListCache is a server-local LRU cache of sorted lists in the cache store. Lists are loaded from the cache store
+// on miss and expire after the time-to-live.
+type SortedListCache struct {
+	server  *RestServer
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func NewSortedListCache(s *RestServer) *SortedListCache {
+	return &SortedListCache{
+		server:  s,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// GetSorted gets scores between begin and end (inclusive) of a sorted list, where end -1 means the end of the list.
+// The cache store is read directly if the cache is disabled.
+func (sc *SortedListCache) GetSorted(ctx context.Context, key string, begin, end int) ([]cache.Scored, error) {
+	if sc.server.Config().Server.LocalCacheSize <= 0 {
+		return sc.server.CacheClient.GetSorted(ctx, key, begin, end)
+	}
+	sc.mu.Lock()
+	if element, exist := sc.entries[key]; exist && time.Now().Before(element.Value.(*sortedListEntry).expire) {
+		sc.lru.MoveToFront(element)
+		scores := element.Value.(*sortedListEntry).scores
+		sc.mu.Unlock()
+		LocalCacheRequestsTotalVec.WithLabelValues(CacheHit).Inc()
+		return sliceScores(scores, begin, end), nil
+	}
+	sc.mu.Unlock()
+	LocalCacheRequestsTotalVec.WithLabelValues(CacheMiss).Inc()
+	scores, err := sc.server.CacheClient.GetSorted(ctx, key, 0, -1)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	entry := &sortedListEntry{key: key, scores: scores, expire: time.Now().Add(sc.server.Config().Server.LocalCacheTTL)}
+	if element, exist := sc.entries[key]; exist {
+		element.Value = entry
+		sc.lru.MoveToFront(element)
+	} else {
+		sc.entries[key] = sc.lru.PushFront(entry)
+	}
+	for sc.lru.Len() > sc.server.Config().Server.LocalCacheSize {
+		element := sc.lru.Back()
+		sc.lru.Remove(element)
+		delete(sc.entries, element.Value.(*sortedListEntry).key)
+	}
+	return sliceScores(scores, begin, end), nil
+}
+
+// Remove sorted lists from the cache.
+func (sc *SortedListCache) Remove(keys ...string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, key := range keys {
+		if element, exist := sc.entries[key]; exist {
+			sc.lru.Remove(element)
+			delete(sc.entries, key)
+		}
+	}
+}
+
+// sliceScores copies scores between begin and end (inclusive), where end -1 means the end of the list.
+func sliceScores(scores []cache.Scored, begin, end int) []cache.Scored {
+	if end < 0 || end >= len(scores) {
+		end = len(scores) - 1
+	}
+	if begin >= len(scores) || begin > end {
+		return []cache.Scored{}
+	}
+	return append([]cache.Scored{}, scores[begin:end+1]...)
+}
+
+type resultEntry struct {
+	key        string
+	etag       string
+	body       any
+	createTime time.Time
+}
+
+// ResultCache is a server-local cache of final recommendation results keyed by requests, so that identical requests
+// in a short time, e.g., retries of clients, are served without running recommenders and filters again. Results expire
+// after the time-to-live and are not invalidated by new feedback.
+type ResultCache struct {
+	server  *RestServer
+	mu      sync.Mutex
+	queue   *list.List // entries ordered by create time
+	entries map[string]*list.Element
+}
+
+func NewResultCache(s *RestServer) *ResultCache {
+	return &ResultCache{
+		server:  s,
+		queue:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the entity tag and the body of a cached result. There is no cached result if the cache is disabled.
+func (rc *ResultCache) Get(key string) (string, any, bool) {
+	ttl := rc.server.Config().Server.ResultCacheTTL
+	if ttl <= 0 {
+		return "", nil, false
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if element, exist := rc.entries[key]; exist && time.Since(element.Value.(*resultEntry).createTime) < ttl {
+		entry := element.Value.(*resultEntry)
+		ResultCacheRequestsTotalVec.WithLabelValues(CacheHit).Inc()
+		return entry.etag, entry.body, true
+	}
+	ResultCacheRequestsTotalVec.WithLabelValues(CacheMiss).Inc()
+	return "", nil, false
+}
+
+// Set caches a result and removes expired results. Results are queued in the order of create time, so expired results
+// are removed from the front.
+func (rc *ResultCache) Set(key, etag string, body any) {
+	ttl := rc.server.Config().Server.ResultCacheTTL
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if element, exist := rc.entries[key]; exist {
+		rc.queue.Remove(element)
+	}
+	rc.entries[key] = rc.queue.PushBack(&resultEntry{key: key, etag: etag, body: body, createTime: now})
+	for element := rc.queue.Front(); element != nil && now.Sub(element.Value.(*resultEntry).createTime) >= ttl; element = rc.queue.Front() {
+		rc.queue.Remove(element)
+		delete(rc.entries, element.Value.(*resultEntry).key)
+	}
+}
+
 type HiddenItemsManager struct {
 	server                  *RestServer
 	mu                      sync.RWMutex
@@ -267,8 +581,8 @@ func NewHiddenItemsManager(s *RestServer) *HiddenItemsManager {
 	go func() {
 		for {
 			hc.sync()
-			log.Logger().Debug("refresh server side hidden items cache", zap.String("cache_expire", s.Config.Server.CacheExpire.String()))
-			time.Sleep(hc.server.Config.Server.CacheExpire)
+			log.Logger().Debug("refresh server side hidden items cache", zap.String("cache_expire", s.Config().Server.CacheExpire.String()))
+			time.Sleep(hc.server.Config().Server.CacheExpire)
 		}
 	}()
 	return hc
@@ -441,6 +755,28 @@ func (cm *CacheModification) modifyItem(itemId string, prevCategories, categorie
 	return cm
 }
 
+func (cm *CacheModification) modifyItemLabels(itemId string, prevLabels, labels []string) *CacheModification {
+	labelsSet := strset.New(labels...)
+	for _, label := range labels {
+		cm.insertion = append(cm.insertion, cache.Sorted(cache.Key(cache.LabeledItems, label), []cache.Scored{{itemId, float64(time.Now().Unix())}}))
+	}
+	for _, label := range prevLabels {
+		if !labelsSet.Has(label) {
+			cm.deletion = append(cm.deletion, cache.Member(cache.Key(cache.LabeledItems, label), itemId))
+		}
+	}
+	return cm
+}
+
+func (cm *CacheModification) modifyItemLocation(prev, item data.Item) *CacheModification {
+	if item.HasLocation() {
+		cm.insertion = append(cm.insertion, cache.Sorted(cache.ItemLocations, []cache.Scored{{item.ItemId, cache.EncodeGeohash(*item.Latitude, *item.Longitude)}}))
+	} else if prev.HasLocation() {
+		cm.deletion = append(cm.deletion, cache.Member(cache.ItemLocations, item.ItemId))
+	}
+	return cm
+}
+
 func (cm *CacheModification) HideItem(itemId string) *CacheModification {
 	cm.insertion = append(cm.insertion, cache.Sorted(cache.HiddenItemsV2, []cache.Scored{{itemId, float64(time.Now().Unix())}}))
 	return cm
@@ -490,3 +826,825 @@ func (cm *CacheModification) Exec() error {
 	}
 	return nil
 }
+
+const (
+	NewUserCohort     = "new"
+	ActiveUserCohort  = "active"
+	DormantUserCohort = "dormant"
+
+	// FallbackUsageRate is the name of measurements of fraction of requests served by each source.
+	//  Fallback usage rate - fallback_usage_rate/{cohort}/{source}
+	FallbackUsageRate = "fallback_usage_rate"
+)
+
+// DailyCounter counts events for each day. Counts are accumulated locally and merged with counts from other nodes
+// through the cache store periodically, so that counts from other nodes lag behind at most one synchronization.
+type DailyCounter struct {
+	server *RestServer
+	name   string // name of sorted sets in the cache store
+	node   string
+	mu     sync.Mutex
+	local  map[time.Time]map[string]int // counts of this node
+	remote map[string]int               // counts of today from other nodes
+	date   time.Time                    // date of remote counts
+}
+
+// DailyCountTTL is how long daily counts are kept in the cache store. Counts of the date expired are removed while
+// synchronizing.
+const DailyCountTTL = 90 * 24 * time.Hour
+
+func NewDailyCounter(s *RestServer, name string) *DailyCounter {
+	return &DailyCounter{
+		server: s,
+		name:   name,
+		node:   uuid.New().String(),
+		local:  make(map[time.Time]map[string]int),
+		remote: make(map[string]int),
+	}
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// Add n events to the count of a key.
+func (c *DailyCounter) Add(key string, n int) {
+	date := today()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exist := c.local[date]; !exist {
+		c.local[date] = make(map[string]int)
+	}
+	c.local[date][key] += n
+}
+
+// AddWithin adds n events to the count of a key unless the count today exceeds the limit, and returns whether the
+// events are added. The count is increased before compared, so that concurrent callers never exceed the limit
+// together. There is no limit if the limit is not positive.
+func (c *DailyCounter) AddWithin(key string, n, limit int) bool {
+	date := today()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exist := c.local[date]; !exist {
+		c.local[date] = make(map[string]int)
+	}
+	c.local[date][key] += n
+	if limit <= 0 {
+		return true
+	}
+	count := c.local[date][key]
+	if c.date.Equal(date) {
+		count += c.remote[key]
+	}
+	if count > limit {
+		c.local[date][key] -= n
+		return false
+	}
+	return true
+}
+
+// Count returns the number of events of a key today counted by all nodes.
+func (c *DailyCounter) Count(key string) int {
+	date := today()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := c.local[date][key]
+	if c.date.Equal(date) {
+		count += c.remote[key]
+	}
+	return count
+}
+
+// Sync writes local counts to the cache store and loads counts from other nodes. It returns merged counts of dates
+// counted by this node.
+func (c *DailyCounter) Sync(ctx context.Context) (map[time.Time]map[string]int, error) {
+	date := today()
+	// copy local counts
+	c.mu.Lock()
+	scores := make(map[time.Time][]cache.Scored, len(c.local))
+	for date, counts := range c.local {
+		for key, count := range counts {
+			scores[date] = append(scores[date], cache.Scored{Id: cache.Key(key, c.node), Score: float64(count)})
+		}
+	}
+	c.mu.Unlock()
+
+	merged := make(map[time.Time]map[string]int, len(scores))
+	for countDate, dateScores := range scores {
+		// write local counts
+		if err := c.server.CacheClient.AddSorted(ctx, cache.Sorted(cache.Key(c.name, countDate.Format("2006-01-02")), dateScores)); err != nil {
+			return nil, errors.Trace(err)
+		}
+		// load counts from all nodes
+		counts, remote, err := c.load(ctx, countDate)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		merged[countDate] = counts
+		if countDate.Equal(date) {
+			c.mu.Lock()
+			c.remote, c.date = remote, countDate
+			c.mu.Unlock()
+		}
+	}
+
+	// remove expired counts
+	expired := cache.Key(c.name, date.Add(-DailyCountTTL).Format("2006-01-02"))
+	if err := c.server.CacheClient.RemSortedByScore(ctx, expired, math.Inf(-1), math.Inf(1)); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// remove outdated local counts
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for countDate := range c.local {
+		if countDate.Before(date) {
+			delete(c.local, countDate)
+		}
+	}
+	return merged, nil
+}
+
+// Load counts of a date counted by all nodes.
+func (c *DailyCounter) Load(ctx context.Context, date time.Time) (map[string]int, error) {
+	counts, _, err := c.load(ctx, date)
+	return counts, err
+}
+
+func (c *DailyCounter) load(ctx context.Context, date time.Time) (all, remote map[string]int, err error) {
+	scores, err := c.server.CacheClient.GetSorted(ctx, cache.Key(c.name, date.Format("2006-01-02")), 0, -1)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	all, remote = make(map[string]int), make(map[string]int)
+	for _, score := range scores {
+		pos := strings.LastIndex(score.Id, "/")
+		if pos < 0 {
+			log.Logger().Warn("invalid daily count", zap.String("name", c.name), zap.String("member", score.Id))
+			continue
+		}
+		key, node := score.Id[:pos], score.Id[pos+1:]
+		all[key] += int(score.Score)
+		if node != c.node {
+			remote[key] += int(score.Score)
+		}
+	}
+	return all, remote, nil
+}
+
+// FallbackUsageTracker counts recommendation requests served by each source for each user cohort.
+type FallbackUsageTracker struct {
+	server  *RestServer
+	counter *DailyCounter
+	test    bool
+}
+
+func NewFallbackUsageTracker(s *RestServer) *FallbackUsageTracker {
+	ft := &FallbackUsageTracker{
+		server:  s,
+		counter: NewDailyCounter(s, cache.FallbackUsage),
+	}
+	go func() {
+		for {
+			time.Sleep(s.Config().Server.CacheExpire)
+			ft.sync()
+			log.Logger().Debug("synchronize fallback usage", zap.String("cache_expire", s.Config().Server.CacheExpire.String()))
+		}
+	}()
+	return ft
+}
+
+func newFallbackUsageTrackerForTest(s *RestServer) *FallbackUsageTracker {
+	return &FallbackUsageTracker{
+		server:  s,
+		counter: NewDailyCounter(s, cache.FallbackUsage),
+		test:    true,
+	}
+}
+
+// Record a recommendation request served by a source for a user cohort.
+func (ft *FallbackUsageTracker) Record(cohort, source string) {
+	RecommendRequestsTotalVec.WithLabelValues(cohort, source).Inc()
+	ft.counter.Add(cache.Key(cohort, source), 1)
+	if ft.test {
+		ft.sync()
+	}
+}
+
+func (ft *FallbackUsageTracker) sync() {
+	ctx := context.Background()
+	merged, err := ft.counter.Sync(ctx)
+	if err != nil {
+		log.Logger().Error("failed to synchronize fallback usage", zap.Error(err))
+		return
+	}
+	var measurements []Measurement
+	for date, counts := range merged {
+		totals := make(map[string]int)
+		for key, count := range counts {
+			cohort, _, _ := strings.Cut(key, "/")
+			totals[cohort] += count
+		}
+		for key, count := range counts {
+			cohort, source, _ := strings.Cut(key, "/")
+			measurements = append(measurements, Measurement{
+				Name:      cache.Key(FallbackUsageRate, cohort, source),
+				Timestamp: date,
+				Value:     float32(count) / float32(totals[cohort]),
+			})
+		}
+	}
+	if err = ft.server.InsertMeasurement(ctx, measurements...); err != nil {
+		log.Logger().Error("failed to insert fallback usage rate", zap.Error(err))
+	}
+}
+
+// APIKeyNameAttribute is the request attribute of the name of the API key of a consumer.
+const APIKeyNameAttribute = "api_key_name"
+
+// QuotaManager counts requests and written rows of API keys of consumers for daily quotas.
+type QuotaManager struct {
+	server   *RestServer
+	requests *DailyCounter
+	writes   *DailyCounter
+	test     bool
+}
+
+func NewQuotaManager(s *RestServer) *QuotaManager {
+	qm := &QuotaManager{
+		server:   s,
+		requests: NewDailyCounter(s, cache.APIKeyRequests),
+		writes:   NewDailyCounter(s, cache.APIKeyWrites),
+	}
+	go func() {
+		for {
+			time.Sleep(s.Config().Server.CacheExpire)
+			qm.sync()
+			log.Logger().Debug("synchronize API key usage", zap.String("cache_expire", s.Config().Server.CacheExpire.String()))
+		}
+	}()
+	return qm
+}
+
+func newQuotaManagerForTest(s *RestServer) *QuotaManager {
+	return &QuotaManager{
+		server:   s,
+		requests: NewDailyCounter(s, cache.APIKeyRequests),
+		writes:   NewDailyCounter(s, cache.APIKeyWrites),
+		test:     true,
+	}
+}
+
+// RecordRequest records a request of an API key unless the daily limit of requests is reached, and returns whether
+// the request is recorded. There is no limit if the limit is zero.
+func (qm *QuotaManager) RecordRequest(name string, limit int) bool {
+	if !qm.requests.AddWithin(name, 1, limit) {
+		return false
+	}
+	APIKeyRequestsTotalVec.WithLabelValues(name).Inc()
+	if qm.test {
+		qm.sync()
+	}
+	return true
+}
+
+// RecordWrites records written rows of an API key.
+func (qm *QuotaManager) RecordWrites(name string, n int) {
+	APIKeyWritesTotalVec.WithLabelValues(name).Add(float64(n))
+	qm.writes.Add(name, n)
+	if qm.test {
+		qm.sync()
+	}
+}
+
+func (qm *QuotaManager) sync() {
+	ctx := context.Background()
+	if _, err := qm.requests.Sync(ctx); err != nil {
+		log.Logger().Error("failed to synchronize API key requests", zap.Error(err))
+	}
+	if _, err := qm.writes.Sync(ctx); err != nil {
+		log.Logger().Error("failed to synchronize API key writes", zap.Error(err))
+	}
+}
+
+// APIKeyUsage is the usage of an API key in a day.
+type APIKeyUsage struct {
+	Name          string
+	Date          time.Time
+	Requests      int
+	Writes        int
+	DailyRequests int
+	DailyWrites   int
+}
+
+// Usage returns usage of API keys of consumers in recent days, from the latest to the oldest.
+func (qm *QuotaManager) Usage(ctx context.Context, days int) ([]APIKeyUsage, error) {
+	names := lo.Keys(qm.server.Config().Server.APIKeys)
+	sort.Strings(names)
+	var usage []APIKeyUsage
+	date := today()
+	for i := 0; i < days; i++ {
+		requests, err := qm.requests.Load(ctx, date)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		writes, err := qm.writes.Load(ctx, date)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, name := range names {
+			keyConfig := qm.server.Config().Server.APIKeys[name]
+			usage = append(usage, APIKeyUsage{
+				Name:          name,
+				Date:          date,
+				Requests:      requests[name],
+				Writes:        writes[name],
+				DailyRequests: keyConfig.DailyRequests,
+				DailyWrites:   keyConfig.DailyWrites,
+			})
+		}
+		date = date.Add(-24 * time.Hour)
+	}
+	return usage, nil
+}
+
+// SourcePositiveFeedbackRate is the name of measurements of positive feedback rates of recommendation sources.
+//
+//	Source positive feedback rate - source_positive_feedback_rate/{source}
+const SourcePositiveFeedbackRate = "source_positive_feedback_rate"
+
+// SourceFeedbackTracker attributes positive feedback to sources of recommended items.
+type SourceFeedbackTracker struct {
+	server   *RestServer
+	served   *DailyCounter
+	positive *DailyCounter
+	items    *DailyCounter
+	test     bool
+}
+
+func NewSourceFeedbackTracker(s *RestServer) *SourceFeedbackTracker {
+	st := &SourceFeedbackTracker{
+		server:   s,
+		served:   NewDailyCounter(s, cache.SourceServed),
+		positive: NewDailyCounter(s, cache.SourcePositive),
+		items:    NewDailyCounter(s, cache.ItemServed),
+	}
+	go func() {
+		for {
+			time.Sleep(s.Config().Server.CacheExpire)
+			st.sync()
+			log.Logger().Debug("synchronize source feedback", zap.String("cache_expire", s.Config().Server.CacheExpire.String()))
+		}
+	}()
+	return st
+}
+
+func newSourceFeedbackTrackerForTest(s *RestServer) *SourceFeedbackTracker {
+	return &SourceFeedbackTracker{
+		server:   s,
+		served:   NewDailyCounter(s, cache.SourceServed),
+		positive: NewDailyCounter(s, cache.SourcePositive),
+		items:    NewDailyCounter(s, cache.ItemServed),
+		test:     true,
+	}
+}
+
+// Serve records items recommended to a user and their sources.
+func (st *SourceFeedbackTracker) Serve(ctx context.Context, userId string, items, sources []string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	now := time.Now()
+	scores := make([]cache.Scored, len(items))
+	for i := range items {
+		scores[i] = cache.Scored{Id: cache.Key(sources[i], items[i]), Score: float64(now.Unix())}
+		st.served.Add(sources[i], 1)
+		st.items.Add(items[i], 1)
+	}
+	key := cache.Key(cache.RecommendSources, userId)
+	if err := st.server.CacheClient.AddSorted(ctx, cache.Sorted(key, scores)); err != nil {
+		return errors.Trace(err)
+	}
+	// remove records out of the attribution window
+	expire := now.Add(-st.server.Config().Recommend.Online.AttributionWindow)
+	if err := st.server.CacheClient.RemSortedByScore(ctx, key, math.Inf(-1), float64(expire.Unix()-1)); err != nil {
+		return errors.Trace(err)
+	}
+	if st.test {
+		st.sync()
+	}
+	return nil
+}
+
+// Attribute positive feedback to sources of recommended items. Each recommendation is attributed at most once.
+func (st *SourceFeedbackTracker) Attribute(ctx context.Context, feedback []data.Feedback) error {
+	userFeedback := make(map[string][]data.Feedback)
+	for _, f := range feedback {
+		if lo.Contains(st.server.Config().Recommend.DataSource.PositiveFeedbackTypes, f.FeedbackType) {
+			userFeedback[f.UserId] = append(userFeedback[f.UserId], f)
+		}
+	}
+	for userId, positiveFeedback := range userFeedback {
+		key := cache.Key(cache.RecommendSources, userId)
+		expire := time.Now().Add(-st.server.Config().Recommend.Online.AttributionWindow)
+		records, err := st.server.CacheClient.GetSortedByScore(ctx, key, float64(expire.Unix()), math.Inf(1))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// find the latest source of each item
+		sources := make(map[string]string)
+		members := make(map[string][]string)
+		for _, record := range records {
+			source, itemId, _ := strings.Cut(record.Id, "/")
+			sources[itemId] = source
+			members[itemId] = append(members[itemId], record.Id)
+		}
+		var attributed []cache.SetMember
+		for _, f := range positiveFeedback {
+			if source, exist := sources[f.ItemId]; exist {
+				st.positive.Add(source, 1)
+				for _, member := range members[f.ItemId] {
+					attributed = append(attributed, cache.Member(key, member))
+				}
+				delete(sources, f.ItemId)
+			}
+		}
+		if len(attributed) > 0 {
+			if err = st.server.CacheClient.RemSorted(ctx, attributed...); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	if st.test {
+		st.sync()
+	}
+	return nil
+}
+
+// ItemServed returns the number of times an item is recommended in a date.
+func (st *SourceFeedbackTracker) ItemServed(ctx context.Context, itemId string, date time.Time) (int, error) {
+	counts, err := st.items.Load(ctx, date)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	return counts[itemId], nil
+}
+
+func (st *SourceFeedbackTracker) sync() {
+	ctx := context.Background()
+	if _, err := st.items.Sync(ctx); err != nil {
+		log.Logger().Error("failed to synchronize served times of items", zap.Error(err))
+	}
+	served, err := st.served.Sync(ctx)
+	if err != nil {
+		log.Logger().Error("failed to synchronize served items of sources", zap.Error(err))
+		return
+	}
+	positive, err := st.positive.Sync(ctx)
+	if err != nil {
+		log.Logger().Error("failed to synchronize positive feedback of sources", zap.Error(err))
+		return
+	}
+	var measurements []Measurement
+	for _, date := range lo.Uniq(append(lo.Keys(served), lo.Keys(positive)...)) {
+		if _, exist := served[date]; !exist {
+			if served[date], err = st.served.Load(ctx, date); err != nil {
+				log.Logger().Error("failed to load served items of sources", zap.Error(err))
+				return
+			}
+		}
+		if _, exist := positive[date]; !exist {
+			if positive[date], err = st.positive.Load(ctx, date); err != nil {
+				log.Logger().Error("failed to load positive feedback of sources", zap.Error(err))
+				return
+			}
+		}
+		for source, count := range served[date] {
+			measurements = append(measurements, Measurement{
+				Name:      cache.Key(SourcePositiveFeedbackRate, source),
+				Timestamp: date,
+				Value:     float32(positive[date][source]) / float32(count),
+			})
+		}
+	}
+	if err = st.server.InsertMeasurement(ctx, measurements...); err != nil {
+		log.Logger().Error("failed to insert positive feedback rates of sources", zap.Error(err))
+	}
+}
+
+// AdminActor is the actor of audit logs of requests authorized by the API key of the server.
+const AdminActor = "admin"
+
+// ActorAttribute is the request attribute of the name of the dashboard account sending the request.
+const ActorAttribute = "actor"
+
+// AuditLogger writes audit logs of write operations to the sink: a file of JSON lines or the data store.
+type AuditLogger struct {
+	server *RestServer
+	mu     sync.Mutex
+}
+
+func NewAuditLogger(s *RestServer) *AuditLogger {
+	return &AuditLogger{server: s}
+}
+
+// Write an audit log to the sink.
+func (al *AuditLogger) Write(ctx context.Context, l data.AuditLog) error {
+	if al.server.Config().Audit.Sink == "data_store" {
+		return errors.Trace(al.server.DataClient.InsertAuditLogs(ctx, []data.AuditLog{l}))
+	}
+	buf, err := json.Marshal(l)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	file, err := os.OpenFile(al.server.Config().Audit.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = file.Write(append(buf, '\n')); err != nil {
+		_ = file.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(file.Close())
+}
+
+// Query the latest n audit logs between beginTime and endTime.
+func (al *AuditLogger) Query(ctx context.Context, n int, beginTime, endTime *time.Time) ([]data.AuditLog, error) {
+	if al.server.Config().Audit.Sink == "data_store" {
+		logs, err := al.server.DataClient.GetAuditLogs(ctx, n, beginTime, endTime)
+		return logs, errors.Trace(err)
+	}
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	file, err := os.Open(al.server.Config().Audit.Path)
+	if os.IsNotExist(err) {
+		return []data.AuditLog{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer file.Close()
+	var logs []data.AuditLog
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var l data.AuditLog
+		if err = json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if beginTime != nil && l.Timestamp.Before(*beginTime) {
+			continue
+		}
+		if endTime != nil && l.Timestamp.After(*endTime) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// return the latest logs first
+	logs = lo.Reverse(logs)
+	if n < len(logs) {
+		logs = logs[:n]
+	}
+	return logs, nil
+}
+
+// feedbackWALEntry is an entry of the write-ahead log of feedback.
+type feedbackWALEntry struct {
+	Feedback  []data.Feedback
+	Overwrite bool
+}
+
+// FeedbackWAL persists feedback to a local write-ahead log, which is flushed to the data store asynchronously in
+// batches. Feedback being flushed is moved to another file so that new feedback could be appended at the same time.
+// Feedback might be inserted more than once if the server crashes during flushing, which makes no difference since
+// insertion of feedback is idempotent. The write-ahead log is synced to the disk once per flush instead of once per
+// append, so feedback appended in the last flush period might be lost if the host (not the server) crashes.
+type FeedbackWAL struct {
+	server  *RestServer
+	mu      sync.Mutex // lock of the write-ahead log
+	file    *os.File   // the write-ahead log opened for appending
+	flushMu sync.Mutex // lock of the flushing file
+}
+
+func NewFeedbackWAL(s *RestServer) *FeedbackWAL {
+	return &FeedbackWAL{server: s}
+}
+
+// Append feedback to the write-ahead log. Feedback survives crashes of the server once this function returns, and
+// survives crashes of the host once the write-ahead log is synced by the next flush.
+func (w *FeedbackWAL) Append(feedback []data.Feedback, overwrite bool) error {
+	buf, err := json.Marshal(feedbackWALEntry{Feedback: feedback, Overwrite: overwrite})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil && w.file.Name() != w.server.Config().Server.FeedbackWALPath {
+		if err = w.close(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if w.file == nil {
+		file, err := os.OpenFile(w.server.Config().Server.FeedbackWALPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		w.file = file
+	}
+	_, err = w.file.Write(append(buf, '\n'))
+	return errors.Trace(err)
+}
+
+// close syncs the write-ahead log to the disk and closes it. It must be called with the lock held.
+func (w *FeedbackWAL) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return errors.Trace(err)
+}
+
+// Flush feedback in the write-ahead log to the data store. Feedback left by a failed flush are flushed first.
+func (w *FeedbackWAL) Flush(ctx context.Context) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	flushingPath := w.server.Config().Server.FeedbackWALPath + ".flushing"
+	if _, err := os.Stat(flushingPath); os.IsNotExist(err) {
+		// move the write-ahead log to the flushing file
+		w.mu.Lock()
+		if err = w.close(); err != nil {
+			w.mu.Unlock()
+			return errors.Trace(err)
+		}
+		err = os.Rename(w.server.Config().Server.FeedbackWALPath, flushingPath)
+		w.mu.Unlock()
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	// load feedback
+	file, err := os.Open(flushingPath)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var entries []feedbackWALEntry
+	var broken [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, math.MaxInt32)
+	for scanner.Scan() {
+		var entry feedbackWALEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// entries might be partially written
+			log.Logger().Error("broken entry in feedback write-ahead log", zap.Error(err))
+			broken = append(broken, append([]byte(nil), scanner.Bytes()...))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		_ = file.Close()
+		return errors.Trace(err)
+	}
+	if err = file.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	// insert feedback in batches
+	var batch []data.Feedback
+	insert := func(overwrite bool) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := w.server.DataClient.BatchInsertFeedback(ctx, batch,
+			w.server.Config().Server.AutoInsertUser,
+			w.server.Config().Server.AutoInsertItem, overwrite)
+		batch = batch[:0]
+		return errors.Trace(err)
+	}
+	for i, entry := range entries {
+		if i > 0 && entries[i-1].Overwrite != entry.Overwrite {
+			if err = insert(entries[i-1].Overwrite); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		for _, feedback := range entry.Feedback {
+			batch = append(batch, feedback)
+			if len(batch) >= w.server.Config().Server.FeedbackFlushSize {
+				if err = insert(entry.Overwrite); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+	}
+	if len(entries) > 0 {
+		if err = insert(entries[len(entries)-1].Overwrite); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// quarantine broken entries for manual recovery
+	if len(broken) > 0 {
+		if err = w.quarantine(broken); err != nil {
+			return errors.Trace(err)
+		}
+		log.Logger().Warn("quarantine broken entries in feedback write-ahead log",
+			zap.Int("num_entries", len(broken)), zap.String("path", w.server.Config().Server.FeedbackWALPath+".corrupt"))
+	}
+	return errors.Trace(os.Remove(flushingPath))
+}
+
+// quarantine appends broken entries to the corrupt file next to the write-ahead log.
+func (w *FeedbackWAL) quarantine(entries [][]byte) error {
+	file, err := os.OpenFile(w.server.Config().Server.FeedbackWALPath+".corrupt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, entry := range entries {
+		if _, err = file.Write(append(entry, '\n')); err != nil {
+			_ = file.Close()
+			return errors.Trace(err)
+		}
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(file.Close())
+}
+
+// Run flushes the write-ahead log periodically.
+func (w *FeedbackWAL) Run() {
+	defer base.CheckPanic()
+	for {
+		time.Sleep(w.server.Config().Server.FeedbackFlushPeriod)
+		if err := w.Flush(context.Background()); err != nil {
+			log.Logger().Error("failed to flush feedback write-ahead log", zap.Error(err))
+		}
+	}
+}
+
+// FeedbackDeduplicator collapses repeated feedback with the same type, user and item received within the deduplication
+// window, such as double clicks and retries of clients. Feedback is deduplicated by each server.
+type FeedbackDeduplicator struct {
+	server    *RestServer
+	mu        sync.Mutex
+	received  map[data.FeedbackKey]time.Time
+	purgeTime time.Time
+}
+
+func NewFeedbackDeduplicator(s *RestServer) *FeedbackDeduplicator {
+	return &FeedbackDeduplicator{
+		server:   s,
+		received: make(map[data.FeedbackKey]time.Time),
+	}
+}
+
+// Deduplicate returns feedback whose keys have not been received within the window. Keys of returned feedback are
+// reserved until the window ends, and should be released if the feedback fails to be written. All feedback is kept if
+// the window is 0.
+func (d *FeedbackDeduplicator) Deduplicate(feedback []data.Feedback) []data.Feedback {
+	window := d.server.Config().Server.FeedbackDedupWindow
+	if window <= 0 {
+		return feedback
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// remove keys out of the window
+	if now.Sub(d.purgeTime) >= window {
+		for key, received := range d.received {
+			if now.Sub(received) >= window {
+				delete(d.received, key)
+			}
+		}
+		d.purgeTime = now
+	}
+	deduplicated := make([]data.Feedback, 0, len(feedback))
+	for _, f := range feedback {
+		if received, exist := d.received[f.FeedbackKey]; exist && now.Sub(received) < window {
+			DuplicateFeedbackTotal.Inc()
+			continue
+		}
+		d.received[f.FeedbackKey] = now
+		deduplicated = append(deduplicated, f)
+	}
+	return deduplicated
+}
+
+// Release keys of feedback failed to be written, so that retries of the feedback are not dropped as duplicates.
+func (d *FeedbackDeduplicator) Release(feedback []data.Feedback) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, f := range feedback {
+		delete(d.received, f.FeedbackKey)
+	}
+}
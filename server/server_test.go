@@ -24,7 +24,10 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"net"
+	"net/http"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type mockMaster struct {
@@ -34,6 +37,7 @@ type mockMaster struct {
 	meta       *protocol.Meta
 	cacheStore *miniredis.Miniredis
 	dataStore  *miniredis.Miniredis
+	deregister chan string
 }
 
 func newMockMaster(t *testing.T) *mockMaster {
@@ -51,6 +55,7 @@ func newMockMaster(t *testing.T) *mockMaster {
 		meta:       &protocol.Meta{Config: string(bytes)},
 		cacheStore: cacheStore,
 		dataStore:  dataStore,
+		deregister: make(chan string, 1),
 	}
 }
 
@@ -58,6 +63,11 @@ func (m *mockMaster) GetMeta(_ context.Context, _ *protocol.NodeInfo) (*protocol
 	return m.meta, nil
 }
 
+func (m *mockMaster) Deregister(_ context.Context, nodeInfo *protocol.NodeInfo) (*protocol.DeregisterResponse, error) {
+	m.deregister <- nodeInfo.NodeName
+	return &protocol.DeregisterResponse{}, nil
+}
+
 func (m *mockMaster) GetRankingModel(_ *protocol.VersionInfo, _ protocol.Master_GetRankingModelServer) error {
 	panic("not implement")
 }
@@ -101,3 +111,61 @@ func TestServer_Sync(t *testing.T) {
 	assert.Equal(t, "redis://"+master.cacheStore.Addr(), serv.cachePath)
 	master.Stop()
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	master := newMockMaster(t)
+	go master.Start(t)
+	address := <-master.addr
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	cacheFile := filepath.Join(t.TempDir(), "server.cache")
+	serv := &Server{
+		masterClient: protocol.NewMasterClient(conn),
+		serverName:   "server",
+		cacheFile:    cacheFile,
+		localCache:   &LocalCache{path: cacheFile, ServerName: "server"},
+		stopSync:     make(chan struct{}),
+		RestServer: RestServer{
+			Settings: config.NewSettings(),
+		},
+	}
+	serv.FeedbackWAL = NewFeedbackWAL(&serv.RestServer)
+	serv.Config().Server.FeedbackWALPath = filepath.Join(t.TempDir(), "feedback.wal")
+	serv.Config().Server.ShutdownTimeout = time.Second
+
+	// start http server with a slow handler
+	started := make(chan struct{})
+	serv.HttpServer = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})}
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = serv.HttpServer.Serve(listener)
+	}()
+	url := "http://" + listener.Addr().String()
+	status := make(chan int)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	// in-flight requests are finished and new requests are rejected
+	<-started
+	serv.Shutdown()
+	assert.Equal(t, http.StatusOK, <-status)
+	_, err = http.Get(url)
+	assert.Error(t, err)
+	assert.Equal(t, "server", <-master.deregister)
+	localCache, err := LoadLocalCache(cacheFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "server", localCache.ServerName)
+	master.Stop()
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// Session is the state of an anonymous session, e.g., a logged-out visitor. Feedback of the session is kept in the
+// cache store only and never inserted into the data store, so that sessions do not pollute users.
+type Session struct {
+	Feedback   []data.Feedback `json:"feedback"`
+	UpdateTime time.Time       `json:"update_time"`
+}
+
+// Expired returns true if there is no update within the time-to-live.
+func (session Session) Expired(ttl time.Duration) bool {
+	return time.Since(session.UpdateTime) > ttl
+}
+
+// Append merges feedback into the session and keeps at most n latest feedback. Feedback of the same type on an item
+// is replaced by the latter one.
+func (session *Session) Append(feedback []data.Feedback, n int) {
+	merged := make([]data.Feedback, 0, len(session.Feedback)+len(feedback))
+	index := make(map[data.FeedbackKey]int, len(session.Feedback)+len(feedback))
+	for _, f := range append(session.Feedback, feedback...) {
+		key := data.FeedbackKey{FeedbackType: f.FeedbackType, ItemId: f.ItemId}
+		if i, exist := index[key]; exist {
+			merged[i] = f
+		} else {
+			index[key] = len(merged)
+			merged = append(merged, f)
+		}
+	}
+	data.SortFeedbacks(merged)
+	if n > 0 && len(merged) > n {
+		merged = merged[:n]
+	}
+	session.Feedback = merged
+}
+
+// LoadSession loads the state of a session from the cache store. The state is empty if the session does not exist.
+// Expired sessions are returned as they are and reclaimed by the master.
+func LoadSession(ctx context.Context, client cache.Database, sessionId string) (Session, error) {
+	buf, err := client.Get(ctx, cache.Key(cache.Session, sessionId)).String()
+	if errors.Is(err, errors.NotFound) {
+		return Session{}, nil
+	} else if err != nil {
+		return Session{}, errors.Trace(err)
+	}
+	var session Session
+	if err = cache.Unmarshal(buf, &session); err != nil {
+		return Session{}, errors.Trace(err)
+	}
+	return session, nil
+}
+
+// SaveSession saves the state of a session to the cache store.
+func SaveSession(ctx context.Context, client cache.Database, sessionId string, session Session) error {
+	buf, err := cache.Marshal(client, session)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return client.Set(ctx, cache.String(cache.Key(cache.Session, sessionId), buf))
+}
+
+// updateSession merges feedback into the state of a session and returns feedback of the session. Feedback of an
+// expired session is discarded.
+func (s *RestServer) updateSession(ctx context.Context, sessionId string, feedback []data.Feedback) ([]data.Feedback, error) {
+	session, err := LoadSession(ctx, s.CacheClient, sessionId)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if session.Expired(s.Config().Server.SessionTTL) {
+		session.Feedback = nil
+	}
+	session.Append(feedback, s.Config().Recommend.CacheSize)
+	session.UpdateTime = time.Now()
+	if err = SaveSession(ctx, s.CacheClient, sessionId, session); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return session.Feedback, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/recommender"
+	"go.uber.org/zap"
+)
+
+// SlateQuota requests N items from a recommender in a slate. Sponsored items can be requested from the popular or latest
+// items in a dedicated category.
+type SlateQuota struct {
+	Source   string // offline, collaborative, item_based, user_based, latest, popular or a custom recommender
+	Category string
+	N        int
+}
+
+// SlateItem is an item in a slate with the recommender it comes from.
+type SlateItem struct {
+	ItemId string
+	Source string
+}
+
+func (s *RestServer) slateRecommender(source string) (Recommender, bool) {
+	switch source {
+	case "offline":
+		return s.RecommendOffline, true
+	case "collaborative":
+		return s.RecommendCollaborative, true
+	case "item_based":
+		return s.RecommendItemBased, true
+	case "user_based":
+		return s.RecommendUserBased, true
+	case "latest":
+		return s.RecommendLatest, true
+	case "popular":
+		return s.RecommendPopular, true
+	}
+	if custom, err := recommender.Get(source); err == nil {
+		return s.RecommendCustom(source, custom), true
+	}
+	return nil, false
+}
+
+// ComposeSlate fills quotas of a slate in order. Items are de-duplicated across quotas and read, ignored or hidden
+// items are excluded as in online recommendation. A quota is left short if its recommender runs out of items.
+func (s *RestServer) ComposeSlate(ctx context.Context, response *restful.Response, userId string, quotas []SlateQuota) ([]SlateItem, error) {
+	recommenders := make([]Recommender, len(quotas))
+	for i, quota := range quotas {
+		var exist bool
+		if recommenders[i], exist = s.slateRecommender(quota.Source); !exist {
+			return nil, errors.NotValidf("source `%s` of slate", quota.Source)
+		} else if quota.N <= 0 {
+			return nil, errors.NotValidf("non-positive quota of `%s`", quota.Source)
+		}
+	}
+	recommendCtx, err := s.createRecommendContext(ctx, userId, "", 0)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	recommendCtx.response = response
+	for i, quota := range quotas {
+		begin := len(recommendCtx.results)
+		recommendCtx.category = quota.Category
+		recommendCtx.n = begin + quota.N
+		if err = recommenders[i](recommendCtx); err != nil {
+			return nil, errors.Trace(err)
+		}
+		// recommenders may return more items than the quota, return extra items to following quotas
+		if len(recommendCtx.results) > recommendCtx.n {
+			recommendCtx.excludeSet.Remove(recommendCtx.results[recommendCtx.n:]...)
+			recommendCtx.results = recommendCtx.results[:recommendCtx.n]
+			recommendCtx.sources = recommendCtx.sources[:recommendCtx.n]
+			recommendCtx.numPrevStage = recommendCtx.n
+		}
+	}
+	items := make([]SlateItem, len(recommendCtx.results))
+	for i := range items {
+		items[i] = SlateItem{ItemId: recommendCtx.results[i], Source: recommendCtx.sources[i]}
+	}
+	return items, nil
+}
+
+func (s *RestServer) composeSlate(request *restful.Request, response *restful.Response) {
+	ctx := context.Background()
+	if request != nil && request.Request != nil {
+		ctx = request.Request.Context()
+	}
+	userId := request.PathParameter("user-id")
+	var quotas []SlateQuota
+	if err := request.ReadEntity(&quotas); err != nil {
+		BadRequest(response, err)
+		return
+	}
+	items, err := s.ComposeSlate(ctx, response, userId, quotas)
+	if errors.IsNotValid(err) {
+		BadRequest(response, err)
+		return
+	} else if err != nil {
+		InternalServerError(response, err)
+		return
+	}
+	results := make([]string, len(items))
+	sources := make([]string, len(items))
+	for i, item := range items {
+		results[i], sources[i] = item.ItemId, item.Source
+	}
+	if err = s.SourceFeedbackTracker.Serve(ctx, userId, results, sources); err != nil {
+		log.ResponseLogger(response).Error("failed to record sources of recommendation", zap.Error(err))
+	}
+	if err = s.RecordImpressions(ctx, userId, results); err != nil {
+		log.ResponseLogger(response).Error("failed to record impressions of recommendation", zap.Error(err))
+	}
+	Ok(response, items)
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/model/click"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/feature"
+	"go.uber.org/zap"
+)
+
+// TimeContext of a recommendation request. The hour and the weekday are in UTC as those of feedback in training.
+type TimeContext struct {
+	Hour    int
+	Weekday time.Weekday
+}
+
+// ParseTimeContext parses the time context from the query parameters hour (0 to 23) and weekday (0 to 6, starting from
+// Sunday). Nil is returned if the time context is not given.
+func ParseTimeContext(request *restful.Request) (*TimeContext, error) {
+	hour, weekday := request.QueryParameter("hour"), request.QueryParameter("weekday")
+	if hour == "" && weekday == "" {
+		return nil, nil
+	} else if hour == "" || weekday == "" {
+		return nil, errors.NotValidf("time context without hour or weekday")
+	}
+	var timeContext TimeContext
+	var err error
+	if timeContext.Hour, err = strconv.Atoi(hour); err != nil || timeContext.Hour < 0 || timeContext.Hour > 23 {
+		return nil, errors.NotValidf("hour `%s`", hour)
+	}
+	day, err := strconv.Atoi(weekday)
+	if err != nil || day < int(time.Sunday) || day > int(time.Saturday) {
+		return nil, errors.NotValidf("weekday `%s`", weekday)
+	}
+	timeContext.Weekday = time.Weekday(day)
+	return &timeContext, nil
+}
+
+// appendStoreFeatures appends features from the feature store to labels of the user and items. Labels are kept if the
+// feature store is not configured or fails.
+func (s *RestServer) appendStoreFeatures(ctx context.Context, userId string, userLabels []string, itemMap map[string]data.Item) []string {
+	if s.FeatureClient == nil {
+		return userLabels
+	}
+	if userFeatures, err := s.FeatureClient.GetUserFeatures(ctx, []string{userId}); err != nil {
+		log.Logger().Warn("failed to fetch user features from feature store", zap.String("user_id", userId), zap.Error(err))
+	} else {
+		userLabels = feature.AppendFeatures(userLabels, userFeatures[userId])
+	}
+	itemIds := make([]string, 0, len(itemMap))
+	for itemId := range itemMap {
+		itemIds = append(itemIds, itemId)
+	}
+	if itemFeatures, err := s.FeatureClient.GetItemFeatures(ctx, itemIds); err != nil {
+		log.Logger().Warn("failed to fetch item features from feature store", zap.Int("n_items", len(itemIds)), zap.Error(err))
+	} else {
+		for itemId, features := range itemFeatures {
+			if item, exist := itemMap[itemId]; exist {
+				item.Labels = feature.AppendFeatures(item.Labels, features)
+				itemMap[itemId] = item
+			}
+		}
+	}
+	return userLabels
+}
+
+// applyTimeContext re-ranks results by click-through rates predicted in the time context. Results are kept if time
+// contexts are disabled or the click model is unavailable.
+func (s *RestServer) applyTimeContext(ctx *recommendContext) error {
+	if ctx.timeContext == nil || !s.Config().Recommend.Offline.EnableTimeContext ||
+		s.ClickModel == nil || s.ClickModel.Invalid() || len(ctx.results) == 0 || !s.useDataStore(ctx) {
+		return nil
+	}
+	var userLabels []string
+	user, err := s.DataClient.GetUser(ctx.context, ctx.userId)
+	if s.dataStoreFailed(err) {
+		ctx.degraded = true
+		return nil
+	} else if err == nil {
+		userLabels = user.Labels
+	} else if !errors.Is(err, errors.NotFound) {
+		return errors.Trace(err)
+	}
+	items, err := s.DataClient.BatchGetItems(ctx.context, ctx.results)
+	if s.dataStoreFailed(err) {
+		ctx.degraded = true
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	itemMap := make(map[string]data.Item, len(items))
+	for _, item := range items {
+		itemMap[item.ItemId] = item
+	}
+	userLabels = s.appendStoreFeatures(ctx.context, ctx.userId, userLabels, itemMap)
+	ctxLabels := click.TimeContextLabels(ctx.timeContext.Hour, ctx.timeContext.Weekday)
+	scores := make([]float32, len(ctx.results))
+	order := make([]int, len(ctx.results))
+	for i, itemId := range ctx.results {
+		scores[i] = s.ClickModel.PredictWithContext(ctx.userId, itemId, userLabels, itemMap[itemId].Labels, ctxLabels)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	results := make([]string, len(order))
+	sources := make([]string, len(order))
+	for i, j := range order {
+		results[i], sources[i] = ctx.results[j], ctx.sources[j]
+	}
+	ctx.results, ctx.sources = results, sources
+	return nil
+}
@@ -0,0 +1,501 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	boltMembers = []byte("members")
+	boltScores  = []byte("scores")
+)
+
+// boltFiles are opened bolt files. A bolt file is locked exclusively once opened, so that the master, the worker and
+// the server of gorse-in-one share the same file.
+var boltFiles = struct {
+	sync.Mutex
+	dbs  map[string]*bbolt.DB
+	refs map[string]int
+}{
+	dbs:  make(map[string]*bbolt.DB),
+	refs: make(map[string]int),
+}
+
+// Bolt is the cache store in an embedded bolt file. Values are stored in the bucket of values, while each set and
+// each sorted set is stored in a nested bucket of the bucket of sets or sorted sets. Members of a sorted set are
+// indexed by scores so that members are scanned in the order of scores.
+type Bolt struct {
+	storage.TablePrefix
+	path string
+	db   *bbolt.DB
+}
+
+// OpenBolt opens the bolt file. The file is created if not exists.
+func OpenBolt(path, tablePrefix string) (*Bolt, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	boltFiles.Lock()
+	defer boltFiles.Unlock()
+	db, exist := boltFiles.dbs[path]
+	if !exist {
+		if db, err = bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second}); err != nil {
+			return nil, errors.Annotate(err, path)
+		}
+		boltFiles.dbs[path] = db
+	}
+	boltFiles.refs[path]++
+	return &Bolt{TablePrefix: storage.TablePrefix(tablePrefix), path: path, db: db}, nil
+}
+
+func (db *Bolt) Close() error {
+	boltFiles.Lock()
+	defer boltFiles.Unlock()
+	if boltFiles.refs[db.path]--; boltFiles.refs[db.path] > 0 {
+		return nil
+	}
+	delete(boltFiles.dbs, db.path)
+	delete(boltFiles.refs, db.path)
+	return db.db.Close()
+}
+
+func (db *Bolt) Ping() error {
+	return db.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+func (db *Bolt) Init() error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range db.buckets() {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) buckets() [][]byte {
+	return [][]byte{[]byte(db.ValuesTable()), []byte(db.SetsTable()), []byte(db.SortedSetsTable())}
+}
+
+func (db *Bolt) Scan(work func(string) error) error {
+	var keys []string
+	if err := db.db.View(func(tx *bbolt.Tx) error {
+		for _, name := range db.buckets() {
+			if bucket := tx.Bucket(name); bucket != nil {
+				if err := bucket.ForEach(func(k, _ []byte) error {
+					keys = append(keys, string(k))
+					return nil
+				}); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return errors.Trace(err)
+	}
+	// call work out of the transaction, since work might write the database.
+	for _, key := range keys {
+		if err := work(key); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (db *Bolt) Purge() error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range db.buckets() {
+			if err := tx.DeleteBucket(name); err != nil && err != bbolt.ErrBucketNotFound {
+				return errors.Trace(err)
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) Set(ctx context.Context, values ...Value) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(db.ValuesTable()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, value := range values {
+			if err = bucket.Put([]byte(value.name), []byte(value.value)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) Get(ctx context.Context, name string) *ReturnValue {
+	var (
+		value string
+		exist bool
+	)
+	if err := db.db.View(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket([]byte(db.ValuesTable())); bucket != nil {
+			if v := bucket.Get([]byte(name)); v != nil {
+				value, exist = string(v), true
+			}
+		}
+		return nil
+	}); err != nil {
+		return &ReturnValue{err: errors.Trace(err)}
+	}
+	if !exist {
+		return &ReturnValue{err: errors.Annotate(ErrObjectNotExist, name)}
+	}
+	return &ReturnValue{value: value}
+}
+
+func (db *Bolt) Delete(ctx context.Context, name string) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		if bucket := tx.Bucket([]byte(db.ValuesTable())); bucket != nil {
+			return errors.Trace(bucket.Delete([]byte(name)))
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) GetSet(ctx context.Context, key string) ([]string, error) {
+	var members []string
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		set := nestedBucket(tx, db.SetsTable(), key)
+		if set == nil {
+			return nil
+		}
+		return set.ForEach(func(k, _ []byte) error {
+			members = append(members, string(k))
+			return nil
+		})
+	})
+	return members, errors.Trace(err)
+}
+
+func (db *Bolt) SetSet(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		if err := deleteNestedBucket(tx, db.SetsTable(), key); err != nil {
+			return errors.Trace(err)
+		}
+		return db.addSet(tx, key, members)
+	})
+}
+
+func (db *Bolt) AddSet(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		return db.addSet(tx, key, members)
+	})
+}
+
+func (db *Bolt) addSet(tx *bbolt.Tx, key string, members []string) error {
+	set, err := createNestedBucket(tx, db.SetsTable(), key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, member := range members {
+		if err = set.Put([]byte(member), nil); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (db *Bolt) RemSet(ctx context.Context, key string, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		set := nestedBucket(tx, db.SetsTable(), key)
+		if set == nil {
+			return nil
+		}
+		for _, member := range members {
+			if err := set.Delete([]byte(member)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		// remove the empty set like Redis
+		if k, _ := set.Cursor().First(); k == nil {
+			return deleteNestedBucket(tx, db.SetsTable(), key)
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) AddSorted(ctx context.Context, sortedSets ...SortedSet) error {
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		for _, sortedSet := range sortedSets {
+			if err := db.addSorted(tx, sortedSet.name, sortedSet.scores); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) addSorted(tx *bbolt.Tx, key string, scores []Scored) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	sorted, err := createNestedBucket(tx, db.SortedSetsTable(), key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	members, err := sorted.CreateBucketIfNotExists(boltMembers)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	index, err := sorted.CreateBucketIfNotExists(boltScores)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, score := range scores {
+		member := []byte(score.Id)
+		if prev := members.Get(member); prev != nil {
+			if err = index.Delete(encodeScoreIndex(decodeScore(prev), member)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if err = members.Put(member, encodeScore(score.Score)); err != nil {
+			return errors.Trace(err)
+		}
+		if err = index.Put(encodeScoreIndex(score.Score, member), nil); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// GetSorted returns members between begin and end ranked by scores from high to low. All members after begin are
+// returned if end < 0.
+func (db *Bolt) GetSorted(ctx context.Context, key string, begin, end int) ([]Scored, error) {
+	var results []Scored
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		results = db.getSorted(tx, key, begin, end)
+		return nil
+	})
+	return results, errors.Trace(err)
+}
+
+func (db *Bolt) getSorted(tx *bbolt.Tx, key string, begin, end int) []Scored {
+	var results []Scored
+	index := sortedIndex(tx, db.SortedSetsTable(), key)
+	if index == nil {
+		return results
+	}
+	c := index.Cursor()
+	i := 0
+	for k, _ := c.Last(); k != nil && (end < 0 || i <= end); k, _ = c.Prev() {
+		if i >= begin {
+			results = append(results, decodeScoreIndex(k))
+		}
+		i++
+	}
+	return results
+}
+
+// GetSortedByScore returns members with scores between begin and end ranked by scores from low to high.
+func (db *Bolt) GetSortedByScore(ctx context.Context, key string, begin, end float64) ([]Scored, error) {
+	var results []Scored
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		index := sortedIndex(tx, db.SortedSetsTable(), key)
+		if index == nil {
+			return nil
+		}
+		c := index.Cursor()
+		for k, _ := c.Seek(encodeScore(begin)); k != nil; k, _ = c.Next() {
+			scored := decodeScoreIndex(k)
+			if scored.Score > end {
+				break
+			}
+			results = append(results, scored)
+		}
+		return nil
+	})
+	return results, errors.Trace(err)
+}
+
+func (db *Bolt) RemSortedByScore(ctx context.Context, key string, begin, end float64) error {
+	removed, err := db.GetSortedByScore(ctx, key, begin, end)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	members := make([]SetMember, len(removed))
+	for i, scored := range removed {
+		members[i] = Member(key, scored.Id)
+	}
+	return db.RemSorted(ctx, members...)
+}
+
+func (db *Bolt) SetSorted(ctx context.Context, key string, scores []Scored) error {
+	return db.BatchSetSorted(ctx, Sorted(key, scores))
+}
+
+func (db *Bolt) BatchGetSorted(ctx context.Context, keys []string, begin, end int) ([][]Scored, error) {
+	results := make([][]Scored, len(keys))
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		for i, key := range keys {
+			results[i] = db.getSorted(tx, key, begin, end)
+			if results[i] == nil {
+				results[i] = []Scored{}
+			}
+		}
+		return nil
+	})
+	return results, errors.Trace(err)
+}
+
+func (db *Bolt) BatchSetSorted(ctx context.Context, sortedSets ...SortedSet) error {
+	if len(sortedSets) == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		for _, sortedSet := range sortedSets {
+			if err := deleteNestedBucket(tx, db.SortedSetsTable(), sortedSet.name); err != nil {
+				return errors.Trace(err)
+			}
+			if err := db.addSorted(tx, sortedSet.name, sortedSet.scores); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *Bolt) RemSorted(ctx context.Context, members ...SetMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return db.db.Update(func(tx *bbolt.Tx) error {
+		for _, member := range members {
+			sorted := nestedBucket(tx, db.SortedSetsTable(), member.name)
+			if sorted == nil {
+				continue
+			}
+			scores, index := sorted.Bucket(boltMembers), sorted.Bucket(boltScores)
+			if score := scores.Get([]byte(member.member)); score != nil {
+				if err := index.Delete(encodeScoreIndex(decodeScore(score), []byte(member.member))); err != nil {
+					return errors.Trace(err)
+				}
+				if err := scores.Delete([]byte(member.member)); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			// remove the empty sorted set like Redis
+			if k, _ := scores.Cursor().First(); k == nil {
+				if err := deleteNestedBucket(tx, db.SortedSetsTable(), member.name); err != nil {
+					return errors.Trace(err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func nestedBucket(tx *bbolt.Tx, table, key string) *bbolt.Bucket {
+	bucket := tx.Bucket([]byte(table))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Bucket([]byte(key))
+}
+
+func createNestedBucket(tx *bbolt.Tx, table, key string) (*bbolt.Bucket, error) {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(table))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	nested, err := bucket.CreateBucketIfNotExists([]byte(key))
+	return nested, errors.Trace(err)
+}
+
+func deleteNestedBucket(tx *bbolt.Tx, table, key string) error {
+	bucket := tx.Bucket([]byte(table))
+	if bucket == nil {
+		return nil
+	}
+	if err := bucket.DeleteBucket([]byte(key)); err != nil && err != bbolt.ErrBucketNotFound {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func sortedIndex(tx *bbolt.Tx, table, key string) *bbolt.Bucket {
+	sorted := nestedBucket(tx, table, key)
+	if sorted == nil {
+		return nil
+	}
+	return sorted.Bucket(boltScores)
+}
+
+// encodeScore encodes a score to 8 bytes whose lexicographical order is the same as the order of scores.
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+func encodeScoreIndex(score float64, member []byte) []byte {
+	return bytes.Join([][]byte{encodeScore(score), member}, nil)
+}
+
+func decodeScoreIndex(k []byte) Scored {
+	return Scored{Id: string(k[8:]), Score: decodeScore(k[:8])}
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/zhenghaoz/gorse/storage"
+)
+
+type BoltTestSuite struct {
+	baseTestSuite
+}
+
+func (suite *BoltTestSuite) SetupSuite() {
+	var err error
+	suite.Database, err = Open(storage.LocalPrefix+filepath.Join(suite.T().TempDir(), "cache.db"), "gorse_")
+	suite.NoError(err)
+	err = suite.Database.Init()
+	suite.NoError(err)
+}
+
+func TestBolt(t *testing.T) {
+	suite.Run(t, new(BoltTestSuite))
+}
+
+func TestBolt_Share(t *testing.T) {
+	path := storage.LocalPrefix + filepath.Join(t.TempDir(), "cache.db")
+	// the file is shared by stores in the same process
+	a, err := Open(path, "")
+	assert.NoError(t, err)
+	b, err := Open(path, "")
+	assert.NoError(t, err)
+	assert.NoError(t, a.Set(context.Background(), String("key", "value")))
+	value, err := b.Get(context.Background(), "key").String()
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	// the file is closed after all stores are closed
+	assert.NoError(t, a.Close())
+	assert.NoError(t, b.Ping())
+	assert.NoError(t, b.Close())
+	assert.Error(t, b.Ping())
+	// data persists after reopened
+	c, err := Open(path, "")
+	assert.NoError(t, err)
+	value, err = c.Get(context.Background(), "key").String()
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.NoError(t, c.Close())
+}
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(-1), -100, -1.5, 0, 1e-9, 1.5, 100, math.Inf(1)}
+	for i, score := range scores {
+		assert.Equal(t, score, decodeScore(encodeScore(score)))
+		if i > 0 {
+			assert.Less(t, string(encodeScore(scores[i-1])), string(encodeScore(score)))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
+	"github.com/scylladb/go-set/strset"
+)
+
+// compressedPrefixes are prefixes of sorted sets compressed if they are larger than the threshold. Only sorted sets of
+// each user are compressed, since a compressed sorted set is rewritten by each update.
+var compressedPrefixes = strset.New(OfflineRecommend, CollaborativeRecommend, IgnoreItems)
+
+// maxCompressedRetries is the max number of retries to update a compressed sorted set modified concurrently.
+const maxCompressedRetries = 10
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compressSorted encodes scores in descending order and compresses them by zstd. The member of the latest score is
+// kept if a member appears multiple times.
+func compressSorted(scores []Scored) []byte {
+	scores = sortScores(scores)
+	buf := make([]byte, binary.MaxVarintLen64)
+	data := append([]byte(nil), buf[:binary.PutUvarint(buf, uint64(len(scores)))]...)
+	for _, score := range scores {
+		data = append(data, buf[:binary.PutUvarint(buf, uint64(len(score.Id)))]...)
+		data = append(data, score.Id...)
+		binary.BigEndian.PutUint64(buf, math.Float64bits(score.Score))
+		data = append(data, buf[:8]...)
+	}
+	return zstdEncoder.EncodeAll(data, nil)
+}
+
+// decompressSorted decodes scores in descending order compressed by compressSorted.
+func decompressSorted(compressed []byte) ([]Scored, error) {
+	data, err := zstdDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	n, offset := binary.Uvarint(data)
+	if offset <= 0 || n > uint64(len(data)) {
+		return nil, errors.NotValidf("compressed sorted set")
+	}
+	scores := make([]Scored, 0, n)
+	for i := uint64(0); i < n; i++ {
+		length, size := binary.Uvarint(data[offset:])
+		if size <= 0 || uint64(len(data)-offset-size) < length+8 {
+			return nil, errors.NotValidf("compressed sorted set")
+		}
+		offset += size
+		id := string(data[offset : offset+int(length)])
+		offset += int(length)
+		scores = append(scores, Scored{Id: id, Score: math.Float64frombits(binary.BigEndian.Uint64(data[offset:]))})
+		offset += 8
+	}
+	return scores, nil
+}
+
+// sortScores removes duplicate members and sorts scores in the order of ZREVRANGE, which is descending by scores and
+// members.
+func sortScores(scores []Scored) []Scored {
+	positions := make(map[string]int, len(scores))
+	sorted := make([]Scored, 0, len(scores))
+	for _, score := range scores {
+		if i, exist := positions[score.Id]; exist {
+			sorted[i].Score = score.Score
+		} else {
+			positions[score.Id] = len(sorted)
+			sorted = append(sorted, score)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Score != sorted[j].Score {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].Id > sorted[j].Id
+	})
+	return sorted
+}
+
+// isCompressed returns true if a command failed since the sorted set is compressed, which is stored as a string.
+func isCompressed(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+}
+
+// compressible returns true if the sorted set is compressed once it is larger than the threshold.
+func (r *Redis) compressible(name string) bool {
+	return r.compressThreshold > 0 && compressedPrefixes.Has(strings.SplitN(name, "/", 2)[0])
+}
+
+// setSorted replaces scores of a sorted set in a pipeline. The sorted set is compressed if it is larger than the
+// threshold.
+func (r *Redis) setSorted(ctx context.Context, p redis.Pipeliner, name string, scores []Scored) {
+	p.Del(ctx, r.Key(name))
+	if len(scores) == 0 {
+		return
+	}
+	if r.compressible(name) && len(scores) > r.compressThreshold {
+		p.Set(ctx, r.Key(name), compressSorted(scores), 0)
+		return
+	}
+	members := make([]redis.Z, 0, len(scores))
+	for _, score := range scores {
+		members = append(members, redis.Z{Member: score.Id, Score: score.Score})
+	}
+	p.ZAdd(ctx, r.Key(name), members...)
+}
+
+// getCompressed reads all scores of a compressed sorted set in descending order.
+func (r *Redis) getCompressed(ctx context.Context, client redis.Cmdable, name string) ([]Scored, error) {
+	buf, err := client.Get(ctx, r.Key(name)).Bytes()
+	if err == redis.Nil {
+		return []Scored{}, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return decompressSorted(buf)
+}
+
+// updateSorted reads a compressed or uncompressed sorted set, updates scores and writes the sorted set back in an
+// optimistic transaction, which is retried if the sorted set is modified concurrently. Only the sorted set is watched
+// and written in the transaction, so that the transaction is served by a single slot of Redis Cluster.
+func (r *Redis) updateSorted(ctx context.Context, name string, update func([]Scored) []Scored) error {
+	for i := 0; i < maxCompressedRetries; i++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			var scores []Scored
+			members, err := tx.ZRevRangeWithScores(ctx, r.Key(name), 0, -1).Result()
+			if isCompressed(err) {
+				if scores, err = r.getCompressed(ctx, tx, name); err != nil {
+					return errors.Trace(err)
+				}
+			} else if err != nil {
+				return errors.Trace(err)
+			} else {
+				scores = make([]Scored, 0, len(members))
+				for _, member := range members {
+					scores = append(scores, Scored{Id: member.Member.(string), Score: member.Score})
+				}
+			}
+			scores = update(scores)
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				r.setSorted(ctx, p, name, scores)
+				return nil
+			})
+			return err
+		}, r.Key(name))
+		if err != redis.TxFailedErr {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Errorf("failed to update sorted set %s after %d retries", name, maxCompressedRetries)
+}
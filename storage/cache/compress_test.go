@@ -0,0 +1,41 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressSorted(t *testing.T) {
+	var scores []Scored
+	for i := 0; i < 1000; i++ {
+		scores = append(scores, Scored{Id: strconv.Itoa(i), Score: float64(i % 100)})
+	}
+	// the latest score of a member is kept
+	scores = append(scores, Scored{Id: "0", Score: 1000})
+	compressed := compressSorted(scores)
+	result, err := decompressSorted(compressed)
+	assert.NoError(t, err)
+	assert.Len(t, result, 1000)
+	assert.Equal(t, Scored{Id: "0", Score: 1000}, result[0])
+	assert.Equal(t, Scored{Id: "999", Score: 99}, result[1])
+	assert.Equal(t, Scored{Id: "99", Score: 99}, result[2])
+	// corrupted data
+	_, err = decompressSorted(compressed[:len(compressed)/2])
+	assert.Error(t, err)
+}
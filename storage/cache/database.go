@@ -49,10 +49,58 @@ const (
 	// 	Measurements - measurements/{name}
 	Measurements = "measurements"
 
+	// FallbackUsage is sorted set of number of recommendation requests served by each source for each day. The member
+	// is {cohort}/{source}/{node} so that nodes count requests independently.
+	//  Fallback usage     - fallback_usage/{date}
+	FallbackUsage = "fallback_usage"
+
+	// APIKeyRequests and APIKeyWrites are sorted sets of number of requests and written rows of each API key for
+	// each day. The member is {name}/{node}.
+	//  API key requests   - api_key_requests/{date}
+	//  API key writes     - api_key_writes/{date}
+	APIKeyRequests = "api_key_requests"
+	APIKeyWrites   = "api_key_writes"
+
+	// RecommendSources is sorted set of recommended items for each user. The member is {source}/{item_id} and the
+	// score is the time when the item is recommended.
+	//  Recommend sources  - recommend_sources/{user_id}
+	RecommendSources = "recommend_sources"
+
+	// SourceServed and SourcePositive are sorted sets of number of recommended items and positive feedback of each
+	// source for each day. The member is {source}/{node}.
+	//  Source served      - source_served/{date}
+	//  Source positive    - source_positive/{date}
+	SourceServed   = "source_served"
+	SourcePositive = "source_positive"
+
+	// ItemServed is sorted set of number of times each item is recommended for each day. The member is
+	// {item_id}/{node}.
+	//  Item served        - item_served/{date}
+	ItemServed = "item_served"
+
+	// ItemImpressions is sorted set of items returned to each user. The member is {item_id}/{timestamp} and the score
+	// is the time when the item is returned.
+	//  Item impressions   - item_impressions/{user_id}
+	ItemImpressions = "item_impressions"
+
+	// LabeledItems is sorted set of items with each label. The score is the time when the item is labeled.
+	//  Labeled items      - labeled_items/{label}
+	LabeledItems = "labeled_items"
+
+	// ItemLocations is sorted set of items with locations. The score is the 52-bit geohash of the location, which is
+	// encoded by EncodeGeohash.
+	//  Item locations     - item_locations
+	ItemLocations = "item_locations"
+
 	// IgnoreItems is sorted set of ignored items for each user
 	//  Ignored items      - ignore_items/{user_id}
 	IgnoreItems = "ignore_items"
 
+	// SuppressedItems is sorted set of items suppressed by negative feedback for each user. The score is the time
+	// when the suppression window closes.
+	//  Suppressed items   - suppressed_items/{user_id}
+	SuppressedItems = "suppressed_items"
+
 	// HiddenItemsV2 is sorted set of hidden items.
 	//  Global hidden items 	- hidden_items_v2
 	//  Category hidden items   - hidden_items_v2/{category}
@@ -67,6 +115,10 @@ const (
 	//	Item neighbors digest      - item_neighbors_digest/{item_id}
 	ItemNeighborsDigest = "item_neighbors_digest"
 
+	// AlsoLikedItems is sorted set of items liked by users who liked each item. The score is the number of these users.
+	//  Also liked items           - also_liked_items/{item_id}
+	AlsoLikedItems = "also_liked_items"
+
 	// UserNeighbors is sorted set of neighbors for each user.
 	//  User neighbors      - user_neighbors/{user_id}
 	UserNeighbors = "user_neighbors"
@@ -94,20 +146,60 @@ const (
 	//  Categorized popular items - latest_items/{category}
 	PopularItems = "popular_items"
 
+	// ItemFrequency is sorted set of numbers of positive feedback of items. The format of key:
+	//  Item frequency - item_frequency
+	ItemFrequency = "item_frequency"
+
 	// LatestItems is sorted set of the latest items. The format of key:
 	//  Global latest items      - latest_items
 	//  Categorized the latest items - latest_items/{category}
 	LatestItems = "latest_items"
 
+	// ColdStartItems is sorted set of cold-start items, which are new items with few impressions. The score is the
+	// timestamp of the item. The format of key:
+	//  Global cold-start items      - cold_start_items
+	//  Categorized cold-start items - cold_start_items/{category}
+	ColdStartItems = "cold_start_items"
+
+	// WorkerCheckpoint is the progress of offline recommendation in each worker. The format of key:
+	//  Checkpoint of a worker - worker_checkpoint/{worker_name}
+	WorkerCheckpoint = "worker_checkpoint"
+
+	// LeaderLease is the lock held by the leader of master nodes. The format of key:
+	//  Lock of the leader - leader_lease/{name}
+	LeaderLease = "leader_lease"
+
 	// ItemCategories is the set of item categories. The format of key:
 	//	Global item categories - item_categories
 	ItemCategories = "item_categories"
 
+	// UserRedirect is the user that a merged user is redirected to. The format of key:
+	//	Redirect of a merged user - user_redirect/{user_id}
+	UserRedirect = "user_redirect"
+
+	// FeedbackEvents is the sorted set of events of feedback with a count threshold, scored by timestamps. The format
+	// of key:
+	//	Events of feedback - feedback_events/{feedback_type}/{user_id}/{item_id}
+	FeedbackEvents = "feedback_events"
+
+	// Session is the state of an anonymous session. The format of key:
+	//	State of an anonymous session - session/{session_id}
+	Session = "session"
+
+	// BlockedLabels is the set of labels excluded from model training. The format of key:
+	//	Global blocked labels - blocked_labels
+	BlockedLabels = "blocked_labels"
+
+	// ActiveUsers is sorted set of users by the latest timestamp that user related data was modified. The format of key:
+	//	Global active users - active_users
+	ActiveUsers = "active_users"
+
 	LastModifyItemTime          = "last_modify_item_time"           // the latest timestamp that a user related data was modified
 	LastModifyUserTime          = "last_modify_user_time"           // the latest timestamp that an item related data was modified
 	LastUpdateUserRecommendTime = "last_update_user_recommend_time" // the latest timestamp that a user's recommendation was updated
 	LastUpdateUserNeighborsTime = "last_update_user_neighbors_time" // the latest timestamp that a user's neighbors item was updated
 	LastUpdateItemNeighborsTime = "last_update_item_neighbors_time" // the latest timestamp that an item's neighbors was updated
+	LastUpdateAlsoLikedTime     = "last_update_also_liked_time"     // the latest timestamp that also liked items were updated
 
 	// GlobalMeta is global meta information
 	GlobalMeta                 = "global_meta"
@@ -126,6 +218,16 @@ const (
 	UserNeighborIndexRecall    = "user_neighbor_index_recall"
 	ItemNeighborIndexRecall    = "item_neighbor_index_recall"
 	MatchingIndexRecall        = "matching_index_recall"
+	UserShards                 = "user_shards"            // assignment of user shards to workers
+	BusinessRules              = "business_rules"         // rules to pin, boost and block items in online recommendation
+	UserSegments               = "user_segments"          // segments of users to override configurations of recommendation
+	DynamicConfig              = "dynamic_config"         // settings to override recommendation settings in the config file
+	DynamicConfigHistory       = "dynamic_config_history" // changes of dynamic config
+	DashboardAccounts          = "dashboard_accounts"     // accounts and roles of dashboard operators
+	DataStoreCounts            = "data_store_counts"      // numbers of users, items and feedback in the data store
+	CategoryMetadata           = "category_metadata"      // metadata and hierarchy of item categories
+	LabelCounts                = "label_counts"           // numbers of users and items of labels
+	ItemAliases                = "item_aliases"           // canonical items of duplicate items
 )
 
 var (
@@ -264,6 +366,17 @@ func Sorted(name string, scores []Scored) SortedSet {
 	return SortedSet{name: name, scores: scores}
 }
 
+// sliceScores returns scores between begin and end (inclusive). Scores to the end are returned if end is negative.
+func sliceScores(scores []Scored, begin, end int) []Scored {
+	if end < 0 || end >= len(scores) {
+		end = len(scores) - 1
+	}
+	if begin >= len(scores) || begin > end {
+		return []Scored{}
+	}
+	return scores[begin : end+1]
+}
+
 type SetMember struct {
 	name   string
 	member string
@@ -296,6 +409,43 @@ type Database interface {
 	RemSortedByScore(ctx context.Context, key string, begin, end float64) error
 	SetSorted(ctx context.Context, key string, scores []Scored) error
 	RemSorted(ctx context.Context, members ...SetMember) error
+	// BatchGetSorted gets scores of multiple sorted sets in a round trip. Results are in the same order as keys.
+	BatchGetSorted(ctx context.Context, keys []string, begin, end int) ([][]Scored, error)
+	// BatchSetSorted sets scores of multiple sorted sets in a round trip and clears previous scores.
+	BatchSetSorted(ctx context.Context, sortedSets ...SortedSet) error
+}
+
+// Locker is implemented by cache stores supporting distributed locks.
+type Locker interface {
+	// Lock acquires a lock for the owner or extends the lock if the owner holds it already. False is returned if the
+	// lock is held by another owner.
+	Lock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// Unlock releases a lock if the owner holds it.
+	Unlock(ctx context.Context, name, owner string) error
+}
+
+// Options of the connection to a cache store.
+type Options struct {
+	Encoding          string // encoding of documents
+	CompressThreshold int    // sorted sets of each user larger than the threshold are compressed, 0 means no compression
+}
+
+// Configure applies options to the connection to a cache store. Binary encodings of documents and compression of
+// sorted sets are supported by Redis only, since other cache stores save documents and members in text columns.
+func Configure(database Database, options Options) {
+	if r, ok := database.(*Redis); ok {
+		r.encoding = options.Encoding
+		r.compressThreshold = options.CompressThreshold
+		return
+	}
+	if options.Encoding != "" && options.Encoding != JSONEncoding {
+		log.Logger().Warn("binary encoding of documents is only supported by redis, JSON is used instead",
+			zap.String("cache_encoding", options.Encoding))
+	}
+	if options.CompressThreshold > 0 {
+		log.Logger().Warn("compression of sorted sets is only supported by redis",
+			zap.Int("cache_compress_threshold", options.CompressThreshold))
+	}
 }
 
 // Open a connection to a database.
@@ -327,6 +477,19 @@ func Open(path, tablePrefix string) (Database, error) {
 			return nil, errors.Trace(err)
 		}
 		return database, nil
+	} else if strings.HasPrefix(path, storage.RedisSentinelPrefix) {
+		opt, err := ParseRedisSentinelURL(path)
+		if err != nil {
+			return nil, err
+		}
+		database := new(Redis)
+		database.client = redis.NewFailoverClient(opt)
+		database.TablePrefix = storage.TablePrefix(tablePrefix)
+		if err = redisotel.InstrumentTracing(database.client, redisotel.WithAttributes(semconv.DBSystemRedis)); err != nil {
+			log.Logger().Error("failed to add tracing for redis", zap.Error(err))
+			return nil, errors.Trace(err)
+		}
+		return database, nil
 	} else if strings.HasPrefix(path, storage.MongoPrefix) || strings.HasPrefix(path, storage.MongoSrvPrefix) {
 		// connect to database
 		database := new(MongoDB)
@@ -434,6 +597,12 @@ func Open(path, tablePrefix string) (Database, error) {
 			return nil, errors.Trace(err)
 		}
 		return database, nil
+	} else if strings.HasPrefix(path, storage.LocalPrefix) {
+		database, err := OpenBolt(path[len(storage.LocalPrefix):], tablePrefix)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return database, nil
 	}
 	return nil, errors.Errorf("Unknown database: %s", path)
 }
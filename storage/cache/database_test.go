@@ -279,6 +279,36 @@ func (suite *baseTestSuite) TestPurge() {
 	suite.NoError(err)
 }
 
+func (suite *baseTestSuite) TestBatchSorted() {
+	ctx := context.Background()
+	// set sorted sets
+	err := suite.Database.SetSorted(ctx, "batch_sort_c", []Scored{{"0", 0}})
+	suite.NoError(err)
+	err = suite.Database.BatchSetSorted(ctx,
+		Sorted("batch_sort_a", []Scored{{"0", 0}, {"1", 1}, {"2", 2}}),
+		Sorted("batch_sort_b", []Scored{{"3", 3}, {"4", 4}}),
+		Sorted("batch_sort_c", nil))
+	suite.NoError(err)
+	err = suite.Database.BatchSetSorted(ctx)
+	suite.NoError(err)
+	// get sorted sets
+	results, err := suite.Database.BatchGetSorted(ctx, []string{"batch_sort_b", "batch_sort_a", "batch_sort_c"}, 0, -1)
+	suite.NoError(err)
+	suite.Equal([][]Scored{{{"4", 4}, {"3", 3}}, {{"2", 2}, {"1", 1}, {"0", 0}}, {}}, results)
+	results, err = suite.Database.BatchGetSorted(ctx, []string{"batch_sort_a", "batch_sort_b"}, 1, 1)
+	suite.NoError(err)
+	suite.Equal([][]Scored{{{"1", 1}}, {{"3", 3}}}, results)
+	results, err = suite.Database.BatchGetSorted(ctx, nil, 0, -1)
+	suite.NoError(err)
+	suite.Empty(results)
+	// overwrite sorted sets
+	err = suite.Database.BatchSetSorted(ctx, Sorted("batch_sort_a", []Scored{{"5", 5}}))
+	suite.NoError(err)
+	scores, err := suite.Database.GetSorted(ctx, "batch_sort_a", 0, -1)
+	suite.NoError(err)
+	suite.Equal([]Scored{{"5", 5}}, scores)
+}
+
 func TestScored(t *testing.T) {
 	itemIds := []string{"2", "4", "6"}
 	scores := []float64{2, 4, 6}
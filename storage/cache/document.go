@@ -0,0 +1,56 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/encoding"
+)
+
+// Encodings of documents in the cache store, such as dynamic config, business rules and payloads of measurements.
+const (
+	JSONEncoding    = "json"
+	MsgpackEncoding = "msgpack"
+)
+
+// msgpackMarker is the first byte of documents encoded in MessagePack. The byte is never used by MessagePack and never
+// starts a JSON document, so that documents in both encodings are read while documents are migrated by writes.
+const msgpackMarker = 0xc1
+
+// Marshal encodes a document written to the cache store by the encoding of the cache store.
+func Marshal(database Database, v interface{}) (string, error) {
+	if r, ok := database.(*Redis); ok && r.encoding == MsgpackEncoding {
+		buf, err := encoding.MarshalMsgpack(v)
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+		return string(append([]byte{msgpackMarker}, buf...)), nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(buf), nil
+}
+
+// Unmarshal decodes a document in the cache store, which is encoded in either JSON or MessagePack.
+func Unmarshal(data string, v interface{}) error {
+	if len(data) > 0 && data[0] == msgpackMarker {
+		return errors.Trace(encoding.UnmarshalMsgpack([]byte(data[1:]), v))
+	}
+	return errors.Trace(json.Unmarshal([]byte(data), v))
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocument(t *testing.T) {
+	type document struct {
+		Name  string  `json:"name"`
+		Value float32 `json:"value"`
+	}
+	a := document{Name: "a", Value: 1.5}
+	jsonDatabase := &Redis{}
+	Configure(jsonDatabase, Options{Encoding: JSONEncoding})
+	msgpackDatabase := &Redis{}
+	Configure(msgpackDatabase, Options{Encoding: MsgpackEncoding})
+
+	jsonBuf, err := Marshal(jsonDatabase, a)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"a","value":1.5}`, jsonBuf)
+	msgpackBuf, err := Marshal(msgpackDatabase, a)
+	assert.NoError(t, err)
+	assert.Less(t, len(msgpackBuf), len(jsonBuf))
+	// documents in both encodings are read
+	for _, buf := range []string{jsonBuf, msgpackBuf} {
+		var b document
+		assert.NoError(t, Unmarshal(buf, &b))
+		assert.Equal(t, a, b)
+	}
+	// cache stores other than Redis write JSON
+	sqlDatabase := &SQLDatabase{}
+	Configure(sqlDatabase, Options{Encoding: MsgpackEncoding})
+	buf, err := Marshal(sqlDatabase, a)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonBuf, buf)
+}
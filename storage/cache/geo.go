@@ -0,0 +1,116 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "math"
+
+const (
+	geohashStep = 26     // number of bits of each coordinate in a geohash
+	earthRadius = 6371.0 // in kilometers
+)
+
+// EncodeGeohash encodes a location into a 52-bit geohash, which is represented by a score of sorted sets exactly. Bits
+// of longitudes and latitudes are interleaved so that nearby locations have close geohashes.
+func EncodeGeohash(latitude, longitude float64) float64 {
+	return float64(interleave(quantize(latitude, 90), quantize(longitude, 180), geohashStep))
+}
+
+// DecodeGeohash decodes a geohash into the center of its cell.
+func DecodeGeohash(hash float64) (latitude, longitude float64) {
+	lat, lng := deinterleave(uint64(hash), geohashStep)
+	return dequantize(lat, 90), dequantize(lng, 180)
+}
+
+// GeohashRanges returns ranges of geohashes covering the circle around a location. The radius is in kilometers and
+// both ends of ranges are inclusive. Locations in ranges might be out of the circle, so that distances should be
+// checked again.
+func GeohashRanges(latitude, longitude, radius float64) [][2]float64 {
+	// find the finest step whose cells are larger than the circle
+	maxLatitude := math.Min(math.Abs(latitude)+radius/earthRadius*180/math.Pi, 90)
+	step := geohashStep
+	for ; step > 0; step-- {
+		height := math.Pi * earthRadius / float64(uint64(1)<<step)
+		width := 2 * math.Pi * earthRadius * math.Cos(maxLatitude*math.Pi/180) / float64(uint64(1)<<step)
+		if height >= radius && width >= radius {
+			break
+		}
+	}
+	if step == 0 {
+		return [][2]float64{{0, float64(uint64(1)<<(2*geohashStep) - 1)}}
+	}
+	// the circle is covered by the cell of the location and its neighbors
+	shift := geohashStep - step
+	lat, lng := int64(quantize(latitude, 90)>>shift), int64(quantize(longitude, 180)>>shift)
+	numCells := int64(1) << step
+	visited := make(map[uint64]struct{})
+	var ranges [][2]float64
+	for dLat := int64(-1); dLat <= 1; dLat++ {
+		cellLat := lat + dLat
+		if cellLat < 0 || cellLat >= numCells {
+			continue
+		}
+		for dLng := int64(-1); dLng <= 1; dLng++ {
+			cellLng := (lng + dLng + numCells) % numCells
+			hash := interleave(uint64(cellLat), uint64(cellLng), step)
+			if _, exist := visited[hash]; exist {
+				continue
+			}
+			visited[hash] = struct{}{}
+			ranges = append(ranges, [2]float64{
+				float64(hash << (2 * shift)),
+				float64((hash+1)<<(2*shift) - 1),
+			})
+		}
+	}
+	return ranges
+}
+
+// GeoDistance returns the great-circle distance in kilometers between two locations.
+func GeoDistance(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	lat1, lat2 := latitude1*math.Pi/180, latitude2*math.Pi/180
+	dLat, dLng := lat2-lat1, (longitude2-longitude1)*math.Pi/180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(math.Sqrt(a), 1))
+}
+
+func quantize(x, bound float64) uint64 {
+	cell := (x + bound) / (2 * bound) * float64(uint64(1)<<geohashStep)
+	if cell < 0 {
+		return 0
+	} else if cell >= float64(uint64(1)<<geohashStep) {
+		return uint64(1)<<geohashStep - 1
+	}
+	return uint64(cell)
+}
+
+func dequantize(cell uint64, bound float64) float64 {
+	return (float64(cell)+0.5)/float64(uint64(1)<<geohashStep)*2*bound - bound
+}
+
+func interleave(lat, lng uint64, step int) uint64 {
+	var hash uint64
+	for i := step - 1; i >= 0; i-- {
+		hash = hash<<2 | (lng>>i&1)<<1 | lat>>i&1
+	}
+	return hash
+}
+
+func deinterleave(hash uint64, step int) (lat, lng uint64) {
+	for i := step - 1; i >= 0; i-- {
+		lng = lng<<1 | hash>>(2*i+1)&1
+		lat = lat<<1 | hash>>(2*i)&1
+	}
+	return
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeohash(t *testing.T) {
+	for _, location := range [][2]float64{{31.23, 121.47}, {-33.87, 151.21}, {90, 180}, {-90, -180}, {0, 0}} {
+		latitude, longitude := DecodeGeohash(EncodeGeohash(location[0], location[1]))
+		assert.InDelta(t, location[0], latitude, 1e-5)
+		assert.InDelta(t, location[1], longitude, 1e-5)
+	}
+	// nearby locations have close geohashes
+	assert.Less(t, EncodeGeohash(31.23, 121.47)-EncodeGeohash(31.23, 121.46), EncodeGeohash(31.23, 121.47)-EncodeGeohash(-31.23, 121.47))
+}
+
+func TestGeohashRanges(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	for _, radius := range []float64{0.1, 1, 10, 100, 1000, 10000} {
+		for _, center := range [][2]float64{{31.23, 121.47}, {0, 179.99}, {-89.9, 0}} {
+			ranges := GeohashRanges(center[0], center[1], radius)
+			for i := 0; i < 100; i++ {
+				latitude := center[0] + (rng.Float64()*2-1)*radius/earthRadius*57.3
+				longitude := center[1] + (rng.Float64()*2-1)*radius/earthRadius*57.3
+				if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 ||
+					GeoDistance(center[0], center[1], latitude, longitude) > radius {
+					continue
+				}
+				hash := EncodeGeohash(latitude, longitude)
+				covered := false
+				for _, r := range ranges {
+					if r[0] <= hash && hash <= r[1] {
+						covered = true
+					}
+				}
+				assert.True(t, covered, "(%v, %v) is not covered in %v km of (%v, %v)", latitude, longitude, radius, center[0], center[1])
+			}
+		}
+	}
+}
+
+func TestGeoDistance(t *testing.T) {
+	assert.InDelta(t, 1068, GeoDistance(31.23, 121.47, 39.9, 116.4), 5)
+	assert.InDelta(t, 0, GeoDistance(31.23, 121.47, 31.23, 121.47), 1e-9)
+}
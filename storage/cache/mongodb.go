@@ -377,6 +377,54 @@ func (m MongoDB) SetSorted(ctx context.Context, name string, scores []Scored) er
 	return errors.Trace(err)
 }
 
+func (m MongoDB) BatchGetSorted(ctx context.Context, names []string, begin, end int) ([][]Scored, error) {
+	members := make(map[string][]Scored)
+	if len(names) > 0 {
+		c := m.client.Database(m.dbName).Collection(m.SortedSetsTable())
+		opt := options.Find()
+		opt.SetSort(bson.M{"score": -1})
+		r, err := c.Find(ctx, bson.M{"name": bson.M{"$in": names}}, opt)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for r.Next(ctx) {
+			var doc bson.Raw
+			if err = r.Decode(&doc); err != nil {
+				return nil, errors.Trace(err)
+			}
+			name := doc.Lookup("name").StringValue()
+			members[name] = append(members[name], Scored{
+				Id:    doc.Lookup("member").StringValue(),
+				Score: doc.Lookup("score").Double(),
+			})
+		}
+	}
+	results := make([][]Scored, len(names))
+	for i, name := range names {
+		results[i] = sliceScores(members[name], begin, end)
+	}
+	return results, nil
+}
+
+func (m MongoDB) BatchSetSorted(ctx context.Context, sortedSets ...SortedSet) error {
+	if len(sortedSets) == 0 {
+		return nil
+	}
+	c := m.client.Database(m.dbName).Collection(m.SortedSetsTable())
+	var models []mongo.WriteModel
+	for _, sorted := range sortedSets {
+		models = append(models, mongo.NewDeleteManyModel().SetFilter(bson.M{"name": bson.M{"$eq": sorted.name}}))
+		for _, score := range sorted.scores {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetUpsert(true).
+				SetFilter(bson.M{"name": bson.M{"$eq": sorted.name}, "member": bson.M{"$eq": score.Id}}).
+				SetUpdate(bson.M{"$set": bson.M{"name": sorted.name, "member": score.Id, "score": score.Score}}))
+		}
+	}
+	_, err := c.BulkWrite(ctx, models)
+	return errors.Trace(err)
+}
+
 func (m MongoDB) RemSorted(ctx context.Context, members ...SetMember) error {
 	if len(members) == 0 {
 		return nil
@@ -104,3 +104,13 @@ func (NoDatabase) SetSorted(_ context.Context, _ string, _ []Scored) error {
 func (NoDatabase) RemSorted(_ context.Context, _ ...SetMember) error {
 	return ErrNoDatabase
 }
+
+// BatchGetSorted method of NoDatabase returns ErrNoDatabase.
+func (NoDatabase) BatchGetSorted(_ context.Context, _ []string, _, _ int) ([][]Scored, error) {
+	return nil, ErrNoDatabase
+}
+
+// BatchSetSorted method of NoDatabase returns ErrNoDatabase.
+func (NoDatabase) BatchSetSorted(_ context.Context, _ ...SortedSet) error {
+	return ErrNoDatabase
+}
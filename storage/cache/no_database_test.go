@@ -65,4 +65,8 @@ func TestNoDatabase(t *testing.T) {
 	assert.ErrorIs(t, err, ErrNoDatabase)
 	err = database.RemSorted(ctx)
 	assert.ErrorIs(t, err, ErrNoDatabase)
+	_, err = database.BatchGetSorted(ctx, nil, 0, 0)
+	assert.ErrorIs(t, err, ErrNoDatabase)
+	err = database.BatchSetSorted(ctx)
+	assert.ErrorIs(t, err, ErrNoDatabase)
 }
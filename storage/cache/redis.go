@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v9"
 	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/strset"
 	"github.com/zhenghaoz/gorse/storage"
 	"net/url"
 	"strconv"
@@ -26,15 +28,24 @@ import (
 	"time"
 )
 
-func ParseRedisClusterURL(redisURL string) (*redis.ClusterOptions, error) {
-	options := &redis.ClusterOptions{}
-	uri := redisURL
+// redisURL is the parsed URL of a Redis cluster or Redis sentinels.
+type redisURL struct {
+	username string
+	password string
+	hosts    []string
+	path     string
+	args     []string
+}
+
+func parseRedisURL(rawURL, prefix string) (*redisURL, error) {
+	u := &redisURL{}
+	uri := rawURL
 
 	var err error
-	if strings.HasPrefix(redisURL, storage.RedisClusterPrefix) {
-		uri = uri[len(storage.RedisClusterPrefix):]
+	if strings.HasPrefix(rawURL, prefix) {
+		uri = uri[len(prefix):]
 	} else {
-		return nil, fmt.Errorf("scheme must be \"redis+cluster\"")
+		return nil, fmt.Errorf("scheme must be %q", strings.TrimSuffix(prefix, "://"))
 	}
 
 	if idx := strings.Index(uri, "@"); idx != -1 {
@@ -53,7 +64,7 @@ func ParseRedisClusterURL(redisURL string) (*redis.ClusterOptions, error) {
 		if strings.Contains(username, "/") {
 			return nil, fmt.Errorf("unescaped slash in username")
 		}
-		options.Username, err = url.PathUnescape(username)
+		u.username, err = url.PathUnescape(username)
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Errorf("invalid username"))
 		}
@@ -65,7 +76,7 @@ func ParseRedisClusterURL(redisURL string) (*redis.ClusterOptions, error) {
 		if strings.Contains(password, "/") {
 			return nil, fmt.Errorf("unescaped slash in password")
 		}
-		options.Password, err = url.PathUnescape(password)
+		u.password, err = url.PathUnescape(password)
 		if err != nil {
 			return nil, errors.Wrap(err, fmt.Errorf("invalid password"))
 		}
@@ -80,23 +91,117 @@ func ParseRedisClusterURL(redisURL string) (*redis.ClusterOptions, error) {
 		hosts = uri[:idx]
 	}
 
-	options.Addrs = strings.Split(hosts, ",")
+	u.hosts = strings.Split(hosts, ",")
 	uri = uri[len(hosts):]
 	if len(uri) > 0 && uri[0] == '/' {
 		uri = uri[1:]
 	}
 
+	// fetch the path field
+	if idx := strings.Index(uri, "?"); idx != -1 {
+		u.path = uri[:idx]
+		uri = uri[idx:]
+	} else {
+		u.path = uri
+		uri = ""
+	}
+
 	// grab connection arguments from URI
-	connectionArgsFromQueryString, err := extractQueryArgsFromURI(uri)
+	u.args, err = extractQueryArgsFromURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func ParseRedisClusterURL(redisURL string) (*redis.ClusterOptions, error) {
+	options := &redis.ClusterOptions{}
+	u, err := parseRedisURL(redisURL, storage.RedisClusterPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if u.path != "" {
+		return nil, errors.New("must have a ? separator between path and query")
+	}
+	options.Username = u.username
+	options.Password = u.password
+	options.Addrs = u.hosts
+
+	handlers := map[string]optionHandler{
+		"max_retries":        {int: &options.MaxRetries},
+		"min_retry_backoff":  {duration: &options.MinRetryBackoff},
+		"max_retry_backoff":  {duration: &options.MaxRetryBackoff},
+		"dial_timeout":       {duration: &options.DialTimeout},
+		"read_timeout":       {duration: &options.ReadTimeout},
+		"write_timeout":      {duration: &options.WriteTimeout},
+		"pool_fifo":          {bool: &options.PoolFIFO},
+		"pool_size":          {int: &options.PoolSize},
+		"pool_timeout":       {duration: &options.PoolTimeout},
+		"min_idle_conns":     {int: &options.MinIdleConns},
+		"max_idle_conns":     {int: &options.MaxIdleConns},
+		"conn_max_idle_time": {duration: &options.ConnMaxIdleTime},
+		"conn_max_lifetime":  {duration: &options.ConnMaxLifetime},
+	}
+	for _, pair := range u.args {
+		err = addOption(handlers, pair)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return options, nil
+}
+
+// ParseRedisSentinelURL parses the URL of Redis sentinels:
+//
+//	redis+sentinel://<user>:<password>@<host1>:<port1>,...,<hostN>:<portN>/<db_number>?master_name=<master_name>
+//
+// The user and password are used to connect the master, while the sentinel_username and sentinel_password options are
+// used to connect sentinels.
+func ParseRedisSentinelURL(redisURL string) (*redis.FailoverOptions, error) {
+	options := &redis.FailoverOptions{}
+	u, err := parseRedisURL(redisURL, storage.RedisSentinelPrefix)
 	if err != nil {
 		return nil, err
 	}
-	for _, pair := range connectionArgsFromQueryString {
-		err = addOption(options, pair)
+	options.Username = u.username
+	options.Password = u.password
+	options.SentinelAddrs = u.hosts
+	if u.path != "" {
+		options.DB, err = strconv.Atoi(u.path)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Errorf("invalid database number: %q", u.path))
+		}
+	}
+
+	handlers := map[string]optionHandler{
+		"master_name":        {string: &options.MasterName},
+		"sentinel_username":  {string: &options.SentinelUsername},
+		"sentinel_password":  {string: &options.SentinelPassword},
+		"replica_only":       {bool: &options.ReplicaOnly},
+		"max_retries":        {int: &options.MaxRetries},
+		"min_retry_backoff":  {duration: &options.MinRetryBackoff},
+		"max_retry_backoff":  {duration: &options.MaxRetryBackoff},
+		"dial_timeout":       {duration: &options.DialTimeout},
+		"read_timeout":       {duration: &options.ReadTimeout},
+		"write_timeout":      {duration: &options.WriteTimeout},
+		"pool_fifo":          {bool: &options.PoolFIFO},
+		"pool_size":          {int: &options.PoolSize},
+		"pool_timeout":       {duration: &options.PoolTimeout},
+		"min_idle_conns":     {int: &options.MinIdleConns},
+		"max_idle_conns":     {int: &options.MaxIdleConns},
+		"conn_max_idle_time": {duration: &options.ConnMaxIdleTime},
+		"conn_max_lifetime":  {duration: &options.ConnMaxLifetime},
+	}
+	for _, pair := range u.args {
+		err = addOption(handlers, pair)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if options.MasterName == "" {
+		return nil, fmt.Errorf("redis: master_name is required")
+	}
 
 	return options, nil
 }
@@ -121,9 +226,10 @@ type optionHandler struct {
 	int      *int
 	bool     *bool
 	duration *time.Duration
+	string   *string
 }
 
-func addOption(options *redis.ClusterOptions, pair string) error {
+func addOption(handlers map[string]optionHandler, pair string) error {
 	kv := strings.SplitN(pair, "=", 2)
 	if len(kv) != 2 || kv[0] == "" {
 		return fmt.Errorf("invalid option")
@@ -139,22 +245,6 @@ func addOption(options *redis.ClusterOptions, pair string) error {
 		return errors.Wrap(err, errors.Errorf("invalid option value %q", kv[1]))
 	}
 
-	handlers := map[string]optionHandler{
-		"max_retries":        {int: &options.MaxRetries},
-		"min_retry_backoff":  {duration: &options.MinRetryBackoff},
-		"max_retry_backoff":  {duration: &options.MaxRetryBackoff},
-		"dial_timeout":       {duration: &options.DialTimeout},
-		"read_timeout":       {duration: &options.ReadTimeout},
-		"write_timeout":      {duration: &options.WriteTimeout},
-		"pool_fifo":          {bool: &options.PoolFIFO},
-		"pool_size":          {int: &options.PoolSize},
-		"pool_timeout":       {duration: &options.PoolTimeout},
-		"min_idle_conns":     {int: &options.MinIdleConns},
-		"max_idle_conns":     {int: &options.MaxIdleConns},
-		"conn_max_idle_time": {duration: &options.ConnMaxIdleTime},
-		"conn_max_lifetime":  {duration: &options.ConnMaxLifetime},
-	}
-
 	lowerKey := strings.ToLower(key)
 	if handler, ok := handlers[lowerKey]; ok {
 		if handler.int != nil {
@@ -172,6 +262,8 @@ func addOption(options *redis.ClusterOptions, pair string) error {
 			if err != nil {
 				return errors.Wrap(err, fmt.Errorf("invalid '%s' value: %q", key, value))
 			}
+		} else if handler.string != nil {
+			*handler.string = value
 		} else {
 			return fmt.Errorf("redis: unexpected option: %s", key)
 		}
@@ -182,10 +274,13 @@ func addOption(options *redis.ClusterOptions, pair string) error {
 	return nil
 }
 
-// Redis cache storage.
+// Redis cache storage. Commands of pipelines are sent to slots separately by the cluster client, and transactions
+// access a single key only, so that no command crosses slots of Redis Cluster.
 type Redis struct {
 	storage.TablePrefix
-	client redis.UniversalClient
+	client            redis.UniversalClient
+	encoding          string // encoding of documents
+	compressThreshold int    // sorted sets larger than the threshold are compressed, 0 means no compression
 }
 
 // Close redis connection.
@@ -225,6 +320,10 @@ func (r *Redis) scan(ctx context.Context, client redis.UniversalClient, work fun
 			return errors.Trace(err)
 		}
 		for _, key := range result {
+			// keys of other versions share the table prefix if the version is 0
+			if storage.IsVersionedKey(key[len(r.TablePrefix):]) {
+				continue
+			}
 			if err = work(key[len(r.TablePrefix):]); err != nil {
 				return errors.Trace(err)
 			}
@@ -257,6 +356,9 @@ func (r *Redis) purge(ctx context.Context, client redis.UniversalClient, isClust
 		if err != nil {
 			return errors.Trace(err)
 		}
+		result = lo.Filter(result, func(key string, _ int) bool {
+			return !storage.IsVersionedKey(key[len(r.TablePrefix):])
+		})
 		if len(result) > 0 {
 			if isCluster {
 				p := client.Pipeline()
@@ -356,7 +458,13 @@ func (r *Redis) RemSet(ctx context.Context, key string, members ...string) error
 // GetSorted get scores from sorted set.
 func (r *Redis) GetSorted(ctx context.Context, key string, begin, end int) ([]Scored, error) {
 	members, err := r.client.ZRevRangeWithScores(ctx, r.Key(key), int64(begin), int64(end)).Result()
-	if err != nil {
+	if isCompressed(err) {
+		scores, err := r.getCompressed(ctx, r.client, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return sliceScores(scores, begin, end), nil
+	} else if err != nil {
 		return nil, err
 	}
 	results := make([]Scored, 0, len(members))
@@ -373,7 +481,20 @@ func (r *Redis) GetSortedByScore(ctx context.Context, key string, begin, end flo
 		Offset: 0,
 		Count:  -1,
 	}).Result()
-	if err != nil {
+	if isCompressed(err) {
+		scores, err := r.getCompressed(ctx, r.client, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// scores in the range are returned in ascending order
+		results := make([]Scored, 0)
+		for i := len(scores) - 1; i >= 0; i-- {
+			if scores[i].Score >= begin && scores[i].Score <= end {
+				results = append(results, scores[i])
+			}
+		}
+		return results, nil
+	} else if err != nil {
 		return nil, err
 	}
 	results := make([]Scored, 0, len(members))
@@ -384,39 +505,69 @@ func (r *Redis) GetSortedByScore(ctx context.Context, key string, begin, end flo
 }
 
 func (r *Redis) RemSortedByScore(ctx context.Context, key string, begin, end float64) error {
-	return r.client.ZRemRangeByScore(ctx, r.Key(key),
+	err := r.client.ZRemRangeByScore(ctx, r.Key(key),
 		strconv.FormatFloat(begin, 'g', -1, 64),
 		strconv.FormatFloat(end, 'g', -1, 64)).
 		Err()
+	if isCompressed(err) {
+		return r.updateSorted(ctx, key, func(scores []Scored) []Scored {
+			return lo.Filter(scores, func(score Scored, _ int) bool {
+				return score.Score < begin || score.Score > end
+			})
+		})
+	}
+	return err
 }
 
-// AddSorted add scores to sorted set.
+// AddSorted add scores to sorted set. Scores added to compressed sorted sets are merged, and sorted sets growing
+// larger than the threshold are compressed.
 func (r *Redis) AddSorted(ctx context.Context, sortedSets ...SortedSet) error {
 	p := r.client.Pipeline()
-	for _, sorted := range sortedSets {
+	adds := make([]*redis.IntCmd, len(sortedSets))
+	cards := make([]*redis.IntCmd, len(sortedSets))
+	for i, sorted := range sortedSets {
 		if len(sorted.scores) > 0 {
 			members := make([]redis.Z, 0, len(sorted.scores))
 			for _, score := range sorted.scores {
 				members = append(members, redis.Z{Member: score.Id, Score: score.Score})
 			}
-			p.ZAdd(ctx, r.Key(sorted.name), members...)
+			adds[i] = p.ZAdd(ctx, r.Key(sorted.name), members...)
+			if r.compressible(sorted.name) {
+				cards[i] = p.ZCard(ctx, r.Key(sorted.name))
+			}
 		}
 	}
-	_, err := p.Exec(ctx)
-	return err
+	if _, err := p.Exec(ctx); err != nil && !isCompressed(err) {
+		return err
+	}
+	for i, sorted := range sortedSets {
+		if adds[i] == nil {
+			continue
+		}
+		if err := adds[i].Err(); isCompressed(err) {
+			added := sorted.scores
+			if err = r.updateSorted(ctx, sorted.name, func(scores []Scored) []Scored {
+				return append(scores, added...)
+			}); err != nil {
+				return errors.Trace(err)
+			}
+		} else if err != nil {
+			return err
+		} else if cards[i] != nil && cards[i].Val() > int64(r.compressThreshold) {
+			if err = r.updateSorted(ctx, sorted.name, func(scores []Scored) []Scored {
+				return scores
+			}); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
 }
 
 // SetSorted set scores in sorted set and clear previous scores.
 func (r *Redis) SetSorted(ctx context.Context, key string, scores []Scored) error {
-	members := make([]redis.Z, 0, len(scores))
-	for _, score := range scores {
-		members = append(members, redis.Z{Member: score.Id, Score: float64(score.Score)})
-	}
 	pipeline := r.client.Pipeline()
-	pipeline.Del(ctx, r.Key(key))
-	if len(scores) > 0 {
-		pipeline.ZAdd(ctx, r.Key(key), members...)
-	}
+	r.setSorted(ctx, pipeline, key, scores)
 	_, err := pipeline.Exec(ctx)
 	return err
 }
@@ -427,9 +578,108 @@ func (r *Redis) RemSorted(ctx context.Context, members ...SetMember) error {
 		return nil
 	}
 	pipe := r.client.Pipeline()
-	for _, member := range members {
-		pipe.ZRem(ctx, r.Key(member.name), member.member)
+	commands := make([]*redis.IntCmd, len(members))
+	for i, member := range members {
+		commands[i] = pipe.ZRem(ctx, r.Key(member.name), member.member)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !isCompressed(err) {
+		return errors.Trace(err)
+	}
+	// members are removed from compressed sorted sets
+	removed := make(map[string]*strset.Set)
+	for i, command := range commands {
+		if err := command.Err(); isCompressed(err) {
+			if _, exist := removed[members[i].name]; !exist {
+				removed[members[i].name] = strset.New()
+			}
+			removed[members[i].name].Add(members[i].member)
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for name, set := range removed {
+		if err := r.updateSorted(ctx, name, func(scores []Scored) []Scored {
+			return lo.Filter(scores, func(score Scored, _ int) bool {
+				return !set.Has(score.Id)
+			})
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// BatchGetSorted gets scores of multiple sorted sets in a pipeline.
+func (r *Redis) BatchGetSorted(ctx context.Context, keys []string, begin, end int) ([][]Scored, error) {
+	pipe := r.client.Pipeline()
+	commands := make([]*redis.ZSliceCmd, len(keys))
+	for i, key := range keys {
+		commands[i] = pipe.ZRevRangeWithScores(ctx, r.Key(key), int64(begin), int64(end))
+	}
+	if len(keys) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && !isCompressed(err) {
+			return nil, errors.Trace(err)
+		}
+	}
+	results := make([][]Scored, len(keys))
+	for i, command := range commands {
+		if err := command.Err(); isCompressed(err) {
+			scores, err := r.getCompressed(ctx, r.client, keys[i])
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			results[i] = sliceScores(scores, begin, end)
+			continue
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		results[i] = make([]Scored, 0, len(command.Val()))
+		for _, member := range command.Val() {
+			results[i] = append(results[i], Scored{Id: member.Member.(string), Score: member.Score})
+		}
+	}
+	return results, nil
+}
+
+// BatchSetSorted sets scores of multiple sorted sets in a pipeline.
+func (r *Redis) BatchSetSorted(ctx context.Context, sortedSets ...SortedSet) error {
+	if len(sortedSets) == 0 {
+		return nil
+	}
+	pipe := r.client.Pipeline()
+	for _, sorted := range sortedSets {
+		r.setSorted(ctx, pipe, sorted.name, sorted.scores)
 	}
 	_, err := pipe.Exec(ctx)
 	return errors.Trace(err)
 }
+
+// lockScript sets the owner of a lock if the lock is free, or extends the lock if the owner holds it.
+var lockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+elseif redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0`)
+
+// unlockScript deletes a lock if the owner holds it.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lock acquires a lock for the owner or extends the lock if the owner holds it already.
+func (r *Redis) Lock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	locked, err := lockScript.Run(ctx, r.client, []string{r.Key(Key(LeaderLease, name))}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return locked == 1, nil
+}
+
+// Unlock releases a lock if the owner holds it.
+func (r *Redis) Unlock(ctx context.Context, name, owner string) error {
+	return errors.Trace(unlockScript.Run(ctx, r.client, []string{r.Key(Key(LeaderLease, name))}, owner).Err())
+}
@@ -369,6 +369,71 @@ func (db *SQLDatabase) SetSorted(ctx context.Context, key string, scores []Score
 	return nil
 }
 
+func (db *SQLDatabase) BatchGetSorted(ctx context.Context, keys []string, begin, end int) ([][]Scored, error) {
+	members := make(map[string][]Scored)
+	if len(keys) > 0 {
+		rs, err := db.gormDB.WithContext(ctx).Table(db.SortedSetsTable()).
+			Select("name, member, score").
+			Where("name IN ?", keys).
+			Order("score DESC").Rows()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		defer rs.Close()
+		for rs.Next() {
+			var (
+				name   string
+				member Scored
+			)
+			if err = rs.Scan(&name, &member.Id, &member.Score); err != nil {
+				return nil, errors.Trace(err)
+			}
+			members[name] = append(members[name], member)
+		}
+	}
+	results := make([][]Scored, len(keys))
+	for i, key := range keys {
+		results[i] = sliceScores(members[key], begin, end)
+	}
+	return results, nil
+}
+
+func (db *SQLDatabase) BatchSetSorted(ctx context.Context, sortedSets ...SortedSet) error {
+	if len(sortedSets) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(sortedSets))
+	memberSets := make(map[lo.Tuple2[string, string]]struct{})
+	var rows []SQLSortedSet
+	for _, sortedSet := range sortedSets {
+		names = append(names, sortedSet.name)
+		for _, member := range sortedSet.scores {
+			if _, exist := memberSets[lo.Tuple2[string, string]{sortedSet.name, member.Id}]; !exist {
+				rows = append(rows, SQLSortedSet{
+					Name:   sortedSet.name,
+					Member: member.Id,
+					Score:  member.Score,
+				})
+				memberSets[lo.Tuple2[string, string]{sortedSet.name, member.Id}] = struct{}{}
+			}
+		}
+	}
+	return db.gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&SQLSortedSet{}, "name IN ?", names).Error; err != nil {
+			return errors.Trace(err)
+		}
+		if len(rows) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "name"}, {Name: "member"}},
+				DoUpdates: clause.AssignmentColumns([]string{"score"}),
+			}).Create(&rows).Error; err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
 func (db *SQLDatabase) RemSorted(ctx context.Context, members ...SetMember) error {
 	if len(members) == 0 {
 		return nil
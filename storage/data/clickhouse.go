@@ -0,0 +1,272 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// clickHouseReadBufferSize is the size of the buffer to read responses of ClickHouse.
+const clickHouseReadBufferSize = 1 << 20
+
+// getClickHouseFeedbackStream reads feedback from ClickHouse in the Native format through the HTTP interface. Columns of
+// each block are decoded at once, which is much faster than scanning rows one by one in TabSeparated format.
+func (d *SQLDatabase) getClickHouseFeedbackStream(ctx context.Context, batchSize int, beginTime, endTime *time.Time, feedbackTypes ...string) (chan []Feedback, chan error) {
+	feedbackChan := make(chan []Feedback, bufSize)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(feedbackChan)
+		defer close(errChan)
+		feedbacks := make([]Feedback, 0, batchSize)
+		err := d.readClickHouseFeedback(ctx, batchSize, beginTime, endTime, feedbackTypes, func(block []Feedback) {
+			for len(block) > 0 {
+				n := batchSize - len(feedbacks)
+				if n > len(block) {
+					n = len(block)
+				}
+				feedbacks = append(feedbacks, block[:n]...)
+				block = block[n:]
+				if len(feedbacks) == batchSize {
+					feedbackChan <- feedbacks
+					feedbacks = make([]Feedback, 0, batchSize)
+				}
+			}
+		})
+		if err != nil {
+			errChan <- errors.Trace(err)
+			return
+		}
+		if len(feedbacks) > 0 {
+			feedbackChan <- feedbacks
+		}
+		errChan <- nil
+	}()
+	return feedbackChan, errChan
+}
+
+// readClickHouseFeedback sends the query of feedback to ClickHouse and calls handle for each block of the result.
+// Conditions are sent as query parameters, and blocks are limited to the batch size.
+func (d *SQLDatabase) readClickHouseFeedback(ctx context.Context, batchSize int, beginTime, endTime *time.Time, feedbackTypes []string, handle func([]Feedback)) error {
+	db := d
+	if d.replica != nil {
+		db = d.replica
+	}
+	endpoint := *db.clickhouseURL
+	params := url.Values{}
+	if database := strings.Trim(endpoint.Path, "/"); database != "" {
+		params.Set("database", database)
+	}
+	params.Set("max_block_size", strconv.Itoa(batchSize))
+	// build query
+	var conditions []string
+	if len(feedbackTypes) > 0 {
+		conditions = append(conditions, "has({feedback_types:Array(String)}, feedback_type)")
+		params.Set("param_feedback_types", clickHouseStringArray(feedbackTypes))
+	}
+	if beginTime != nil {
+		conditions = append(conditions, "time_stamp >= toDateTime({begin_time:Int64})")
+		params.Set("param_begin_time", strconv.FormatInt(beginTime.Unix(), 10))
+	}
+	if endTime != nil {
+		conditions = append(conditions, "time_stamp <= toDateTime({end_time:Int64})")
+		params.Set("param_end_time", strconv.FormatInt(endTime.Unix(), 10))
+	}
+	query := fmt.Sprintf("SELECT feedback_type, user_id, item_id, time_stamp, comment FROM %s", d.FeedbackTable())
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " FORMAT Native"
+	// send query
+	endpoint.Path = "/"
+	endpoint.RawQuery = params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(query))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("clickhouse: %s %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	// decode blocks
+	reader := &nativeReader{reader: bufio.NewReaderSize(resp.Body, clickHouseReadBufferSize)}
+	for {
+		block, err := reader.readBlock()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+		feedbacks, err := block.feedback()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(feedbacks) > 0 {
+			handle(feedbacks)
+		}
+	}
+}
+
+// clickHouseStringArray formats strings as a value of Array(String) in query parameters.
+func clickHouseStringArray(values []string) string {
+	var builder strings.Builder
+	builder.WriteString("[")
+	for i, value := range values {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		builder.WriteString("'")
+		builder.WriteString(strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value))
+		builder.WriteString("'")
+	}
+	builder.WriteString("]")
+	return builder.String()
+}
+
+// nativeBlock is a block of columns in the Native format of ClickHouse.
+type nativeBlock struct {
+	numRows int
+	columns map[string]interface{}
+}
+
+// feedback converts the block to feedback.
+func (b *nativeBlock) feedback() ([]Feedback, error) {
+	feedbackTypes, okFeedbackType := b.columns["feedback_type"].([]string)
+	userIds, okUserId := b.columns["user_id"].([]string)
+	itemIds, okItemId := b.columns["item_id"].([]string)
+	timestamps, okTimestamp := b.columns["time_stamp"].([]time.Time)
+	comments, okComment := b.columns["comment"].([]string)
+	if !okFeedbackType || !okUserId || !okItemId || !okTimestamp || !okComment {
+		return nil, errors.NotValidf("columns of feedback")
+	}
+	feedbacks := make([]Feedback, b.numRows)
+	for i := range feedbacks {
+		feedbacks[i] = Feedback{
+			FeedbackKey: FeedbackKey{FeedbackType: feedbackTypes[i], UserId: userIds[i], ItemId: itemIds[i]},
+			Timestamp:   timestamps[i],
+			Comment:     comments[i],
+		}
+	}
+	return feedbacks, nil
+}
+
+// nativeReader decodes blocks in the Native format of ClickHouse. Only types of feedback columns are supported.
+type nativeReader struct {
+	reader *bufio.Reader
+}
+
+// readBlock reads a block. io.EOF is returned if there are no more blocks.
+func (r *nativeReader) readBlock() (*nativeBlock, error) {
+	numColumns, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	numRows, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	block := &nativeBlock{numRows: int(numRows), columns: make(map[string]interface{}, numColumns)}
+	for i := uint64(0); i < numColumns; i++ {
+		name, err := r.readString()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		columnType, err := r.readString()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if block.columns[name], err = r.readColumn(columnType, block.numRows); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return block, nil
+}
+
+// readColumn reads values of a column. Null values are decoded as the zero values of the nested type.
+func (r *nativeReader) readColumn(columnType string, numRows int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(columnType, "Nullable(") && strings.HasSuffix(columnType, ")"):
+		if _, err := r.reader.Discard(numRows); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return r.readColumn(columnType[len("Nullable("):len(columnType)-1], numRows)
+	case columnType == "String":
+		values := make([]string, numRows)
+		for i := range values {
+			value, err := r.readString()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			values[i] = value
+		}
+		return values, nil
+	case columnType == "DateTime" || strings.HasPrefix(columnType, "DateTime("):
+		buf := make([]byte, 4*numRows)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return nil, errors.Trace(err)
+		}
+		values := make([]time.Time, numRows)
+		for i := range values {
+			values[i] = time.Unix(int64(binary.LittleEndian.Uint32(buf[4*i:])), 0).In(time.UTC)
+		}
+		return values, nil
+	case strings.HasPrefix(columnType, "DateTime64("):
+		precision, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(strings.TrimSuffix(columnType[len("DateTime64("):], ")"), ",", 2)[0]))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		scale := int64(math.Pow10(precision))
+		buf := make([]byte, 8*numRows)
+		if _, err = io.ReadFull(r.reader, buf); err != nil {
+			return nil, errors.Trace(err)
+		}
+		values := make([]time.Time, numRows)
+		for i := range values {
+			ticks := int64(binary.LittleEndian.Uint64(buf[8*i:]))
+			values[i] = time.Unix(ticks/scale, ticks%scale*(int64(time.Second)/scale)).In(time.UTC)
+		}
+		return values, nil
+	}
+	return nil, errors.NotSupportedf("column type %s", columnType)
+}
+
+// readString reads a string prefixed by its length.
+func (r *nativeReader) readString() (string, error) {
+	length, err := binary.ReadUvarint(r.reader)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(r.reader, buf); err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(buf), nil
+}
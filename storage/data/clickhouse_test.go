@@ -0,0 +1,111 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/storage"
+)
+
+// nativeWriter encodes blocks in the Native format of ClickHouse for tests.
+type nativeWriter struct {
+	bytes.Buffer
+}
+
+func (w *nativeWriter) writeUvarint(value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutUvarint(buf, value)])
+}
+
+func (w *nativeWriter) writeString(value string) {
+	w.writeUvarint(uint64(len(value)))
+	w.WriteString(value)
+}
+
+func (w *nativeWriter) writeFeedback(feedback []Feedback) {
+	w.writeUvarint(5)
+	w.writeUvarint(uint64(len(feedback)))
+	for _, column := range []string{"feedback_type", "user_id", "item_id"} {
+		w.writeString(column)
+		w.writeString("String")
+		for _, f := range feedback {
+			w.writeString(map[string]string{"feedback_type": f.FeedbackType, "user_id": f.UserId, "item_id": f.ItemId}[column])
+		}
+	}
+	w.writeString("time_stamp")
+	w.writeString("DateTime")
+	for _, f := range feedback {
+		_ = binary.Write(w, binary.LittleEndian, uint32(f.Timestamp.Unix()))
+	}
+	w.writeString("comment")
+	w.writeString("Nullable(String)")
+	w.Write(make([]byte, len(feedback)))
+	for _, f := range feedback {
+		w.writeString(f.Comment)
+	}
+}
+
+func TestClickHouseFeedbackStream(t *testing.T) {
+	timestamp := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var query string
+	var params url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query, params = string(body), r.URL.Query()
+		var writer nativeWriter
+		writer.writeFeedback([]Feedback{
+			{FeedbackKey{"click", "0", "0"}, timestamp, "a"},
+			{FeedbackKey{"click", "0", "1"}, timestamp, "b"},
+		})
+		writer.writeFeedback([]Feedback{
+			{FeedbackKey{"click", "1", "0"}, timestamp, "c"},
+		})
+		_, _ = w.Write(writer.Bytes())
+	}))
+	defer server.Close()
+	endpoint, err := url.Parse(server.URL + "/gorse")
+	assert.NoError(t, err)
+	database := &SQLDatabase{TablePrefix: storage.TablePrefix("gorse_"), driver: ClickHouse, clickhouseURL: endpoint}
+
+	// read feedback by batches
+	feedbackChan, errChan := database.GetFeedbackStream(context.Background(), 2, &timestamp, &timestamp, "click", "it's")
+	var batches [][]Feedback
+	for feedback := range feedbackChan {
+		batches = append(batches, feedback)
+	}
+	assert.NoError(t, <-errChan)
+	assert.Equal(t, [][]Feedback{
+		{{FeedbackKey{"click", "0", "0"}, timestamp, "a"}, {FeedbackKey{"click", "0", "1"}, timestamp, "b"}},
+		{{FeedbackKey{"click", "1", "0"}, timestamp, "c"}},
+	}, batches)
+	assert.Equal(t, "SELECT feedback_type, user_id, item_id, time_stamp, comment FROM gorse_feedback "+
+		"WHERE has({feedback_types:Array(String)}, feedback_type) AND time_stamp >= toDateTime({begin_time:Int64}) "+
+		"AND time_stamp <= toDateTime({end_time:Int64}) FORMAT Native", query)
+	assert.Equal(t, "gorse", params.Get("database"))
+	assert.Equal(t, "2", params.Get("max_block_size"))
+	assert.Equal(t, `['click','it\'s']`, params.Get("param_feedback_types"))
+	assert.Equal(t, "1672531200", params.Get("param_begin_time"))
+	assert.Equal(t, "1672531200", params.Get("param_end_time"))
+}
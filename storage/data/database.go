@@ -46,6 +46,8 @@ var (
 	ErrUserNotExist = errors.NotFoundf("user")
 	ErrItemNotExist = errors.NotFoundf("item")
 	ErrNoDatabase   = errors.NotAssignedf("database")
+	// ErrPreconditionFailed is returned if the condition of a conditional modification is not satisfied.
+	ErrPreconditionFailed = errors.New("precondition failed")
 )
 
 // Item stores meta data about item.
@@ -56,6 +58,37 @@ type Item struct {
 	Timestamp  time.Time
 	Labels     []string `gorm:"serializer:json"`
 	Comment    string
+	Latitude   *float64
+	Longitude  *float64
+}
+
+// HasLocation returns true if the item has a location.
+func (item *Item) HasLocation() bool {
+	return item.Latitude != nil && item.Longitude != nil
+}
+
+// ItemHistory is a previous version of an item, which is replaced at ModifyTime.
+type ItemHistory struct {
+	ItemId     string
+	IsHidden   bool
+	Categories []string
+	Timestamp  time.Time
+	Labels     []string
+	Comment    string
+	ModifyTime time.Time
+}
+
+// NewItemHistory creates the history of an item replaced at modifyTime.
+func NewItemHistory(item Item, modifyTime time.Time) ItemHistory {
+	return ItemHistory{
+		ItemId:     item.ItemId,
+		IsHidden:   item.IsHidden,
+		Categories: item.Categories,
+		Timestamp:  item.Timestamp,
+		Labels:     item.Labels,
+		Comment:    item.Comment,
+		ModifyTime: modifyTime,
+	}
 }
 
 // ItemPatch is the modification on an item.
@@ -65,6 +98,8 @@ type ItemPatch struct {
 	Timestamp  *time.Time
 	Labels     []string
 	Comment    *string
+	Latitude   *float64
+	Longitude  *float64
 }
 
 // User stores meta data about user.
@@ -96,6 +131,16 @@ type Feedback struct {
 	Comment     string    `gorm:"column:comment"`
 }
 
+// AuditLog records a write operation.
+type AuditLog struct {
+	Timestamp time.Time `gorm:"column:time_stamp"`
+	Actor     string    `gorm:"column:actor"`  // name of the API key
+	Method    string    `gorm:"column:method"` // HTTP method
+	Path      string    `gorm:"column:path"`   // URL path
+	Status    int       `gorm:"column:status"` // HTTP status code
+	Digest    string    `gorm:"column:digest"` // SHA-256 digest of the payload
+}
+
 // SortFeedbacks sorts feedback from latest to oldest.
 func SortFeedbacks(feedback []Feedback) {
 	sort.Sort(feedbackSorter(feedback))
@@ -126,21 +171,33 @@ type Database interface {
 	DeleteItem(ctx context.Context, itemId string) error
 	GetItem(ctx context.Context, itemId string) (Item, error)
 	ModifyItem(ctx context.Context, itemId string, patch ItemPatch) error
+	// ModifyItemIf modifies an item only if the stored item satisfies the condition. The check and the modification
+	// are atomic, ErrPreconditionFailed is returned if the condition is not satisfied.
+	ModifyItemIf(ctx context.Context, itemId string, patch ItemPatch, condition func(Item) bool) error
 	GetItems(ctx context.Context, cursor string, n int, beginTime *time.Time) (string, []Item, error)
 	GetItemFeedback(ctx context.Context, itemId string, feedbackTypes ...string) ([]Feedback, error)
 	BatchInsertUsers(ctx context.Context, users []User) error
 	DeleteUser(ctx context.Context, userId string) error
 	GetUser(ctx context.Context, userId string) (User, error)
 	ModifyUser(ctx context.Context, userId string, patch UserPatch) error
+	// ModifyUserIf modifies a user only if the stored user satisfies the condition. The check and the modification
+	// are atomic, ErrPreconditionFailed is returned if the condition is not satisfied.
+	ModifyUserIf(ctx context.Context, userId string, patch UserPatch, condition func(User) bool) error
 	GetUsers(ctx context.Context, cursor string, n int) (string, []User, error)
 	GetUserFeedback(ctx context.Context, userId string, endTime *time.Time, feedbackTypes ...string) ([]Feedback, error)
 	GetUserItemFeedback(ctx context.Context, userId, itemId string, feedbackTypes ...string) ([]Feedback, error)
 	DeleteUserItemFeedback(ctx context.Context, userId, itemId string, feedbackTypes ...string) (int, error)
 	BatchInsertFeedback(ctx context.Context, feedback []Feedback, insertUser, insertItem, overwrite bool) error
-	GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, feedbackTypes ...string) (string, []Feedback, error)
+	// GetFeedback returns a page of feedback. Feedback is filtered by the time range, the user and the item if they are
+	// not empty.
+	GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, userId, itemId string, feedbackTypes ...string) (string, []Feedback, error)
 	GetUserStream(ctx context.Context, batchSize int) (chan []User, chan error)
 	GetItemStream(ctx context.Context, batchSize int, timeLimit *time.Time) (chan []Item, chan error)
 	GetFeedbackStream(ctx context.Context, batchSize int, beginTime, endTime *time.Time, feedbackTypes ...string) (chan []Feedback, chan error)
+	InsertItemHistory(ctx context.Context, history []ItemHistory) error
+	GetItemHistory(ctx context.Context, itemId string, n int) ([]ItemHistory, error)
+	InsertAuditLogs(ctx context.Context, logs []AuditLog) error
+	GetAuditLogs(ctx context.Context, n int, beginTime, endTime *time.Time) ([]AuditLog, error)
 }
 
 // Open a connection to a database.
@@ -175,6 +232,9 @@ func Open(path, tablePrefix string) (Database, error) {
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if err = database.registerCallbacks(); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return database, nil
 	} else if strings.HasPrefix(path, storage.PostgresPrefix) || strings.HasPrefix(path, storage.PostgreSQLPrefix) {
 		database := new(SQLDatabase)
@@ -190,6 +250,9 @@ func Open(path, tablePrefix string) (Database, error) {
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if err = database.registerCallbacks(); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return database, nil
 	} else if strings.HasPrefix(path, storage.ClickhousePrefix) || strings.HasPrefix(path, storage.CHHTTPPrefix) || strings.HasPrefix(path, storage.CHHTTPSPrefix) {
 		// replace schema
@@ -206,6 +269,7 @@ func Open(path, tablePrefix string) (Database, error) {
 		database := new(SQLDatabase)
 		database.driver = ClickHouse
 		database.TablePrefix = storage.TablePrefix(tablePrefix)
+		database.clickhouseURL = parsed
 		if database.client, err = otelsql.Open("chhttp", uri,
 			otelsql.WithAttributes(semconv.DBSystemKey.String("clickhouse")),
 			otelsql.WithSpanOptions(otelsql.SpanOptions{DisableErrSkip: true}),
@@ -216,6 +280,9 @@ func Open(path, tablePrefix string) (Database, error) {
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if err = database.registerCallbacks(); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return database, nil
 	} else if strings.HasPrefix(path, storage.MongoPrefix) || strings.HasPrefix(path, storage.MongoSrvPrefix) {
 		// connect to database
@@ -265,6 +332,9 @@ func Open(path, tablePrefix string) (Database, error) {
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if err = database.registerCallbacks(); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return database, nil
 	} else if strings.HasPrefix(path, storage.RedisPrefix) {
 		addr := path[len(storage.RedisPrefix):]
@@ -289,7 +359,38 @@ func Open(path, tablePrefix string) (Database, error) {
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+		if err = database.registerCallbacks(); err != nil {
+			return nil, errors.Trace(err)
+		}
 		return database, nil
 	}
 	return nil, errors.Errorf("Unknown database: %s", path)
 }
+
+// OpenWithReplica opens a connection to a database and a connection to its read replica. Scans of items, users and
+// feedback are sent to the replica while others are sent to the primary. Read replicas are supported by SQL databases
+// only, and no replica is used if the replica path is empty.
+func OpenWithReplica(path, replicaPath, tablePrefix string) (Database, error) {
+	database, err := Open(path, tablePrefix)
+	if err != nil || replicaPath == "" {
+		return database, err
+	}
+	primary, ok := database.(*SQLDatabase)
+	if !ok {
+		_ = database.Close()
+		return nil, errors.NotSupportedf("read replica of %s", log.RedactDBURL(path))
+	}
+	replica, err := Open(replicaPath, tablePrefix)
+	if err != nil {
+		_ = database.Close()
+		return nil, errors.Trace(err)
+	}
+	sqlReplica, ok := replica.(*SQLDatabase)
+	if !ok || sqlReplica.driver != primary.driver {
+		_ = database.Close()
+		_ = replica.Close()
+		return nil, errors.NotValidf("read replica %s of %s", log.RedactDBURL(replicaPath), log.RedactDBURL(path))
+	}
+	primary.replica = sqlReplica
+	return primary, nil
+}
@@ -110,7 +110,7 @@ func (suite *baseTestSuite) getFeedback(ctx context.Context, batchSize int, begi
 	var data []Feedback
 	cursor := ""
 	for {
-		cursor, data, err = suite.Database.GetFeedback(ctx, cursor, batchSize, beginTime, endTime, feedbackTypes...)
+		cursor, data, err = suite.Database.GetFeedback(ctx, cursor, batchSize, beginTime, endTime, "", "", feedbackTypes...)
 		suite.NoError(err)
 		feedback = append(feedback, data...)
 		if cursor == "" {
@@ -410,6 +410,8 @@ func (suite *baseTestSuite) TestItem() {
 			Timestamp:  time.Date(1996, 3, 15, 0, 0, 0, 0, time.UTC),
 			Labels:     []string{"a"},
 			Comment:    "comment 2",
+			Latitude:   proto.Float64(31.23),
+			Longitude:  proto.Float64(121.47),
 		},
 		{
 			ItemId:     "4",
@@ -484,6 +486,8 @@ func (suite *baseTestSuite) TestItem() {
 	suite.NoError(err)
 	err = suite.Database.ModifyItem(ctx, "2", ItemPatch{Timestamp: &timestamp})
 	suite.NoError(err)
+	err = suite.Database.ModifyItem(ctx, "2", ItemPatch{Latitude: proto.Float64(39.9), Longitude: proto.Float64(116.4)})
+	suite.NoError(err)
 	err = suite.Database.Optimize()
 	suite.NoError(err)
 	item, err = suite.Database.GetItem(ctx, "2")
@@ -493,6 +497,8 @@ func (suite *baseTestSuite) TestItem() {
 	suite.Equal("modify", item.Comment)
 	suite.Equal([]string{"a", "b", "c"}, item.Labels)
 	suite.Equal(timestamp, item.Timestamp)
+	suite.Equal(proto.Float64(39.9), item.Latitude)
+	suite.Equal(proto.Float64(116.4), item.Longitude)
 
 	// test insert empty
 	err = suite.Database.BatchInsertItems(ctx, nil)
@@ -527,7 +533,7 @@ func (suite *baseTestSuite) TestDeleteUser() {
 	ret, err := suite.Database.GetUserFeedback(ctx, "a", lo.ToPtr(time.Now()), positiveFeedbackType)
 	suite.NoError(err)
 	suite.Equal(0, len(ret))
-	_, ret, err = suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()), positiveFeedbackType)
+	_, ret, err = suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()), "", "", positiveFeedbackType)
 	suite.NoError(err)
 	suite.Empty(ret)
 }
@@ -552,7 +558,7 @@ func (suite *baseTestSuite) TestDeleteItem() {
 	ret, err := suite.Database.GetItemFeedback(ctx, "b", positiveFeedbackType)
 	suite.NoError(err)
 	suite.Equal(0, len(ret))
-	_, ret, err = suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()), positiveFeedbackType)
+	_, ret, err = suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()), "", "", positiveFeedbackType)
 	suite.NoError(err)
 	suite.Empty(ret)
 }
@@ -650,13 +656,22 @@ func (suite *baseTestSuite) TestTimeLimit() {
 	}
 	err = suite.Database.BatchInsertFeedback(ctx, feedbacks, true, true, true)
 	suite.NoError(err)
-	_, retFeedback, err := suite.Database.GetFeedback(ctx, "", 100, &timeLimit, lo.ToPtr(time.Now()))
+	_, retFeedback, err := suite.Database.GetFeedback(ctx, "", 100, &timeLimit, lo.ToPtr(time.Now()), "", "")
 	suite.NoError(err)
 	suite.Equal([]Feedback{feedbacks[4], feedbacks[3], feedbacks[2]}, retFeedback)
 	typeFilter := "type1"
-	_, retFeedback, err = suite.Database.GetFeedback(ctx, "", 100, &timeLimit, lo.ToPtr(time.Now()), typeFilter)
+	_, retFeedback, err = suite.Database.GetFeedback(ctx, "", 100, &timeLimit, lo.ToPtr(time.Now()), "", "", typeFilter)
 	suite.NoError(err)
 	suite.Equal([]Feedback{feedbacks[4], feedbacks[3]}, retFeedback)
+	_, retFeedback, err = suite.Database.GetFeedback(ctx, "", 100, &timeLimit, lo.ToPtr(time.Now()), "2", "")
+	suite.NoError(err)
+	suite.Equal([]Feedback{feedbacks[3], feedbacks[2]}, retFeedback)
+	_, retFeedback, err = suite.Database.GetFeedback(ctx, "", 100, &timeLimit, lo.ToPtr(time.Now()), "", "3")
+	suite.NoError(err)
+	suite.Equal([]Feedback{feedbacks[4], feedbacks[2]}, retFeedback)
+	_, retFeedback, err = suite.Database.GetFeedback(ctx, "", 100, nil, nil, "2", "3", typeFilter)
+	suite.NoError(err)
+	suite.Equal([]Feedback{feedbacks[0]}, retFeedback)
 }
 
 func (suite *baseTestSuite) TestTimezone() {
@@ -677,7 +692,7 @@ func (suite *baseTestSuite) TestTimezone() {
 	feedback := suite.getFeedback(ctx, 10, nil, lo.ToPtr(time.Now()))
 	suite.Equal(3, len(feedback))
 	// get feedback
-	_, feedback, err = suite.Database.GetFeedback(ctx, "", 10, nil, lo.ToPtr(time.Now()))
+	_, feedback, err = suite.Database.GetFeedback(ctx, "", 10, nil, lo.ToPtr(time.Now()), "", "")
 	suite.NoError(err)
 	suite.Equal(3, len(feedback))
 	// get user feedback
@@ -757,7 +772,7 @@ func (suite *baseTestSuite) TestPurge() {
 	_, items, err := suite.Database.GetItems(ctx, "", 100, nil)
 	suite.NoError(err)
 	suite.Equal(100, len(items))
-	_, feedbacks, err := suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()))
+	_, feedbacks, err := suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()), "", "")
 	suite.NoError(err)
 	suite.Equal(100, len(feedbacks))
 	// purge data
@@ -769,7 +784,7 @@ func (suite *baseTestSuite) TestPurge() {
 	_, items, err = suite.Database.GetItems(ctx, "", 100, nil)
 	suite.NoError(err)
 	suite.Empty(items)
-	_, feedbacks, err = suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()))
+	_, feedbacks, err = suite.Database.GetFeedback(ctx, "", 100, nil, lo.ToPtr(time.Now()), "", "")
 	suite.NoError(err)
 	suite.Empty(feedbacks)
 	// purge empty database
@@ -777,6 +792,115 @@ func (suite *baseTestSuite) TestPurge() {
 	suite.NoError(err)
 }
 
+func (suite *baseTestSuite) TestItemHistory() {
+	ctx := context.Background()
+	// insert item history
+	history := lo.Map(lo.Range(5), func(i int, _ int) ItemHistory {
+		return NewItemHistory(Item{
+			ItemId:     lo.If(i < 4, "0").Else("1"),
+			IsHidden:   i%2 == 0,
+			Categories: []string{strconv.Itoa(i)},
+			Timestamp:  time.Date(1996, 3, 15, 0, 0, 0, 0, time.UTC),
+			Labels:     []string{"a", strconv.Itoa(i)},
+			Comment:    "comment " + strconv.Itoa(i),
+		}, time.Date(2000, 1, 1, 0, 0, i, 0, time.UTC))
+	})
+	err := suite.Database.InsertItemHistory(ctx, history)
+	suite.NoError(err)
+	err = suite.Database.InsertItemHistory(ctx, nil)
+	suite.NoError(err)
+	inUTC := func(history []ItemHistory) []ItemHistory {
+		return lo.Map(history, func(h ItemHistory, _ int) ItemHistory {
+			h.Timestamp = h.Timestamp.In(time.UTC)
+			h.ModifyTime = h.ModifyTime.In(time.UTC)
+			return h
+		})
+	}
+	// get latest history
+	ret, err := suite.Database.GetItemHistory(ctx, "0", 3)
+	suite.NoError(err)
+	suite.Equal([]ItemHistory{history[3], history[2], history[1]}, inUTC(ret))
+	ret, err = suite.Database.GetItemHistory(ctx, "1", 10)
+	suite.NoError(err)
+	suite.Equal([]ItemHistory{history[4]}, inUTC(ret))
+	ret, err = suite.Database.GetItemHistory(ctx, "2", 10)
+	suite.NoError(err)
+	suite.Empty(ret)
+}
+
+func (suite *baseTestSuite) TestModifyIf() {
+	ctx := context.Background()
+	err := suite.Database.BatchInsertUsers(ctx, []User{{UserId: "1", Comment: "a"}})
+	suite.NoError(err)
+	err = suite.Database.BatchInsertItems(ctx, []Item{{ItemId: "1", Comment: "a", Timestamp: time.Date(1996, 4, 8, 10, 0, 0, 0, time.UTC)}})
+	suite.NoError(err)
+
+	// modify user if condition is satisfied
+	err = suite.Database.ModifyUserIf(ctx, "1", UserPatch{Comment: proto.String("b")}, func(user User) bool { return user.Comment == "a" })
+	suite.NoError(err)
+	err = suite.Database.Optimize()
+	suite.NoError(err)
+	err = suite.Database.ModifyUserIf(ctx, "1", UserPatch{Comment: proto.String("c")}, func(user User) bool { return user.Comment == "a" })
+	suite.ErrorIs(err, ErrPreconditionFailed)
+	err = suite.Database.ModifyUserIf(ctx, "2", UserPatch{Comment: proto.String("c")}, func(user User) bool { return true })
+	suite.ErrorIs(err, ErrUserNotExist)
+	user, err := suite.Database.GetUser(ctx, "1")
+	suite.NoError(err)
+	suite.Equal("b", user.Comment)
+
+	// modify item if condition is satisfied
+	err = suite.Database.ModifyItemIf(ctx, "1", ItemPatch{Comment: proto.String("b")}, func(item Item) bool { return item.Comment == "a" })
+	suite.NoError(err)
+	err = suite.Database.Optimize()
+	suite.NoError(err)
+	err = suite.Database.ModifyItemIf(ctx, "1", ItemPatch{Comment: proto.String("c")}, func(item Item) bool { return item.Comment == "a" })
+	suite.ErrorIs(err, ErrPreconditionFailed)
+	err = suite.Database.ModifyItemIf(ctx, "2", ItemPatch{Comment: proto.String("c")}, func(item Item) bool { return true })
+	suite.ErrorIs(err, ErrItemNotExist)
+	item, err := suite.Database.GetItem(ctx, "1")
+	suite.NoError(err)
+	suite.Equal("b", item.Comment)
+}
+
+func (suite *baseTestSuite) TestAuditLogs() {
+	ctx := context.Background()
+	// insert audit logs
+	logs := lo.Map(lo.Range(5), func(i int, _ int) AuditLog {
+		return AuditLog{
+			Timestamp: time.Date(2000, 1, 1, 0, 0, i, 0, time.UTC),
+			Actor:     "analytics",
+			Method:    "POST",
+			Path:      "/api/user/" + strconv.Itoa(i),
+			Status:    200,
+			Digest:    strconv.Itoa(i),
+		}
+	})
+	err := suite.Database.InsertAuditLogs(ctx, logs)
+	suite.NoError(err)
+	err = suite.Database.InsertAuditLogs(ctx, nil)
+	suite.NoError(err)
+	inUTC := func(logs []AuditLog) []AuditLog {
+		return lo.Map(logs, func(l AuditLog, _ int) AuditLog {
+			l.Timestamp = l.Timestamp.In(time.UTC)
+			return l
+		})
+	}
+	// get latest audit logs
+	ret, err := suite.Database.GetAuditLogs(ctx, 3, nil, nil)
+	suite.NoError(err)
+	suite.Equal([]AuditLog{logs[4], logs[3], logs[2]}, inUTC(ret))
+	// get audit logs in time range
+	ret, err = suite.Database.GetAuditLogs(ctx, 10, &logs[1].Timestamp, &logs[3].Timestamp)
+	suite.NoError(err)
+	suite.Equal([]AuditLog{logs[3], logs[2], logs[1]}, inUTC(ret))
+	// purge audit logs
+	err = suite.Database.Purge()
+	suite.NoError(err)
+	ret, err = suite.Database.GetAuditLogs(ctx, 10, nil, nil)
+	suite.NoError(err)
+	suite.Empty(ret)
+}
+
 func TestSortFeedbacks(t *testing.T) {
 	feedback := []Feedback{
 		{FeedbackKey: FeedbackKey{"star", "1", "1"}, Timestamp: time.Date(2000, 10, 1, 0, 0, 0, 0, time.UTC)},
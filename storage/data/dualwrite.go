@@ -0,0 +1,428 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhenghaoz/gorse/base/log"
+	"go.uber.org/zap"
+)
+
+var DualWriteErrorsVec = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gorse",
+	Subsystem: "data_store",
+	Name:      "dual_write_errors_total",
+}, []string{LabelOperation})
+
+// WithDualWrite wraps a data store to write to both the data store and the target data store while reads are sent to
+// the data store only. It is used to move to another data store without downtime: historical data are copied to the
+// target by CopyData in background, and writes during the copy are sent to both. Failed writes to the target are
+// logged instead of failing requests, since differences are repaired after the copy is verified.
+func WithDualWrite(database, target Database) Database {
+	return &dualWriteDatabase{Database: database, target: target}
+}
+
+// dualWriteDatabase writes to the data store and the target data store.
+type dualWriteDatabase struct {
+	Database
+	target Database
+}
+
+// shadow records a failed write to the target data store.
+func (d *dualWriteDatabase) shadow(operation string, err error) {
+	if err != nil {
+		DualWriteErrorsVec.WithLabelValues(operation).Inc()
+		log.Logger().Warn("failed to write to target data store", zap.String("operation", operation), zap.Error(err))
+	}
+}
+
+func (d *dualWriteDatabase) Init() error {
+	if err := d.Database.Init(); err != nil {
+		return err
+	}
+	return d.target.Init()
+}
+
+func (d *dualWriteDatabase) Close() error {
+	if err := d.Database.Close(); err != nil {
+		return err
+	}
+	return d.target.Close()
+}
+
+func (d *dualWriteDatabase) Optimize() error {
+	if err := d.Database.Optimize(); err != nil {
+		return err
+	}
+	return d.target.Optimize()
+}
+
+func (d *dualWriteDatabase) Purge() error {
+	if err := d.Database.Purge(); err != nil {
+		return err
+	}
+	return d.target.Purge()
+}
+
+func (d *dualWriteDatabase) BatchInsertItems(ctx context.Context, items []Item) error {
+	if err := d.Database.BatchInsertItems(ctx, items); err != nil {
+		return err
+	}
+	d.shadow("batch_insert_items", d.target.BatchInsertItems(ctx, items))
+	return nil
+}
+
+func (d *dualWriteDatabase) DeleteItem(ctx context.Context, itemId string) error {
+	if err := d.Database.DeleteItem(ctx, itemId); err != nil {
+		return err
+	}
+	d.shadow("delete_item", d.target.DeleteItem(ctx, itemId))
+	return nil
+}
+
+func (d *dualWriteDatabase) ModifyItem(ctx context.Context, itemId string, patch ItemPatch) error {
+	if err := d.Database.ModifyItem(ctx, itemId, patch); err != nil {
+		return err
+	}
+	d.shadow("modify_item", d.target.ModifyItem(ctx, itemId, patch))
+	return nil
+}
+
+// ModifyItemIf checks the condition on the data store only, and the target is modified unconditionally if the
+// condition is satisfied.
+func (d *dualWriteDatabase) ModifyItemIf(ctx context.Context, itemId string, patch ItemPatch, condition func(Item) bool) error {
+	if err := d.Database.ModifyItemIf(ctx, itemId, patch, condition); err != nil {
+		return err
+	}
+	d.shadow("modify_item", d.target.ModifyItem(ctx, itemId, patch))
+	return nil
+}
+
+func (d *dualWriteDatabase) BatchInsertUsers(ctx context.Context, users []User) error {
+	if err := d.Database.BatchInsertUsers(ctx, users); err != nil {
+		return err
+	}
+	d.shadow("batch_insert_users", d.target.BatchInsertUsers(ctx, users))
+	return nil
+}
+
+func (d *dualWriteDatabase) DeleteUser(ctx context.Context, userId string) error {
+	if err := d.Database.DeleteUser(ctx, userId); err != nil {
+		return err
+	}
+	d.shadow("delete_user", d.target.DeleteUser(ctx, userId))
+	return nil
+}
+
+func (d *dualWriteDatabase) ModifyUser(ctx context.Context, userId string, patch UserPatch) error {
+	if err := d.Database.ModifyUser(ctx, userId, patch); err != nil {
+		return err
+	}
+	d.shadow("modify_user", d.target.ModifyUser(ctx, userId, patch))
+	return nil
+}
+
+// ModifyUserIf checks the condition on the data store only, and the target is modified unconditionally if the
+// condition is satisfied.
+func (d *dualWriteDatabase) ModifyUserIf(ctx context.Context, userId string, patch UserPatch, condition func(User) bool) error {
+	if err := d.Database.ModifyUserIf(ctx, userId, patch, condition); err != nil {
+		return err
+	}
+	d.shadow("modify_user", d.target.ModifyUser(ctx, userId, patch))
+	return nil
+}
+
+func (d *dualWriteDatabase) DeleteUserItemFeedback(ctx context.Context, userId, itemId string, feedbackTypes ...string) (int, error) {
+	n, err := d.Database.DeleteUserItemFeedback(ctx, userId, itemId, feedbackTypes...)
+	if err != nil {
+		return n, err
+	}
+	_, err = d.target.DeleteUserItemFeedback(ctx, userId, itemId, feedbackTypes...)
+	d.shadow("delete_user_item_feedback", err)
+	return n, nil
+}
+
+func (d *dualWriteDatabase) BatchInsertFeedback(ctx context.Context, feedback []Feedback, insertUser, insertItem, overwrite bool) error {
+	if err := d.Database.BatchInsertFeedback(ctx, feedback, insertUser, insertItem, overwrite); err != nil {
+		return err
+	}
+	d.shadow("batch_insert_feedback", d.target.BatchInsertFeedback(ctx, feedback, insertUser, insertItem, overwrite))
+	return nil
+}
+
+func (d *dualWriteDatabase) InsertItemHistory(ctx context.Context, history []ItemHistory) error {
+	if err := d.Database.InsertItemHistory(ctx, history); err != nil {
+		return err
+	}
+	d.shadow("insert_item_history", d.target.InsertItemHistory(ctx, history))
+	return nil
+}
+
+func (d *dualWriteDatabase) InsertAuditLogs(ctx context.Context, logs []AuditLog) error {
+	if err := d.Database.InsertAuditLogs(ctx, logs); err != nil {
+		return err
+	}
+	d.shadow("insert_audit_logs", d.target.InsertAuditLogs(ctx, logs))
+	return nil
+}
+
+// PartitionFeedback partitions feedback in the data store only, since the target might not support partitions.
+func (d *dualWriteDatabase) PartitionFeedback(ctx context.Context, interval time.Duration, until time.Time) error {
+	partitioner, ok := d.Database.(FeedbackPartitioner)
+	if !ok {
+		return errors.NotSupportedf("partitions of feedback")
+	}
+	return partitioner.PartitionFeedback(ctx, interval, until)
+}
+
+// PruneFeedback prunes feedback in the data store only.
+func (d *dualWriteDatabase) PruneFeedback(ctx context.Context, before time.Time) (int, error) {
+	partitioner, ok := d.Database.(FeedbackPartitioner)
+	if !ok {
+		return 0, nil
+	}
+	return partitioner.PruneFeedback(ctx, before)
+}
+
+// CopyStats are numbers of users, items and feedback copied from a data store to another.
+type CopyStats struct {
+	Users    int
+	Items    int
+	Feedback int
+}
+
+// CopyData copies users, items and feedback from the source to the target in batches. Users and items in the target
+// are overwritten while existing feedback in the target are kept, since feedback written to the target during the
+// copy are newer.
+func CopyData(ctx context.Context, source, target Database, batchSize int) (CopyStats, error) {
+	var stats CopyStats
+	userChan, errChan := source.GetUserStream(ctx, batchSize)
+	for users := range userChan {
+		if err := target.BatchInsertUsers(ctx, users); err != nil {
+			return stats, errors.Trace(err)
+		}
+		stats.Users += len(users)
+	}
+	if err := <-errChan; err != nil {
+		return stats, errors.Trace(err)
+	}
+	itemChan, errChan := source.GetItemStream(ctx, batchSize, nil)
+	for items := range itemChan {
+		if err := target.BatchInsertItems(ctx, items); err != nil {
+			return stats, errors.Trace(err)
+		}
+		stats.Items += len(items)
+	}
+	if err := <-errChan; err != nil {
+		return stats, errors.Trace(err)
+	}
+	feedbackChan, errChan := source.GetFeedbackStream(ctx, batchSize, nil, nil)
+	for feedback := range feedbackChan {
+		if err := target.BatchInsertFeedback(ctx, feedback, false, false, false); err != nil {
+			return stats, errors.Trace(err)
+		}
+		stats.Feedback += len(feedback)
+	}
+	if err := <-errChan; err != nil {
+		return stats, errors.Trace(err)
+	}
+	return stats, nil
+}
+
+// DataDiff are users, items and feedback which are missing, redundant or different in the target data store.
+type DataDiff struct {
+	Users    []string
+	Items    []string
+	Feedback []FeedbackKey
+}
+
+// Len returns the number of differences.
+func (diff DataDiff) Len() int {
+	return len(diff.Users) + len(diff.Items) + len(diff.Feedback)
+}
+
+// VerifyData compares users, items and feedback in the target with the source by digests. Timestamps are compared in
+// seconds since precisions of timestamps vary in data stores.
+func VerifyData(ctx context.Context, source, target Database, batchSize int) (DataDiff, error) {
+	var diff DataDiff
+	// compare users
+	userDigests := func(database Database) (map[string]uint64, error) {
+		digests := make(map[string]uint64)
+		userChan, errChan := database.GetUserStream(ctx, batchSize)
+		for users := range userChan {
+			for _, user := range users {
+				digests[user.UserId] = digestUser(user)
+			}
+		}
+		return digests, errors.Trace(<-errChan)
+	}
+	sourceUsers, err := userDigests(source)
+	if err != nil {
+		return diff, errors.Trace(err)
+	}
+	targetUsers, err := userDigests(target)
+	if err != nil {
+		return diff, errors.Trace(err)
+	}
+	diff.Users = diffDigests(sourceUsers, targetUsers)
+	sort.Strings(diff.Users)
+	// compare items
+	itemDigests := func(database Database) (map[string]uint64, error) {
+		digests := make(map[string]uint64)
+		itemChan, errChan := database.GetItemStream(ctx, batchSize, nil)
+		for items := range itemChan {
+			for _, item := range items {
+				digests[item.ItemId] = digestItem(item)
+			}
+		}
+		return digests, errors.Trace(<-errChan)
+	}
+	sourceItems, err := itemDigests(source)
+	if err != nil {
+		return diff, errors.Trace(err)
+	}
+	targetItems, err := itemDigests(target)
+	if err != nil {
+		return diff, errors.Trace(err)
+	}
+	diff.Items = diffDigests(sourceItems, targetItems)
+	sort.Strings(diff.Items)
+	// compare feedback
+	feedbackDigests := func(database Database) (map[FeedbackKey]uint64, error) {
+		digests := make(map[FeedbackKey]uint64)
+		feedbackChan, errChan := database.GetFeedbackStream(ctx, batchSize, nil, nil)
+		for feedback := range feedbackChan {
+			for _, f := range feedback {
+				digests[f.FeedbackKey] = digestFeedback(f)
+			}
+		}
+		return digests, errors.Trace(<-errChan)
+	}
+	sourceFeedback, err := feedbackDigests(source)
+	if err != nil {
+		return diff, errors.Trace(err)
+	}
+	targetFeedback, err := feedbackDigests(target)
+	if err != nil {
+		return diff, errors.Trace(err)
+	}
+	diff.Feedback = diffDigests(sourceFeedback, targetFeedback)
+	sort.Slice(diff.Feedback, func(i, j int) bool {
+		a, b := diff.Feedback[i], diff.Feedback[j]
+		if a.FeedbackType != b.FeedbackType {
+			return a.FeedbackType < b.FeedbackType
+		} else if a.UserId != b.UserId {
+			return a.UserId < b.UserId
+		}
+		return a.ItemId < b.ItemId
+	})
+	return diff, nil
+}
+
+// RepairData copies differences from the source to the target again. Users, items and feedback which no longer exist
+// in the source are deleted from the target.
+func RepairData(ctx context.Context, source, target Database, diff DataDiff) error {
+	for _, userId := range diff.Users {
+		user, err := source.GetUser(ctx, userId)
+		if errors.Is(err, errors.NotFound) {
+			err = target.DeleteUser(ctx, userId)
+		} else if err == nil {
+			err = target.BatchInsertUsers(ctx, []User{user})
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, itemId := range diff.Items {
+		item, err := source.GetItem(ctx, itemId)
+		if errors.Is(err, errors.NotFound) {
+			err = target.DeleteItem(ctx, itemId)
+		} else if err == nil {
+			err = target.BatchInsertItems(ctx, []Item{item})
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, key := range diff.Feedback {
+		feedback, err := source.GetUserItemFeedback(ctx, key.UserId, key.ItemId, key.FeedbackType)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(feedback) == 0 {
+			_, err = target.DeleteUserItemFeedback(ctx, key.UserId, key.ItemId, key.FeedbackType)
+		} else {
+			err = target.BatchInsertFeedback(ctx, feedback, false, false, true)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// diffDigests returns keys which are missing, redundant or different in the target.
+func diffDigests[K comparable](source, target map[K]uint64) []K {
+	var keys []K
+	for key, digest := range source {
+		if targetDigest, exist := target[key]; !exist || targetDigest != digest {
+			keys = append(keys, key)
+		}
+	}
+	for key := range target {
+		if _, exist := source[key]; !exist {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func digestUser(user User) uint64 {
+	return digest(user.UserId, normalizeStrings(user.Labels), normalizeStrings(user.Subscribe), user.Comment)
+}
+
+func digestItem(item Item) uint64 {
+	return digest(item.ItemId, item.IsHidden, normalizeStrings(item.Categories), normalizeTime(item.Timestamp),
+		normalizeStrings(item.Labels), item.Comment, item.Latitude, item.Longitude)
+}
+
+func digestFeedback(feedback Feedback) uint64 {
+	return digest(feedback.FeedbackKey, normalizeTime(feedback.Timestamp), feedback.Comment)
+}
+
+func digest(fields ...interface{}) uint64 {
+	h := fnv.New64a()
+	_ = json.NewEncoder(h).Encode(fields)
+	return h.Sum64()
+}
+
+func normalizeStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func normalizeTime(t time.Time) int64 {
+	return t.Unix()
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDualWrite(t *testing.T) {
+	ctx := context.Background()
+	source, err := Open("sqlite://"+filepath.Join(t.TempDir(), "source.db"), "gorse_")
+	assert.NoError(t, err)
+	target, err := Open("sqlite://"+filepath.Join(t.TempDir(), "target.db"), "gorse_")
+	assert.NoError(t, err)
+	database := WithDualWrite(source, target)
+	assert.NoError(t, database.Init())
+
+	// insert historical data into the source only
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, source.BatchInsertUsers(ctx, []User{{UserId: strconv.Itoa(i)}}))
+		assert.NoError(t, source.BatchInsertItems(ctx, []Item{{ItemId: strconv.Itoa(i), Timestamp: now}}))
+	}
+	assert.NoError(t, source.BatchInsertFeedback(ctx, lo.Times(10, func(i int) Feedback {
+		return Feedback{FeedbackKey: FeedbackKey{"click", strconv.Itoa(i), strconv.Itoa(i)}, Timestamp: now}
+	}), false, false, false))
+
+	// writes are sent to both data stores
+	assert.NoError(t, database.BatchInsertItems(ctx, []Item{{ItemId: "new", Timestamp: now}}))
+	assert.NoError(t, database.ModifyUser(ctx, "0", UserPatch{Comment: lo.ToPtr("modified")}))
+	item, err := target.GetItem(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", item.ItemId)
+
+	// verify differences before the copy
+	diff, err := VerifyData(ctx, source, target, 3)
+	assert.NoError(t, err)
+	assert.Len(t, diff.Users, 10)
+	assert.Len(t, diff.Items, 10)
+	assert.Len(t, diff.Feedback, 10)
+
+	// copy historical data
+	stats, err := CopyData(ctx, source, target, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, CopyStats{Users: 10, Items: 11, Feedback: 10}, stats)
+	diff, err = VerifyData(ctx, source, target, 3)
+	assert.NoError(t, err)
+	assert.Zero(t, diff.Len())
+	user, err := target.GetUser(ctx, "0")
+	assert.NoError(t, err)
+	assert.Equal(t, "modified", user.Comment)
+
+	// repair differences
+	assert.NoError(t, target.ModifyItem(ctx, "1", ItemPatch{Comment: lo.ToPtr("stale")}))
+	assert.NoError(t, target.BatchInsertUsers(ctx, []User{{UserId: "deleted"}}))
+	_, err = source.DeleteUserItemFeedback(ctx, "2", "2", "click")
+	assert.NoError(t, err)
+	diff, err = VerifyData(ctx, source, target, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, DataDiff{
+		Users:    []string{"deleted"},
+		Items:    []string{"1"},
+		Feedback: []FeedbackKey{{"click", "2", "2"}},
+	}, diff)
+	assert.NoError(t, RepairData(ctx, source, target, diff))
+	diff, err = VerifyData(ctx, source, target, 3)
+	assert.NoError(t, err)
+	assert.Zero(t, diff.Len())
+	assert.NoError(t, database.Close())
+}
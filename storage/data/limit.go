@@ -0,0 +1,268 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Limits of connections and concurrent calls to a data store.
+type Limits struct {
+	MaxOpenConns    int           // max open connections of SQL databases, 0 means unlimited
+	MaxIdleConns    int           // max idle connections of SQL databases
+	ConnMaxLifetime time.Duration // max lifetime of connections of SQL databases, 0 means unlimited
+	MaxConcurrency  int           // max concurrent calls, 0 means unlimited
+	WaitTimeout     time.Duration // max time to wait for other calls if concurrent calls exceed the limit
+}
+
+// WithLimits applies limits of connections to a SQL database and its read replica, and wraps the database to limit
+// concurrent calls. Calls waiting longer than WaitTimeout fail with a timeout error instead of queueing up, so that a
+// burst of calls cannot exhaust connections of the data store. Streams are not limited since they are only used in
+// offline jobs.
+func WithLimits(database Database, limits Limits) Database {
+	if sqlDatabase, ok := database.(*SQLDatabase); ok {
+		for d := sqlDatabase; d != nil; d = d.replica {
+			d.client.SetMaxOpenConns(limits.MaxOpenConns)
+			d.client.SetMaxIdleConns(limits.MaxIdleConns)
+			d.client.SetConnMaxLifetime(limits.ConnMaxLifetime)
+		}
+	}
+	if limits.MaxConcurrency <= 0 {
+		return database
+	}
+	return &limitedDatabase{
+		Database:    database,
+		semaphore:   make(chan struct{}, limits.MaxConcurrency),
+		waitTimeout: limits.WaitTimeout,
+	}
+}
+
+// limitedDatabase limits concurrent calls to a database by a semaphore.
+type limitedDatabase struct {
+	Database
+	semaphore   chan struct{}
+	waitTimeout time.Duration
+}
+
+// acquire waits for a slot of the semaphore. A timeout error is returned if no slot is released within the wait
+// timeout.
+func (d *limitedDatabase) acquire(ctx context.Context) error {
+	select {
+	case d.semaphore <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(d.waitTimeout)
+	defer timer.Stop()
+	select {
+	case d.semaphore <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errors.Timeoutf("data store is busy after waiting for %v", d.waitTimeout)
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+func (d *limitedDatabase) release() {
+	<-d.semaphore
+}
+
+func (d *limitedDatabase) BatchInsertItems(ctx context.Context, items []Item) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.BatchInsertItems(ctx, items)
+}
+
+func (d *limitedDatabase) BatchGetItems(ctx context.Context, itemIds []string) ([]Item, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.Database.BatchGetItems(ctx, itemIds)
+}
+
+func (d *limitedDatabase) DeleteItem(ctx context.Context, itemId string) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.DeleteItem(ctx, itemId)
+}
+
+func (d *limitedDatabase) GetItem(ctx context.Context, itemId string) (Item, error) {
+	if err := d.acquire(ctx); err != nil {
+		return Item{}, err
+	}
+	defer d.release()
+	return d.Database.GetItem(ctx, itemId)
+}
+
+func (d *limitedDatabase) ModifyItem(ctx context.Context, itemId string, patch ItemPatch) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.ModifyItem(ctx, itemId, patch)
+}
+
+func (d *limitedDatabase) ModifyItemIf(ctx context.Context, itemId string, patch ItemPatch, condition func(Item) bool) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.ModifyItemIf(ctx, itemId, patch, condition)
+}
+
+func (d *limitedDatabase) GetItems(ctx context.Context, cursor string, n int, beginTime *time.Time) (string, []Item, error) {
+	if err := d.acquire(ctx); err != nil {
+		return "", nil, err
+	}
+	defer d.release()
+	return d.Database.GetItems(ctx, cursor, n, beginTime)
+}
+
+func (d *limitedDatabase) GetItemFeedback(ctx context.Context, itemId string, feedbackTypes ...string) ([]Feedback, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.Database.GetItemFeedback(ctx, itemId, feedbackTypes...)
+}
+
+func (d *limitedDatabase) BatchInsertUsers(ctx context.Context, users []User) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.BatchInsertUsers(ctx, users)
+}
+
+func (d *limitedDatabase) DeleteUser(ctx context.Context, userId string) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.DeleteUser(ctx, userId)
+}
+
+func (d *limitedDatabase) GetUser(ctx context.Context, userId string) (User, error) {
+	if err := d.acquire(ctx); err != nil {
+		return User{}, err
+	}
+	defer d.release()
+	return d.Database.GetUser(ctx, userId)
+}
+
+func (d *limitedDatabase) ModifyUser(ctx context.Context, userId string, patch UserPatch) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.ModifyUser(ctx, userId, patch)
+}
+
+func (d *limitedDatabase) ModifyUserIf(ctx context.Context, userId string, patch UserPatch, condition func(User) bool) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.ModifyUserIf(ctx, userId, patch, condition)
+}
+
+func (d *limitedDatabase) GetUsers(ctx context.Context, cursor string, n int) (string, []User, error) {
+	if err := d.acquire(ctx); err != nil {
+		return "", nil, err
+	}
+	defer d.release()
+	return d.Database.GetUsers(ctx, cursor, n)
+}
+
+func (d *limitedDatabase) GetUserFeedback(ctx context.Context, userId string, endTime *time.Time, feedbackTypes ...string) ([]Feedback, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.Database.GetUserFeedback(ctx, userId, endTime, feedbackTypes...)
+}
+
+func (d *limitedDatabase) GetUserItemFeedback(ctx context.Context, userId, itemId string, feedbackTypes ...string) ([]Feedback, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.Database.GetUserItemFeedback(ctx, userId, itemId, feedbackTypes...)
+}
+
+func (d *limitedDatabase) DeleteUserItemFeedback(ctx context.Context, userId, itemId string, feedbackTypes ...string) (int, error) {
+	if err := d.acquire(ctx); err != nil {
+		return 0, err
+	}
+	defer d.release()
+	return d.Database.DeleteUserItemFeedback(ctx, userId, itemId, feedbackTypes...)
+}
+
+func (d *limitedDatabase) BatchInsertFeedback(ctx context.Context, feedback []Feedback, insertUser, insertItem, overwrite bool) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.BatchInsertFeedback(ctx, feedback, insertUser, insertItem, overwrite)
+}
+
+func (d *limitedDatabase) GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, userId, itemId string, feedbackTypes ...string) (string, []Feedback, error) {
+	if err := d.acquire(ctx); err != nil {
+		return "", nil, err
+	}
+	defer d.release()
+	return d.Database.GetFeedback(ctx, cursor, n, beginTime, endTime, userId, itemId, feedbackTypes...)
+}
+
+func (d *limitedDatabase) InsertItemHistory(ctx context.Context, history []ItemHistory) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.InsertItemHistory(ctx, history)
+}
+
+func (d *limitedDatabase) GetItemHistory(ctx context.Context, itemId string, n int) ([]ItemHistory, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.Database.GetItemHistory(ctx, itemId, n)
+}
+
+func (d *limitedDatabase) InsertAuditLogs(ctx context.Context, logs []AuditLog) error {
+	if err := d.acquire(ctx); err != nil {
+		return err
+	}
+	defer d.release()
+	return d.Database.InsertAuditLogs(ctx, logs)
+}
+
+func (d *limitedDatabase) GetAuditLogs(ctx context.Context, n int, beginTime, endTime *time.Time) ([]AuditLog, error) {
+	if err := d.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.Database.GetAuditLogs(ctx, n, beginTime, endTime)
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingDatabase blocks GetUser until unblocked.
+type blockingDatabase struct {
+	NoDatabase
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (d *blockingDatabase) GetUser(_ context.Context, userId string) (User, error) {
+	d.entered <- struct{}{}
+	<-d.unblock
+	return User{UserId: userId}, nil
+}
+
+func TestWithLimits(t *testing.T) {
+	ctx := context.Background()
+	// no limit of concurrency
+	var noDatabase NoDatabase
+	assert.Equal(t, noDatabase, WithLimits(noDatabase, Limits{}))
+
+	database := &blockingDatabase{entered: make(chan struct{}, 2), unblock: make(chan struct{})}
+	limited := WithLimits(database, Limits{MaxConcurrency: 1, WaitTimeout: 100 * time.Millisecond})
+	done := make(chan error)
+	go func() {
+		_, err := limited.GetUser(ctx, "1")
+		done <- err
+	}()
+	<-database.entered
+	// calls exceeding the limit time out
+	_, err := limited.GetUser(ctx, "2")
+	assert.True(t, errors.Is(err, errors.Timeout))
+	// calls exceeding the limit are cancelled with the context
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = limited.GetUser(cancelCtx, "2")
+	assert.ErrorIs(t, err, context.Canceled)
+	// calls proceed once others complete
+	go func() {
+		_, err := limited.GetUser(ctx, "3")
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	database.unblock <- struct{}{}
+	assert.NoError(t, <-done)
+	<-database.entered
+	database.unblock <- struct{}{}
+	assert.NoError(t, <-done)
+	// calls not limited are passed through
+	err = limited.Ping()
+	assert.ErrorIs(t, err, ErrNoDatabase)
+}
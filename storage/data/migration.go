@@ -0,0 +1,218 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/zhenghaoz/gorse/base/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+)
+
+// Migration is a versioned change of the schema of a data store. Migrations are applied in ascending order of versions
+// and recorded in the table of applied migrations, so that each migration is applied only once instead of altering
+// large tables on every start.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error // reverts Up, nil if the migration is irreversible
+}
+
+// AppliedMigration is a migration recorded in the table of applied migrations.
+type AppliedMigration struct {
+	Version     int       `gorm:"column:version;primaryKey" bson:"_id"`
+	Description string    `gorm:"column:description"`
+	AppliedAt   time.Time `gorm:"column:applied_at"`
+}
+
+// Migrator is implemented by data stores with versioned schemas. Init applies all migrations of the data store.
+type Migrator interface {
+	// Migrations returns migrations of the data store in ascending order of versions.
+	Migrations() []Migration
+	// AppliedMigrations returns applied migrations in ascending order of versions.
+	AppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+	// Migrate applies migrations until the version and reverts applied migrations after the version. Version 0 reverts
+	// all migrations.
+	Migrate(ctx context.Context, version int) error
+}
+
+// LatestVersion returns the version of the last migration, 0 if there are no migrations.
+func LatestVersion(migrations []Migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// migrationTable stores applied migrations of a data store.
+type migrationTable interface {
+	Migrations() []Migration
+	AppliedMigrations(ctx context.Context) ([]AppliedMigration, error)
+	insertAppliedMigration(ctx context.Context, migration AppliedMigration) error
+	deleteAppliedMigration(ctx context.Context, version int) error
+}
+
+// migrate applies and reverts migrations to reach the version. Migrations applied by newer versions of gorse are
+// unknown, so that the data store must be migrated down by the newer version before downgrading.
+func migrate(ctx context.Context, table migrationTable, version int) error {
+	migrations := table.Migrations()
+	for i, migration := range migrations {
+		if migration.Version <= 0 || (i > 0 && migration.Version <= migrations[i-1].Version) {
+			return errors.NotValidf("version %d of migration `%s`", migration.Version, migration.Description)
+		}
+	}
+	if version < 0 || version > LatestVersion(migrations) {
+		return errors.NotValidf("version %d, the latest version is %d", version, LatestVersion(migrations))
+	}
+	applied, err := table.AppliedMigrations(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	known := lo.SliceToMap(migrations, func(m Migration) (int, Migration) {
+		return m.Version, m
+	})
+	for _, m := range applied {
+		if _, exist := known[m.Version]; !exist {
+			return errors.NotSupportedf("migration %d (%s) applied by a newer version of gorse", m.Version, m.Description)
+		}
+	}
+	appliedVersions := lo.SliceToMap(applied, func(m AppliedMigration) (int, struct{}) {
+		return m.Version, struct{}{}
+	})
+	// revert migrations after the version from the latest
+	for i := len(migrations) - 1; i >= 0 && migrations[i].Version > version; i-- {
+		m := migrations[i]
+		if _, exist := appliedVersions[m.Version]; !exist {
+			continue
+		}
+		if m.Down == nil {
+			return errors.NotSupportedf("reverting migration %d (%s)", m.Version, m.Description)
+		}
+		log.Logger().Info("revert migration", zap.Int("version", m.Version), zap.String("description", m.Description))
+		if err = m.Down(ctx); err != nil {
+			return errors.Annotatef(err, "failed to revert migration %d", m.Version)
+		}
+		if err = table.deleteAppliedMigration(ctx, m.Version); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// apply migrations until the version from the earliest
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		if _, exist := appliedVersions[m.Version]; exist {
+			continue
+		}
+		log.Logger().Info("apply migration", zap.Int("version", m.Version), zap.String("description", m.Description))
+		if err = m.Up(ctx); err != nil {
+			return errors.Annotatef(err, "failed to apply migration %d", m.Version)
+		}
+		if err = table.insertAppliedMigration(ctx, AppliedMigration{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now().UTC(),
+		}); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// Migrations of SQL databases. Tables created before migrations were introduced are kept by the first migration.
+func (d *SQLDatabase) Migrations() []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create tables of users, items, feedback, item history and audit logs",
+			Up:          d.createTables,
+			Down:        d.dropTables,
+		},
+	}
+}
+
+// Migrate applies or reverts migrations of a SQL database to reach the version.
+func (d *SQLDatabase) Migrate(ctx context.Context, version int) error {
+	if err := d.createMigrationsTable(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	return migrate(ctx, d, version)
+}
+
+// createMigrationsTable creates the table of applied migrations if it doesn't exist.
+func (d *SQLDatabase) createMigrationsTable(ctx context.Context) error {
+	switch d.driver {
+	case ClickHouse:
+		type SchemaMigrations struct {
+			Version     int       `gorm:"column:version;type:Int32"`
+			Description string    `gorm:"column:description;type:String"`
+			AppliedAt   time.Time `gorm:"column:applied_at;type:DateTime"`
+		}
+		return errors.Trace(d.gormDB.WithContext(ctx).Set("gorm:table_options", "ENGINE = ReplacingMergeTree() ORDER BY version").
+			AutoMigrate(SchemaMigrations{}))
+	case Oracle:
+		type SchemaMigrations struct {
+			Version     int       `gorm:"column:VERSION;type:integer;not null;primaryKey"`
+			Description string    `gorm:"column:DESCRIPTION;type:varchar2(4000);not null"`
+			AppliedAt   time.Time `gorm:"column:APPLIED_AT;type:TIMESTAMP;not null"`
+		}
+		return errors.Trace(d.gormDB.WithContext(ctx).AutoMigrate(SchemaMigrations{}))
+	default:
+		type SchemaMigrations struct {
+			Version     int       `gorm:"column:version;type:integer;not null;primaryKey;autoIncrement:false"`
+			Description string    `gorm:"column:description;type:text;not null"`
+			AppliedAt   time.Time `gorm:"column:applied_at;not null"`
+		}
+		return errors.Trace(d.gormDB.WithContext(ctx).AutoMigrate(SchemaMigrations{}))
+	}
+}
+
+// AppliedMigrations returns applied migrations of a SQL database.
+func (d *SQLDatabase) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	var applied []AppliedMigration
+	if err := d.gormDB.WithContext(ctx).Table(d.MigrationsTable()).Order("version").Find(&applied).Error; err != nil {
+		return nil, errors.Trace(err)
+	}
+	// rows in ClickHouse are deduplicated in background
+	return lo.UniqBy(applied, func(m AppliedMigration) int { return m.Version }), nil
+}
+
+func (d *SQLDatabase) insertAppliedMigration(ctx context.Context, migration AppliedMigration) error {
+	tx := d.gormDB.WithContext(ctx).Table(d.MigrationsTable())
+	if d.driver != ClickHouse {
+		tx = tx.Clauses(clause.OnConflict{DoNothing: true})
+	}
+	return errors.Trace(tx.Create(&migration).Error)
+}
+
+func (d *SQLDatabase) deleteAppliedMigration(ctx context.Context, version int) error {
+	if d.driver == ClickHouse {
+		return errors.Trace(d.gormDB.WithContext(ctx).Exec(
+			"ALTER TABLE "+d.MigrationsTable()+" DELETE WHERE version = ? SETTINGS mutations_sync = 1", version).Error)
+	}
+	return errors.Trace(d.gormDB.WithContext(ctx).Table(d.MigrationsTable()).
+		Where("version = ?", version).Delete(&AppliedMigration{}).Error)
+}
+
+// dropTables drops tables created by the first migration.
+func (d *SQLDatabase) dropTables(ctx context.Context) error {
+	return errors.Trace(d.gormDB.WithContext(ctx).Migrator().DropTable(
+		d.UsersTable(), d.ItemsTable(), d.FeedbackTable(), d.ItemHistoryTable(), d.AuditLogsTable()))
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockMigrationTable records applied migrations in memory and the order of applied and reverted migrations.
+type mockMigrationTable struct {
+	migrations []Migration
+	applied    map[int]AppliedMigration
+	history    []int // positive versions are applied and negative versions are reverted
+}
+
+func newMockMigrationTable(versions ...int) *mockMigrationTable {
+	table := &mockMigrationTable{applied: make(map[int]AppliedMigration)}
+	for _, version := range versions {
+		version := version
+		table.migrations = append(table.migrations, Migration{
+			Version: version,
+			Up: func(ctx context.Context) error {
+				table.history = append(table.history, version)
+				return nil
+			},
+			Down: func(ctx context.Context) error {
+				table.history = append(table.history, -version)
+				return nil
+			},
+		})
+	}
+	return table
+}
+
+func (m *mockMigrationTable) Migrations() []Migration {
+	return m.migrations
+}
+
+func (m *mockMigrationTable) AppliedMigrations(_ context.Context) ([]AppliedMigration, error) {
+	applied := lo.Values(m.applied)
+	sort.Slice(applied, func(i, j int) bool {
+		return applied[i].Version < applied[j].Version
+	})
+	return applied, nil
+}
+
+func (m *mockMigrationTable) insertAppliedMigration(_ context.Context, migration AppliedMigration) error {
+	m.applied[migration.Version] = migration
+	return nil
+}
+
+func (m *mockMigrationTable) deleteAppliedMigration(_ context.Context, version int) error {
+	delete(m.applied, version)
+	return nil
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	table := newMockMigrationTable(1, 2, 3)
+	assert.Equal(t, 3, LatestVersion(table.Migrations()))
+	assert.Zero(t, LatestVersion(nil))
+
+	// apply migrations in order
+	assert.NoError(t, migrate(ctx, table, 2))
+	assert.NoError(t, migrate(ctx, table, 3))
+	assert.Equal(t, []int{1, 2, 3}, table.history)
+	// applied migrations are skipped
+	assert.NoError(t, migrate(ctx, table, 3))
+	assert.Equal(t, []int{1, 2, 3}, table.history)
+	// revert migrations in reverse order
+	assert.NoError(t, migrate(ctx, table, 1))
+	assert.Equal(t, []int{1, 2, 3, -3, -2}, table.history)
+	applied, err := table.AppliedMigrations(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, lo.Map(applied, func(m AppliedMigration, _ int) int { return m.Version }))
+	assert.NoError(t, migrate(ctx, table, 0))
+	assert.Empty(t, table.applied)
+
+	// versions out of range
+	assert.True(t, errors.Is(migrate(ctx, table, 4), errors.NotValid))
+	assert.True(t, errors.Is(migrate(ctx, table, -1), errors.NotValid))
+	// versions of migrations must be ascending
+	assert.True(t, errors.Is(migrate(ctx, newMockMigrationTable(1, 3, 2), 1), errors.NotValid))
+	// migrations applied by newer versions are unknown
+	table.applied[4] = AppliedMigration{Version: 4}
+	assert.True(t, errors.Is(migrate(ctx, table, 3), errors.NotSupported))
+	// irreversible migrations
+	table = newMockMigrationTable(1, 2)
+	table.migrations[1].Down = nil
+	assert.NoError(t, migrate(ctx, table, 2))
+	assert.True(t, errors.Is(migrate(ctx, table, 1), errors.NotSupported))
+	assert.Equal(t, []int{1, 2}, table.history)
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	database, err := Open("sqlite://"+filepath.Join(t.TempDir(), "gorse.db"), "gorse_")
+	assert.NoError(t, err)
+	d := database.(*SQLDatabase)
+	assert.NoError(t, database.Init())
+	applied, err := d.AppliedMigrations(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, LatestVersion(d.Migrations()), len(applied))
+	assert.Equal(t, 1, applied[0].Version)
+	assert.Equal(t, d.Migrations()[0].Description, applied[0].Description)
+	assert.False(t, applied[0].AppliedAt.IsZero())
+
+	// data are kept by Init since migrations have been applied
+	assert.NoError(t, database.BatchInsertItems(ctx, []Item{{ItemId: "1", Labels: []string{}, Categories: []string{}}}))
+	assert.NoError(t, database.Init())
+	_, err = database.GetItem(ctx, "1")
+	assert.NoError(t, err)
+
+	// revert all migrations
+	assert.NoError(t, d.Migrate(ctx, 0))
+	assert.False(t, d.gormDB.Migrator().HasTable(d.ItemsTable()))
+	applied, err = d.AppliedMigrations(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+	assert.NoError(t, d.Migrate(ctx, 1))
+	assert.True(t, d.gormDB.Migrator().HasTable(d.ItemsTable()))
+	assert.NoError(t, database.Close())
+}
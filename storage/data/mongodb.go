@@ -50,12 +50,70 @@ func (db *MongoDB) Optimize() error {
 	return nil
 }
 
-// Init collections and indices in MongoDB.
+// Init applies all migrations to MongoDB.
 func (db *MongoDB) Init() error {
-	ctx := context.Background()
+	return db.Migrate(context.Background(), LatestVersion(db.Migrations()))
+}
+
+// Migrations of MongoDB. Collections created before migrations were introduced are kept by the first migration.
+func (db *MongoDB) Migrations() []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create collections and indices of users, items, feedback, item history and audit logs",
+			Up:          db.createCollections,
+			Down:        db.dropCollections,
+		},
+	}
+}
+
+// Migrate applies or reverts migrations of MongoDB to reach the version.
+func (db *MongoDB) Migrate(ctx context.Context, version int) error {
+	return migrate(ctx, db, version)
+}
+
+// AppliedMigrations returns applied migrations of MongoDB.
+func (db *MongoDB) AppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	c := db.client.Database(db.dbName).Collection(db.MigrationsTable())
+	r, err := c.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	applied := make([]AppliedMigration, 0)
+	if err = r.All(ctx, &applied); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return applied, nil
+}
+
+func (db *MongoDB) insertAppliedMigration(ctx context.Context, migration AppliedMigration) error {
+	c := db.client.Database(db.dbName).Collection(db.MigrationsTable())
+	_, err := c.UpdateOne(ctx, bson.M{"_id": migration.Version}, bson.M{"$setOnInsert": migration}, options.Update().SetUpsert(true))
+	return errors.Trace(err)
+}
+
+func (db *MongoDB) deleteAppliedMigration(ctx context.Context, version int) error {
+	c := db.client.Database(db.dbName).Collection(db.MigrationsTable())
+	_, err := c.DeleteOne(ctx, bson.M{"_id": version})
+	return errors.Trace(err)
+}
+
+// dropCollections drops collections created by the first migration.
+func (db *MongoDB) dropCollections(ctx context.Context) error {
+	d := db.client.Database(db.dbName)
+	for _, name := range []string{db.UsersTable(), db.ItemsTable(), db.FeedbackTable(), db.ItemHistoryTable(), db.AuditLogsTable()} {
+		if err := d.Collection(name).Drop(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// createCollections creates collections and indices. Existing collections are kept.
+func (db *MongoDB) createCollections(ctx context.Context) error {
 	d := db.client.Database(db.dbName)
 	// list collections
-	var hasUsers, hasItems, hasFeedback bool
+	var hasUsers, hasItems, hasFeedback, hasItemHistory, hasAuditLogs bool
 	collections, err := d.ListCollectionNames(ctx, bson.M{})
 	if err != nil {
 		return errors.Trace(err)
@@ -68,6 +126,10 @@ func (db *MongoDB) Init() error {
 			hasItems = true
 		case db.FeedbackTable():
 			hasFeedback = true
+		case db.ItemHistoryTable():
+			hasItemHistory = true
+		case db.AuditLogsTable():
+			hasAuditLogs = true
 		}
 	}
 	// create collections
@@ -86,6 +148,16 @@ func (db *MongoDB) Init() error {
 			return errors.Trace(err)
 		}
 	}
+	if !hasItemHistory {
+		if err = d.CreateCollection(ctx, db.ItemHistoryTable()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if !hasAuditLogs {
+		if err = d.CreateCollection(ctx, db.AuditLogsTable()); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	// create index
 	_, err = d.Collection(db.UsersTable()).Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.M{
@@ -130,6 +202,23 @@ func (db *MongoDB) Init() error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	_, err = d.Collection(db.ItemHistoryTable()).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{"itemid", 1},
+			{"modifytime", -1},
+		},
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = d.Collection(db.AuditLogsTable()).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{
+			"timestamp": 1,
+		},
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
 
@@ -143,7 +232,7 @@ func (db *MongoDB) Close() error {
 }
 
 func (db *MongoDB) Purge() error {
-	tables := []string{db.ItemsTable(), db.FeedbackTable(), db.UsersTable()}
+	tables := []string{db.ItemsTable(), db.FeedbackTable(), db.UsersTable(), db.ItemHistoryTable(), db.AuditLogsTable()}
 	for _, tableName := range tables {
 		c := db.client.Database(db.dbName).Collection(tableName)
 		_, err := c.DeleteMany(context.Background(), bson.D{})
@@ -194,7 +283,41 @@ func (db *MongoDB) BatchGetItems(ctx context.Context, itemIds []string) ([]Item,
 
 // ModifyItem modify an item in MongoDB.
 func (db *MongoDB) ModifyItem(ctx context.Context, itemId string, patch ItemPatch) error {
-	// create update
+	c := db.client.Database(db.dbName).Collection(db.ItemsTable())
+	_, err := c.UpdateOne(ctx, bson.M{"itemid": bson.M{"$eq": itemId}}, bson.M{"$set": itemUpdate(patch)})
+	return errors.Trace(err)
+}
+
+// ModifyItemIf modifies an item in MongoDB if the condition is satisfied. The update is filtered by the checked
+// document, so that nothing is matched if the item has been modified concurrently.
+func (db *MongoDB) ModifyItemIf(ctx context.Context, itemId string, patch ItemPatch, condition func(Item) bool) error {
+	c := db.client.Database(db.dbName).Collection(db.ItemsTable())
+	r := c.FindOne(ctx, bson.M{"itemid": bson.M{"$eq": itemId}})
+	if r.Err() == mongo.ErrNoDocuments {
+		return errors.Annotate(ErrItemNotExist, itemId)
+	}
+	doc, err := r.DecodeBytes()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var item Item
+	if err = bson.Unmarshal(doc, &item); err != nil {
+		return errors.Trace(err)
+	}
+	if !condition(item) {
+		return errors.Annotate(ErrPreconditionFailed, itemId)
+	}
+	result, err := c.UpdateOne(ctx, doc, bson.M{"$set": itemUpdate(patch)})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.Annotate(ErrPreconditionFailed, itemId)
+	}
+	return nil
+}
+
+func itemUpdate(patch ItemPatch) bson.M {
 	update := bson.M{}
 	if patch.IsHidden != nil {
 		update["ishidden"] = patch.IsHidden
@@ -211,10 +334,13 @@ func (db *MongoDB) ModifyItem(ctx context.Context, itemId string, patch ItemPatc
 	if patch.Timestamp != nil {
 		update["timestamp"] = patch.Timestamp
 	}
-	// execute
-	c := db.client.Database(db.dbName).Collection(db.ItemsTable())
-	_, err := c.UpdateOne(ctx, bson.M{"itemid": bson.M{"$eq": itemId}}, bson.M{"$set": update})
-	return errors.Trace(err)
+	if patch.Latitude != nil {
+		update["latitude"] = patch.Latitude
+	}
+	if patch.Longitude != nil {
+		update["longitude"] = patch.Longitude
+	}
+	return update
 }
 
 // DeleteItem deletes a item from MongoDB.
@@ -369,7 +495,41 @@ func (db *MongoDB) BatchInsertUsers(ctx context.Context, users []User) error {
 
 // ModifyUser modify a user in MongoDB.
 func (db *MongoDB) ModifyUser(ctx context.Context, userId string, patch UserPatch) error {
-	// create patch
+	c := db.client.Database(db.dbName).Collection(db.UsersTable())
+	_, err := c.UpdateOne(ctx, bson.M{"userid": bson.M{"$eq": userId}}, bson.M{"$set": userUpdate(patch)})
+	return errors.Trace(err)
+}
+
+// ModifyUserIf modifies a user in MongoDB if the condition is satisfied. The update is filtered by the checked
+// document, so that nothing is matched if the user has been modified concurrently.
+func (db *MongoDB) ModifyUserIf(ctx context.Context, userId string, patch UserPatch, condition func(User) bool) error {
+	c := db.client.Database(db.dbName).Collection(db.UsersTable())
+	r := c.FindOne(ctx, bson.M{"userid": bson.M{"$eq": userId}})
+	if r.Err() == mongo.ErrNoDocuments {
+		return errors.Annotate(ErrUserNotExist, userId)
+	}
+	doc, err := r.DecodeBytes()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var user User
+	if err = bson.Unmarshal(doc, &user); err != nil {
+		return errors.Trace(err)
+	}
+	if !condition(user) {
+		return errors.Annotate(ErrPreconditionFailed, userId)
+	}
+	result, err := c.UpdateOne(ctx, doc, bson.M{"$set": userUpdate(patch)})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.Annotate(ErrPreconditionFailed, userId)
+	}
+	return nil
+}
+
+func userUpdate(patch UserPatch) bson.M {
 	update := bson.M{}
 	if patch.Labels != nil {
 		update["labels"] = patch.Labels
@@ -380,10 +540,7 @@ func (db *MongoDB) ModifyUser(ctx context.Context, userId string, patch UserPatc
 	if patch.Subscribe != nil {
 		update["subscribe"] = patch.Subscribe
 	}
-	// execute
-	c := db.client.Database(db.dbName).Collection(db.UsersTable())
-	_, err := c.UpdateOne(ctx, bson.M{"userid": bson.M{"$eq": userId}}, bson.M{"$set": update})
-	return errors.Trace(err)
+	return update
 }
 
 // DeleteUser deletes a user from MongoDB.
@@ -605,7 +762,7 @@ func (db *MongoDB) BatchInsertFeedback(ctx context.Context, feedback []Feedback,
 }
 
 // GetFeedback returns multiple feedback from MongoDB.
-func (db *MongoDB) GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, feedbackTypes ...string) (string, []Feedback, error) {
+func (db *MongoDB) GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, userId, itemId string, feedbackTypes ...string) (string, []Feedback, error) {
 	buf, err := base64.StdEncoding.DecodeString(cursor)
 	if err != nil {
 		return "", nil, errors.Trace(err)
@@ -627,6 +784,13 @@ func (db *MongoDB) GetFeedback(ctx context.Context, cursor string, n int, beginT
 	if len(feedbackTypes) > 0 {
 		filter["feedbackkey.feedbacktype"] = bson.M{"$in": feedbackTypes}
 	}
+	// pass user and item to filter
+	if userId != "" {
+		filter["feedbackkey.userid"] = bson.M{"$eq": userId}
+	}
+	if itemId != "" {
+		filter["feedbackkey.itemid"] = bson.M{"$eq": itemId}
+	}
 	// pass time limit to filter
 	timestampConditions := bson.M{}
 	if beginTime != nil {
@@ -635,7 +799,9 @@ func (db *MongoDB) GetFeedback(ctx context.Context, cursor string, n int, beginT
 	if endTime != nil {
 		timestampConditions["$lte"] = *endTime
 	}
-	filter["timestamp"] = timestampConditions
+	if len(timestampConditions) > 0 {
+		filter["timestamp"] = timestampConditions
+	}
 	r, err := c.Find(ctx, filter, opt)
 	if err != nil {
 		return "", nil, err
@@ -754,3 +920,86 @@ func (db *MongoDB) DeleteUserItemFeedback(ctx context.Context, userId, itemId st
 	}
 	return int(r.DeletedCount), nil
 }
+
+// InsertItemHistory inserts previous versions of items into MongoDB.
+func (db *MongoDB) InsertItemHistory(ctx context.Context, history []ItemHistory) error {
+	if len(history) == 0 {
+		return nil
+	}
+	c := db.client.Database(db.dbName).Collection(db.ItemHistoryTable())
+	documents := make([]interface{}, len(history))
+	for i, h := range history {
+		documents[i] = h
+	}
+	_, err := c.InsertMany(ctx, documents)
+	return errors.Trace(err)
+}
+
+// GetItemHistory returns the latest n previous versions of an item from MongoDB.
+func (db *MongoDB) GetItemHistory(ctx context.Context, itemId string, n int) ([]ItemHistory, error) {
+	c := db.client.Database(db.dbName).Collection(db.ItemHistoryTable())
+	opt := options.Find()
+	opt.SetLimit(int64(n))
+	opt.SetSort(bson.D{{"modifytime", -1}})
+	r, err := c.Find(ctx, bson.M{"itemid": bson.M{"$eq": itemId}}, opt)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	history := make([]ItemHistory, 0)
+	defer r.Close(ctx)
+	for r.Next(ctx) {
+		var h ItemHistory
+		if err = r.Decode(&h); err != nil {
+			return nil, errors.Trace(err)
+		}
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// InsertAuditLogs inserts audit logs into MongoDB.
+func (db *MongoDB) InsertAuditLogs(ctx context.Context, logs []AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	c := db.client.Database(db.dbName).Collection(db.AuditLogsTable())
+	documents := make([]interface{}, len(logs))
+	for i, l := range logs {
+		documents[i] = l
+	}
+	_, err := c.InsertMany(ctx, documents)
+	return errors.Trace(err)
+}
+
+// GetAuditLogs returns the latest n audit logs from MongoDB.
+func (db *MongoDB) GetAuditLogs(ctx context.Context, n int, beginTime, endTime *time.Time) ([]AuditLog, error) {
+	c := db.client.Database(db.dbName).Collection(db.AuditLogsTable())
+	opt := options.Find()
+	opt.SetLimit(int64(n))
+	opt.SetSort(bson.D{{"timestamp", -1}})
+	timestampConditions := bson.M{}
+	if beginTime != nil {
+		timestampConditions["$gte"] = *beginTime
+	}
+	if endTime != nil {
+		timestampConditions["$lte"] = *endTime
+	}
+	filter := bson.M{}
+	if len(timestampConditions) > 0 {
+		filter["timestamp"] = timestampConditions
+	}
+	r, err := c.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	logs := make([]AuditLog, 0)
+	defer r.Close(ctx)
+	for r.Next(ctx) {
+		var l AuditLog
+		if err = r.Decode(&l); err != nil {
+			return nil, errors.Trace(err)
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
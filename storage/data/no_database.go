@@ -140,7 +140,7 @@ func (NoDatabase) BatchInsertFeedback(_ context.Context, _ []Feedback, _, _, _ b
 }
 
 // GetFeedback method of NoDatabase returns ErrNoDatabase.
-func (NoDatabase) GetFeedback(_ context.Context, _ string, _ int, _, _ *time.Time, _ ...string) (string, []Feedback, error) {
+func (NoDatabase) GetFeedback(_ context.Context, _ string, _ int, _, _ *time.Time, _, _ string, _ ...string) (string, []Feedback, error) {
 	return "", nil, ErrNoDatabase
 }
 
@@ -163,3 +163,31 @@ func (d NoDatabase) ModifyItem(_ context.Context, _ string, _ ItemPatch) error {
 func (d NoDatabase) ModifyUser(_ context.Context, _ string, _ UserPatch) error {
 	return ErrNoDatabase
 }
+
+func (d NoDatabase) ModifyItemIf(_ context.Context, _ string, _ ItemPatch, _ func(Item) bool) error {
+	return ErrNoDatabase
+}
+
+func (d NoDatabase) ModifyUserIf(_ context.Context, _ string, _ UserPatch, _ func(User) bool) error {
+	return ErrNoDatabase
+}
+
+// InsertAuditLogs method of NoDatabase returns ErrNoDatabase.
+func (NoDatabase) InsertAuditLogs(_ context.Context, _ []AuditLog) error {
+	return ErrNoDatabase
+}
+
+// GetAuditLogs method of NoDatabase returns ErrNoDatabase.
+func (NoDatabase) GetAuditLogs(_ context.Context, _ int, _, _ *time.Time) ([]AuditLog, error) {
+	return nil, ErrNoDatabase
+}
+
+// InsertItemHistory method of NoDatabase returns ErrNoDatabase.
+func (NoDatabase) InsertItemHistory(_ context.Context, _ []ItemHistory) error {
+	return ErrNoDatabase
+}
+
+// GetItemHistory method of NoDatabase returns ErrNoDatabase.
+func (NoDatabase) GetItemHistory(_ context.Context, _ string, _ int) ([]ItemHistory, error) {
+	return nil, ErrNoDatabase
+}
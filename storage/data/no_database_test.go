@@ -73,7 +73,7 @@ func TestNoDatabase(t *testing.T) {
 	assert.ErrorIs(t, err, ErrNoDatabase)
 	_, err = database.GetItemFeedback(ctx, "")
 	assert.ErrorIs(t, err, ErrNoDatabase)
-	_, _, err = database.GetFeedback(ctx, "", 0, nil, lo.ToPtr(time.Now()))
+	_, _, err = database.GetFeedback(ctx, "", 0, nil, lo.ToPtr(time.Now()), "", "")
 	assert.ErrorIs(t, err, ErrNoDatabase)
 	_, err = database.GetUserItemFeedback(ctx, "", "")
 	assert.ErrorIs(t, err, ErrNoDatabase)
@@ -81,4 +81,13 @@ func TestNoDatabase(t *testing.T) {
 	assert.ErrorIs(t, err, ErrNoDatabase)
 	_, c = database.GetFeedbackStream(ctx, 0, nil, lo.ToPtr(time.Now()))
 	assert.ErrorIs(t, <-c, ErrNoDatabase)
+
+	err = database.InsertItemHistory(ctx, nil)
+	assert.ErrorIs(t, err, ErrNoDatabase)
+	_, err = database.GetItemHistory(ctx, "", 0)
+	assert.ErrorIs(t, err, ErrNoDatabase)
+	err = database.InsertAuditLogs(ctx, nil)
+	assert.ErrorIs(t, err, ErrNoDatabase)
+	_, err = database.GetAuditLogs(ctx, 0, nil, nil)
+	assert.ErrorIs(t, err, ErrNoDatabase)
 }
@@ -0,0 +1,344 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FeedbackPartitioner is implemented by data stores partitioning the feedback table by time. Queries of feedback in a
+// time range only scan overlapping partitions, and old feedback is discarded by dropping partitions instead of deleting
+// rows one by one.
+type FeedbackPartitioner interface {
+	// PartitionFeedback creates the feedback table partitioned by time if it doesn't exist, and adds partitions of the
+	// interval to cover feedback until the time. Existing feedback tables without partitions are never converted.
+	PartitionFeedback(ctx context.Context, interval time.Duration, until time.Time) error
+	// PruneFeedback drops partitions of feedback before the time and returns the number of dropped partitions.
+	PruneFeedback(ctx context.Context, before time.Time) (int, error)
+}
+
+// partitionCheckInterval is the interval to check again whether the feedback table is partitioned, since the table
+// might be created by the master after servers and workers connect.
+const partitionCheckInterval = time.Minute
+
+const (
+	mysqlPartitionTimeLayout    = "2006-01-02 15:04:05"
+	postgresPartitionTimeLayout = "2006-01-02 15:04:05-07:00"
+)
+
+// postgresPartitionBound matches the upper bound of a partition in PostgreSQL, such as
+// FOR VALUES FROM ('2023-01-01 00:00:00+00') TO ('2023-01-02 00:00:00+00').
+var postgresPartitionBound = regexp.MustCompile(`TO \('([^']+)'\)`)
+
+// feedbackPartition holds feedback before the end time.
+type feedbackPartition struct {
+	Name string
+	End  time.Time
+}
+
+// feedbackPartitionName returns the name of the partition ending at the time.
+func feedbackPartitionName(end time.Time) string {
+	return "p" + end.UTC().Format("20060102150405")
+}
+
+// PartitionFeedback creates the feedback table partitioned by time_stamp in MySQL or PostgreSQL. The primary key of
+// partitioned tables must contain time_stamp, so that uniqueness of feedback is kept by BatchInsertFeedback instead.
+func (d *SQLDatabase) PartitionFeedback(ctx context.Context, interval time.Duration, until time.Time) error {
+	if d.driver != MySQL && d.driver != Postgres {
+		return errors.NotSupportedf("partitions of feedback")
+	}
+	if interval <= 0 {
+		return errors.NotValidf("partition interval %v", interval)
+	}
+	if !d.gormDB.Migrator().HasTable(d.FeedbackTable()) {
+		if err := d.createPartitionedFeedback(ctx); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	partitions, partitioned, err := d.feedbackPartitions(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	} else if !partitioned {
+		return errors.NotSupportedf("partitions of existing feedback table %s", d.FeedbackTable())
+	}
+	// continue from the last partition
+	start := time.Now().UTC().Truncate(interval)
+	if len(partitions) > 0 {
+		start = partitions[len(partitions)-1].End.UTC()
+	}
+	var ends []time.Time
+	for ; !start.After(until); start = start.Add(interval) {
+		ends = append(ends, start.Add(interval))
+	}
+	if len(ends) == 0 {
+		return nil
+	}
+	tx := d.gormDB.WithContext(ctx)
+	switch d.driver {
+	case MySQL:
+		definitions := make([]string, 0, len(ends)+1)
+		for _, end := range ends {
+			definitions = append(definitions, fmt.Sprintf("PARTITION %s VALUES LESS THAN ('%s')",
+				feedbackPartitionName(end), end.Format(mysqlPartitionTimeLayout)))
+		}
+		definitions = append(definitions, "PARTITION pmax VALUES LESS THAN (MAXVALUE)")
+		err = tx.Exec(fmt.Sprintf("ALTER TABLE `%s` REORGANIZE PARTITION pmax INTO (%s)",
+			d.FeedbackTable(), strings.Join(definitions, ", "))).Error
+		if err != nil {
+			return errors.Trace(err)
+		}
+	case Postgres:
+		for _, end := range ends {
+			err = tx.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s_%s" PARTITION OF "%s" FOR VALUES FROM ('%s') TO ('%s')`,
+				d.FeedbackTable(), feedbackPartitionName(end), d.FeedbackTable(),
+				end.Add(-interval).Format(postgresPartitionTimeLayout), end.Format(postgresPartitionTimeLayout))).Error
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// PruneFeedback drops partitions of feedback before the time. In PostgreSQL, feedback before the first partition is
+// kept in the default partition and deleted row by row.
+func (d *SQLDatabase) PruneFeedback(ctx context.Context, before time.Time) (int, error) {
+	if d.driver != MySQL && d.driver != Postgres {
+		return 0, errors.NotSupportedf("partitions of feedback")
+	}
+	partitions, partitioned, err := d.feedbackPartitions(ctx)
+	if err != nil {
+		return 0, errors.Trace(err)
+	} else if !partitioned {
+		return 0, errors.NotSupportedf("partitions of existing feedback table %s", d.FeedbackTable())
+	}
+	var names []string
+	for _, partition := range partitions {
+		if !partition.End.After(before) {
+			names = append(names, partition.Name)
+		}
+	}
+	tx := d.gormDB.WithContext(ctx)
+	switch d.driver {
+	case MySQL:
+		if len(names) > 0 {
+			err = tx.Exec(fmt.Sprintf("ALTER TABLE `%s` DROP PARTITION %s", d.FeedbackTable(), strings.Join(names, ", "))).Error
+			if err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+	case Postgres:
+		for _, name := range names {
+			if err = tx.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "%s"`, name)).Error; err != nil {
+				return 0, errors.Trace(err)
+			}
+		}
+		err = tx.Exec(fmt.Sprintf(`DELETE FROM "%s_default" WHERE time_stamp < ?`, d.FeedbackTable()), before).Error
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	return len(names), nil
+}
+
+// createPartitionedFeedback creates the feedback table with a partition for all feedback. Partitions of time ranges are
+// split from it later. In MySQL, key columns are shorter by one character than those of unpartitioned tables, since
+// InnoDB limits keys to 3072 bytes.
+func (d *SQLDatabase) createPartitionedFeedback(ctx context.Context) error {
+	switch d.driver {
+	case MySQL:
+		return d.gormDB.WithContext(ctx).Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` ("+
+			"feedback_type varchar(255) NOT NULL, "+
+			"user_id varchar(255) NOT NULL, "+
+			"item_id varchar(255) NOT NULL, "+
+			"time_stamp datetime NOT NULL, "+
+			"comment text NOT NULL, "+
+			"PRIMARY KEY (feedback_type, user_id, item_id, time_stamp), "+
+			"INDEX user_id_time_stamp (user_id, time_stamp), "+
+			"INDEX item_id_time_stamp (item_id, time_stamp)"+
+			") ENGINE=InnoDB PARTITION BY RANGE COLUMNS(time_stamp) (PARTITION pmax VALUES LESS THAN (MAXVALUE))",
+			d.FeedbackTable())).Error
+	case Postgres:
+		return d.gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			statements := []string{
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`+
+					"feedback_type varchar(256) NOT NULL, "+
+					"user_id varchar(256) NOT NULL, "+
+					"item_id varchar(256) NOT NULL, "+
+					"time_stamp timestamptz NOT NULL, "+
+					"comment text NOT NULL DEFAULT '', "+
+					"PRIMARY KEY (feedback_type, user_id, item_id, time_stamp)"+
+					") PARTITION BY RANGE (time_stamp)", d.FeedbackTable()),
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s_default" PARTITION OF "%s" DEFAULT`, d.FeedbackTable(), d.FeedbackTable()),
+				fmt.Sprintf(`CREATE INDEX IF NOT EXISTS user_id_time_stamp_index ON "%s" (user_id, time_stamp)`, d.FeedbackTable()),
+				fmt.Sprintf(`CREATE INDEX IF NOT EXISTS item_id_time_stamp_index ON "%s" (item_id, time_stamp)`, d.FeedbackTable()),
+			}
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return errors.Trace(err)
+				}
+			}
+			return nil
+		})
+	}
+	return errors.NotSupportedf("partitions of feedback")
+}
+
+// feedbackPartitions returns partitions of time ranges sorted by end time, and whether the feedback table is
+// partitioned. The partition for feedback after all ranges or before them is not listed.
+func (d *SQLDatabase) feedbackPartitions(ctx context.Context) ([]feedbackPartition, bool, error) {
+	var partitions []feedbackPartition
+	partitioned := false
+	tx := d.gormDB.WithContext(ctx)
+	switch d.driver {
+	case MySQL:
+		rs, err := tx.Raw("SELECT PARTITION_NAME, PARTITION_DESCRIPTION FROM information_schema.PARTITIONS "+
+			"WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL", d.FeedbackTable()).Rows()
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		defer rs.Close()
+		for rs.Next() {
+			var name, description string
+			if err = rs.Scan(&name, &description); err != nil {
+				return nil, false, errors.Trace(err)
+			}
+			partitioned = true
+			if description == "MAXVALUE" {
+				continue
+			}
+			end, err := time.ParseInLocation(mysqlPartitionTimeLayout, strings.Trim(description, "'"), time.UTC)
+			if err != nil {
+				return nil, false, errors.Trace(err)
+			}
+			partitions = append(partitions, feedbackPartition{Name: name, End: end})
+		}
+		if err = rs.Err(); err != nil {
+			return nil, false, errors.Trace(err)
+		}
+	case Postgres:
+		var count int64
+		err := tx.Raw("SELECT COUNT(*) FROM pg_partitioned_table JOIN pg_class ON pg_class.oid = pg_partitioned_table.partrelid "+
+			"WHERE pg_class.relname = ? AND pg_class.relnamespace = current_schema()::regnamespace", d.FeedbackTable()).
+			Scan(&count).Error
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		partitioned = count > 0
+		rs, err := tx.Raw("SELECT child.relname, pg_get_expr(child.relpartbound, child.oid) FROM pg_inherits "+
+			"JOIN pg_class parent ON parent.oid = pg_inherits.inhparent JOIN pg_class child ON child.oid = pg_inherits.inhrelid "+
+			"WHERE parent.relname = ? AND parent.relnamespace = current_schema()::regnamespace", d.FeedbackTable()).Rows()
+		if err != nil {
+			return nil, false, errors.Trace(err)
+		}
+		defer rs.Close()
+		for rs.Next() {
+			var name, bound string
+			if err = rs.Scan(&name, &bound); err != nil {
+				return nil, false, errors.Trace(err)
+			}
+			matches := postgresPartitionBound.FindStringSubmatch(bound)
+			if matches == nil {
+				continue
+			}
+			end, err := parsePostgresTime(matches[1])
+			if err != nil {
+				return nil, false, errors.Trace(err)
+			}
+			partitions = append(partitions, feedbackPartition{Name: name, End: end})
+		}
+		if err = rs.Err(); err != nil {
+			return nil, false, errors.Trace(err)
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].End.Before(partitions[j].End)
+	})
+	return partitions, partitioned, nil
+}
+
+// parsePostgresTime parses timestamps printed by PostgreSQL, whose time zone offsets omit minutes if zero.
+func parsePostgresTime(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05.999999-07:00"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.NotValidf("timestamp %s", value)
+}
+
+// isFeedbackPartitioned returns whether the feedback table is partitioned. Negative results are checked again after
+// partitionCheckInterval.
+func (d *SQLDatabase) isFeedbackPartitioned(ctx context.Context) (bool, error) {
+	if d.driver != MySQL && d.driver != Postgres {
+		return false, nil
+	}
+	d.partitionMutex.Lock()
+	defer d.partitionMutex.Unlock()
+	if d.partitioned || time.Since(d.partitionCheckTime) < partitionCheckInterval {
+		return d.partitioned, nil
+	}
+	_, partitioned, err := d.feedbackPartitions(ctx)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	d.partitioned, d.partitionCheckTime = partitioned, time.Now()
+	return partitioned, nil
+}
+
+// insertPartitionedFeedback inserts feedback into the partitioned table. Feedback with the same key might exist in
+// other partitions, so it is deleted before insertion if overwrite, otherwise the new feedback is skipped.
+func (d *SQLDatabase) insertPartitionedFeedback(ctx context.Context, rows []Feedback, overwrite bool) error {
+	return d.gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		keys := lo.Map(rows, func(f Feedback, _ int) []interface{} {
+			return []interface{}{f.FeedbackType, f.UserId, f.ItemId}
+		})
+		if overwrite {
+			err := tx.Table(d.FeedbackTable()).Where("(feedback_type, user_id, item_id) IN ?", keys).Delete(&Feedback{}).Error
+			if err != nil {
+				return errors.Trace(err)
+			}
+		} else {
+			var existed []FeedbackKey
+			err := tx.Table(d.FeedbackTable()).Select("feedback_type, user_id, item_id").
+				Where("(feedback_type, user_id, item_id) IN ?", keys).Find(&existed).Error
+			if err != nil {
+				return errors.Trace(err)
+			}
+			existedKeys := make(map[FeedbackKey]struct{}, len(existed))
+			for _, key := range existed {
+				existedKeys[key] = struct{}{}
+			}
+			rows = lo.Filter(rows, func(f Feedback, _ int) bool {
+				_, exist := existedKeys[f.FeedbackKey]
+				return !exist
+			})
+			if len(rows) == 0 {
+				return nil
+			}
+		}
+		return errors.Trace(tx.Clauses(clause.OnConflict{DoNothing: true}).Create(rows).Error)
+	})
+}
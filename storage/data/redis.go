@@ -27,9 +27,11 @@ import (
 )
 
 const (
-	prefixItem     = "item/"     // prefix for items
-	prefixUser     = "user/"     // prefix for users
-	prefixFeedback = "feedback/" // prefix for feedback
+	prefixItem     = "item/"         // prefix for items
+	prefixUser     = "user/"         // prefix for users
+	prefixFeedback = "feedback/"     // prefix for feedback
+	prefixHistory  = "item_history/" // prefix for sorted sets of item history
+	keyAuditLogs   = "audit_logs"    // sorted set of audit logs
 )
 
 // Redis use Redis as data storage, but used for test only.
@@ -542,11 +544,12 @@ func (r *Redis) BatchInsertFeedback(ctx context.Context, feedback []Feedback, in
 }
 
 // GetFeedback returns feedback from Redis.
-func (r *Redis) GetFeedback(ctx context.Context, _ string, _ int, beginTime, endTime *time.Time, feedbackTypes ...string) (string, []Feedback, error) {
+func (r *Redis) GetFeedback(ctx context.Context, _ string, _ int, beginTime, endTime *time.Time, userId, itemId string, feedbackTypes ...string) (string, []Feedback, error) {
 	feedback := make([]Feedback, 0)
 	feedbackTypeSet := strset.New(feedbackTypes...)
 	err := r.ForFeedback(ctx, func(key, thisFeedbackType, thisUserId, thisItemId string) error {
-		if feedbackTypeSet.IsEmpty() || feedbackTypeSet.Has(thisFeedbackType) {
+		if (userId == "" || thisUserId == userId) && (itemId == "" || thisItemId == itemId) &&
+			(feedbackTypeSet.IsEmpty() || feedbackTypeSet.Has(thisFeedbackType)) {
 			val, err := r.getFeedbackInternal(key)
 			if err != nil {
 				return errors.Trace(err)
@@ -640,7 +643,38 @@ func (r *Redis) ModifyItem(ctx context.Context, itemId string, patch ItemPatch)
 	if err != nil {
 		return err
 	}
-	// apply patch
+	// write back
+	return r.insertItem(ctx, patchItem(item, patch))
+}
+
+// ModifyItemIf modifies an item in Redis if the condition is satisfied. The item is watched during the check, so
+// that the transaction fails if the item has been modified concurrently.
+func (r *Redis) ModifyItemIf(ctx context.Context, itemId string, patch ItemPatch, condition func(Item) bool) error {
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		item, err := r.GetItem(ctx, itemId)
+		if err != nil {
+			return err
+		}
+		if !condition(item) {
+			return errors.Annotate(ErrPreconditionFailed, itemId)
+		}
+		data, err := json.Marshal(patchItem(item, patch))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, prefixItem+itemId, data, 0)
+			return nil
+		})
+		return err
+	}, prefixItem+itemId)
+	if err == redis.TxFailedErr {
+		return errors.Annotate(ErrPreconditionFailed, itemId)
+	}
+	return err
+}
+
+func patchItem(item Item, patch ItemPatch) Item {
 	if patch.IsHidden != nil {
 		item.IsHidden = *patch.IsHidden
 	}
@@ -656,8 +690,13 @@ func (r *Redis) ModifyItem(ctx context.Context, itemId string, patch ItemPatch)
 	if patch.Timestamp != nil {
 		item.Timestamp = *patch.Timestamp
 	}
-	// write back
-	return r.insertItem(ctx, item)
+	if patch.Latitude != nil {
+		item.Latitude = patch.Latitude
+	}
+	if patch.Longitude != nil {
+		item.Longitude = patch.Longitude
+	}
+	return item
 }
 
 // ModifyUser modify a user in Redis.
@@ -667,7 +706,38 @@ func (r *Redis) ModifyUser(ctx context.Context, userId string, patch UserPatch)
 	if err != nil {
 		return err
 	}
-	// apply patch
+	// write back
+	return r.insertUser(ctx, patchUser(user, patch))
+}
+
+// ModifyUserIf modifies a user in Redis if the condition is satisfied. The user is watched during the check, so
+// that the transaction fails if the user has been modified concurrently.
+func (r *Redis) ModifyUserIf(ctx context.Context, userId string, patch UserPatch, condition func(User) bool) error {
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		user, err := r.GetUser(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if !condition(user) {
+			return errors.Annotate(ErrPreconditionFailed, userId)
+		}
+		data, err := json.Marshal(patchUser(user, patch))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, prefixUser+userId, data, 0)
+			return nil
+		})
+		return err
+	}, prefixUser+userId)
+	if err == redis.TxFailedErr {
+		return errors.Annotate(ErrPreconditionFailed, userId)
+	}
+	return err
+}
+
+func patchUser(user User, patch UserPatch) User {
 	if patch.Comment != nil {
 		user.Comment = *patch.Comment
 	}
@@ -677,6 +747,72 @@ func (r *Redis) ModifyUser(ctx context.Context, userId string, patch UserPatch)
 	if patch.Subscribe != nil {
 		user.Subscribe = patch.Subscribe
 	}
-	// write back
-	return r.insertUser(ctx, user)
+	return user
+}
+
+// InsertItemHistory inserts previous versions of items into Redis.
+func (r *Redis) InsertItemHistory(ctx context.Context, history []ItemHistory) error {
+	for _, h := range history {
+		data, err := json.Marshal(h)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = r.client.ZAdd(ctx, prefixHistory+h.ItemId, redis.Z{Score: float64(h.ModifyTime.UnixNano()), Member: data}).Err(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// GetItemHistory returns the latest n previous versions of an item from Redis.
+func (r *Redis) GetItemHistory(ctx context.Context, itemId string, n int) ([]ItemHistory, error) {
+	members, err := r.client.ZRevRange(ctx, prefixHistory+itemId, 0, int64(n-1)).Result()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	history := make([]ItemHistory, len(members))
+	for i, member := range members {
+		if err = json.Unmarshal([]byte(member), &history[i]); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return history, nil
+}
+
+// InsertAuditLogs inserts audit logs into Redis.
+func (r *Redis) InsertAuditLogs(ctx context.Context, logs []AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	members := make([]redis.Z, len(logs))
+	for i, l := range logs {
+		data, err := json.Marshal(l)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		members[i] = redis.Z{Score: float64(l.Timestamp.UnixNano()), Member: data}
+	}
+	return r.client.ZAdd(ctx, keyAuditLogs, members...).Err()
+}
+
+// GetAuditLogs returns the latest n audit logs from Redis.
+func (r *Redis) GetAuditLogs(ctx context.Context, n int, beginTime, endTime *time.Time) ([]AuditLog, error) {
+	opt := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: int64(n)}
+	if beginTime != nil {
+		opt.Min = strconv.FormatInt(beginTime.UnixNano(), 10)
+	}
+	if endTime != nil {
+		opt.Max = strconv.FormatInt(endTime.UnixNano(), 10)
+	}
+	members, err := r.client.ZRevRangeByScore(ctx, keyAuditLogs, opt).Result()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	logs := make([]AuditLog, len(members))
+	for i, member := range members {
+		if err = json.Unmarshal([]byte(member), &logs[i]); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return logs, nil
 }
@@ -32,6 +32,8 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	_ "modernc.org/sqlite"
+	"net/url"
+	"sync"
 	"time"
 )
 
@@ -54,6 +56,8 @@ type SQLItem struct {
 	Timestamp  time.Time `gorm:"column:time_stamp"`
 	Labels     string    `gorm:"column:labels"`
 	Comment    string    `gorm:"column:comment"`
+	Latitude   *float64  `gorm:"column:latitude"`
+	Longitude  *float64  `gorm:"column:longitude"`
 }
 
 func NewSQLItem(item Item) (sqlItem SQLItem) {
@@ -66,6 +70,8 @@ func NewSQLItem(item Item) (sqlItem SQLItem) {
 	buf, _ = json.Marshal(item.Labels)
 	sqlItem.Labels = string(buf)
 	sqlItem.Comment = item.Comment
+	sqlItem.Latitude = item.Latitude
+	sqlItem.Longitude = item.Longitude
 	return
 }
 
@@ -87,6 +93,30 @@ func NewSQLUser(user User) (sqlUser SQLUser) {
 	return
 }
 
+type SQLItemHistory struct {
+	ItemId     string    `gorm:"column:item_id"`
+	IsHidden   bool      `gorm:"column:is_hidden"`
+	Categories string    `gorm:"column:categories"`
+	Timestamp  time.Time `gorm:"column:time_stamp"`
+	Labels     string    `gorm:"column:labels"`
+	Comment    string    `gorm:"column:comment"`
+	ModifyTime time.Time `gorm:"column:modify_time"`
+}
+
+func NewSQLItemHistory(history ItemHistory) (sqlItemHistory SQLItemHistory) {
+	var buf []byte
+	sqlItemHistory.ItemId = history.ItemId
+	sqlItemHistory.IsHidden = history.IsHidden
+	buf, _ = json.Marshal(history.Categories)
+	sqlItemHistory.Categories = string(buf)
+	sqlItemHistory.Timestamp = history.Timestamp
+	buf, _ = json.Marshal(history.Labels)
+	sqlItemHistory.Labels = string(buf)
+	sqlItemHistory.Comment = history.Comment
+	sqlItemHistory.ModifyTime = history.ModifyTime
+	return
+}
+
 type ClickHouseItem struct {
 	SQLItem `gorm:"embedded"`
 	Version time.Time `gorm:"column:version"`
@@ -118,9 +148,26 @@ type ClickHouseFeedback struct {
 // SQLDatabase use MySQL as data storage.
 type SQLDatabase struct {
 	storage.TablePrefix
-	gormDB *gorm.DB
-	client *sql.DB
-	driver SQLDriver
+	gormDB  *gorm.DB
+	client  *sql.DB
+	driver  SQLDriver
+	replica *SQLDatabase // read replica for scans, nil if not configured
+
+	clickhouseURL *url.URL // HTTP endpoint of ClickHouse for bulk reads
+	queryLog      QueryLogOptions
+
+	partitionMutex     sync.Mutex
+	partitioned        bool // whether the feedback table is partitioned
+	partitionCheckTime time.Time
+}
+
+// reader returns the connection to scan items, users and feedback. Scans are sent to the read replica if configured,
+// otherwise the primary.
+func (d *SQLDatabase) reader() *gorm.DB {
+	if d.replica != nil {
+		return d.replica.gormDB
+	}
+	return d.gormDB
 }
 
 // Optimize is used by ClickHouse only.
@@ -136,8 +183,13 @@ func (d *SQLDatabase) Optimize() error {
 	return nil
 }
 
-// Init tables and indices in MySQL.
+// Init applies all migrations to the SQL database.
 func (d *SQLDatabase) Init() error {
+	return d.Migrate(context.Background(), LatestVersion(d.Migrations()))
+}
+
+// createTables creates tables and indices. Existing tables are kept.
+func (d *SQLDatabase) createTables(ctx context.Context) error {
 	switch d.driver {
 	case MySQL:
 		// create tables
@@ -148,6 +200,8 @@ func (d *SQLDatabase) Init() error {
 			Timestamp  time.Time `gorm:"column:time_stamp;type:datetime;not null"`
 			Labels     []string  `gorm:"column:labels;type:json;not null"`
 			Comment    string    `gorm:"column:comment;type:text;not null"`
+			Latitude   *float64  `gorm:"column:latitude;type:double"`
+			Longitude  *float64  `gorm:"column:longitude;type:double"`
 		}
 		type Users struct {
 			UserId    string   `gorm:"column:user_id;type:varchar(256);not null;primaryKey"`
@@ -157,12 +211,38 @@ func (d *SQLDatabase) Init() error {
 		}
 		type Feedback struct {
 			FeedbackType string    `gorm:"column:feedback_type;type:varchar(256);not null;primaryKey"`
-			UserId       string    `gorm:"column:user_id;type:varchar(256);not null;primaryKey;index:user_id"`
-			ItemId       string    `gorm:"column:item_id;type:varchar(256);not null;primaryKey;index:item_id"`
-			Timestamp    time.Time `gorm:"column:time_stamp;type:datetime;not null"`
+			UserId       string    `gorm:"column:user_id;type:varchar(256);not null;primaryKey;index:user_id_time_stamp,priority:1"`
+			ItemId       string    `gorm:"column:item_id;type:varchar(256);not null;primaryKey;index:item_id_time_stamp,priority:1"`
+			Timestamp    time.Time `gorm:"column:time_stamp;type:datetime;not null;index:user_id_time_stamp,priority:2;index:item_id_time_stamp,priority:2"`
 			Comment      string    `gorm:"column:comment;type:text;not null"`
 		}
-		err := d.gormDB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(Users{}, Items{}, Feedback{})
+		type ItemHistory struct {
+			ItemId     string    `gorm:"column:item_id;type:varchar(256);not null;index:item_id"`
+			IsHidden   bool      `gorm:"column:is_hidden;type:bool;not null"`
+			Categories []string  `gorm:"column:categories;type:json;not null"`
+			Timestamp  time.Time `gorm:"column:time_stamp;type:datetime;not null"`
+			Labels     []string  `gorm:"column:labels;type:json;not null"`
+			Comment    string    `gorm:"column:comment;type:text;not null"`
+			ModifyTime time.Time `gorm:"column:modify_time;type:datetime(6);not null"`
+		}
+		type AuditLogs struct {
+			Timestamp time.Time `gorm:"column:time_stamp;type:datetime(6);not null;index:time_stamp"`
+			Actor     string    `gorm:"column:actor;type:varchar(256);not null"`
+			Method    string    `gorm:"column:method;type:varchar(16);not null"`
+			Path      string    `gorm:"column:path;type:text;not null"`
+			Status    int       `gorm:"column:status;type:int;not null"`
+			Digest    string    `gorm:"column:digest;type:varchar(64);not null"`
+		}
+		// partitioned feedback table is created by PartitionFeedback with its own keys
+		_, partitioned, err := d.feedbackPartitions(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tables := []interface{}{Users{}, Items{}, ItemHistory{}, AuditLogs{}}
+		if !partitioned {
+			tables = append(tables, Feedback{})
+		}
+		err = d.gormDB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(tables...)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -175,6 +255,8 @@ func (d *SQLDatabase) Init() error {
 			Timestamp  time.Time `gorm:"column:time_stamp;type:timestamptz;not null"`
 			Labels     string    `gorm:"column:labels;type:json;not null;default:'[]'"`
 			Comment    string    `gorm:"column:comment;type:text;not null;default:''"`
+			Latitude   *float64  `gorm:"column:latitude;type:double precision"`
+			Longitude  *float64  `gorm:"column:longitude;type:double precision"`
 		}
 		type Users struct {
 			UserId    string `gorm:"column:user_id;type:varchar(256) not null;primaryKey"`
@@ -184,24 +266,52 @@ func (d *SQLDatabase) Init() error {
 		}
 		type Feedback struct {
 			FeedbackType string    `gorm:"column:feedback_type;type:varchar(256);not null;primaryKey"`
-			UserId       string    `gorm:"column:user_id;type:varchar(256);not null;primaryKey;index:user_id_index"`
-			ItemId       string    `gorm:"column:item_id;type:varchar(256);not null;primaryKey;index:item_id_index"`
-			Timestamp    time.Time `gorm:"column:time_stamp;type:timestamptz;not null"`
+			UserId       string    `gorm:"column:user_id;type:varchar(256);not null;primaryKey;index:user_id_time_stamp_index,priority:1"`
+			ItemId       string    `gorm:"column:item_id;type:varchar(256);not null;primaryKey;index:item_id_time_stamp_index,priority:1"`
+			Timestamp    time.Time `gorm:"column:time_stamp;type:timestamptz;not null;index:user_id_time_stamp_index,priority:2;index:item_id_time_stamp_index,priority:2"`
 			Comment      string    `gorm:"column:comment;type:text;not null;default:''"`
 		}
-		err := d.gormDB.AutoMigrate(Users{}, Items{}, Feedback{})
+		type ItemHistory struct {
+			ItemId     string    `gorm:"column:item_id;type:varchar(256);not null;index:item_history_item_id_index"`
+			IsHidden   bool      `gorm:"column:is_hidden;type:bool;not null;default:false"`
+			Categories string    `gorm:"column:categories;type:json;not null;default:'[]'"`
+			Timestamp  time.Time `gorm:"column:time_stamp;type:timestamptz;not null"`
+			Labels     string    `gorm:"column:labels;type:json;not null;default:'[]'"`
+			Comment    string    `gorm:"column:comment;type:text;not null;default:''"`
+			ModifyTime time.Time `gorm:"column:modify_time;type:timestamptz;not null"`
+		}
+		type AuditLogs struct {
+			Timestamp time.Time `gorm:"column:time_stamp;type:timestamptz;not null;index:audit_logs_time_stamp_index"`
+			Actor     string    `gorm:"column:actor;type:varchar(256);not null"`
+			Method    string    `gorm:"column:method;type:varchar(16);not null"`
+			Path      string    `gorm:"column:path;type:text;not null"`
+			Status    int       `gorm:"column:status;type:integer;not null"`
+			Digest    string    `gorm:"column:digest;type:varchar(64);not null"`
+		}
+		// partitioned feedback table is created by PartitionFeedback with its own keys
+		_, partitioned, err := d.feedbackPartitions(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tables := []interface{}{Users{}, Items{}, ItemHistory{}, AuditLogs{}}
+		if !partitioned {
+			tables = append(tables, Feedback{})
+		}
+		err = d.gormDB.AutoMigrate(tables...)
 		if err != nil {
 			return errors.Trace(err)
 		}
 	case SQLite:
 		// create tables
 		type Items struct {
-			ItemId     string `gorm:"column:item_id;type:varchar(256);not null;primaryKey"`
-			IsHidden   bool   `gorm:"column:is_hidden;type:bool;not null;default:false"`
-			Categories string `gorm:"column:categories;type:json;not null;default:'[]'"`
-			Timestamp  string `gorm:"column:time_stamp;type:datetime;not null;default:'0001-01-01'"`
-			Labels     string `gorm:"column:labels;type:json;not null;default:'[]'"`
-			Comment    string `gorm:"column:comment;type:text;not null;default:''"`
+			ItemId     string   `gorm:"column:item_id;type:varchar(256);not null;primaryKey"`
+			IsHidden   bool     `gorm:"column:is_hidden;type:bool;not null;default:false"`
+			Categories string   `gorm:"column:categories;type:json;not null;default:'[]'"`
+			Timestamp  string   `gorm:"column:time_stamp;type:datetime;not null;default:'0001-01-01'"`
+			Labels     string   `gorm:"column:labels;type:json;not null;default:'[]'"`
+			Comment    string   `gorm:"column:comment;type:text;not null;default:''"`
+			Latitude   *float64 `gorm:"column:latitude;type:real"`
+			Longitude  *float64 `gorm:"column:longitude;type:real"`
 		}
 		type Users struct {
 			UserId    string `gorm:"column:user_id;type:varchar(256) not null;primaryKey"`
@@ -216,7 +326,24 @@ func (d *SQLDatabase) Init() error {
 			Timestamp    string `gorm:"column:time_stamp;type:datetime;not null;default:'0001-01-01'"`
 			Comment      string `gorm:"column:comment;type:text;not null;default:''"`
 		}
-		err := d.gormDB.AutoMigrate(Users{}, Items{}, Feedback{})
+		type ItemHistory struct {
+			ItemId     string `gorm:"column:item_id;type:varchar(256);not null;index:item_history_item_id_index"`
+			IsHidden   bool   `gorm:"column:is_hidden;type:bool;not null;default:false"`
+			Categories string `gorm:"column:categories;type:json;not null;default:'[]'"`
+			Timestamp  string `gorm:"column:time_stamp;type:datetime;not null;default:'0001-01-01'"`
+			Labels     string `gorm:"column:labels;type:json;not null;default:'[]'"`
+			Comment    string `gorm:"column:comment;type:text;not null;default:''"`
+			ModifyTime string `gorm:"column:modify_time;type:datetime;not null;default:'0001-01-01'"`
+		}
+		type AuditLogs struct {
+			Timestamp string `gorm:"column:time_stamp;type:datetime;not null;default:'0001-01-01';index:audit_logs_time_stamp_index"`
+			Actor     string `gorm:"column:actor;type:varchar(256);not null;default:''"`
+			Method    string `gorm:"column:method;type:varchar(16);not null;default:''"`
+			Path      string `gorm:"column:path;type:text;not null;default:''"`
+			Status    int    `gorm:"column:status;type:integer;not null;default:0"`
+			Digest    string `gorm:"column:digest;type:varchar(64);not null;default:''"`
+		}
+		err := d.gormDB.AutoMigrate(Users{}, Items{}, Feedback{}, ItemHistory{}, AuditLogs{})
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -229,6 +356,8 @@ func (d *SQLDatabase) Init() error {
 			Timestamp  time.Time `gorm:"column:TIME_STAMP;type:TIMESTAMP;not null"`
 			Labels     []string  `gorm:"column:LABELS;type:varchar2(4000);not null"`
 			Comment    string    `gorm:"column:\"COMMENT\";type:varchar2(4000)"`
+			Latitude   *float64  `gorm:"column:LATITUDE;type:binary_double"`
+			Longitude  *float64  `gorm:"column:LONGITUDE;type:binary_double"`
 		}
 		type Users struct {
 			UserId    string   `gorm:"column:USER_ID;type:varchar2(256);not null;primaryKey"`
@@ -243,7 +372,24 @@ func (d *SQLDatabase) Init() error {
 			Timestamp    time.Time `gorm:"column:TIME_STAMP;type:TIMESTAMP;not null"`
 			Comment      string    `gorm:"column:\"COMMENT\";type:varchar2(4000)"`
 		}
-		err := d.gormDB.AutoMigrate(Users{}, Items{}, Feedback{})
+		type ItemHistory struct {
+			ItemId     string    `gorm:"column:ITEM_ID;type:varchar2(256);not null;index:item_history_item_id_index"`
+			IsHidden   bool      `gorm:"column:IS_HIDDEN;type:bool;not null"`
+			Categories []string  `gorm:"column:CATEGORIES;type:varchar2(4000);not null"`
+			Timestamp  time.Time `gorm:"column:TIME_STAMP;type:TIMESTAMP;not null"`
+			Labels     []string  `gorm:"column:LABELS;type:varchar2(4000);not null"`
+			Comment    string    `gorm:"column:\"COMMENT\";type:varchar2(4000)"`
+			ModifyTime time.Time `gorm:"column:MODIFY_TIME;type:TIMESTAMP;not null"`
+		}
+		type AuditLogs struct {
+			Timestamp time.Time `gorm:"column:TIME_STAMP;type:TIMESTAMP;not null;index:audit_logs_time_stamp_index"`
+			Actor     string    `gorm:"column:ACTOR;type:varchar2(256);not null"`
+			Method    string    `gorm:"column:METHOD;type:varchar2(16);not null"`
+			Path      string    `gorm:"column:PATH;type:varchar2(4000);not null"`
+			Status    int       `gorm:"column:STATUS;type:integer;not null"`
+			Digest    string    `gorm:"column:DIGEST;type:varchar2(64);not null"`
+		}
+		err := d.gormDB.AutoMigrate(Users{}, Items{}, Feedback{}, ItemHistory{}, AuditLogs{})
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -256,6 +402,8 @@ func (d *SQLDatabase) Init() error {
 			Timestamp  time.Time `gorm:"column:time_stamp;type:Datetime"`
 			Labels     string    `gorm:"column:labels;type:String;default:'[]'"`
 			Comment    string    `gorm:"column:comment;type:String"`
+			Latitude   *float64  `gorm:"column:latitude;type:Nullable(Float64)"`
+			Longitude  *float64  `gorm:"column:longitude;type:Nullable(Float64)"`
 			Version    struct{}  `gorm:"column:version;type:DateTime"`
 		}
 		err := d.gormDB.Set("gorm:table_options", "ENGINE = ReplacingMergeTree(version) ORDER BY item_id").AutoMigrate(Items{})
@@ -285,21 +433,56 @@ func (d *SQLDatabase) Init() error {
 		if err != nil {
 			return errors.Trace(err)
 		}
+		type ItemHistory struct {
+			ItemId     string    `gorm:"column:item_id;type:String"`
+			IsHidden   int       `gorm:"column:is_hidden;type:Boolean;default:0"`
+			Categories string    `gorm:"column:categories;type:String;default:'[]'"`
+			Timestamp  time.Time `gorm:"column:time_stamp;type:Datetime"`
+			Labels     string    `gorm:"column:labels;type:String;default:'[]'"`
+			Comment    string    `gorm:"column:comment;type:String"`
+			ModifyTime time.Time `gorm:"column:modify_time;type:DateTime64(6)"`
+		}
+		err = d.gormDB.Set("gorm:table_options", "ENGINE = MergeTree() ORDER BY (item_id, modify_time)").AutoMigrate(ItemHistory{})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		type AuditLogs struct {
+			Timestamp time.Time `gorm:"column:time_stamp;type:DateTime"`
+			Actor     string    `gorm:"column:actor;type:String"`
+			Method    string    `gorm:"column:method;type:String"`
+			Path      string    `gorm:"column:path;type:String"`
+			Status    int       `gorm:"column:status;type:Int32"`
+			Digest    string    `gorm:"column:digest;type:String"`
+		}
+		err = d.gormDB.Set("gorm:table_options", "ENGINE = MergeTree() ORDER BY time_stamp").AutoMigrate(AuditLogs{})
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
 	return nil
 }
 
 func (d *SQLDatabase) Ping() error {
+	if d.replica != nil {
+		if err := d.replica.Ping(); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return d.client.Ping()
 }
 
 // Close MySQL connection.
 func (d *SQLDatabase) Close() error {
+	if d.replica != nil {
+		if err := d.replica.Close(); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return d.client.Close()
 }
 
 func (d *SQLDatabase) Purge() error {
-	tables := []string{d.ItemsTable(), d.FeedbackTable(), d.UsersTable()}
+	tables := []string{d.ItemsTable(), d.FeedbackTable(), d.UsersTable(), d.ItemHistoryTable(), d.AuditLogsTable()}
 	if d.driver == ClickHouse {
 		for _, tableName := range tables {
 			err := d.gormDB.Exec(fmt.Sprintf("alter table %s delete where 1=1", tableName)).Error
@@ -349,7 +532,7 @@ func (d *SQLDatabase) BatchInsertItems(ctx context.Context, items []Item) error
 		}
 		err := d.gormDB.WithContext(ctx).Clauses(clause.OnConflict{
 			Columns:   []clause.Column{{Name: "item_id"}},
-			DoUpdates: clause.AssignmentColumns([]string{"is_hidden", "categories", "time_stamp", "labels", "comment"}),
+			DoUpdates: clause.AssignmentColumns([]string{"is_hidden", "categories", "time_stamp", "labels", "comment", "latitude", "longitude"}),
 		}).Create(rows).Error
 		return errors.Trace(err)
 	}
@@ -360,7 +543,7 @@ func (d *SQLDatabase) BatchGetItems(ctx context.Context, itemIds []string) ([]It
 		return nil, nil
 	}
 	result, err := d.gormDB.WithContext(ctx).Table(d.ItemsTable()).
-		Select("item_id, is_hidden, categories, time_stamp, labels, comment").
+		Select("item_id, is_hidden, categories, time_stamp, labels, comment, latitude, longitude").
 		Where("item_id IN ?", itemIds).Rows()
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -370,7 +553,7 @@ func (d *SQLDatabase) BatchGetItems(ctx context.Context, itemIds []string) ([]It
 	for result.Next() {
 		var item Item
 		var labels, categories string
-		if err = result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &item.Comment); err != nil {
+		if err = result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &item.Comment, &item.Latitude, &item.Longitude); err != nil {
 			return nil, errors.Trace(err)
 		}
 		if err = json.Unmarshal([]byte(labels), &item.Labels); err != nil {
@@ -397,9 +580,13 @@ func (d *SQLDatabase) DeleteItem(ctx context.Context, itemId string) error {
 
 // GetItem get a item from MySQL.
 func (d *SQLDatabase) GetItem(ctx context.Context, itemId string) (Item, error) {
+	return d.getItem(d.gormDB.WithContext(ctx), itemId)
+}
+
+func (d *SQLDatabase) getItem(tx *gorm.DB, itemId string) (Item, error) {
 	var result *sql.Rows
 	var err error
-	result, err = d.gormDB.WithContext(ctx).Table(d.ItemsTable()).Select("item_id, is_hidden, categories, time_stamp, labels, comment").Where("item_id = ?", itemId).Rows()
+	result, err = tx.Table(d.ItemsTable()).Select("item_id, is_hidden, categories, time_stamp, labels, comment, latitude, longitude").Where("item_id = ?", itemId).Rows()
 	if err != nil {
 		return Item{}, errors.Trace(err)
 	}
@@ -408,7 +595,7 @@ func (d *SQLDatabase) GetItem(ctx context.Context, itemId string) (Item, error)
 		var item Item
 		var labels, categories string
 		var comment sql.NullString
-		if err := result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &comment); err != nil {
+		if err := result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &comment, &item.Latitude, &item.Longitude); err != nil {
 			return Item{}, errors.Trace(err)
 		}
 		if err := json.Unmarshal([]byte(labels), &item.Labels); err != nil {
@@ -425,8 +612,42 @@ func (d *SQLDatabase) GetItem(ctx context.Context, itemId string) (Item, error)
 
 // ModifyItem modify an item in MySQL.
 func (d *SQLDatabase) ModifyItem(ctx context.Context, itemId string, patch ItemPatch) error {
+	return d.modifyItem(d.gormDB.WithContext(ctx), itemId, patch)
+}
+
+// ModifyItemIf modifies an item in MySQL if the condition is satisfied. The item is locked in a transaction until
+// modified, except in ClickHouse which doesn't support transactions.
+func (d *SQLDatabase) ModifyItemIf(ctx context.Context, itemId string, patch ItemPatch, condition func(Item) bool) error {
+	if d.driver == ClickHouse {
+		item, err := d.GetItem(ctx, itemId)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !condition(item) {
+			return errors.Annotate(ErrPreconditionFailed, itemId)
+		}
+		return d.ModifyItem(ctx, itemId, patch)
+	}
+	return d.gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// lock the item before reading
+		if err := tx.Table(d.ItemsTable()).Where("item_id = ?", itemId).Update("item_id", gorm.Expr("item_id")).Error; err != nil {
+			return errors.Trace(err)
+		}
+		item, err := d.getItem(tx, itemId)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !condition(item) {
+			return errors.Annotate(ErrPreconditionFailed, itemId)
+		}
+		return d.modifyItem(tx, itemId, patch)
+	})
+}
+
+func (d *SQLDatabase) modifyItem(tx *gorm.DB, itemId string, patch ItemPatch) error {
 	// ignore empty patch
-	if patch.IsHidden == nil && patch.Categories == nil && patch.Labels == nil && patch.Comment == nil && patch.Timestamp == nil {
+	if patch.IsHidden == nil && patch.Categories == nil && patch.Labels == nil && patch.Comment == nil && patch.Timestamp == nil &&
+		patch.Latitude == nil && patch.Longitude == nil {
 		log.Logger().Debug("empty item patch")
 		return nil
 	}
@@ -449,6 +670,12 @@ func (d *SQLDatabase) ModifyItem(ctx context.Context, itemId string, patch ItemP
 		text, _ := json.Marshal(patch.Labels)
 		attributes["labels"] = string(text)
 	}
+	if patch.Latitude != nil {
+		attributes["latitude"] = *patch.Latitude
+	}
+	if patch.Longitude != nil {
+		attributes["longitude"] = *patch.Longitude
+	}
 	if patch.Timestamp != nil {
 		switch d.driver {
 		case ClickHouse, SQLite, Oracle:
@@ -457,7 +684,7 @@ func (d *SQLDatabase) ModifyItem(ctx context.Context, itemId string, patch ItemP
 			attributes["time_stamp"] = patch.Timestamp
 		}
 	}
-	err := d.gormDB.WithContext(ctx).Model(&SQLItem{ItemId: itemId}).Updates(attributes).Error
+	err := tx.Model(&SQLItem{ItemId: itemId}).Updates(attributes).Error
 	return errors.Trace(err)
 }
 
@@ -468,7 +695,7 @@ func (d *SQLDatabase) GetItems(ctx context.Context, cursor string, n int, timeLi
 		return "", nil, errors.Trace(err)
 	}
 	cursorItem := string(buf)
-	tx := d.gormDB.WithContext(ctx).Table(d.ItemsTable()).Select("item_id, is_hidden, categories, time_stamp, labels, comment")
+	tx := d.reader().WithContext(ctx).Table(d.ItemsTable()).Select("item_id, is_hidden, categories, time_stamp, labels, comment, latitude, longitude")
 	if cursorItem != "" {
 		tx.Where("item_id >= ?", cursorItem)
 	}
@@ -485,7 +712,7 @@ func (d *SQLDatabase) GetItems(ctx context.Context, cursor string, n int, timeLi
 		var item Item
 		var labels, categories string
 		var comment sql.NullString
-		if err = result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &comment); err != nil {
+		if err = result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &comment, &item.Latitude, &item.Longitude); err != nil {
 			return "", nil, errors.Trace(err)
 		}
 		if err = json.Unmarshal([]byte(labels), &item.Labels); err != nil {
@@ -511,7 +738,7 @@ func (d *SQLDatabase) GetItemStream(ctx context.Context, batchSize int, timeLimi
 		defer close(itemChan)
 		defer close(errChan)
 		// send query
-		tx := d.gormDB.WithContext(ctx).Table(d.ItemsTable()).Select("item_id, is_hidden, categories, time_stamp, labels, comment")
+		tx := d.reader().WithContext(ctx).Table(d.ItemsTable()).Select("item_id, is_hidden, categories, time_stamp, labels, comment, latitude, longitude")
 		if timeLimit != nil {
 			tx.Where("time_stamp >= ?", *timeLimit)
 		}
@@ -526,7 +753,7 @@ func (d *SQLDatabase) GetItemStream(ctx context.Context, batchSize int, timeLimi
 		for result.Next() {
 			var item Item
 			var labels, categories string
-			if err = result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &item.Comment); err != nil {
+			if err = result.Scan(&item.ItemId, &item.IsHidden, &categories, &item.Timestamp, &labels, &item.Comment, &item.Latitude, &item.Longitude); err != nil {
 				errChan <- errors.Trace(err)
 				return
 			}
@@ -628,9 +855,13 @@ func (d *SQLDatabase) DeleteUser(ctx context.Context, userId string) error {
 
 // GetUser returns a user from MySQL.
 func (d *SQLDatabase) GetUser(ctx context.Context, userId string) (User, error) {
+	return d.getUser(d.gormDB.WithContext(ctx), userId)
+}
+
+func (d *SQLDatabase) getUser(tx *gorm.DB, userId string) (User, error) {
 	var result *sql.Rows
 	var err error
-	result, err = d.gormDB.WithContext(ctx).Table(d.UsersTable()).
+	result, err = tx.Table(d.UsersTable()).
 		Select("user_id, labels, subscribe, comment").
 		Where("user_id = ?", userId).Rows()
 	if err != nil {
@@ -657,6 +888,39 @@ func (d *SQLDatabase) GetUser(ctx context.Context, userId string) (User, error)
 
 // ModifyUser modify a user in MySQL.
 func (d *SQLDatabase) ModifyUser(ctx context.Context, userId string, patch UserPatch) error {
+	return d.modifyUser(d.gormDB.WithContext(ctx), userId, patch)
+}
+
+// ModifyUserIf modifies a user in MySQL if the condition is satisfied. The user is locked in a transaction until
+// modified, except in ClickHouse which doesn't support transactions.
+func (d *SQLDatabase) ModifyUserIf(ctx context.Context, userId string, patch UserPatch, condition func(User) bool) error {
+	if d.driver == ClickHouse {
+		user, err := d.GetUser(ctx, userId)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !condition(user) {
+			return errors.Annotate(ErrPreconditionFailed, userId)
+		}
+		return d.ModifyUser(ctx, userId, patch)
+	}
+	return d.gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// lock the user before reading
+		if err := tx.Table(d.UsersTable()).Where("user_id = ?", userId).Update("user_id", gorm.Expr("user_id")).Error; err != nil {
+			return errors.Trace(err)
+		}
+		user, err := d.getUser(tx, userId)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if !condition(user) {
+			return errors.Annotate(ErrPreconditionFailed, userId)
+		}
+		return d.modifyUser(tx, userId, patch)
+	})
+}
+
+func (d *SQLDatabase) modifyUser(tx *gorm.DB, userId string, patch UserPatch) error {
 	// ignore empty patch
 	if patch.Labels == nil && patch.Subscribe == nil && patch.Comment == nil {
 		log.Logger().Debug("empty user patch")
@@ -674,7 +938,7 @@ func (d *SQLDatabase) ModifyUser(ctx context.Context, userId string, patch UserP
 		text, _ := json.Marshal(patch.Subscribe)
 		attributes["subscribe"] = string(text)
 	}
-	err := d.gormDB.WithContext(ctx).Model(&SQLUser{UserId: userId}).Updates(attributes).Error
+	err := tx.Model(&SQLUser{UserId: userId}).Updates(attributes).Error
 	return errors.Trace(err)
 }
 
@@ -685,7 +949,7 @@ func (d *SQLDatabase) GetUsers(ctx context.Context, cursor string, n int) (strin
 		return "", nil, errors.Trace(err)
 	}
 	cursorUser := string(buf)
-	tx := d.gormDB.WithContext(ctx).Table(d.UsersTable()).Select("user_id, labels, subscribe, comment")
+	tx := d.reader().WithContext(ctx).Table(d.UsersTable()).Select("user_id, labels, subscribe, comment")
 	if cursorUser != "" {
 		tx.Where("user_id >= ?", cursorUser)
 	}
@@ -725,7 +989,7 @@ func (d *SQLDatabase) GetUserStream(ctx context.Context, batchSize int) (chan []
 		defer close(userChan)
 		defer close(errChan)
 		// send query
-		result, err := d.gormDB.WithContext(ctx).Table(d.UsersTable()).Select("user_id, labels, subscribe, comment").Rows()
+		result, err := d.reader().WithContext(ctx).Table(d.UsersTable()).Select("user_id, labels, subscribe, comment").Rows()
 		if err != nil {
 			errChan <- errors.Trace(err)
 			return
@@ -934,6 +1198,11 @@ func (d *SQLDatabase) BatchInsertFeedback(ctx context.Context, feedback []Feedba
 		if len(rows) == 0 {
 			return nil
 		}
+		if partitioned, err := d.isFeedbackPartitioned(ctx); err != nil {
+			return errors.Trace(err)
+		} else if partitioned {
+			return errors.Trace(d.insertPartitionedFeedback(ctx, rows, overwrite))
+		}
 		err := tx.Clauses(clause.OnConflict{
 			Columns:   []clause.Column{{Name: "feedback_type"}, {Name: "user_id"}, {Name: "item_id"}},
 			DoNothing: !overwrite,
@@ -944,12 +1213,12 @@ func (d *SQLDatabase) BatchInsertFeedback(ctx context.Context, feedback []Feedba
 }
 
 // GetFeedback returns feedback from MySQL.
-func (d *SQLDatabase) GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, feedbackTypes ...string) (string, []Feedback, error) {
+func (d *SQLDatabase) GetFeedback(ctx context.Context, cursor string, n int, beginTime, endTime *time.Time, userId, itemId string, feedbackTypes ...string) (string, []Feedback, error) {
 	buf, err := base64.StdEncoding.DecodeString(cursor)
 	if err != nil {
 		return "", nil, errors.Trace(err)
 	}
-	tx := d.gormDB.WithContext(ctx).Table(d.FeedbackTable()).Select("feedback_type, user_id, item_id, time_stamp, comment")
+	tx := d.reader().WithContext(ctx).Table(d.FeedbackTable()).Select("feedback_type, user_id, item_id, time_stamp, comment")
 	if len(buf) > 0 {
 		var cursorKey FeedbackKey
 		if err := json.Unmarshal(buf, &cursorKey); err != nil {
@@ -967,6 +1236,12 @@ func (d *SQLDatabase) GetFeedback(ctx context.Context, cursor string, n int, beg
 	if len(feedbackTypes) > 0 {
 		tx.Where("feedback_type IN ?", feedbackTypes)
 	}
+	if userId != "" {
+		tx.Where("user_id = ?", userId)
+	}
+	if itemId != "" {
+		tx.Where("item_id = ?", itemId)
+	}
 	if beginTime != nil {
 		tx.Where("time_stamp >= ?", d.convertTimeZone(beginTime))
 	}
@@ -1002,13 +1277,16 @@ func (d *SQLDatabase) GetFeedback(ctx context.Context, cursor string, n int, beg
 
 // GetFeedbackStream reads feedback by stream.
 func (d *SQLDatabase) GetFeedbackStream(ctx context.Context, batchSize int, beginTime, endTime *time.Time, feedbackTypes ...string) (chan []Feedback, chan error) {
+	if d.driver == ClickHouse {
+		return d.getClickHouseFeedbackStream(ctx, batchSize, beginTime, endTime, feedbackTypes...)
+	}
 	feedbackChan := make(chan []Feedback, bufSize)
 	errChan := make(chan error, 1)
 	go func() {
 		defer close(feedbackChan)
 		defer close(errChan)
 		// send query
-		tx := d.gormDB.WithContext(ctx).Table(d.FeedbackTable()).Select("feedback_type, user_id, item_id, time_stamp, comment")
+		tx := d.reader().WithContext(ctx).Table(d.FeedbackTable()).Select("feedback_type, user_id, item_id, time_stamp, comment")
 		if len(feedbackTypes) > 0 {
 			tx.Where("feedback_type IN ?", feedbackTypes)
 		}
@@ -1090,6 +1368,89 @@ func (d *SQLDatabase) DeleteUserItemFeedback(ctx context.Context, userId, itemId
 	return int(tx.RowsAffected), nil
 }
 
+// InsertItemHistory inserts previous versions of items into MySQL.
+func (d *SQLDatabase) InsertItemHistory(ctx context.Context, history []ItemHistory) error {
+	if len(history) == 0 {
+		return nil
+	}
+	rows := lo.Map(history, func(h ItemHistory, _ int) SQLItemHistory {
+		row := NewSQLItemHistory(h)
+		row.Timestamp = d.convertTimeZone(&h.Timestamp)
+		row.ModifyTime = d.convertTimeZone(&h.ModifyTime)
+		return row
+	})
+	err := d.gormDB.WithContext(ctx).Table(d.ItemHistoryTable()).Create(rows).Error
+	return errors.Trace(err)
+}
+
+// GetItemHistory returns the latest n previous versions of an item from MySQL.
+func (d *SQLDatabase) GetItemHistory(ctx context.Context, itemId string, n int) ([]ItemHistory, error) {
+	result, err := d.gormDB.WithContext(ctx).Table(d.ItemHistoryTable()).
+		Select("item_id, is_hidden, categories, time_stamp, labels, comment, modify_time").
+		Where("item_id = ?", itemId).Order("modify_time DESC").Limit(n).Rows()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer result.Close()
+	history := make([]ItemHistory, 0)
+	for result.Next() {
+		var h ItemHistory
+		var labels, categories string
+		var comment sql.NullString
+		if err = result.Scan(&h.ItemId, &h.IsHidden, &categories, &h.Timestamp, &labels, &comment, &h.ModifyTime); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = json.Unmarshal([]byte(labels), &h.Labels); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = json.Unmarshal([]byte(categories), &h.Categories); err != nil {
+			return nil, errors.Trace(err)
+		}
+		h.Comment = comment.String
+		history = append(history, h)
+	}
+	return history, nil
+}
+
+// InsertAuditLogs inserts audit logs into MySQL.
+func (d *SQLDatabase) InsertAuditLogs(ctx context.Context, logs []AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	rows := make([]AuditLog, len(logs))
+	for i, l := range logs {
+		rows[i] = l
+		rows[i].Timestamp = d.convertTimeZone(&l.Timestamp)
+	}
+	err := d.gormDB.WithContext(ctx).Table(d.AuditLogsTable()).Create(rows).Error
+	return errors.Trace(err)
+}
+
+// GetAuditLogs returns the latest n audit logs from MySQL.
+func (d *SQLDatabase) GetAuditLogs(ctx context.Context, n int, beginTime, endTime *time.Time) ([]AuditLog, error) {
+	tx := d.gormDB.WithContext(ctx).Table(d.AuditLogsTable()).Select("time_stamp, actor, method, path, status, digest")
+	if beginTime != nil {
+		tx.Where("time_stamp >= ?", d.convertTimeZone(beginTime))
+	}
+	if endTime != nil {
+		tx.Where("time_stamp <= ?", d.convertTimeZone(endTime))
+	}
+	result, err := tx.Order("time_stamp DESC").Limit(n).Rows()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer result.Close()
+	logs := make([]AuditLog, 0)
+	for result.Next() {
+		var l AuditLog
+		if err = result.Scan(&l.Timestamp, &l.Actor, &l.Method, &l.Path, &l.Status, &l.Digest); err != nil {
+			return nil, errors.Trace(err)
+		}
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
 func (d *SQLDatabase) convertTimeZone(timestamp *time.Time) time.Time {
 	switch d.driver {
 	case ClickHouse, SQLite, Oracle:
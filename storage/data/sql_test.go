@@ -15,13 +15,18 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/juju/errors"
+	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"github.com/zhenghaoz/gorse/storage"
@@ -79,6 +84,13 @@ func (suite *MySQLTestSuite) TestInit() {
 	assertQuery(suite.T(), connection, "SELECT @@sql_mode", "ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION")
 }
 
+func (suite *MySQLTestSuite) TestPartitionFeedback() {
+	database, err := Open(mySqlDSN+"gorse_data_test", "gorse_partition_")
+	suite.NoError(err)
+	testPartitionFeedback(suite.T(), database)
+	suite.NoError(database.Close())
+}
+
 func TestMySQL(t *testing.T) {
 	suite.Run(t, new(MySQLTestSuite))
 }
@@ -107,6 +119,13 @@ func (suite *PostgresTestSuite) SetupSuite() {
 	suite.NoError(err)
 }
 
+func (suite *PostgresTestSuite) TestPartitionFeedback() {
+	database, err := Open(postgresDSN+"gorse_data_test?sslmode=disable", "gorse_partition_")
+	suite.NoError(err)
+	testPartitionFeedback(suite.T(), database)
+	suite.NoError(database.Close())
+}
+
 func TestPostgres(t *testing.T) {
 	suite.Run(t, new(PostgresTestSuite))
 }
@@ -196,6 +215,83 @@ func TestSQLite(t *testing.T) {
 	suite.Run(t, new(SQLiteTestSuite))
 }
 
+func TestSQLiteReplica(t *testing.T) {
+	ctx := context.Background()
+	primaryPath := "sqlite://" + filepath.Join(t.TempDir(), "primary.db")
+	replicaPath := "sqlite://" + filepath.Join(t.TempDir(), "replica.db")
+	// insert different items into the replica
+	replica, err := Open(replicaPath, "gorse_")
+	assert.NoError(t, err)
+	assert.NoError(t, replica.Init())
+	assert.NoError(t, replica.BatchInsertItems(ctx, []Item{{ItemId: "replica", Labels: []string{}, Categories: []string{}}}))
+	assert.NoError(t, replica.Close())
+
+	database, err := OpenWithReplica(primaryPath, replicaPath, "gorse_")
+	assert.NoError(t, err)
+	assert.NoError(t, database.Init())
+	assert.NoError(t, database.Ping())
+	// inserts are sent to the primary
+	err = database.BatchInsertItems(ctx, []Item{{ItemId: "primary", Labels: []string{}, Categories: []string{}}})
+	assert.NoError(t, err)
+	item, err := database.GetItem(ctx, "primary")
+	assert.NoError(t, err)
+	assert.Equal(t, "primary", item.ItemId)
+	// scans are sent to the replica
+	_, items, err := database.GetItems(ctx, "", 10, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"replica"}, lo.Map(items, func(item Item, _ int) string { return item.ItemId }))
+	assert.NoError(t, database.Close())
+}
+
+func TestSQLitePartitionFeedback(t *testing.T) {
+	database, err := Open("sqlite://:memory:", "gorse_")
+	assert.NoError(t, err)
+	err = database.(FeedbackPartitioner).PartitionFeedback(context.Background(), 24*time.Hour, time.Now())
+	assert.True(t, errors.Is(err, errors.NotSupported))
+	assert.NoError(t, database.Close())
+}
+
+func testPartitionFeedback(t *testing.T, database Database) {
+	ctx := context.Background()
+	partitioner := database.(FeedbackPartitioner)
+	now := time.Now().UTC()
+	assert.NoError(t, partitioner.PartitionFeedback(ctx, 24*time.Hour, now.Add(48*time.Hour)))
+	assert.NoError(t, database.Init())
+	// create partitions again
+	assert.NoError(t, partitioner.PartitionFeedback(ctx, 24*time.Hour, now.Add(48*time.Hour)))
+
+	// insert feedback into different partitions
+	err := database.BatchInsertFeedback(ctx, []Feedback{
+		{FeedbackKey{"click", "0", "0"}, now.Add(-240 * time.Hour).Truncate(time.Second), "old"},
+		{FeedbackKey{"click", "0", "1"}, now.Truncate(time.Second), "new"},
+	}, true, true, true)
+	assert.NoError(t, err)
+	// skip existed feedback
+	err = database.BatchInsertFeedback(ctx, []Feedback{
+		{FeedbackKey{"click", "0", "0"}, now.Truncate(time.Second), "skipped"},
+	}, true, true, false)
+	assert.NoError(t, err)
+	feedback, err := database.GetUserFeedback(ctx, "0", lo.ToPtr(now.Add(time.Hour)))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"old", "new"}, lo.Map(feedback, func(f Feedback, _ int) string { return f.Comment }))
+	// overwrite feedback in another partition
+	err = database.BatchInsertFeedback(ctx, []Feedback{
+		{FeedbackKey{"click", "0", "0"}, now.Truncate(time.Second), "overwritten"},
+	}, true, true, true)
+	assert.NoError(t, err)
+	feedback, err = database.GetUserFeedback(ctx, "0", lo.ToPtr(now.Add(time.Hour)))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"overwritten", "new"}, lo.Map(feedback, func(f Feedback, _ int) string { return f.Comment }))
+
+	// prune all partitions
+	n, err := partitioner.PruneFeedback(ctx, now.Add(72*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	feedback, err = database.GetUserFeedback(ctx, "0", lo.ToPtr(now.Add(time.Hour)))
+	assert.NoError(t, err)
+	assert.Empty(t, feedback)
+}
+
 func assertQuery(t *testing.T, connection *sql.DB, sql string, expected string) {
 	rows, err := connection.Query(sql)
 	assert.NoError(t, err)
@@ -0,0 +1,209 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhenghaoz/gorse/base/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	LabelOperation = "operation"
+	LabelTable     = "table"
+	LabelEndpoint  = "endpoint"
+
+	statementStartTime = "gorse:start_time"
+)
+
+var (
+	StatementSecondsVec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "gorse",
+		Subsystem: "data_store",
+		Name:      "statement_seconds",
+	}, []string{LabelOperation, LabelTable})
+	StatementErrorsVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "data_store",
+		Name:      "statement_errors_total",
+	}, []string{LabelOperation, LabelTable})
+	SlowStatementsVec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gorse",
+		Subsystem: "data_store",
+		Name:      "slow_statements_total",
+	}, []string{LabelOperation, LabelTable, LabelEndpoint})
+)
+
+// QueryLogOptions configures logging of slow statements to SQL databases.
+type QueryLogOptions struct {
+	SlowThreshold time.Duration // statements slower than the threshold are logged, 0 means disabled
+	ExplainRatio  float64       // ratio of logged slow queries with query plans
+}
+
+type endpointKey struct{}
+
+// WithEndpoint returns a context which attributes statements sent with it to an API endpoint.
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+func endpointFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	endpoint, _ := ctx.Value(endpointKey{}).(string)
+	return endpoint
+}
+
+// WithQueryLog enables logging of slow statements to a SQL database and its read replica. It must be called before
+// the database is used.
+func WithQueryLog(database Database, options QueryLogOptions) Database {
+	if sqlDatabase, ok := database.(*SQLDatabase); ok {
+		for d := sqlDatabase; d != nil; d = d.replica {
+			d.queryLog = options
+		}
+	}
+	return database
+}
+
+// registerCallbacks registers callbacks to measure statements sent by GORM.
+func (d *SQLDatabase) registerCallbacks() error {
+	callback := d.gormDB.Callback()
+	for _, err := range []error{
+		callback.Create().Before("gorm:create").Register("gorse:before_create", startStatement),
+		callback.Create().After("gorm:create").Register("gorse:after_create", d.finishStatement("create")),
+		callback.Query().Before("gorm:query").Register("gorse:before_query", startStatement),
+		callback.Query().After("gorm:query").Register("gorse:after_query", d.finishStatement("query")),
+		callback.Update().Before("gorm:update").Register("gorse:before_update", startStatement),
+		callback.Update().After("gorm:update").Register("gorse:after_update", d.finishStatement("update")),
+		callback.Delete().Before("gorm:delete").Register("gorse:before_delete", startStatement),
+		callback.Delete().After("gorm:delete").Register("gorse:after_delete", d.finishStatement("delete")),
+		callback.Row().Before("gorm:row").Register("gorse:before_row", startStatement),
+		callback.Row().After("gorm:row").Register("gorse:after_row", d.finishStatement("row")),
+		callback.Raw().Before("gorm:raw").Register("gorse:before_raw", startStatement),
+		callback.Raw().After("gorm:raw").Register("gorse:after_raw", d.finishStatement("raw")),
+	} {
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func startStatement(db *gorm.DB) {
+	db.InstanceSet(statementStartTime, time.Now())
+}
+
+func (d *SQLDatabase) finishStatement(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(statementStartTime)
+		if !ok {
+			return
+		}
+		startTime, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(startTime)
+		StatementSecondsVec.WithLabelValues(operation, db.Statement.Table).Observe(elapsed.Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			StatementErrorsVec.WithLabelValues(operation, db.Statement.Table).Inc()
+		}
+		if d.queryLog.SlowThreshold > 0 && elapsed >= d.queryLog.SlowThreshold {
+			d.logSlowStatement(db, operation, elapsed)
+		}
+	}
+}
+
+// logSlowStatement logs a slow statement with the API endpoint sending it. Only the parameterized statement is logged,
+// bound values such as user IDs are never written to logs. Query plans of a ratio of slow queries are logged as well.
+func (d *SQLDatabase) logSlowStatement(db *gorm.DB, operation string, elapsed time.Duration) {
+	endpoint := endpointFromContext(db.Statement.Context)
+	SlowStatementsVec.WithLabelValues(operation, db.Statement.Table, endpoint).Inc()
+	statement := db.Statement.SQL.String()
+	fields := []zap.Field{
+		zap.String("operation", operation),
+		zap.String("table", db.Statement.Table),
+		zap.String("endpoint", endpoint),
+		zap.Duration("elapsed", elapsed),
+		zap.Int64("rows", db.Statement.RowsAffected),
+		zap.String("sql", statement),
+		zap.Int("vars", len(db.Statement.Vars)),
+	}
+	if isQuery(statement) && rand.Float64() < d.queryLog.ExplainRatio {
+		if plan, err := d.explain(db.Statement.Context, statement, db.Statement.Vars); err != nil {
+			fields = append(fields, zap.NamedError("explain_error", err))
+		} else {
+			fields = append(fields, zap.String("plan", plan))
+		}
+	}
+	log.ContextLogger(db.Statement.Context).Warn("slow statement to data store", fields...)
+}
+
+func isQuery(statement string) bool {
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(statement)), "SELECT")
+}
+
+// explain returns the query plan of a query. Rows of the plan are separated by newlines and columns are separated by
+// tabs.
+func (d *SQLDatabase) explain(ctx context.Context, statement string, vars []interface{}) (string, error) {
+	var prefix string
+	switch d.driver {
+	case MySQL, Postgres, ClickHouse:
+		prefix = "EXPLAIN "
+	case SQLite:
+		prefix = "EXPLAIN QUERY PLAN "
+	default:
+		return "", errors.NotSupportedf("query plans of Oracle")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	rows, err := d.client.QueryContext(ctx, prefix+statement, vars...)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	var lines []string
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return "", errors.Trace(err)
+		}
+		line := make([]string, len(values))
+		for i, value := range values {
+			line[i] = value.String
+		}
+		lines = append(lines, strings.Join(line, "\t"))
+	}
+	return strings.Join(lines, "\n"), errors.Trace(rows.Err())
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package data
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteStatements(t *testing.T) {
+	const endpoint = "GET /api/item/{item-id}"
+	ctx := WithEndpoint(context.Background(), endpoint)
+	database, err := Open("sqlite://"+filepath.Join(t.TempDir(), "gorse.db"), "gorse_")
+	assert.NoError(t, err)
+	assert.NoError(t, database.Init())
+	database = WithQueryLog(database, QueryLogOptions{SlowThreshold: time.Nanosecond, ExplainRatio: 1})
+
+	// measure statements and count slow statements by endpoints
+	statements := StatementSecondsVec.WithLabelValues("row", "gorse_items")
+	slowStatements := SlowStatementsVec.WithLabelValues("row", "gorse_items", endpoint)
+	count, slowCount := sampleCount(t, statements), counterValue(t, slowStatements)
+	assert.NoError(t, database.BatchInsertItems(ctx, []Item{{ItemId: "1", Labels: []string{}, Categories: []string{}}}))
+	_, err = database.GetItem(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, count+1, sampleCount(t, statements))
+	assert.Equal(t, slowCount+1, counterValue(t, slowStatements))
+
+	// explain queries
+	plan, err := database.(*SQLDatabase).explain(ctx, "SELECT * FROM gorse_items WHERE item_id = ?", []interface{}{"1"})
+	assert.NoError(t, err)
+	assert.Contains(t, plan, "gorse_items")
+	_, err = database.(*SQLDatabase).explain(ctx, "SELECT * FROM unknown", nil)
+	assert.Error(t, err)
+	assert.True(t, isQuery(" select 1"))
+	assert.False(t, isQuery("DELETE FROM gorse_items"))
+	assert.NoError(t, database.Close())
+}
+
+func sampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	var metric dto.Metric
+	assert.NoError(t, observer.(prometheus.Histogram).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var metric dto.Metric
+	assert.NoError(t, counter.Write(&metric))
+	return metric.GetCounter().GetValue()
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feature
+
+import (
+	"context"
+	"time"
+
+	"github.com/ReneKroon/ttlcache/v2"
+	"github.com/juju/errors"
+)
+
+// cachedStore caches features of users and items. Absent features are cached as well, so that users and items
+// without features are not fetched again until expired.
+type cachedStore struct {
+	Store
+	users *ttlcache.Cache
+	items *ttlcache.Cache
+}
+
+// WithCache caches features of up to size users and items for ttl.
+func WithCache(store Store, size int, ttl time.Duration) Store {
+	newCache := func() *ttlcache.Cache {
+		c := ttlcache.NewCache()
+		_ = c.SetTTL(ttl)
+		c.SetCacheSizeLimit(size)
+		c.SkipTTLExtensionOnHit(true)
+		return c
+	}
+	return &cachedStore{Store: store, users: newCache(), items: newCache()}
+}
+
+func (c *cachedStore) GetUserFeatures(ctx context.Context, userIds []string) (map[string][]string, error) {
+	return getCachedFeatures(ctx, c.users, userIds, c.Store.GetUserFeatures)
+}
+
+func (c *cachedStore) GetItemFeatures(ctx context.Context, itemIds []string) (map[string][]string, error) {
+	return getCachedFeatures(ctx, c.items, itemIds, c.Store.GetItemFeatures)
+}
+
+func (c *cachedStore) Close() error {
+	_ = c.users.Close()
+	_ = c.items.Close()
+	return c.Store.Close()
+}
+
+func getCachedFeatures(ctx context.Context, c *ttlcache.Cache, ids []string,
+	fetch func(ctx context.Context, ids []string) (map[string][]string, error)) (map[string][]string, error) {
+	features := make(map[string][]string, len(ids))
+	var missed []string
+	for _, id := range ids {
+		if value, err := c.Get(id); err == nil {
+			if value != nil {
+				features[id] = value.([]string)
+			}
+		} else {
+			missed = append(missed, id)
+		}
+	}
+	if len(missed) == 0 {
+		return features, nil
+	}
+	fetched, err := fetch(ctx, missed)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, id := range missed {
+		if value, exist := fetched[id]; exist {
+			features[id] = value
+			_ = c.Set(id, value)
+		} else {
+			_ = c.Set(id, nil)
+		}
+	}
+	return features, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feature
+
+import (
+	"context"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Methods of the gRPC feature store. Both methods accept and return google.protobuf.Struct, so that feature stores are
+// implemented without generated code of gorse:
+//
+//	service FeatureStore {
+//	  // request: {"ids": [...]}, response: {"<id>": ["<feature>", ...], ...}
+//	  rpc GetUserFeatures(google.protobuf.Struct) returns (google.protobuf.Struct);
+//	  rpc GetItemFeatures(google.protobuf.Struct) returns (google.protobuf.Struct);
+//	}
+const (
+	GRPCServiceName           = "gorse.FeatureStore"
+	GRPCGetUserFeaturesMethod = "/gorse.FeatureStore/GetUserFeatures"
+	GRPCGetItemFeaturesMethod = "/gorse.FeatureStore/GetItemFeatures"
+)
+
+// GRPC fetches features from a feature store by gRPC.
+type GRPC struct {
+	conn    *grpc.ClientConn
+	timeout time.Duration
+}
+
+func (g *GRPC) GetUserFeatures(ctx context.Context, userIds []string) (map[string][]string, error) {
+	return g.getFeatures(ctx, GRPCGetUserFeaturesMethod, userIds)
+}
+
+func (g *GRPC) GetItemFeatures(ctx context.Context, itemIds []string) (map[string][]string, error) {
+	return g.getFeatures(ctx, GRPCGetItemFeaturesMethod, itemIds)
+}
+
+func (g *GRPC) Close() error {
+	return g.conn.Close()
+}
+
+func (g *GRPC) getFeatures(ctx context.Context, method string, ids []string) (map[string][]string, error) {
+	if len(ids) == 0 {
+		return map[string][]string{}, nil
+	}
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	request, err := structpb.NewStruct(map[string]interface{}{"ids": values})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+	if requestId := log.RequestId(ctx); requestId != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", requestId)
+	}
+	response := new(structpb.Struct)
+	if err = g.conn.Invoke(ctx, method, request, response); err != nil {
+		return nil, errors.Trace(err)
+	}
+	features := make(map[string][]string, len(response.Fields))
+	for id, value := range response.Fields {
+		list := value.GetListValue()
+		if list == nil {
+			return nil, errors.NotValidf("features of %s", id)
+		}
+		features[id] = make([]string, len(list.Values))
+		for i, feature := range list.Values {
+			features[id][i] = feature.GetStringValue()
+		}
+	}
+	return features, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feature
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/base/log"
+)
+
+// HTTP fetches features from a feature store by HTTP. Features of users are fetched by POST <endpoint>/users and
+// features of items by POST <endpoint>/items. The request body is {"ids": [...]} and the response body maps ids to
+// features, e.g. {"1": ["segment:vip"]}.
+type HTTP struct {
+	client   *http.Client
+	endpoint *url.URL
+}
+
+func (h *HTTP) GetUserFeatures(ctx context.Context, userIds []string) (map[string][]string, error) {
+	return h.getFeatures(ctx, "users", userIds)
+}
+
+func (h *HTTP) GetItemFeatures(ctx context.Context, itemIds []string) (map[string][]string, error) {
+	return h.getFeatures(ctx, "items", itemIds)
+}
+
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+func (h *HTTP) getFeatures(ctx context.Context, entity string, ids []string) (map[string][]string, error) {
+	if len(ids) == 0 {
+		return map[string][]string{}, nil
+	}
+	body, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	endpoint := *h.endpoint
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + entity
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestId := log.RequestId(ctx); requestId != "" {
+		req.Header.Set("X-Request-ID", requestId)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return nil, errors.Errorf("POST %s: %s %s", endpoint.Path, resp.Status, message)
+	}
+	var features map[string][]string
+	if err = json.NewDecoder(resp.Body).Decode(&features); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return features, nil
+}
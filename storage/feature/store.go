@@ -0,0 +1,95 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feature
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Store is an external feature store of users and items. Features are labels such as "segment:vip", which are used
+// by click models in addition to labels of users and items in the data store.
+type Store interface {
+	// GetUserFeatures returns features of users. Users without features are absent in the result.
+	GetUserFeatures(ctx context.Context, userIds []string) (map[string][]string, error)
+	// GetItemFeatures returns features of items. Items without features are absent in the result.
+	GetItemFeatures(ctx context.Context, itemIds []string) (map[string][]string, error)
+	Close() error
+}
+
+// Options of the connection to a feature store.
+type Options struct {
+	Timeout   time.Duration // timeout of each request
+	CacheSize int           // max number of cached users and items respectively, 0 means no cache
+	CacheTTL  time.Duration // time-to-live of cached features
+}
+
+// Open connects to the feature store. Features are fetched by HTTP POST from http:// or https:// endpoints, or by
+// gRPC calls to grpc:// endpoints.
+func Open(path string, options Options) (Store, error) {
+	var store Store
+	if strings.HasPrefix(path, storage.HTTPPrefix) || strings.HasPrefix(path, storage.HTTPSPrefix) {
+		endpoint, err := url.Parse(path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store = &HTTP{
+			client:   &http.Client{Timeout: options.Timeout},
+			endpoint: endpoint,
+		}
+	} else if strings.HasPrefix(path, storage.GRPCPrefix) {
+		conn, err := grpc.Dial(path[len(storage.GRPCPrefix):], grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store = &GRPC{conn: conn, timeout: options.Timeout}
+	} else {
+		return nil, errors.Errorf("Unknown feature store: %s", path)
+	}
+	if options.CacheSize > 0 {
+		store = WithCache(store, options.CacheSize, options.CacheTTL)
+	}
+	return store, nil
+}
+
+// AppendFeatures returns labels followed by features not in labels. Labels are not modified.
+func AppendFeatures(labels, features []string) []string {
+	if len(features) == 0 {
+		return labels
+	}
+	merged := make([]string, len(labels), len(labels)+len(features))
+	copy(merged, labels)
+	for _, feature := range features {
+		exist := false
+		for _, label := range labels {
+			if label == feature {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			merged = append(merged, feature)
+		}
+	}
+	return merged
+}
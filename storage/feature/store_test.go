@@ -0,0 +1,176 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package feature
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/base/log"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var mockFeatures = map[string]map[string][]string{
+	"users": {"0": {"segment:vip"}, "1": {"segment:new", "age:20"}},
+	"items": {"0": {"brand:gorse"}},
+}
+
+func TestHTTP(t *testing.T) {
+	var requests, requestIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Ids []string `json:"ids"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		requests = append(requests, r.URL.Path)
+		requestIds = append(requestIds, r.Header.Get("X-Request-ID"))
+		entity := map[string]string{"/features/users": "users", "/features/items": "items"}[r.URL.Path]
+		features := make(map[string][]string)
+		for _, id := range request.Ids {
+			if f, exist := mockFeatures[entity][id]; exist {
+				features[id] = f
+			}
+		}
+		_ = json.NewEncoder(w).Encode(features)
+	}))
+	defer server.Close()
+	store, err := Open(server.URL+"/features", Options{Timeout: time.Second})
+	assert.NoError(t, err)
+	defer store.Close()
+
+	users, err := store.GetUserFeatures(context.Background(), []string{"0", "1", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"0": {"segment:vip"}, "1": {"segment:new", "age:20"}}, users)
+	items, err := store.GetItemFeatures(log.WithRequestId(context.Background(), "request"), []string{"0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"0": {"brand:gorse"}}, items)
+	assert.Equal(t, []string{"/features/users", "/features/items"}, requests)
+	assert.Equal(t, []string{"", "request"}, requestIds)
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer server.Close()
+	defer close(unblock)
+	store, err := Open(server.URL, Options{Timeout: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	_, err = store.GetUserFeatures(context.Background(), []string{"0"})
+	assert.Error(t, err)
+}
+
+type mockFeatureStore struct{}
+
+func (mockFeatureStore) handle(entity string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(_ interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		request := new(structpb.Struct)
+		if err := dec(request); err != nil {
+			return nil, err
+		}
+		features := make(map[string]interface{})
+		for _, id := range request.Fields["ids"].GetListValue().AsSlice() {
+			if f, exist := mockFeatures[entity][id.(string)]; exist {
+				values := make([]interface{}, len(f))
+				for i := range f {
+					values[i] = f[i]
+				}
+				features[id.(string)] = values
+			}
+		}
+		return structpb.NewStruct(features)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: GRPCServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "GetUserFeatures", Handler: mockFeatureStore{}.handle("users")},
+			{MethodName: "GetItemFeatures", Handler: mockFeatureStore{}.handle("items")},
+		},
+	}, mockFeatureStore{})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+	store, err := Open("grpc://"+listener.Addr().String(), Options{Timeout: time.Second})
+	assert.NoError(t, err)
+	defer store.Close()
+
+	users, err := store.GetUserFeatures(context.Background(), []string{"0", "1", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"0": {"segment:vip"}, "1": {"segment:new", "age:20"}}, users)
+	items, err := store.GetItemFeatures(context.Background(), []string{"0", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"0": {"brand:gorse"}}, items)
+}
+
+type countingStore struct {
+	Store
+	fetched [][]string
+}
+
+func (c *countingStore) GetUserFeatures(ctx context.Context, userIds []string) (map[string][]string, error) {
+	c.fetched = append(c.fetched, userIds)
+	features := make(map[string][]string)
+	for _, id := range userIds {
+		if f, exist := mockFeatures["users"][id]; exist {
+			features[id] = f
+		}
+	}
+	return features, nil
+}
+
+func (c *countingStore) Close() error {
+	return nil
+}
+
+func TestWithCache(t *testing.T) {
+	store := &countingStore{}
+	cached := WithCache(store, 100, time.Hour)
+	defer cached.Close()
+	users, err := cached.GetUserFeatures(context.Background(), []string{"0", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"0": {"segment:vip"}}, users)
+	// cached users and users without features are not fetched again
+	users, err = cached.GetUserFeatures(context.Background(), []string{"0", "1", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"0": {"segment:vip"}, "1": {"segment:new", "age:20"}}, users)
+	users, err = cached.GetUserFeatures(context.Background(), []string{"1", "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{"1": {"segment:new", "age:20"}}, users)
+	assert.Equal(t, [][]string{{"0", "2"}, {"1"}}, store.fetched)
+}
+
+func TestAppendFeatures(t *testing.T) {
+	labels := []string{"a", "b"}
+	assert.Equal(t, []string{"a", "b"}, AppendFeatures(labels, nil))
+	assert.Equal(t, []string{"a", "b", "c"}, AppendFeatures(labels, []string{"b", "c"}))
+	assert.Equal(t, []string{"a", "b"}, labels)
+	assert.Equal(t, []string{"c"}, AppendFeatures(nil, []string{"c"}))
+}
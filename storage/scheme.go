@@ -16,6 +16,7 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 	"github.com/go-sql-driver/mysql"
 	"github.com/juju/errors"
 	"github.com/samber/lo"
@@ -24,23 +25,33 @@ import (
 	"gorm.io/gorm/schema"
 	"moul.io/zapgorm2"
 	"net/url"
+	"regexp"
 	"strings"
 )
 
 const (
-	MySQLPrefix        = "mysql://"
-	MongoPrefix        = "mongodb://"
-	MongoSrvPrefix     = "mongodb+srv://"
-	PostgresPrefix     = "postgres://"
-	PostgreSQLPrefix   = "postgresql://"
-	ClickhousePrefix   = "clickhouse://"
-	CHHTTPPrefix       = "chhttp://"
-	CHHTTPSPrefix      = "chhttps://"
-	SQLitePrefix       = "sqlite://"
-	RedisPrefix        = "redis://"
-	RedissPrefix       = "rediss://"
-	RedisClusterPrefix = "redis+cluster://"
-	OraclePrefix       = "oracle://"
+	MySQLPrefix         = "mysql://"
+	MongoPrefix         = "mongodb://"
+	MongoSrvPrefix      = "mongodb+srv://"
+	PostgresPrefix      = "postgres://"
+	PostgreSQLPrefix    = "postgresql://"
+	ClickhousePrefix    = "clickhouse://"
+	CHHTTPPrefix        = "chhttp://"
+	CHHTTPSPrefix       = "chhttps://"
+	SQLitePrefix        = "sqlite://"
+	RedisPrefix         = "redis://"
+	RedissPrefix        = "rediss://"
+	RedisClusterPrefix  = "redis+cluster://"
+	RedisSentinelPrefix = "redis+sentinel://"
+	OraclePrefix        = "oracle://"
+	LocalPrefix         = "local://"
+
+	ElasticsearchPrefix  = "elasticsearch://"
+	ElasticsearchsPrefix = "elasticsearchs://"
+
+	HTTPPrefix  = "http://"
+	HTTPSPrefix = "https://"
+	GRPCPrefix  = "grpc://"
 )
 
 func AppendURLParams(rawURL string, params []lo.Tuple2[string, string]) (string, error) {
@@ -118,10 +129,39 @@ func (tp TablePrefix) FeedbackTable() string {
 	return string(tp) + "feedback"
 }
 
+func (tp TablePrefix) ItemHistoryTable() string {
+	return string(tp) + "item_history"
+}
+
+func (tp TablePrefix) AuditLogsTable() string {
+	return string(tp) + "audit_logs"
+}
+
+func (tp TablePrefix) MigrationsTable() string {
+	return string(tp) + "schema_migrations"
+}
+
 func (tp TablePrefix) Key(key string) string {
 	return string(tp) + key
 }
 
+// versionedKey matches keys of a version in the cache store, without the table prefix.
+var versionedKey = regexp.MustCompile(`^v[0-9]+_`)
+
+// VersionedTablePrefix returns the table prefix of a version of keys in the cache store. Keys of version 0 are not
+// versioned to be compatible with cache stores written before versions of keys.
+func VersionedTablePrefix(tablePrefix string, version int) string {
+	if version == 0 {
+		return tablePrefix
+	}
+	return fmt.Sprintf("%sv%d_", tablePrefix, version)
+}
+
+// IsVersionedKey returns true if a key without the table prefix belongs to a version other than 0.
+func IsVersionedKey(key string) bool {
+	return versionedKey.MatchString(key)
+}
+
 func NewGORMConfig(tablePrefix string) *gorm.Config {
 	return &gorm.Config{
 		Logger:                 zapgorm2.New(log.Logger()),
@@ -0,0 +1,70 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// MaxResults is the max number of items returned by a search, which is the default max result window of
+// Elasticsearch and OpenSearch.
+const MaxResults = 10000
+
+// Database is the search store of items. Items are indexed by servers once they are inserted, modified or deleted, so
+// that items are searched by full text of labels and comments without a separate search service.
+type Database interface {
+	Init(ctx context.Context) error
+	Close() error
+	// IndexItems inserts or replaces items in the index.
+	IndexItems(ctx context.Context, items []data.Item) error
+	// DeleteItems removes items from the index. Items not indexed are ignored.
+	DeleteItems(ctx context.Context, itemIds []string) error
+	// SearchItems returns visible items matching the query with any of the labels, sorted by relevance. All items with
+	// the labels are matched if the query is empty, and labels are not filtered if labels are empty.
+	SearchItems(ctx context.Context, query string, labels []string, offset, n int) ([]cache.Scored, error)
+}
+
+// Open connects to the search store. Elasticsearch and OpenSearch are supported by elasticsearch:// or elasticsearchs://
+// for HTTPS.
+func Open(path, tablePrefix string) (Database, error) {
+	if strings.HasPrefix(path, storage.ElasticsearchPrefix) || strings.HasPrefix(path, storage.ElasticsearchsPrefix) {
+		parsed, err := url.Parse(path)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if strings.HasPrefix(path, storage.ElasticsearchsPrefix) {
+			parsed.Scheme = "https"
+		} else {
+			parsed.Scheme = "http"
+		}
+		parsed.Path, parsed.RawQuery = "", ""
+		database := &Elasticsearch{
+			client:   &http.Client{Timeout: 30 * time.Second},
+			endpoint: parsed,
+			index:    tablePrefix + "items",
+		}
+		return database, nil
+	}
+	return nil, errors.Errorf("Unknown database: %s", path)
+}
@@ -0,0 +1,233 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// itemMapping is the mapping of the item index. Labels are matched exactly by filters and matched by full text in
+// labels.text.
+var itemMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"item_id":    map[string]interface{}{"type": "keyword"},
+			"is_hidden":  map[string]interface{}{"type": "boolean"},
+			"categories": map[string]interface{}{"type": "keyword"},
+			"labels": map[string]interface{}{
+				"type":   "keyword",
+				"fields": map[string]interface{}{"text": map[string]interface{}{"type": "text"}},
+			},
+			"comment":   map[string]interface{}{"type": "text"},
+			"timestamp": map[string]interface{}{"type": "date"},
+		},
+	},
+}
+
+// itemDocument is the document of an item in the index.
+type itemDocument struct {
+	ItemId     string    `json:"item_id"`
+	IsHidden   bool      `json:"is_hidden"`
+	Categories []string  `json:"categories"`
+	Labels     []string  `json:"labels"`
+	Comment    string    `json:"comment"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Elasticsearch is the search store of items in Elasticsearch or OpenSearch, which share the REST APIs used here.
+type Elasticsearch struct {
+	client   *http.Client
+	endpoint *url.URL
+	index    string
+}
+
+// Init creates the index of items if it doesn't exist.
+func (es *Elasticsearch) Init(ctx context.Context) error {
+	err := es.request(ctx, http.MethodHead, "/"+es.index, nil, nil)
+	if !errors.Is(err, errors.NotFound) {
+		return errors.Trace(err)
+	}
+	return errors.Trace(es.request(ctx, http.MethodPut, "/"+es.index, itemMapping, nil))
+}
+
+func (es *Elasticsearch) Close() error {
+	es.client.CloseIdleConnections()
+	return nil
+}
+
+// IndexItems inserts or replaces items by the bulk API.
+func (es *Elasticsearch) IndexItems(ctx context.Context, items []data.Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, item := range items {
+		action := map[string]interface{}{"index": map[string]interface{}{"_index": es.index, "_id": item.ItemId}}
+		document := itemDocument{
+			ItemId:     item.ItemId,
+			IsHidden:   item.IsHidden,
+			Categories: item.Categories,
+			Labels:     item.Labels,
+			Comment:    item.Comment,
+			Timestamp:  item.Timestamp,
+		}
+		if err := encoder.Encode(action); err != nil {
+			return errors.Trace(err)
+		}
+		if err := encoder.Encode(document); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return es.bulk(ctx, &buf)
+}
+
+// DeleteItems removes items by the bulk API.
+func (es *Elasticsearch) DeleteItems(ctx context.Context, itemIds []string) error {
+	if len(itemIds) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, itemId := range itemIds {
+		action := map[string]interface{}{"delete": map[string]interface{}{"_index": es.index, "_id": itemId}}
+		if err := encoder.Encode(action); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return es.bulk(ctx, &buf)
+}
+
+// SearchItems matches the query against labels and comments of items, and filters items by labels.
+func (es *Elasticsearch) SearchItems(ctx context.Context, query string, labels []string, offset, n int) ([]cache.Scored, error) {
+	if offset+n > MaxResults {
+		n = MaxResults - offset
+	}
+	if n <= 0 {
+		return []cache.Scored{}, nil
+	}
+	filters := []interface{}{map[string]interface{}{"term": map[string]interface{}{"is_hidden": false}}}
+	if len(labels) > 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{"labels": labels}})
+	}
+	boolQuery := map[string]interface{}{"filter": filters}
+	if query != "" {
+		boolQuery["must"] = map[string]interface{}{
+			"multi_match": map[string]interface{}{"query": query, "fields": []string{"labels.text", "comment"}},
+		}
+	}
+	request := map[string]interface{}{
+		"from":    offset,
+		"size":    n,
+		"_source": false,
+		"query":   map[string]interface{}{"bool": boolQuery},
+	}
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Id    string  `json:"_id"`
+				Score float64 `json:"_score"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := es.request(ctx, http.MethodPost, "/"+es.index+"/_search", request, &result); err != nil {
+		return nil, errors.Trace(err)
+	}
+	scores := make([]cache.Scored, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		scores[i] = cache.Scored{Id: hit.Id, Score: hit.Score}
+	}
+	return scores, nil
+}
+
+// bulk sends actions to the bulk API. Bulk requests succeed even if some actions fail, so that failures are checked
+// in results of actions. Deleting items not indexed is not a failure.
+func (es *Elasticsearch) bulk(ctx context.Context, body *bytes.Buffer) error {
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Id    string `json:"_id"`
+			Error *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := es.send(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body, &result); err != nil {
+		return errors.Trace(err)
+	}
+	if result.Errors {
+		for _, item := range result.Items {
+			for action, status := range item {
+				if status.Error != nil {
+					return errors.Errorf("failed to %s item %s: %s %s", action, status.Id, status.Error.Type, status.Error.Reason)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// request sends a JSON request to Elasticsearch.
+func (es *Elasticsearch) request(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		reader = bytes.NewReader(buf)
+	}
+	return es.send(ctx, method, path, "application/json", reader, result)
+}
+
+// send sends a request to Elasticsearch. NotFound is returned for 404.
+func (es *Elasticsearch) send(ctx context.Context, method, path, contentType string, body io.Reader, result interface{}) error {
+	endpoint := *es.endpoint
+	endpoint.Path = path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.NotFoundf("%s %s", method, path)
+	case resp.StatusCode >= http.StatusMultipleChoices:
+		message, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("%s %s: %s %s", method, path, resp.Status, message)
+	}
+	if result != nil {
+		return errors.Trace(json.NewDecoder(resp.Body).Decode(result))
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+)
+
+// mockElasticsearch serves an index like Elasticsearch. Queries match the words of labels and comments, and all
+// documents matching a query have the same score.
+type mockElasticsearch struct {
+	mu        sync.Mutex
+	mappings  map[string]interface{}
+	documents map[string]itemDocument
+}
+
+func (m *mockElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case r.Method == http.MethodHead && r.URL.Path == "/gorse_items":
+		if m.mappings == nil {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPut && r.URL.Path == "/gorse_items":
+		_ = json.NewDecoder(r.Body).Decode(&m.mappings)
+	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+		var items []map[string]interface{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var actions map[string]struct {
+				Id string `json:"_id"`
+			}
+			_ = json.Unmarshal(scanner.Bytes(), &actions)
+			if action, exist := actions["index"]; exist {
+				scanner.Scan()
+				var document itemDocument
+				_ = json.Unmarshal(scanner.Bytes(), &document)
+				m.documents[action.Id] = document
+				items = append(items, map[string]interface{}{"index": map[string]interface{}{"_id": action.Id, "status": 201}})
+			} else if action, exist := actions["delete"]; exist {
+				delete(m.documents, action.Id)
+				items = append(items, map[string]interface{}{"delete": map[string]interface{}{"_id": action.Id, "status": 200}})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": false, "items": items})
+	case r.Method == http.MethodPost && r.URL.Path == "/gorse_items/_search":
+		var request struct {
+			From  int `json:"from"`
+			Size  int `json:"size"`
+			Query struct {
+				Bool struct {
+					Must struct {
+						MultiMatch struct {
+							Query string `json:"query"`
+						} `json:"multi_match"`
+					} `json:"must"`
+					Filter []struct {
+						Terms struct {
+							Labels []string `json:"labels"`
+						} `json:"terms"`
+					} `json:"filter"`
+				} `json:"bool"`
+			} `json:"query"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		var hits []map[string]interface{}
+		for _, id := range []string{"0", "1", "2", "3"} {
+			document, exist := m.documents[id]
+			if !exist || document.IsHidden {
+				continue
+			}
+			if query := request.Query.Bool.Must.MultiMatch.Query; query != "" &&
+				!strings.Contains(strings.Join(document.Labels, " ")+" "+document.Comment, query) {
+				continue
+			}
+			if labels := request.Query.Bool.Filter[len(request.Query.Bool.Filter)-1].Terms.Labels; labels != nil &&
+				!strings.Contains(" "+strings.Join(document.Labels, " ")+" ", " "+labels[0]+" ") {
+				continue
+			}
+			hits = append(hits, map[string]interface{}{"_id": id, "_score": 1.0})
+		}
+		if request.From < len(hits) {
+			hits = hits[request.From:]
+		} else {
+			hits = nil
+		}
+		if request.Size < len(hits) {
+			hits = hits[:request.Size]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestElasticsearch(t *testing.T) {
+	server := &mockElasticsearch{documents: make(map[string]itemDocument)}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	database, err := Open(strings.Replace(httpServer.URL, "http://", "elasticsearch://", 1), "gorse_")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// create index
+	assert.NoError(t, database.Init(ctx))
+	assert.NotNil(t, server.mappings)
+	assert.NoError(t, database.Init(ctx))
+
+	// index items
+	err = database.IndexItems(ctx, []data.Item{
+		{ItemId: "0", Labels: []string{"comedy"}, Comment: "a funny movie"},
+		{ItemId: "1", Labels: []string{"horror"}, Comment: "a scary movie"},
+		{ItemId: "2", Labels: []string{"comedy"}, Comment: "a funny show"},
+		{ItemId: "3", Labels: []string{"comedy"}, Comment: "a hidden movie", IsHidden: true},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, server.documents, 4)
+
+	// search items
+	items, err := database.SearchItems(ctx, "movie", nil, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"0", 1}, {"1", 1}}, items)
+	items, err = database.SearchItems(ctx, "funny", nil, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"2", 1}}, items)
+	items, err = database.SearchItems(ctx, "", []string{"comedy"}, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"0", 1}, {"2", 1}}, items)
+	items, err = database.SearchItems(ctx, "movie", nil, MaxResults, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	// delete items
+	assert.NoError(t, database.DeleteItems(ctx, []string{"0", "4"}))
+	items, err = database.SearchItems(ctx, "movie", nil, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []cache.Scored{{"1", 1}}, items)
+	assert.NoError(t, database.Close())
+}
+
+func TestElasticsearchBulkError(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errors":true,"items":[{"index":{"_id":"0","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+	}))
+	defer httpServer.Close()
+	database, err := Open(strings.Replace(httpServer.URL, "http://", "elasticsearch://", 1), "gorse_")
+	assert.NoError(t, err)
+	err = database.IndexItems(context.Background(), []data.Item{{ItemId: "0"}})
+	assert.ErrorContains(t, err, "mapper_parsing_exception")
+}
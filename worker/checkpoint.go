@@ -0,0 +1,136 @@
+// Copyright 2023 gorse Project Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/scylladb/go-set/i32set"
+	"github.com/zhenghaoz/gorse/base/log"
+	"github.com/zhenghaoz/gorse/storage/cache"
+	"github.com/zhenghaoz/gorse/storage/data"
+	"go.uber.org/zap"
+)
+
+// numCheckpointShards is the number of shards to track progress if user shards aren't assigned by master.
+const numCheckpointShards = 1024
+
+// Checkpoint is the progress of offline recommendation in a worker. Users in completed shards are skipped if the
+// worker restarts before the recommendation completes.
+type Checkpoint struct {
+	StartTime           time.Time `json:"start_time"`
+	RankingModelVersion int64     `json:"ranking_model_version"`
+	ClickModelVersion   int64     `json:"click_model_version"`
+	NumShards           int32     `json:"num_shards"`
+	CompletedShards     []int32   `json:"completed_shards"`
+}
+
+// checkpointTracker tracks users left in each shard and saves the checkpoint once a shard is completed.
+type checkpointTracker struct {
+	worker     *Worker
+	mu         sync.Mutex
+	checkpoint Checkpoint
+	remains    map[int32]int
+}
+
+// resumeCheckpoint returns users not completed in the previous recommendation and the tracker of progress. The
+// previous checkpoint is discarded if models or shards have changed, or it is older than the refresh period.
+func (w *Worker) resumeCheckpoint(ctx context.Context, users []data.User) ([]data.User, *checkpointTracker) {
+	numShards := w.numUserShards
+	if numShards == 0 {
+		numShards = numCheckpointShards
+	}
+	tracker := &checkpointTracker{
+		worker: w,
+		checkpoint: Checkpoint{
+			StartTime:           time.Now(),
+			RankingModelVersion: w.RankingModelVersion,
+			ClickModelVersion:   w.ClickModelVersion,
+			NumShards:           numShards,
+			CompletedShards:     []int32{},
+		},
+		remains: make(map[int32]int),
+	}
+	checkpoint, err := w.loadCheckpoint(ctx)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		log.Logger().Error("failed to load checkpoint", zap.Error(err))
+	} else if err == nil &&
+		checkpoint.RankingModelVersion == w.RankingModelVersion &&
+		checkpoint.ClickModelVersion == w.ClickModelVersion &&
+		checkpoint.NumShards == numShards &&
+		time.Since(checkpoint.StartTime) < w.Config().Recommend.Offline.RefreshRecommendPeriod {
+		completed := i32set.New(checkpoint.CompletedShards...)
+		remainUsers := make([]data.User, 0, len(users))
+		for _, user := range users {
+			if !completed.Has(UserShard(user.UserId, numShards)) {
+				remainUsers = append(remainUsers, user)
+			}
+		}
+		log.Logger().Info("resume offline recommendation from checkpoint",
+			zap.Time("start_time", checkpoint.StartTime),
+			zap.Int("n_completed_shards", len(checkpoint.CompletedShards)),
+			zap.Int("n_skipped_users", len(users)-len(remainUsers)))
+		tracker.checkpoint = *checkpoint
+		users = remainUsers
+	}
+	for _, user := range users {
+		tracker.remains[UserShard(user.UserId, numShards)]++
+	}
+	return users, tracker
+}
+
+// Done marks a user completed. The checkpoint is saved if all users of the shard are completed.
+func (t *checkpointTracker) Done(ctx context.Context, userId string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	shard := UserShard(userId, t.checkpoint.NumShards)
+	if t.remains[shard]--; t.remains[shard] > 0 {
+		return
+	}
+	t.checkpoint.CompletedShards = append(t.checkpoint.CompletedShards, shard)
+	if err := t.worker.saveCheckpoint(ctx, &t.checkpoint); err != nil {
+		log.Logger().Error("failed to save checkpoint", zap.Int32("shard", shard), zap.Error(err))
+	}
+}
+
+// loadCheckpoint loads the checkpoint of the worker from the cache store.
+func (w *Worker) loadCheckpoint(ctx context.Context) (*Checkpoint, error) {
+	buf, err := w.CacheClient.Get(ctx, cache.Key(cache.WorkerCheckpoint, w.workerName)).String()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var checkpoint Checkpoint
+	if err = cache.Unmarshal(buf, &checkpoint); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &checkpoint, nil
+}
+
+// saveCheckpoint saves the checkpoint of the worker to the cache store.
+func (w *Worker) saveCheckpoint(ctx context.Context, checkpoint *Checkpoint) error {
+	buf, err := cache.Marshal(w.CacheClient, checkpoint)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return w.CacheClient.Set(ctx, cache.String(cache.Key(cache.WorkerCheckpoint, w.workerName), buf))
+}
+
+// clearCheckpoint removes the checkpoint of the worker once offline recommendation completes.
+func (w *Worker) clearCheckpoint(ctx context.Context) error {
+	return w.CacheClient.Delete(ctx, cache.Key(cache.WorkerCheckpoint, w.workerName))
+}
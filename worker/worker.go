@@ -15,21 +15,30 @@
 package worker
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"math"
 	"math/rand"
 	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/juju/errors"
 	"github.com/lafikl/consistent"
 	cmap "github.com/orcaman/concurrent-map"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samber/lo"
 	"github.com/scylladb/go-set"
+	"github.com/scylladb/go-set/i32set"
 	"github.com/scylladb/go-set/strset"
 	"github.com/thoas/go-funk"
 	"github.com/zhenghaoz/gorse/base"
@@ -44,12 +53,14 @@ import (
 	"github.com/zhenghaoz/gorse/model/click"
 	"github.com/zhenghaoz/gorse/model/ranking"
 	"github.com/zhenghaoz/gorse/protocol"
+	"github.com/zhenghaoz/gorse/recommender"
+	"github.com/zhenghaoz/gorse/server"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
+	"github.com/zhenghaoz/gorse/storage/feature"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
@@ -77,12 +88,19 @@ type Worker struct {
 	masterHost string
 	masterPort int
 	cacheFile  string
+	tlsConfig  *protocol.TLSConfig
 
 	// database connection path
-	cachePath   string
-	cachePrefix string
-	dataPath    string
-	dataPrefix  string
+	cachePath      string
+	cachePrefix    string
+	cacheOptions   cache.Options
+	dataPath       string
+	dataReplica    string
+	dataPrefix     string
+	dataLimits     data.Limits
+	dataQueryLog   data.QueryLogOptions
+	featurePath    string
+	featureOptions feature.Options
 
 	// master connection
 	masterClient protocol.MasterClient
@@ -90,15 +108,24 @@ type Worker struct {
 	latestRankingModelVersion int64
 	latestClickModelVersion   int64
 	rankingIndex              *search.HNSW
+	modelMutex                sync.RWMutex // models are swapped between recommendation batches
 	randGenerator             *rand.Rand
+	itemFrequency             map[string]float64 // numbers of positive feedback of items to penalize popular items
 
 	// peers
 	peers []string
 	me    string
 
+	// user shards assigned by master
+	numUserShards int32
+	userShards    *i32set.Set
+
 	// scheduler state
 	scheduleState ScheduleState
 
+	// health checks
+	health server.HealthTracker
+
 	// events
 	tickDuration time.Duration
 	ticker       *time.Ticker
@@ -108,7 +135,7 @@ type Worker struct {
 }
 
 // NewWorker creates a new worker node.
-func NewWorker(masterHost string, masterPort int, httpHost string, httpPort, jobs int, cacheFile string, managedMode bool) *Worker {
+func NewWorker(masterHost string, masterPort int, httpHost string, httpPort, jobs int, cacheFile string, managedMode bool, tlsConfig *protocol.TLSConfig) *Worker {
 	return &Worker{
 		managedMode:   managedMode,
 		Settings:      config.NewSettings(),
@@ -117,6 +144,7 @@ func NewWorker(masterHost string, masterPort int, httpHost string, httpPort, job
 		cacheFile:  cacheFile,
 		masterHost: masterHost,
 		masterPort: masterPort,
+		tlsConfig:  tlsConfig,
 		httpHost:   httpHost,
 		httpPort:   httpPort,
 		jobs:       jobs,
@@ -137,59 +165,95 @@ func (w *Worker) SetOneMode(settings *config.Settings) {
 // Sync this worker to the master.
 func (w *Worker) Sync() {
 	defer base.CheckPanic()
-	log.Logger().Info("start meta sync", zap.Duration("meta_timeout", w.Config.Master.MetaTimeout))
+	log.Logger().Info("start meta sync", zap.Duration("meta_timeout", w.Config().Master.MetaTimeout))
 	for {
 		var meta *protocol.Meta
+		var masterConfig *config.Config
 		var err error
-		if meta, err = w.masterClient.GetMeta(context.Background(),
+		start := time.Now()
+		meta, err = w.masterClient.GetMeta(context.Background(),
 			&protocol.NodeInfo{
 				NodeType:      protocol.NodeType_WorkerNode,
 				NodeName:      w.workerName,
 				HttpPort:      int64(w.httpPort),
 				BinaryVersion: version.Version,
-			}); err != nil {
+			})
+		w.health.Sync(start, err)
+		if err != nil {
 			log.Logger().Error("failed to get meta", zap.Error(err))
 			goto sleep
 		}
 
 		// load master config
-		w.Config.Recommend.Offline.Lock()
-		err = json.Unmarshal([]byte(meta.Config), &w.Config)
-		if err != nil {
-			w.Config.Recommend.Offline.UnLock()
+		masterConfig = new(config.Config)
+		if err = json.Unmarshal([]byte(meta.Config), masterConfig); err != nil {
 			log.Logger().Error("failed to parse master config", zap.Error(err))
 			goto sleep
 		}
-		w.Config.Recommend.Offline.UnLock()
+		if cfg, changed := w.Config().Update(masterConfig); len(changed) > 0 {
+			w.SetConfig(cfg)
+			log.Logger().Info("update master config", zap.Strings("changed", changed))
+		}
 
 		// reset ticker
-		if w.tickDuration != w.Config.Recommend.Offline.CheckRecommendPeriod {
-			w.tickDuration = w.Config.Recommend.Offline.CheckRecommendPeriod
-			w.ticker.Reset(w.Config.Recommend.Offline.CheckRecommendPeriod)
+		if w.tickDuration != w.Config().Recommend.Offline.CheckRecommendPeriod {
+			w.tickDuration = w.Config().Recommend.Offline.CheckRecommendPeriod
+			w.ticker.Reset(w.Config().Recommend.Offline.CheckRecommendPeriod)
 		}
 
 		// connect to data store
-		if w.dataPath != w.Config.Database.DataStore || w.dataPrefix != w.Config.Database.DataTablePrefix {
+		if w.dataPath != w.Config().Database.DataStore || w.dataReplica != w.Config().Database.DataStoreReplica ||
+			w.dataPrefix != w.Config().Database.DataTablePrefix || w.dataLimits != w.Config().Database.DataStoreLimits() ||
+			w.dataQueryLog != w.Config().Database.DataStoreQueryLog() {
 			log.Logger().Info("connect data store",
-				zap.String("database", log.RedactDBURL(w.Config.Database.DataStore)))
-			if w.DataClient, err = data.Open(w.Config.Database.DataStore, w.Config.Database.DataTablePrefix); err != nil {
+				zap.String("database", log.RedactDBURL(w.Config().Database.DataStore)))
+			var dataClient data.Database
+			if dataClient, err = data.OpenWithReplica(w.Config().Database.DataStore,
+				w.Config().Database.DataStoreReplica, w.Config().Database.DataTablePrefix); err != nil {
 				log.Logger().Error("failed to connect data store", zap.Error(err))
 				goto sleep
 			}
-			w.dataPath = w.Config.Database.DataStore
-			w.dataPrefix = w.Config.Database.DataTablePrefix
+			dataClient = data.WithQueryLog(dataClient, w.Config().Database.DataStoreQueryLog())
+			w.DataClient = data.WithLimits(dataClient, w.Config().Database.DataStoreLimits())
+			w.dataLimits = w.Config().Database.DataStoreLimits()
+			w.dataQueryLog = w.Config().Database.DataStoreQueryLog()
+			w.dataPath = w.Config().Database.DataStore
+			w.dataReplica = w.Config().Database.DataStoreReplica
+			w.dataPrefix = w.Config().Database.DataTablePrefix
 		}
 
 		// connect to cache store
-		if w.cachePath != w.Config.Database.CacheStore || w.cachePrefix != w.Config.Database.CacheTablePrefix {
+		if w.cachePath != w.Config().Database.CacheStore || w.cachePrefix != w.Config().Database.CachePrefix() ||
+			w.cacheOptions != w.Config().Database.CacheStoreOptions() {
 			log.Logger().Info("connect cache store",
-				zap.String("database", log.RedactDBURL(w.Config.Database.CacheStore)))
-			if w.CacheClient, err = cache.Open(w.Config.Database.CacheStore, w.Config.Database.CacheTablePrefix); err != nil {
+				zap.String("database", log.RedactDBURL(w.Config().Database.CacheStore)))
+			if w.CacheClient, err = cache.Open(w.Config().Database.CacheStore, w.Config().Database.CachePrefix()); err != nil {
 				log.Logger().Error("failed to connect cache store", zap.Error(err))
 				goto sleep
 			}
-			w.cachePath = w.Config.Database.CacheStore
-			w.cachePrefix = w.Config.Database.CacheTablePrefix
+			cache.Configure(w.CacheClient, w.Config().Database.CacheStoreOptions())
+			w.cachePath = w.Config().Database.CacheStore
+			w.cachePrefix = w.Config().Database.CachePrefix()
+			w.cacheOptions = w.Config().Database.CacheStoreOptions()
+		}
+
+		// connect to feature store
+		if w.featurePath != w.Config().Database.FeatureStore || w.featureOptions != w.Config().Database.FeatureStoreOptions() {
+			var featureClient feature.Store
+			if w.Config().Database.FeatureStore != "" {
+				log.Logger().Info("connect feature store",
+					zap.String("database", log.RedactDBURL(w.Config().Database.FeatureStore)))
+				if featureClient, err = feature.Open(w.Config().Database.FeatureStore, w.Config().Database.FeatureStoreOptions()); err != nil {
+					log.Logger().Error("failed to connect feature store", zap.Error(err))
+					goto sleep
+				}
+			}
+			if w.FeatureClient != nil {
+				_ = w.FeatureClient.Close()
+			}
+			w.FeatureClient = featureClient
+			w.featurePath = w.Config().Database.FeatureStore
+			w.featureOptions = w.Config().Database.FeatureStoreOptions()
 		}
 
 		// check ranking model version
@@ -200,6 +264,7 @@ func (w *Worker) Sync() {
 				zap.String("new_version", encoding.Hex(w.latestRankingModelVersion)))
 			w.syncedChan.Signal()
 		}
+		w.health.TrackModel("ranking_model", w.RankingModelVersion, w.latestRankingModelVersion)
 
 		// check click model version
 		w.latestClickModelVersion = meta.ClickModelVersion
@@ -209,14 +274,18 @@ func (w *Worker) Sync() {
 				zap.String("new_version", encoding.Hex(w.latestClickModelVersion)))
 			w.syncedChan.Signal()
 		}
+		w.health.TrackModel("click_model", w.ClickModelVersion, w.latestClickModelVersion)
 
 		w.peers = meta.Workers
 		w.me = meta.Me
+		w.numUserShards = meta.NumUserShards
+		w.userShards = i32set.New(meta.UserShards...)
+		w.health.Start()
 	sleep:
 		if w.testMode {
 			return
 		}
-		time.Sleep(w.Config.Master.MetaTimeout)
+		time.Sleep(w.Config().Master.MetaTimeout)
 	}
 }
 
@@ -229,47 +298,73 @@ func (w *Worker) Pull() {
 		// pull ranking model
 		if w.latestRankingModelVersion != w.RankingModelVersion {
 			log.Logger().Info("start pull ranking model")
-			if rankingModelReceiver, err := w.masterClient.GetRankingModel(context.Background(),
-				&protocol.VersionInfo{Version: w.latestRankingModelVersion},
-				grpc.MaxCallRecvMsgSize(math.MaxInt)); err != nil {
+			var rankingModel ranking.MatrixFactorization
+			if err := w.pullModel("ranking_model", w.latestRankingModelVersion,
+				func(version *protocol.VersionInfo) (protocol.FragmentReceiver, error) {
+					return w.masterClient.GetRankingModel(context.Background(), version, grpc.MaxCallRecvMsgSize(math.MaxInt))
+				},
+				func(reader io.Reader) (err error) {
+					if rankingModel, err = ranking.UnmarshalModel(reader); err != nil {
+						return
+					}
+					if rankingModel.Invalid() {
+						return errors.NotValidf("ranking model")
+					}
+					return
+				}); err != nil {
 				log.Logger().Error("failed to pull ranking model", zap.Error(err))
 			} else {
-				var rankingModel ranking.MatrixFactorization
-				rankingModel, err = protocol.UnmarshalRankingModel(rankingModelReceiver)
-				if err != nil {
-					log.Logger().Error("failed to unmarshal ranking model", zap.Error(err))
-				} else {
-					w.RankingModel = rankingModel
-					w.rankingIndex = nil
-					w.RankingModelVersion = w.latestRankingModelVersion
-					log.Logger().Info("synced ranking model",
-						zap.String("version", encoding.Hex(w.RankingModelVersion)))
-					MemoryInuseBytesVec.WithLabelValues("collaborative_filtering_model").Set(float64(w.RankingModel.Bytes()))
-					pulled = true
-				}
+				w.modelMutex.Lock()
+				w.RankingModel = rankingModel
+				w.rankingIndex = nil
+				w.RankingModelVersion = w.latestRankingModelVersion
+				w.modelMutex.Unlock()
+				w.health.TrackModel("ranking_model", w.RankingModelVersion, w.latestRankingModelVersion)
+				log.Logger().Info("synced ranking model",
+					zap.String("version", encoding.Hex(w.RankingModelVersion)))
+				MemoryInuseBytesVec.WithLabelValues("collaborative_filtering_model").Set(float64(rankingModel.Bytes()))
+				pulled = true
 			}
 		}
 
 		// pull click model
 		if w.latestClickModelVersion != w.ClickModelVersion {
 			log.Logger().Info("start pull click model")
-			if clickModelReceiver, err := w.masterClient.GetClickModel(context.Background(),
-				&protocol.VersionInfo{Version: w.latestClickModelVersion},
-				grpc.MaxCallRecvMsgSize(math.MaxInt)); err != nil {
+			var clickModel click.FactorizationMachine
+			var objectiveModels map[string]click.FactorizationMachine
+			if err := w.pullModel("click_model", w.latestClickModelVersion,
+				func(version *protocol.VersionInfo) (protocol.FragmentReceiver, error) {
+					return w.masterClient.GetClickModel(context.Background(), version, grpc.MaxCallRecvMsgSize(math.MaxInt))
+				},
+				func(reader io.Reader) (err error) {
+					if clickModel, err = click.UnmarshalModel(reader); err != nil {
+						return
+					}
+					if objectiveModels, err = click.UnmarshalObjectives(reader); err != nil {
+						return
+					}
+					if clickModel.Invalid() {
+						return errors.NotValidf("click model")
+					}
+					for name, objectiveModel := range objectiveModels {
+						if objectiveModel.Invalid() {
+							return errors.NotValidf("click model of objective %s", name)
+						}
+					}
+					return
+				}); err != nil {
 				log.Logger().Error("failed to pull click model", zap.Error(err))
 			} else {
-				var clickModel click.FactorizationMachine
-				clickModel, err = protocol.UnmarshalClickModel(clickModelReceiver)
-				if err != nil {
-					log.Logger().Error("failed to unmarshal click model", zap.Error(err))
-				} else {
-					w.ClickModel = clickModel
-					w.ClickModelVersion = w.latestClickModelVersion
-					log.Logger().Info("synced click model",
-						zap.String("version", encoding.Hex(w.ClickModelVersion)))
-					MemoryInuseBytesVec.WithLabelValues("ranking_model").Set(float64(w.ClickModel.Bytes()))
-					pulled = true
-				}
+				w.modelMutex.Lock()
+				w.ClickModel = clickModel
+				w.ObjectiveModels = objectiveModels
+				w.ClickModelVersion = w.latestClickModelVersion
+				w.modelMutex.Unlock()
+				w.health.TrackModel("click_model", w.ClickModelVersion, w.latestClickModelVersion)
+				log.Logger().Info("synced click model",
+					zap.String("version", encoding.Hex(w.ClickModelVersion)))
+				MemoryInuseBytesVec.WithLabelValues("ranking_model").Set(float64(clickModel.Bytes()))
+				pulled = true
 			}
 		}
 
@@ -282,12 +377,68 @@ func (w *Worker) Pull() {
 	}
 }
 
+// pullModel downloads a model from the master to a partial file and loads it. Downloading resumes from the partial
+// file left by the previous failed attempt, and the partial file is removed once the model is loaded.
+func (w *Worker) pullModel(name string, version int64,
+	get func(*protocol.VersionInfo) (protocol.FragmentReceiver, error),
+	load func(io.Reader) error) error {
+	prefix := w.cacheFile
+	if prefix == "" {
+		prefix = filepath.Join(os.TempDir(), "gorse_worker")
+	}
+	path := fmt.Sprintf("%s.%s_%s.part", prefix, name, encoding.Hex(version))
+	// remove partial files of stale versions
+	if matches, err := filepath.Glob(fmt.Sprintf("%s.%s_*.part", prefix, name)); err == nil {
+		for _, match := range matches {
+			if match != path {
+				_ = os.Remove(match)
+			}
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	offset := info.Size()
+	if offset > 0 {
+		log.Logger().Info("resume pulling model", zap.String("model", name), zap.Int64("offset", offset))
+	}
+	// receive fragments
+	receiver, err := get(&protocol.VersionInfo{Version: version, Offset: offset, Compress: true})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if n, err := protocol.ReceiveFragments(receiver, file, offset); err != nil {
+		if n == 0 && offset > 0 {
+			// the partial file might be unusable, start over next time
+			_ = os.Remove(path)
+		}
+		return errors.Trace(err)
+	}
+	// load model
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return errors.Trace(err)
+	}
+	err = load(bufio.NewReader(file))
+	_ = file.Close()
+	_ = os.Remove(path)
+	return errors.Trace(err)
+}
+
 // ServeHTTP serves Prometheus metrics and API.
 func (w *Worker) ServeHTTP() {
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/api/health/live", w.checkLive)
-	http.HandleFunc("/api/admin/schedule", w.ScheduleAPIHandler)
-	err := http.ListenAndServe(fmt.Sprintf("%s:%d", w.httpHost, w.httpPort), nil)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/api/health/startup", w.checkStartup)
+	mux.HandleFunc("/api/health/live", w.checkLive)
+	mux.HandleFunc("/api/health/ready", w.checkReady)
+	mux.HandleFunc("/api/admin/schedule", w.ScheduleAPIHandler)
+	err := http.ListenAndServe(fmt.Sprintf("%s:%d", w.httpHost, w.httpPort), mux)
 	if err != nil {
 		log.Logger().Fatal("failed to start http server", zap.Error(err))
 	}
@@ -316,10 +467,10 @@ func (w *Worker) ScheduleAPIHandler(writer http.ResponseWriter, request *http.Re
 }
 
 func (w *Worker) checkAdmin(request *http.Request) bool {
-	if w.Config.Master.AdminAPIKey == "" {
+	if w.Config().Master.AdminAPIKey == "" {
 		return true
 	}
-	if request.FormValue("X-API-Key") == w.Config.Master.AdminAPIKey {
+	if request.FormValue("X-API-Key") == w.Config().Master.AdminAPIKey {
 		return true
 	}
 	return false
@@ -369,7 +520,11 @@ func (w *Worker) Serve() {
 	}
 
 	// connect to master
-	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", w.masterHost, w.masterPort), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	opt, err := protocol.NewDialOption(w.tlsConfig)
+	if err != nil {
+		log.Logger().Fatal("failed to load tls config", zap.Error(err))
+	}
+	conn, err := grpc.Dial(fmt.Sprintf("%v:%v", w.masterHost, w.masterPort), opt)
 	if err != nil {
 		log.Logger().Fatal("failed to connect master", zap.Error(err))
 	}
@@ -413,7 +568,7 @@ func (w *Worker) Serve() {
 		for {
 			select {
 			case tick := <-w.ticker.C:
-				if time.Since(tick) < w.Config.Recommend.Offline.CheckRecommendPeriod {
+				if time.Since(tick) < w.Config().Recommend.Offline.CheckRecommendPeriod {
 					loop()
 				}
 			case <-w.pulledChan.C:
@@ -425,8 +580,8 @@ func (w *Worker) Serve() {
 
 func (w *Worker) estimateRecommendComplexity(numUsers, numItems int) int {
 	complexity := numUsers * numItems * recommendComplexityFactor
-	if w.Config.Recommend.Collaborative.EnableIndex {
-		complexity += search.EstimateHNSWBuilderComplexity(numItems, w.Config.Recommend.Collaborative.IndexFitEpoch)
+	if w.Config().Recommend.Collaborative.EnableIndex {
+		complexity += search.EstimateHNSWBuilderComplexity(numItems, w.Config().Recommend.Collaborative.IndexFitEpoch)
 	}
 	return complexity
 }
@@ -441,12 +596,15 @@ func (w *Worker) estimateRecommendComplexity(numUsers, numItems int) int {
 // 7. Rank items in results by click-through-rate.
 // 8. Refresh cache.
 func (w *Worker) Recommend(users []data.User) {
+	// models pulled during this batch are swapped in once the batch completes
+	w.modelMutex.RLock()
+	defer w.modelMutex.RUnlock()
 	ctx := context.Background()
 	startRecommendTime := time.Now()
 	log.Logger().Info("ranking recommendation",
 		zap.Int("n_working_users", len(users)),
 		zap.Int("n_jobs", w.jobs),
-		zap.Int("cache_size", w.Config.Recommend.CacheSize))
+		zap.Int("cache_size", w.Config().Recommend.CacheSize))
 
 	// pull items from database
 	itemCache, itemCategories, err := w.pullItems(ctx)
@@ -457,6 +615,33 @@ func (w *Worker) Recommend(users []data.User) {
 	MemoryInuseBytesVec.WithLabelValues("item_cache").Set(float64(itemCache.Bytes()))
 	defer MemoryInuseBytesVec.WithLabelValues("item_cache").Set(0)
 
+	// pull item frequency to penalize popular items
+	w.itemFrequency = nil
+	if w.Config().Recommend.Offline.PopularityPenalty > 0 {
+		if w.itemFrequency, err = w.pullItemFrequency(ctx); err != nil {
+			log.Logger().Error("failed to pull item frequency", zap.Error(err))
+		}
+	}
+
+	// pull user segments to override exploration weights
+	segments, err := server.LoadSegments(ctx, w.CacheClient)
+	if err != nil {
+		log.Logger().Error("failed to pull user segments", zap.Error(err))
+	}
+
+	// look up registered custom recommenders
+	customRecommenders := make(map[string]recommender.Recommender)
+	for _, name := range w.Config().Recommend.Offline.CustomRecommenders {
+		if customRecommenders[name], err = recommender.Get(name); err != nil {
+			log.Logger().Error("failed to load custom recommender", zap.String("recommender", name), zap.Error(err))
+			delete(customRecommenders, name)
+		}
+	}
+
+	// skip users completed before restart and refresh recently active users first
+	users, checkpoint := w.resumeCheckpoint(ctx, users)
+	users = w.prioritizeUsers(ctx, users)
+
 	// progress tracker
 	completed := make(chan struct{}, 1000)
 	recommendTaskName := "Generate offline recommendation"
@@ -493,8 +678,12 @@ func (w *Worker) Recommend(users []data.User) {
 						zap.Int("n_working_users", len(users)),
 						zap.Int("throughput", throughput))
 				}
-				if _, err := w.masterClient.PushTaskInfo(context.Background(), protocol.EncodeTask(recommendTask)); err != nil {
-					log.Logger().Error("failed to report update task", zap.Error(err))
+				if w.masterClient != nil {
+					if resp, err := w.masterClient.PushTaskInfo(context.Background(), protocol.EncodeTask(recommendTask)); err != nil {
+						log.Logger().Error("failed to report update task", zap.Error(err))
+					} else if resp.GetCancelled() {
+						recommendTask.Cancel()
+					}
 				}
 			}
 		}
@@ -502,7 +691,7 @@ func (w *Worker) Recommend(users []data.User) {
 
 	// build ranking index
 	if w.RankingModel != nil && !w.RankingModel.Invalid() && w.rankingIndex == nil {
-		if w.Config.Recommend.Collaborative.EnableIndex {
+		if w.Config().Recommend.Collaborative.EnableIndex {
 			startTime := time.Now()
 			log.Logger().Info("start building ranking index")
 			itemIndex := w.RankingModel.GetItemIndex()
@@ -515,10 +704,10 @@ func (w *Worker) Recommend(users []data.User) {
 					vectors[i] = search.NewDenseVector(w.RankingModel.GetItemFactor(i), nil, true)
 				}
 			}
-			builder := search.NewHNSWBuilder(vectors, w.Config.Recommend.CacheSize, w.jobs)
+			builder := search.NewHNSWBuilder(vectors, w.Config().Recommend.CacheSize, w.jobs)
 			var recall float32
-			w.rankingIndex, recall = builder.Build(w.Config.Recommend.Collaborative.IndexRecall,
-				w.Config.Recommend.Collaborative.IndexFitEpoch, false, recommendTask)
+			w.rankingIndex, recall = builder.Build(w.Config().Recommend.Collaborative.IndexRecall,
+				w.Config().Recommend.Collaborative.IndexFitEpoch, false, recommendTask)
 			CollaborativeFilteringIndexRecall.Set(float64(recall))
 			if err = w.CacheClient.Set(ctx, cache.String(cache.Key(cache.GlobalMeta, cache.MatchingIndexRecall), encoding.FormatFloat32(recall))); err != nil {
 				log.Logger().Error("failed to write meta", zap.Error(err))
@@ -541,16 +730,20 @@ func (w *Worker) Recommend(users []data.User) {
 		popularRecommendSeconds       atomic.Float64
 	)
 
-	userFeedbackCache := NewFeedbackCache(w, w.Config.Recommend.DataSource.PositiveFeedbackTypes...)
+	userFeedbackCache := NewFeedbackCache(w, w.Config().Recommend.DataSource.PositiveFeedbackTypes...)
 	defer MemoryInuseBytesVec.WithLabelValues("user_feedback_cache").Set(0)
 	err = parallel.Parallel(len(users), w.jobs, func(workerId, jobId int) error {
 		defer func() {
 			completed <- struct{}{}
 		}()
+		if recommendTask.IsCancelled() {
+			return nil
+		}
 		user := users[jobId]
 		userId := user.UserId
 		// skip inactive users before max recommend period
 		if !w.checkRecommendCacheTimeout(ctx, userId, itemCategories) {
+			checkpoint.Done(ctx, userId)
 			return nil
 		}
 		updateUserCount.Add(1)
@@ -563,10 +756,15 @@ func (w *Worker) Recommend(users []data.User) {
 				zap.String("user_id", userId), zap.Error(err))
 			return errors.Trace(err)
 		}
+		// items suppressed by negative feedback never become candidates
+		suppressedItems := w.suppressedItems(feedbacks)
+		for itemId := range suppressedItems {
+			excludeSet.Add(itemId)
+		}
 
 		// load positive items
 		var positiveItems []string
-		if w.Config.Recommend.Offline.EnableItemBasedRecommend {
+		if w.Config().Recommend.Offline.EnableItemBasedRecommend {
 			positiveItems, err = userFeedbackCache.GetUserFeedback(ctx, userId)
 			if err != nil {
 				log.Logger().Error("failed to pull user feedback",
@@ -585,11 +783,11 @@ func (w *Worker) Recommend(users []data.User) {
 
 		// Recommender #1: collaborative filtering.
 		collaborativeUsed := false
-		if w.Config.Recommend.Offline.EnableColRecommend && w.RankingModel != nil && !w.RankingModel.Invalid() {
+		if w.Config().Recommend.Offline.EnableColRecommend && w.RankingModel != nil && !w.RankingModel.Invalid() {
 			if userIndex := w.RankingModel.GetUserIndex().ToNumber(userId); w.RankingModel.IsUserPredictable(userIndex) {
 				var recommend map[string][]string
 				var usedTime time.Duration
-				if w.Config.Recommend.Collaborative.EnableIndex && w.rankingIndex != nil {
+				if w.Config().Recommend.Collaborative.EnableIndex && w.rankingIndex != nil {
 					recommend, usedTime, err = w.collaborativeRecommendHNSW(w.rankingIndex, userId, itemCategories, excludeSet, itemCache)
 				} else {
 					recommend, usedTime, err = w.collaborativeRecommendBruteForce(userId, itemCategories, excludeSet, itemCache)
@@ -613,14 +811,14 @@ func (w *Worker) Recommend(users []data.User) {
 
 		// Recommender #2: item-based.
 		itemNeighborDigests := strset.New()
-		if w.Config.Recommend.Offline.EnableItemBasedRecommend {
+		if w.Config().Recommend.Offline.EnableItemBasedRecommend {
 			localStartTime := time.Now()
 			for _, category := range append([]string{""}, itemCategories...) {
 				// collect candidates
 				scores := make(map[string]float64)
 				for _, itemId := range positiveItems {
 					// load similar items
-					similarItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, itemId, category), 0, w.Config.Recommend.CacheSize)
+					similarItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.ItemNeighbors, itemId, category), 0, w.Config().Recommend.CacheSize)
 					if err != nil {
 						log.Logger().Error("failed to load similar items", zap.Error(err))
 						return errors.Trace(err)
@@ -642,7 +840,7 @@ func (w *Worker) Recommend(users []data.User) {
 					itemNeighborDigests.Add(digest)
 				}
 				// collect top k
-				filter := heap.NewTopKFilter[string, float64](w.Config.Recommend.CacheSize)
+				filter := heap.NewTopKFilter[string, float64](w.Config().Recommend.CacheSize)
 				for id, score := range scores {
 					filter.Push(id, score)
 				}
@@ -654,11 +852,11 @@ func (w *Worker) Recommend(users []data.User) {
 
 		// Recommender #3: insert user-based items
 		userNeighborDigests := strset.New()
-		if w.Config.Recommend.Offline.EnableUserBasedRecommend {
+		if w.Config().Recommend.Offline.EnableUserBasedRecommend {
 			localStartTime := time.Now()
 			scores := make(map[string]float64)
 			// load similar users
-			similarUsers, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, userId), 0, w.Config.Recommend.CacheSize)
+			similarUsers, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.UserNeighbors, userId), 0, w.Config().Recommend.CacheSize)
 			if err != nil {
 				log.Logger().Error("failed to load similar users", zap.Error(err))
 				return errors.Trace(err)
@@ -690,9 +888,9 @@ func (w *Worker) Recommend(users []data.User) {
 			}
 			// collect top k
 			filters := make(map[string]*heap.TopKFilter[string, float64])
-			filters[""] = heap.NewTopKFilter[string, float64](w.Config.Recommend.CacheSize)
+			filters[""] = heap.NewTopKFilter[string, float64](w.Config().Recommend.CacheSize)
 			for _, category := range itemCategories {
-				filters[category] = heap.NewTopKFilter[string, float64](w.Config.Recommend.CacheSize)
+				filters[category] = heap.NewTopKFilter[string, float64](w.Config().Recommend.CacheSize)
 			}
 			for id, score := range scores {
 				filters[""].Push(id, score)
@@ -708,16 +906,19 @@ func (w *Worker) Recommend(users []data.User) {
 		}
 
 		// Recommender #4: latest items.
-		if w.Config.Recommend.Offline.EnableLatestRecommend {
+		if w.Config().Recommend.Offline.EnableLatestRecommend {
 			localStartTime := time.Now()
-			for _, category := range append([]string{""}, itemCategories...) {
-				latestItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.LatestItems, category), 0, w.Config.Recommend.CacheSize)
-				if err != nil {
-					log.Logger().Error("failed to load latest items", zap.Error(err))
-					return errors.Trace(err)
-				}
+			categories := append([]string{""}, itemCategories...)
+			latestItemsLists, err := w.CacheClient.BatchGetSorted(ctx, lo.Map(categories, func(category string, _ int) string {
+				return cache.Key(cache.LatestItems, category)
+			}), 0, w.Config().Recommend.CacheSize)
+			if err != nil {
+				log.Logger().Error("failed to load latest items", zap.Error(err))
+				return errors.Trace(err)
+			}
+			for i, category := range categories {
 				var recommend []string
-				for _, latestItem := range latestItems {
+				for _, latestItem := range latestItemsLists[i] {
 					if !excludeSet.Has(latestItem.Id) && itemCache.IsAvailable(latestItem.Id) {
 						recommend = append(recommend, latestItem.Id)
 					}
@@ -728,23 +929,54 @@ func (w *Worker) Recommend(users []data.User) {
 		}
 
 		// Recommender #5: popular items.
-		if w.Config.Recommend.Offline.EnablePopularRecommend {
+		if w.Config().Recommend.Offline.EnablePopularRecommend {
 			localStartTime := time.Now()
+			categories := append([]string{""}, itemCategories...)
+			popularItemsLists, err := w.CacheClient.BatchGetSorted(ctx, lo.Map(categories, func(category string, _ int) string {
+				return cache.Key(cache.PopularItems, category)
+			}), 0, w.Config().Recommend.CacheSize)
+			if err != nil {
+				log.Logger().Error("failed to load popular items", zap.Error(err))
+				return errors.Trace(err)
+			}
+			for i, category := range categories {
+				var recommend []string
+				for _, popularItem := range popularItemsLists[i] {
+					if !excludeSet.Has(popularItem.Id) && itemCache.IsAvailable(popularItem.Id) {
+						recommend = append(recommend, popularItem.Id)
+					}
+				}
+				candidates[category] = append(candidates[category], recommend)
+			}
+			popularRecommendSeconds.Add(time.Since(localStartTime).Seconds())
+		}
+
+		// Recommender #6: custom recommenders.
+		for _, name := range w.Config().Recommend.Offline.CustomRecommenders {
+			customRecommender, exist := customRecommenders[name]
+			if !exist {
+				continue
+			}
 			for _, category := range append([]string{""}, itemCategories...) {
-				popularItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, category), 0, w.Config.Recommend.CacheSize)
+				items, err := customRecommender.Recommend(ctx, &recommender.Request{
+					Settings: w.Settings,
+					UserId:   userId,
+					Category: category,
+					N:        w.Config().Recommend.CacheSize,
+				})
 				if err != nil {
-					log.Logger().Error("failed to load popular items", zap.Error(err))
+					log.Logger().Error("failed to recommend by custom recommender",
+						zap.String("recommender", name), zap.String("user_id", userId), zap.Error(err))
 					return errors.Trace(err)
 				}
 				var recommend []string
-				for _, popularItem := range popularItems {
-					if !excludeSet.Has(popularItem.Id) && itemCache.IsAvailable(popularItem.Id) {
-						recommend = append(recommend, popularItem.Id)
+				for _, item := range items {
+					if !excludeSet.Has(item.Id) && itemCache.IsAvailable(item.Id) {
+						recommend = append(recommend, item.Id)
 					}
 				}
 				candidates[category] = append(candidates[category], recommend)
 			}
-			popularRecommendSeconds.Add(time.Since(localStartTime).Seconds())
 		}
 
 		// rank items from different recommenders
@@ -754,7 +986,7 @@ func (w *Worker) Recommend(users []data.User) {
 		ctrUsed := false
 		results := make(map[string][]cache.Scored)
 		for category, catCandidates := range candidates {
-			if w.Config.Recommend.Offline.EnableClickThroughPrediction && w.ClickModel != nil && !w.ClickModel.Invalid() {
+			if w.Config().Recommend.Offline.EnableClickThroughPrediction && w.ClickModel != nil && !w.ClickModel.Invalid() {
 				results[category], err = w.rankByClickTroughRate(&user, catCandidates, itemCache)
 				if err != nil {
 					log.Logger().Error("failed to rank items", zap.Error(err))
@@ -769,36 +1001,45 @@ func (w *Worker) Recommend(users []data.User) {
 					return errors.Trace(err)
 				}
 			} else {
-				results[category] = w.mergeAndShuffle(catCandidates)
+				results[category] = w.mergeAndShuffle(catCandidates, w.userRand(userId, category, startTime))
 			}
 		}
 
 		// replacement
-		if w.Config.Recommend.Replacement.EnableReplacement {
-			if results, err = w.replacement(results, &user, feedbacks, itemCache); err != nil {
+		if w.Config().Recommend.Replacement.EnableReplacement {
+			if results, err = w.replacement(results, &user, feedbacks, suppressedItems, itemCache); err != nil {
 				log.Logger().Error("failed to replace items", zap.Error(err))
 				return errors.Trace(err)
 			}
 		}
 
+		// normalize scores of items not ranked by click-through rates
+		if w.Config().Recommend.Offline.NormalizeScores && !ctrUsed {
+			for category, result := range results {
+				results[category] = normalizeScores(result)
+			}
+		}
+
 		// explore latest and popular
+		segment := server.MatchSegment(segments, user.Labels)
+		sortedSets := make([]cache.SortedSet, 0, len(results))
 		for category, result := range results {
-			results[category], err = w.exploreRecommend(result, excludeSet, category)
+			results[category], err = w.exploreRecommend(result, excludeSet, category, segment, w.userRand(userId, category, startTime))
 			if err != nil {
 				log.Logger().Error("failed to explore latest and popular items", zap.Error(err))
 				return errors.Trace(err)
 			}
-
-			if err = w.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, userId, category), results[category]); err != nil {
-				log.Logger().Error("failed to cache recommendation", zap.Error(err))
-				return errors.Trace(err)
-			}
+			sortedSets = append(sortedSets, cache.Sorted(cache.Key(cache.OfflineRecommend, userId, category), results[category]))
+		}
+		if err = w.CacheClient.BatchSetSorted(ctx, sortedSets...); err != nil {
+			log.Logger().Error("failed to cache recommendation", zap.Error(err))
+			return errors.Trace(err)
 		}
 		recommendTime := time.Now()
 		if err = w.CacheClient.Set(
 			ctx,
 			cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, userId), recommendTime),
-			cache.String(cache.Key(cache.OfflineRecommendDigest, userId), w.Config.OfflineRecommendDigest(
+			cache.String(cache.Key(cache.OfflineRecommendDigest, userId), w.Config().OfflineRecommendDigest(
 				config.WithCollaborative(collaborativeUsed),
 				config.WithRanking(ctrUsed),
 				config.WithItemNeighborDigest(strings.Join(itemNeighborDigests.List(), "-")),
@@ -808,18 +1049,30 @@ func (w *Worker) Recommend(users []data.User) {
 		}
 
 		// refresh cache
-		err = w.refreshCache(ctx, userId, recommendTime)
+		err = w.refreshCache(ctx, userId, recommendTime, suppressedItems)
 		if err != nil {
 			log.Logger().Error("failed to refresh cache", zap.Error(err))
 			return errors.Trace(err)
 		}
+		checkpoint.Done(ctx, userId)
 		return nil
 	})
 	close(completed)
-	if err != nil {
+	if recommendTask.IsCancelled() {
+		log.Logger().Info("offline recommendation cancelled")
+		if w.masterClient != nil {
+			if _, err := w.masterClient.PushTaskInfo(context.Background(), protocol.EncodeTask(recommendTask)); err != nil {
+				log.Logger().Error("failed to report cancel task", zap.Error(err))
+			}
+		}
+		return
+	} else if err != nil {
 		log.Logger().Error("failed to continue offline recommendation", zap.Error(err))
 		return
 	}
+	if err = w.clearCheckpoint(ctx); err != nil {
+		log.Logger().Error("failed to clear checkpoint", zap.Error(err))
+	}
 	if w.masterClient != nil {
 		recommendTask.Finish()
 		if _, err := w.masterClient.PushTaskInfo(context.Background(), protocol.EncodeTask(recommendTask)); err != nil {
@@ -843,28 +1096,30 @@ func (w *Worker) collaborativeRecommendBruteForce(userId string, itemCategories
 	itemIds := w.RankingModel.GetItemIndex().GetNames()
 	localStartTime := time.Now()
 	recItemsFilters := make(map[string]*heap.TopKFilter[string, float64])
-	recItemsFilters[""] = heap.NewTopKFilter[string, float64](w.Config.Recommend.CacheSize)
+	recItemsFilters[""] = heap.NewTopKFilter[string, float64](w.Config().Recommend.CacheSize)
 	for _, category := range itemCategories {
-		recItemsFilters[category] = heap.NewTopKFilter[string, float64](w.Config.Recommend.CacheSize)
+		recItemsFilters[category] = heap.NewTopKFilter[string, float64](w.Config().Recommend.CacheSize)
 	}
 	for itemIndex, itemId := range itemIds {
 		if !excludeSet.Has(itemId) && itemCache.IsAvailable(itemId) && w.RankingModel.IsItemPredictable(int32(itemIndex)) {
-			prediction := w.RankingModel.InternalPredict(userIndex, int32(itemIndex))
-			recItemsFilters[""].Push(itemId, float64(prediction))
+			prediction := w.penalizePopularity(itemId, float64(w.RankingModel.InternalPredict(userIndex, int32(itemIndex))))
+			recItemsFilters[""].Push(itemId, prediction)
 			for _, category := range itemCache.GetCategory(itemId) {
-				recItemsFilters[category].Push(itemId, float64(prediction))
+				recItemsFilters[category].Push(itemId, prediction)
 			}
 		}
 	}
 	// save result
 	recommend := make(map[string][]string)
+	sortedSets := make([]cache.SortedSet, 0, len(recItemsFilters))
 	for category, recItemsFilter := range recItemsFilters {
 		recommendItems, recommendScores := recItemsFilter.PopAll()
 		recommend[category] = recommendItems
-		if err := w.CacheClient.SetSorted(ctx, cache.Key(cache.CollaborativeRecommend, userId, category), cache.CreateScoredItems(recommendItems, recommendScores)); err != nil {
-			log.Logger().Error("failed to cache collaborative filtering recommendation result", zap.String("user_id", userId), zap.Error(err))
-			return nil, 0, errors.Trace(err)
-		}
+		sortedSets = append(sortedSets, cache.Sorted(cache.Key(cache.CollaborativeRecommend, userId, category), cache.CreateScoredItems(recommendItems, recommendScores)))
+	}
+	if err := w.CacheClient.BatchSetSorted(ctx, sortedSets...); err != nil {
+		log.Logger().Error("failed to cache collaborative filtering recommendation result", zap.String("user_id", userId), zap.Error(err))
+		return nil, 0, errors.Trace(err)
 	}
 	return recommend, time.Since(localStartTime), nil
 }
@@ -874,25 +1129,30 @@ func (w *Worker) collaborativeRecommendHNSW(rankingIndex *search.HNSW, userId st
 	userIndex := w.RankingModel.GetUserIndex().ToNumber(userId)
 	localStartTime := time.Now()
 	values, scores := rankingIndex.MultiSearch(search.NewDenseVector(w.RankingModel.GetUserFactor(userIndex), nil, false),
-		itemCategories, w.Config.Recommend.CacheSize+excludeSet.Size(), false)
+		itemCategories, w.Config().Recommend.CacheSize+excludeSet.Size(), false)
 	// save result
 	recommend := make(map[string][]string)
+	sortedSets := make([]cache.SortedSet, 0, len(values))
 	for category, catValues := range values {
-		recommendItems := make([]string, 0, len(catValues))
-		recommendScores := make([]float64, 0, len(catValues))
+		recommendItems := make([]cache.Scored, 0, len(catValues))
 		for i := range catValues {
 			itemId := w.RankingModel.GetItemIndex().ToName(catValues[i])
 			if !excludeSet.Has(itemId) && itemCache.IsAvailable(itemId) {
-				recommendItems = append(recommendItems, itemId)
-				recommendScores = append(recommendScores, float64(scores[category][i]))
+				recommendItems = append(recommendItems, cache.Scored{
+					Id:    itemId,
+					Score: w.penalizePopularity(itemId, float64(scores[category][i])),
+				})
 			}
 		}
-		recommend[category] = recommendItems
-		if err := w.CacheClient.SetSorted(ctx, cache.Key(cache.CollaborativeRecommend, userId, category),
-			cache.CreateScoredItems(recommendItems, recommendScores)); err != nil {
-			log.Logger().Error("failed to cache collaborative filtering recommendation result", zap.String("user_id", userId), zap.Error(err))
-			return nil, 0, errors.Trace(err)
+		if w.itemFrequency != nil {
+			cache.SortScores(recommendItems)
 		}
+		recommend[category] = cache.RemoveScores(recommendItems)
+		sortedSets = append(sortedSets, cache.Sorted(cache.Key(cache.CollaborativeRecommend, userId, category), recommendItems))
+	}
+	if err := w.CacheClient.BatchSetSorted(ctx, sortedSets...); err != nil {
+		log.Logger().Error("failed to cache collaborative filtering recommendation result", zap.String("user_id", userId), zap.Error(err))
+		return nil, 0, errors.Trace(err)
 	}
 	return recommend, time.Since(localStartTime), nil
 }
@@ -914,13 +1174,39 @@ func (w *Worker) rankByCollaborativeFiltering(userId string, candidates [][]stri
 	for _, itemId := range itemIds {
 		topItems = append(topItems, cache.Scored{
 			Id:    itemId,
-			Score: float64(w.RankingModel.Predict(userId, itemId)),
+			Score: w.penalizePopularity(itemId, float64(w.RankingModel.Predict(userId, itemId))),
 		})
 	}
 	cache.SortScores(topItems)
 	return topItems, nil
 }
 
+// pullItemFrequency pulls numbers of positive feedback of items from cache.
+func (w *Worker) pullItemFrequency(ctx context.Context) (map[string]float64, error) {
+	scores, err := w.CacheClient.GetSorted(ctx, cache.ItemFrequency, 0, -1)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	frequency := make(map[string]float64, len(scores))
+	for _, score := range scores {
+		frequency[score.Id] = score.Score
+	}
+	return frequency, nil
+}
+
+// penalizePopularity divides a positive collaborative filtering score by (frequency + 1)^alpha and multiplies a
+// negative score by it, so that popular items always lose scores.
+func (w *Worker) penalizePopularity(itemId string, score float64) float64 {
+	if w.itemFrequency == nil {
+		return score
+	}
+	penalty := math.Pow(w.itemFrequency[itemId]+1, w.Config().Recommend.Offline.PopularityPenalty)
+	if score < 0 {
+		return score * penalty
+	}
+	return score / penalty
+}
+
 // rankByClickTroughRate ranks items by predicted click-through-rate.
 func (w *Worker) rankByClickTroughRate(user *data.User, candidates [][]string, itemCache *ItemCache) ([]cache.Scored, error) {
 	// concat candidates
@@ -943,19 +1229,104 @@ func (w *Worker) rankByClickTroughRate(user *data.User, candidates [][]string, i
 			log.Logger().Warn("item doesn't exists in database", zap.String("item_id", itemId))
 		}
 	}
+	user, items = w.appendStoreFeatures(context.Background(), user, items)
 	// rank by CTR
 	topItems := make([]cache.Scored, 0, len(items))
 	for _, item := range items {
 		topItems = append(topItems, cache.Scored{
 			Id:    item.ItemId,
-			Score: float64(w.ClickModel.Predict(user.UserId, item.ItemId, user.Labels, item.Labels)),
+			Score: w.predictClickThroughRate(user, item),
 		})
 	}
 	cache.SortScores(topItems)
 	return topItems, nil
 }
 
-func (w *Worker) mergeAndShuffle(candidates [][]string) []cache.Scored {
+// appendStoreFeatures returns copies of the user and items whose labels are followed by features from the feature store.
+// The user and items are returned as they are if the feature store is not configured or fails.
+func (w *Worker) appendStoreFeatures(ctx context.Context, user *data.User, items []*data.Item) (*data.User, []*data.Item) {
+	if w.FeatureClient == nil {
+		return user, items
+	}
+	if userFeatures, err := w.FeatureClient.GetUserFeatures(ctx, []string{user.UserId}); err != nil {
+		log.Logger().Warn("failed to fetch user features from feature store", zap.String("user_id", user.UserId), zap.Error(err))
+	} else if features := userFeatures[user.UserId]; len(features) > 0 {
+		featuredUser := *user
+		featuredUser.Labels = feature.AppendFeatures(user.Labels, features)
+		user = &featuredUser
+	}
+	itemIds := make([]string, len(items))
+	for i, item := range items {
+		itemIds[i] = item.ItemId
+	}
+	itemFeatures, err := w.FeatureClient.GetItemFeatures(ctx, itemIds)
+	if err != nil {
+		log.Logger().Warn("failed to fetch item features from feature store", zap.Int("n_items", len(items)), zap.Error(err))
+		return user, items
+	}
+	featuredItems := make([]*data.Item, len(items))
+	for i, item := range items {
+		featuredItems[i] = item
+		if features := itemFeatures[item.ItemId]; len(features) > 0 {
+			featuredItem := *item
+			featuredItem.Labels = feature.AppendFeatures(item.Labels, features)
+			featuredItems[i] = &featuredItem
+		}
+	}
+	return user, featuredItems
+}
+
+// predictClickThroughRate predicts the score of an item for a user by the click model. The score is the raw output of
+// the click model unless the model is calibrated, scores are normalized or objectives are configured, in which case the
+// score is the click probability plus probabilities of objectives multiplied by weights. Objectives without models are
+// ignored.
+func (w *Worker) predictClickThroughRate(user *data.User, item *data.Item) float64 {
+	score := w.ClickModel.Predict(user.UserId, item.ItemId, user.Labels, item.Labels)
+	if len(w.Config().Recommend.Offline.Objectives) == 0 &&
+		!w.Config().Recommend.Offline.NormalizeScores && !click.IsCalibrated(w.ClickModel) {
+		return float64(score)
+	}
+	probability := click.Probability(w.ClickModel, score)
+	for _, objective := range w.Config().Recommend.Offline.Objectives {
+		if objectiveModel, exist := w.ObjectiveModels[objective.Name]; exist && !objectiveModel.Invalid() {
+			probability += objective.Weight * click.Probability(objectiveModel, objectiveModel.Predict(user.UserId, item.ItemId, user.Labels, item.Labels))
+		}
+	}
+	return probability
+}
+
+// normalizeScores scales scores of recommendation to [0, 1] by min-max scaling. Scores are set to 1 if they are all
+// equal.
+func normalizeScores(recommend []cache.Scored) []cache.Scored {
+	if len(recommend) == 0 {
+		return recommend
+	}
+	scores := cache.GetScores(recommend)
+	minScore, maxScore := lo.Min(scores), lo.Max(scores)
+	normalized := make([]cache.Scored, len(recommend))
+	for i, item := range recommend {
+		normalized[i] = cache.Scored{Id: item.Id, Score: 1}
+		if maxScore > minScore {
+			normalized[i].Score = (item.Score - minScore) / (maxScore - minScore)
+		}
+	}
+	return normalized
+}
+
+// userRand returns the random generator for shuffling and exploration of a user in a category. The generator is seeded
+// by the user, the category and the current shuffle period, so that the recommendation of a user is stable within a
+// period and rotates in the next period. The shared generator is used if the shuffle period is 0.
+func (w *Worker) userRand(userId, category string, now time.Time) *rand.Rand {
+	period := w.Config().Recommend.Offline.ShufflePeriod
+	if period <= 0 {
+		return w.randGenerator
+	}
+	seed := int64(crc32.ChecksumIEEE([]byte(cache.Key(userId, category))))
+	return base.NewRand(seed<<32 ^ now.Truncate(period).Unix())
+}
+
+// mergeAndShuffle merges candidates from recommenders by selecting recommenders randomly.
+func (w *Worker) mergeAndShuffle(candidates [][]string, randGenerator *rand.Rand) []cache.Scored {
 	memo := strset.New()
 	pos := make([]int, len(candidates))
 	var recommend []cache.Scored
@@ -971,7 +1342,7 @@ func (w *Worker) mergeAndShuffle(candidates [][]string) []cache.Scored {
 			break
 		}
 		// select a slice randomly
-		j := src[w.randGenerator.Intn(len(src))]
+		j := src[randGenerator.Intn(len(src))]
 		candidateId := candidates[j][pos[j]]
 		pos[j]++
 		if !memo.Has(candidateId) {
@@ -982,30 +1353,47 @@ func (w *Worker) mergeAndShuffle(candidates [][]string) []cache.Scored {
 	return recommend
 }
 
-func (w *Worker) exploreRecommend(exploitRecommend []cache.Scored, excludeSet *strset.Set, category string) ([]cache.Scored, error) {
+// exploreRecommend injects popular, latest and cold-start items into recommendation. Exploration weights of the user
+// segment take precedence over explore_recommend.
+func (w *Worker) exploreRecommend(exploitRecommend []cache.Scored, excludeSet *strset.Set, category string, segment *server.Segment, randGenerator *rand.Rand) ([]cache.Scored, error) {
 	var localExcludeSet *strset.Set
 	ctx := context.Background()
-	if w.Config.Recommend.Replacement.EnableReplacement {
+	if w.Config().Recommend.Replacement.EnableReplacement {
 		localExcludeSet = strset.New()
 	} else {
 		localExcludeSet = excludeSet.Copy()
 	}
 	// create thresholds
+	getExploreRecommend := func(recommender string) (float64, bool) {
+		if threshold, exist := segment.GetExploreRecommend(recommender); exist {
+			return threshold, true
+		}
+		return w.Config().Recommend.Offline.GetExploreRecommend(recommender)
+	}
 	explorePopularThreshold := 0.0
-	if threshold, exist := w.Config.Recommend.Offline.GetExploreRecommend("popular"); exist {
+	if threshold, exist := getExploreRecommend("popular"); exist {
 		explorePopularThreshold = threshold
 	}
 	exploreLatestThreshold := explorePopularThreshold
-	if threshold, exist := w.Config.Recommend.Offline.GetExploreRecommend("latest"); exist {
+	if threshold, exist := getExploreRecommend("latest"); exist {
 		exploreLatestThreshold += threshold
 	}
+	exploreColdStartThreshold := exploreLatestThreshold
+	if threshold, exist := getExploreRecommend("cold_start"); exist {
+		exploreColdStartThreshold += threshold
+	}
 	// load popular items
-	popularItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, category), 0, w.Config.Recommend.CacheSize)
+	popularItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.PopularItems, category), 0, w.Config().Recommend.CacheSize)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	// load the latest items
-	latestItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.LatestItems, category), 0, w.Config.Recommend.CacheSize)
+	latestItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.LatestItems, category), 0, w.Config().Recommend.CacheSize)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// load cold-start items
+	coldStartItems, err := w.CacheClient.GetSorted(ctx, cache.Key(cache.ColdStartItems, category), 0, w.Config().Recommend.CacheSize)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -1016,7 +1404,7 @@ func (w *Worker) exploreRecommend(exploitRecommend []cache.Scored, excludeSet *s
 		score += exploitRecommend[0].Score
 	}
 	for range exploitRecommend {
-		dice := w.randGenerator.Float64()
+		dice := randGenerator.Float64()
 		var recommendItem cache.Scored
 		if dice < explorePopularThreshold && len(popularItems) > 0 {
 			score -= 1e-5
@@ -1028,6 +1416,11 @@ func (w *Worker) exploreRecommend(exploitRecommend []cache.Scored, excludeSet *s
 			recommendItem = latestItems[0]
 			recommendItem.Score = score
 			latestItems = latestItems[1:]
+		} else if dice < exploreColdStartThreshold && len(coldStartItems) > 0 {
+			score -= 1e-5
+			recommendItem = coldStartItems[0]
+			recommendItem.Score = score
+			coldStartItems = coldStartItems[1:]
 		} else if len(exploitRecommend) > 0 {
 			recommendItem = exploitRecommend[0]
 			exploitRecommend = exploitRecommend[1:]
@@ -1046,7 +1439,7 @@ func (w *Worker) exploreRecommend(exploitRecommend []cache.Scored, excludeSet *s
 // checkRecommendCacheTimeout checks if recommend cache stale.
 // 1. if cache is empty, stale.
 // 2. if active time > recommend time, stale.
-// 3. if recommend time + timeout < now, stale.
+// 3. if recommend time + timeout < now, stale. The timeout depends on the refresh tier of active time.
 func (w *Worker) checkRecommendCacheTimeout(ctx context.Context, userId string, categories []string) bool {
 	var (
 		activeTime    time.Time
@@ -1072,7 +1465,7 @@ func (w *Worker) checkRecommendCacheTimeout(ctx context.Context, userId string,
 		}
 		return true
 	}
-	if cacheDigest != w.Config.OfflineRecommendDigest() {
+	if cacheDigest != w.Config().OfflineRecommendDigest() {
 		return true
 	}
 	// read active time
@@ -1092,21 +1485,47 @@ func (w *Worker) checkRecommendCacheTimeout(ctx context.Context, userId string,
 		return true
 	}
 	// check cache expire
-	if recommendTime.Before(time.Now().Add(-w.Config.Recommend.CacheExpire)) {
+	if recommendTime.Before(time.Now().Add(-w.Config().Recommend.CacheExpire)) {
 		return true
 	}
 	// check time
 	if activeTime.Before(recommendTime) {
-		timeoutTime := recommendTime.Add(w.Config.Recommend.Offline.RefreshRecommendPeriod)
+		timeoutTime := recommendTime.Add(w.Config().Recommend.Offline.GetRefreshRecommendPeriod(time.Since(activeTime)))
 		return timeoutTime.Before(time.Now())
 	}
 	return true
 }
 
+// prioritizeUsers sorts users by last active time in descending order, so that recently active users are refreshed
+// before dormant users. Users never active are placed last.
+func (w *Worker) prioritizeUsers(ctx context.Context, users []data.User) []data.User {
+	activeTimes := make([]time.Time, len(users))
+	_ = parallel.Parallel(len(users), w.jobs, func(_, jobId int) error {
+		activeTime, err := w.CacheClient.Get(ctx, cache.Key(cache.LastModifyUserTime, users[jobId].UserId)).Time()
+		if err != nil && !errors.Is(err, errors.NotFound) {
+			log.Logger().Error("failed to read last modify user time", zap.String("user_id", users[jobId].UserId), zap.Error(err))
+		}
+		activeTimes[jobId] = activeTime
+		return nil
+	})
+	indices := make([]int, len(users))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(i, j int) bool {
+		return activeTimes[indices[i]].After(activeTimes[indices[j]])
+	})
+	prioritized := make([]data.User, len(users))
+	for i, index := range indices {
+		prioritized[i] = users[index]
+	}
+	return prioritized
+}
+
 func (w *Worker) loadUserHistoricalItems(database data.Database, userId string) ([]string, []data.Feedback, error) {
 	items := make([]string, 0)
 	ctx := context.Background()
-	feedbacks, err := database.GetUserFeedback(ctx, userId, w.Config.Now())
+	feedbacks, err := database.GetUserFeedback(ctx, userId, w.Config().Now())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1116,9 +1535,35 @@ func (w *Worker) loadUserHistoricalItems(database data.Database, userId string)
 	return items, feedbacks, nil
 }
 
-func (w *Worker) refreshCache(ctx context.Context, userId string, recommendTime time.Time) error {
+// suppressedItems collects items in negative feedback whose suppression windows are open, and returns the ends
+// of the windows.
+func (w *Worker) suppressedItems(feedbacks []data.Feedback) map[string]float64 {
+	now := float64(time.Now().Unix())
+	suppressed := make(map[string]float64)
+	for _, feedback := range feedbacks {
+		if until, isNegative := w.Config().Recommend.DataSource.SuppressUntil(feedback.FeedbackType, feedback.Timestamp); isNegative && until > now {
+			if until > suppressed[feedback.ItemId] {
+				suppressed[feedback.ItemId] = until
+			}
+		}
+	}
+	return suppressed
+}
+
+func (w *Worker) refreshCache(ctx context.Context, userId string, recommendTime time.Time, suppressedItems map[string]float64) error {
+	// refresh suppressed items
+	if len(w.Config().Recommend.DataSource.NegativeFeedbackTTL) > 0 {
+		var items []cache.Scored
+		for itemId, until := range suppressedItems {
+			items = append(items, cache.Scored{Id: itemId, Score: until})
+		}
+		if err := w.CacheClient.SetSorted(ctx, cache.Key(cache.SuppressedItems, userId), items); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	// reload cache
-	if w.Config.Recommend.Replacement.EnableReplacement {
+	if w.Config().Recommend.Replacement.EnableReplacement {
 		err := w.CacheClient.SetSorted(ctx, cache.IgnoreItems, nil)
 		if err != nil {
 			return errors.Trace(err)
@@ -1169,7 +1614,7 @@ func (w *Worker) pullUsers(peers []string, me string) ([]data.User, error) {
 	if !funk.ContainsString(peers, me) {
 		return nil, errors.New("current node isn't in worker nodes")
 	}
-	// create consistent hash ring
+	// create consistent hash ring if user shards aren't assigned by master
 	c := consistent.New()
 	for _, peer := range peers {
 		c.Add(peer)
@@ -1179,6 +1624,12 @@ func (w *Worker) pullUsers(peers []string, me string) ([]data.User, error) {
 	userChan, errChan := w.DataClient.GetUserStream(ctx, batchSize)
 	for batchUsers := range userChan {
 		for _, user := range batchUsers {
+			if w.numUserShards > 0 {
+				if w.userShards.Has(UserShard(user.UserId, w.numUserShards)) {
+					users = append(users, user)
+				}
+				continue
+			}
 			p, err := c.Get(user.UserId)
 			if err != nil {
 				return nil, errors.Trace(err)
@@ -1194,8 +1645,14 @@ func (w *Worker) pullUsers(peers []string, me string) ([]data.User, error) {
 	return users, nil
 }
 
+// UserShard returns the shard of a user.
+func UserShard(userId string, numShards int32) int32 {
+	return int32(crc32.ChecksumIEEE([]byte(userId)) % uint32(numShards))
+}
+
 // replacement inserts historical items back to recommendation.
-func (w *Worker) replacement(recommend map[string][]cache.Scored, user *data.User, feedbacks []data.Feedback, itemCache *ItemCache) (map[string][]cache.Scored, error) {
+func (w *Worker) replacement(recommend map[string][]cache.Scored, user *data.User, feedbacks []data.Feedback,
+	suppressedItems map[string]float64, itemCache *ItemCache) (map[string][]cache.Scored, error) {
 	upperBounds := make(map[string]float64)
 	lowerBounds := make(map[string]float64)
 	newRecommend := make(map[string][]cache.Scored)
@@ -1217,23 +1674,28 @@ func (w *Worker) replacement(recommend map[string][]cache.Scored, user *data.Use
 	positiveItems := strset.New()
 	distinctItems := strset.New()
 	for _, feedback := range feedbacks {
-		if funk.ContainsString(w.Config.Recommend.DataSource.PositiveFeedbackTypes, feedback.FeedbackType) {
+		if funk.ContainsString(w.Config().Recommend.DataSource.PositiveFeedbackTypes, feedback.FeedbackType) {
 			positiveItems.Add(feedback.ItemId)
 			distinctItems.Add(feedback.ItemId)
-		} else if funk.ContainsString(w.Config.Recommend.DataSource.ReadFeedbackTypes, feedback.FeedbackType) {
+		} else if funk.ContainsString(w.Config().Recommend.DataSource.ReadFeedbackTypes, feedback.FeedbackType) {
 			distinctItems.Add(feedback.ItemId)
 		}
 	}
 
 	for _, itemId := range distinctItems.List() {
+		if _, suppressed := suppressedItems[itemId]; suppressed {
+			// items suppressed by negative feedback are not replaced
+			continue
+		}
 		if item, exist := itemCache.Get(itemId); exist {
 			// scoring item
 			// 1. If click-through rate prediction model is available, use it.
 			// 2. If collaborative filtering model is available, use it.
 			// 3. Otherwise, give a random score.
 			var score float64
-			if w.Config.Recommend.Offline.EnableClickThroughPrediction && w.ClickModel != nil {
-				score = float64(w.ClickModel.Predict(user.UserId, itemId, user.Labels, item.Labels))
+			if w.Config().Recommend.Offline.EnableClickThroughPrediction && w.ClickModel != nil && !w.ClickModel.Invalid() {
+				featuredUser, featuredItems := w.appendStoreFeatures(context.Background(), user, []*data.Item{item})
+				score = w.predictClickThroughRate(featuredUser, featuredItems[0])
 			} else if w.RankingModel != nil && !w.RankingModel.Invalid() && w.RankingModel.IsUserPredictable(w.RankingModel.GetUserIndex().ToNumber(user.UserId)) {
 				score = float64(w.RankingModel.Predict(user.UserId, itemId))
 			} else {
@@ -1255,9 +1717,9 @@ func (w *Worker) replacement(recommend map[string][]cache.Scored, user *data.Use
 					if score < 0 {
 						continue
 					} else if positiveItems.Has(itemId) {
-						score *= w.Config.Recommend.Replacement.PositiveReplacementDecay
+						score *= w.Config().Recommend.Replacement.PositiveReplacementDecay
 					} else {
-						score *= w.Config.Recommend.Replacement.ReadReplacementDecay
+						score *= w.Config().Recommend.Replacement.ReadReplacementDecay
 					}
 					score += lowerBound
 				}
@@ -1276,26 +1738,79 @@ func (w *Worker) replacement(recommend map[string][]cache.Scored, user *data.Use
 }
 
 type HealthStatus struct {
+	Ready               bool
 	DataStoreError      error
 	CacheStoreError     error
 	DataStoreConnected  bool
 	CacheStoreConnected bool
+	Started             bool
+	Dependencies        map[string]server.DependencyStatus `json:",omitempty"` // latencies of stores and the master
+	LastSync            *time.Time                         `json:",omitempty"` // last successful sync with the master
+	ModelStaleness      map[string]time.Duration           `json:",omitempty"` // durations since newer models were found
 }
 
-func (w *Worker) checkHealth() HealthStatus {
-	healthStatus := HealthStatus{}
-	healthStatus.DataStoreError = w.DataClient.Ping()
-	healthStatus.CacheStoreError = w.CacheClient.Ping()
-	healthStatus.DataStoreConnected = healthStatus.DataStoreError == nil
-	healthStatus.CacheStoreConnected = healthStatus.CacheStoreError == nil
+// checkHealth collects the health status of this worker. Stores are pinged only if ping is true.
+func (w *Worker) checkHealth(ping bool) HealthStatus {
+	healthStatus := HealthStatus{
+		Started:        w.health.Started(),
+		LastSync:       w.health.LastSync(),
+		ModelStaleness: w.health.ModelStaleness(),
+	}
+	if master := w.health.Master(); master != nil {
+		healthStatus.Dependencies = map[string]server.DependencyStatus{"master": *master}
+	}
+	if ping {
+		if healthStatus.Dependencies == nil {
+			healthStatus.Dependencies = make(map[string]server.DependencyStatus)
+		}
+		start := time.Now()
+		healthStatus.DataStoreError = w.DataClient.Ping()
+		healthStatus.Dependencies["data_store"] = server.NewDependencyStatus(start, healthStatus.DataStoreError)
+		start = time.Now()
+		healthStatus.CacheStoreError = w.CacheClient.Ping()
+		healthStatus.Dependencies["cache_store"] = server.NewDependencyStatus(start, healthStatus.CacheStoreError)
+		healthStatus.DataStoreConnected = healthStatus.DataStoreError == nil
+		healthStatus.CacheStoreConnected = healthStatus.CacheStoreError == nil
+		healthStatus.Ready = healthStatus.Started && healthStatus.DataStoreConnected && healthStatus.CacheStoreConnected
+	}
 	return healthStatus
 }
 
+// checkStartup returns OK once the worker has connected to stores and synced with the master.
+func (w *Worker) checkStartup(writer http.ResponseWriter, _ *http.Request) {
+	healthStatus := w.checkHealth(false)
+	if healthStatus.Started {
+		writeJSON(writer, healthStatus)
+	} else {
+		writeUnavailable(writer, healthStatus)
+	}
+}
+
+// checkLive returns OK once the worker starts. Dependencies are not checked.
 func (w *Worker) checkLive(writer http.ResponseWriter, _ *http.Request) {
-	healthStatus := w.checkHealth()
+	healthStatus := w.checkHealth(false)
 	writeJSON(writer, healthStatus)
 }
 
+// checkReady returns OK if the worker has started and stores are connected.
+func (w *Worker) checkReady(writer http.ResponseWriter, _ *http.Request) {
+	healthStatus := w.checkHealth(true)
+	if healthStatus.Ready {
+		writeJSON(writer, healthStatus)
+	} else {
+		writeUnavailable(writer, healthStatus)
+	}
+}
+
+func writeUnavailable(writer http.ResponseWriter, healthStatus HealthStatus) {
+	bytes, err := json.Marshal(healthStatus)
+	if err != nil {
+		writeError(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeError(writer, string(bytes), http.StatusServiceUnavailable)
+}
+
 // ItemCache is alias of map[string]data.Item.
 type ItemCache struct {
 	Data      map[string]*data.Item
@@ -1360,7 +1875,7 @@ type FeedbackCache struct {
 // NewFeedbackCache creates a new FeedbackCache.
 func NewFeedbackCache(worker *Worker, feedbackTypes ...string) *FeedbackCache {
 	return &FeedbackCache{
-		Config: worker.Config,
+		Config: worker.Config(),
 		Client: worker.DataClient,
 		Types:  feedbackTypes,
 		Cache:  cmap.New(),
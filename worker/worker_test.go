@@ -19,16 +19,22 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 
 	"github.com/alicebob/miniredis/v2"
 	"github.com/bits-and-blooms/bitset"
+	"github.com/juju/errors"
+	"github.com/samber/lo"
+	"github.com/scylladb/go-set/i32set"
 	"github.com/scylladb/go-set/strset"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -40,6 +46,8 @@ import (
 	"github.com/zhenghaoz/gorse/model/click"
 	"github.com/zhenghaoz/gorse/model/ranking"
 	"github.com/zhenghaoz/gorse/protocol"
+	"github.com/zhenghaoz/gorse/recommender"
+	"github.com/zhenghaoz/gorse/server"
 	"github.com/zhenghaoz/gorse/storage/cache"
 	"github.com/zhenghaoz/gorse/storage/data"
 	"google.golang.org/grpc"
@@ -83,8 +91,9 @@ func (suite *WorkerTestSuite) SetupTest() {
 	err = suite.CacheClient.Purge()
 	suite.NoError(err)
 	// configuration
-	suite.Config = config.GetDefaultConfig()
+	suite.SetConfig(config.GetDefaultConfig())
 	suite.jobs = 1
+	suite.itemFrequency = nil
 	// reset random generator
 	suite.randGenerator = rand.New(rand.NewSource(0))
 }
@@ -112,6 +121,17 @@ func (suite *WorkerTestSuite) TestPullUsers() {
 
 	_, err = suite.pullUsers(nodes, "d")
 	suite.Error(err)
+
+	// pull users in shards assigned by master
+	suite.numUserShards = 4
+	suite.userShards = i32set.New(0, 1)
+	defer func() {
+		suite.numUserShards = 0
+		suite.userShards = nil
+	}()
+	users, err = suite.pullUsers(nodes, "b")
+	suite.NoError(err)
+	suite.Equal([]data.User{{UserId: "2"}, {UserId: "4"}, {UserId: "6"}}, users)
 }
 
 func (suite *WorkerTestSuite) TestCheckRecommendCacheTimeout() {
@@ -124,7 +144,7 @@ func (suite *WorkerTestSuite) TestCheckRecommendCacheTimeout() {
 
 	// digest mismatch
 	suite.True(suite.checkRecommendCacheTimeout(ctx, "0", nil))
-	err = suite.CacheClient.Set(ctx, cache.String(cache.Key(cache.OfflineRecommendDigest, "0"), suite.Config.OfflineRecommendDigest()))
+	err = suite.CacheClient.Set(ctx, cache.String(cache.Key(cache.OfflineRecommendDigest, "0"), suite.Config().OfflineRecommendDigest()))
 	suite.NoError(err)
 
 	err = suite.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "0"), time.Now().Add(-time.Hour)))
@@ -136,11 +156,33 @@ func (suite *WorkerTestSuite) TestCheckRecommendCacheTimeout() {
 	err = suite.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "0"), time.Now().Add(time.Hour*100)))
 	suite.NoError(err)
 	suite.False(suite.checkRecommendCacheTimeout(ctx, "0", nil))
+
+	// refresh recently active users by tiers
+	err = suite.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, "0"), time.Now().Add(-time.Hour*3)))
+	suite.NoError(err)
+	err = suite.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastUpdateUserRecommendTime, "0"), time.Now().Add(-time.Hour*2)))
+	suite.NoError(err)
+	suite.False(suite.checkRecommendCacheTimeout(ctx, "0", nil))
+	suite.Config().Recommend.Offline.RefreshTiers = []config.RefreshTier{{ActiveWithin: 24 * time.Hour, RefreshPeriod: time.Hour}}
+	suite.True(suite.checkRecommendCacheTimeout(ctx, "0", nil))
+	suite.Config().Recommend.Offline.RefreshTiers = []config.RefreshTier{{ActiveWithin: time.Hour, RefreshPeriod: time.Hour}}
+	suite.False(suite.checkRecommendCacheTimeout(ctx, "0", nil))
+
 	err = suite.CacheClient.SetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), nil)
 	suite.NoError(err)
 	suite.True(suite.checkRecommendCacheTimeout(ctx, "0", nil))
 }
 
+func (suite *WorkerTestSuite) TestPrioritizeUsers() {
+	ctx := context.Background()
+	for userId, inactive := range map[string]time.Duration{"1": 48 * time.Hour, "3": time.Minute, "4": time.Hour} {
+		err := suite.CacheClient.Set(ctx, cache.Time(cache.Key(cache.LastModifyUserTime, userId), time.Now().Add(-inactive)))
+		suite.NoError(err)
+	}
+	users := suite.prioritizeUsers(ctx, []data.User{{UserId: "1"}, {UserId: "2"}, {UserId: "3"}, {UserId: "4"}, {UserId: "5"}})
+	suite.Equal([]data.User{{UserId: "3"}, {UserId: "4"}, {UserId: "1"}, {UserId: "2"}, {UserId: "5"}}, users)
+}
+
 type mockMatrixFactorizationForRecommend struct {
 	ranking.BaseMatrixFactorization
 }
@@ -202,8 +244,8 @@ func (m *mockMatrixFactorizationForRecommend) GetParamsGrid(_ bool) model.Params
 
 func (suite *WorkerTestSuite) TestRecommendMatrixFactorizationBruteForce() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = true
-	suite.Config.Recommend.Collaborative.EnableIndex = false
+	suite.Config().Recommend.Offline.EnableColRecommend = true
+	suite.Config().Recommend.Collaborative.EnableIndex = false
 	// insert feedbacks
 	now := time.Now()
 	err := suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
@@ -259,8 +301,8 @@ func (suite *WorkerTestSuite) TestRecommendMatrixFactorizationBruteForce() {
 
 func (suite *WorkerTestSuite) TestRecommendMatrixFactorizationHNSW() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = true
-	suite.Config.Recommend.Collaborative.EnableIndex = true
+	suite.Config().Recommend.Offline.EnableColRecommend = true
+	suite.Config().Recommend.Collaborative.EnableIndex = true
 	// insert feedbacks
 	now := time.Now()
 	err := suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
@@ -310,8 +352,8 @@ func (suite *WorkerTestSuite) TestRecommendMatrixFactorizationHNSW() {
 
 func (suite *WorkerTestSuite) TestRecommendItemBased() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = false
-	suite.Config.Recommend.Offline.EnableItemBasedRecommend = true
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnableItemBasedRecommend = true
 	// insert feedback
 	err := suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
 		{FeedbackKey: data.FeedbackKey{FeedbackType: "a", UserId: "0", ItemId: "21"}},
@@ -395,8 +437,8 @@ func (suite *WorkerTestSuite) TestRecommendItemBased() {
 
 func (suite *WorkerTestSuite) TestRecommendUserBased() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = false
-	suite.Config.Recommend.Offline.EnableUserBasedRecommend = true
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnableUserBasedRecommend = true
 	// insert similar users
 	err := suite.CacheClient.SetSorted(ctx, cache.Key(cache.UserNeighbors, "0"), []cache.Scored{
 		{"1", 2},
@@ -443,8 +485,8 @@ func (suite *WorkerTestSuite) TestRecommendUserBased() {
 
 func (suite *WorkerTestSuite) TestRecommendPopular() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = false
-	suite.Config.Recommend.Offline.EnablePopularRecommend = true
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnablePopularRecommend = true
 	// insert popular items
 	err := suite.CacheClient.SetSorted(ctx, cache.PopularItems, []cache.Scored{{"11", 11}, {"10", 10}, {"9", 9}, {"8", 8}})
 	suite.NoError(err)
@@ -472,11 +514,98 @@ func (suite *WorkerTestSuite) TestRecommendPopular() {
 	suite.Equal([]cache.Scored{{"20", 20}, {"19", 19}, {"18", 18}}, recommends)
 }
 
+func init() {
+	recommender.Register("test_custom", recommender.Func(func(ctx context.Context, request *recommender.Request) ([]cache.Scored, error) {
+		if request.Settings == nil || request.N <= 0 {
+			return nil, errors.New("invalid request")
+		}
+		if request.Category == "*" {
+			return []cache.Scored{{"20", 20}, {"19", 19}}, nil
+		}
+		return []cache.Scored{{"11", 11}, {"10", 10}, {"9", 9}, {"8", 8}}, nil
+	}))
+}
+
+func (suite *WorkerTestSuite) TestRecommendCustom() {
+	ctx := context.Background()
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.CustomRecommenders = []string{"test_custom", "unknown"}
+	suite.RankingModel = nil
+	err := suite.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "11", IsHidden: true}, {ItemId: "10"}, {ItemId: "9"}, {ItemId: "8"},
+		{ItemId: "20", Categories: []string{"*"}},
+		{ItemId: "19", Categories: []string{"*"}},
+	})
+	suite.NoError(err)
+	err = suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "click", UserId: "0", ItemId: "9"}, Timestamp: time.Now()},
+	}, true, true, true)
+	suite.NoError(err)
+	suite.Recommend([]data.User{{UserId: "0"}})
+	// read and hidden items are filtered out
+	recommends, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), 0, -1)
+	suite.NoError(err)
+	suite.Equal([]string{"10", "8"}, cache.RemoveScores(recommends))
+	recommends, err = suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "0", "*"), 0, -1)
+	suite.NoError(err)
+	suite.Equal([]string{"20", "19"}, cache.RemoveScores(recommends))
+}
+
+func (suite *WorkerTestSuite) TestRecommendCheckpoint() {
+	ctx := context.Background()
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnablePopularRecommend = true
+	suite.RankingModel = nil
+	err := suite.CacheClient.SetSorted(ctx, cache.PopularItems, []cache.Scored{{"10", 10}, {"9", 9}, {"8", 8}})
+	suite.NoError(err)
+	err = suite.DataClient.BatchInsertItems(ctx, []data.Item{{ItemId: "10"}, {ItemId: "9"}, {ItemId: "8"}})
+	suite.NoError(err)
+	// the shard of user 0 was completed before restart
+	shard := UserShard("0", numCheckpointShards)
+	suite.NotEqual(shard, UserShard("1", numCheckpointShards))
+	err = suite.saveCheckpoint(ctx, &Checkpoint{
+		StartTime:       time.Now(),
+		NumShards:       numCheckpointShards,
+		CompletedShards: []int32{shard},
+	})
+	suite.NoError(err)
+	users, tracker := suite.resumeCheckpoint(ctx, []data.User{{UserId: "0"}, {UserId: "1"}})
+	suite.Equal([]data.User{{UserId: "1"}}, users)
+	tracker.Done(ctx, "1")
+	checkpoint, err := suite.loadCheckpoint(ctx)
+	suite.NoError(err)
+	suite.ElementsMatch([]int32{shard, UserShard("1", numCheckpointShards)}, checkpoint.CompletedShards)
+
+	// skip users in completed shards
+	checkpoint.CompletedShards = []int32{shard}
+	suite.NoError(suite.saveCheckpoint(ctx, checkpoint))
+	suite.Recommend([]data.User{{UserId: "0"}, {UserId: "1"}})
+	recommends, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), 0, -1)
+	suite.NoError(err)
+	suite.Empty(recommends)
+	recommends, err = suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "1"), 0, -1)
+	suite.NoError(err)
+	suite.Equal([]string{"10", "9", "8"}, cache.RemoveScores(recommends))
+	// checkpoint is cleared once completed
+	_, err = suite.loadCheckpoint(ctx)
+	suite.True(errors.Is(err, errors.NotFound))
+
+	// discard stale checkpoint
+	err = suite.saveCheckpoint(ctx, &Checkpoint{
+		StartTime:       time.Now().Add(-suite.Config().Recommend.Offline.RefreshRecommendPeriod),
+		NumShards:       numCheckpointShards,
+		CompletedShards: []int32{shard},
+	})
+	suite.NoError(err)
+	users, _ = suite.resumeCheckpoint(ctx, []data.User{{UserId: "0"}, {UserId: "1"}})
+	suite.Equal([]data.User{{UserId: "0"}, {UserId: "1"}}, users)
+}
+
 func (suite *WorkerTestSuite) TestRecommendLatest() {
 	// create mock worker
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = false
-	suite.Config.Recommend.Offline.EnableLatestRecommend = true
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnableLatestRecommend = true
 	// insert latest items
 	err := suite.CacheClient.SetSorted(ctx, cache.LatestItems, []cache.Scored{{"11", 11}, {"10", 10}, {"9", 9}, {"8", 8}})
 	suite.NoError(err)
@@ -506,8 +635,8 @@ func (suite *WorkerTestSuite) TestRecommendLatest() {
 
 func (suite *WorkerTestSuite) TestRecommendColdStart() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.EnableColRecommend = true
-	suite.Config.Recommend.Offline.EnableLatestRecommend = true
+	suite.Config().Recommend.Offline.EnableColRecommend = true
+	suite.Config().Recommend.Offline.EnableLatestRecommend = true
 	// insert latest items
 	err := suite.CacheClient.SetSorted(ctx, cache.LatestItems, []cache.Scored{{"11", 11}, {"10", 10}, {"9", 9}, {"8", 8}})
 	suite.NoError(err)
@@ -548,34 +677,69 @@ func (suite *WorkerTestSuite) TestRecommendColdStart() {
 }
 
 func (suite *WorkerTestSuite) TestMergeAndShuffle() {
-	scores := suite.mergeAndShuffle([][]string{{"1", "2", "3"}, {"1", "3", "5"}})
+	scores := suite.mergeAndShuffle([][]string{{"1", "2", "3"}, {"1", "3", "5"}}, suite.randGenerator)
 	suite.ElementsMatch([]string{"1", "2", "3", "5"}, cache.RemoveScores(scores))
 }
 
+func (suite *WorkerTestSuite) TestUserRand() {
+	candidates := make([][]string, 10)
+	for i := range candidates {
+		for j := 0; j < 10; j++ {
+			candidates[i] = append(candidates[i], strconv.Itoa(i*10+j))
+		}
+	}
+	shuffle := func(userId, category string, now time.Time) []string {
+		return cache.RemoveScores(suite.mergeAndShuffle(candidates, suite.userRand(userId, category, now)))
+	}
+	// stable within a period
+	day := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	suite.Equal(shuffle("1", "", day), shuffle("1", "", day.Add(23*time.Hour)))
+	// rotates in the next period
+	suite.NotEqual(shuffle("1", "", day), shuffle("1", "", day.Add(24*time.Hour)))
+	// different among users and categories
+	suite.NotEqual(shuffle("1", "", day), shuffle("2", "", day))
+	suite.NotEqual(shuffle("1", "", day), shuffle("1", "*", day))
+	// shared generator if the shuffle period is 0
+	suite.Config().Recommend.Offline.ShufflePeriod = 0
+	suite.Equal(suite.randGenerator, suite.userRand("1", "", day))
+}
+
 func (suite *WorkerTestSuite) TestExploreRecommend() {
 	ctx := context.Background()
-	suite.Config.Recommend.Offline.ExploreRecommend = map[string]float64{"popular": 0.3, "latest": 0.3}
+	suite.Config().Recommend.Offline.ExploreRecommend = map[string]float64{"popular": 0.3, "latest": 0.3, "cold_start": 0.3}
 	// insert popular items
 	err := suite.CacheClient.SetSorted(ctx, cache.PopularItems, []cache.Scored{{"popular", 0}})
 	suite.NoError(err)
 	// insert latest items
 	err = suite.CacheClient.SetSorted(ctx, cache.LatestItems, []cache.Scored{{"latest", 0}})
 	suite.NoError(err)
+	// insert cold-start items
+	err = suite.CacheClient.SetSorted(ctx, cache.ColdStartItems, []cache.Scored{{"cold_start", 0}})
+	suite.NoError(err)
 
 	recommend, err := suite.exploreRecommend(cache.CreateScoredItems(
 		funk.ReverseStrings([]string{"1", "2", "3", "4", "5", "6", "7", "8"}),
-		funk.ReverseFloat64([]float64{1, 2, 3, 4, 5, 6, 7, 8})), strset.New(), "")
+		funk.ReverseFloat64([]float64{1, 2, 3, 4, 5, 6, 7, 8})), strset.New(), "", nil, suite.randGenerator)
 	suite.NoError(err)
 	items := cache.RemoveScores(recommend)
 	suite.Contains(items, "latest")
 	suite.Contains(items, "popular")
+	suite.Contains(items, "cold_start")
 	items = funk.FilterString(items, func(item string) bool {
-		return item != "latest" && item != "popular"
+		return item != "latest" && item != "popular" && item != "cold_start"
 	})
 	suite.IsDecreasing(items)
 	scores := cache.GetScores(recommend)
 	suite.IsDecreasing(scores)
 	suite.Equal(8, len(recommend))
+
+	// exploration weights are overridden by the segment
+	segment := &server.Segment{Name: "vip", Labels: []string{"vip"}, ExploreRecommend: map[string]float64{"popular": 0, "latest": 0, "cold_start": 0}}
+	recommend, err = suite.exploreRecommend(cache.CreateScoredItems(
+		funk.ReverseStrings([]string{"1", "2", "3", "4", "5", "6", "7", "8"}),
+		funk.ReverseFloat64([]float64{1, 2, 3, 4, 5, 6, 7, 8})), strset.New(), "", segment, suite.randGenerator)
+	suite.NoError(err)
+	suite.Equal([]string{"8", "7", "6", "5", "4", "3", "2", "1"}, cache.RemoveScores(recommend))
 }
 
 func marshal(t *testing.T, v interface{}) string {
@@ -707,7 +871,7 @@ func TestWorker_Sync(t *testing.T) {
 			case <-done:
 				return
 			default:
-				p, _ := serv.Config.Recommend.Offline.GetExploreRecommend("popular")
+				p, _ := serv.Config().Recommend.Offline.GetExploreRecommend("popular")
 				assert.Zero(t, p)
 			}
 		}
@@ -720,13 +884,83 @@ func TestWorker_Sync(t *testing.T) {
 	assert.Equal(t, int64(2), serv.latestRankingModelVersion)
 	assert.Zero(t, serv.ClickModelVersion)
 	assert.Zero(t, serv.RankingModelVersion)
-	serv.Pull()
+
+	// models are swapped after the ongoing recommendation batch
+	serv.modelMutex.RLock()
+	pulled := make(chan struct{})
+	go func() {
+		serv.Pull()
+		close(pulled)
+	}()
+	select {
+	case <-pulled:
+		assert.Fail(t, "models were swapped during recommendation")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Zero(t, serv.RankingModelVersion)
+	serv.modelMutex.RUnlock()
+	<-pulled
 	assert.Equal(t, int64(1), serv.ClickModelVersion)
 	assert.Equal(t, int64(2), serv.RankingModelVersion)
 	master.Stop()
 	done <- struct{}{}
 }
 
+type mockFragmentStream struct {
+	fragments []*protocol.Fragment
+	err       error
+}
+
+func (s *mockFragmentStream) Recv() (*protocol.Fragment, error) {
+	if len(s.fragments) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	fragment := s.fragments[0]
+	s.fragments = s.fragments[1:]
+	return fragment, nil
+}
+
+func TestWorker_PullModel(t *testing.T) {
+	w := &Worker{cacheFile: filepath.Join(t.TempDir(), "worker_cache.data")}
+	data := []byte("hello gorse")
+	path := w.cacheFile + ".ranking_model_1.part"
+	stalePath := w.cacheFile + ".ranking_model_2.part"
+	assert.NoError(t, os.WriteFile(stalePath, data, 0644))
+
+	// keep partial file on failure
+	var request *protocol.VersionInfo
+	err := w.pullModel("ranking_model", 1, func(version *protocol.VersionInfo) (protocol.FragmentReceiver, error) {
+		request = version
+		return &mockFragmentStream{fragments: []*protocol.Fragment{{Data: data[:5]}}, err: io.ErrUnexpectedEOF}, nil
+	}, func(reader io.Reader) error {
+		return nil
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, int64(0), request.Offset)
+	assert.True(t, request.Compress)
+	assert.NoFileExists(t, stalePath)
+	partial, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data[:5], partial)
+
+	// resume from partial file
+	var loaded []byte
+	err = w.pullModel("ranking_model", 1, func(version *protocol.VersionInfo) (protocol.FragmentReceiver, error) {
+		request = version
+		return &mockFragmentStream{fragments: []*protocol.Fragment{{Data: data[version.Offset:], Offset: version.Offset}}}, nil
+	}, func(reader io.Reader) (err error) {
+		loaded, err = io.ReadAll(reader)
+		return
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), request.Offset)
+	assert.Equal(t, data, loaded)
+	assert.NoFileExists(t, path)
+}
+
 func TestWorker_SyncRecommend(t *testing.T) {
 	cfg := config.GetDefaultConfig()
 	cfg.Recommend.Offline.ExploreRecommend = map[string]float64{"popular": 0.5}
@@ -765,7 +999,7 @@ func TestWorker_SyncRecommend(t *testing.T) {
 			case <-stopRecommend:
 				return
 			default:
-				worker.Settings.Config.OfflineRecommendDigest()
+				worker.Settings.Config().OfflineRecommendDigest()
 			}
 		}
 	}()
@@ -808,6 +1042,10 @@ func (m mockFactorizationMachine) Predict(_, itemId string, _, _ []string) float
 	return float32(score)
 }
 
+func (m mockFactorizationMachine) PredictWithContext(userId, itemId string, userLabels, itemLabels, _ []string) float32 {
+	return m.Predict(userId, itemId, userLabels, itemLabels)
+}
+
 func (m mockFactorizationMachine) InternalPredict(_ []int32, _ []float32) float32 {
 	panic("implement me")
 }
@@ -838,6 +1076,23 @@ func (suite *WorkerTestSuite) TestRankByCollaborativeFiltering() {
 	suite.IsDecreasing(cache.GetScores(result))
 }
 
+func (suite *WorkerTestSuite) TestRankByCollaborativeFilteringWithPopularityPenalty() {
+	ctx := context.Background()
+	suite.Config().Recommend.Offline.PopularityPenalty = 1
+	err := suite.CacheClient.SetSorted(ctx, cache.ItemFrequency, []cache.Scored{{"5", 9}, {"4", 0}})
+	suite.NoError(err)
+	suite.itemFrequency, err = suite.pullItemFrequency(ctx)
+	suite.NoError(err)
+	suite.Equal(map[string]float64{"5": 9, "4": 0}, suite.itemFrequency)
+	// rank items
+	suite.RankingModel = newMockMatrixFactorizationForRecommend(10, 10)
+	result, err := suite.rankByCollaborativeFiltering("1", [][]string{{"1", "2", "3", "4", "5"}})
+	suite.NoError(err)
+	suite.Equal([]cache.Scored{{"4", 4}, {"3", 3}, {"2", 2}, {"1", 1}, {"5", 0.5}}, result)
+	// negative scores are multiplied by penalties
+	suite.Equal(-20.0, suite.penalizePopularity("5", -2))
+}
+
 func (suite *WorkerTestSuite) TestRankByClickTroughRate() {
 	ctx := context.Background()
 	// insert a user
@@ -856,14 +1111,124 @@ func (suite *WorkerTestSuite) TestRankByClickTroughRate() {
 	suite.IsDecreasing(cache.GetScores(result))
 }
 
+// mockFeatureStore returns features of users and items in maps.
+type mockFeatureStore struct {
+	users map[string][]string
+	items map[string][]string
+}
+
+func (m *mockFeatureStore) GetUserFeatures(_ context.Context, userIds []string) (map[string][]string, error) {
+	return lo.PickByKeys(m.users, userIds), nil
+}
+
+func (m *mockFeatureStore) GetItemFeatures(_ context.Context, itemIds []string) (map[string][]string, error) {
+	return lo.PickByKeys(m.items, itemIds), nil
+}
+
+func (m *mockFeatureStore) Close() error {
+	return nil
+}
+
+// mockLabeledModel scores items by numbers of labels of users and items, and then identifiers of items.
+type mockLabeledModel struct {
+	mockFactorizationMachine
+}
+
+func (m mockLabeledModel) Predict(userId, itemId string, userLabels, itemLabels []string) float32 {
+	return 100*float32(len(userLabels)) + 10*float32(len(itemLabels)) + m.mockFactorizationMachine.Predict(userId, itemId, userLabels, itemLabels)
+}
+
+func (suite *WorkerTestSuite) TestRankByClickTroughRateWithFeatureStore() {
+	// insert items
+	itemCache := NewItemCache()
+	for i := 1; i <= 5; i++ {
+		itemCache.Set(strconv.Itoa(i), data.Item{ItemId: strconv.Itoa(i)})
+	}
+	// rank items with features
+	suite.ClickModel = new(mockLabeledModel)
+	suite.FeatureClient = &mockFeatureStore{
+		users: map[string][]string{"1": {"segment:vip"}},
+		items: map[string][]string{"1": {"brand:gorse"}},
+	}
+	defer func() {
+		suite.FeatureClient = nil
+	}()
+	result, err := suite.rankByClickTroughRate(&data.User{UserId: "1"}, [][]string{{"1", "2", "3", "4", "5"}}, itemCache)
+	suite.NoError(err)
+	suite.Equal([]cache.Scored{{"1", 111}, {"5", 105}, {"4", 104}, {"3", 103}, {"2", 102}}, result)
+	// cached items are not modified
+	item, _ := itemCache.Get("1")
+	suite.Empty(item.Labels)
+}
+
+type mockObjectiveModel struct {
+	mockFactorizationMachine
+}
+
+func (m mockObjectiveModel) Predict(_, itemId string, _, _ []string) float32 {
+	if itemId == "1" {
+		return 10
+	}
+	return -10
+}
+
+func (suite *WorkerTestSuite) TestRankByClickTroughRateWithObjectives() {
+	// insert items
+	itemCache := NewItemCache()
+	for i := 1; i <= 5; i++ {
+		itemCache.Set(strconv.Itoa(i), data.Item{ItemId: strconv.Itoa(i)})
+	}
+	// rank items by clicks and purchases
+	suite.ClickModel = new(mockFactorizationMachine)
+	suite.ObjectiveModels = map[string]click.FactorizationMachine{"purchase": new(mockObjectiveModel)}
+	suite.Config().Recommend.Offline.Objectives = []config.ObjectiveConfig{
+		{Name: "purchase", FeedbackTypes: []string{"purchase"}, Weight: 1},
+		{Name: "missing", FeedbackTypes: []string{"missing"}, Weight: 1},
+	}
+	result, err := suite.rankByClickTroughRate(&data.User{UserId: "1"}, [][]string{{"1", "2", "3", "4", "5"}}, itemCache)
+	suite.NoError(err)
+	suite.Equal([]string{"1", "5", "4", "3", "2"}, cache.RemoveScores(result))
+	suite.IsDecreasing(cache.GetScores(result))
+	// objectives without weights are ignored
+	suite.Config().Recommend.Offline.Objectives[0].Weight = 0
+	result, err = suite.rankByClickTroughRate(&data.User{UserId: "1"}, [][]string{{"1", "2", "3", "4", "5"}}, itemCache)
+	suite.NoError(err)
+	suite.Equal([]string{"5", "4", "3", "2", "1"}, cache.RemoveScores(result))
+}
+
+func (suite *WorkerTestSuite) TestRankByClickTroughRateWithNormalization() {
+	// insert items
+	itemCache := NewItemCache()
+	for i := 1; i <= 3; i++ {
+		itemCache.Set(strconv.Itoa(i), data.Item{ItemId: strconv.Itoa(i)})
+	}
+	// click-through rates are converted to probabilities
+	suite.ClickModel = new(mockFactorizationMachine)
+	suite.Config().Recommend.Offline.NormalizeScores = true
+	result, err := suite.rankByClickTroughRate(&data.User{UserId: "1"}, [][]string{{"1", "2", "3"}}, itemCache)
+	suite.NoError(err)
+	suite.Equal([]string{"3", "2", "1"}, cache.RemoveScores(result))
+	for i, score := range cache.GetScores(result) {
+		suite.InDelta(1/(1+math.Exp(float64(i-3))), score, 1e-6)
+	}
+}
+
+func TestNormalizeScores(t *testing.T) {
+	assert.Empty(t, normalizeScores(nil))
+	assert.Equal(t, []cache.Scored{{"1", 1}, {"2", 0.5}, {"3", 0}},
+		normalizeScores([]cache.Scored{{"1", 10}, {"2", 6}, {"3", 2}}))
+	assert.Equal(t, []cache.Scored{{"1", 1}, {"2", 1}},
+		normalizeScores([]cache.Scored{{"1", 5}, {"2", 5}}))
+}
+
 func (suite *WorkerTestSuite) TestReplacement_ClickThroughRate() {
 	ctx := context.Background()
-	suite.Config.Recommend.DataSource.PositiveFeedbackTypes = []string{"p"}
-	suite.Config.Recommend.DataSource.ReadFeedbackTypes = []string{"n"}
-	suite.Config.Recommend.Offline.EnableColRecommend = false
-	suite.Config.Recommend.Offline.EnablePopularRecommend = true
-	suite.Config.Recommend.Replacement.EnableReplacement = true
-	suite.Config.Recommend.Offline.EnableClickThroughPrediction = true
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"p"}
+	suite.Config().Recommend.DataSource.ReadFeedbackTypes = []string{"n"}
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnablePopularRecommend = true
+	suite.Config().Recommend.Replacement.EnableReplacement = true
+	suite.Config().Recommend.Offline.EnableClickThroughPrediction = true
 
 	// 1. Insert historical items into empty recommendation.
 	// insert items
@@ -905,11 +1270,11 @@ func (suite *WorkerTestSuite) TestReplacement_ClickThroughRate() {
 
 func (suite *WorkerTestSuite) TestReplacement_CollaborativeFiltering() {
 	ctx := context.Background()
-	suite.Config.Recommend.DataSource.PositiveFeedbackTypes = []string{"p"}
-	suite.Config.Recommend.DataSource.ReadFeedbackTypes = []string{"n"}
-	suite.Config.Recommend.Offline.EnableColRecommend = false
-	suite.Config.Recommend.Offline.EnablePopularRecommend = true
-	suite.Config.Recommend.Replacement.EnableReplacement = true
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"p"}
+	suite.Config().Recommend.DataSource.ReadFeedbackTypes = []string{"n"}
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnablePopularRecommend = true
+	suite.Config().Recommend.Replacement.EnableReplacement = true
 
 	// 1. Insert historical items into empty recommendation.
 	// insert items
@@ -949,31 +1314,120 @@ func (suite *WorkerTestSuite) TestReplacement_CollaborativeFiltering() {
 	suite.Equal([]cache.Scored{{"10", 9}, {"9", 7.4}, {"7", 7}}, recommends)
 }
 
+func (suite *WorkerTestSuite) TestReplacement_NegativeFeedback() {
+	ctx := context.Background()
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"p"}
+	suite.Config().Recommend.DataSource.ReadFeedbackTypes = []string{"n"}
+	suite.Config().Recommend.DataSource.NegativeFeedbackTTL = map[string]time.Duration{"dislike": time.Hour}
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnablePopularRecommend = true
+	suite.Config().Recommend.Replacement.EnableReplacement = true
+
+	// insert items
+	err := suite.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "10"}, {ItemId: "9"}, {ItemId: "8"}, {ItemId: "7"}, {ItemId: "6"}, {ItemId: "5"},
+	})
+	suite.NoError(err)
+	// insert popular items
+	err = suite.CacheClient.SetSorted(ctx, cache.PopularItems, []cache.Scored{{"7", 10}, {"6", 9}, {"5", 8}})
+	suite.NoError(err)
+	// insert feedback
+	err = suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "p", UserId: "0", ItemId: "10"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "n", UserId: "0", ItemId: "9"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "9"}, Timestamp: time.Now().Add(-time.Minute)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "7"}, Timestamp: time.Now().Add(-time.Minute)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "6"}, Timestamp: time.Now().Add(-2 * time.Hour)},
+	}, true, false, true)
+	suite.NoError(err)
+	suite.Recommend([]data.User{{UserId: "0"}})
+	recommends, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), 0, -1)
+	suite.NoError(err)
+	suite.ElementsMatch([]string{"10", "5"}, cache.RemoveScores(recommends))
+	suppressed, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.SuppressedItems, "0"), 0, -1)
+	suite.NoError(err)
+	suite.ElementsMatch([]string{"9", "7"}, cache.RemoveScores(suppressed))
+}
+
+func (suite *WorkerTestSuite) TestRecommend_SuppressedItems() {
+	ctx := context.Background()
+	suite.Config().Recommend.CacheSize = 3
+	suite.Config().Recommend.DataSource.PositiveFeedbackTypes = []string{"p"}
+	suite.Config().Recommend.DataSource.ReadFeedbackTypes = []string{"n"}
+	suite.Config().Recommend.DataSource.NegativeFeedbackTTL = map[string]time.Duration{"dislike": time.Hour}
+	suite.Config().Recommend.Offline.EnableColRecommend = false
+	suite.Config().Recommend.Offline.EnablePopularRecommend = true
+	suite.Config().Recommend.Offline.ExploreRecommend = map[string]float64{"popular": 0.5}
+	suite.Config().Recommend.Replacement.EnableReplacement = true
+
+	// insert items
+	err := suite.DataClient.BatchInsertItems(ctx, []data.Item{
+		{ItemId: "10"}, {ItemId: "9"}, {ItemId: "8"}, {ItemId: "7"}, {ItemId: "6"}, {ItemId: "5"}, {ItemId: "4"},
+	})
+	suite.NoError(err)
+	// insert popular items
+	err = suite.CacheClient.SetSorted(ctx, cache.PopularItems,
+		[]cache.Scored{{"9", 11}, {"8", 10}, {"7", 9}, {"6", 8}, {"5", 7}, {"4", 6}})
+	suite.NoError(err)
+	// insert feedback
+	err = suite.DataClient.BatchInsertFeedback(ctx, []data.Feedback{
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "p", UserId: "0", ItemId: "10"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "n", UserId: "0", ItemId: "9"}},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "9"}, Timestamp: time.Now().Add(-time.Minute)},
+		{FeedbackKey: data.FeedbackKey{FeedbackType: "dislike", UserId: "0", ItemId: "8"}, Timestamp: time.Now().Add(-time.Minute)},
+	}, true, false, true)
+	suite.NoError(err)
+	suite.Recommend([]data.User{{UserId: "0"}})
+	// suppressed items are neither candidates, explored nor replaced, so that lists are full
+	recommends, err := suite.CacheClient.GetSorted(ctx, cache.Key(cache.OfflineRecommend, "0"), 0, -1)
+	suite.NoError(err)
+	suite.Len(recommends, suite.Config().Recommend.CacheSize)
+	suite.ElementsMatch([]string{"10", "7", "6"}, cache.RemoveScores(recommends))
+}
+
 func (suite *WorkerTestSuite) TestHealth() {
-	// ready
+	// not started
 	req := httptest.NewRequest("GET", "https://example.com/", nil)
 	w := httptest.NewRecorder()
+	suite.checkStartup(w, req)
+	suite.Equal(http.StatusServiceUnavailable, w.Code)
+	w = httptest.NewRecorder()
 	suite.checkLive(w, req)
 	suite.Equal(http.StatusOK, w.Code)
-	suite.Equal(marshal(suite.T(), HealthStatus{
-		DataStoreError:      nil,
-		CacheStoreError:     nil,
-		DataStoreConnected:  true,
-		CacheStoreConnected: true,
-	}), w.Body.String())
+	suite.Equal(marshal(suite.T(), HealthStatus{}), w.Body.String())
+
+	// ready
+	suite.health.Sync(time.Now(), nil)
+	suite.health.TrackModel("ranking_model", 1, 1)
+	suite.health.TrackModel("click_model", 1, 2)
+	suite.health.Start()
+	w = httptest.NewRecorder()
+	suite.checkStartup(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	suite.checkReady(w, req)
+	suite.Equal(http.StatusOK, w.Code)
+	healthStatus := suite.checkHealth(true)
+	suite.True(healthStatus.Ready)
+	suite.True(healthStatus.DataStoreConnected)
+	suite.True(healthStatus.CacheStoreConnected)
+	suite.NotNil(healthStatus.LastSync)
+	suite.ElementsMatch([]string{"master", "data_store", "cache_store"}, lo.Keys(healthStatus.Dependencies))
+	suite.Zero(healthStatus.ModelStaleness["ranking_model"])
+	suite.Positive(healthStatus.ModelStaleness["click_model"])
 
 	// not ready
 	dataClient, cacheClient := suite.DataClient, suite.CacheClient
 	suite.DataClient, suite.CacheClient = data.NoDatabase{}, cache.NoDatabase{}
 	w = httptest.NewRecorder()
-	suite.checkLive(w, req)
-	suite.Equal(http.StatusOK, w.Code)
-	suite.Equal(marshal(suite.T(), HealthStatus{
-		DataStoreError:      data.ErrNoDatabase,
-		CacheStoreError:     cache.ErrNoDatabase,
-		DataStoreConnected:  false,
-		CacheStoreConnected: false,
-	}), w.Body.String())
+	suite.checkReady(w, req)
+	suite.Equal(http.StatusServiceUnavailable, w.Code)
+	healthStatus = suite.checkHealth(true)
+	suite.False(healthStatus.Ready)
+	suite.Equal(data.ErrNoDatabase, healthStatus.DataStoreError)
+	suite.Equal(cache.ErrNoDatabase, healthStatus.CacheStoreError)
+	suite.False(healthStatus.Dependencies["data_store"].Connected)
+	suite.False(healthStatus.Dependencies["cache_store"].Connected)
 	suite.DataClient, suite.CacheClient = dataClient, cacheClient
 }
 